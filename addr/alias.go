@@ -0,0 +1,100 @@
+package addr
+
+import (
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Endpoint returns the network endpoint an address refers to: its leading network component, such as ip4 or dns4,
+// followed by its transport component, such as tcp or udp. Addresses sharing an endpoint, like /ip4/1.2.3.4/tcp/4001
+// and /ip4/1.2.3.4/tcp/4001/ws, are aliases of each other. It returns nil for addresses that don't start with a
+// network and a transport component, which have no aliases.
+func Endpoint(a ma.Multiaddr) ma.Multiaddr {
+	if a == nil {
+		return nil
+	}
+	network, rest := ma.SplitFirst(a)
+	if network == nil || rest == nil {
+		return nil
+	}
+	transport, _ := ma.SplitFirst(rest)
+	if transport == nil {
+		return nil
+	}
+	switch transport.Protocol().Code {
+	case ma.P_TCP, ma.P_UDP, ma.P_SCTP, ma.P_DCCP:
+		return ma.Join(network, transport)
+	}
+	return nil
+}
+
+// Transport returns the name of the transport stack an address dials over: the protocols following its network
+// components, up to the peer ID, joined by slashes, such as "tcp", "tcp/ws" or "udp/quic". Relayed addresses all
+// share the "p2p-circuit" transport. It returns "" for addresses without transport components.
+func Transport(a ma.Multiaddr) string {
+	if a == nil {
+		return ""
+	}
+	var (
+		names   []string
+		network = true
+		pastID  = false
+		relayed = false
+	)
+	ma.ForEach(a, func(c ma.Component) bool {
+		code := c.Protocol().Code
+		switch {
+		case code == ma.P_CIRCUIT:
+			relayed = true
+			return false
+		case code == ma.P_P2P:
+			// keep looking for a relay.
+			pastID = true
+		case network && isNetwork(code):
+		case !pastID:
+			network = false
+			names = append(names, c.Protocol().Name)
+		}
+		return true
+	})
+	if relayed {
+		return "p2p-circuit"
+	}
+	return strings.Join(names, "/")
+}
+
+func isNetwork(code int) bool {
+	switch code {
+	case ma.P_IP4, ma.P_IP6, ma.P_IP6ZONE, ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR:
+		return true
+	}
+	return false
+}
+
+// IsAlias returns whether two distinct addresses refer to the same endpoint.
+func IsAlias(a, b ma.Multiaddr) bool {
+	if a.Equal(b) {
+		return false
+	}
+	ea := Endpoint(a)
+	return ea != nil && ea.Equal(Endpoint(b))
+}
+
+// DedupAliases keeps the first address of every endpoint, in order. Addresses without an endpoint are all kept. It
+// reuses the backing array of addrs.
+func DedupAliases(addrs []ma.Multiaddr) []ma.Multiaddr {
+	seen := make(map[string]struct{}, len(addrs))
+	res := addrs[:0]
+	for _, a := range addrs {
+		if ep := Endpoint(a); ep != nil {
+			k := string(ep.Bytes())
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+		}
+		res = append(res, a)
+	}
+	return res
+}
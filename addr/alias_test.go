@@ -0,0 +1,67 @@
+package addr
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"/ip4/1.2.3.4/tcp/4001":         "/ip4/1.2.3.4/tcp/4001",
+		"/ip4/1.2.3.4/tcp/4001/ws":      "/ip4/1.2.3.4/tcp/4001",
+		"/ip6/::1/udp/4001/quic":        "/ip6/::1/udp/4001",
+		"/dns4/example.com/tcp/443/wss": "/dns4/example.com/tcp/443",
+		"/ip4/1.2.3.4":                  "",
+		"/ip4/1.2.3.4/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC": "",
+	}
+	for in, want := range cases {
+		got := Endpoint(newAddrOrFatal(t, in))
+		if want == "" {
+			if got != nil {
+				t.Errorf("expected no endpoint for %s, got %s", in, got)
+			}
+			continue
+		}
+		if got == nil || got.String() != want {
+			t.Errorf("expected endpoint %s for %s, got %s", want, in, got)
+		}
+	}
+}
+
+func TestDedupAliases(t *testing.T) {
+	tcp := newAddrOrFatal(t, "/ip4/1.2.3.4/tcp/4001")
+	ws := newAddrOrFatal(t, "/ip4/1.2.3.4/tcp/4001/ws")
+	other := newAddrOrFatal(t, "/ip4/1.2.3.4/tcp/4002")
+	bare := newAddrOrFatal(t, "/ip4/1.2.3.4")
+
+	if !IsAlias(tcp, ws) || IsAlias(tcp, other) || IsAlias(tcp, tcp) || IsAlias(bare, bare) {
+		t.Fatal("unexpected aliasing")
+	}
+
+	got := DedupAliases([]ma.Multiaddr{ws, other, tcp, bare})
+	if len(got) != 3 || !got[0].Equal(ws) || !got[1].Equal(other) || !got[2].Equal(bare) {
+		t.Fatalf("unexpected deduplicated addresses: %v", got)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	cases := map[string]string{
+		"/ip4/1.2.3.4/tcp/4001":         "tcp",
+		"/ip4/1.2.3.4/tcp/4001/ws":      "tcp/ws",
+		"/ip6/::1/udp/4001/quic":        "udp/quic",
+		"/dns4/example.com/tcp/443/wss": "tcp/wss",
+		"/ip4/1.2.3.4/tcp/4001/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC":             "tcp",
+		"/ip4/1.2.3.4/tcp/4001/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit": "p2p-circuit",
+		"/p2p-circuit": "p2p-circuit",
+		"/ip4/1.2.3.4": "",
+	}
+	for in, want := range cases {
+		if got := Transport(newAddrOrFatal(t, in)); got != want {
+			t.Errorf("expected transport %q for %s, got %q", want, in, got)
+		}
+	}
+	if got := Transport(nil); got != "" {
+		t.Errorf("expected no transport for a nil address, got %q", got)
+	}
+}
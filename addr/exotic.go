@@ -0,0 +1,208 @@
+package addr
+
+import (
+	"errors"
+	"fmt"
+
+	ma "github.com/multiformats/go-multiaddr"
+	mb "github.com/multiformats/go-multibase"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Codes of the protocols of the WebTransport and WebRTC transports, which this version of go-multiaddr doesn't know
+// about. They are registered on init, unless already registered, so that their addresses can be parsed and stored
+// rather than rejected.
+const (
+	P_WEBRTC_DIRECT = 0x0118
+	P_WEBRTC        = 0x0119
+	P_QUIC_V1       = 0x01CD
+	P_WEBTRANSPORT  = 0x01D1
+	P_CERTHASH      = 0x01D2
+)
+
+func init() {
+	certhash := ma.NewTranscoderFromFunctions(certHashStringToBytes, certHashBytesToString, certHashValidate)
+	for _, p := range []ma.Protocol{
+		{Name: "webrtc-direct", Code: P_WEBRTC_DIRECT},
+		{Name: "webrtc", Code: P_WEBRTC},
+		{Name: "quic-v1", Code: P_QUIC_V1},
+		{Name: "webtransport", Code: P_WEBTRANSPORT},
+		{Name: "certhash", Code: P_CERTHASH, Size: ma.LengthPrefixedVarSize, Transcoder: certhash},
+	} {
+		if ma.ProtocolWithCode(p.Code).Code != 0 || ma.ProtocolWithName(p.Name).Code != 0 {
+			continue
+		}
+		p.VCode = ma.CodeToVarint(p.Code)
+		if err := ma.AddProtocol(p); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// certhash components hold a multihash of the certificate of the listener, multibase-encoded.
+func certHashStringToBytes(s string) ([]byte, error) {
+	_, b, err := mb.Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	return b, certHashValidate(b)
+}
+
+func certHashBytesToString(b []byte) (string, error) {
+	if err := certHashValidate(b); err != nil {
+		return "", err
+	}
+	return mb.Encode(mb.Base64url, b)
+}
+
+func certHashValidate(b []byte) error {
+	_, err := mh.Cast(b)
+	return err
+}
+
+// ExoticKind is the kind of transport of the addresses which aren't plain IP ones, see Exotic.
+type ExoticKind int
+
+const (
+	// NotExotic is the kind of all the other addresses.
+	NotExotic ExoticKind = iota
+	// Onion addresses reach Tor onion services: /onion and /onion3.
+	Onion
+	// Garlic addresses reach I2P destinations: /garlic32 and /garlic64.
+	Garlic
+	// WebTransport addresses dial over /quic-v1/webtransport, and usually carry /certhash components.
+	WebTransport
+	// WebRTC addresses dial over /webrtc-direct, usually with /certhash components, or over /webrtc through a relay.
+	WebRTC
+)
+
+func (k ExoticKind) String() string {
+	switch k {
+	case NotExotic:
+		return "none"
+	case Onion:
+		return "onion"
+	case Garlic:
+		return "garlic"
+	case WebTransport:
+		return "webtransport"
+	case WebRTC:
+		return "webrtc"
+	default:
+		return fmt.Sprintf("ExoticKind(%d)", int(k))
+	}
+}
+
+// Exotic returns the kind of transport of an address, by its first component of an exotic transport.
+func Exotic(a ma.Multiaddr) ExoticKind {
+	kind := NotExotic
+	if a == nil {
+		return kind
+	}
+	ma.ForEach(a, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_ONION, ma.P_ONION3:
+			kind = Onion
+		case ma.P_GARLIC32, ma.P_GARLIC64:
+			kind = Garlic
+		case P_WEBTRANSPORT:
+			kind = WebTransport
+		case P_WEBRTC, P_WEBRTC_DIRECT, ma.P_P2P_WEBRTC_DIRECT:
+			kind = WebRTC
+		}
+		return kind == NotExotic
+	})
+	return kind
+}
+
+// HasCertHash returns whether an address carries a /certhash component, pinning the certificate of a listener which
+// is typically rotated within weeks.
+func HasCertHash(a ma.Multiaddr) bool {
+	if a == nil {
+		return false
+	}
+	found := false
+	ma.ForEach(a, func(c ma.Component) bool {
+		found = c.Protocol().Code == P_CERTHASH
+		return !found
+	})
+	return found
+}
+
+// ErrMalformedExotic is wrapped by the errors of ValidateExotic, so that callers can tell them apart with errors.Is.
+var ErrMalformedExotic = errors.New("malformed address")
+
+// ExoticError reports an address rejected by ValidateExotic.
+type ExoticError struct {
+	Addr ma.Multiaddr
+	// Protocol is the name of the misplaced component.
+	Protocol string
+	Reason   string
+}
+
+func (e *ExoticError) Error() string {
+	return fmt.Sprintf("malformed address %s: /%s %s", e.Addr, e.Protocol, e.Reason)
+}
+
+func (e *ExoticError) Unwrap() error {
+	return ErrMalformedExotic
+}
+
+// ValidateExotic checks the arrangement of the components of exotic transports in an address, returning an
+// *ExoticError e.g. for an /onion3 component following an IP one, a /webtransport one not over /quic or /quic-v1, a
+// /webrtc-direct one not over /udp, or a /certhash one following neither. Other addresses are valid.
+func ValidateExotic(a ma.Multiaddr) error {
+	if a == nil {
+		return nil
+	}
+	var (
+		err      error
+		first    = true
+		prev     int
+		overlay  int  // the code of the /onion or /garlic component coming first, if any.
+		certHash bool // /certhash components may follow.
+	)
+	ma.ForEach(a, func(c ma.Component) bool {
+		code := c.Protocol().Code
+		var reason string
+		switch code {
+		case ma.P_ONION, ma.P_ONION3, ma.P_GARLIC32, ma.P_GARLIC64:
+			if !first {
+				reason = "must come first"
+			}
+			overlay = code
+		case ma.P_IP4, ma.P_IP6, ma.P_IP6ZONE, ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR:
+			if overlay != 0 {
+				reason = "can't follow an overlay network address"
+			}
+		case ma.P_TCP, ma.P_UDP:
+			if overlay == ma.P_ONION || overlay == ma.P_ONION3 {
+				reason = "can't follow an onion address, which holds its port"
+			}
+		case P_WEBTRANSPORT:
+			if prev != ma.P_QUIC && prev != P_QUIC_V1 {
+				reason = "must follow /quic-v1"
+			}
+		case P_WEBRTC_DIRECT:
+			if prev != ma.P_UDP {
+				reason = "must follow /udp"
+			}
+		case P_WEBRTC:
+			if !first && prev != ma.P_CIRCUIT {
+				reason = "must come first or follow /p2p-circuit"
+			}
+		case P_CERTHASH:
+			if !certHash {
+				reason = "must follow /webtransport or /webrtc-direct"
+			}
+		}
+		if reason != "" {
+			err = &ExoticError{Addr: a, Protocol: c.Protocol().Name, Reason: reason}
+			return false
+		}
+		first, prev = false, code
+		certHash = code == P_WEBTRANSPORT || code == P_WEBRTC_DIRECT || code == P_CERTHASH
+		return true
+	})
+	return err
+}
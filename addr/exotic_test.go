@@ -0,0 +1,84 @@
+package addr
+
+import (
+	"errors"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	testOnion3   = "/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:1234"
+	testGarlic32 = "/garlic32/566niximlxdzpanmn4qouucvua3k7neniwss47li5r6ugoertzuq"
+	testCertHash = "/certhash/uEiAGKYQy6AZrKeIiO8wjqpUEtWrlCPq_NDVQiGm5wxkOIg"
+	testPeer     = "/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"
+)
+
+func TestExotic(t *testing.T) {
+	cases := map[string]ExoticKind{
+		"/ip4/1.2.3.4/tcp/4001":        NotExotic,
+		"/ip4/1.2.3.4/udp/443/quic-v1": NotExotic,
+		testOnion3 + testPeer:          Onion,
+		"/onion/timaq4ygg2iegci7:1234": Onion,
+		testGarlic32:                   Garlic,
+		"/ip4/1.2.3.4/udp/443/quic-v1/webtransport" + testCertHash:     WebTransport,
+		"/ip4/1.2.3.4/udp/443/webrtc-direct" + testCertHash + testPeer: WebRTC,
+		"/ip4/1.2.3.4/tcp/4001" + testPeer + "/p2p-circuit/webrtc":     WebRTC,
+		"/ip4/1.2.3.4/tcp/9090/http/p2p-webrtc-direct":                 WebRTC,
+	}
+	for in, want := range cases {
+		a := newAddrOrFatal(t, in)
+		if got := Exotic(a); got != want {
+			t.Errorf("expected %s for %s, got %s", want, in, got)
+		}
+		// exotic addresses are encoded and decoded without loss.
+		if b, err := ma.NewMultiaddrBytes(a.Bytes()); err != nil || !b.Equal(a) || b.String() != in {
+			t.Errorf("expected %s to round-trip, got %s (%v)", in, b, err)
+		}
+	}
+	if !HasCertHash(newAddrOrFatal(t, "/ip4/1.2.3.4/udp/443/quic-v1/webtransport"+testCertHash)) ||
+		HasCertHash(newAddrOrFatal(t, "/ip4/1.2.3.4/udp/443/quic-v1/webtransport")) {
+		t.Error("unexpected certhash detection")
+	}
+	if _, err := ma.NewMultiaddr("/ip4/1.2.3.4/udp/443/quic-v1/webtransport/certhash/uAAAA"); err == nil {
+		t.Error("expected a certhash that isn't a multihash to be rejected")
+	}
+}
+
+func TestValidateExotic(t *testing.T) {
+	valid := []string{
+		"/ip4/1.2.3.4/tcp/4001",
+		testOnion3,
+		testOnion3 + testPeer,
+		"/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:80/http",
+		testGarlic32 + "/tcp/8080",
+		"/ip4/1.2.3.4/udp/443/quic-v1/webtransport" + testCertHash + testCertHash + testPeer,
+		"/ip4/1.2.3.4/udp/443/quic/webtransport",
+		"/ip6/::1/udp/443/webrtc-direct" + testCertHash,
+		"/ip4/1.2.3.4/tcp/4001" + testPeer + "/p2p-circuit/webrtc" + testPeer,
+		"/webrtc",
+	}
+	for _, in := range valid {
+		if err := ValidateExotic(newAddrOrFatal(t, in)); err != nil {
+			t.Errorf("expected %s to be valid, got %s", in, err)
+		}
+	}
+
+	invalid := []string{
+		"/ip4/1.2.3.4" + testOnion3,
+		testOnion3 + "/tcp/1234",
+		testGarlic32 + "/ip4/1.2.3.4",
+		"/ip4/1.2.3.4/udp/443/webtransport",
+		"/ip4/1.2.3.4/tcp/443/webrtc-direct",
+		"/ip4/1.2.3.4/tcp/4001/webrtc",
+		"/ip4/1.2.3.4/udp/443/quic-v1" + testCertHash,
+		"/ip4/1.2.3.4/udp/443/quic-v1/webtransport" + testPeer + testCertHash,
+	}
+	for _, in := range invalid {
+		err := ValidateExotic(newAddrOrFatal(t, in))
+		var eerr *ExoticError
+		if !errors.Is(err, ErrMalformedExotic) || !errors.As(err, &eerr) {
+			t.Errorf("expected %s to be malformed, got %v", in, err)
+		}
+	}
+}
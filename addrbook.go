@@ -0,0 +1,94 @@
+package peerstore
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ExpiringAddr is an address along with the TTL it was last set with, and the time it expires at.
+type ExpiringAddr struct {
+	Addr    ma.Multiaddr
+	TTL     time.Duration
+	Expires time.Time
+}
+
+// ExpiringAddrBook is implemented by address books that expose the expiry of the addresses they hold.
+type ExpiringAddrBook interface {
+	// AddrsWithExpiry returns the non-expired addresses of a peer, along with their expiry.
+	AddrsWithExpiry(p peer.ID) []ExpiringAddr
+}
+
+// ExpiryTimeline is implemented by address books that can list addresses by expiry, across peers.
+type ExpiryTimeline interface {
+	// ExpiringBefore returns the non-expired addresses of every peer that will expire before t, keyed by peer.
+	// Peers with no such address are omitted.
+	ExpiringBefore(t time.Time) map[peer.ID][]ma.Multiaddr
+}
+
+// RecentAddr is an address along with the time it was last added or confirmed.
+type RecentAddr struct {
+	Addr     ma.Multiaddr
+	LastSeen time.Time
+}
+
+// RecencyAddrBook is implemented by address books that track when their addresses were last added or confirmed.
+type RecencyAddrBook interface {
+	// AddrsByRecency returns the non-expired addresses of a peer, most recently added or confirmed first, so that
+	// dialers can try the endpoints most likely to be current first, e.g. after a network change.
+	AddrsByRecency(p peer.ID) []RecentAddr
+}
+
+// SortByRecency sorts addresses most recently seen first. Addresses seen at the same time are sorted by their binary
+// representation, so that the order is stable.
+func SortByRecency(addrs []RecentAddr) {
+	sort.Slice(addrs, func(i, j int) bool {
+		if !addrs[i].LastSeen.Equal(addrs[j].LastSeen) {
+			return addrs[i].LastSeen.After(addrs[j].LastSeen)
+		}
+		return bytes.Compare(addrs[i].Addr.Bytes(), addrs[j].Addr.Bytes()) < 0
+	})
+}
+
+// AddrOrder decides the order in which address books return the addresses of a peer.
+type AddrOrder int
+
+const (
+	// AddrOrderNone returns addresses in the order the address book happens to hold them in, e.g. map iteration
+	// order. This is the default.
+	AddrOrderNone AddrOrder = iota
+
+	// AddrOrderInsertion returns addresses in the order they were first added for the peer. Addresses keep their
+	// position when added or set again, but move to the end when added again once expired or removed.
+	AddrOrderInsertion
+
+	// AddrOrderBytes returns addresses sorted by their binary representation.
+	AddrOrderBytes
+)
+
+// Less reports whether the address a, added at position addedA in the insertion order of its peer, comes before the
+// address b, added at position addedB. Addresses at the same position, such as those stored by versions that didn't
+// track positions, are sorted by their binary representation. It is always false under AddrOrderNone.
+func (o AddrOrder) Less(a ma.Multiaddr, addedA uint64, b ma.Multiaddr, addedB uint64) bool {
+	switch o {
+	case AddrOrderInsertion:
+		if addedA != addedB {
+			return addedA < addedB
+		}
+	case AddrOrderBytes:
+	default:
+		return false
+	}
+	return bytes.Compare(a.Bytes(), b.Bytes()) < 0
+}
+
+// AddrBookE is implemented by address books whose mutators can fail, e.g. because of datastore errors, which the
+// AddrBook methods can only log. The legacy methods behave like these, discarding the error.
+type AddrBookE interface {
+	AddAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error
+	SetAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error
+	ClearAddrsE(p peer.ID) error
+}
@@ -0,0 +1,211 @@
+package peerstore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrHistory is implemented by peerstores that can tell which addresses they held for a peer in the past, e.g. for
+// crawlers analysing how peers move.
+type AddrHistory interface {
+	// AddrsAt returns the addresses held for a peer at t, as far as the retained history tells.
+	AddrsAt(p peer.ID, t time.Time) []ma.Multiaddr
+}
+
+// AddrHistoryOption configures an AddrHistoryPeerstore.
+type AddrHistoryOption func(*AddrHistoryPeerstore)
+
+// WithAddrHistoryClock sets the source of time of an AddrHistoryPeerstore. Defaults to the system clock.
+func WithAddrHistoryClock(c Clock) AddrHistoryOption {
+	return func(hp *AddrHistoryPeerstore) {
+		hp.clock = c
+	}
+}
+
+// addrSpan is a period during which a peer had an address.
+type addrSpan struct {
+	addr  ma.Multiaddr
+	start time.Time
+	// end is the expiry of the address as last seen while the span is open, and the time the address was found
+	// removed once it is closed.
+	end  time.Time
+	open bool
+}
+
+// AddrHistoryPeerstore is a Peerstore middleware that keeps the periods during which every peer had each of its
+// addresses, so that AddrsAt can tell what was known about a peer at a past moment.
+//
+// The history is best-effort: it is kept in memory, and built by reading the addresses of a peer back after every
+// write made through the middleware, which costs a read per write. Addresses are taken as held from the write that
+// added them until they expire or a write finds them removed; writes that bypass the middleware, e.g. through
+// optional interfaces of the wrapped peerstore such as ConsumePeerRecord, are only seen at the next write through it,
+// and addresses the wrapped peerstore held before being wrapped from the first write. Periods that ended before the
+// retention window are dropped, so AddrsAt returns nothing for the moments before it.
+type AddrHistoryPeerstore struct {
+	pstore.Peerstore
+	retention time.Duration
+	clock     Clock
+
+	mu        sync.Mutex
+	spans     map[peer.ID][]*addrSpan
+	lastSweep time.Time
+}
+
+var (
+	_ pstore.Peerstore = (*AddrHistoryPeerstore)(nil)
+	_ AddrHistory      = (*AddrHistoryPeerstore)(nil)
+	_ PeerRemover      = (*AddrHistoryPeerstore)(nil)
+)
+
+// NewAddrHistoryPeerstore wraps ps, retaining the address history of peers for retention, which must be positive.
+func NewAddrHistoryPeerstore(ps pstore.Peerstore, retention time.Duration, opts ...AddrHistoryOption) (*AddrHistoryPeerstore, error) {
+	if retention <= 0 {
+		return nil, fmt.Errorf("address history retention must be positive: %s", retention)
+	}
+	hp := &AddrHistoryPeerstore{
+		Peerstore: ps,
+		retention: retention,
+		clock:     RealClock{},
+		spans:     make(map[peer.ID][]*addrSpan),
+	}
+	for _, opt := range opts {
+		opt(hp)
+	}
+	hp.lastSweep = hp.clock.Now()
+	return hp, nil
+}
+
+func (hp *AddrHistoryPeerstore) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	hp.AddAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+func (hp *AddrHistoryPeerstore) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	hp.Peerstore.AddAddrs(p, addrs, ttl)
+	hp.observe(p)
+}
+
+func (hp *AddrHistoryPeerstore) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	hp.SetAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+func (hp *AddrHistoryPeerstore) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	hp.Peerstore.SetAddrs(p, addrs, ttl)
+	hp.observe(p)
+}
+
+func (hp *AddrHistoryPeerstore) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
+	hp.Peerstore.UpdateAddrs(p, oldTTL, newTTL)
+	hp.observe(p)
+}
+
+func (hp *AddrHistoryPeerstore) ClearAddrs(p peer.ID) {
+	hp.Peerstore.ClearAddrs(p)
+	hp.observe(p)
+}
+
+// RemovePeer removes the peer from the wrapped peerstore, if it is a PeerRemover. The address history of the peer is
+// retained.
+func (hp *AddrHistoryPeerstore) RemovePeer(p peer.ID) {
+	if pr, ok := hp.Peerstore.(PeerRemover); ok {
+		pr.RemovePeer(p)
+	}
+	hp.observe(p)
+}
+
+// AddrsAt returns the addresses held for a peer at t, in the order they were added.
+func (hp *AddrHistoryPeerstore) AddrsAt(p peer.ID, t time.Time) []ma.Multiaddr {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	var res []ma.Multiaddr
+	for _, s := range hp.spans[p] {
+		if !s.start.After(t) && t.Before(s.end) {
+			res = append(res, s.addr)
+		}
+	}
+	return res
+}
+
+// observe reads the addresses of p back, and opens or closes its spans accordingly.
+func (hp *AddrHistoryPeerstore) observe(p peer.ID) {
+	current := make(map[string]ExpiringAddr)
+	if eab, ok := hp.Peerstore.(ExpiringAddrBook); ok {
+		for _, a := range eab.AddrsWithExpiry(p) {
+			current[string(a.Addr.Bytes())] = a
+		}
+	} else {
+		// without expiries, addresses are held until a write finds them removed.
+		for _, a := range hp.Peerstore.Addrs(p) {
+			current[string(a.Bytes())] = ExpiringAddr{Addr: a, Expires: time.Unix(1<<62, 0)}
+		}
+	}
+
+	now := hp.clock.Now()
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	spans := hp.spans[p]
+	for _, s := range spans {
+		if !s.open {
+			continue
+		}
+		k := string(s.addr.Bytes())
+		a, ok := current[k]
+		switch {
+		case !ok:
+			s.open = false
+			if now.Before(s.end) {
+				s.end = now
+			}
+		case s.end.After(now):
+			// still held: its expiry may have moved.
+			s.end = a.Expires
+			delete(current, k)
+		default:
+			// expired, then added again: the gap is kept.
+			s.open = false
+		}
+	}
+	added := make([]ExpiringAddr, 0, len(current))
+	for _, a := range current {
+		added = append(added, a)
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Addr.String() < added[j].Addr.String() })
+	for _, a := range added {
+		spans = append(spans, &addrSpan{addr: a.Addr, start: now, end: a.Expires, open: true})
+	}
+	hp.spans[p] = hp.prune(spans, now)
+	if len(hp.spans[p]) == 0 {
+		delete(hp.spans, p)
+	}
+
+	// the spans of peers that aren't written to anymore are dropped by a sweep every half retention window.
+	if now.Sub(hp.lastSweep) >= hp.retention/2 {
+		hp.lastSweep = now
+		for q, spans := range hp.spans {
+			if spans = hp.prune(spans, now); len(spans) == 0 {
+				delete(hp.spans, q)
+			} else {
+				hp.spans[q] = spans
+			}
+		}
+	}
+}
+
+// prune drops the spans that ended before the retention window. To be called with the lock held.
+func (hp *AddrHistoryPeerstore) prune(spans []*addrSpan, now time.Time) []*addrSpan {
+	horizon := now.Add(-hp.retention)
+	kept := spans[:0]
+	for _, s := range spans {
+		if s.end.After(horizon) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,61 @@
+package peerstore_test
+
+import (
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestAddrHistoryPeerstore(t *testing.T) {
+	clock := pt.NewMockClock()
+	hp, err := pstore.NewAddrHistoryPeerstore(pstoremem.NewPeerstore(pstoremem.WithClock(clock)), time.Hour,
+		pstore.WithAddrHistoryClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hp.Close()
+
+	p := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(3)
+	t0 := clock.Now()
+
+	hp.AddAddrs(p, addrs[:2], 10*time.Minute)
+	clock.Add(time.Minute)
+	hp.SetAddr(p, addrs[0], 0)
+	clock.Add(time.Minute)
+	hp.AddAddr(p, addrs[2], 10*time.Minute)
+	clock.Add(time.Minute)
+
+	pt.AssertAddressesEqual(t, addrs[:2], hp.AddrsAt(p, t0))
+	pt.AssertAddressesEqual(t, addrs[1:2], hp.AddrsAt(p, t0.Add(90*time.Second)))
+	pt.AssertAddressesEqual(t, addrs[1:], hp.AddrsAt(p, clock.Now()))
+	if got := hp.AddrsAt(p, t0.Add(-time.Second)); len(got) != 0 {
+		t.Fatalf("expected no address before the first write, got %v", got)
+	}
+
+	// addresses are taken as gone once expired, and a later addition starts a new period.
+	pt.AssertAddressesEqual(t, addrs[2:], hp.AddrsAt(p, t0.Add(11*time.Minute)))
+	clock.Add(20 * time.Minute)
+	hp.AddAddr(p, addrs[1], 10*time.Minute)
+	if got := hp.AddrsAt(p, t0.Add(15*time.Minute)); len(got) != 0 {
+		t.Fatalf("expected no address between the expiry and the new addition, got %v", got)
+	}
+	pt.AssertAddressesEqual(t, addrs[1:2], hp.AddrsAt(p, clock.Now()))
+
+	// the history survives the removal of the peer, until it leaves the retention window.
+	clock.Add(time.Minute)
+	hp.RemovePeer(p)
+	pt.AssertAddressesEqual(t, addrs[1:2], hp.AddrsAt(p, clock.Now().Add(-time.Second)))
+	clock.Add(2 * time.Hour)
+	hp.ClearAddrs(p)
+	if got := hp.AddrsAt(p, t0); len(got) != 0 {
+		t.Fatalf("expected the history past the retention window to be dropped, got %v", got)
+	}
+
+	if _, err := pstore.NewAddrHistoryPeerstore(pstoremem.NewPeerstore(), 0); err == nil {
+		t.Fatal("expected an error for a zero retention")
+	}
+}
@@ -0,0 +1,70 @@
+package peerstore
+
+import (
+	"errors"
+	"sync/atomic"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrAddrTooLong is returned when addresses longer than the cap of an AddrLengthCap are added or set.
+var ErrAddrTooLong = errors.New("address too long")
+
+// DefaultMaxAddrLen is the default cap on the binary length of the addresses stored by address books, in bytes. It's
+// far above the length of any address in use, relayed ones carrying peer IDs and certificate hashes included, and only
+// rejects pathological addresses that dialers would then waste time parsing.
+const DefaultMaxAddrLen = 1024
+
+// AddrLengthCap rejects the addresses whose binary encoding is longer than a cap, and counts them. A nil AddrLengthCap
+// accepts all addresses.
+type AddrLengthCap struct {
+	rejected uint64 // accessed atomically; keep first for 64-bit alignment.
+
+	max int
+}
+
+// NewAddrLengthCap creates an AddrLengthCap rejecting the addresses longer than max bytes. A zero max applies
+// DefaultMaxAddrLen; it returns nil, which accepts all addresses, if max is negative.
+func NewAddrLengthCap(max int) *AddrLengthCap {
+	if max < 0 {
+		return nil
+	}
+	if max == 0 {
+		max = DefaultMaxAddrLen
+	}
+	return &AddrLengthCap{max: max}
+}
+
+// Filter returns the addresses among addrs no longer than the cap, and ErrAddrTooLong if any was rejected. The
+// returned slice is addrs itself when nothing is rejected.
+func (c *AddrLengthCap) Filter(addrs []ma.Multiaddr) ([]ma.Multiaddr, error) {
+	if c == nil {
+		return addrs, nil
+	}
+	var res []ma.Multiaddr
+	for i, a := range addrs {
+		if a != nil && len(a.Bytes()) > c.max {
+			atomic.AddUint64(&c.rejected, 1)
+			if res == nil {
+				res = append(make([]ma.Multiaddr, 0, len(addrs)), addrs[:i]...)
+			}
+			continue
+		}
+		if res != nil {
+			res = append(res, a)
+		}
+	}
+	if res == nil {
+		return addrs, nil
+	}
+	log.Debugf("rejected %d addresses longer than %d bytes", len(addrs)-len(res), c.max)
+	return res, ErrAddrTooLong
+}
+
+// Rejected returns the number of addresses rejected so far.
+func (c *AddrLengthCap) Rejected() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.rejected)
+}
@@ -0,0 +1,34 @@
+package peerstore_test
+
+import (
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestAddrLengthCap(t *testing.T) {
+	short := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	long := ma.StringCast("/dns4/" + strings.Repeat("a", pstore.DefaultMaxAddrLen) + "/tcp/4001")
+
+	c := pstore.NewAddrLengthCap(0)
+	addrs := []ma.Multiaddr{short, short}
+	if got, err := c.Filter(addrs); err != nil || &got[0] != &addrs[0] {
+		t.Fatalf("expected the addresses to go through as they are, got %v (%v)", got, err)
+	}
+	got, err := c.Filter([]ma.Multiaddr{long, short, long})
+	if err != pstore.ErrAddrTooLong || len(got) != 1 || !got[0].Equal(short) {
+		t.Fatalf("expected the long addresses to be rejected, got %v (%v)", got, err)
+	}
+	if n := c.Rejected(); n != 2 {
+		t.Fatalf("expected 2 rejected addresses, got %d", n)
+	}
+
+	// a negative cap accepts everything.
+	c = pstore.NewAddrLengthCap(-1)
+	if got, err := c.Filter([]ma.Multiaddr{long}); err != nil || len(got) != 1 || c.Rejected() != 0 {
+		t.Fatalf("expected no cap, got %v (%v)", got, err)
+	}
+}
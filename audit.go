@@ -0,0 +1,97 @@
+package peerstore
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// AuditEventType identifies a security-relevant event recorded by an AuditSink.
+type AuditEventType int
+
+const (
+	// AuditKeyAdded is recorded when a key is first stored for a peer.
+	AuditKeyAdded AuditEventType = iota
+	// AuditKeyMismatch is recorded when a key is rejected because it doesn't match the peer ID it was added for.
+	AuditKeyMismatch
+	// AuditRecordKeyMismatch is recorded when a signed peer record is rejected because it was signed by a key that
+	// doesn't match the peer ID in the record.
+	AuditRecordKeyMismatch
+	// AuditRecordStale is recorded when a signed peer record is ignored because its sequence number is lower than
+	// that of the record already held, i.e. an older record was replayed, or a downgrade was attempted.
+	AuditRecordStale
+)
+
+func (t AuditEventType) String() string {
+	switch t {
+	case AuditKeyAdded:
+		return "KeyAdded"
+	case AuditKeyMismatch:
+		return "KeyMismatch"
+	case AuditRecordKeyMismatch:
+		return "RecordKeyMismatch"
+	case AuditRecordStale:
+		return "RecordStale"
+	default:
+		return "Unknown"
+	}
+}
+
+// MarshalText encodes the type as its name.
+func (t AuditEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// AuditEvent is a security-relevant event, as recorded by an AuditSink.
+type AuditEvent struct {
+	Time time.Time
+	Type AuditEventType
+	Peer peer.ID
+
+	// Private is set for key events about private keys.
+	Private bool `json:",omitempty"`
+
+	// Seq and StoredSeq are the sequence numbers of the signed peer record received and of the one held, for record
+	// events.
+	Seq       uint64 `json:",omitempty"`
+	StoredSeq uint64 `json:",omitempty"`
+}
+
+// AuditSink receives the security-relevant events of a peerstore, e.g. to keep evidence of impersonation attempts.
+// Events are delivered synchronously, while the peerstore handles the operation that caused them, so Audit must not
+// block nor call back into the peerstore.
+type AuditSink interface {
+	Audit(AuditEvent)
+}
+
+// AuditSinkFunc adapts a function to an AuditSink.
+type AuditSinkFunc func(AuditEvent)
+
+// Audit calls f(e).
+func (f AuditSinkFunc) Audit(e AuditEvent) {
+	f(e)
+}
+
+// AuditLog is an AuditSink appending events to a writer, one JSON object per line.
+type AuditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewAuditLog creates an AuditLog writing to w. To keep the log append-only, files should be opened with
+// os.O_APPEND.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{enc: json.NewEncoder(w)}
+}
+
+// Audit appends an event to the log. Write errors are logged, and the event dropped.
+func (l *AuditLog) Audit(e AuditEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil {
+		log.Errorf("failed to write audit event %s for peer %s: %s", e.Type, e.Peer.Pretty(), err)
+	}
+}
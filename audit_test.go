@@ -0,0 +1,43 @@
+package peerstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func TestAuditLog(t *testing.T) {
+	id, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	l := NewAuditLog(&buf)
+	now := time.Unix(1600000000, 0).UTC()
+	l.Audit(AuditEvent{Time: now, Type: AuditKeyAdded, Peer: id})
+	l.Audit(AuditEvent{Time: now, Type: AuditRecordStale, Peer: id, Seq: 1, StoredSeq: 2})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per event, got %q", buf.String())
+	}
+	var e struct {
+		Type      string
+		Peer      string
+		Seq       uint64
+		StoredSeq uint64
+	}
+	if err := json.Unmarshal(lines[1], &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != "RecordStale" || e.Peer != id.Pretty() || e.Seq != 1 || e.StoredSeq != 2 {
+		t.Fatalf("unexpected event %s", lines[1])
+	}
+	if bytes.Contains(lines[0], []byte("Seq")) || bytes.Contains(lines[0], []byte("Private")) {
+		t.Fatalf("expected unset fields to be omitted, got %s", lines[0])
+	}
+}
@@ -0,0 +1,169 @@
+package peerstore
+
+import (
+	"encoding/gob"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// BackoffKey is the metadata key under which BackoffBook stores the dial backoff of a peer.
+const BackoffKey = "backoff"
+
+func init() {
+	// allow datastore-backed metadata books to persist backoffs.
+	gob.Register(PeerBackoff{})
+}
+
+// PeerBackoff is the dial backoff state of a peer, as stored by BackoffBook.
+type PeerBackoff struct {
+	// Until is a Unix timestamp in nanoseconds before which the peer shouldn't be dialed.
+	Until int64
+	// Failures is the number of consecutive failed dials.
+	Failures int
+	// Updated is a Unix timestamp in nanoseconds of the last dial recorded.
+	Updated int64
+}
+
+// BackoffOption configures a BackoffBook.
+type BackoffOption func(*BackoffBook)
+
+// WithBackoffBase sets the backoff after the first failed dial, doubled on every further failure. Defaults to five
+// seconds.
+func WithBackoffBase(d time.Duration) BackoffOption {
+	return func(bb *BackoffBook) {
+		bb.base = d
+	}
+}
+
+// WithBackoffMax caps the backoff. Defaults to five minutes.
+func WithBackoffMax(d time.Duration) BackoffOption {
+	return func(bb *BackoffBook) {
+		bb.max = d
+	}
+}
+
+// WithBackoffExpiry sets how long after its backoff ends the failure streak of a peer is forgotten, so that peers
+// which failed long ago start over from the base backoff. Defaults to one hour.
+func WithBackoffExpiry(d time.Duration) BackoffOption {
+	return func(bb *BackoffBook) {
+		bb.expiry = d
+	}
+}
+
+// WithBackoffClock sets the source of time of a BackoffBook. Defaults to the system clock.
+func WithBackoffClock(c Clock) BackoffOption {
+	return func(bb *BackoffBook) {
+		bb.clock = c
+	}
+}
+
+// BackoffBook tracks when peers may be dialed again after failed dials, in the metadata of a peerstore, under
+// BackoffKey, so that the backoff of a swarm survives restarts along with the peerstore, and can be inspected by
+// operators. The backoff grows exponentially with the number of consecutive failures, and is cleared by a successful
+// dial. Entries expire on their own: once a backoff has ended for longer than the expiry, the peer is treated as if
+// it never failed. Their storage is reclaimed along with the rest of the peer, e.g. by peer GC.
+//
+// Writes are serialized by the BackoffBook, which should thus be shared by all writers of a peerstore.
+type BackoffBook struct {
+	md     pstore.PeerMetadata
+	clock  Clock
+	base   time.Duration
+	max    time.Duration
+	expiry time.Duration
+
+	mu sync.Mutex
+}
+
+// NewBackoffBook creates a BackoffBook backed by md.
+func NewBackoffBook(md pstore.PeerMetadata, opts ...BackoffOption) *BackoffBook {
+	bb := &BackoffBook{
+		md:     md,
+		clock:  RealClock{},
+		base:   5 * time.Second,
+		max:    5 * time.Minute,
+		expiry: time.Hour,
+	}
+	for _, opt := range opts {
+		opt(bb)
+	}
+	return bb
+}
+
+// Backoff returns the backoff state of a peer, the zero value if it has none or it expired.
+func (bb *BackoffBook) Backoff(p peer.ID) PeerBackoff {
+	return bb.load(p, bb.clock.Now())
+}
+
+// NextDial returns when a peer may be dialed again, the zero time if it may be dialed right away.
+func (bb *BackoffBook) NextDial(p peer.ID) time.Time {
+	now := bb.clock.Now()
+	if pb := bb.load(p, now); pb.Until > now.UnixNano() {
+		return time.Unix(0, pb.Until)
+	}
+	return time.Time{}
+}
+
+// CanDial returns whether a peer may be dialed now.
+func (bb *BackoffBook) CanDial(p peer.ID) bool {
+	return bb.NextDial(p).IsZero()
+}
+
+// RecordFailure records a failed dial of a peer, extending its backoff, and returns its resulting state.
+func (bb *BackoffBook) RecordFailure(p peer.ID) (PeerBackoff, error) {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+
+	now := bb.clock.Now()
+	pb := bb.load(p, now)
+	pb.Failures++
+	pb.Until = now.Add(bb.backoff(pb.Failures)).UnixNano()
+	pb.Updated = now.UnixNano()
+	if err := bb.md.Put(p, BackoffKey, pb); err != nil {
+		return PeerBackoff{}, err
+	}
+	return pb, nil
+}
+
+// RecordSuccess records a successful dial of a peer, clearing its backoff.
+func (bb *BackoffBook) RecordSuccess(p peer.ID) error {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+
+	now := bb.clock.Now()
+	if bb.load(p, now).Failures == 0 {
+		return nil
+	}
+	// metadata can't be deleted, so the state is reset instead.
+	return bb.md.Put(p, BackoffKey, PeerBackoff{Updated: now.UnixNano()})
+}
+
+// backoff returns the backoff after the given number of consecutive failures.
+func (bb *BackoffBook) backoff(failures int) time.Duration {
+	d := bb.base
+	for i := 1; i < failures && d < bb.max; i++ {
+		d *= 2
+	}
+	if d > bb.max {
+		d = bb.max
+	}
+	return d
+}
+
+func (bb *BackoffBook) load(p peer.ID, now time.Time) PeerBackoff {
+	v, err := bb.md.Get(p, BackoffKey)
+	if err != nil {
+		return PeerBackoff{}
+	}
+	pb, ok := v.(PeerBackoff)
+	if !ok {
+		log.Debugf("unexpected type %T under metadata key %s for peer %s", v, BackoffKey, p.Pretty())
+		return PeerBackoff{}
+	}
+	if now.After(time.Unix(0, pb.Until).Add(bb.expiry)) {
+		return PeerBackoff{}
+	}
+	return pb
+}
@@ -0,0 +1,102 @@
+package peerstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestBackoffBook(t *testing.T) {
+	clock := pt.NewMockClock()
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+	bb := pstore.NewBackoffBook(ps,
+		pstore.WithBackoffBase(time.Second),
+		pstore.WithBackoffMax(5*time.Second),
+		pstore.WithBackoffExpiry(time.Minute),
+		pstore.WithBackoffClock(clock))
+	ids := pt.GeneratePeerIDs(2)
+
+	if !bb.CanDial(ids[0]) {
+		t.Fatal("expected unknown peer to be dialable")
+	}
+
+	// the backoff doubles on every failure, up to the max.
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		pb, err := bb.RecordFailure(ids[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pb.Failures != i+1 {
+			t.Fatalf("expected %d failures, got %d", i+1, pb.Failures)
+		}
+		if got := bb.NextDial(ids[0]).Sub(clock.Now()); got != want {
+			t.Fatalf("expected a backoff of %s after %d failures, got %s", want, i+1, got)
+		}
+	}
+	if bb.CanDial(ids[0]) {
+		t.Fatal("expected backed off peer not to be dialable")
+	}
+	clock.Add(5 * time.Second)
+	if !bb.CanDial(ids[0]) {
+		t.Fatal("expected peer to be dialable once its backoff ended")
+	}
+
+	// a success clears the backoff.
+	if err := bb.RecordSuccess(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if pb := bb.Backoff(ids[0]); pb.Failures != 0 || pb.Until != 0 {
+		t.Fatalf("expected backoff to be cleared, got %+v", pb)
+	}
+
+	// the failure streak is forgotten once the backoff ended long enough ago.
+	for i := 0; i < 3; i++ {
+		if _, err := bb.RecordFailure(ids[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Add(4*time.Second + time.Minute)
+	if pb := bb.Backoff(ids[1]); pb.Failures != 3 {
+		t.Fatalf("expected backoff to be retained until it expires, got %+v", pb)
+	}
+	clock.Add(time.Second)
+	if pb := bb.Backoff(ids[1]); pb.Failures != 0 {
+		t.Fatalf("expected backoff to expire, got %+v", pb)
+	}
+	if pb, err := bb.RecordFailure(ids[1]); err != nil || pb.Failures != 1 {
+		t.Fatalf("expected the failure streak to start over, got %+v (%v)", pb, err)
+	}
+}
+
+func TestBackoffBookPersistence(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	p := pt.GeneratePeerIDs(1)[0]
+
+	ps, err := pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pstore.NewBackoffBook(ps).RecordFailure(p); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+
+	ps, err = pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	bb := pstore.NewBackoffBook(ps)
+	if bb.CanDial(p) || bb.Backoff(p).Failures != 1 {
+		t.Fatalf("expected backoff to persist, got %+v", bb.Backoff(p))
+	}
+}
@@ -0,0 +1,141 @@
+package peerstore
+
+import (
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ChainedPeerstore is a Peerstore that falls back to other peerstores for the peers its primary knows nothing about,
+// e.g. a read-only seed store of well-known peers bundled with an application.
+//
+// Reads are served by the primary if it holds data of the requested kind for the peer, and otherwise by the first
+// fallback that does: addresses, keys, protocols and metadata are looked up independently. Peer listings are the
+// union of all peerstores. Writes, metrics, address streams and Close only concern the primary; fallbacks are never
+// written to, nor closed.
+type ChainedPeerstore struct {
+	pstore.Peerstore
+
+	fallbacks []pstore.Peerstore
+}
+
+var _ pstore.Peerstore = (*ChainedPeerstore)(nil)
+
+// NewChained chains primary with fallbacks, consulted in order.
+func NewChained(primary pstore.Peerstore, fallbacks ...pstore.Peerstore) *ChainedPeerstore {
+	return &ChainedPeerstore{Peerstore: primary, fallbacks: fallbacks}
+}
+
+// Addrs returns the addresses of a peer held by the first peerstore that has any.
+func (cp *ChainedPeerstore) Addrs(p peer.ID) []ma.Multiaddr {
+	addrs := cp.Peerstore.Addrs(p)
+	for _, fb := range cp.fallbacks {
+		if len(addrs) > 0 {
+			break
+		}
+		addrs = fb.Addrs(p)
+	}
+	return addrs
+}
+
+// PubKey returns the public key of a peer held by the first peerstore that has it.
+func (cp *ChainedPeerstore) PubKey(p peer.ID) ic.PubKey {
+	pk := cp.Peerstore.PubKey(p)
+	for _, fb := range cp.fallbacks {
+		if pk != nil {
+			break
+		}
+		pk = fb.PubKey(p)
+	}
+	return pk
+}
+
+// PrivKey returns the private key of a peer held by the first peerstore that has it.
+func (cp *ChainedPeerstore) PrivKey(p peer.ID) ic.PrivKey {
+	sk := cp.Peerstore.PrivKey(p)
+	for _, fb := range cp.fallbacks {
+		if sk != nil {
+			break
+		}
+		sk = fb.PrivKey(p)
+	}
+	return sk
+}
+
+// Get returns a metadata value of a peer held by the first peerstore that has it.
+func (cp *ChainedPeerstore) Get(p peer.ID, key string) (interface{}, error) {
+	v, err := cp.Peerstore.Get(p, key)
+	for _, fb := range cp.fallbacks {
+		if err != pstore.ErrNotFound {
+			break
+		}
+		v, err = fb.Get(p, key)
+	}
+	return v, err
+}
+
+// GetProtocols returns the protocols of a peer held by the first peerstore that has any.
+func (cp *ChainedPeerstore) GetProtocols(p peer.ID) ([]string, error) {
+	return cp.protocolSource(p).GetProtocols(p)
+}
+
+func (cp *ChainedPeerstore) SupportsProtocols(p peer.ID, protos ...string) ([]string, error) {
+	return cp.protocolSource(p).SupportsProtocols(p, protos...)
+}
+
+func (cp *ChainedPeerstore) FirstSupportedProtocol(p peer.ID, protos ...string) (string, error) {
+	return cp.protocolSource(p).FirstSupportedProtocol(p, protos...)
+}
+
+// protocolSource returns the first peerstore holding protocols for p, or the primary if none does.
+func (cp *ChainedPeerstore) protocolSource(p peer.ID) pstore.Peerstore {
+	if protos, err := cp.Peerstore.GetProtocols(p); err != nil || len(protos) > 0 {
+		return cp.Peerstore
+	}
+	for _, fb := range cp.fallbacks {
+		if protos, err := fb.GetProtocols(p); err == nil && len(protos) > 0 {
+			return fb
+		}
+	}
+	return cp.Peerstore
+}
+
+func (cp *ChainedPeerstore) PeerInfo(p peer.ID) peer.AddrInfo {
+	return peer.AddrInfo{ID: p, Addrs: cp.Addrs(p)}
+}
+
+// Peers returns the peers known to any of the peerstores.
+func (cp *ChainedPeerstore) Peers() peer.IDSlice {
+	return cp.union(pstore.Peerstore.Peers)
+}
+
+// PeersWithAddrs returns the peers with addresses in any of the peerstores.
+func (cp *ChainedPeerstore) PeersWithAddrs() peer.IDSlice {
+	return cp.union(pstore.Peerstore.PeersWithAddrs)
+}
+
+// PeersWithKeys returns the peers with keys in any of the peerstores.
+func (cp *ChainedPeerstore) PeersWithKeys() peer.IDSlice {
+	return cp.union(pstore.Peerstore.PeersWithKeys)
+}
+
+func (cp *ChainedPeerstore) union(list func(pstore.Peerstore) peer.IDSlice) peer.IDSlice {
+	ids := list(cp.Peerstore)
+	if len(cp.fallbacks) == 0 {
+		return ids
+	}
+	seen := make(map[peer.ID]struct{}, len(ids))
+	for _, p := range ids {
+		seen[p] = struct{}{}
+	}
+	for _, fb := range cp.fallbacks {
+		for _, p := range list(fb) {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				ids = append(ids, p)
+			}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,78 @@
+package peerstore_test
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	core "github.com/libp2p/go-libp2p-core/peerstore"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestChainedPeerstore(t *testing.T) {
+	primary := pstoremem.NewPeerstore()
+	defer primary.Close()
+	seed := pstoremem.NewPeerstore()
+	defer seed.Close()
+	cp := pstore.NewChained(primary, seed)
+
+	ids := pt.GeneratePeerIDs(2)
+	addrs := pt.GenerateAddrs(3)
+
+	// ids[0] is only known to the seed store; ids[1] to both.
+	seed.AddAddrs(ids[0], addrs[:1], time.Hour)
+	seed.AddAddrs(ids[1], addrs[1:2], time.Hour)
+	primary.AddAddrs(ids[1], addrs[2:], time.Hour)
+	if err := seed.AddProtocols(ids[0], "/seed/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := seed.Put(ids[0], "agent", "seed"); err != nil {
+		t.Fatal(err)
+	}
+	_, pk, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyed, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := seed.AddPubKey(keyed, pk); err != nil {
+		t.Fatal(err)
+	}
+
+	pt.AssertAddressesEqual(t, addrs[:1], cp.Addrs(ids[0]))
+	pt.AssertAddressesEqual(t, addrs[2:], cp.Addrs(ids[1]))
+	pt.AssertAddressesEqual(t, addrs[:1], cp.PeerInfo(ids[0]).Addrs)
+	if !pk.Equals(cp.PubKey(keyed)) {
+		t.Error("expected the public key of the seed store")
+	}
+	if protos, err := cp.GetProtocols(ids[0]); err != nil || len(protos) != 1 || protos[0] != "/seed/1.0.0" {
+		t.Errorf("expected the protocols of the seed store, got %v, %v", protos, err)
+	}
+	if proto, err := cp.FirstSupportedProtocol(ids[0], "/seed/1.0.0"); err != nil || proto != "/seed/1.0.0" {
+		t.Errorf("expected the seed protocol to be supported, got %q, %v", proto, err)
+	}
+	if v, err := cp.Get(ids[0], "agent"); err != nil || v != "seed" {
+		t.Errorf("expected the metadata of the seed store, got %v, %v", v, err)
+	}
+	if _, err := cp.Get(ids[1], "agent"); err != core.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if n := len(cp.Peers()); n != 3 {
+		t.Errorf("expected 3 peers, got %d", n)
+	}
+	if n := len(cp.PeersWithAddrs()); n != 2 {
+		t.Errorf("expected 2 peers with addresses, got %d", n)
+	}
+
+	// writes only hit the primary, which then takes precedence.
+	cp.AddAddrs(ids[0], addrs[2:], time.Hour)
+	pt.AssertAddressesEqual(t, addrs[2:], cp.Addrs(ids[0]))
+	pt.AssertAddressesEqual(t, addrs[:1], seed.Addrs(ids[0]))
+}
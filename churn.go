@@ -0,0 +1,203 @@
+package peerstore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrEventType is the kind of change an AddrEvent records.
+type AddrEventType int
+
+const (
+	// AddrAdded is recorded when an address the peer didn't hold, or held expired, is added or set. Refreshes of the
+	// TTL of a live address aren't recorded.
+	AddrAdded AddrEventType = iota
+	// AddrExpired is recorded when an expired address is dropped. The event is timed at the expiry of the address,
+	// rather than at the time it was dropped.
+	AddrExpired
+	// AddrRemoved is recorded when a live address is removed, by a zero TTL or by clearing the addresses of the peer.
+	AddrRemoved
+)
+
+func (t AddrEventType) String() string {
+	switch t {
+	case AddrAdded:
+		return "added"
+	case AddrExpired:
+		return "expired"
+	case AddrRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("AddrEventType(%d)", int(t))
+	}
+}
+
+// AddrEvent is a change of the addresses of a peer.
+type AddrEvent struct {
+	Addr ma.Multiaddr
+	Type AddrEventType
+	Time time.Time
+}
+
+func (e AddrEvent) String() string {
+	return fmt.Sprintf("%s %s %s", e.Time.Format(time.RFC3339), e.Type, e.Addr)
+}
+
+// AddrChurnBook is implemented by address books that keep a bounded history of the changes of the addresses of every
+// peer, for diagnosing peers whose addresses flap or that are rebound by NATs.
+type AddrChurnBook interface {
+	// AddrHistory returns the retained address events of a peer timed at or after since, oldest first.
+	AddrHistory(p peer.ID, since time.Time) []AddrEvent
+}
+
+// addrRing holds the latest events of a peer, overwriting the oldest once full.
+type addrRing struct {
+	events []AddrEvent
+	next   int
+	full   bool
+}
+
+func (r *addrRing) push(e AddrEvent) {
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+	r.full = r.full || r.next == 0
+}
+
+// held returns the events of the ring, in no particular order. The slice is owned by the ring.
+func (r *addrRing) held() []AddrEvent {
+	if r.full {
+		return r.events
+	}
+	return r.events[:r.next]
+}
+
+// all returns the events of the ring in the order they were pushed.
+func (r *addrRing) all() []AddrEvent {
+	if !r.full {
+		return append([]AddrEvent(nil), r.events[:r.next]...)
+	}
+	return append(append([]AddrEvent(nil), r.events[r.next:]...), r.events[:r.next]...)
+}
+
+// AddrChurnLog keeps the latest address events of every peer, in a ring buffer of fixed size per peer, for address
+// books implementing AddrChurnBook. A nil AddrChurnLog records nothing.
+//
+// Expiries may be found by several code paths, e.g. a cleanup of the cached copy of a record and of the stored one, so
+// an expiry already held for the same address and time is recorded once.
+type AddrChurnLog struct {
+	size int
+
+	mu    sync.Mutex
+	peers map[peer.ID]*addrRing
+}
+
+// NewAddrChurnLog creates an AddrChurnLog keeping up to size events per peer. It returns nil, which records nothing, if
+// size is not positive.
+func NewAddrChurnLog(size int) *AddrChurnLog {
+	if size <= 0 {
+		return nil
+	}
+	return &AddrChurnLog{size: size, peers: make(map[peer.ID]*addrRing)}
+}
+
+// Record records an event of p, dropping the oldest event of p if its ring is full.
+func (l *AddrChurnLog) Record(p peer.ID, addr ma.Multiaddr, typ AddrEventType, t time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.peers[p]
+	if !ok {
+		r = &addrRing{events: make([]AddrEvent, l.size)}
+		l.peers[p] = r
+	}
+	e := AddrEvent{Addr: addr, Type: typ, Time: t}
+	if typ == AddrExpired && containsAddrEvent(r.held(), e) {
+		return
+	}
+	r.push(e)
+}
+
+// History returns the events of p timed at or after since, oldest first.
+func (l *AddrChurnLog) History(p peer.ID, since time.Time) []AddrEvent {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	r, ok := l.peers[p]
+	var events []AddrEvent
+	if ok {
+		events = r.all()
+	}
+	l.mu.Unlock()
+	return FilterAddrEvents(events, since)
+}
+
+// Drain returns the events of every peer, in the order they were recorded, and forgets them.
+func (l *AddrChurnLog) Drain() map[peer.ID][]AddrEvent {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := make(map[peer.ID][]AddrEvent, len(l.peers))
+	for p, r := range l.peers {
+		res[p] = r.all()
+	}
+	l.peers = make(map[peer.ID]*addrRing)
+	return res
+}
+
+// Forget drops the events of p.
+func (l *AddrChurnLog) Forget(p peer.ID) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.peers, p)
+	l.mu.Unlock()
+}
+
+// Peers returns the peers with events.
+func (l *AddrChurnLog) Peers() peer.IDSlice {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	pids := make(peer.IDSlice, 0, len(l.peers))
+	for p := range l.peers {
+		pids = append(pids, p)
+	}
+	return pids
+}
+
+// FilterAddrEvents returns the events timed at or after since, sorted oldest first. Events timed alike keep their
+// order. events is sorted in place.
+func FilterAddrEvents(events []AddrEvent, since time.Time) []AddrEvent {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	i := sort.Search(len(events), func(i int) bool { return !events[i].Time.Before(since) })
+	if i == len(events) {
+		return nil
+	}
+	return events[i:]
+}
+
+// containsAddrEvent returns whether events holds an event of the same type, address and time as e.
+func containsAddrEvent(events []AddrEvent, e AddrEvent) bool {
+	for _, have := range events {
+		if have.Type == e.Type && have.Time.Equal(e.Time) && have.Addr.Equal(e.Addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package peerstore
+
+import "time"
+
+// Clock is the source of time used by peerstores to compute address expiry. Injecting a Clock lets tests control
+// time instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is a Clock backed by the system time.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
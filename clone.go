@@ -0,0 +1,15 @@
+package peerstore
+
+import (
+	"context"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// Cloner is implemented by peerstores that can copy themselves, e.g. for what-if simulations, test fixtures seeded
+// from a production peerstore, or offline analysis.
+type Cloner interface {
+	// Clone returns an independent copy of the peerstore, configured like it: writes to either of them are not seen
+	// by the other. The copy must be closed by the caller. Cancelling ctx aborts the copy.
+	Clone(ctx context.Context) (pstore.Peerstore, error)
+}
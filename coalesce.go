@@ -0,0 +1,94 @@
+package peerstore
+
+import (
+	"sync"
+	"sync/atomic"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// CoalescingPeerstore is a Peerstore middleware that coalesces concurrent identical reads: while a call to Addrs or
+// PubKey for a peer is in flight, other calls for the same peer wait for it and share its result, rather than hitting
+// the wrapped peerstore again. This spares the datastore a burst of identical lookups when many streams to the same
+// peer are opened at once.
+//
+// Results are only shared between concurrent calls; nothing is cached once a call returns.
+type CoalescingPeerstore struct {
+	pstore.Peerstore
+
+	addrs     flightGroup
+	pubKeys   flightGroup
+	coalesced uint64 // accessed atomically
+}
+
+var _ pstore.Peerstore = (*CoalescingPeerstore)(nil)
+
+// NewCoalescingPeerstore wraps ps, coalescing its reads.
+func NewCoalescingPeerstore(ps pstore.Peerstore) *CoalescingPeerstore {
+	return &CoalescingPeerstore{Peerstore: ps}
+}
+
+// Addrs returns the addresses of a peer, sharing the result of a concurrent call for the same peer if there is one.
+// Callers sharing a result get their own copy of it.
+func (cp *CoalescingPeerstore) Addrs(p peer.ID) []ma.Multiaddr {
+	v, shared := cp.addrs.do(p, &cp.coalesced, func() interface{} { return cp.Peerstore.Addrs(p) })
+	addrs := v.([]ma.Multiaddr)
+	if shared && addrs != nil {
+		addrs = append([]ma.Multiaddr(nil), addrs...)
+	}
+	return addrs
+}
+
+// PubKey returns the public key of a peer, sharing the result of a concurrent call for the same peer if there is one.
+func (cp *CoalescingPeerstore) PubKey(p peer.ID) ic.PubKey {
+	v, _ := cp.pubKeys.do(p, &cp.coalesced, func() interface{} { return cp.Peerstore.PubKey(p) })
+	pk, _ := v.(ic.PubKey)
+	return pk
+}
+
+// Coalesced returns the number of calls that were served with the result of a concurrent call.
+func (cp *CoalescingPeerstore) Coalesced() uint64 {
+	return atomic.LoadUint64(&cp.coalesced)
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+}
+
+// flightGroup runs one call per peer at a time, callers arriving while a call is in flight waiting for its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[peer.ID]*flightCall
+}
+
+// do calls fn, or waits for the result of the call in flight for p, in which case it increments joined and returns
+// true.
+func (g *flightGroup) do(p peer.ID, joined *uint64, fn func() interface{}) (interface{}, bool) {
+	g.mu.Lock()
+	if c, ok := g.calls[p]; ok {
+		g.mu.Unlock()
+		atomic.AddUint64(joined, 1)
+		c.wg.Wait()
+		return c.val, true
+	}
+	if g.calls == nil {
+		g.calls = make(map[peer.ID]*flightCall)
+	}
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.calls[p] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, p)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.val = fn()
+	return c.val, false
+}
@@ -0,0 +1,93 @@
+package peerstore_test
+
+import (
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	core "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+// gatedPeerstore blocks Addrs until released, counting calls.
+type gatedPeerstore struct {
+	core.Peerstore
+	gate  chan struct{}
+	calls int32
+}
+
+func (g *gatedPeerstore) Addrs(p peer.ID) []ma.Multiaddr {
+	atomic.AddInt32(&g.calls, 1)
+	<-g.gate
+	return g.Peerstore.Addrs(p)
+}
+
+func TestCoalescingPeerstore(t *testing.T) {
+	mem := pstoremem.NewPeerstore()
+	defer mem.Close()
+	gated := &gatedPeerstore{Peerstore: mem, gate: make(chan struct{})}
+	cp := pstore.NewCoalescingPeerstore(gated)
+
+	id := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(2)
+	cp.AddAddrs(id, addrs, time.Hour)
+
+	const callers = 10
+	results := make([][]ma.Multiaddr, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = cp.Addrs(id)
+		}(i)
+	}
+
+	// release the lookup once all other callers joined it.
+	for cp.Coalesced() < callers-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(gated.gate)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&gated.calls); n != 1 {
+		t.Fatalf("expected a single lookup, got %d", n)
+	}
+	for _, res := range results {
+		pt.AssertAddressesEqual(t, addrs, res)
+	}
+
+	// callers get their own copy of shared results.
+	results[0][0] = nil
+	for _, res := range results[1:] {
+		if res[0] == nil {
+			t.Fatal("expected results not to share memory")
+		}
+	}
+
+	// calls that don't overlap aren't coalesced.
+	cp.Addrs(id)
+	if n := atomic.LoadInt32(&gated.calls); n != 2 {
+		t.Fatalf("expected a second lookup, got %d", n)
+	}
+
+	_, pub, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk := cp.PubKey(kid); pk == nil || !pk.Equals(pub) {
+		t.Fatal("expected the public key of the peer")
+	}
+}
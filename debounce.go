@@ -0,0 +1,135 @@
+package peerstore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrDebouncer suppresses repeated additions of the same address, for the same peer and with the same TTL class,
+// within a short window, so that address books can skip their locks and datastore writes altogether when identify
+// or the DHT add identical addresses many times per second. TTLs fall in the same class when they are equal once
+// rounded down to a multiple of the window.
+//
+// A suppressed addition doesn't extend the expiry of the address, which thus lags by at most the window. Addresses
+// added with a TTL no longer than the window are never suppressed, nor are those of a peer whose addresses were
+// changed otherwise since, as reported to Forget.
+type AddrDebouncer struct {
+	suppressed uint64 // accessed atomically; keep first for 64-bit alignment.
+
+	window time.Duration
+	clock  Clock
+
+	mu        sync.Mutex
+	seen      map[peer.ID]map[string]debouncedAddr
+	nextSweep time.Time
+}
+
+type debouncedAddr struct {
+	class int64
+	until time.Time
+}
+
+// NewAddrDebouncer creates an AddrDebouncer suppressing additions repeated within window, as measured by clock. It
+// returns nil, which suppresses nothing, if window is not positive.
+func NewAddrDebouncer(window time.Duration, clock Clock) *AddrDebouncer {
+	if window <= 0 {
+		return nil
+	}
+	if clock == nil {
+		clock = RealClock{}
+	}
+	return &AddrDebouncer{window: window, clock: clock, seen: make(map[peer.ID]map[string]debouncedAddr)}
+}
+
+// Filter returns the addresses among addrs that weren't added for p with a TTL of the same class as ttl within the
+// window, and records them as added. The returned slice is addrs itself when nothing is suppressed.
+func (d *AddrDebouncer) Filter(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) []ma.Multiaddr {
+	if d == nil || len(addrs) == 0 {
+		return addrs
+	}
+	now := d.clock.Now()
+	class := int64(ttl / d.window)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !now.Before(d.nextSweep) {
+		d.sweepUnlocked(now)
+	}
+	seen, ok := d.seen[p]
+	if !ok {
+		seen = make(map[string]debouncedAddr)
+		d.seen[p] = seen
+	}
+
+	var res []ma.Multiaddr
+	for i, a := range addrs {
+		if a == nil {
+			if res != nil {
+				res = append(res, a)
+			}
+			continue
+		}
+		k := string(a.Bytes())
+		if ttl <= d.window {
+			// the address may expire within the window; leave it alone, and forget it, as its expiry may change.
+			delete(seen, k)
+		} else if prev, ok := seen[k]; ok && prev.class == class && now.Before(prev.until) {
+			atomic.AddUint64(&d.suppressed, 1)
+			if res == nil {
+				res = append(make([]ma.Multiaddr, 0, len(addrs)), addrs[:i]...)
+			}
+			continue
+		} else {
+			seen[k] = debouncedAddr{class: class, until: now.Add(d.window)}
+		}
+		if res != nil {
+			res = append(res, a)
+		}
+	}
+	if len(seen) == 0 {
+		delete(d.seen, p)
+	}
+	if res == nil {
+		return addrs
+	}
+	return res
+}
+
+// Forget drops what was recorded about p, so that its next additions aren't suppressed. Address books call it when
+// the addresses of a peer are set, updated, cleared, or couldn't be added.
+func (d *AddrDebouncer) Forget(p peer.ID) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	delete(d.seen, p)
+	d.mu.Unlock()
+}
+
+// Suppressed returns the number of additions suppressed so far.
+func (d *AddrDebouncer) Suppressed() uint64 {
+	if d == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&d.suppressed)
+}
+
+// sweepUnlocked drops the records whose window has passed, bounding memory to the additions of a window.
+func (d *AddrDebouncer) sweepUnlocked(now time.Time) {
+	for p, seen := range d.seen {
+		for k, e := range seen {
+			if !now.Before(e.until) {
+				delete(seen, k)
+			}
+		}
+		if len(seen) == 0 {
+			delete(d.seen, p)
+		}
+	}
+	d.nextSweep = now.Add(d.window)
+}
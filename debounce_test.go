@@ -0,0 +1,57 @@
+package peerstore_test
+
+import (
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestAddrDebouncer(t *testing.T) {
+	clock := pt.NewMockClock()
+	d := pstore.NewAddrDebouncer(time.Second, clock)
+	ids := pt.GeneratePeerIDs(2)
+	addrs := pt.GenerateAddrs(3)
+
+	filter := func(want int, p int, ttl time.Duration, as ...ma.Multiaddr) {
+		t.Helper()
+		if got := d.Filter(ids[p], as, ttl); len(got) != want {
+			t.Fatalf("expected %d addresses to go through, got %v", want, got)
+		}
+	}
+
+	filter(2, 0, time.Hour, addrs[:2]...)
+	// repeated additions are suppressed, new addresses and other peers aren't.
+	filter(1, 0, time.Hour, addrs...)
+	filter(2, 1, time.Hour, addrs[:2]...)
+	// TTLs in the same class are suppressed, others aren't.
+	filter(0, 0, time.Hour+500*time.Millisecond, addrs[0])
+	filter(1, 0, 2*time.Hour, addrs[0])
+	filter(1, 0, time.Hour, addrs[0])
+	if n := d.Suppressed(); n != 3 {
+		t.Fatalf("expected 3 suppressed additions, got %d", n)
+	}
+
+	// short TTLs are never suppressed.
+	filter(1, 0, time.Second, addrs[1])
+	filter(1, 0, time.Second, addrs[1])
+
+	// additions go through again once the window passed, or the peer was forgotten.
+	clock.Add(time.Second)
+	filter(1, 0, time.Hour, addrs[0])
+	filter(0, 0, time.Hour, addrs[0])
+	d.Forget(ids[0])
+	filter(1, 0, time.Hour, addrs[0])
+
+	// a nil debouncer suppresses nothing.
+	var nd *pstore.AddrDebouncer
+	if got := nd.Filter(ids[0], addrs, time.Hour); len(got) != len(addrs) {
+		t.Fatalf("expected all addresses to go through, got %v", got)
+	}
+	if pstore.NewAddrDebouncer(0, clock) != nil {
+		t.Fatal("expected no debouncer for a zero window")
+	}
+}
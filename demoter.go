@@ -0,0 +1,164 @@
+package peerstore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DemoterOption configures an AddrDemoter.
+type DemoterOption func(*demoterConfig)
+
+type demoterConfig struct {
+	demoteAfter int
+	removeAfter int
+	demotedTTL  time.Duration
+}
+
+// WithDemoteAfter sets the number of consecutive dial failures after which an address is demoted. Zero disables
+// demotion. Defaults to 3.
+func WithDemoteAfter(n int) DemoterOption {
+	return func(cfg *demoterConfig) {
+		cfg.demoteAfter = n
+	}
+}
+
+// WithRemoveAfter sets the number of consecutive dial failures after which an address is removed. Zero disables
+// removal. Defaults to 6.
+func WithRemoveAfter(n int) DemoterOption {
+	return func(cfg *demoterConfig) {
+		cfg.removeAfter = n
+	}
+}
+
+// WithDemotedTTL sets the TTL demoted addresses are set to. Defaults to TempAddrTTL.
+func WithDemotedTTL(d time.Duration) DemoterOption {
+	return func(cfg *demoterConfig) {
+		cfg.demotedTTL = d
+	}
+}
+
+// AddrDemoter keeps dead addresses from wasting dial attempts, based on the dial results it is fed: an address is
+// demoted to a short TTL after a number of consecutive failures, and removed after more of them. A successful dial
+// resets the failure count of the address; it is up to the caller to add it back with a longer TTL, as it normally
+// does for the addresses of connected peers.
+//
+// Demotion lowers the TTL of the address only: addresses whose TTL is already lower are left alone. Failures are
+// only counted for the addresses held by the address book, which must implement ExpiringAddrBook.
+type AddrDemoter struct {
+	ab  pstore.AddrBook
+	eab ExpiringAddrBook
+	cfg demoterConfig
+
+	mu       sync.Mutex
+	failures map[peer.ID]map[string]int
+}
+
+var _ PeerRemover = (*AddrDemoter)(nil)
+
+// NewAddrDemoter creates an AddrDemoter acting on ab.
+func NewAddrDemoter(ab pstore.AddrBook, opts ...DemoterOption) (*AddrDemoter, error) {
+	eab, ok := ab.(ExpiringAddrBook)
+	if !ok {
+		return nil, fmt.Errorf("address book does not expose address expiry")
+	}
+
+	cfg := demoterConfig{
+		demoteAfter: 3,
+		removeAfter: 6,
+		demotedTTL:  pstore.TempAddrTTL,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.demoteAfter < 0 || cfg.removeAfter < 0 {
+		return nil, fmt.Errorf("failure thresholds must not be negative, respectively: %d, %d", cfg.demoteAfter, cfg.removeAfter)
+	}
+	if cfg.demoteAfter > 0 && cfg.removeAfter > 0 && cfg.removeAfter <= cfg.demoteAfter {
+		return nil, fmt.Errorf("remove threshold must be larger than demote threshold, respectively: %d, %d", cfg.removeAfter, cfg.demoteAfter)
+	}
+	if cfg.demotedTTL <= 0 {
+		return nil, fmt.Errorf("demoted TTL must be positive: %s", cfg.demotedTTL)
+	}
+
+	return &AddrDemoter{
+		ab:       ab,
+		eab:      eab,
+		cfg:      cfg,
+		failures: make(map[peer.ID]map[string]int),
+	}, nil
+}
+
+// RecordDialResult records the result of dialing a peer on an address: err is nil if the dial succeeded. The address
+// is demoted or removed once it reaches the configured number of consecutive failures.
+func (d *AddrDemoter) RecordDialResult(p peer.ID, addr ma.Multiaddr, err error) {
+	k := string(addr.Bytes())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		d.resetUnlocked(p, k)
+		return
+	}
+
+	var current *ExpiringAddr
+	for _, a := range d.eab.AddrsWithExpiry(p) {
+		if a.Addr.Equal(addr) {
+			current = &a
+			break
+		}
+	}
+	if current == nil {
+		// not ours to demote; forget about it, as it may come back as a fresh address.
+		d.resetUnlocked(p, k)
+		return
+	}
+
+	counts, ok := d.failures[p]
+	if !ok {
+		counts = make(map[string]int)
+		d.failures[p] = counts
+	}
+	counts[k]++
+	n := counts[k]
+
+	switch {
+	case d.cfg.removeAfter > 0 && n >= d.cfg.removeAfter:
+		log.Debugf("removing address %s of peer %s after %d failed dials", addr, p.Pretty(), n)
+		d.ab.SetAddr(p, addr, 0)
+		d.resetUnlocked(p, k)
+	case d.cfg.demoteAfter > 0 && n >= d.cfg.demoteAfter && current.TTL > d.cfg.demotedTTL:
+		log.Debugf("demoting address %s of peer %s after %d failed dials", addr, p.Pretty(), n)
+		d.ab.SetAddr(p, addr, d.cfg.demotedTTL)
+	}
+}
+
+// Failures returns the number of consecutive failed dials recorded for an address of a peer.
+func (d *AddrDemoter) Failures(p peer.ID, addr ma.Multiaddr) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.failures[p][string(addr.Bytes())]
+}
+
+// RemovePeer forgets the failures recorded for a peer.
+func (d *AddrDemoter) RemovePeer(p peer.ID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.failures, p)
+}
+
+func (d *AddrDemoter) resetUnlocked(p peer.ID, k string) {
+	counts, ok := d.failures[p]
+	if !ok {
+		return
+	}
+	delete(counts, k)
+	if len(counts) == 0 {
+		delete(d.failures, p)
+	}
+}
@@ -0,0 +1,80 @@
+package peerstore_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestAddrDemoter(t *testing.T) {
+	ab := pstoremem.NewAddrBook()
+	defer ab.Close()
+
+	d, err := pstore.NewAddrDemoter(ab,
+		pstore.WithDemoteAfter(2),
+		pstore.WithRemoveAfter(4),
+		pstore.WithDemotedTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(2)
+	ab.AddAddrs(id, addrs, time.Hour)
+	dead, alive := addrs[0], addrs[1]
+
+	ttlOf := func() time.Duration {
+		t.Helper()
+		for _, a := range ab.AddrsWithExpiry(id) {
+			if a.Addr.Equal(dead) {
+				return a.TTL
+			}
+		}
+		t.Fatal("expected the address to be in the address book")
+		return 0
+	}
+	failed := errors.New("dial failed")
+
+	d.RecordDialResult(id, dead, failed)
+	if ttl := ttlOf(); ttl != time.Hour {
+		t.Fatalf("expected the address not to be demoted after a single failure, got TTL %s", ttl)
+	}
+
+	// a success resets the count.
+	d.RecordDialResult(id, dead, nil)
+	if n := d.Failures(id, dead); n != 0 {
+		t.Fatalf("expected failures to be reset on success, got %d", n)
+	}
+	d.RecordDialResult(id, dead, failed)
+	if ttl := ttlOf(); ttl != time.Hour {
+		t.Fatalf("expected the address not to be demoted after a reset, got TTL %s", ttl)
+	}
+
+	d.RecordDialResult(id, dead, failed)
+	if ttl := ttlOf(); ttl != time.Minute {
+		t.Fatalf("expected the address to be demoted, got TTL %s", ttl)
+	}
+
+	d.RecordDialResult(id, dead, failed)
+	d.RecordDialResult(id, dead, failed)
+	pt.AssertAddressesEqual(t, []ma.Multiaddr{alive}, ab.Addrs(id))
+	if n := d.Failures(id, dead); n != 0 {
+		t.Fatalf("expected failures to be forgotten once the address is removed, got %d", n)
+	}
+
+	// failures of addresses not in the address book aren't counted.
+	d.RecordDialResult(id, dead, failed)
+	if n := d.Failures(id, dead); n != 0 {
+		t.Fatalf("expected failures of unknown addresses not to be counted, got %d", n)
+	}
+
+	if _, err := pstore.NewAddrDemoter(ab, pstore.WithDemoteAfter(3), pstore.WithRemoveAfter(3)); err == nil {
+		t.Fatal("expected a remove threshold not above the demote threshold to be rejected")
+	}
+}
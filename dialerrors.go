@@ -0,0 +1,180 @@
+package peerstore
+
+import (
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DialErrorsKey is the metadata key under which DialErrorBook stores the last dial errors of the addresses of a peer.
+const DialErrorsKey = "dial-errors"
+
+// maxDialErrorLen is the length past which error messages are truncated, so that a verbose error doesn't bloat the
+// metadata of the peer.
+const maxDialErrorLen = 256
+
+func init() {
+	// allow datastore-backed metadata books to persist dial errors.
+	gob.Register(PeerDialErrors{})
+}
+
+// AddrDialError is the last error dialing an address, as stored by DialErrorBook.
+type AddrDialError struct {
+	// Addr is the binary representation of the multiaddr.
+	Addr []byte
+	// Error is the message of the error.
+	Error string
+	// Time is a Unix timestamp in nanoseconds of the failed dial.
+	Time int64
+}
+
+// PeerDialErrors is the set of last dial errors of the addresses of a peer, as stored by DialErrorBook.
+type PeerDialErrors struct {
+	Errors []AddrDialError
+}
+
+// DialError is the last error dialing an address of a peer.
+type DialError struct {
+	Addr  ma.Multiaddr
+	Error string
+	Time  time.Time
+}
+
+func (e DialError) String() string {
+	return fmt.Sprintf("%s: %s (%s)", e.Addr, e.Error, e.Time.Format(time.RFC3339))
+}
+
+// DialErrorOption configures a DialErrorBook.
+type DialErrorOption func(*DialErrorBook)
+
+// WithDialErrorLimit sets the number of addresses per peer whose last error is kept, the errors of the addresses that
+// failed least recently being dropped first. Defaults to 16.
+func WithDialErrorLimit(n int) DialErrorOption {
+	return func(eb *DialErrorBook) {
+		eb.limit = n
+	}
+}
+
+// WithDialErrorClock sets the source of time of a DialErrorBook. Defaults to the system clock.
+func WithDialErrorClock(c Clock) DialErrorOption {
+	return func(eb *DialErrorBook) {
+		eb.clock = c
+	}
+}
+
+// DialErrorBook keeps the last error dialing each address of a peer, with its time, in the metadata of a peerstore,
+// under DialErrorsKey, so that operators can tell why a known peer is unreachable. The error of an address is cleared
+// when dialing it succeeds. Messages longer than 256 bytes are truncated. Their storage is reclaimed along with the
+// rest of the peer, e.g. by peer GC.
+//
+// Writes are serialized by the DialErrorBook, which should thus be shared by all writers of a peerstore.
+type DialErrorBook struct {
+	md    pstore.PeerMetadata
+	clock Clock
+	limit int
+
+	mu sync.Mutex
+}
+
+// NewDialErrorBook creates a DialErrorBook backed by md.
+func NewDialErrorBook(md pstore.PeerMetadata, opts ...DialErrorOption) *DialErrorBook {
+	eb := &DialErrorBook{md: md, clock: RealClock{}, limit: 16}
+	for _, opt := range opts {
+		opt(eb)
+	}
+	return eb
+}
+
+// RecordFailure records that dialing an address of a peer failed with err, replacing the previous error of the
+// address.
+func (eb *DialErrorBook) RecordFailure(p peer.ID, addr ma.Multiaddr, err error) error {
+	msg := err.Error()
+	if len(msg) > maxDialErrorLen {
+		msg = msg[:maxDialErrorLen]
+	}
+	b := addr.Bytes()
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	pe := eb.load(p)
+	// the stored slice may be shared with readers, so it is copied rather than filtered in place.
+	errs := make([]AddrDialError, 0, len(pe.Errors)+1)
+	for _, e := range pe.Errors {
+		if string(e.Addr) != string(b) {
+			errs = append(errs, e)
+		}
+	}
+	errs = append(errs, AddrDialError{Addr: b, Error: msg, Time: eb.clock.Now().UnixNano()})
+	if eb.limit > 0 && len(errs) > eb.limit {
+		// entries are kept in the order they were recorded.
+		errs = errs[len(errs)-eb.limit:]
+	}
+	return eb.md.Put(p, DialErrorsKey, PeerDialErrors{Errors: errs})
+}
+
+// RecordSuccess records that dialing an address of a peer succeeded, clearing its last error.
+func (eb *DialErrorBook) RecordSuccess(p peer.ID, addr ma.Multiaddr) error {
+	b := addr.Bytes()
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	pe := eb.load(p)
+	errs := make([]AddrDialError, 0, len(pe.Errors))
+	for _, e := range pe.Errors {
+		if string(e.Addr) != string(b) {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) == len(pe.Errors) {
+		return nil
+	}
+	return eb.md.Put(p, DialErrorsKey, PeerDialErrors{Errors: errs})
+}
+
+// LastError returns the last error dialing an address of a peer, if it failed since it last succeeded.
+func (eb *DialErrorBook) LastError(p peer.ID, addr ma.Multiaddr) (DialError, bool) {
+	b := addr.Bytes()
+	for _, e := range eb.load(p).Errors {
+		if string(e.Addr) == string(b) {
+			return DialError{Addr: addr, Error: e.Error, Time: time.Unix(0, e.Time)}, true
+		}
+	}
+	return DialError{}, false
+}
+
+// DialErrors returns the last dial errors of the addresses of a peer, most recent first.
+func (eb *DialErrorBook) DialErrors(p peer.ID) []DialError {
+	pe := eb.load(p)
+	res := make([]DialError, 0, len(pe.Errors))
+	for _, e := range pe.Errors {
+		addr, err := ma.NewMultiaddrBytes(e.Addr)
+		if err != nil {
+			log.Debugf("skipping invalid address in the dial errors of peer %s: %s", p.Pretty(), err)
+			continue
+		}
+		res = append(res, DialError{Addr: addr, Error: e.Error, Time: time.Unix(0, e.Time)})
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Time.After(res[j].Time) })
+	return res
+}
+
+func (eb *DialErrorBook) load(p peer.ID) PeerDialErrors {
+	v, err := eb.md.Get(p, DialErrorsKey)
+	if err != nil {
+		return PeerDialErrors{}
+	}
+	pe, ok := v.(PeerDialErrors)
+	if !ok {
+		log.Debugf("unexpected type %T under metadata key %s for peer %s", v, DialErrorsKey, p.Pretty())
+		return PeerDialErrors{}
+	}
+	return pe
+}
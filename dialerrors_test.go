@@ -0,0 +1,97 @@
+package peerstore_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestDialErrorBook(t *testing.T) {
+	clock := pt.NewMockClock()
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+	eb := pstore.NewDialErrorBook(ps, pstore.WithDialErrorLimit(2), pstore.WithDialErrorClock(clock))
+	p := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(3)
+
+	if _, ok := eb.LastError(p, addrs[0]); ok {
+		t.Fatal("expected no error for an address never dialed")
+	}
+
+	if err := eb.RecordFailure(p, addrs[0], errors.New("connection refused")); err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(time.Second)
+	if err := eb.RecordFailure(p, addrs[1], errors.New("i/o timeout")); err != nil {
+		t.Fatal(err)
+	}
+	e, ok := eb.LastError(p, addrs[0])
+	if !ok || e.Error != "connection refused" || !e.Time.Equal(clock.Now().Add(-time.Second)) {
+		t.Fatalf("unexpected last error %+v", e)
+	}
+	if s := e.String(); !strings.HasPrefix(s, addrs[0].String()+": connection refused (") {
+		t.Fatalf("unexpected description %q", s)
+	}
+	if errs := eb.DialErrors(p); len(errs) != 2 || !errs[0].Addr.Equal(addrs[1]) || !errs[1].Addr.Equal(addrs[0]) {
+		t.Fatalf("expected the errors most recent first, got %v", errs)
+	}
+
+	// a new error replaces the previous one of the address.
+	clock.Add(time.Second)
+	if err := eb.RecordFailure(p, addrs[0], errors.New(strings.Repeat("x", 1000))); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := eb.LastError(p, addrs[0]); len(e.Error) != 256 || !e.Time.Equal(clock.Now()) {
+		t.Fatalf("expected a truncated, more recent error, got %+v", e)
+	}
+
+	// the errors of the addresses that failed least recently are dropped past the limit.
+	if err := eb.RecordFailure(p, addrs[2], errors.New("no route to host")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := eb.LastError(p, addrs[1]); ok {
+		t.Fatal("expected the oldest error to be dropped")
+	}
+
+	// a success clears the error of the address.
+	if err := eb.RecordSuccess(p, addrs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if errs := eb.DialErrors(p); len(errs) != 1 || !errs[0].Addr.Equal(addrs[2]) {
+		t.Fatalf("expected only the error of the last address, got %v", errs)
+	}
+}
+
+func TestDialErrorBookPersistence(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	p := pt.GeneratePeerIDs(1)[0]
+	addr := pt.GenerateAddrs(1)[0]
+
+	ps, err := pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pstore.NewDialErrorBook(ps).RecordFailure(p, addr, errors.New("connection refused")); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+
+	ps, err = pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	if e, ok := pstore.NewDialErrorBook(ps).LastError(p, addr); !ok || e.Error != "connection refused" {
+		t.Fatalf("expected the dial error to persist, got %+v", e)
+	}
+}
@@ -0,0 +1,321 @@
+// Command persistent runs a minimal host on top of a persistent peerstore, backed by badger.
+//
+// The host identifies a few remote hosts over TCP, storing their signed peer records, protocols and agent versions
+// the way the identify protocol of go-libp2p does, and names them so that its output shows names rather than peer IDs.
+// It then shuts down, restarts on the same datastore with a warm cache, checks that everything it learnt survived, and
+// waits for the tuned GC to purge the addresses that expired in the meantime. go-libp2p itself depends on this module,
+// so the host and the identify exchange are reduced to the parts that touch the peerstore.
+//
+// Usage:
+//
+//	persistent [-dir path] [-remotes n] [-gc-interval d]
+//
+// The datastore is created in a temporary directory, removed on exit, unless -dir is set.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	badger "github.com/ipfs/go-ds-badger"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+const (
+	agentVersion = "persistent-example/0.1"
+
+	// TTL of the address remotes announce as short-lived, which the GC purges after the restart.
+	transientTTL = time.Second
+)
+
+var transientAddr = ma.StringCast("/ip4/192.0.2.1/tcp/4001")
+
+type config struct {
+	// Directory of the badger datastore.
+	Dir string
+	// Number of remote hosts to identify.
+	Remotes int
+	// Interval of GC purge cycles, also used as their initial delay.
+	GCInterval time.Duration
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.Dir, "dir", "", "directory of the datastore (default: a temporary directory)")
+	flag.IntVar(&cfg.Remotes, "remotes", 3, "number of remote hosts to identify")
+	flag.DurationVar(&cfg.GCInterval, "gc-interval", time.Second, "interval of GC purge cycles")
+	flag.Parse()
+
+	if cfg.Dir == "" {
+		dir, err := ioutil.TempDir("", "peerstore-example")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		cfg.Dir = dir
+	}
+
+	if err := run(cfg, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run identifies the remotes, restarts the peerstore and checks what it retained.
+func run(cfg config, out io.Writer) error {
+	remotes := make([]*remote, cfg.Remotes)
+	for i := range remotes {
+		r, err := newRemote(fmt.Sprintf("/proto/%d", i))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		remotes[i] = r
+	}
+
+	// first run: identify every remote, then disconnect from them.
+	h, err := openHost(cfg)
+	if err != nil {
+		return err
+	}
+	for i, r := range remotes {
+		if err := h.identify(r.Multiaddr()); err != nil {
+			h.Close()
+			return err
+		}
+		h.ps.UpdateAddrs(r.id, pstore.ConnectedAddrTTL, pstore.RecentlyConnectedAddrTTL)
+		if err := h.ps.SetName(r.id, fmt.Sprintf("remote-%d", i)); err != nil {
+			h.Close()
+			return err
+		}
+		fmt.Fprintf(out, "identified %s at %s\n", peerstore.DisplayName(h.ps, r.id), r.Multiaddr())
+	}
+	identified := time.Now()
+	if err := h.Close(); err != nil {
+		return err
+	}
+
+	// second run: everything learnt must have survived the restart.
+	h, err = openHost(cfg)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	for _, r := range remotes {
+		if err := h.check(r); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "restored %s: %v\n", peerstore.DisplayName(h.ps, r.id), h.ps.Addrs(r.id))
+	}
+
+	// once the transient addresses have expired, the next GC cycle purges them from the datastore.
+	time.Sleep(time.Until(identified.Add(transientTTL + time.Second)))
+	deadline := time.Now().Add(10 * cfg.GCInterval)
+	for start := h.ps.Stats().GCVisits; h.ps.Stats().GCVisits < start+uint64(len(remotes)); {
+		if time.Now().After(deadline) {
+			return errors.New("GC didn't run in time")
+		}
+		time.Sleep(cfg.GCInterval / 10)
+	}
+	fmt.Fprintf(out, "GC purged the expired addresses, %d records visited so far\n", h.ps.Stats().GCVisits)
+	return nil
+}
+
+// host is the local host, storing what it learns about remotes in a persistent peerstore.
+type host struct {
+	store *badger.Datastore
+	ps    interface {
+		pstore.Peerstore
+		peerstore.NameBook
+		Stats() pstoreds.AddrBookStats
+	}
+}
+
+func openHost(cfg config) (*host, error) {
+	store, err := badger.NewDatastore(cfg.Dir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := pstoreds.DefaultOpts()
+	// purge expired addresses often, right from startup, visiting every record each time: this peerstore is small.
+	opts.GCPurgeInterval = cfg.GCInterval
+	opts.GCInitialDelay = cfg.GCInterval
+	opts.GCLookaheadInterval = 0
+	// load the records of the most accessed peers of the previous run into the cache on startup.
+	opts.CacheWarmPeers = 64
+
+	ps, err := pstoreds.NewPeerstore(context.Background(), store, opts)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return &host{store: store, ps: ps}, nil
+}
+
+// identify connects to a remote and stores its identify message, as the identify protocol does.
+func (h *host) identify(addr ma.Multiaddr) error {
+	conn, err := manet.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var msg identifyMsg
+	if err := json.NewDecoder(conn).Decode(&msg); err != nil {
+		return fmt.Errorf("failed to read identify message from %s: %s", addr, err)
+	}
+	env, rec, err := record.ConsumeEnvelope(msg.SignedPeerRecord, peer.PeerRecordEnvelopeDomain)
+	if err != nil {
+		return fmt.Errorf("invalid signed peer record from %s: %s", addr, err)
+	}
+	id := rec.(*peer.PeerRecord).PeerID
+
+	if err := h.ps.AddPubKey(id, env.PublicKey); err != nil {
+		return err
+	}
+	cab, ok := h.ps.(pstore.CertifiedAddrBook)
+	if !ok {
+		return errors.New("peerstore can't store signed peer records")
+	}
+	if _, err := cab.ConsumePeerRecord(env, pstore.ConnectedAddrTTL); err != nil {
+		return err
+	}
+	// unsigned addresses, e.g. observed ones, are stored alongside.
+	h.ps.AddAddr(id, transientAddr, transientTTL)
+	if err := h.ps.SetProtocols(id, msg.Protocols...); err != nil {
+		return err
+	}
+	return h.ps.Put(id, "AgentVersion", msg.AgentVersion)
+}
+
+// check verifies that the peerstore holds what was learnt about a remote.
+func (h *host) check(r *remote) error {
+	env := h.ps.(pstore.CertifiedAddrBook).GetPeerRecord(r.id)
+	if env == nil {
+		return fmt.Errorf("lost the signed peer record of %s", r.id)
+	}
+	if !env.PublicKey.Equals(r.key.GetPublic()) {
+		return fmt.Errorf("signed peer record of %s isn't signed by its key", r.id)
+	}
+	if addrs := h.ps.Addrs(r.id); len(addrs) == 0 || !containsAddr(addrs, r.Multiaddr()) {
+		return fmt.Errorf("lost the addresses of %s: %v", r.id, addrs)
+	}
+	if protos, err := h.ps.SupportsProtocols(r.id, r.proto); err != nil || len(protos) != 1 {
+		return fmt.Errorf("lost the protocols of %s (%v)", r.id, err)
+	}
+	if v, err := h.ps.Get(r.id, "AgentVersion"); err != nil || v != agentVersion {
+		return fmt.Errorf("lost the agent version of %s: %v (%v)", r.id, v, err)
+	}
+	if h.ps.Name(r.id) == "" {
+		return fmt.Errorf("lost the name of %s", r.id)
+	}
+	return nil
+}
+
+func (h *host) Close() error {
+	// the peerstore persists the access log warming the cache on close, so it must be closed first.
+	if err := h.ps.Close(); err != nil {
+		h.store.Close()
+		return err
+	}
+	return h.store.Close()
+}
+
+// identifyMsg is the message remotes send on every connection, a subset of that of the identify protocol.
+type identifyMsg struct {
+	SignedPeerRecord []byte
+	Protocols        []string
+	AgentVersion     string
+}
+
+// remote is a remote host, serving its identify message over TCP.
+type remote struct {
+	manet.Listener
+	id    peer.ID
+	key   crypto.PrivKey
+	proto string
+	ps    pstore.Peerstore
+}
+
+func newRemote(proto string) (*remote, error) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		return nil, err
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	l, err := manet.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		return nil, err
+	}
+	r := &remote{Listener: l, id: id, key: key, proto: proto, ps: pstoremem.NewPeerstore()}
+
+	// certify our own addresses, as hosts do on startup.
+	rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{l.Multiaddr()}})
+	env, err := record.Seal(rec, key)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	if _, err := r.ps.(pstore.CertifiedAddrBook).ConsumePeerRecord(env, pstore.PermanentAddrTTL); err != nil {
+		l.Close()
+		return nil, err
+	}
+	go r.serve()
+	return r, nil
+}
+
+func (r *remote) Close() error {
+	r.ps.Close()
+	return r.Listener.Close()
+}
+
+func (r *remote) serve() {
+	for {
+		conn, err := r.Accept()
+		if err != nil {
+			return
+		}
+		env, err := r.ps.(pstore.CertifiedAddrBook).GetPeerRecord(r.id).Marshal()
+		if err == nil {
+			err = json.NewEncoder(conn).Encode(identifyMsg{
+				SignedPeerRecord: env,
+				Protocols:        []string{r.proto},
+				AgentVersion:     agentVersion,
+			})
+		}
+		if err != nil {
+			log.Printf("failed to send identify message: %s", err)
+		}
+		conn.Close()
+	}
+}
+
+func containsAddr(addrs []ma.Multiaddr, a ma.Multiaddr) bool {
+	for _, b := range addrs {
+		if b.Equal(a) {
+			return true
+		}
+	}
+	return false
+}
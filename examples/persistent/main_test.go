@@ -0,0 +1,20 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerstore-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := run(config{Dir: dir, Remotes: 3, GCInterval: 200 * time.Millisecond}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,148 @@
+package peerstore
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+
+	"github.com/libp2p/go-libp2p-peerstore/addr"
+)
+
+// SignedOrUnsignedRecord packages a peer's addresses for peer exchange. If the address book holds a certified record
+// for the peer, Envelope carries it and Record is its decoded contents; otherwise Envelope is nil and Record is an
+// unsigned record assembled from the peer's known public addresses.
+type SignedOrUnsignedRecord struct {
+	Envelope *record.Envelope
+	Record   *peer.PeerRecord
+}
+
+// Signed returns true if this record is backed by a signed envelope.
+func (r *SignedOrUnsignedRecord) Signed() bool {
+	return r.Envelope != nil
+}
+
+// ExportPeerRecords selects up to n good peers from the peerstore and packages them as peer records, ready to be
+// shipped by gossip-based peer exchange protocols. A value of n <= 0 exports every eligible peer.
+//
+// Only peers with at least one public address, Tor onion and I2P garlic ones included, are eligible. If the address
+// book implements RecencyAddrBook, candidates whose public addresses were last added or confirmed more than
+// exportStaleness before those of the most recently seen candidate are stale, and ranked after the others, as they have
+// likely moved or gone. Candidates are then ranked by latency (peers with no measurements last), preferring peers with
+// a certified record when latencies are equal, and then the most recently seen ones. The optional filter can be used to
+// exclude peers, e.g. those we are not connected to; a nil filter accepts all peers.
+func ExportPeerRecords(ps pstore.Peerstore, n int, filter func(peer.ID) bool) []*SignedOrUnsignedRecord {
+	type candidate struct {
+		rec      *SignedOrUnsignedRecord
+		latency  time.Duration
+		lastSeen time.Time
+		stale    bool
+	}
+
+	cab, _ := ps.(pstore.CertifiedAddrBook)
+	rab, _ := ps.(RecencyAddrBook)
+
+	var candidates []candidate
+	for _, p := range ps.Peers() {
+		if filter != nil && !filter(p) {
+			continue
+		}
+		public := publicAddrs(ps.Addrs(p))
+		if len(public) == 0 {
+			continue
+		}
+		rec := exchangeRecord(cab, p, public)
+		candidates = append(candidates, candidate{rec: rec, latency: ps.LatencyEWMA(p), lastSeen: lastSeen(rab, p, public)})
+	}
+
+	var latest time.Time
+	for _, c := range candidates {
+		if c.lastSeen.After(latest) {
+			latest = c.lastSeen
+		}
+	}
+	for i := range candidates {
+		candidates[i].stale = latest.Sub(candidates[i].lastSeen) > exportStaleness
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ci, cj := candidates[i], candidates[j]
+		li, lj := ci.latency, cj.latency
+		switch {
+		case ci.stale != cj.stale:
+			return cj.stale
+		case li == lj && ci.rec.Signed() != cj.rec.Signed():
+			return ci.rec.Signed()
+		case li == lj:
+			return ci.lastSeen.After(cj.lastSeen)
+		case li == 0:
+			return false
+		case lj == 0:
+			return true
+		default:
+			return li < lj
+		}
+	})
+
+	if n > 0 && len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	out := make([]*SignedOrUnsignedRecord, len(candidates))
+	for i, c := range candidates {
+		out[i] = c.rec
+	}
+	return out
+}
+
+// exportStaleness is how long before the most recently seen candidate of ExportPeerRecords others are deemed stale.
+const exportStaleness = time.Hour
+
+// lastSeen returns when the most recently seen of the public addresses of a peer was last added or confirmed, or the
+// zero time if rab is nil.
+func lastSeen(rab RecencyAddrBook, p peer.ID, public []ma.Multiaddr) time.Time {
+	if rab == nil {
+		return time.Time{}
+	}
+	for _, a := range rab.AddrsByRecency(p) {
+		for _, pa := range public {
+			if a.Addr.Equal(pa) {
+				return a.LastSeen
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// exchangeRecord builds the exchange record for a peer, preferring the signed record if the address book holds one.
+func exchangeRecord(cab pstore.CertifiedAddrBook, p peer.ID, public []ma.Multiaddr) *SignedOrUnsignedRecord {
+	if cab != nil {
+		if env := cab.GetPeerRecord(p); env != nil {
+			// fall back to an unsigned record if the envelope is unreadable.
+			if r, err := env.Record(); err == nil {
+				if rec, ok := r.(*peer.PeerRecord); ok {
+					return &SignedOrUnsignedRecord{Envelope: env, Record: rec}
+				}
+			}
+		}
+	}
+	return &SignedOrUnsignedRecord{
+		Record: peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: p, Addrs: public}),
+	}
+}
+
+// publicAddrs returns the public addresses of addrs: those of public IPs, and of Tor onion services and I2P
+// destinations, which are reachable from anywhere through their network.
+func publicAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	public := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		if kind := addr.Exotic(a); manet.IsPublicAddr(a) || kind == addr.Onion || kind == addr.Garlic {
+			public = append(public, a)
+		}
+	}
+	return public
+}
@@ -0,0 +1,147 @@
+package peerstore_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestExportPeerRecords(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(3)
+	public, private := pt.Multiaddr("/ip4/1.2.3.4/tcp/4001"), pt.Multiaddr("/ip4/127.0.0.1/tcp/4001")
+
+	// ids[0]: slow, public. ids[1]: fast, public. ids[2]: private only.
+	ps.AddAddr(ids[0], public, time.Hour)
+	ps.AddAddr(ids[0], private, time.Hour)
+	ps.RecordLatency(ids[0], 200*time.Millisecond)
+	ps.AddAddr(ids[1], public, time.Hour)
+	ps.RecordLatency(ids[1], 10*time.Millisecond)
+	ps.AddAddr(ids[2], private, time.Hour)
+
+	recs := pstore.ExportPeerRecords(ps, 0, nil)
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 exported records, got %d", len(recs))
+	}
+	if recs[0].Record.PeerID != ids[1] || recs[1].Record.PeerID != ids[0] {
+		t.Fatal("expected records to be ordered by latency")
+	}
+	for _, r := range recs {
+		if r.Signed() {
+			t.Fatal("expected unsigned records")
+		}
+		pt.AssertAddressesEqual(t, []ma.Multiaddr{public}, r.Record.Addrs)
+	}
+
+	if recs := pstore.ExportPeerRecords(ps, 1, nil); len(recs) != 1 || recs[0].Record.PeerID != ids[1] {
+		t.Fatal("expected only the fastest peer to be exported")
+	}
+
+	recs = pstore.ExportPeerRecords(ps, 0, func(p peer.ID) bool { return p != ids[1] })
+	if len(recs) != 1 || recs[0].Record.PeerID != ids[0] {
+		t.Fatal("expected filtered peer to be excluded")
+	}
+}
+
+func TestExportPeerRecordsOverlay(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	// onion addresses have no IP, but are reachable from anywhere through Tor.
+	id := pt.GeneratePeerIDs(1)[0]
+	onion := pt.Multiaddr("/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:1234")
+	ps.AddAddr(id, onion, time.Hour)
+
+	recs := pstore.ExportPeerRecords(ps, 0, nil)
+	if len(recs) != 1 || recs[0].Record.PeerID != id {
+		t.Fatalf("expected the onion peer to be exported, got %v", recs)
+	}
+	pt.AssertAddressesEqual(t, []ma.Multiaddr{onion}, recs[0].Record.Addrs)
+}
+
+func TestExportPeerRecordsSigned(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := peer.NewPeerRecord()
+	rec.PeerID = id
+	rec.Addrs = []ma.Multiaddr{pt.Multiaddr("/ip4/1.2.3.4/tcp/4001")}
+	env, err := record.Seal(rec, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.ConsumePeerRecord(env, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := pstore.ExportPeerRecords(ps, 0, nil)
+	if len(recs) != 1 || !recs[0].Signed() {
+		t.Fatal("expected a single signed record")
+	}
+	if !recs[0].Envelope.Equal(env) || recs[0].Record.Seq != rec.Seq {
+		t.Fatal("exported envelope does not match the consumed one")
+	}
+}
+
+func TestExportPeerRecordsRecency(t *testing.T) {
+	clock := pt.NewMockClock()
+	ps := pstoremem.NewPeerstore(pstoremem.WithClock(clock))
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(4)
+	var public []ma.Multiaddr
+	for i := 1; i <= 4; i++ {
+		public = append(public, pt.Multiaddr(fmt.Sprintf("/ip4/1.2.3.%d/tcp/4001", i)))
+	}
+	order := func() (res []peer.ID) {
+		for _, r := range pstore.ExportPeerRecords(ps, 0, nil) {
+			res = append(res, r.Record.PeerID)
+		}
+		return res
+	}
+
+	// ids[0]: fastest, seen 2h ago. ids[2]: no measurements, seen 30m ago. ids[1]: slow, and ids[3]: no measurements,
+	// both seen just now.
+	ps.AddAddr(ids[0], public[0], 24*time.Hour)
+	ps.RecordLatency(ids[0], 10*time.Millisecond)
+	clock.Add(90 * time.Minute)
+	ps.AddAddr(ids[2], public[2], 24*time.Hour)
+	clock.Add(30 * time.Minute)
+	ps.AddAddr(ids[1], public[1], 24*time.Hour)
+	ps.RecordLatency(ids[1], 200*time.Millisecond)
+	ps.AddAddr(ids[3], public[3], 24*time.Hour)
+
+	// the stale peer ranks last despite its latency, and the most recently seen one first among equals.
+	if got, want := order(), []peer.ID{ids[1], ids[3], ids[2], ids[0]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	// once its address is confirmed, the peer is no longer stale.
+	ps.AddAddr(ids[0], public[0], 24*time.Hour)
+	if got, want := order(), []peer.ID{ids[0], ids[1], ids[3], ids[2]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,88 @@
+package peerstore
+
+import (
+	"errors"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/libp2p/go-libp2p-peerstore/addr"
+)
+
+// ErrExoticAddrDropped is returned when addresses of a transport dropped by an ExoticAddrPolicy are added or set.
+var ErrExoticAddrDropped = errors.New("address of a dropped transport")
+
+// DefaultCertHashAddrTTL is the longest TTL of the addresses carrying a /certhash component under an ExoticAddrPolicy
+// that doesn't set one. Listeners rotate their certificates, so the addresses of older ones stop being dialable.
+const DefaultCertHashAddrTTL = 14 * 24 * time.Hour
+
+// ExoticAddrPolicy decides how address books handle the addresses of the transports which aren't plain IP ones (see
+// addr.Exotic): Tor onion services, I2P destinations, WebTransport and WebRTC. The zero value stores them all, with
+// the TTL of addresses carrying a /certhash component capped to DefaultCertHashAddrTTL.
+type ExoticAddrPolicy struct {
+	// Validate rejects the addresses of these transports whose components aren't arranged as their transport expects,
+	// with an error wrapping addr.ErrMalformedExotic, see addr.ValidateExotic.
+	Validate bool
+
+	// Drop lists the kinds of addresses rejected with ErrExoticAddrDropped, e.g. addr.Onion and addr.Garlic on nodes
+	// without a Tor or I2P router.
+	Drop []addr.ExoticKind
+
+	// CertHashTTL caps the TTL of the addresses carrying a /certhash component. Zero applies DefaultCertHashAddrTTL,
+	// and a negative value leaves them uncapped.
+	CertHashTTL time.Duration
+}
+
+// Apply returns nil if the address may be stored, or the reason it's rejected.
+func (pol ExoticAddrPolicy) Apply(a ma.Multiaddr) error {
+	if pol.Validate {
+		if err := addr.ValidateExotic(a); err != nil {
+			return err
+		}
+	}
+	if len(pol.Drop) > 0 {
+		kind := addr.Exotic(a)
+		for _, k := range pol.Drop {
+			if k == kind {
+				return ErrExoticAddrDropped
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyAll applies the policy to addrs, returning the addresses to store, and the reason the first rejected one was
+// rejected, if any.
+func (pol ExoticAddrPolicy) ApplyAll(addrs []ma.Multiaddr) ([]ma.Multiaddr, error) {
+	if !pol.Validate && len(pol.Drop) == 0 {
+		return addrs, nil
+	}
+	var err error
+	res := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		if a == nil {
+			continue
+		}
+		if aerr := pol.Apply(a); aerr != nil {
+			if err == nil {
+				err = aerr
+			}
+			continue
+		}
+		res = append(res, a)
+	}
+	return res, err
+}
+
+// TTL returns the TTL to store an address with when it's added or set with ttl. TTLs that don't make addresses expire,
+// such as ConnectedAddrTTL, are capped too: the certificates of a connected peer may rotate while connected.
+func (pol ExoticAddrPolicy) TTL(a ma.Multiaddr, ttl time.Duration) time.Duration {
+	max := pol.CertHashTTL
+	if max == 0 {
+		max = DefaultCertHashAddrTTL
+	}
+	if max < 0 || ttl <= max || !addr.HasCertHash(a) {
+		return ttl
+	}
+	return max
+}
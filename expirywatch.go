@@ -0,0 +1,211 @@
+package peerstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// ExpiryWarning notifies that a watched peer is about to be left without addresses.
+type ExpiryWarning struct {
+	Peer peer.ID
+	// Expires is when the last address of the peer expires, the zero time if the peer has no addresses left.
+	Expires time.Time
+}
+
+// ExpiryWatcherOption configures an ExpiryWatcher.
+type ExpiryWatcherOption func(*expiryWatcherConfig)
+
+type expiryWatcherConfig struct {
+	interval time.Duration
+	lead     time.Duration
+	clock    Clock
+}
+
+// WithExpiryCheckInterval sets how often watched peers are checked. Defaults to ten seconds.
+func WithExpiryCheckInterval(d time.Duration) ExpiryWatcherOption {
+	return func(cfg *expiryWatcherConfig) {
+		cfg.interval = d
+	}
+}
+
+// WithExpiryLead sets how long before their last address expires subscribers are warned. Defaults to two minutes.
+func WithExpiryLead(d time.Duration) ExpiryWatcherOption {
+	return func(cfg *expiryWatcherConfig) {
+		cfg.lead = d
+	}
+}
+
+// WithExpiryClock sets the clock expiries are compared against, which should be that of the address book. Defaults
+// to the system clock.
+func WithExpiryClock(c Clock) ExpiryWatcherOption {
+	return func(cfg *expiryWatcherConfig) {
+		cfg.clock = c
+	}
+}
+
+// ExpiryWatcher warns subscribers when the last address of a peer they watch is about to expire, so that
+// applications holding sessions with the peer can refresh its contact information before losing it entirely.
+//
+// A subscriber is warned once when the last address of a peer comes within the lead of its expiry, then again
+// whenever that expiry changes while still within the lead, e.g. when the peer is left without addresses. Once the
+// addresses of the peer are renewed past the lead, the next approach to expiry triggers a new warning. Warnings a
+// subscriber isn't ready to receive are retried on the next check.
+//
+// The address book must implement ExpiringAddrBook.
+type ExpiryWatcher struct {
+	eab ExpiringAddrBook
+	cfg expiryWatcherConfig
+
+	mu   sync.Mutex
+	subs map[*expirySub]struct{}
+
+	ctx    context.Context
+	cancel func()
+	done   chan struct{}
+}
+
+// expirySub is a subscription to the expiry of a set of peers.
+type expirySub struct {
+	ch chan ExpiryWarning
+	// last expiry each watched peer was warned about, if any.
+	warned map[peer.ID]*time.Time
+}
+
+// NewExpiryWatcher creates an ExpiryWatcher and starts its background process. It must be closed when no longer
+// needed.
+func NewExpiryWatcher(ab pstore.AddrBook, opts ...ExpiryWatcherOption) (*ExpiryWatcher, error) {
+	eab, ok := ab.(ExpiringAddrBook)
+	if !ok {
+		return nil, fmt.Errorf("address book does not expose address expiry")
+	}
+
+	cfg := expiryWatcherConfig{
+		interval: 10 * time.Second,
+		lead:     2 * time.Minute,
+		clock:    RealClock{},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.interval <= 0 {
+		return nil, fmt.Errorf("expiry check interval must be positive: %s", cfg.interval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &ExpiryWatcher{
+		eab:    eab,
+		cfg:    cfg,
+		subs:   make(map[*expirySub]struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go w.background()
+	return w, nil
+}
+
+// Subscribe returns a channel of warnings about the given peers. The channel is closed when ctx is done or the
+// watcher is closed.
+func (w *ExpiryWatcher) Subscribe(ctx context.Context, peers ...peer.ID) <-chan ExpiryWarning {
+	sub := &expirySub{
+		ch:     make(chan ExpiryWarning, len(peers)),
+		warned: make(map[peer.ID]*time.Time, len(peers)),
+	}
+	for _, p := range peers {
+		sub.warned[p] = nil
+	}
+
+	w.mu.Lock()
+	if w.ctx.Err() != nil {
+		w.mu.Unlock()
+		close(sub.ch)
+		return sub.ch
+	}
+	w.subs[sub] = struct{}{}
+	w.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.ctx.Done():
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subs, sub)
+		close(sub.ch)
+	}()
+	return sub.ch
+}
+
+// Close stops the background process and closes the channels of all subscriptions.
+func (w *ExpiryWatcher) Close() error {
+	w.cancel()
+	<-w.done
+	return nil
+}
+
+func (w *ExpiryWatcher) background() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// check warns subscribers about the watched peers whose last address is about to expire.
+func (w *ExpiryWatcher) check() {
+	deadline := w.cfg.clock.Now().Add(w.cfg.lead)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// peers watched by several subscribers are only looked up once.
+	expiries := make(map[peer.ID]time.Time)
+	for sub := range w.subs {
+		for p, warned := range sub.warned {
+			exp, ok := expiries[p]
+			if !ok {
+				exp = w.lastExpiry(p)
+				expiries[p] = exp
+			}
+
+			if exp.After(deadline) {
+				sub.warned[p] = nil
+				continue
+			}
+			if warned != nil && warned.Equal(exp) {
+				continue
+			}
+			select {
+			case sub.ch <- ExpiryWarning{Peer: p, Expires: exp}:
+				sub.warned[p] = &exp
+			default:
+				log.Debugf("dropped expiry warning for peer %s, subscriber not ready", p.Pretty())
+			}
+		}
+	}
+}
+
+// lastExpiry returns when the last address of a peer expires, the zero time if it has none.
+func (w *ExpiryWatcher) lastExpiry(p peer.ID) time.Time {
+	var last time.Time
+	for _, a := range w.eab.AddrsWithExpiry(p) {
+		if a.Expires.After(last) {
+			last = a.Expires
+		}
+	}
+	return last
+}
@@ -0,0 +1,106 @@
+package peerstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestExpiryWatcher(t *testing.T) {
+	clock := pt.NewMockClock()
+	ps := pstoremem.NewPeerstore(pstoremem.WithClock(clock))
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(3)
+	addrs := pt.GenerateAddrs(3)
+
+	// ids[0] is about to expire, ids[1] is fresh, ids[2] is about to expire but not watched.
+	ps.AddAddr(ids[0], addrs[0], time.Minute)
+	ps.AddAddr(ids[1], addrs[1], 24*time.Hour)
+	ps.AddAddr(ids[2], addrs[2], time.Minute)
+
+	w, err := pstore.NewExpiryWatcher(ps,
+		pstore.WithExpiryCheckInterval(10*time.Millisecond),
+		pstore.WithExpiryLead(2*time.Minute),
+		pstore.WithExpiryClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := w.Subscribe(ctx, ids[0], ids[1])
+
+	expect := func(p peer.ID, expires time.Time) {
+		t.Helper()
+		select {
+		case ev := <-ch:
+			if ev.Peer != p || !ev.Expires.Equal(expires) {
+				t.Fatalf("expected warning for %s expiring at %s, got %s at %s", p, expires, ev.Peer, ev.Expires)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected warning for %s", p)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case ev := <-ch:
+			t.Fatalf("unexpected warning for %s", ev.Peer)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	expect(ids[0], clock.Now().Add(time.Minute))
+	expectNone()
+
+	// renewed past the lead: no warning until it approaches expiry again.
+	ps.AddAddr(ids[0], addrs[0], time.Hour)
+	expectNone()
+	clock.Add(59 * time.Minute)
+	expect(ids[0], clock.Now().Add(time.Minute))
+
+	// left without addresses: warned again, as the expiry changed.
+	ps.ClearAddrs(ids[0])
+	expect(ids[0], time.Time{})
+	expectNone()
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected the channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the channel to be closed")
+	}
+}
+
+func TestExpiryWatcherClose(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	if _, err := pstore.NewExpiryWatcher(ps, pstore.WithExpiryCheckInterval(0)); err == nil {
+		t.Error("expected error for a zero check interval")
+	}
+
+	w, err := pstore.NewExpiryWatcher(ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := w.Subscribe(context.Background(), pt.GeneratePeerIDs(1)...)
+	w.Close()
+	if _, ok := <-ch; ok {
+		t.Error("expected the channel to be closed with the watcher")
+	}
+	if _, ok := <-w.Subscribe(context.Background(), pt.GeneratePeerIDs(1)...); ok {
+		t.Error("expected subscriptions to a closed watcher to be closed")
+	}
+}
@@ -0,0 +1,160 @@
+package peerstore
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// GenerationTracker is implemented by peerstores that number the changes of every peer, so that caches layered above
+// them can tell whether an entry is still valid with a single integer comparison, rather than by comparing what they
+// cached with what the peerstore holds.
+//
+// The generation of a peer changes on every write to the data held about it, through any book, including removals. It
+// never decreases, and never takes the same value again after changing. Caches should read the generation of a peer
+// before the data they cache: the generation changes once a write is visible, so the data read may be newer than the
+// generation, which only causes a spurious invalidation, but never older.
+//
+// Expiry is not a change: addresses past their expiry stop being returned without the generation changing, so caches
+// of addresses must honour their expiry on their own, e.g. by caching AddrsWithExpiry. Writes made by other processes
+// sharing a datastore aren't seen either.
+type GenerationTracker interface {
+	// Generation returns the current generation of a peer.
+	Generation(p peer.ID) uint64
+}
+
+// ChangeFeed is implemented by peerstores numbering all their writes in a single sequence, and keeping a log of the
+// latest ones, so that external indexers and replication agents can sync incrementally: they read the changes since
+// the last sequence number they synced, and then the data of the peers changed.
+//
+// The log is bounded, so a consumer falling too far behind misses changes: this is the case when the first change
+// returned doesn't follow since, or when none is returned although ChangeSeq is above since. It must then sync fully,
+// e.g. from Peers, after reading ChangeSeq. Like generations (see GenerationTracker), changes are logged once visible,
+// the expiry of addresses isn't a change, and writes made by other processes sharing a datastore aren't seen.
+type ChangeFeed interface {
+	// ChangeSeq returns the sequence number of the latest change, 0 if there was none.
+	ChangeSeq() uint64
+	// Changes returns the changes logged after the sequence number since, oldest first.
+	Changes(since uint64) []ChangeRecord
+}
+
+// ChangeRecord is a change logged by a ChangeFeed.
+type ChangeRecord struct {
+	// Seq is the sequence number of the change, which is also the generation it moved Peer to.
+	Seq  uint64
+	Peer peer.ID
+	// Removed is set if the peer was removed, e.g. by RemovePeer or peer GC, rather than written to.
+	Removed bool
+}
+
+// Generations implements GenerationTracker and ChangeFeed for peerstores, whose books report their writes to it.
+// Generations are drawn from a single counter, the sequence of changes, so that forgotten peers can fall back to a
+// floor above any generation they had. A nil Generations tracks nothing.
+type Generations struct {
+	mu   sync.Mutex
+	last uint64
+	// floor is the generation of the peers without an entry, raised whenever an entry is dropped.
+	floor uint64
+	peers map[peer.ID]uint64
+
+	// log holds the latest changes in a ring buffer, next being the index of the oldest once full; nil if disabled.
+	log  []ChangeRecord
+	next int
+	full bool
+}
+
+var (
+	_ GenerationTracker = (*Generations)(nil)
+	_ ChangeFeed        = (*Generations)(nil)
+)
+
+// NewGenerations creates a Generations, logging the latest logSize changes. Changes aren't logged if logSize is not
+// positive, though they are still numbered.
+func NewGenerations(logSize int) *Generations {
+	g := &Generations{peers: make(map[peer.ID]uint64)}
+	if logSize > 0 {
+		g.log = make([]ChangeRecord, logSize)
+	}
+	return g
+}
+
+// Bump moves p to a new generation, once a write to its data is visible.
+func (g *Generations) Bump(p peer.ID) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.last++
+	g.peers[p] = g.last
+	g.logUnlocked(ChangeRecord{Seq: g.last, Peer: p})
+	g.mu.Unlock()
+}
+
+// Remove moves p to a new generation once it was removed, and drops its entry so that removed peers don't take any
+// space. Peers without an entry all share a generation, which every removal thus changes.
+func (g *Generations) Remove(p peer.ID) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.last++
+	g.floor = g.last
+	delete(g.peers, p)
+	g.logUnlocked(ChangeRecord{Seq: g.last, Peer: p, Removed: true})
+	g.mu.Unlock()
+}
+
+// logUnlocked logs a change, dropping the oldest one if the log is full. To be called with g locked.
+func (g *Generations) logUnlocked(c ChangeRecord) {
+	if g.log == nil {
+		return
+	}
+	g.log[g.next] = c
+	g.next = (g.next + 1) % len(g.log)
+	g.full = g.full || g.next == 0
+}
+
+// Generation returns the generation of p, which is 0 until its first write.
+func (g *Generations) Generation(p peer.ID) uint64 {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if gen, ok := g.peers[p]; ok {
+		return gen
+	}
+	return g.floor
+}
+
+// ChangeSeq returns the sequence number of the latest change.
+func (g *Generations) ChangeSeq() uint64 {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.last
+}
+
+// Changes returns the logged changes after since, oldest first.
+func (g *Generations) Changes(since uint64) []ChangeRecord {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if since >= g.last {
+		return nil
+	}
+
+	held := g.log[:g.next]
+	if g.full {
+		held = append(g.log[g.next:len(g.log):len(g.log)], held...)
+	}
+	// sequence numbers are consecutive, so the changes after since are the latest last-since ones.
+	if n := g.last - since; n < uint64(len(held)) {
+		held = held[uint64(len(held))-n:]
+	}
+	return append([]ChangeRecord(nil), held...)
+}
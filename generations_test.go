@@ -0,0 +1,82 @@
+package peerstore_test
+
+import (
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestGenerations(t *testing.T) {
+	gens := pstore.NewGenerations(0)
+	ids := pt.GeneratePeerIDs(3)
+	a, b, c := ids[0], ids[1], ids[2]
+
+	if gens.Generation(a) != 0 {
+		t.Fatal("expected unknown peers to start at 0")
+	}
+	gens.Bump(a)
+	gens.Bump(b)
+	ga, gb := gens.Generation(a), gens.Generation(b)
+	if ga == 0 || gb == ga {
+		t.Fatalf("expected distinct generations, got %d and %d", ga, gb)
+	}
+
+	// removals change the generation of the removed peer and of the peers without an entry, but not of the others.
+	gc := gens.Generation(c)
+	gens.Remove(a)
+	if g := gens.Generation(a); g == ga || g == 0 {
+		t.Fatalf("expected the removed peer to move to a new generation, got %d", g)
+	}
+	if gens.Generation(c) == gc {
+		t.Fatal("expected the peers without an entry to move to a new generation")
+	}
+	if gens.Generation(b) != gb {
+		t.Fatal("expected the other peers to keep their generation")
+	}
+	removed := gens.Generation(a)
+	gens.Bump(a)
+	if g := gens.Generation(a); g <= removed {
+		t.Fatalf("expected generations to increase, got %d after %d", g, removed)
+	}
+
+	var none *pstore.Generations
+	none.Bump(a)
+	none.Remove(a)
+	if none.Generation(a) != 0 {
+		t.Fatal("expected a nil Generations to track nothing")
+	}
+}
+
+func TestGenerationsChanges(t *testing.T) {
+	gens := pstore.NewGenerations(3)
+	ids := pt.GeneratePeerIDs(2)
+
+	gens.Bump(ids[0])
+	gens.Bump(ids[1])
+	if got := gens.Changes(0); len(got) != 2 || got[0].Seq != 1 || got[0].Peer != ids[0] || got[1].Seq != 2 {
+		t.Fatalf("expected both changes, got %v", got)
+	}
+	gens.Remove(ids[0])
+	gens.Bump(ids[1])
+	if gens.ChangeSeq() != 4 {
+		t.Fatalf("expected change 4 to be the latest, got %d", gens.ChangeSeq())
+	}
+	// the log wrapped around, and holds the latest 3 changes.
+	got := gens.Changes(0)
+	if len(got) != 3 || got[0].Seq != 2 || !got[1].Removed || got[2].Seq != 4 {
+		t.Fatalf("expected the latest 3 changes, got %v", got)
+	}
+	if got := gens.Changes(3); len(got) != 1 || got[0].Seq != 4 {
+		t.Fatalf("expected the last change, got %v", got)
+	}
+	if got := gens.Changes(4); len(got) != 0 {
+		t.Fatalf("expected no change, got %v", got)
+	}
+
+	unlogged := pstore.NewGenerations(0)
+	unlogged.Bump(ids[0])
+	if unlogged.ChangeSeq() != 1 || len(unlogged.Changes(0)) != 0 {
+		t.Fatal("expected changes to be numbered but not logged")
+	}
+}
@@ -1,24 +1,32 @@
 module github.com/libp2p/go-libp2p-peerstore
 
 require (
+	github.com/fxamacker/cbor/v2 v2.2.0
 	github.com/gogo/protobuf v1.3.1
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db
+	github.com/google/flatbuffers v1.12.0
 	github.com/hashicorp/golang-lru v0.5.4
+	github.com/ipfs/go-cid v0.0.5
 	github.com/ipfs/go-datastore v0.4.4
 	github.com/ipfs/go-ds-badger v0.2.3
 	github.com/ipfs/go-ds-leveldb v0.4.2
 	github.com/ipfs/go-log v1.0.3
 	github.com/libp2p/go-buffer-pool v0.0.2
 	github.com/libp2p/go-libp2p-core v0.5.4
+	github.com/libp2p/go-libp2p-peerstore/test v0.0.0-00010101000000-000000000000
 	github.com/multiformats/go-base32 v0.0.3
 	github.com/multiformats/go-multiaddr v0.2.1
 	github.com/multiformats/go-multiaddr-fmt v0.1.0
 	github.com/multiformats/go-multiaddr-net v0.1.4
+	github.com/multiformats/go-multibase v0.0.1
 	github.com/multiformats/go-multihash v0.0.13
 	github.com/pkg/errors v0.9.1
-	github.com/stretchr/testify v1.4.0
 	github.com/whyrusleeping/go-keyspace v0.0.0-20160322163242-5b898ac5add1
 	go.uber.org/goleak v1.0.0
-	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5 // indirect
+	go.uber.org/zap v1.10.0
+	golang.org/x/crypto v0.0.0-20190611184440-5c40567a22f8
 )
 
 go 1.13
+
+replace github.com/libp2p/go-libp2p-peerstore/test => ./test
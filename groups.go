@@ -0,0 +1,27 @@
+package peerstore
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrEmptyGroup is returned when adding a peer to, or removing it from, a group with an empty name.
+var ErrEmptyGroup = errors.New("empty group name")
+
+// GroupBook is implemented by peerstores that can assign peers to named groups, for applications that partition
+// peers, e.g. the members of a cluster or of a private swarm, and list the members of a group without visiting every
+// peer.
+//
+// Memberships are configuration rather than something learnt about peers: like protection tags, they are kept by
+// RemovePeer and peer GC, and only dropped by RemoveFromGroup.
+type GroupBook interface {
+	// AddToGroup adds a peer to a group. Adding a peer to a group it is already a member of is a no-op.
+	AddToGroup(p peer.ID, group string) error
+	// RemoveFromGroup removes a peer from a group. Removing a peer from a group it isn't a member of is a no-op.
+	RemoveFromGroup(p peer.ID, group string) error
+	// Groups returns the groups a peer is a member of, sorted.
+	Groups(p peer.ID) []string
+	// GroupMembers returns the members of a group.
+	GroupMembers(group string) peer.IDSlice
+}
@@ -0,0 +1,30 @@
+package peerstore
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// IDValidator checks the peer IDs written to a peerstore, returning an error for those that must be rejected. Address
+// books apply it on every path, reads and clears included, so that the peers they accept can be read back and removed
+// alike; other books only reject empty IDs on reads.
+type IDValidator func(p peer.ID) error
+
+// RelaxedIDs accepts any non-empty peer ID, including those that aren't valid multihashes, such as the shortened IDs
+// used by test networks. It is the default.
+func RelaxedIDs(p peer.ID) error {
+	return p.Validate()
+}
+
+// StrictIDs only accepts peer IDs that are valid multihashes, such as those derived from keys, for production nodes
+// that shouldn't store garbage handed to them by buggy or malicious peers.
+func StrictIDs(p peer.ID) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if _, err := peer.IDFromBytes([]byte(p)); err != nil {
+		return fmt.Errorf("peer ID is not a valid multihash: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package peerstore
+
+// InvariantChecker is implemented by books that can verify the consistency of their internal state, e.g. that reads
+// don't return expired entries, that the indexes they maintain agree with the entries they index, and that their
+// counters match their contents. It is meant for tests: the suites call it after every test, and implementations may
+// take all their locks and scan their entire contents.
+type InvariantChecker interface {
+	// CheckInvariants returns an error describing the first violated invariant, if any. It must not be called
+	// concurrently with writes.
+	CheckInvariants() error
+}
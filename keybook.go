@@ -0,0 +1,48 @@
+package peerstore
+
+import (
+	"fmt"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// KeyDecodeError is returned when a stored key, or a key inlined in a peer ID, cannot be decoded.
+type KeyDecodeError struct {
+	Peer    peer.ID
+	Private bool
+	Err     error
+}
+
+func (e *KeyDecodeError) Error() string {
+	kind := "public"
+	if e.Private {
+		kind = "private"
+	}
+	return fmt.Sprintf("failed to decode %s key for peer %s: %s", kind, e.Peer.Pretty(), e.Err)
+}
+
+func (e *KeyDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// KeyBookE is implemented by key books that can report why a key could not be returned. The error is
+// pstore.ErrNotFound if the key book holds no key for the peer, and a *KeyDecodeError if the key is corrupt.
+type KeyBookE interface {
+	PubKeyE(p peer.ID) (ic.PubKey, error)
+	PrivKeyE(p peer.ID) (ic.PrivKey, error)
+}
+
+// KeyTypeCounter is implemented by key books that can account for the types of the keys they hold.
+type KeyTypeCounter interface {
+	// KeyTypeCounts returns the number of stored public and private keys, by key type. Public keys inlined in
+	// peer IDs are only counted if they have been added explicitly.
+	KeyTypeCounts() KeyTypeCounts
+}
+
+// KeyTypeCounts holds the number of keys of each type held by a key book.
+type KeyTypeCounts struct {
+	PubKeys  map[pb.KeyType]int
+	PrivKeys map[pb.KeyType]int
+}
@@ -0,0 +1,315 @@
+package peerstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyFormat is the encoding of an exported private key.
+type KeyFormat int
+
+const (
+	// KeyFormatProtobuf is the libp2p protobuf encoding of keys, as used by crypto.MarshalPrivateKey, which carries
+	// the key type.
+	KeyFormatProtobuf KeyFormat = iota
+	// KeyFormatPEM is a PEM "PRIVATE KEY" block holding the PKCS #8 encoding of the key, readable by standard tools.
+	// Secp256k1 keys, which PKCS #8 implementations don't support, are held in a "LIBP2P PRIVATE KEY" block in the
+	// protobuf encoding instead. Imports also accept "RSA PRIVATE KEY" and "EC PRIVATE KEY" blocks.
+	KeyFormatPEM
+	// KeyFormatRaw is the raw encoding of the key, as returned by its Raw method, which doesn't carry the key type:
+	// imports tell it by length and structure, taking 64 bytes for an Ed25519 key, 32 bytes for a Secp256k1 key, and
+	// DER for an RSA or ECDSA key.
+	KeyFormatRaw
+)
+
+func (f KeyFormat) String() string {
+	switch f {
+	case KeyFormatProtobuf:
+		return "protobuf"
+	case KeyFormatPEM:
+		return "PEM"
+	case KeyFormatRaw:
+		return "raw"
+	default:
+		return fmt.Sprintf("KeyFormat(%d)", int(f))
+	}
+}
+
+var (
+	// ErrPassphraseRequired is returned when importing a passphrase-protected key without a passphrase.
+	ErrPassphraseRequired = errors.New("the key is protected by a passphrase")
+	// ErrBadPassphrase is returned when a passphrase-protected key cannot be decrypted, because the passphrase is wrong
+	// or the key was altered.
+	ErrBadPassphrase = errors.New("wrong passphrase, or corrupt key")
+)
+
+const (
+	pemPrivKey          = "PRIVATE KEY"
+	pemLibp2pPrivKey    = "LIBP2P PRIVATE KEY"
+	pemEncryptedPrivKey = "ENCRYPTED LIBP2P PRIVATE KEY"
+)
+
+// Passphrase protection: the encoded key is sealed with AES-256-GCM, under a key derived from the passphrase with
+// scrypt, and prefixed with encryptedKeyMagic, the salt and the nonce.
+var encryptedKeyMagic = []byte("p2pkenc\x01")
+
+const (
+	keySaltLen = 16
+	scryptN    = 1 << 15
+	scryptR    = 8
+	scryptP    = 1
+)
+
+// KeyExporter is implemented by key books that back up and restore private keys in standard formats.
+type KeyExporter interface {
+	// ExportPrivKey returns the private key of p encoded in format, protected by passphrase unless it is empty.
+	ExportPrivKey(p peer.ID, format KeyFormat, passphrase []byte) ([]byte, error)
+
+	// ImportPrivKey adds the private key encoded in data, and its public key, for the peer they identify, which it
+	// returns. The passphrase must be the one the key was exported with, if any.
+	ImportPrivKey(data []byte, format KeyFormat, passphrase []byte) (peer.ID, error)
+}
+
+// ExportPrivKey returns the private key of p held by kb, encoded like KeyExporter.ExportPrivKey. It returns
+// pstore.ErrNotFound if kb holds no private key for p.
+func ExportPrivKey(kb pstore.KeyBook, p peer.ID, format KeyFormat, passphrase []byte) ([]byte, error) {
+	var sk ic.PrivKey
+	if kbe, ok := kb.(KeyBookE); ok {
+		var err error
+		if sk, err = kbe.PrivKeyE(p); err != nil {
+			return nil, err
+		}
+	} else if sk = kb.PrivKey(p); sk == nil {
+		return nil, pstore.ErrNotFound
+	}
+	return MarshalPrivKey(sk, format, passphrase)
+}
+
+// ImportPrivKey adds the private key encoded in data, and its public key, to kb, like KeyExporter.ImportPrivKey.
+func ImportPrivKey(kb pstore.KeyBook, data []byte, format KeyFormat, passphrase []byte) (peer.ID, error) {
+	sk, err := UnmarshalPrivKey(data, format, passphrase)
+	if err != nil {
+		return "", err
+	}
+	p, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return "", err
+	}
+	if err := kb.AddPubKey(p, sk.GetPublic()); err != nil {
+		return "", err
+	}
+	if err := kb.AddPrivKey(p, sk); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// MarshalPrivKey encodes sk in format, protected by passphrase unless it is empty.
+func MarshalPrivKey(sk ic.PrivKey, format KeyFormat, passphrase []byte) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch format {
+	case KeyFormatProtobuf:
+		data, err = ic.MarshalPrivateKey(sk)
+	case KeyFormatPEM:
+		data, err = marshalPEMPrivKey(sk)
+	case KeyFormatRaw:
+		data, err = sk.Raw()
+	default:
+		return nil, fmt.Errorf("unknown key format: %s", format)
+	}
+	if err != nil || len(passphrase) == 0 {
+		return data, err
+	}
+
+	sealed, err := sealKey(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if format == KeyFormatPEM {
+		return pem.EncodeToMemory(&pem.Block{Type: pemEncryptedPrivKey, Bytes: sealed}), nil
+	}
+	return sealed, nil
+}
+
+// UnmarshalPrivKey decodes a private key encoded in format by MarshalPrivKey, or by standard tools for PEM.
+func UnmarshalPrivKey(data []byte, format KeyFormat, passphrase []byte) (ic.PrivKey, error) {
+	if format == KeyFormatPEM {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM block found")
+		}
+		if block.Type != pemEncryptedPrivKey {
+			return unmarshalPEMPrivKey(block)
+		}
+		data = block.Bytes
+	}
+
+	if bytes.HasPrefix(data, encryptedKeyMagic) {
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		var err error
+		if data, err = openKey(data, passphrase); err != nil {
+			return nil, err
+		}
+		if format == KeyFormatPEM {
+			// the sealed data is the unprotected PEM encoding.
+			return UnmarshalPrivKey(data, format, nil)
+		}
+	}
+
+	switch format {
+	case KeyFormatProtobuf:
+		return ic.UnmarshalPrivateKey(data)
+	case KeyFormatRaw:
+		return unmarshalRawPrivKey(data)
+	default:
+		return nil, fmt.Errorf("unknown key format: %s", format)
+	}
+}
+
+func marshalPEMPrivKey(sk ic.PrivKey) ([]byte, error) {
+	var std interface{}
+	switch k := sk.(type) {
+	case *ic.Ed25519PrivateKey:
+		raw, err := k.Raw()
+		if err != nil {
+			return nil, err
+		}
+		std = ed25519.PrivateKey(raw)
+	case *ic.RsaPrivateKey, *ic.ECDSAPrivateKey:
+		var err error
+		if std, err = ic.PrivKeyToStdKey(sk); err != nil {
+			return nil, err
+		}
+	default:
+		data, err := ic.MarshalPrivateKey(sk)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemLibp2pPrivKey, Bytes: data}), nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(std)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPrivKey, Bytes: der}), nil
+}
+
+func unmarshalPEMPrivKey(block *pem.Block) (ic.PrivKey, error) {
+	var (
+		std interface{}
+		err error
+	)
+	switch block.Type {
+	case pemLibp2pPrivKey:
+		return ic.UnmarshalPrivateKey(block.Bytes)
+	case pemPrivKey:
+		std, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		std, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		std, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := std.(type) {
+	case ed25519.PrivateKey:
+		return ic.UnmarshalEd25519PrivateKey(k)
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		sk, _, err := ic.KeyPairFromStdKey(k)
+		return sk, err
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", std)
+	}
+}
+
+func unmarshalRawPrivKey(data []byte) (ic.PrivKey, error) {
+	switch len(data) {
+	case ed25519.PrivateKeySize:
+		return ic.UnmarshalEd25519PrivateKey(data)
+	case 32:
+		return ic.UnmarshalSecp256k1PrivateKey(data)
+	}
+	if sk, err := ic.UnmarshalRsaPrivateKey(data); err == nil {
+		return sk, nil
+	}
+	if sk, err := ic.UnmarshalECDSAPrivateKey(data); err == nil {
+		return sk, nil
+	}
+	return nil, fmt.Errorf("unrecognized raw private key of %d bytes", len(data))
+}
+
+func keyCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealKey(data, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, keySaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := keyCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedKeyMagic)+len(salt)+len(nonce)+len(data)+aead.Overhead())
+	out = append(out, encryptedKeyMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	// the header is authenticated along with the key.
+	return aead.Seal(out, nonce, data, out), nil
+}
+
+func openKey(data, passphrase []byte) ([]byte, error) {
+	header := len(encryptedKeyMagic) + keySaltLen
+	if len(data) < header {
+		return nil, ErrBadPassphrase
+	}
+	aead, err := keyCipher(passphrase, data[len(encryptedKeyMagic):header])
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < header+aead.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+	nonce := data[header : header+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[header+aead.NonceSize():], data[:header+aead.NonceSize()])
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	return plain, nil
+}
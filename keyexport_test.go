@@ -0,0 +1,100 @@
+package peerstore
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func TestPrivKeyFormats(t *testing.T) {
+	formats := []KeyFormat{KeyFormatProtobuf, KeyFormatPEM, KeyFormatRaw}
+	for _, typ := range []int{ic.Ed25519, ic.RSA, ic.ECDSA, ic.Secp256k1} {
+		t.Run(pb.KeyType(typ).String(), func(t *testing.T) {
+			sk, _, err := test.RandTestKeyPair(typ, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, format := range formats {
+				for _, passphrase := range [][]byte{nil, []byte("secret")} {
+					data, err := MarshalPrivKey(sk, format, passphrase)
+					if err != nil {
+						t.Fatalf("%s: %s", format, err)
+					}
+					got, err := UnmarshalPrivKey(data, format, passphrase)
+					if err != nil {
+						t.Fatalf("%s: %s", format, err)
+					}
+					if !sk.Equals(got) {
+						t.Fatalf("%s: decoded key doesn't match", format)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPrivKeyPEMInterop(t *testing.T) {
+	// keys of standard types are readable by standard tools.
+	for _, typ := range []int{ic.Ed25519, ic.RSA, ic.ECDSA} {
+		sk, _, err := test.RandTestKeyPair(typ, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := MarshalPrivKey(sk, KeyFormatPEM, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "PRIVATE KEY" {
+			t.Fatalf("expected a PRIVATE KEY block, got %v", block)
+		}
+		if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// and keys written by standard tools are accepted.
+	sk, _, err := test.RandTestKeyPair(ic.RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	std, err := ic.PrivKeyToStdKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(std.(*rsa.PrivateKey))})
+	got, err := UnmarshalPrivKey(data, KeyFormatPEM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sk.Equals(got) {
+		t.Fatal("decoded key doesn't match")
+	}
+}
+
+func TestPrivKeyPassphrase(t *testing.T) {
+	sk, _, err := test.RandTestKeyPair(ic.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalPrivKey(sk, KeyFormatProtobuf, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UnmarshalPrivKey(data, KeyFormatProtobuf, nil); err != ErrPassphraseRequired {
+		t.Fatalf("expected ErrPassphraseRequired, got %v", err)
+	}
+	if _, err := UnmarshalPrivKey(data, KeyFormatProtobuf, []byte("wrong")); err != ErrBadPassphrase {
+		t.Fatalf("expected ErrBadPassphrase, got %v", err)
+	}
+	data[len(encryptedKeyMagic)] ^= 1
+	if _, err := UnmarshalPrivKey(data, KeyFormatProtobuf, []byte("secret")); err != ErrBadPassphrase {
+		t.Fatalf("expected a tampered key to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package peerstore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// OpenMetricsContentType is the content type of the exposition written by LatencyExporter.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// LatencyHistogram is a histogram of latency measurements.
+type LatencyHistogram struct {
+	// Buckets are the upper bounds of the buckets, in increasing order. See LatencyBuckets.
+	Buckets []time.Duration
+	// Counts holds the number of measurements in each bucket, i.e. greater than the previous bound and lower than or
+	// equal to the bound of the bucket. The last count is that of the implicit +Inf bucket.
+	Counts []uint64
+	// Count is the total number of measurements.
+	Count uint64
+	// Sum is the sum of all measurements.
+	Sum time.Duration
+}
+
+func newLatencyHistogram(buckets []time.Duration) *LatencyHistogram {
+	return &LatencyHistogram{Buckets: buckets, Counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *LatencyHistogram) observe(d time.Duration) {
+	i := sort.Search(len(h.Buckets), func(i int) bool { return d <= h.Buckets[i] })
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+func (h *LatencyHistogram) clone() LatencyHistogram {
+	c := *h
+	c.Counts = append([]uint64(nil), h.Counts...)
+	return c
+}
+
+// LatencyDistributions is implemented by Metrics, and by the peerstores using them, that keep histograms of the
+// latency measurements they record.
+type LatencyDistributions interface {
+	// PeerLatencyHistograms returns a snapshot of the latency histogram of every peer with measurements.
+	PeerLatencyHistograms() map[peer.ID]LatencyHistogram
+
+	// LatencyHistogram returns a snapshot of the histogram of all latency measurements, those of removed peers
+	// included.
+	LatencyHistogram() LatencyHistogram
+}
+
+// LatencyExporter renders latency histograms in the OpenMetrics text format: the distribution of all measurements,
+// and the distribution of each peer's.
+//
+// Per-peer histograms add a series per bucket and peer, so their number is bounded to keep the cardinality of the
+// exposition in check: above a threshold of peers, only the peers with the most measurements are exported.
+type LatencyExporter struct {
+	src      LatencyDistributions
+	maxPeers int
+	topK     int
+}
+
+// NewLatencyExporter creates a LatencyExporter for m, which may be a peerstore, and must implement
+// LatencyDistributions. Histograms of all peers are exported as long as there are at most maxPeers of them; beyond,
+// only the histograms of the topK peers with the most measurements are. A zero maxPeers exports all peers, and a
+// zero topK exports none beyond the threshold.
+func NewLatencyExporter(m pstore.Metrics, maxPeers, topK int) (*LatencyExporter, error) {
+	src, ok := m.(LatencyDistributions)
+	if !ok {
+		return nil, fmt.Errorf("metrics do not keep latency histograms")
+	}
+	return &LatencyExporter{src: src, maxPeers: maxPeers, topK: topK}, nil
+}
+
+// WriteTo writes the exposition to w.
+func (e *LatencyExporter) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	peers, omitted := e.selectPeers(e.src.PeerLatencyHistograms())
+
+	writeHistogramHeader(bw, "libp2p_peerstore_latency_seconds", "Latency of all peers.")
+	writeHistogram(bw, "libp2p_peerstore_latency_seconds", "", e.src.LatencyHistogram())
+
+	writeHistogramHeader(bw, "libp2p_peerstore_peer_latency_seconds", "Latency per peer.")
+	for _, ph := range peers {
+		labels := `peer="` + ph.peer.Pretty() + `"`
+		writeHistogram(bw, "libp2p_peerstore_peer_latency_seconds", labels, ph.hist)
+	}
+
+	fmt.Fprintf(bw, "# TYPE libp2p_peerstore_peer_latency_omitted_peers gauge\n")
+	fmt.Fprintf(bw, "# HELP libp2p_peerstore_peer_latency_omitted_peers Peers whose latency histogram was omitted.\n")
+	fmt.Fprintf(bw, "libp2p_peerstore_peer_latency_omitted_peers %d\n", omitted)
+	fmt.Fprintf(bw, "# EOF\n")
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the exposition, e.g. to be scraped by Prometheus.
+func (e *LatencyExporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+	if _, err := e.WriteTo(w); err != nil {
+		log.Debugf("failed to write latency metrics: %s", err)
+	}
+}
+
+type peerHistogram struct {
+	peer peer.ID
+	hist LatencyHistogram
+}
+
+// selectPeers returns the histograms to export, sorted by peer, and the number of peers omitted.
+func (e *LatencyExporter) selectPeers(hists map[peer.ID]LatencyHistogram) ([]peerHistogram, int) {
+	res := make([]peerHistogram, 0, len(hists))
+	for p, h := range hists {
+		res = append(res, peerHistogram{p, h})
+	}
+
+	if e.maxPeers > 0 && len(res) > e.maxPeers {
+		sort.Slice(res, func(i, j int) bool {
+			if res[i].hist.Count != res[j].hist.Count {
+				return res[i].hist.Count > res[j].hist.Count
+			}
+			return res[i].peer < res[j].peer
+		})
+		k := e.topK
+		if k > len(res) {
+			k = len(res)
+		}
+		res = res[:k]
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].peer < res[j].peer })
+	return res, len(hists) - len(res)
+}
+
+func writeHistogramHeader(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	fmt.Fprintf(w, "# UNIT %s seconds\n", name)
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+}
+
+func writeHistogram(w io.Writer, name, labels string, h LatencyHistogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, bound := range h.Buckets {
+		cumulative += h.Counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatSeconds(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.Count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.Count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatSeconds(h.Sum))
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
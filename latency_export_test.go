@@ -0,0 +1,98 @@
+package peerstore_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestLatencyHistograms(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(2)
+	for _, lat := range []time.Duration{time.Millisecond, 20 * time.Millisecond, 20 * time.Second} {
+		ps.RecordLatency(ids[0], lat)
+	}
+	ps.RecordLatency(ids[1], 3*time.Millisecond)
+
+	h := ps.PeerLatencyHistograms()[ids[0]]
+	if h.Count != 3 || h.Sum != 20021*time.Millisecond {
+		t.Fatalf("unexpected histogram count %d and sum %s", h.Count, h.Sum)
+	}
+	// 1ms falls in the first bucket, 20ms in the 25ms one, and 20s in +Inf.
+	if h.Counts[0] != 1 || h.Counts[3] != 1 || h.Counts[len(h.Counts)-1] != 1 {
+		t.Fatalf("unexpected bucket counts %v", h.Counts)
+	}
+
+	ps.RemovePeer(ids[0])
+	if _, ok := ps.PeerLatencyHistograms()[ids[0]]; ok {
+		t.Fatal("expected histogram of removed peer to be dropped")
+	}
+	if agg := ps.LatencyHistogram(); agg.Count != 4 {
+		t.Fatalf("expected aggregate to retain measurements of removed peers, got %d", agg.Count)
+	}
+}
+
+func TestLatencyExporter(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(3)
+	for i, p := range ids {
+		for j := 0; j <= i; j++ {
+			ps.RecordLatency(p, 10*time.Millisecond)
+		}
+	}
+
+	e, err := pstore.NewLatencyExporter(ps, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := e.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != buf.Len() {
+		t.Fatalf("expected %d bytes written to be reported, got %d", buf.Len(), n)
+	}
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE libp2p_peerstore_latency_seconds histogram",
+		`libp2p_peerstore_latency_seconds_bucket{le="0.005"} 0`,
+		`libp2p_peerstore_latency_seconds_bucket{le="0.01"} 6`,
+		`libp2p_peerstore_latency_seconds_bucket{le="+Inf"} 6`,
+		"libp2p_peerstore_latency_seconds_count 6",
+		"libp2p_peerstore_latency_seconds_sum 0.06",
+		// above the threshold, only the peer with the most measurements is exported.
+		`libp2p_peerstore_peer_latency_seconds_count{peer="` + ids[2].Pretty() + `"} 3`,
+		"libp2p_peerstore_peer_latency_omitted_peers 2",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, ids[0].Pretty()) || strings.Contains(out, ids[1].Pretty()) {
+		t.Errorf("expected peers beyond the top-K to be omitted:\n%s", out)
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Error("expected exposition to be terminated by # EOF")
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != pstore.OpenMetricsContentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if rec.Body.String() != out {
+		t.Fatal("expected the same exposition over HTTP")
+	}
+}
@@ -0,0 +1,66 @@
+// Package logadapter adapts common logging libraries to the Logger the peerstore packages log through, see
+// peerstore.SetLogger.
+package logadapter
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// Zap adapts a zap logger, naming the loggers of subsystems with Named.
+func Zap(l *zap.SugaredLogger) peerstore.Logger {
+	return zapLogger{l}
+}
+
+type zapLogger struct {
+	*zap.SugaredLogger
+}
+
+func (z zapLogger) Named(name string) peerstore.Logger {
+	return zapLogger{z.SugaredLogger.Named(name)}
+}
+
+// LogrSink holds the methods of a logr.Logger that Logr uses, so that adapting one doesn't make this package depend on
+// logr.
+type LogrSink interface {
+	Info(msg string, keysAndValues ...interface{})
+	Error(err error, msg string, keysAndValues ...interface{})
+}
+
+// Logr adapts a logr logger l. Debug logs go to debug, typically l.V(1), or are dropped if it is nil; info and
+// warning logs go to l.Info, warnings with a "level" key set to "warn"; error logs go to l.Error, with a nil error.
+// The subsystem logging is passed under the "logger" key.
+func Logr(l, debug LogrSink) peerstore.Logger {
+	return logrLogger{l: l, debug: debug}
+}
+
+type logrLogger struct {
+	l, debug LogrSink
+	name     string
+}
+
+func (lg logrLogger) Named(name string) peerstore.Logger {
+	lg.name = name
+	return lg
+}
+
+func (lg logrLogger) Debugf(format string, args ...interface{}) {
+	if lg.debug != nil {
+		lg.debug.Info(fmt.Sprintf(format, args...), "logger", lg.name)
+	}
+}
+
+func (lg logrLogger) Infof(format string, args ...interface{}) {
+	lg.l.Info(fmt.Sprintf(format, args...), "logger", lg.name)
+}
+
+func (lg logrLogger) Warnf(format string, args ...interface{}) {
+	lg.l.Info(fmt.Sprintf(format, args...), "logger", lg.name, "level", "warn")
+}
+
+func (lg logrLogger) Errorf(format string, args ...interface{}) {
+	lg.l.Error(nil, fmt.Sprintf(format, args...), "logger", lg.name)
+}
@@ -0,0 +1,86 @@
+package logadapter_test
+
+import (
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/logadapter"
+)
+
+func TestZap(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	pstore.SetLogger(logadapter.Zap(zap.New(core).Sugar()))
+	defer pstore.SetLogger(nil)
+
+	// loggers created before SetLogger follow it.
+	log := pstore.SubsystemLogger("peerstore/test")
+	log.Debugf("debug %d", 1)
+	log.Warnf("warn %d", 2)
+
+	entries := logs.AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Level != zapcore.DebugLevel || e.Message != "debug 1" || e.LoggerName != "peerstore/test" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.Level != zapcore.WarnLevel || e.Message != "warn 2" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+
+	// restoring the default stops forwarding.
+	pstore.SetLogger(nil)
+	log.Errorf("error")
+	if n := logs.Len(); n != 2 {
+		t.Fatalf("expected no more entries, got %d", n)
+	}
+}
+
+// logrRecorder records the calls of a logr logger, as "<level> <msg> <keys and values>".
+type logrRecorder struct {
+	level string
+	calls *[]string
+}
+
+func (r logrRecorder) Info(msg string, kv ...interface{}) {
+	*r.calls = append(*r.calls, fmt.Sprint(r.level, " ", msg, " ", kv))
+}
+
+func (r logrRecorder) Error(err error, msg string, kv ...interface{}) {
+	*r.calls = append(*r.calls, fmt.Sprint("error ", msg, " ", kv))
+}
+
+func TestLogr(t *testing.T) {
+	var calls []string
+	pstore.SetLogger(logadapter.Logr(logrRecorder{"info", &calls}, logrRecorder{"debug", &calls}))
+	defer pstore.SetLogger(nil)
+
+	log := pstore.SubsystemLogger("peerstore/test")
+	log.Debugf("a")
+	log.Infof("b")
+	log.Warnf("c")
+	log.Errorf("d")
+
+	exp := []string{
+		"debug a [logger peerstore/test]",
+		"info b [logger peerstore/test]",
+		"info c [logger peerstore/test level warn]",
+		"error d [logger peerstore/test]",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(exp) {
+		t.Fatalf("expected %q, got %q", exp, calls)
+	}
+
+	// without a debug sink, debug logs are dropped.
+	calls = nil
+	pstore.SetLogger(logadapter.Logr(logrRecorder{"info", &calls}, nil))
+	log.Debugf("a")
+	if len(calls) != 0 {
+		t.Fatalf("expected debug logs to be dropped, got %q", calls)
+	}
+}
@@ -0,0 +1,90 @@
+package peerstore
+
+import (
+	"sync/atomic"
+
+	logging "github.com/ipfs/go-log"
+)
+
+var log = SubsystemLogger("peerstore")
+
+// Logger is the leveled logger the peerstore packages log through. Its methods format their arguments like
+// fmt.Sprintf. By default, logs go to go-log, under the name of the subsystem logging; see SetLogger to route them to
+// another logging pipeline. The logadapter package adapts the common logging libraries.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// NamedLogger is implemented by Loggers telling the subsystems of the peerstore apart, such as "peerstore/ds": the
+// logs of a subsystem go to the Logger returned by Named for its name.
+type NamedLogger interface {
+	Logger
+	Named(name string) Logger
+}
+
+// injectedLogger holds the *injected Logger set by SetLogger, if any.
+var injectedLogger atomic.Value
+
+type injected struct {
+	l Logger
+}
+
+// SetLogger routes the logs of all peerstore packages to l from then on, including those of peerstores already
+// created. A nil l restores the default go-log loggers.
+func SetLogger(l Logger) {
+	injectedLogger.Store(&injected{l: l})
+}
+
+// SubsystemLogger returns the Logger of a subsystem of the peerstore, forwarding to the Logger set by SetLogger, or to
+// the go-log logger of the subsystem. It is meant for the peerstore packages, which create theirs on initialization.
+func SubsystemLogger(name string) Logger {
+	return &subsystemLogger{name: name, fallback: logging.Logger(name)}
+}
+
+type subsystemLogger struct {
+	name     string
+	fallback Logger
+
+	// holds the *namedFor the injected Logger last resolved, so that Named isn't called on every log.
+	resolved atomic.Value
+}
+
+type namedFor struct {
+	src *injected
+	l   Logger
+}
+
+func (s *subsystemLogger) logger() Logger {
+	inj, _ := injectedLogger.Load().(*injected)
+	if inj == nil || inj.l == nil {
+		return s.fallback
+	}
+	if r, _ := s.resolved.Load().(*namedFor); r != nil && r.src == inj {
+		return r.l
+	}
+	l := inj.l
+	if nl, ok := l.(NamedLogger); ok {
+		l = nl.Named(s.name)
+	}
+	s.resolved.Store(&namedFor{src: inj, l: l})
+	return l
+}
+
+func (s *subsystemLogger) Debugf(format string, args ...interface{}) {
+	s.logger().Debugf(format, args...)
+}
+
+func (s *subsystemLogger) Infof(format string, args ...interface{}) {
+	s.logger().Infof(format, args...)
+}
+
+func (s *subsystemLogger) Warnf(format string, args ...interface{}) {
+	s.logger().Warnf(format, args...)
+}
+
+func (s *subsystemLogger) Errorf(format string, args ...interface{}) {
+	s.logger().Errorf(format, args...)
+}
@@ -0,0 +1,41 @@
+package peerstore
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// ErrValueTooLarge is returned when putting a metadata value larger than the limit configured on the metadata store.
+var ErrValueTooLarge = errors.New("metadata value too large")
+
+// PeerMetadataBatch is implemented by metadata stores that can look up a key for many peers at once, e.g. with a
+// single datastore query instead of a read per peer.
+type PeerMetadataBatch interface {
+	// GetMany returns the value stored under key for each of the given peers. Peers without a value, or whose value
+	// cannot be read, are absent from the result.
+	GetMany(key string, peers []peer.ID) map[peer.ID]interface{}
+}
+
+// GetMany looks up key for the given peers, in a single batch if md implements PeerMetadataBatch, and peer by peer
+// otherwise.
+func GetMany(md pstore.PeerMetadata, key string, peers []peer.ID) map[peer.ID]interface{} {
+	if b, ok := md.(PeerMetadataBatch); ok {
+		return b.GetMany(key, peers)
+	}
+	res := make(map[peer.ID]interface{}, len(peers))
+	for _, p := range peers {
+		if v, err := md.Get(p, key); err == nil {
+			res[p] = v
+		}
+	}
+	return res
+}
+
+// PeerMetadataSizer is implemented by metadata stores that can report how much space the metadata of a peer takes.
+type PeerMetadataSizer interface {
+	// MetadataSize returns the size of the metadata of a peer, in bytes: the size of keys and encoded values for
+	// persistent stores, and an estimate for in-memory ones.
+	MetadataSize(p peer.ID) int
+}
@@ -0,0 +1,123 @@
+package peerstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// MetadataEvent reports that the value of a metadata key of a peer changed.
+type MetadataEvent struct {
+	Peer peer.ID
+	Key  string
+	// Value is the new value of the key, nil if it was removed.
+	Value interface{}
+	// Removed is set when the key was removed along with the other metadata of the peer.
+	Removed bool
+}
+
+// MetadataWatcher is implemented by metadata stores that report changes of metadata keys, so that subsystems reacting
+// to e.g. agent version or reachability changes don't have to poll.
+type MetadataWatcher interface {
+	// WatchMetadata returns a channel of the changes of key, for all peers, from now on. Putting the value a key
+	// already holds is not a change. The channel is closed when ctx is done; events are buffered until then, so
+	// subscribers should keep up with them or cancel ctx.
+	WatchMetadata(ctx context.Context, key string) <-chan MetadataEvent
+}
+
+// MetadataSubManager dispatches metadata events to the subscriptions of the keys they concern. Metadata stores hold
+// one, and broadcast the changes made to the keys it reports as watched.
+type MetadataSubManager struct {
+	mu   sync.RWMutex
+	subs map[string][]*metadataSub
+}
+
+type metadataSub struct {
+	pubch chan MetadataEvent
+	ctx   context.Context
+}
+
+// NewMetadataSubManager initializes a MetadataSubManager.
+func NewMetadataSubManager() *MetadataSubManager {
+	return &MetadataSubManager{subs: make(map[string][]*metadataSub)}
+}
+
+// Watched returns whether key has subscriptions, so that metadata stores skip detecting changes nobody watches.
+func (mgr *MetadataSubManager) Watched(key string) bool {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	return len(mgr.subs[key]) > 0
+}
+
+// Broadcast sends ev to the subscriptions of its key.
+func (mgr *MetadataSubManager) Broadcast(ev MetadataEvent) {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	for _, sub := range mgr.subs[ev.Key] {
+		select {
+		case sub.pubch <- ev:
+		case <-sub.ctx.Done():
+		}
+	}
+}
+
+// WatchMetadata implements MetadataWatcher.
+func (mgr *MetadataSubManager) WatchMetadata(ctx context.Context, key string) <-chan MetadataEvent {
+	sub := &metadataSub{pubch: make(chan MetadataEvent), ctx: ctx}
+	out := make(chan MetadataEvent)
+
+	mgr.mu.Lock()
+	mgr.subs[key] = append(mgr.subs[key], sub)
+	mgr.mu.Unlock()
+
+	go func() {
+		defer close(out)
+
+		var (
+			buffer []MetadataEvent
+			outch  chan MetadataEvent
+			next   MetadataEvent
+		)
+		for {
+			select {
+			case outch <- next:
+				if len(buffer) > 0 {
+					next, buffer = buffer[0], buffer[1:]
+				} else {
+					outch = nil
+				}
+			case ev := <-sub.pubch:
+				if outch == nil {
+					next, outch = ev, out
+				} else {
+					buffer = append(buffer, ev)
+				}
+			case <-ctx.Done():
+				mgr.remove(key, sub)
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (mgr *MetadataSubManager) remove(key string, s *metadataSub) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	subs := mgr.subs[key]
+	for i, v := range subs {
+		if v == s {
+			subs[i] = subs[len(subs)-1]
+			subs[len(subs)-1] = nil
+			subs = subs[:len(subs)-1]
+			break
+		}
+	}
+	if len(subs) == 0 {
+		delete(mgr.subs, key)
+	} else {
+		mgr.subs[key] = subs
+	}
+}
@@ -0,0 +1,50 @@
+package peerstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestMetadataSubManager(t *testing.T) {
+	mgr := pstore.NewMetadataSubManager()
+	p := pt.GeneratePeerIDs(1)[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := mgr.WatchMetadata(ctx, "key")
+	if !mgr.Watched("key") || mgr.Watched("other") {
+		t.Fatal("expected only the subscribed key to be watched")
+	}
+
+	// events are buffered until read, in order.
+	for i := 0; i < 100; i++ {
+		mgr.Broadcast(pstore.MetadataEvent{Peer: p, Key: "key", Value: i})
+	}
+	mgr.Broadcast(pstore.MetadataEvent{Peer: p, Key: "other", Value: -1})
+	for i := 0; i < 100; i++ {
+		select {
+		case ev := <-events:
+			if ev.Value != i {
+				t.Fatalf("expected event %d, got %v", i, ev.Value)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a metadata event")
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// cancelling the subscription closes the channel and unwatches the key.
+	cancel()
+	for range events {
+	}
+	if mgr.Watched("key") {
+		t.Fatal("expected the key to be unwatched once the subscription is cancelled")
+	}
+}
@@ -1,11 +1,16 @@
 package peerstore
 
 import (
+	"math"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	core "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/libp2p/go-libp2p-peerstore/addr"
 )
 
 // LatencyEWMASmooting governs the decay of the EWMA (the speed
@@ -13,37 +18,182 @@ import (
 // 1 is 100% change, 0 is no change.
 var LatencyEWMASmoothing = 0.1
 
+// LatencyVarianceSmoothing governs the decay of the latency variance, like LatencyEWMASmoothing does for the mean.
+// It defaults to the 1/4 gain TCP uses for RTTVAR.
+var LatencyVarianceSmoothing = 0.25
+
 // Deprecated: use github.com/libp2p/go-libp2p-core/peerstore.Metrics instead.
 type Metrics = core.Metrics
 
+// LatencyBuckets are the upper bounds of the buckets of latency histograms. Changes only apply to metrics created
+// afterwards.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyStats summarizes the latency measurements of a peer.
+type LatencyStats struct {
+	// Mean is the exponentially-weighted moving average of the measurements, as returned by LatencyEWMA.
+	Mean time.Duration
+	// Variance is the smoothed mean deviation of the measurements from Mean, computed like TCP's RTTVAR: it is
+	// expressed in the same unit as the mean, so that a timeout can be derived as Mean + k*Variance.
+	Variance time.Duration
+	// Samples is the number of measurements recorded.
+	Samples int
+}
+
+// LatencyStatistics is implemented by Metrics, and by the peerstores using them, that track the variance of the
+// latency measurements they record.
+type LatencyStatistics interface {
+	// LatencyStats returns the latency statistics of a peer, zero if it has no measurements.
+	LatencyStats(p peer.ID) LatencyStats
+}
+
+// TransportLatencies is implemented by Metrics, and by the peerstores using them, that track the latency of every
+// peer by the transport it was measured over, so that dialers can prefer the transports that have actually been fast
+// for a peer.
+type TransportLatencies interface {
+	// RecordLatencyVia records a latency measurement like RecordLatency, taken over the address a. It also counts
+	// towards the statistics of the transport of a, as named by addr.Transport.
+	RecordLatencyVia(p peer.ID, a ma.Multiaddr, next time.Duration)
+
+	// TransportLatencyStats returns the latency statistics of a peer by transport, nil if it has no measurements
+	// taken over a known address.
+	TransportLatencyStats(p peer.ID) map[string]LatencyStats
+}
+
+// RankAddrsByLatency sorts addrs in place by the mean latency of their transport, as reported by stats, fastest
+// first. Addresses over transports without measurements go last, in their original order, as do addresses sharing a
+// transport.
+func RankAddrsByLatency(addrs []ma.Multiaddr, stats map[string]LatencyStats) {
+	if len(stats) == 0 {
+		return
+	}
+	mean := func(a ma.Multiaddr) (time.Duration, bool) {
+		st, ok := stats[addr.Transport(a)]
+		return st.Mean, ok && st.Samples > 0
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		mi, oki := mean(addrs[i])
+		mj, okj := mean(addrs[j])
+		if oki != okj {
+			return oki
+		}
+		return oki && mi < mj
+	})
+}
+
+type latencyState struct {
+	ewma    time.Duration
+	dev     float64
+	samples int
+}
+
+func newLatencyState(next time.Duration) *latencyState {
+	// when no data, just take it as the mean, and half of it as the deviation, like TCP does.
+	return &latencyState{ewma: next, dev: float64(next) / 2, samples: 1}
+}
+
+// observe folds a measurement into the state, with the mean and deviation smoothing factors s and vs.
+func (st *latencyState) observe(next time.Duration, s, vs float64) {
+	nextf := float64(next)
+	ewmaf := float64(st.ewma)
+	// the deviation is measured against the previous mean.
+	st.dev = ((1.0 - vs) * st.dev) + (vs * math.Abs(ewmaf-nextf))
+	st.ewma = time.Duration(((1.0 - s) * ewmaf) + (s * nextf))
+	st.samples++
+}
+
+func (st *latencyState) stats() LatencyStats {
+	return LatencyStats{Mean: st.ewma, Variance: time.Duration(st.dev), Samples: st.samples}
+}
+
 type metrics struct {
-	latmap map[peer.ID]time.Duration
+	latmap map[peer.ID]*latencyState
 	latmu  sync.RWMutex
+
+	// latency by transport, by peer.
+	transmap map[peer.ID]map[string]*latencyState
+
+	buckets   []time.Duration
+	histmap   map[peer.ID]*LatencyHistogram
+	aggregate *LatencyHistogram
 }
 
+var (
+	_ LatencyDistributions = (*metrics)(nil)
+	_ LatencyStatistics    = (*metrics)(nil)
+	_ TransportLatencies   = (*metrics)(nil)
+)
+
 func NewMetrics() *metrics {
+	buckets := append([]time.Duration(nil), LatencyBuckets...)
 	return &metrics{
-		latmap: make(map[peer.ID]time.Duration),
+		latmap:    make(map[peer.ID]*latencyState),
+		transmap:  make(map[peer.ID]map[string]*latencyState),
+		buckets:   buckets,
+		histmap:   make(map[peer.ID]*LatencyHistogram),
+		aggregate: newLatencyHistogram(buckets),
 	}
 }
 
 // RecordLatency records a new latency measurement
 func (m *metrics) RecordLatency(p peer.ID, next time.Duration) {
-	nextf := float64(next)
+	m.record(p, "", next)
+}
+
+// RecordLatencyVia records a new latency measurement, taken over the address a.
+func (m *metrics) RecordLatencyVia(p peer.ID, a ma.Multiaddr, next time.Duration) {
+	m.record(p, addr.Transport(a), next)
+}
+
+// record records a new latency measurement, also counting it towards transport if not empty.
+func (m *metrics) record(p peer.ID, transport string, next time.Duration) {
 	s := LatencyEWMASmoothing
 	if s > 1 || s < 0 {
 		s = 0.1 // ignore the knob. it's broken. look, it jiggles.
 	}
+	vs := LatencyVarianceSmoothing
+	if vs > 1 || vs < 0 {
+		vs = 0.25
+	}
 
 	m.latmu.Lock()
-	ewma, found := m.latmap[p]
-	ewmaf := float64(ewma)
-	if !found {
-		m.latmap[p] = next // when no data, just take it as the mean.
+	if st, found := m.latmap[p]; !found {
+		m.latmap[p] = newLatencyState(next)
 	} else {
-		nextf = ((1.0 - s) * ewmaf) + (s * nextf)
-		m.latmap[p] = time.Duration(nextf)
+		st.observe(next, s, vs)
+	}
+	if transport != "" {
+		byTransport, found := m.transmap[p]
+		if !found {
+			byTransport = make(map[string]*latencyState)
+			m.transmap[p] = byTransport
+		}
+		if st, found := byTransport[transport]; !found {
+			byTransport[transport] = newLatencyState(next)
+		} else {
+			st.observe(next, s, vs)
+		}
+	}
+	h, found := m.histmap[p]
+	if !found {
+		h = newLatencyHistogram(m.buckets)
+		m.histmap[p] = h
 	}
+	h.observe(next)
+	m.aggregate.observe(next)
 	m.latmu.Unlock()
 }
 
@@ -51,7 +201,62 @@ func (m *metrics) RecordLatency(p peer.ID, next time.Duration) {
 // of all measurements of a peer's latency.
 func (m *metrics) LatencyEWMA(p peer.ID) time.Duration {
 	m.latmu.RLock()
-	lat := m.latmap[p]
-	m.latmu.RUnlock()
-	return time.Duration(lat)
+	defer m.latmu.RUnlock()
+	if st, ok := m.latmap[p]; ok {
+		return st.ewma
+	}
+	return 0
+}
+
+// LatencyStats returns the mean, variance and number of the latency measurements of a peer.
+func (m *metrics) LatencyStats(p peer.ID) LatencyStats {
+	m.latmu.RLock()
+	defer m.latmu.RUnlock()
+	st, ok := m.latmap[p]
+	if !ok {
+		return LatencyStats{}
+	}
+	return st.stats()
+}
+
+// TransportLatencyStats returns the latency statistics of a peer by transport.
+func (m *metrics) TransportLatencyStats(p peer.ID) map[string]LatencyStats {
+	m.latmu.RLock()
+	defer m.latmu.RUnlock()
+	byTransport, ok := m.transmap[p]
+	if !ok {
+		return nil
+	}
+	res := make(map[string]LatencyStats, len(byTransport))
+	for transport, st := range byTransport {
+		res[transport] = st.stats()
+	}
+	return res
+}
+
+// RemovePeer forgets the latency measurements of a peer.
+func (m *metrics) RemovePeer(p peer.ID) {
+	m.latmu.Lock()
+	delete(m.latmap, p)
+	delete(m.transmap, p)
+	delete(m.histmap, p)
+	m.latmu.Unlock()
+}
+
+// PeerLatencyHistograms returns a snapshot of the latency histogram of every peer with measurements.
+func (m *metrics) PeerLatencyHistograms() map[peer.ID]LatencyHistogram {
+	m.latmu.RLock()
+	defer m.latmu.RUnlock()
+	res := make(map[peer.ID]LatencyHistogram, len(m.histmap))
+	for p, h := range m.histmap {
+		res[p] = h.clone()
+	}
+	return res
+}
+
+// LatencyHistogram returns a snapshot of the histogram of all latency measurements, those of removed peers included.
+func (m *metrics) LatencyHistogram() LatencyHistogram {
+	m.latmu.RLock()
+	defer m.latmu.RUnlock()
+	return m.aggregate.clone()
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/test"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 func TestLatencyEWMAFun(t *testing.T) {
@@ -66,3 +67,109 @@ func TestLatencyEWMA(t *testing.T) {
 		t.Fatal("latency outside of expected range: ", exp, lat, sig)
 	}
 }
+
+func TestLatencyStats(t *testing.T) {
+	m := NewMetrics()
+	id, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st := m.LatencyStats(id); st != (LatencyStats{}) {
+		t.Fatalf("expected no stats before any measurement, got %+v", st)
+	}
+
+	check := func(mean, variance time.Duration, samples int) {
+		t.Helper()
+		st := m.LatencyStats(id)
+		if st.Mean != mean || st.Variance != variance || st.Samples != samples {
+			t.Fatalf("expected mean %s, variance %s and %d samples, got %+v", mean, variance, samples, st)
+		}
+		if lat := m.LatencyEWMA(id); lat != st.Mean {
+			t.Fatalf("expected the EWMA to match the mean, got %s", lat)
+		}
+	}
+
+	m.RecordLatency(id, 100*time.Millisecond)
+	check(100*time.Millisecond, 50*time.Millisecond, 1)
+	m.RecordLatency(id, 100*time.Millisecond)
+	check(100*time.Millisecond, 37500*time.Microsecond, 2)
+	m.RecordLatency(id, 200*time.Millisecond)
+	check(110*time.Millisecond, 53125*time.Microsecond, 3)
+
+	m.RemovePeer(id)
+	if st := m.LatencyStats(id); st != (LatencyStats{}) {
+		t.Fatalf("expected no stats after removing the peer, got %+v", st)
+	}
+}
+
+func TestTransportLatency(t *testing.T) {
+	m := NewMetrics()
+	id, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcp := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	quic := ma.StringCast("/ip4/1.2.3.4/udp/4001/quic")
+	ws := ma.StringCast("/ip4/1.2.3.4/tcp/4002/ws")
+
+	m.RecordLatency(id, time.Second)
+	if stats := m.TransportLatencyStats(id); stats != nil {
+		t.Fatalf("expected no transport stats without an address, got %v", stats)
+	}
+
+	m.RecordLatencyVia(id, tcp, 100*time.Millisecond)
+	m.RecordLatencyVia(id, quic, 20*time.Millisecond)
+	m.RecordLatencyVia(id, quic, 20*time.Millisecond)
+	m.RecordLatencyVia(id, nil, 30*time.Millisecond)
+
+	stats := m.TransportLatencyStats(id)
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for 2 transports, got %v", stats)
+	}
+	if st := stats["tcp"]; st.Mean != 100*time.Millisecond || st.Samples != 1 {
+		t.Fatalf("unexpected tcp stats %+v", st)
+	}
+	if st := stats["udp/quic"]; st.Mean != 20*time.Millisecond || st.Samples != 2 {
+		t.Fatalf("unexpected quic stats %+v", st)
+	}
+	// measurements taken over an address also count towards the peer.
+	if st := m.LatencyStats(id); st.Samples != 5 {
+		t.Fatalf("expected 5 samples for the peer, got %+v", st)
+	}
+
+	addrs := []ma.Multiaddr{ws, tcp, quic}
+	RankAddrsByLatency(addrs, stats)
+	if !addrs[0].Equal(quic) || !addrs[1].Equal(tcp) || !addrs[2].Equal(ws) {
+		t.Fatalf("unexpected ranking %v", addrs)
+	}
+
+	m.RemovePeer(id)
+	if stats := m.TransportLatencyStats(id); stats != nil {
+		t.Fatalf("expected no transport stats after removing the peer, got %v", stats)
+	}
+}
+
+func TestRankAddrsByLatency(t *testing.T) {
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	b := ma.StringCast("/ip4/1.2.3.5/tcp/4001")
+	c := ma.StringCast("/ip4/1.2.3.4/tcp/4002/ws")
+	d := ma.StringCast("/ip4/1.2.3.4/udp/4001/quic")
+
+	// without stats, the order is left alone.
+	addrs := []ma.Multiaddr{c, a, d, b}
+	RankAddrsByLatency(addrs, nil)
+	if !addrs[0].Equal(c) || !addrs[1].Equal(a) || !addrs[2].Equal(d) || !addrs[3].Equal(b) {
+		t.Fatalf("unexpected ranking %v", addrs)
+	}
+
+	RankAddrsByLatency(addrs, map[string]LatencyStats{
+		"tcp/ws": {Mean: 50 * time.Millisecond, Samples: 3},
+		"tcp":    {Mean: 10 * time.Millisecond, Samples: 1},
+	})
+	// addresses sharing a transport, and those over unmeasured transports, keep their order.
+	if !addrs[0].Equal(a) || !addrs[1].Equal(b) || !addrs[2].Equal(c) || !addrs[3].Equal(d) {
+		t.Fatalf("unexpected ranking %v", addrs)
+	}
+}
@@ -0,0 +1,40 @@
+package peerstore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrNameTaken is returned when naming a peer with a name already assigned to another peer.
+var ErrNameTaken = errors.New("name already assigned to another peer")
+
+// NameBook is implemented by peerstores that can assign human-readable names to well-known peers, e.g.
+// "bootstrap-eu-1", so that logs, debug endpoints and CLIs show them instead of peer IDs. A peer has at most one name,
+// and a name is assigned to at most one peer.
+//
+// Like group memberships, names are configuration rather than something learnt about peers: they are kept by
+// RemovePeer and peer GC, and only dropped by SetName with an empty name.
+type NameBook interface {
+	// SetName assigns a name to a peer, replacing its previous name, if any. An empty name removes the name of the
+	// peer. It returns ErrNameTaken if the name is assigned to another peer.
+	SetName(p peer.ID, name string) error
+	// Name returns the name of a peer, or an empty string if it has none.
+	Name(p peer.ID) string
+	// PeerByName returns the peer a name is assigned to, if any.
+	PeerByName(name string) (peer.ID, bool)
+	// Names returns the names of all named peers.
+	Names() map[peer.ID]string
+}
+
+// DisplayName returns how a peer is shown to operators: its name followed by its short ID, e.g.
+// "bootstrap-eu-1 (<peer.ID Qm*abcdef>)", if ps implements NameBook and the peer is named, or its full ID otherwise.
+func DisplayName(ps interface{}, p peer.ID) string {
+	if nb, ok := ps.(NameBook); ok {
+		if name := nb.Name(p); name != "" {
+			return fmt.Sprintf("%s (%s)", name, p.ShortString())
+		}
+	}
+	return p.Pretty()
+}
@@ -0,0 +1,146 @@
+package peerstore
+
+import (
+	"encoding/gob"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// NotesKey is the metadata key under which NoteBook stores the notes of a peer.
+const NotesKey = "notes"
+
+func init() {
+	// allow datastore-backed metadata books to persist notes.
+	gob.Register(PeerNotes{})
+}
+
+// Note is a field of PeerNotes: its value, the time it was written at, and whether it was deleted.
+type Note struct {
+	Value string
+	// Time is a Unix timestamp in nanoseconds.
+	Time    int64
+	Deleted bool
+}
+
+// newer returns whether n wins over o. Ties are broken deterministically, deletions first, so that all replicas
+// converge whatever the order of merges.
+func (n Note) newer(o Note) bool {
+	if n.Time != o.Time {
+		return n.Time > o.Time
+	}
+	if n.Deleted != o.Deleted {
+		return n.Deleted
+	}
+	return n.Value > o.Value
+}
+
+// PeerNotes is a last-writer-wins map of free-form fields annotating a peer. Merging two PeerNotes keeps the latest
+// write of every field, so independent writers don't clobber each other's fields, and merges commute.
+type PeerNotes map[string]Note
+
+// Get returns the value of a field, and whether it is set.
+func (pn PeerNotes) Get(field string) (string, bool) {
+	n, ok := pn[field]
+	if !ok || n.Deleted {
+		return "", false
+	}
+	return n.Value, true
+}
+
+// Values returns the fields that are set, along with their values.
+func (pn PeerNotes) Values() map[string]string {
+	vals := make(map[string]string, len(pn))
+	for f, n := range pn {
+		if !n.Deleted {
+			vals[f] = n.Value
+		}
+	}
+	return vals
+}
+
+// Merge merges other into pn, and returns whether pn changed.
+func (pn PeerNotes) Merge(other PeerNotes) (changed bool) {
+	for f, n := range other {
+		if cur, ok := pn[f]; !ok || n.newer(cur) {
+			pn[f] = n
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Clone returns a copy of pn.
+func (pn PeerNotes) Clone() PeerNotes {
+	c := make(PeerNotes, len(pn))
+	for f, n := range pn {
+		c[f] = n
+	}
+	return c
+}
+
+// NoteBook stores PeerNotes in the metadata of a peerstore, under NotesKey. Every write is merged with the stored
+// notes, so subsystems can annotate the same peer concurrently, each with its own fields, and notes received from
+// other replicas can be merged in with Merge.
+//
+// Writes read, merge and write back the stored notes; they are serialized by the NoteBook, which should thus be
+// shared by all writers of a peerstore.
+type NoteBook struct {
+	md    pstore.PeerMetadata
+	clock Clock
+
+	mu sync.Mutex
+}
+
+// NewNoteBook creates a NoteBook backed by md.
+func NewNoteBook(md pstore.PeerMetadata) *NoteBook {
+	return &NoteBook{md: md, clock: RealClock{}}
+}
+
+// Notes returns the notes of a peer, deleted fields included. The result is a copy, and may be modified freely.
+func (nb *NoteBook) Notes(p peer.ID) PeerNotes {
+	return nb.load(p).Clone()
+}
+
+// Get returns the value of a field of the notes of a peer, and whether it is set.
+func (nb *NoteBook) Get(p peer.ID, field string) (string, bool) {
+	return nb.load(p).Get(field)
+}
+
+// Set sets a field of the notes of a peer.
+func (nb *NoteBook) Set(p peer.ID, field, value string) error {
+	return nb.Merge(p, PeerNotes{field: {Value: value, Time: nb.clock.Now().UnixNano()}})
+}
+
+// Delete deletes a field of the notes of a peer. The deletion wins over writes that happened before it, including
+// those merged later.
+func (nb *NoteBook) Delete(p peer.ID, field string) error {
+	return nb.Merge(p, PeerNotes{field: {Time: nb.clock.Now().UnixNano(), Deleted: true}})
+}
+
+// Merge merges notes into the stored notes of a peer.
+func (nb *NoteBook) Merge(p peer.ID, notes PeerNotes) error {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+
+	// the stored value may be shared with the metadata book, so it's never modified in place.
+	stored := nb.load(p).Clone()
+	if !stored.Merge(notes) {
+		return nil
+	}
+	return nb.md.Put(p, NotesKey, stored)
+}
+
+func (nb *NoteBook) load(p peer.ID) PeerNotes {
+	v, err := nb.md.Get(p, NotesKey)
+	if err != nil {
+		return PeerNotes{}
+	}
+	notes, ok := v.(PeerNotes)
+	if !ok {
+		log.Debugf("unexpected type %T under metadata key %s for peer %s", v, NotesKey, p.Pretty())
+		return PeerNotes{}
+	}
+	return notes
+}
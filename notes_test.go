@@ -0,0 +1,117 @@
+package peerstore_test
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	core "github.com/libp2p/go-libp2p-core/peerstore"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestPeerNotesMerge(t *testing.T) {
+	a := pstore.PeerNotes{
+		"x": {Value: "a", Time: 1},
+		"y": {Value: "a", Time: 3},
+	}
+	b := pstore.PeerNotes{
+		"x": {Value: "b", Time: 2},
+		"y": {Time: 2, Deleted: true},
+		"z": {Value: "b", Time: 1},
+	}
+
+	ab, ba := a.Clone(), b.Clone()
+	if !ab.Merge(b) || !ba.Merge(a) {
+		t.Fatal("expected merges to change notes")
+	}
+	want := map[string]string{"x": "b", "y": "a", "z": "b"}
+	for _, merged := range []pstore.PeerNotes{ab, ba} {
+		if vals := merged.Values(); len(vals) != len(want) || vals["x"] != want["x"] || vals["y"] != want["y"] || vals["z"] != want["z"] {
+			t.Fatalf("expected %v, got %v", want, vals)
+		}
+	}
+	if ab.Merge(b) {
+		t.Fatal("expected merging again to be a no-op")
+	}
+
+	// concurrent writes at the same time converge, deletions first.
+	c := pstore.PeerNotes{"x": {Value: "c", Time: 2}}
+	d := pstore.PeerNotes{"x": {Time: 2, Deleted: true}}
+	cd, dc := c.Clone(), d.Clone()
+	cd.Merge(d)
+	dc.Merge(c)
+	if _, ok := cd.Get("x"); ok {
+		t.Fatal("expected deletion to win a tie")
+	}
+	if _, ok := dc.Get("x"); ok {
+		t.Fatal("expected deletion to win a tie")
+	}
+}
+
+func TestNoteBook(t *testing.T) {
+	dstore, err := pstoreds.NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, ps := range map[string]core.Peerstore{"Memory": pstoremem.NewPeerstore(), "Datastore": dstore} {
+		t.Run(name, func(t *testing.T) {
+			defer ps.Close()
+			testNoteBook(t, ps)
+		})
+	}
+}
+
+func testNoteBook(t *testing.T, ps core.Peerstore) {
+	p := pt.GeneratePeerIDs(1)[0]
+	nb := pstore.NewNoteBook(ps)
+
+	if _, ok := nb.Get(p, "role"); ok {
+		t.Fatal("expected no notes for unknown peer")
+	}
+
+	// subsystems writing their own fields don't clobber each other.
+	if err := nb.Set(p, "role", "relay"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pstore.NewNoteBook(ps).Set(p, "region", "eu"); err != nil {
+		t.Fatal(err)
+	}
+	assertNotes(t, nb, p, map[string]string{"role": "relay", "region": "eu"})
+
+	if err := nb.Delete(p, "role"); err != nil {
+		t.Fatal(err)
+	}
+	assertNotes(t, nb, p, map[string]string{"region": "eu"})
+
+	// stale notes from another replica lose, newer ones win.
+	if err := nb.Merge(p, pstore.PeerNotes{
+		"role":   {Value: "stale", Time: 1},
+		"region": {Value: "us", Time: 1 << 62},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertNotes(t, nb, p, map[string]string{"region": "us"})
+
+	// returned notes are copies.
+	nb.Notes(p)["region"] = pstore.Note{Value: "modified", Time: 1 << 62}
+	assertNotes(t, nb, p, map[string]string{"region": "us"})
+}
+
+func assertNotes(t *testing.T, nb *pstore.NoteBook, p peer.ID, want map[string]string) {
+	t.Helper()
+	got := nb.Notes(p).Values()
+	if len(got) != len(want) {
+		t.Fatalf("expected notes %v, got %v", want, got)
+	}
+	for f, v := range want {
+		if got[f] != v {
+			t.Fatalf("expected notes %v, got %v", want, got)
+		}
+	}
+}
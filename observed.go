@@ -0,0 +1,202 @@
+package peerstore
+
+import (
+	"encoding/gob"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ObservedAddrsKey is the metadata key under which ObservedAddrBook stores the observations of the addresses of the
+// local peer.
+const ObservedAddrsKey = "observed-addrs"
+
+// observationFloor is the decayed count below which an observation is forgotten.
+const observationFloor = 0.05
+
+func init() {
+	// allow datastore-backed metadata books to persist observations.
+	gob.Register(ObservedAddrs{})
+}
+
+// ObservedAddr is an address of the local peer as observed by remote peers, as stored by ObservedAddrBook.
+type ObservedAddr struct {
+	// Addr is the binary representation of the observed multiaddr.
+	Addr []byte
+	// Count is the number of observations, decayed as of Updated.
+	Count float64
+	// Updated is a Unix timestamp in nanoseconds of the last observation, or of the last decay of Count.
+	Updated int64
+}
+
+// ObservedAddrs is the set of observed addresses of the local peer, as stored by ObservedAddrBook.
+type ObservedAddrs struct {
+	Addrs []ObservedAddr
+}
+
+// AddrObservation is an observed address along with its decayed observation count.
+type AddrObservation struct {
+	Addr  ma.Multiaddr
+	Count float64
+}
+
+// ObservedAddrOption configures an ObservedAddrBook.
+type ObservedAddrOption func(*ObservedAddrBook)
+
+// WithObservationHalfLife sets the time after which the observation count of an address is halved. Defaults to one
+// hour.
+func WithObservationHalfLife(d time.Duration) ObservedAddrOption {
+	return func(ob *ObservedAddrBook) {
+		ob.halfLife = d
+	}
+}
+
+// WithObservationLimit sets the number of addresses kept, the least observed ones being dropped first. Defaults to 32.
+func WithObservationLimit(n int) ObservedAddrOption {
+	return func(ob *ObservedAddrBook) {
+		ob.limit = n
+	}
+}
+
+// WithObservationClock sets the source of time of an ObservedAddrBook. Defaults to the system clock.
+func WithObservationClock(c Clock) ObservedAddrOption {
+	return func(ob *ObservedAddrBook) {
+		ob.clock = c
+	}
+}
+
+// ObservedAddrBook counts the observations of the addresses of the local peer reported by remote peers, e.g. through
+// identify, in the metadata of the local peer under ObservedAddrsKey. Counts decay exponentially over time, so that
+// stale addresses fade away, and persist along with the peerstore, so that decisions built on them, such as whether
+// to look for relays, survive restarts instead of starting from zero on every boot. Addresses whose count decays
+// below a twentieth of an observation are forgotten.
+//
+// The local peer is never collected by peer GC, as long as the peerstore holds its private key.
+//
+// Writes are serialized by the ObservedAddrBook, which should thus be shared by all writers of a peerstore.
+type ObservedAddrBook struct {
+	md       pstore.PeerMetadata
+	self     peer.ID
+	clock    Clock
+	halfLife time.Duration
+	limit    int
+
+	mu sync.Mutex
+}
+
+// NewObservedAddrBook creates an ObservedAddrBook for the local peer self, backed by md.
+func NewObservedAddrBook(md pstore.PeerMetadata, self peer.ID, opts ...ObservedAddrOption) *ObservedAddrBook {
+	ob := &ObservedAddrBook{
+		md:       md,
+		self:     self,
+		clock:    RealClock{},
+		halfLife: time.Hour,
+		limit:    32,
+	}
+	for _, opt := range opts {
+		opt(ob)
+	}
+	return ob
+}
+
+// Observe records an observation of an address of the local peer, and returns its resulting count.
+func (ob *ObservedAddrBook) Observe(a ma.Multiaddr) (float64, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	now := ob.clock.Now()
+	addrs := ob.load(now)
+	b := a.Bytes()
+	i := 0
+	for ; i < len(addrs); i++ {
+		if string(addrs[i].Addr) == string(b) {
+			break
+		}
+	}
+	if i == len(addrs) {
+		// make room for the new address by evicting the least observed ones.
+		sort.SliceStable(addrs, func(i, j int) bool { return addrs[i].Count > addrs[j].Count })
+		if ob.limit > 0 && len(addrs) >= ob.limit {
+			addrs = addrs[:ob.limit-1]
+		}
+		i = len(addrs)
+		addrs = append(addrs, ObservedAddr{Addr: b})
+	}
+	addrs[i].Count++
+	addrs[i].Updated = now.UnixNano()
+	count := addrs[i].Count
+
+	if err := ob.md.Put(ob.self, ObservedAddrsKey, ObservedAddrs{Addrs: addrs}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Count returns the decayed observation count of an address, zero if it wasn't observed or was forgotten.
+func (ob *ObservedAddrBook) Count(a ma.Multiaddr) float64 {
+	b := a.Bytes()
+	for _, o := range ob.load(ob.clock.Now()) {
+		if string(o.Addr) == string(b) {
+			return o.Count
+		}
+	}
+	return 0
+}
+
+// Observations returns the observed addresses of the local peer with their decayed counts, the most observed first.
+func (ob *ObservedAddrBook) Observations() []AddrObservation {
+	addrs := ob.load(ob.clock.Now())
+	res := make([]AddrObservation, 0, len(addrs))
+	for _, o := range addrs {
+		a, err := ma.NewMultiaddrBytes(o.Addr)
+		if err != nil {
+			log.Debugf("skipping undecodable observed address: %s", err)
+			continue
+		}
+		res = append(res, AddrObservation{Addr: a, Count: o.Count})
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Count > res[j].Count })
+	return res
+}
+
+// Addrs returns the addresses of the local peer observed at least min times, once decayed, the most observed first.
+func (ob *ObservedAddrBook) Addrs(min float64) []ma.Multiaddr {
+	var res []ma.Multiaddr
+	for _, o := range ob.Observations() {
+		if o.Count >= min {
+			res = append(res, o.Addr)
+		}
+	}
+	return res
+}
+
+// load returns the stored observations decayed as of now, dropping the forgotten ones.
+func (ob *ObservedAddrBook) load(now time.Time) []ObservedAddr {
+	v, err := ob.md.Get(ob.self, ObservedAddrsKey)
+	if err != nil {
+		return nil
+	}
+	stored, ok := v.(ObservedAddrs)
+	if !ok {
+		log.Debugf("unexpected type %T under metadata key %s for peer %s", v, ObservedAddrsKey, ob.self.Pretty())
+		return nil
+	}
+
+	addrs := make([]ObservedAddr, 0, len(stored.Addrs))
+	for _, o := range stored.Addrs {
+		if elapsed := now.Sub(time.Unix(0, o.Updated)); elapsed > 0 && ob.halfLife > 0 {
+			o.Count *= math.Exp2(-float64(elapsed) / float64(ob.halfLife))
+			o.Updated = now.UnixNano()
+		}
+		if o.Count < observationFloor {
+			continue
+		}
+		addrs = append(addrs, o)
+	}
+	return addrs
+}
@@ -0,0 +1,90 @@
+package peerstore_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestObservedAddrBook(t *testing.T) {
+	clock := pt.NewMockClock()
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+	self := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(3)
+	ob := pstore.NewObservedAddrBook(ps, self,
+		pstore.WithObservationHalfLife(time.Hour),
+		pstore.WithObservationLimit(2),
+		pstore.WithObservationClock(clock))
+
+	observe := func(i, n int) {
+		t.Helper()
+		for j := 0; j < n; j++ {
+			if _, err := ob.Observe(addrs[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	observe(0, 4)
+	observe(1, 2)
+
+	// counts halve every half-life.
+	clock.Add(time.Hour)
+	if c := ob.Count(addrs[0]); math.Abs(c-2) > 1e-9 {
+		t.Fatalf("expected a decayed count of 2, got %f", c)
+	}
+	if got := ob.Addrs(1.5); len(got) != 1 || !got[0].Equal(addrs[0]) {
+		t.Fatalf("expected only the most observed address, got %v", got)
+	}
+
+	// the least observed address is dropped once over the limit.
+	observe(2, 3)
+	obs := ob.Observations()
+	if len(obs) != 2 || !obs[0].Addr.Equal(addrs[2]) || !obs[1].Addr.Equal(addrs[0]) {
+		t.Fatalf("expected the two most observed addresses, got %v", obs)
+	}
+	if c := ob.Count(addrs[1]); c != 0 {
+		t.Fatalf("expected dropped address to have no count, got %f", c)
+	}
+
+	// stale observations are forgotten.
+	clock.Add(10 * time.Hour)
+	if obs := ob.Observations(); len(obs) != 0 {
+		t.Fatalf("expected observations to be forgotten, got %v", obs)
+	}
+}
+
+func TestObservedAddrBookPersistence(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	self := pt.GeneratePeerIDs(1)[0]
+	a := pt.GenerateAddrs(1)[0]
+
+	ps, err := pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := pstore.NewObservedAddrBook(ps, self).Observe(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ps.Close()
+
+	ps, err = pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	if got := pstore.NewObservedAddrBook(ps, self).Addrs(2); len(got) != 1 || !got[0].Equal(a) {
+		t.Fatalf("expected observations to persist, got %v", got)
+	}
+}
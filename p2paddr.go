@@ -0,0 +1,69 @@
+package peerstore
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrP2PAddrMismatch is returned when addresses ending with the /p2p component of another peer are rejected.
+var ErrP2PAddrMismatch = errors.New("address names another peer")
+
+// P2PAddrPolicy decides how address books handle the addresses ending with a /p2p/<id> component (or its legacy
+// /ipfs/<id> form) being added or set. Inner components, such as the relay of a circuit address, are left alone.
+type P2PAddrPolicy int
+
+const (
+	// P2PAddrKeep stores addresses as they are, whichever peer they name. This is the default.
+	P2PAddrKeep P2PAddrPolicy = iota
+
+	// P2PAddrStrip strips the component when it names the peer the address is added for, and rejects the address
+	// when it names another peer.
+	P2PAddrStrip
+
+	// P2PAddrReject rejects the addresses naming another peer, and stores the others as they are.
+	P2PAddrReject
+)
+
+// Apply returns the address to store for peer p, or ErrP2PAddrMismatch if the address must be rejected.
+func (pol P2PAddrPolicy) Apply(p peer.ID, addr ma.Multiaddr) (ma.Multiaddr, error) {
+	if pol == P2PAddrKeep {
+		return addr, nil
+	}
+	rest, last := ma.SplitLast(addr)
+	if last == nil || last.Protocol().Code != ma.P_P2P {
+		return addr, nil
+	}
+	id, err := peer.IDFromBytes(last.RawValue())
+	if err != nil || id != p {
+		return nil, ErrP2PAddrMismatch
+	}
+	// keep bare /p2p addresses, rather than leaving nothing.
+	if pol == P2PAddrStrip && rest != nil {
+		return rest, nil
+	}
+	return addr, nil
+}
+
+// ApplyAll applies the policy to addrs, returning the addresses to store, and ErrP2PAddrMismatch if any was
+// rejected.
+func (pol P2PAddrPolicy) ApplyAll(p peer.ID, addrs []ma.Multiaddr) ([]ma.Multiaddr, error) {
+	if pol == P2PAddrKeep {
+		return addrs, nil
+	}
+	var err error
+	res := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		if a == nil {
+			continue
+		}
+		na, aerr := pol.Apply(p, a)
+		if aerr != nil {
+			err = aerr
+			continue
+		}
+		res = append(res, na)
+	}
+	return res, err
+}
@@ -0,0 +1,93 @@
+package pstore_pb
+
+import (
+	"errors"
+	"fmt"
+
+	proto "github.com/gogo/protobuf/proto"
+)
+
+var errTruncated = errors.New("truncated protobuf record")
+
+// ForEachAddrEntry iterates over the address entries of a serialized AddrBookRecord, in storage order, without
+// decoding the rest of the record. Iteration stops early if fn returns false.
+//
+// The addr slice passed to fn aliases data; callers that retain it must copy it.
+func ForEachAddrEntry(data []byte, fn func(addr []byte, expiry int64, ttl int64) bool) error {
+	for len(data) > 0 {
+		field, wire, payload, rest, err := nextField(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		if field != 2 || wire != proto.WireBytes {
+			continue
+		}
+
+		var (
+			addr        []byte
+			expiry, ttl int64
+		)
+		for len(payload) > 0 {
+			efield, ewire, evalue, erest, err := nextField(payload)
+			if err != nil {
+				return err
+			}
+			payload = erest
+			switch {
+			case efield == 1 && ewire == proto.WireBytes:
+				addr = evalue
+			case efield == 2 && ewire == proto.WireVarint:
+				expiry = decodeInt64(evalue)
+			case efield == 3 && ewire == proto.WireVarint:
+				ttl = decodeInt64(evalue)
+			}
+		}
+		if !fn(addr, expiry, ttl) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// nextField reads the field at the head of buf. For length-delimited fields, value holds the payload; for varints,
+// it holds the varint bytes. Fixed-width fields are skipped over and returned raw.
+func nextField(buf []byte) (field int32, wire int, value []byte, rest []byte, err error) {
+	key, n := proto.DecodeVarint(buf)
+	if n == 0 {
+		return 0, 0, nil, nil, errTruncated
+	}
+	field, wire, buf = int32(key>>3), int(key&0x7), buf[n:]
+	if field <= 0 {
+		return 0, 0, nil, nil, fmt.Errorf("illegal field number %d", field)
+	}
+
+	switch wire {
+	case proto.WireVarint:
+		_, n = proto.DecodeVarint(buf)
+		if n == 0 {
+			return 0, 0, nil, nil, errTruncated
+		}
+	case proto.WireBytes:
+		l, ln := proto.DecodeVarint(buf)
+		if ln == 0 || uint64(len(buf)-ln) < l {
+			return 0, 0, nil, nil, errTruncated
+		}
+		return field, wire, buf[ln : ln+int(l)], buf[ln+int(l):], nil
+	case proto.WireFixed64:
+		n = 8
+	case proto.WireFixed32:
+		n = 4
+	default:
+		return 0, 0, nil, nil, fmt.Errorf("unsupported wire type %d", wire)
+	}
+	if len(buf) < n {
+		return 0, 0, nil, nil, errTruncated
+	}
+	return field, wire, buf[:n], buf[n:], nil
+}
+
+func decodeInt64(varint []byte) int64 {
+	x, _ := proto.DecodeVarint(varint)
+	return int64(x)
+}
@@ -0,0 +1,50 @@
+package pstore_pb
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestForEachAddrEntry(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < 100; i++ {
+		rec := NewPopulatedAddrBookRecord(r, false)
+		data, err := rec.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var seen int
+		err = ForEachAddrEntry(data, func(addr []byte, expiry int64, ttl int64) bool {
+			want := rec.Addrs[seen]
+			if string(addr) != string(want.Addr.Bytes()) || expiry != want.Expiry || ttl != want.Ttl {
+				t.Fatalf("entry %d mismatch", seen)
+			}
+			seen++
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen != len(rec.Addrs) {
+			t.Fatalf("expected %d entries, got %d", len(rec.Addrs), seen)
+		}
+	}
+}
+
+func TestForEachAddrEntryTruncated(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	rec := NewPopulatedAddrBookRecord(r, false)
+	for len(rec.Addrs) == 0 {
+		rec = NewPopulatedAddrBookRecord(r, false)
+	}
+	data, err := rec.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ForEachAddrEntry(data[:len(data)-1], func([]byte, int64, int64) bool { return true })
+	if err == nil {
+		t.Fatal("expected an error for a truncated record")
+	}
+}
@@ -87,6 +87,10 @@ type AddrBookRecord_AddrEntry struct {
 	Expiry int64 `protobuf:"varint,2,opt,name=expiry,proto3" json:"expiry,omitempty"`
 	// The original TTL of this address.
 	Ttl int64 `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	// The point in time when this address was last added or confirmed.
+	LastSeen int64 `protobuf:"varint,4,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	// The position of this address in the order the addresses of the peer were added.
+	Added uint64 `protobuf:"varint,5,opt,name=added,proto3" json:"added,omitempty"`
 }
 
 func (m *AddrBookRecord_AddrEntry) Reset()         { *m = AddrBookRecord_AddrEntry{} }
@@ -136,6 +140,20 @@ func (m *AddrBookRecord_AddrEntry) GetTtl() int64 {
 	return 0
 }
 
+func (m *AddrBookRecord_AddrEntry) GetLastSeen() int64 {
+	if m != nil {
+		return m.LastSeen
+	}
+	return 0
+}
+
+func (m *AddrBookRecord_AddrEntry) GetAdded() uint64 {
+	if m != nil {
+		return m.Added
+	}
+	return 0
+}
+
 // CertifiedRecord contains a serialized signed PeerRecord used to
 // populate the signedAddrs list.
 type AddrBookRecord_CertifiedRecord struct {
@@ -201,28 +219,30 @@ func init() {
 func init() { proto.RegisterFile("pstore.proto", fileDescriptor_f96873690e08a98f) }
 
 var fileDescriptor_f96873690e08a98f = []byte{
-	// 322 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x29, 0x28, 0x2e, 0xc9,
-	0x2f, 0x4a, 0xd5, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x84, 0xf1, 0x92, 0xa4, 0x74, 0xd3,
-	0x33, 0x4b, 0x32, 0x4a, 0x93, 0xf4, 0x92, 0xf3, 0x73, 0xf5, 0xd3, 0xf3, 0xd3, 0xf3, 0xf5, 0xc1,
-	0x2a, 0x92, 0x4a, 0xd3, 0xc0, 0x3c, 0x30, 0x07, 0xcc, 0x82, 0xe8, 0x54, 0xba, 0xcc, 0xc4, 0xc5,
-	0xe7, 0x98, 0x92, 0x52, 0xe4, 0x94, 0x9f, 0x9f, 0x1d, 0x94, 0x9a, 0x9c, 0x5f, 0x94, 0x22, 0x24,
-	0xcf, 0xc5, 0x94, 0x99, 0x22, 0xc1, 0xa8, 0xc0, 0xa8, 0xc1, 0xe3, 0xc4, 0x7f, 0xeb, 0x9e, 0x3c,
-	0x77, 0x00, 0x48, 0x65, 0x40, 0x6a, 0x6a, 0x91, 0xa7, 0x4b, 0x10, 0x53, 0x66, 0x8a, 0x90, 0x25,
-	0x17, 0x6b, 0x62, 0x4a, 0x4a, 0x51, 0xb1, 0x04, 0x93, 0x02, 0xb3, 0x06, 0xb7, 0x91, 0xb2, 0x1e,
-	0xdc, 0x76, 0x3d, 0x54, 0xa3, 0xc0, 0x5c, 0xd7, 0xbc, 0x92, 0xa2, 0xca, 0x20, 0x88, 0x0e, 0xa1,
-	0x10, 0x2e, 0x81, 0xe4, 0xd4, 0xa2, 0x92, 0xcc, 0xb4, 0xcc, 0xd4, 0x94, 0xf8, 0x22, 0xb0, 0x22,
-	0x09, 0x66, 0x05, 0x46, 0x0d, 0x6e, 0x23, 0x4d, 0xdc, 0xa6, 0x38, 0xc3, 0x74, 0x40, 0xf8, 0x41,
-	0xfc, 0xc9, 0xa8, 0x02, 0x52, 0x11, 0x5c, 0x9c, 0x70, 0x9b, 0x84, 0x14, 0xb9, 0x58, 0x40, 0x76,
-	0x41, 0x3d, 0xc0, 0x7b, 0xeb, 0x9e, 0x3c, 0x27, 0xd8, 0x03, 0x20, 0x15, 0x41, 0x60, 0x29, 0x21,
-	0x31, 0x2e, 0xb6, 0xd4, 0x8a, 0x82, 0xcc, 0xa2, 0x4a, 0x09, 0x26, 0x05, 0x46, 0x0d, 0xe6, 0x20,
-	0x28, 0x4f, 0x48, 0x80, 0x8b, 0xb9, 0xa4, 0x24, 0x07, 0xec, 0x20, 0xe6, 0x20, 0x10, 0x53, 0xca,
-	0x94, 0x8b, 0x1f, 0xcd, 0x76, 0x90, 0xa2, 0xe2, 0xd4, 0x42, 0xb0, 0xf1, 0x2c, 0x41, 0x20, 0x26,
-	0x48, 0xa4, 0x28, 0xb1, 0x1c, 0x6c, 0x16, 0x4f, 0x10, 0x88, 0xe9, 0xa4, 0xf0, 0xe3, 0xa1, 0x1c,
-	0xe3, 0x81, 0x47, 0x72, 0x8c, 0x27, 0x1e, 0xc9, 0x31, 0x5e, 0x78, 0x24, 0xc7, 0xf8, 0xe0, 0x91,
-	0x1c, 0xe3, 0x84, 0xc7, 0x72, 0x0c, 0x17, 0x1e, 0xcb, 0x31, 0xdc, 0x78, 0x2c, 0xc7, 0x90, 0xc4,
-	0x06, 0x0e, 0x7e, 0x63, 0x40, 0x00, 0x00, 0x00, 0xff, 0xff, 0x29, 0xcd, 0xe8, 0xd4, 0xc8, 0x01,
-	0x00, 0x00,
+	// 353 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x91, 0xc1, 0x4e, 0xea, 0x40,
+	0x14, 0x86, 0x99, 0xb6, 0x90, 0xdb, 0x81, 0x7b, 0x21, 0x93, 0x9b, 0x9b, 0x86, 0x9b, 0x0c, 0x55,
+	0x37, 0x75, 0x61, 0x49, 0x30, 0x2e, 0x5c, 0x8a, 0xba, 0x70, 0x47, 0x46, 0xf7, 0x84, 0x76, 0x06,
+	0x6c, 0x44, 0x06, 0xa7, 0x43, 0x94, 0x27, 0x70, 0xe1, 0xc6, 0x47, 0x72, 0xe9, 0x92, 0xa5, 0x61,
+	0x41, 0xb4, 0xbc, 0x84, 0x4b, 0x33, 0xa7, 0x40, 0x82, 0x89, 0xbb, 0xf3, 0xfd, 0xfd, 0xcf, 0xf9,
+	0xcf, 0xe9, 0xe0, 0xca, 0x38, 0xd5, 0x52, 0x89, 0x70, 0xac, 0xa4, 0x96, 0xc4, 0x5d, 0x53, 0x54,
+	0x3f, 0x18, 0x24, 0xfa, 0x7a, 0x12, 0x85, 0xb1, 0xbc, 0x6d, 0x0e, 0xe4, 0x40, 0x36, 0xc1, 0x11,
+	0x4d, 0xfa, 0x40, 0x00, 0x50, 0xe5, 0x9d, 0xbb, 0x8f, 0x36, 0xfe, 0x73, 0xc2, 0xb9, 0x6a, 0x4b,
+	0x79, 0xc3, 0x44, 0x2c, 0x15, 0x27, 0x0d, 0x6c, 0x25, 0xdc, 0x43, 0x3e, 0x0a, 0x2a, 0xed, 0xea,
+	0x7c, 0xd1, 0x28, 0x77, 0x8c, 0xb3, 0x23, 0x84, 0xba, 0x38, 0x63, 0x56, 0xc2, 0xc9, 0x31, 0x2e,
+	0xf6, 0x38, 0x57, 0xa9, 0x67, 0xf9, 0x76, 0x50, 0x6e, 0xed, 0x85, 0x9b, 0xf4, 0x70, 0x7b, 0x14,
+	0xe0, 0xf9, 0x48, 0xab, 0x29, 0xcb, 0x3b, 0xc8, 0x15, 0xae, 0xc5, 0x42, 0xe9, 0xa4, 0x9f, 0x08,
+	0xde, 0x55, 0x60, 0xf2, 0x6c, 0x1f, 0x05, 0xe5, 0xd6, 0xfe, 0xcf, 0x53, 0x4e, 0xd7, 0x1d, 0x39,
+	0xb3, 0x6a, 0xbc, 0x2d, 0xd4, 0x9f, 0x10, 0x76, 0x37, 0x51, 0x64, 0x07, 0x3b, 0x26, 0x6c, 0x75,
+	0xc1, 0xef, 0xf9, 0xa2, 0xe1, 0xc2, 0x05, 0xc6, 0xc1, 0xe0, 0x13, 0xf9, 0x87, 0x4b, 0xe2, 0x61,
+	0x9c, 0xa8, 0xa9, 0x67, 0xf9, 0x28, 0xb0, 0xd9, 0x8a, 0x48, 0x0d, 0xdb, 0x5a, 0x0f, 0x61, 0x23,
+	0x9b, 0x99, 0x92, 0xfc, 0xc7, 0xee, 0xb0, 0x97, 0xea, 0x6e, 0x2a, 0xc4, 0xc8, 0x73, 0x40, 0xff,
+	0x65, 0x84, 0x4b, 0x21, 0x46, 0xe4, 0x2f, 0xfc, 0x08, 0xc1, 0xbd, 0xa2, 0x8f, 0x02, 0x87, 0xe5,
+	0x50, 0x3f, 0xc2, 0xd5, 0x6f, 0x1b, 0x9b, 0xb9, 0xa9, 0xb8, 0x83, 0x8d, 0x1c, 0x66, 0x4a, 0xa3,
+	0xa8, 0xde, 0x3d, 0xc4, 0x57, 0x98, 0x29, 0xdb, 0xfe, 0xe7, 0x07, 0x45, 0x2f, 0x19, 0x45, 0xaf,
+	0x19, 0x45, 0xb3, 0x8c, 0xa2, 0xf7, 0x8c, 0xa2, 0xe7, 0x25, 0x2d, 0xcc, 0x96, 0xb4, 0xf0, 0xb6,
+	0xa4, 0x85, 0xa8, 0x04, 0x4f, 0x76, 0xf8, 0x35, 0x00, 0x87, 0xdc, 0x5b, 0xc9, 0xfc, 0x01, 0x00,
+	0x00,
 }
 
 func (m *AddrBookRecord) Marshal() (dAtA []byte, err error) {
@@ -306,6 +326,16 @@ func (m *AddrBookRecord_AddrEntry) MarshalToSizedBuffer(dAtA []byte) (int, error
 	_ = i
 	var l int
 	_ = l
+	if m.Added != 0 {
+		i = encodeVarintPstore(dAtA, i, uint64(m.Added))
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.LastSeen != 0 {
+		i = encodeVarintPstore(dAtA, i, uint64(m.LastSeen))
+		i--
+		dAtA[i] = 0x20
+	}
 	if m.Ttl != 0 {
 		i = encodeVarintPstore(dAtA, i, uint64(m.Ttl))
 		i--
@@ -406,6 +436,11 @@ func NewPopulatedAddrBookRecord_AddrEntry(r randyPstore, easy bool) *AddrBookRec
 	if r.Intn(2) == 0 {
 		this.Ttl *= -1
 	}
+	this.LastSeen = int64(r.Int63())
+	if r.Intn(2) == 0 {
+		this.LastSeen *= -1
+	}
+	this.Added = uint64(uint64(r.Uint32()))
 	if !easy && r.Intn(10) != 0 {
 	}
 	return this
@@ -535,6 +570,12 @@ func (m *AddrBookRecord_AddrEntry) Size() (n int) {
 	if m.Ttl != 0 {
 		n += 1 + sovPstore(uint64(m.Ttl))
 	}
+	if m.LastSeen != 0 {
+		n += 1 + sovPstore(uint64(m.LastSeen))
+	}
+	if m.Added != 0 {
+		n += 1 + sovPstore(uint64(m.Added))
+	}
 	return n
 }
 
@@ -820,6 +861,44 @@ func (m *AddrBookRecord_AddrEntry) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LastSeen", wireType)
+			}
+			m.LastSeen = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LastSeen |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Added", wireType)
+			}
+			m.Added = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Added |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPstore(dAtA[iNdEx:])
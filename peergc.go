@@ -0,0 +1,127 @@
+package peerstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// PeerRemover is implemented by peerstores, and their components, that can forget everything they hold about a
+// peer.
+type PeerRemover interface {
+	RemovePeer(p peer.ID)
+}
+
+// PeerCollector garbage-collects whole peers: peers left without any live address, once their addresses have
+// expired, are removed from every book of the peerstore, along with their protocols, metadata and keys.
+//
+// Peers are only collected once they have been found without addresses on two consecutive sweeps, so that peers
+// whose addresses are about to be added are spared. Protected peers (see PeerProtector) and peers we hold a private
+// key for, normally the local peer, are never collected.
+type PeerCollector struct {
+	ps    pstore.Peerstore
+	rm    PeerRemover
+	peers func() peer.IDSlice
+
+	mu         sync.Mutex
+	candidates map[peer.ID]struct{}
+
+	cancel func()
+	done   chan struct{}
+}
+
+// NewPeerCollector creates a PeerCollector for ps, which must implement PeerRemover. The peers function enumerates
+// the peers known to any book of the peerstore; it defaults to ps.Peers when nil. If interval is positive, a sweep
+// runs in the background at that interval; otherwise, sweeps only run on explicit calls to Collect.
+func NewPeerCollector(ps pstore.Peerstore, peers func() peer.IDSlice, interval time.Duration) (*PeerCollector, error) {
+	rm, ok := ps.(PeerRemover)
+	if !ok {
+		return nil, fmt.Errorf("peerstore cannot remove peers")
+	}
+	if peers == nil {
+		peers = ps.Peers
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &PeerCollector{
+		ps:         ps,
+		rm:         rm,
+		peers:      peers,
+		candidates: make(map[peer.ID]struct{}),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	if interval > 0 {
+		go c.background(ctx, interval)
+	} else {
+		close(c.done)
+	}
+	return c, nil
+}
+
+// Collect runs a sweep, and returns the number of peers removed.
+func (c *PeerCollector) Collect() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	protector, _ := c.ps.(PeerProtector)
+
+	var removed int
+	candidates := make(map[peer.ID]struct{})
+	for _, p := range c.peers() {
+		if len(c.ps.Addrs(p)) > 0 || c.hasPrivKey(p) {
+			continue
+		}
+		if protector != nil && protector.IsProtected(p, "") {
+			continue
+		}
+		if _, ok := c.candidates[p]; !ok {
+			candidates[p] = struct{}{}
+			continue
+		}
+		c.rm.RemovePeer(p)
+		removed++
+	}
+	c.candidates = candidates
+
+	if removed > 0 {
+		log.Debugf("peer GC removed %d peers", removed)
+	}
+	return removed
+}
+
+func (c *PeerCollector) hasPrivKey(p peer.ID) bool {
+	// avoid noisy logs for the expected missing keys.
+	if kb, ok := c.ps.(KeyBookE); ok {
+		sk, _ := kb.PrivKeyE(p)
+		return sk != nil
+	}
+	return c.ps.PrivKey(p) != nil
+}
+
+// Close stops the background sweeps, if any.
+func (c *PeerCollector) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *PeerCollector) background(ctx context.Context, interval time.Duration) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Collect()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
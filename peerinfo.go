@@ -1,6 +1,9 @@
 package peerstore
 
 import (
+	"fmt"
+	"strings"
+
 	core "github.com/libp2p/go-libp2p-core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -20,3 +23,61 @@ func InfoFromP2pAddr(m ma.Multiaddr) (*core.AddrInfo, error) {
 func InfoToP2pAddrs(pi *core.AddrInfo) ([]ma.Multiaddr, error) {
 	return core.AddrInfoToP2pAddrs(pi)
 }
+
+// ParsePeerInfo parses a peer and its address from a dial string, as found in command lines and configuration files.
+// Both the multiaddr form, /<maddr>/p2p/<id> (or its legacy /ipfs/<id> form), and the <id>@<maddr> form are accepted.
+// The address may be omitted, as in /p2p/<id>, in which case the returned info holds no address.
+func ParsePeerInfo(s string) (*core.AddrInfo, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "/") {
+		m, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer dial string %q: %s", s, err)
+		}
+		pi, err := core.AddrInfoFromP2pAddr(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer dial string %q: %s", s, err)
+		}
+		return pi, nil
+	}
+
+	i := strings.Index(s, "@")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid peer dial string %q: expected /<maddr>/p2p/<id> or <id>@<maddr>", s)
+	}
+	id, err := core.Decode(s[:i])
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID in dial string %q: %s", s, err)
+	}
+	m, err := ma.NewMultiaddr(s[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid address in dial string %q: %s", s, err)
+	}
+	// tolerate the address naming the peer again, but not another one.
+	if transport, other := core.SplitAddr(m); other != "" {
+		if other != id {
+			return nil, fmt.Errorf("invalid peer dial string %q: address names peer %s", s, other.Pretty())
+		}
+		m = transport
+	}
+	pi := &core.AddrInfo{ID: id}
+	if m != nil {
+		pi.Addrs = []ma.Multiaddr{m}
+	}
+	return pi, nil
+}
+
+// DialStrings returns the canonical dial strings of a peer, /<maddr>/p2p/<id>, one per address, or /p2p/<id> alone if
+// it has no address. They are parsed back by ParsePeerInfo.
+func DialStrings(pi core.AddrInfo) []string {
+	addrs, err := core.AddrInfoToP2pAddrs(&pi)
+	if err != nil {
+		// only happens for IDs that can't be encoded, which dial strings can't name either.
+		return nil
+	}
+	res := make([]string, len(addrs))
+	for i, a := range addrs {
+		res[i] = a.String()
+	}
+	return res
+}
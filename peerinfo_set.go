@@ -0,0 +1,126 @@
+package peerstore
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// PeerInfoSet is a set of peer infos deduplicated by peer ID, e.g. to collect the results of FindProvidersAsync: the
+// addresses of infos added for the same peer are merged, without duplicates. Iteration is stable, in the order peers
+// were first added.
+//
+// The zero value is an empty set ready to use. A PeerInfoSet is not safe for concurrent use.
+type PeerInfoSet struct {
+	index map[peer.ID]int
+	infos []peer.AddrInfo
+	// addrs indexes the addresses of each info, by position.
+	addrs []map[string]struct{}
+}
+
+// NewPeerInfoSet creates a set holding infos.
+func NewPeerInfoSet(infos ...peer.AddrInfo) *PeerInfoSet {
+	s := new(PeerInfoSet)
+	for _, pi := range infos {
+		s.Add(pi)
+	}
+	return s
+}
+
+// Add adds an info to the set, merging its addresses into those already held for the peer. It returns whether the
+// peer is new to the set.
+func (s *PeerInfoSet) Add(pi peer.AddrInfo) bool {
+	if s.index == nil {
+		s.index = make(map[peer.ID]int)
+	}
+	i, ok := s.index[pi.ID]
+	if !ok {
+		i = len(s.infos)
+		s.index[pi.ID] = i
+		s.infos = append(s.infos, peer.AddrInfo{ID: pi.ID})
+		s.addrs = append(s.addrs, make(map[string]struct{}, len(pi.Addrs)))
+	}
+	s.addAddrs(i, pi.Addrs)
+	return !ok
+}
+
+func (s *PeerInfoSet) addAddrs(i int, addrs []ma.Multiaddr) {
+	for _, a := range addrs {
+		if a == nil {
+			continue
+		}
+		k := string(a.Bytes())
+		if _, dup := s.addrs[i][k]; dup {
+			continue
+		}
+		s.addrs[i][k] = struct{}{}
+		s.infos[i].Addrs = append(s.infos[i].Addrs, a)
+	}
+}
+
+// Merge adds all the infos of other to the set, in the order of other.
+func (s *PeerInfoSet) Merge(other *PeerInfoSet) {
+	for _, pi := range other.infos {
+		s.Add(pi)
+	}
+}
+
+// Filter returns a new set holding the infos for which keep returns true.
+func (s *PeerInfoSet) Filter(keep func(peer.AddrInfo) bool) *PeerInfoSet {
+	res := new(PeerInfoSet)
+	for _, pi := range s.infos {
+		if keep(pi) {
+			res.Add(pi)
+		}
+	}
+	return res
+}
+
+// Has returns whether the set holds an info for p.
+func (s *PeerInfoSet) Has(p peer.ID) bool {
+	_, ok := s.index[p]
+	return ok
+}
+
+// Get returns the info held for p, if any.
+func (s *PeerInfoSet) Get(p peer.ID) (peer.AddrInfo, bool) {
+	i, ok := s.index[p]
+	if !ok {
+		return peer.AddrInfo{}, false
+	}
+	return cappedInfo(s.infos[i]), true
+}
+
+// Len returns the number of peers in the set.
+func (s *PeerInfoSet) Len() int {
+	return len(s.infos)
+}
+
+// ForEach calls f with every info in the set, in order, until f returns false. The set must not be modified by f.
+func (s *PeerInfoSet) ForEach(f func(peer.AddrInfo) bool) {
+	for _, pi := range s.infos {
+		if !f(cappedInfo(pi)) {
+			return
+		}
+	}
+}
+
+// Infos returns the infos in the set, in order.
+func (s *PeerInfoSet) Infos() []peer.AddrInfo {
+	res := make([]peer.AddrInfo, len(s.infos))
+	for i, pi := range s.infos {
+		res[i] = peer.AddrInfo{ID: pi.ID, Addrs: append([]ma.Multiaddr(nil), pi.Addrs...)}
+	}
+	return res
+}
+
+// IDs returns the peers in the set, in order.
+func (s *PeerInfoSet) IDs() peer.IDSlice {
+	return PeerInfoIDs(s.infos)
+}
+
+// cappedInfo returns pi with its addresses capped to their length, so that appending to them doesn't write into the
+// set.
+func cappedInfo(pi peer.AddrInfo) peer.AddrInfo {
+	pi.Addrs = pi.Addrs[:len(pi.Addrs):len(pi.Addrs)]
+	return pi
+}
@@ -0,0 +1,57 @@
+package peerstore_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestPeerInfoSet(t *testing.T) {
+	ids := pt.GeneratePeerIDs(3)
+	addrs := pt.GenerateAddrs(3)
+
+	var s pstore.PeerInfoSet
+	if !s.Add(peer.AddrInfo{ID: ids[1], Addrs: addrs[:2]}) {
+		t.Fatal("expected peer to be new")
+	}
+	s.Add(peer.AddrInfo{ID: ids[0]})
+	if s.Add(peer.AddrInfo{ID: ids[1], Addrs: []ma.Multiaddr{addrs[1], addrs[2], nil}}) {
+		t.Fatal("expected peer to be known")
+	}
+
+	if s.Len() != 2 || !s.Has(ids[0]) || s.Has(ids[2]) {
+		t.Fatalf("unexpected set: %v", s.Infos())
+	}
+	if got := s.IDs(); !reflect.DeepEqual(got, peer.IDSlice{ids[1], ids[0]}) {
+		t.Fatalf("expected insertion order, got %v", got)
+	}
+	pi, ok := s.Get(ids[1])
+	if !ok || !reflect.DeepEqual(pi.Addrs, addrs) {
+		t.Fatalf("expected merged addresses, got %v", pi.Addrs)
+	}
+
+	other := pstore.NewPeerInfoSet(peer.AddrInfo{ID: ids[2], Addrs: addrs[:1]}, peer.AddrInfo{ID: ids[0], Addrs: addrs[:1]})
+	s.Merge(other)
+	if got := s.IDs(); !reflect.DeepEqual(got, peer.IDSlice{ids[1], ids[0], ids[2]}) {
+		t.Fatalf("unexpected order after merge: %v", got)
+	}
+
+	withAddrs := s.Filter(func(pi peer.AddrInfo) bool { return len(pi.Addrs) == 1 })
+	if got := withAddrs.IDs(); !reflect.DeepEqual(got, peer.IDSlice{ids[0], ids[2]}) {
+		t.Fatalf("unexpected filtered set: %v", got)
+	}
+
+	var visited peer.IDSlice
+	s.ForEach(func(pi peer.AddrInfo) bool {
+		visited = append(visited, pi.ID)
+		return len(visited) < 2
+	})
+	if !reflect.DeepEqual(visited, peer.IDSlice{ids[1], ids[0]}) {
+		t.Fatalf("unexpected iteration: %v", visited)
+	}
+}
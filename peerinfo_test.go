@@ -0,0 +1,76 @@
+package peerstore_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestParsePeerInfo(t *testing.T) {
+	ids := pt.GeneratePeerIDs(2)
+	id, other := peer.Encode(ids[0]), peer.Encode(ids[1])
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	for _, s := range []string{
+		"/ip4/1.2.3.4/tcp/4001/p2p/" + id,
+		"/ip4/1.2.3.4/tcp/4001/ipfs/" + id,
+		id + "@/ip4/1.2.3.4/tcp/4001",
+		id + "@/ip4/1.2.3.4/tcp/4001/p2p/" + id,
+		" " + id + "@/ip4/1.2.3.4/tcp/4001\n",
+	} {
+		pi, err := pstore.ParsePeerInfo(s)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %s", s, err)
+		}
+		if pi.ID != ids[0] || len(pi.Addrs) != 1 || !pi.Addrs[0].Equal(addr) {
+			t.Fatalf("unexpected info parsed from %q: %s", s, pi)
+		}
+	}
+
+	pi, err := pstore.ParsePeerInfo("/p2p/" + id)
+	if err != nil || pi.ID != ids[0] || len(pi.Addrs) != 0 {
+		t.Fatalf("expected a bare peer, got %v (%v)", pi, err)
+	}
+
+	for _, s := range []string{
+		"",
+		id,
+		"/ip4/1.2.3.4/tcp/4001",
+		"nope@/ip4/1.2.3.4/tcp/4001",
+		id + "@ip4/1.2.3.4",
+		id + "@/ip4/1.2.3.4/tcp/4001/p2p/" + other,
+	} {
+		if _, err := pstore.ParsePeerInfo(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestDialStrings(t *testing.T) {
+	id := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(2)
+
+	got := pstore.DialStrings(peer.AddrInfo{ID: id, Addrs: addrs})
+	want := []string{
+		addrs[0].String() + "/p2p/" + peer.Encode(id),
+		addrs[1].String() + "/p2p/" + peer.Encode(id),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i, s := range got {
+		pi, err := pstore.ParsePeerInfo(s)
+		if err != nil || pi.ID != id || !pi.Addrs[0].Equal(addrs[i]) {
+			t.Fatalf("expected %q to round-trip, got %v (%v)", s, pi, err)
+		}
+	}
+
+	if got := pstore.DialStrings(peer.AddrInfo{ID: id}); len(got) != 1 || got[0] != "/p2p/"+peer.Encode(id) {
+		t.Fatalf("expected a bare dial string, got %v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package peerstore
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PeerLocker is implemented by peerstores that hand out a lock per peer, so that callers composing read-modify-write
+// sequences across books, such as identify comparing and updating the addresses and protocols of a peer, can make
+// them atomic with respect to each other without a global mutex of their own.
+//
+// The locks are advisory: they only exclude other holders of the lock of the same peer. Operations of the books don't
+// take them, so they may be called while holding the lock, and aren't blocked by it. The locks aren't reentrant, and
+// aren't shared by the processes sharing a datastore.
+type PeerLocker interface {
+	// LockPeer locks p, waiting until no one else holds its lock.
+	LockPeer(p peer.ID)
+	// UnlockPeer unlocks p, which must be locked.
+	UnlockPeer(p peer.ID)
+	// WithPeerLocked calls fn with p locked, and returns its error.
+	WithPeerLocked(p peer.ID, fn func() error) error
+}
+
+// PeerLocks implements PeerLocker for peerstores. Locks are allocated while held or waited for, so idle peers cost
+// nothing. The zero value is ready to use.
+type PeerLocks struct {
+	mu    sync.Mutex
+	locks map[peer.ID]*peerLock
+}
+
+var _ PeerLocker = (*PeerLocks)(nil)
+
+type peerLock struct {
+	sync.Mutex
+	// refs is the number of holders and waiters of the lock, guarded by PeerLocks.mu.
+	refs int
+}
+
+func (l *PeerLocks) LockPeer(p peer.ID) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[peer.ID]*peerLock)
+	}
+	pl, ok := l.locks[p]
+	if !ok {
+		pl = new(peerLock)
+		l.locks[p] = pl
+	}
+	pl.refs++
+	l.mu.Unlock()
+
+	pl.Lock()
+}
+
+func (l *PeerLocks) UnlockPeer(p peer.ID) {
+	l.mu.Lock()
+	pl, ok := l.locks[p]
+	if !ok {
+		l.mu.Unlock()
+		panic("peerstore: unlock of unlocked peer " + p.Pretty())
+	}
+	if pl.refs--; pl.refs == 0 {
+		delete(l.locks, p)
+	}
+	l.mu.Unlock()
+
+	pl.Unlock()
+}
+
+func (l *PeerLocks) WithPeerLocked(p peer.ID, fn func() error) error {
+	l.LockPeer(p)
+	defer l.UnlockPeer(p)
+	return fn()
+}
@@ -0,0 +1,38 @@
+package peerstore_test
+
+import (
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestPeerLocks(t *testing.T) {
+	var locks pstore.PeerLocks
+	p := pt.GeneratePeerIDs(1)[0]
+
+	locks.LockPeer(p)
+	acquired, released := make(chan struct{}), make(chan struct{})
+	go func() {
+		locks.LockPeer(p)
+		close(acquired)
+		locks.UnlockPeer(p)
+		close(released)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the lock to be held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	locks.UnlockPeer(p)
+	<-acquired
+	<-released
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected unlocking an unlocked peer to panic")
+		}
+	}()
+	locks.UnlockPeer(p)
+}
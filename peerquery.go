@@ -0,0 +1,129 @@
+package peerstore
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// ProtocolPeers is implemented by peerstores that can list the peers supporting given protocols.
+type ProtocolPeers interface {
+	// PeersWithProtocols returns the peers supporting any of the given protocols.
+	PeersWithProtocols(protos ...string) peer.IDSlice
+}
+
+// PeerExistence is implemented by peerstores that can tell whether they hold data about a peer without loading or
+// decoding its records.
+type PeerExistence interface {
+	// HasAddrs returns whether a peer has non-expired addresses.
+	HasAddrs(p peer.ID) bool
+	// HasKey returns whether a public or private key was added for a peer. Keys inlined in peer IDs don't count, as
+	// for PeersWithKeys.
+	HasKey(p peer.ID) bool
+	// Known returns whether any book holds data about a peer: addresses, keys, protocols or metadata.
+	Known(p peer.ID) bool
+}
+
+// KnownFilter is implemented by peerstores that can check many peers for addresses in a single batched lookup, e.g.
+// for routing layers pruning the candidates they have no addresses for before querying them.
+type KnownFilter interface {
+	// FilterKnown splits peers into those with non-expired addresses and the others, each in the order they were
+	// passed in.
+	FilterKnown(peers []peer.ID) (known, unknown []peer.ID)
+}
+
+// FilterKnown splits peers into those ps holds non-expired addresses for and the others, each in the order they were
+// passed in. It uses the batched lookup of ps if it implements KnownFilter, and checks peers one by one otherwise.
+func FilterKnown(ps pstore.Peerstore, peers []peer.ID) (known, unknown []peer.ID) {
+	if kf, ok := ps.(KnownFilter); ok {
+		return kf.FilterKnown(peers)
+	}
+	pe, _ := ps.(PeerExistence)
+	for _, p := range peers {
+		var has bool
+		if pe != nil {
+			has = pe.HasAddrs(p)
+		} else {
+			has = len(ps.Addrs(p)) > 0
+		}
+		if has {
+			known = append(known, p)
+		} else {
+			unknown = append(unknown, p)
+		}
+	}
+	return known, unknown
+}
+
+// Filter selects peers by the books they are present in. Criteria are combined: a peer must match all the set ones.
+// The zero value matches all peers.
+type Filter struct {
+	// HasAddrs selects the peers with addresses.
+	HasAddrs bool
+	// HasKeys selects the peers with keys.
+	HasKeys bool
+	// SupportsProto, if not empty, selects the peers supporting this protocol.
+	SupportsProto string
+	// Group, if not empty, selects the members of this group. Peerstores that don't implement GroupBook have no
+	// group members.
+	Group string
+}
+
+// Peers returns the peers of ps matching f. Candidates are listed from a single book, by group, then by protocol if
+// ps implements ProtocolPeers, then by keys, then by addresses, and checked against the other criteria; peers known
+// only to books that aren't part of the filter are thus left out.
+func Peers(ps pstore.Peerstore, f Filter) peer.IDSlice {
+	var (
+		candidates peer.IDSlice
+		checkProto = f.SupportsProto != ""
+		checkKeys  = f.HasKeys
+		checkAddrs = f.HasAddrs
+	)
+	if f.Group != "" {
+		gb, ok := ps.(GroupBook)
+		if !ok {
+			return peer.IDSlice{}
+		}
+		candidates = gb.GroupMembers(f.Group)
+	} else if pp, ok := ps.(ProtocolPeers); ok && checkProto {
+		candidates, checkProto = pp.PeersWithProtocols(f.SupportsProto), false
+	} else if checkKeys {
+		candidates, checkKeys = ps.PeersWithKeys(), false
+	} else if checkAddrs {
+		candidates, checkAddrs = ps.PeersWithAddrs(), false
+	} else {
+		candidates = ps.Peers()
+	}
+
+	var withKeys, withAddrs map[peer.ID]struct{}
+	if checkKeys {
+		withKeys = peerSet(ps.PeersWithKeys())
+	}
+	if checkAddrs {
+		withAddrs = peerSet(ps.PeersWithAddrs())
+	}
+
+	out := make(peer.IDSlice, 0, len(candidates))
+	for _, p := range candidates {
+		if _, ok := withKeys[p]; checkKeys && !ok {
+			continue
+		}
+		if _, ok := withAddrs[p]; checkAddrs && !ok {
+			continue
+		}
+		if checkProto {
+			if supported, err := ps.SupportsProtocols(p, f.SupportsProto); err != nil || len(supported) == 0 {
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func peerSet(peers peer.IDSlice) map[peer.ID]struct{} {
+	set := make(map[peer.ID]struct{}, len(peers))
+	for _, p := range peers {
+		set[p] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,34 @@
+package peerstore
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PeerState is what a peerstore holds about a peer, read in one consistent view, e.g. to compute identify deltas or to
+// serve debug endpoints.
+type PeerState struct {
+	ID peer.ID
+	// Name is the name of the peer, if the peerstore implements NameBook and the peer is named.
+	Name string
+	// Addrs are the non-expired addresses of the peer, along with their TTLs and expiry times.
+	Addrs []ExpiringAddr
+	// Protocols are the protocols supported by the peer, sorted.
+	Protocols []string
+	// HasPubKey and HasPrivKey report whether keys were added for the peer. Keys inlined in peer IDs don't count.
+	HasPubKey  bool
+	HasPrivKey bool
+	// Latency is the EWMA of the latency measurements of the peer. Metrics are read alongside the books, but aren't
+	// part of the consistent view.
+	Latency time.Duration
+	// MetadataKeys are the keys the metadata of the peer is stored under, sorted. Protocols are not included, even
+	// if the backend keeps them in the metadata.
+	MetadataKeys []string
+}
+
+// PeerStateReader is implemented by peerstores that can read the state of a peer across all books at once.
+type PeerStateReader interface {
+	// GetPeerState returns what the peerstore holds about a peer.
+	GetPeerState(p peer.ID) PeerState
+}
@@ -53,6 +53,7 @@ func (ps *peerstore) Close() (err error) {
 	return nil
 }
 
+// Peers returns the peers with addresses or keys, each once. The order is not defined, and may change between calls.
 func (ps *peerstore) Peers() peer.IDSlice {
 	set := map[peer.ID]struct{}{}
 	for _, p := range ps.PeersWithKeys() {
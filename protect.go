@@ -0,0 +1,23 @@
+package peerstore
+
+import "github.com/libp2p/go-libp2p-core/peer"
+
+// PeerProtector is implemented by peerstores that can exempt important peers (e.g. bootstrap nodes or cluster
+// members) from eviction. The last-known addresses of a protected peer are retained and returned even after they
+// expire, and are never garbage collected; they can still be removed explicitly. Keys are never evicted.
+//
+// Protections are tagged, so that independent components can protect the same peer; a peer stays protected until
+// all its tags have been removed.
+type PeerProtector interface {
+	// Protect protects a peer under the given tag.
+	Protect(p peer.ID, tag string)
+
+	// Unprotect removes a protection tag from a peer, and returns whether the peer remains protected by other tags.
+	Unprotect(p peer.ID, tag string) (protected bool)
+
+	// IsProtected returns whether the peer is protected under the given tag, or under any tag if tag is empty.
+	IsProtected(p peer.ID, tag string) bool
+
+	// ProtectedPeers returns all protected peers.
+	ProtectedPeers() peer.IDSlice
+}
@@ -3,22 +3,24 @@ package pstoreds
 import (
 	"context"
 	"fmt"
+	"math"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/record"
 
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
-	logging "github.com/ipfs/go-log"
 
 	"github.com/libp2p/go-libp2p-core/peer"
-	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/addr"
 	pb "github.com/libp2p/go-libp2p-peerstore/pb"
 	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
 
-	lru "github.com/hashicorp/golang-lru"
 	b32 "github.com/multiformats/go-base32"
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -27,11 +29,11 @@ type ttlWriteMode int
 
 const (
 	ttlOverride ttlWriteMode = iota
-	ttlExtend
+	ttlMerge
 )
 
 var (
-	log = logging.Logger("peerstore/ds")
+	log = pstore.SubsystemLogger("peerstore/ds")
 
 	// Peer addresses are stored db key pattern:
 	// /peers/addrs/<b32 peer id no padding>
@@ -43,30 +45,14 @@ type addrsRecord struct {
 	sync.RWMutex
 	*pb.AddrBookRecord
 	dirty bool
-}
-
-// flush writes the record to the datastore by calling ds.Put, unless the record is
-// marked for deletion, in which case we call ds.Delete. To be called within a lock.
-func (r *addrsRecord) flush(write ds.Write) (err error) {
-	key := addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(r.Id.ID)))
 
-	if len(r.Addrs) == 0 {
-		if err = write.Delete(key); err == nil {
-			r.dirty = false
-		}
-		return err
-	}
+	// stored holds the encoded entries of the record as last loaded or flushed, keyed by name, in the per-address
+	// layout; see perAddrStore.
+	stored map[string][]byte
 
-	data, err := r.Marshal()
-	if err != nil {
-		return err
-	}
-	if err = write.Put(key, data); err != nil {
-		return err
-	}
-	// write succeeded; record is no longer dirty.
-	r.dirty = false
-	return nil
+	// checked is a Unix timestamp in nanoseconds of when the record was read from the datastore or last compared with
+	// it, if Options.CacheReconciliation is set.
+	checked int64
 }
 
 // clean is called on records to perform housekeeping. The return value indicates if the record was changed
@@ -74,7 +60,7 @@ func (r *addrsRecord) flush(write ds.Write) (err error) {
 //
 // clean does the following:
 // * sorts addresses by expiration (soonest expiring first).
-// * removes expired addresses.
+// * removes addresses expired by now (a unix timestamp).
 //
 // It short-circuits optimistically when there's nothing to do.
 //
@@ -83,12 +69,13 @@ func (r *addrsRecord) flush(write ds.Write) (err error) {
 // * when performing periodic GC.
 // * after an entry has been modified (e.g. addresses have been added or removed, TTLs updated, etc.)
 //
+// If keepExpired is true, expired addresses are retained (see PeerProtector); the record is only sorted if dirty.
+//
 // If the return value is true, the caller should perform a flush immediately to sync the record with the store.
-func (r *addrsRecord) clean() (chgd bool) {
-	now := time.Now().Unix()
+func (r *addrsRecord) clean(now int64, keepExpired bool) (chgd bool) {
 	addrsLen := len(r.Addrs)
 
-	if !r.dirty && !r.hasExpiredAddrs(now) {
+	if !r.dirty && (keepExpired || !r.hasExpiredAddrs(now)) {
 		// record is not dirty, and we have no expired entries to purge.
 		return false
 	}
@@ -105,7 +92,9 @@ func (r *addrsRecord) clean() (chgd bool) {
 		})
 	}
 
-	r.Addrs = removeExpired(r.Addrs, now)
+	if !keepExpired {
+		r.Addrs = removeExpired(r.Addrs, now)
+	}
 
 	return r.dirty || len(r.Addrs) != addrsLen
 }
@@ -134,28 +123,63 @@ func removeExpired(entries []*pb.AddrBookRecord_AddrEntry, now int64) []*pb.Addr
 // dsAddrBook is an address book backed by a Datastore with a GC procedure to purge expired entries. It uses an
 // in-memory address stream manager. See the NewAddrBook for more information.
 type dsAddrBook struct {
+	// stats counters; accessed atomically, keep first for 64-bit alignment.
+	compactions uint64
+	gcVisits    uint64
+	gcPurges    uint64
+	gcInterval  int64 // nanoseconds.
+
 	ctx  context.Context
 	opts Options
 
 	cache       cache
 	ds          ds.Batching
+	codec       RecordCodec
+	records     addrStore
+	clock       pstore.Clock
 	gc          *dsAddrBookGc
+	expiries    *expiryIndex // nil unless Options.GCExpiryIndex is set.
+	accesses    *accessLog   // nil unless Options.CacheWarmPeers is set.
 	subsManager *pstoremem.AddrSubManager
+	auditor     auditor
+	ttlPolicy   pstore.TTLPolicy
+	corrupt     *corruptReporter
+	validateID  pstore.IDValidator
+	jitter      *pstore.TTLJitter
+	debouncer   *pstore.AddrDebouncer
+	strict      *pstore.StrictChecks
+	gens        *pstore.Generations
+	lengthCap   *pstore.AddrLengthCap
+	budget      *diskBudget      // set by NewPeerstore, if Options.MaxDiskBytes is set.
+	changes     *changeNotifier  // nil unless Options.ChangeNotifyInterval is set.
+	refreshes   *refreshQueue    // nil unless Options.RefreshFlushInterval is set.
+	reconcile   *cacheReconciler // nil unless Options.CacheReconciliation is set.
+	history     *addrHistory     // nil unless Options.AddrHistorySize is set.
+	*pstoremem.ProtectManager
 
 	// controls children goroutine lifetime.
 	childrenDone sync.WaitGroup
 	cancelFn     func()
 }
 
-var _ pstore.AddrBook = (*dsAddrBook)(nil)
-var _ pstore.CertifiedAddrBook = (*dsAddrBook)(nil)
+var _ peerstore.AddrBook = (*dsAddrBook)(nil)
+var _ peerstore.CertifiedAddrBook = (*dsAddrBook)(nil)
+var _ pstore.ExpiringAddrBook = (*dsAddrBook)(nil)
+var _ pstore.ExpiryTimeline = (*dsAddrBook)(nil)
+var _ pstore.RecencyAddrBook = (*dsAddrBook)(nil)
+var _ pstore.AddrBookE = (*dsAddrBook)(nil)
+var _ pstore.KnownFilter = (*dsAddrBook)(nil)
+var _ pstore.BackendTimer = (*dsAddrBook)(nil)
+var _ pstore.AddrChurnBook = (*dsAddrBook)(nil)
+var _ pstoremem.AddrSubProvider = (*dsAddrBook)(nil)
 
 // NewAddrBook initializes a new datastore-backed address book. It serves as a drop-in replacement for pstoremem
 // (memory-backed peerstore), and works with any datastore implementing the ds.Batching interface.
 //
-// Addresses and peer records are serialized into protobuf, storing one datastore entry per peer, along with metadata
-// to control address expiration. To alleviate disk access and serde overhead, we internally use a read/write-through
-// ARC cache, the size of which is adjustable via Options.CacheSize.
+// Addresses and peer records are serialized into protobuf (or the codec set in Options.Codec), storing one datastore
+// entry per peer, or per address depending on Options.AddrLayout, along with metadata to control address expiration.
+// To alleviate disk access and serde overhead, we internally use a read/write-through ARC cache, the size of which is
+// adjustable via Options.CacheSize.
 //
 // The user has a choice of two GC algorithms:
 //
@@ -169,97 +193,393 @@ var _ pstore.CertifiedAddrBook = (*dsAddrBook)(nil)
 //    the range of possible TTL values is small and the values themselves are also extreme, e.g. 10 minutes or
 //    permanent, popular values used in other libp2p modules. In this cited case, optimizing with lookahead windows
 //    makes little sense.
+//
+// Full-purge GC can be narrowed down by setting Options.GCExpiryIndex. The address book then keeps an in-memory index
+// of the soonest expiry of every peer, updated on writes. The first purge cycle after startup visits the whole store
+// to seed the index; subsequent cycles only visit the peers whose soonest expiry has passed.
 func NewAddrBook(ctx context.Context, store ds.Batching, opts Options) (ab *dsAddrBook, err error) {
 	ctx, cancelFn := context.WithCancel(ctx)
 	ab = &dsAddrBook{
 		ctx:         ctx,
-		ds:          store,
+		ds:          wrapStore(store, opts),
 		opts:        opts,
+		codec:       opts.Codec,
+		clock:       opts.Clock,
 		cancelFn:    cancelFn,
 		subsManager: pstoremem.NewAddrSubManager(),
+		auditor:     newAuditor(opts),
+		ttlPolicy:   opts.TTLPolicy,
+		corrupt:     newCorruptReporter(opts),
+		validateID:  idValidator(opts),
+		jitter:      pstore.NewTTLJitter(opts.TTLJitter, time.Now().UnixNano()),
+		debouncer:   pstore.NewAddrDebouncer(opts.AddrDebounce, opts.Clock),
+		lengthCap:   pstore.NewAddrLengthCap(opts.MaxAddrLen),
+		strict:      pstore.NewStrictChecks(opts.StrictChecks),
+
+		ProtectManager: pstoremem.NewProtectManager(),
+	}
+
+	if ab.clock == nil {
+		ab.clock = pstore.RealClock{}
+	}
+
+	if ab.ttlPolicy == nil {
+		ab.ttlPolicy = pstore.MaxTTL
+	}
+
+	if ab.codec == nil {
+		ab.codec = ProtobufCodec
+	} else if c, ok := lookupCodec(ab.codec.ID()); !ok || c != ab.codec {
+		return nil, fmt.Errorf("record codec with ID %d is not registered", ab.codec.ID())
+	}
+	if !opts.Compression.valid() {
+		return nil, fmt.Errorf("unknown record compression: %s", opts.Compression)
+	}
+	if ab.records, err = newAddrStore(opts.AddrLayout, ab.codec, opts.Compression); err != nil {
+		return nil, err
+	}
+
+	if opts.GCExpiryIndex {
+		ab.expiries = newExpiryIndex()
 	}
 
 	if opts.CacheSize > 0 {
-		if ab.cache, err = lru.NewARC(int(opts.CacheSize)); err != nil {
+		switch opts.CacheAdmission {
+		case CacheAdmissionAlways:
+			ab.cache, err = newARCCache(int(opts.CacheSize))
+		case CacheAdmissionTinyLFU:
+			ab.cache, err = newTinyLFUCache(int(opts.CacheSize))
+		default:
+			err = fmt.Errorf("unknown cache admission policy: %s", opts.CacheAdmission)
+		}
+		if err != nil {
 			return nil, err
 		}
 	} else {
 		ab.cache = new(noopCache)
 	}
 
+	if opts.CacheSize > 0 {
+		if ab.reconcile, err = newReconciler(ab, opts.CacheReconciliation, opts.ReconcileTTL); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.CacheSize > 0 && opts.CacheWarmPeers > 0 {
+		ab.accesses = newAccessLog(4 * opts.CacheWarmPeers)
+		if err := ab.warmCache(); err != nil {
+			log.Warnf("failed to warm the cache from the access log: %s", err)
+		}
+	}
+
 	if ab.gc, err = newAddressBookGc(ctx, ab); err != nil {
 		return nil, err
 	}
 
+	if ab.changes, err = newChangeNotifier(ab, opts.ChangeNotifyInterval); err != nil {
+		return nil, err
+	}
+	if ab.changes != nil {
+		ab.childrenDone.Add(1)
+		go ab.changes.background()
+	}
+
+	if ab.refreshes, err = newRefreshQueue(ab, opts.RefreshFlushInterval); err != nil {
+		return nil, err
+	}
+	if ab.refreshes != nil {
+		ab.childrenDone.Add(1)
+		go ab.refreshes.background()
+	}
+
+	if ab.history, err = newAddrHistory(ab, opts.AddrHistorySize); err != nil {
+		return nil, err
+	}
+	if ab.history != nil {
+		ab.childrenDone.Add(1)
+		go ab.history.background()
+	}
+
+	if rs, ok := ab.ds.(*retryStore); ok {
+		if opts.AddrLayout == AddrLayoutPerAddr {
+			rs.addReconciler(addrKeysBase, ab.mergeEntries)
+		} else {
+			rs.addReconciler(addrBookBase, ab.mergeRecords)
+		}
+	}
+
 	return ab, nil
 }
 
+// mergeRecords reconciles a record written while the datastore was unavailable, and thus without knowledge of the
+// persisted record, with the latter. Addresses are merged, keeping the latest expiry of each; the certified record
+// written last wins.
+func (ab *dsAddrBook) mergeRecords(_ ds.Key, held, persisted []byte) ([]byte, error) {
+	pr := &addrsRecord{AddrBookRecord: new(pb.AddrBookRecord)}
+	if err := decodeRecord(held, pr.AddrBookRecord); err != nil {
+		return nil, err
+	}
+	old := new(pb.AddrBookRecord)
+	if err := decodeRecord(persisted, old); err != nil {
+		return nil, err
+	}
+
+	pr.Addrs = mergeAddrEntries(pr.Addrs, old.Addrs)
+	if pr.CertifiedRecord == nil {
+		pr.CertifiedRecord = old.CertifiedRecord
+	}
+
+	pr.dirty = true
+	ab.cleanRecord(pr)
+
+	// the cached record, if any, is the one written blind.
+	ab.cache.Remove(pr.Id.ID)
+	ab.indexRecord(pr)
+
+	return encodeRecord(ab.codec, ab.opts.Compression, pr.AddrBookRecord)
+}
+
+// mergeAddrEntries adds the entries of src to dst, keeping the latest expiry and largest TTL of the addresses both
+// hold. The entries of dst are updated in place.
+func mergeAddrEntries(dst, src []*pb.AddrBookRecord_AddrEntry) []*pb.AddrBookRecord_AddrEntry {
+	entries := make(map[string]*pb.AddrBookRecord_AddrEntry, len(dst))
+	for _, e := range dst {
+		entries[string(e.Addr.Bytes())] = e
+	}
+	for _, e := range src {
+		if have, ok := entries[string(e.Addr.Bytes())]; ok {
+			if e.Expiry > have.Expiry {
+				have.Expiry = e.Expiry
+			}
+			if e.Ttl > have.Ttl {
+				have.Ttl = e.Ttl
+			}
+			continue
+		}
+		dst = append(dst, e)
+	}
+	return dst
+}
+
+// mergeEntries is the counterpart of mergeRecords for the per-address layout: an address entry written while the
+// datastore was unavailable keeps the latest expiry and largest TTL of both entries; the certified record written last
+// wins.
+func (ab *dsAddrBook) mergeEntries(key ds.Key, held, persisted []byte) ([]byte, error) {
+	// the cached record, if any, no longer knows what's stored.
+	if id, err := b32.RawStdEncoding.DecodeString(key.Parent().Name()); err == nil {
+		ab.cache.Remove(peer.ID(id))
+	}
+	if key.Name() == certifiedName {
+		return held, nil
+	}
+
+	entry := new(pb.AddrBookRecord_AddrEntry)
+	if err := entry.Unmarshal(held); err != nil {
+		return nil, err
+	}
+	old := new(pb.AddrBookRecord_AddrEntry)
+	if err := old.Unmarshal(persisted); err != nil {
+		return nil, err
+	}
+	if old.Expiry > entry.Expiry {
+		entry.Expiry = old.Expiry
+	}
+	if old.Ttl > entry.Ttl {
+		entry.Ttl = old.Ttl
+	}
+	if old.LastSeen > entry.LastSeen {
+		entry.LastSeen = old.LastSeen
+	}
+	return entry.Marshal()
+}
+
 func (ab *dsAddrBook) Close() error {
 	ab.cancelFn()
 	ab.childrenDone.Wait()
+	if ab.accesses != nil {
+		return ab.accesses.save(ab.ds, ab.opts.CacheWarmPeers)
+	}
 	return nil
 }
 
+// Stats returns a snapshot of the counters maintained by this address book.
+func (ab *dsAddrBook) Stats() AddrBookStats {
+	stats := AddrBookStats{
+		Compactions:    atomic.LoadUint64(&ab.compactions),
+		GCVisits:       atomic.LoadUint64(&ab.gcVisits),
+		GCPurges:       atomic.LoadUint64(&ab.gcPurges),
+		GCInterval:     time.Duration(atomic.LoadInt64(&ab.gcInterval)),
+		CorruptRecords: ab.corrupt.reported(),
+		DebouncedAddrs: ab.debouncer.Suppressed(),
+		TooLongAddrs:   ab.lengthCap.Rejected(),
+		Invalidations:  ab.changes.stats(),
+		Reconciliation: ab.reconcile.stats(),
+	}
+	stats.DeferredRefreshes, stats.RefreshWrites = ab.refreshes.stats()
+	if rs, ok := ab.ds.(*retryStore); ok {
+		stats.Datastore = rs.Stats()
+	}
+	if c, ok := ab.cache.(*tinyLFUCache); ok {
+		stats.Cache = c.stats()
+	}
+	if ab.budget != nil {
+		stats.Budget = ab.budget.stats()
+	}
+	return stats
+}
+
 // loadRecord is a read-through fetch. It fetches a record from cache, falling back to the
 // datastore upon a miss, and returning a newly initialized record if the peer doesn't exist.
 //
 // loadRecord calls clean() on an existing record before returning it. If the record changes
-// as a result and the update argument is true, the resulting state is saved in the datastore.
+// as a result and the update argument is true, the resulting state is saved in the datastore,
+// subject to the compaction threshold (see compact).
 //
 // If the cache argument is true, the record is inserted in the cache when loaded from the datastore.
 func (ab *dsAddrBook) loadRecord(id peer.ID, cache bool, update bool) (pr *addrsRecord, err error) {
-	if err := id.Validate(); err != nil {
+	if err := ab.validateID(id); err != nil {
 		return nil, err
 	}
+	if ab.accesses != nil {
+		ab.accesses.record(id)
+	}
 	if e, ok := ab.cache.Get(id); ok {
 		pr = e.(*addrsRecord)
+		// checked before taking the lock of the record, which comes after that of the refresh queue.
+		pending := ab.reconcile != nil && ab.refreshes.get(id) != nil
 		pr.Lock()
 		defer pr.Unlock()
 
-		if pr.clean() && update {
-			err = pr.flush(ab.ds)
+		if !pending {
+			if err := ab.reconcile.check(pr); err != nil {
+				return nil, err
+			}
+		}
+		if update {
+			err = ab.compact(pr)
+		} else {
+			ab.cleanRecord(pr)
 		}
 		return pr, err
 	}
+	if pr = ab.refreshes.get(id); pr != nil {
+		// queued refreshes are ahead of the datastore.
+		pr.Lock()
+		defer pr.Unlock()
+		ab.cleanRecord(pr)
+		if cache {
+			ab.cache.Add(id, pr)
+		}
+		return pr, nil
+	}
 
 	pr = &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}}
-	key := addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(id)))
-	data, err := ab.ds.Get(key)
-
-	switch err {
-	case ds.ErrNotFound:
-		err = nil
+	found, err := ab.records.load(ab.ds, id, pr)
+	switch {
+	case err != nil:
+		return nil, err
+	case !found:
 		pr.Id = &pb.ProtoPeerID{ID: id}
-	case nil:
-		if err = pr.Unmarshal(data); err != nil {
-			return nil, err
-		}
+	default:
 		// this record is new and local for now (not in cache), so we don't need to lock.
-		if pr.clean() && update {
-			err = pr.flush(ab.ds)
+		if update {
+			err = ab.compact(pr)
+		} else {
+			ab.cleanRecord(pr)
 		}
-	default:
-		return nil, err
 	}
 
+	ab.reconcile.loaded(pr)
 	if cache {
 		ab.cache.Add(id, pr)
 	}
 	return pr, err
 }
 
+// compact cleans the record and rewrites it in the datastore if the fraction of expired entries it contained
+// reaches Options.CompactionThreshold. Records that were already dirty are always written. To be called within
+// a lock.
+func (ab *dsAddrBook) compact(pr *addrsRecord) error {
+	dirty, before := pr.dirty, len(pr.Addrs)
+	if !ab.cleanRecord(pr) {
+		return nil
+	}
+	dead := before - len(pr.Addrs)
+	if !dirty && before > 0 && dead > 0 && float64(dead)/float64(before) < ab.opts.CompactionThreshold {
+		// below the threshold; keep the cleaned record in memory only.
+		return nil
+	}
+	if err := ab.records.flush(ab.ds, pr); err != nil {
+		return err
+	}
+	ab.indexRecord(pr)
+	if dead > 0 {
+		atomic.AddUint64(&ab.compactions, 1)
+	}
+	return nil
+}
+
+// cleanRecord cleans a record, retaining the expired addresses of protected peers. To be called within a lock.
+func (ab *dsAddrBook) cleanRecord(pr *addrsRecord) bool {
+	now, protected := ab.clock.Now().Unix(), ab.IsProtected(pr.Id.ID, "")
+	if ab.history != nil && !protected {
+		for _, e := range pr.Addrs {
+			if e.Expiry <= now {
+				ab.history.record(pr.Id.ID, e.Addr.Multiaddr, pstore.AddrExpired, time.Unix(e.Expiry, 0))
+			}
+		}
+	}
+	return pr.clean(now, protected)
+}
+
+// indexRecord updates the expiry index with the soonest expiry of a record, if the index is enabled. The record must
+// be clean (i.e. sorted by expiry). To be called within a lock.
+func (ab *dsAddrBook) indexRecord(pr *addrsRecord) {
+	if ab.expiries == nil {
+		return
+	}
+	if len(pr.Addrs) == 0 {
+		ab.expiries.remove(pr.Id.ID)
+		return
+	}
+	ab.expiries.update(pr.Id.ID, pr.Addrs[0].Expiry)
+}
+
 // AddAddr will add a new address if it's not already in the AddrBook.
 func (ab *dsAddrBook) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
 	ab.AddAddrs(p, []ma.Multiaddr{addr}, ttl)
 }
 
-// AddAddrs will add many new addresses if they're not already in the AddrBook.
+// AddAddrs will add many new addresses if they're not already in the AddrBook. The TTLs of addresses already held
+// are resolved by the TTL policy.
 func (ab *dsAddrBook) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	if err := ab.AddAddrsE(p, addrs, ttl); err != nil {
+		log.Errorf("failed to add addresses for peer %s: %s", p.Pretty(), err)
+	}
+}
+
+// AddAddrsE is like AddAddrs, but returns an error if the addresses could not be persisted,
+// pstore.ErrP2PAddrMismatch if some were rejected by the /p2p address policy, the reason of the first rejected by the
+// exotic address policy, pstore.ErrAddrTooLong if some were longer than Options.MaxAddrLen, or a *pstore.ArgumentError
+// if Options.StrictChecks is set and rejects the arguments.
+func (ab *dsAddrBook) AddAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	if err := ab.strict.AddAddrs(p, addrs, ttl); err != nil {
+		return err
+	}
 	if ttl <= 0 {
-		return
+		return nil
 	}
-	addrs = cleanAddrs(addrs)
-	ab.setAddrs(p, addrs, ttl, ttlExtend, false)
+	addrs, perr := ab.applyPolicies(p, addrs)
+	if len(addrs) > 0 {
+		if addrs = ab.debouncer.Filter(p, addrs, ttl); len(addrs) == 0 {
+			return perr
+		}
+	}
+	if err := ab.setAddrs(p, addrs, ttl, ttlMerge, false); err != nil {
+		ab.debouncer.Forget(p)
+		return err
+	}
+	return perr
 }
 
 // ConsumePeerRecord adds addresses from a signed peer.PeerRecord (contained in
@@ -275,17 +595,31 @@ func (ab *dsAddrBook) ConsumePeerRecord(recordEnvelope *record.Envelope, ttl tim
 		return false, fmt.Errorf("envelope did not contain PeerRecord")
 	}
 	if !rec.PeerID.MatchesPublicKey(recordEnvelope.PublicKey) {
+		ab.auditor.audit(pstore.AuditEvent{Type: pstore.AuditRecordKeyMismatch, Peer: rec.PeerID, Seq: rec.Seq})
 		return false, fmt.Errorf("signing key does not match PeerID in PeerRecord")
 	}
 
 	// ensure that the seq number from envelope is >= any previously received seq no
 	// update when equal to extend the ttls
-	if ab.latestPeerRecordSeq(rec.PeerID) > rec.Seq {
+	if latest := ab.latestPeerRecordSeq(rec.PeerID); latest > rec.Seq {
+		ab.auditor.audit(pstore.AuditEvent{
+			Type:      pstore.AuditRecordStale,
+			Peer:      rec.PeerID,
+			Seq:       rec.Seq,
+			StoredSeq: latest,
+		})
+		log.Debugf("ignored signed peer record %d of peer %s, older than the stored record %d", rec.Seq, rec.PeerID, latest)
 		return false, nil
+	} else if latest > 0 {
+		log.Debugf("replacing signed peer record %d of peer %s with record %d", latest, rec.PeerID, rec.Seq)
 	}
 
-	addrs := cleanAddrs(rec.Addrs)
-	err = ab.setAddrs(rec.PeerID, addrs, ttl, ttlExtend, true)
+	// the addresses of records are subject to the same policies as added ones; the record is kept as signed.
+	addrs, perr := ab.applyPolicies(rec.PeerID, rec.Addrs)
+	if perr != nil {
+		log.Debugf("dropped addresses of signed peer record %d of peer %s: %s", rec.Seq, rec.PeerID, perr)
+	}
+	err = ab.setAddrs(rec.PeerID, addrs, ttl, ttlMerge, true)
 	if err != nil {
 		return false, err
 	}
@@ -325,8 +659,13 @@ func (ab *dsAddrBook) storeSignedPeerRecord(p peer.ID, envelope *record.Envelope
 		Raw: envelopeBytes,
 	}
 	pr.dirty = true
-	err = pr.flush(ab.ds)
-	return err
+	if err = ab.records.flush(ab.ds, pr); err != nil {
+		return err
+	}
+	ab.indexRecord(pr)
+	ab.changes.mark(ab.ds, p)
+	ab.gens.Bump(p)
+	return nil
 }
 
 // GetPeerRecord returns a record.Envelope containing a peer.PeerRecord for the
@@ -358,17 +697,56 @@ func (ab *dsAddrBook) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
 
 // SetAddrs will add or update the TTLs of addresses in the AddrBook.
 func (ab *dsAddrBook) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
-	addrs = cleanAddrs(addrs)
+	if err := ab.SetAddrsE(p, addrs, ttl); err != nil {
+		log.Errorf("failed to set addresses for peer %s: %s", p.Pretty(), err)
+	}
+}
+
+// SetAddrsE is like SetAddrs, but returns an error if the addresses could not be persisted,
+// pstore.ErrP2PAddrMismatch if some were rejected by the /p2p address policy, the reason of the first rejected by the
+// exotic address policy, pstore.ErrAddrTooLong if some were longer than Options.MaxAddrLen, or a *pstore.ArgumentError
+// if Options.StrictChecks is set and rejects the arguments.
+func (ab *dsAddrBook) SetAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	if err := ab.strict.SetAddrs(p, addrs, ttl); err != nil {
+		return err
+	}
+	addrs, perr := ab.applyPolicies(p, addrs)
+	var err error
 	if ttl <= 0 {
-		ab.deleteAddrs(p, addrs)
-		return
+		err = ab.deleteAddrs(p, addrs)
+	} else {
+		err = ab.setAddrs(p, addrs, ttl, ttlOverride, false)
+	}
+	ab.debouncer.Forget(p)
+	if err != nil {
+		return err
 	}
-	ab.setAddrs(p, addrs, ttl, ttlOverride, false)
+	return perr
+}
+
+// applyPolicies drops the nil addresses of addrs and applies the length cap and the /p2p and exotic address policies,
+// returning the addresses to store and the reason the first rejected one was rejected, if any.
+func (ab *dsAddrBook) applyPolicies(p peer.ID, addrs []ma.Multiaddr) ([]ma.Multiaddr, error) {
+	addrs, lerr := ab.lengthCap.Filter(cleanAddrs(addrs))
+	addrs, perr := ab.opts.P2PAddrPolicy.ApplyAll(p, addrs)
+	addrs, eerr := ab.opts.ExoticAddrPolicy.ApplyAll(addrs)
+	for _, err := range []error{lerr, perr, eerr} {
+		if err != nil {
+			return addrs, err
+		}
+	}
+	return addrs, nil
 }
 
 // UpdateAddrs will update any addresses for a given peer and TTL combination to
 // have a new TTL.
 func (ab *dsAddrBook) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
+	if err := ab.strict.UpdateAddrs(p, oldTTL, newTTL); err != nil {
+		log.Errorf("failed to update ttls: %s", err)
+		return
+	}
+	// addresses added with a TTL above the clamp hold the clamped TTL.
+	oldTTL, newTTL = pstore.ClampTTL(oldTTL, ab.opts.MaxAddrTTL), pstore.ClampTTL(newTTL, ab.opts.MaxAddrTTL)
 	pr, err := ab.loadRecord(p, true, false)
 	if err != nil {
 		log.Errorf("failed to update ttls for peer %s: %s\n", p.Pretty(), err)
@@ -378,42 +756,398 @@ func (ab *dsAddrBook) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.D
 	pr.Lock()
 	defer pr.Unlock()
 
-	newExp := time.Now().Add(newTTL).Unix()
+	now := ab.clock.Now()
+	survivors := pr.Addrs[:0]
 	for _, entry := range pr.Addrs {
-		if entry.Ttl != int64(oldTTL) {
-			continue
+		// addresses added with a TTL above the cap of the exotic address policy hold the capped TTL.
+		if entry.Ttl == int64(ab.opts.ExoticAddrPolicy.TTL(entry.Addr.Multiaddr, oldTTL)) {
+			pr.dirty = true
+			if newTTL <= 0 {
+				// drop the address rather than letting it expire, as protected peers retain expired addresses.
+				ab.history.record(p, entry.Addr.Multiaddr, pstore.AddrRemoved, now)
+				continue
+			}
+			ttl := ab.opts.ExoticAddrPolicy.TTL(entry.Addr.Multiaddr, newTTL)
+			entry.Ttl, entry.Expiry = int64(ttl), ab.jitter.Expiry(now, ttl).Unix()
 		}
-		entry.Ttl, entry.Expiry = int64(newTTL), newExp
-		pr.dirty = true
+		survivors = append(survivors, entry)
 	}
+	pr.Addrs = survivors
 
-	if pr.clean() {
-		pr.flush(ab.ds)
+	if ab.cleanRecord(pr) {
+		if err := ab.records.flush(ab.ds, pr); err == nil {
+			ab.indexRecord(pr)
+			ab.changes.mark(ab.ds, p)
+			ab.gens.Bump(p)
+		}
 	}
+	ab.debouncer.Forget(p)
 }
 
 // Addrs returns all of the non-expired addresses for a given peer.
 func (ab *dsAddrBook) Addrs(p peer.ID) []ma.Multiaddr {
 	pr, err := ab.loadRecord(p, true, true)
 	if err != nil {
-		log.Warn("failed to load peerstore entry for peer %v while querying addrs, err: %v", p, err)
+		log.Warnf("failed to load peerstore entry for peer %v while querying addrs, err: %v", p, err)
 		return nil
 	}
 
 	pr.RLock()
 	defer pr.RUnlock()
 
-	addrs := make([]ma.Multiaddr, len(pr.Addrs))
-	for i, a := range pr.Addrs {
+	entries := ab.sortEntries(pr.Addrs)
+	addrs := make([]ma.Multiaddr, len(entries))
+	for i, a := range entries {
 		addrs[i] = a.Addr
 	}
+	if ab.opts.AddrAliases {
+		addrs = addr.DedupAliases(addrs)
+	}
 	return addrs
 }
 
+// AddrsWithExpiry returns all of the non-expired addresses for a given peer, along with their TTLs and expiry times.
+func (ab *dsAddrBook) AddrsWithExpiry(p peer.ID) []pstore.ExpiringAddr {
+	pr, err := ab.loadRecord(p, true, true)
+	if err != nil {
+		log.Warnf("failed to load peerstore entry for peer %v while querying addrs, err: %v", p, err)
+		return nil
+	}
+
+	pr.RLock()
+	defer pr.RUnlock()
+
+	entries := ab.sortEntries(pr.Addrs)
+	res := make([]pstore.ExpiringAddr, len(entries))
+	for i, a := range entries {
+		res[i] = pstore.ExpiringAddr{Addr: a.Addr, TTL: time.Duration(a.Ttl), Expires: time.Unix(a.Expiry, 0)}
+	}
+	return res
+}
+
+// sortEntries returns the entries of a record in the order set by Options.AddrOrder, copying them unless the order is
+// AddrOrderNone, so that the record itself stays sorted by expiry.
+func (ab *dsAddrBook) sortEntries(entries []*pb.AddrBookRecord_AddrEntry) []*pb.AddrBookRecord_AddrEntry {
+	order := ab.opts.AddrOrder
+	if order == pstore.AddrOrderNone {
+		return entries
+	}
+	sorted := append([]*pb.AddrBookRecord_AddrEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return order.Less(sorted[i].Addr.Multiaddr, sorted[i].Added, sorted[j].Addr.Multiaddr, sorted[j].Added)
+	})
+	return sorted
+}
+
+// nextAdded returns the position in the insertion order of the next address added to a record.
+func nextAdded(entries []*pb.AddrBookRecord_AddrEntry) uint64 {
+	var next uint64
+	for _, e := range entries {
+		if e.Added >= next {
+			next = e.Added + 1
+		}
+	}
+	return next
+}
+
+// ReadOption configures a single read of the address book, see AddrsOpt.
+type ReadOption func(*readOptions)
+
+type readOptions struct {
+	noCache bool
+}
+
+// NoCache makes a read bypass the cache and load the record of the peer from the datastore, for callers that just
+// wrote it from another process sharing the datastore. The cache is left as is: it keeps serving the other reads,
+// possibly stale, until the record is evicted or written to by this process.
+var NoCache ReadOption = func(o *readOptions) { o.noCache = true }
+
+func applyReadOptions(opts []ReadOption) readOptions {
+	var o readOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// AddrsOpt returns the non-expired addresses of a peer like Addrs, configured by opts.
+func (ab *dsAddrBook) AddrsOpt(p peer.ID, opts ...ReadOption) []ma.Multiaddr {
+	if !applyReadOptions(opts).noCache {
+		return ab.Addrs(p)
+	}
+	expiring := ab.AddrsWithExpiryOpt(p, opts...)
+	if expiring == nil {
+		return nil
+	}
+	addrs := make([]ma.Multiaddr, len(expiring))
+	for i, a := range expiring {
+		addrs[i] = a.Addr
+	}
+	if ab.opts.AddrAliases {
+		addrs = addr.DedupAliases(addrs)
+	}
+	return addrs
+}
+
+// AddrsWithExpiryOpt returns the non-expired addresses of a peer, along with their TTLs and expiry times, like
+// AddrsWithExpiry, configured by opts.
+func (ab *dsAddrBook) AddrsWithExpiryOpt(p peer.ID, opts ...ReadOption) []pstore.ExpiringAddr {
+	if !applyReadOptions(opts).noCache {
+		return ab.AddrsWithExpiry(p)
+	}
+	if err := ab.validateID(p); err != nil {
+		log.Warnf("failed to load peerstore entry for peer %v while querying addrs, err: %v", p, err)
+		return nil
+	}
+	res, err := ab.readExpiringAddrs(ab.ds, p)
+	if err != nil {
+		log.Warnf("failed to load peerstore entry for peer %v while querying addrs, err: %v", p, err)
+		return nil
+	}
+	if res == nil {
+		// match AddrsWithExpiry, which never returns nil for valid peers.
+		res = []pstore.ExpiringAddr{}
+	}
+	return res
+}
+
+// readExpiringAddrs reads the non-expired addresses of a peer through r, bypassing the cache, which is written through
+// and thus never ahead of the datastore.
+func (ab *dsAddrBook) readExpiringAddrs(r ds.Read, p peer.ID) ([]pstore.ExpiringAddr, error) {
+	rec := &addrsRecord{AddrBookRecord: new(pb.AddrBookRecord)}
+	if found, err := ab.records.load(r, p, rec); err != nil || !found {
+		return nil, err
+	}
+
+	now := ab.clock.Now().Unix()
+	if ab.IsProtected(p, "") {
+		// protected peers retain their expired addresses.
+		now = math.MinInt64
+	}
+	res := make([]pstore.ExpiringAddr, 0, len(rec.Addrs))
+	for _, a := range ab.sortEntries(rec.Addrs) {
+		if a.Expiry > now {
+			res = append(res, pstore.ExpiringAddr{Addr: a.Addr, TTL: time.Duration(a.Ttl), Expires: time.Unix(a.Expiry, 0)})
+		}
+	}
+	return res, nil
+}
+
+// AddrsByRecency returns the non-expired addresses of a peer, most recently added or confirmed first. Addresses
+// written by versions that didn't track when they were last seen are assumed to have been seen when their TTL was
+// last set.
+func (ab *dsAddrBook) AddrsByRecency(p peer.ID) []pstore.RecentAddr {
+	pr, err := ab.loadRecord(p, true, true)
+	if err != nil {
+		log.Warnf("failed to load peerstore entry for peer %v while querying addrs, err: %v", p, err)
+		return nil
+	}
+
+	pr.RLock()
+	res := make([]pstore.RecentAddr, len(pr.Addrs))
+	for i, a := range pr.Addrs {
+		res[i] = pstore.RecentAddr{Addr: a.Addr, LastSeen: entryLastSeen(a)}
+	}
+	pr.RUnlock()
+
+	pstore.SortByRecency(res)
+	return res
+}
+
+// entryLastSeen returns the time an address was last added or confirmed, assuming it was added with its current TTL
+// for entries written before last seen times were recorded.
+func entryLastSeen(a *pb.AddrBookRecord_AddrEntry) time.Time {
+	if a.LastSeen == 0 {
+		return time.Unix(a.Expiry, 0).Add(-time.Duration(a.Ttl))
+	}
+	return time.Unix(a.LastSeen, 0)
+}
+
+// readRecord returns the record of a peer without disturbing the cache, for bulk readers such as budget checks: the
+// record held in memory if any, see peekRecord, or else the record loaded from the datastore, which isn't cached. The
+// record may hold expired entries, and must only be read, with its lock held.
+func (ab *dsAddrBook) readRecord(p peer.ID) (*addrsRecord, error) {
+	if pr := ab.peekRecord(p); pr != nil {
+		return pr, nil
+	}
+	pr := &addrsRecord{AddrBookRecord: new(pb.AddrBookRecord)}
+	if _, err := ab.records.load(ab.ds, p, pr); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// ExpiringBefore returns the non-expired addresses of every peer that will expire before t. When the expiry index is
+// enabled (see Options.GCExpiryIndex) and seeded, only the peers it reports as expiring before t are loaded;
+// otherwise, every peer with addresses is. Peers whose records are being cleaned by GC at the time of the call may
+// be missing from the result.
+func (ab *dsAddrBook) ExpiringBefore(t time.Time) map[peer.ID][]ma.Multiaddr {
+	var candidates []peer.ID
+	if ab.expiries != nil && ab.expiries.isSeeded() {
+		// the index has second granularity, like the stored expiries.
+		candidates = ab.expiries.before(t.Unix())
+	} else {
+		candidates = ab.PeersWithAddrs()
+	}
+
+	res := make(map[peer.ID][]ma.Multiaddr)
+	for _, p := range candidates {
+		var addrs []ma.Multiaddr
+		for _, a := range ab.AddrsWithExpiry(p) {
+			if a.Expires.Before(t) {
+				addrs = append(addrs, a.Addr)
+			}
+		}
+		if len(addrs) > 0 {
+			res[p] = addrs
+		}
+	}
+	return res
+}
+
+// HasAddrs returns whether a peer has non-expired addresses. Like ForEachAddr, it doesn't decode the record of the
+// peer on a cache miss.
+func (ab *dsAddrBook) HasAddrs(p peer.ID) bool {
+	var found bool
+	err := ab.ForEachAddr(p, func(ma.Multiaddr, time.Time) bool {
+		found = true
+		return false
+	})
+	if err != nil {
+		log.Warnf("failed to check addresses of peer %s: %s", p.Pretty(), err)
+	}
+	return found
+}
+
+// ForEachAddr calls fn for each non-expired address of a peer, soonest expiring first, stopping early if fn
+// returns false. It is a cheaper alternative to Addrs for callers that only consume a few addresses: on a cache
+// miss, protobuf records of the per-peer layout are scanned lazily from the stored bytes instead of being fully
+// decoded, and the cache is left untouched.
+//
+// fn must not call back into the address book.
+func (ab *dsAddrBook) ForEachAddr(p peer.ID, fn func(addr ma.Multiaddr, expiry time.Time) bool) error {
+	return ab.forEachAddr(ab.ds, p, fn)
+}
+
+// FilterKnown splits peers into those with non-expired addresses and the others, each in the order they were passed
+// in. Cached records are checked in memory; the others are read in a single read-only transaction if the datastore
+// supports them, and scanned lazily like ForEachAddr does, leaving the cache untouched.
+func (ab *dsAddrBook) FilterKnown(peers []peer.ID) (known, unknown []peer.ID) {
+	has := make([]bool, len(peers))
+	err := multiRead(ab.ds, func(r ds.Read) error {
+		for i, p := range peers {
+			if ab.validateID(p) != nil {
+				continue
+			}
+			err := ab.forEachAddr(r, p, func(ma.Multiaddr, time.Time) bool {
+				has[i] = true
+				return false
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("failed to check the addresses of peers: %s", err)
+	}
+
+	for i, p := range peers {
+		if has[i] {
+			known = append(known, p)
+		} else {
+			unknown = append(unknown, p)
+		}
+	}
+	return known, unknown
+}
+
+// forEachAddr is ForEachAddr, reading through r on a cache miss.
+func (ab *dsAddrBook) forEachAddr(r ds.Read, p peer.ID, fn func(addr ma.Multiaddr, expiry time.Time) bool) error {
+	if err := ab.validateID(p); err != nil {
+		return err
+	}
+	now := ab.clock.Now().Unix()
+	if ab.IsProtected(p, "") {
+		// protected peers retain their expired addresses.
+		now = math.MinInt64
+	}
+
+	if pr := ab.peekRecord(p); pr != nil {
+		pr.RLock()
+		defer pr.RUnlock()
+		for _, a := range pr.Addrs {
+			if a.Expiry <= now {
+				continue
+			}
+			if !fn(a.Addr, time.Unix(a.Expiry, 0)) {
+				break
+			}
+		}
+		return nil
+	}
+
+	if _, ok := ab.records.(*perPeerStore); !ok {
+		// entries are stored separately in the per-address layout; they have to be loaded, and sorted, in full.
+		rec := &addrsRecord{AddrBookRecord: new(pb.AddrBookRecord)}
+		if _, err := ab.records.load(r, p, rec); err != nil {
+			return err
+		}
+		for _, a := range rec.Addrs {
+			if a.Expiry > now && !fn(a.Addr, time.Unix(a.Expiry, 0)) {
+				break
+			}
+		}
+		return nil
+	}
+
+	key := addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(p)))
+	data, err := r.Get(key)
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return nil
+	default:
+		return err
+	}
+
+	if len(data) > 0 && data[0] == recordHeaderMagic {
+		// tagged record; we can only scan untagged protobuf records lazily.
+		rec := new(pb.AddrBookRecord)
+		if err := decodeRecord(data, rec); err != nil {
+			return err
+		}
+		for _, a := range rec.Addrs {
+			if a.Expiry > now && !fn(a.Addr, time.Unix(a.Expiry, 0)) {
+				break
+			}
+		}
+		return nil
+	}
+
+	var ierr error
+	err = pb.ForEachAddrEntry(data, func(b []byte, expiry int64, _ int64) bool {
+		if expiry <= now {
+			return true
+		}
+		addr, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			ierr = err
+			return false
+		}
+		return fn(addr, time.Unix(expiry, 0))
+	})
+	if err == nil {
+		err = ierr
+	}
+	return err
+}
+
 // Peers returns all of the peer IDs for which the AddrBook has addresses.
 func (ab *dsAddrBook) PeersWithAddrs() peer.IDSlice {
-	ids, err := uniquePeerIds(ab.ds, addrBookBase, func(result query.Result) string {
-		return ds.RawKey(result.Key).Name()
+	ids, err := uniquePeerIds(ab.ds, ab.records.base(), ab.corrupt, ab.validateID, func(result query.Result) string {
+		return ab.records.peerName(ds.RawKey(result.Key))
 	})
 	if err != nil {
 		log.Errorf("error while retrieving peers with addresses: %v", err)
@@ -430,25 +1164,108 @@ func (ab *dsAddrBook) AddrStream(ctx context.Context, p peer.ID) <-chan ma.Multi
 
 // ClearAddrs will delete all known addresses for a peer ID.
 func (ab *dsAddrBook) ClearAddrs(p peer.ID) {
-	if err := p.Validate(); err != nil {
+	if err := ab.validateID(p); err != nil {
 		// nothing to do
 		return
 	}
+	if err := ab.ClearAddrsE(p); err != nil {
+		log.Errorf("failed to clear addresses for peer %s: %v", p.Pretty(), err)
+	}
+}
+
+// ClearAddrsE is like ClearAddrs, but returns an error if the peer ID is invalid, or if the addresses could not be
+// removed from the datastore.
+func (ab *dsAddrBook) ClearAddrsE(p peer.ID) error {
+	if err := ab.validateID(p); err != nil {
+		return err
+	}
+
+	if err := ab.clearAddrs(ab.ds, ab.ds, p); err != nil {
+		return err
+	}
+	ab.gens.Bump(p)
+	return nil
+}
+
+// peekRecord returns the record of p held in memory, cached or with queued refreshes, if any, without updating the
+// recency of the cache.
+func (ab *dsAddrBook) peekRecord(p peer.ID) *addrsRecord {
+	if e, ok := ab.cache.Peek(p); ok {
+		return e.(*addrsRecord)
+	}
+	return ab.refreshes.get(p)
+}
 
+// clearAddrs deletes the address record of a peer, listed through r, through w.
+func (ab *dsAddrBook) clearAddrs(r ds.Read, w ds.Write, p peer.ID) error {
+	if ab.history != nil {
+		ab.recordCleared(r, p)
+	}
+	ab.refreshes.forget(p)
 	ab.cache.Remove(p)
+	ab.debouncer.Forget(p)
+	if ab.expiries != nil {
+		ab.expiries.remove(p)
+	}
+	if err := ab.records.remove(r, w, p); err != nil {
+		return err
+	}
+	ab.changes.mark(w, p)
+	return nil
+}
 
-	key := addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(p)))
-	if err := ab.ds.Delete(key); err != nil {
-		log.Errorf("failed to clear addresses for peer %s: %v", p.Pretty(), err)
+// recordCleared records the removal of the addresses of a peer about to be cleared, listed through r, or their expiry
+// if they had expired.
+func (ab *dsAddrBook) recordCleared(r ds.Read, p peer.ID) {
+	pr := ab.peekRecord(p)
+	if pr == nil {
+		pr = &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}}
+		if _, err := ab.records.load(r, p, pr); err != nil {
+			log.Warnf("failed to load the addresses of peer %s to record their removal: %s", p.Pretty(), err)
+			return
+		}
 	}
+	pr.RLock()
+	defer pr.RUnlock()
+	now := ab.clock.Now()
+	for _, e := range pr.Addrs {
+		if e.Expiry <= now.Unix() {
+			ab.history.record(p, e.Addr.Multiaddr, pstore.AddrExpired, time.Unix(e.Expiry, 0))
+		} else {
+			ab.history.record(p, e.Addr.Multiaddr, pstore.AddrRemoved, now)
+		}
+	}
+}
+
+// AddrHistory returns the address events of a peer timed at or after since, oldest first, if Options.AddrHistorySize
+// is set. Events are rolled up into the datastore periodically, see Options.AddrHistorySize.
+func (ab *dsAddrBook) AddrHistory(p peer.ID, since time.Time) []pstore.AddrEvent {
+	return ab.history.history(p, since)
+}
+
+// AddrSubManager returns the manager of the address streams of the address book.
+func (ab *dsAddrBook) AddrSubManager() *pstoremem.AddrSubManager {
+	return ab.subsManager
 }
 
 func (ab *dsAddrBook) setAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, mode ttlWriteMode, signed bool) (err error) {
+	if err := ab.validateID(p); err != nil {
+		return err
+	}
+	ttl = pstore.ClampTTL(ttl, ab.opts.MaxAddrTTL)
 	pr, err := ab.loadRecord(p, true, false)
 	if err != nil {
 		return fmt.Errorf("failed to load peerstore entry for peer %v while setting addrs, err: %v", p, err)
 	}
 
+	// registered before the unlock, so that it runs after it: the refresh queue locks records while flushing them.
+	var deferred bool
+	defer func() {
+		if deferred {
+			ab.refreshes.add(p, pr)
+		}
+	}()
+
 	pr.Lock()
 	defer pr.Unlock()
 
@@ -457,37 +1274,44 @@ func (ab *dsAddrBook) setAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duratio
 	// 	return nil
 	// }
 
-	newExp := time.Now().Add(ttl).Unix()
+	now := ab.clock.Now()
 	// TODO this is very inefficient O(m*n); we could build a map to use as an
 	// index, and test against it. That would turn it into O(m+n). This code
 	// will be refactored entirely anyway, and it's not being used by users
 	// (that we know of); so OK to keep it for now.
-	updateExisting := func(entryList []*pb.AddrBookRecord_AddrEntry, incoming ma.Multiaddr) *pb.AddrBookRecord_AddrEntry {
+	updateExisting := func(entryList []*pb.AddrBookRecord_AddrEntry, incoming ma.Multiaddr, ttl time.Duration, newExp int64) *pb.AddrBookRecord_AddrEntry {
 		for _, have := range entryList {
 			if incoming.Equal(have.Addr) {
 				switch mode {
 				case ttlOverride:
 					have.Ttl = int64(ttl)
 					have.Expiry = newExp
-				case ttlExtend:
-					if int64(ttl) > have.Ttl {
-						have.Ttl = int64(ttl)
-					}
-					if newExp > have.Expiry {
-						have.Expiry = newExp
-					}
+				case ttlMerge:
+					merged := ab.ttlPolicy(
+						pstore.ExpiringAddr{Addr: incoming, TTL: time.Duration(have.Ttl), Expires: time.Unix(have.Expiry, 0)},
+						pstore.ExpiringAddr{Addr: incoming, TTL: ttl, Expires: time.Unix(newExp, 0)},
+					)
+					have.Ttl, have.Expiry = int64(merged.TTL), merged.Expires.Unix()
 				default:
 					panic("BUG: unimplemented ttl mode")
 				}
+				have.LastSeen = now.Unix()
 				return have
 			}
 		}
 		return nil
 	}
 
-	var entries []*pb.AddrBookRecord_AddrEntry
+	next := nextAdded(pr.Addrs)
+	var entries, touched []*pb.AddrBookRecord_AddrEntry
 	for _, incoming := range addrs {
-		existingEntry := updateExisting(pr.Addrs, incoming)
+		// the exotic address policy caps the TTL of some addresses.
+		ttl := ab.opts.ExoticAddrPolicy.TTL(incoming, ttl)
+		newExp := ab.jitter.Expiry(now, ttl).Unix()
+		existingEntry := updateExisting(pr.Addrs, incoming, ttl, newExp)
+		if existingEntry != nil {
+			touched = append(touched, existingEntry)
+		}
 
 		if existingEntry == nil {
 			// 	if signed {
@@ -496,11 +1320,16 @@ func (ab *dsAddrBook) setAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duratio
 			// } else {
 			// new addr, add & broadcast
 			entry := &pb.AddrBookRecord_AddrEntry{
-				Addr:   &pb.ProtoAddr{Multiaddr: incoming},
-				Ttl:    int64(ttl),
-				Expiry: newExp,
+				Addr:     &pb.ProtoAddr{Multiaddr: incoming},
+				Ttl:      int64(ttl),
+				Expiry:   newExp,
+				LastSeen: now.Unix(),
+				Added:    next,
 			}
+			next++
 			entries = append(entries, entry)
+			touched = append(touched, entry)
+			ab.history.record(p, incoming, pstore.AddrAdded, now)
 
 			// note: there's a minor chance that writing the record will fail, in which case we would've broadcast
 			// the addresses without persisting them. This is very unlikely and not much of an issue.
@@ -515,9 +1344,37 @@ func (ab *dsAddrBook) setAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duratio
 	pr.Addrs = append(pr.Addrs, entries...)
 	// }
 
+	if ab.opts.AddrAliases {
+		syncAliases(pr.Addrs, touched)
+	}
+
 	pr.dirty = true
-	pr.clean()
-	return pr.flush(ab.ds)
+	ab.cleanRecord(pr)
+	if ab.refreshes != nil && mode == ttlMerge && !signed && len(entries) == 0 && len(touched) > 0 {
+		// only the TTLs of addresses already present changed; leave the write to the next refresh flush.
+		pr.dirty = false
+		deferred = true
+		ab.gens.Bump(p)
+		return nil
+	}
+	if err = ab.records.flush(ab.ds, pr); err != nil {
+		return err
+	}
+	ab.indexRecord(pr)
+	ab.changes.mark(ab.ds, p)
+	ab.gens.Bump(p)
+	return nil
+}
+
+// syncAliases copies the TTL, expiry and last seen time of the touched entries to their aliases.
+func syncAliases(entries, touched []*pb.AddrBookRecord_AddrEntry) {
+	for _, t := range touched {
+		for _, e := range entries {
+			if addr.IsAlias(e.Addr.Multiaddr, t.Addr.Multiaddr) {
+				e.Ttl, e.Expiry, e.LastSeen = t.Ttl, t.Expiry, t.LastSeen
+			}
+		}
+	}
 }
 
 // deletes addresses in place, avoiding copies until we encounter the first deletion.
@@ -528,20 +1385,17 @@ func deleteInPlace(s []*pb.AddrBookRecord_AddrEntry, addrs []ma.Multiaddr) []*pb
 	}
 	survived := len(s)
 Outer:
-	for i, addr := range s {
+	for i := 0; i < survived; {
 		for _, del := range addrs {
-			if !addr.Addr.Equal(del) {
+			if !s[i].Addr.Equal(del) {
 				continue
 			}
 			survived--
-			// if there are no survivors, bail out
-			if survived == 0 {
-				break Outer
-			}
+			// replace s[i] with the last survivor, which has yet to be checked.
 			s[i] = s[survived]
-			// we've already dealt with s[i], move to the next
 			continue Outer
 		}
+		i++
 	}
 	return s[:survived]
 }
@@ -559,11 +1413,27 @@ func (ab *dsAddrBook) deleteAddrs(p peer.ID, addrs []ma.Multiaddr) (err error) {
 	pr.Lock()
 	defer pr.Unlock()
 
+	if ab.history != nil {
+		now := ab.clock.Now()
+		for _, e := range pr.Addrs {
+			for _, a := range addrs {
+				if e.Addr.Equal(a) {
+					ab.history.record(p, a, pstore.AddrRemoved, now)
+				}
+			}
+		}
+	}
 	pr.Addrs = deleteInPlace(pr.Addrs, addrs)
 
 	pr.dirty = true
-	pr.clean()
-	return pr.flush(ab.ds)
+	ab.cleanRecord(pr)
+	if err = ab.records.flush(ab.ds, pr); err != nil {
+		return err
+	}
+	ab.indexRecord(pr)
+	ab.changes.mark(ab.ds, p)
+	ab.gens.Bump(p)
+	return nil
 }
 
 func cleanAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
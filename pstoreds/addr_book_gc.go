@@ -3,7 +3,10 @@ package pstoreds
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ds "github.com/ipfs/go-datastore"
@@ -29,19 +32,55 @@ var (
 		KeysOnly: true,
 	}
 
+	// the prefix of the following queries is the base of the address layout in use.
 	purgeStoreQuery = query.Query{
-		Prefix:   addrBookBase.String(),
 		Orders:   []query.Order{query.OrderByKey{}},
 		KeysOnly: false,
 	}
 
 	populateLookaheadQuery = query.Query{
-		Prefix:   addrBookBase.String(),
 		Orders:   []query.Order{query.OrderByKey{}},
 		KeysOnly: true,
 	}
 )
 
+// GCAdaptivePolicy adapts the interval between GC purge cycles to churn: cycles that purge no records double the
+// interval, up to MaxInterval, and cycles that purge at least HighChurn records halve it, down to MinInterval. Other
+// cycles keep it unchanged. The zero value disables adaptation.
+type GCAdaptivePolicy struct {
+	MinInterval time.Duration
+	MaxInterval time.Duration
+
+	// Number of records purged in a cycle at or above which the interval is halved. Defaults to 1 when zero, so that
+	// the interval only grows while cycles find nothing to purge.
+	HighChurn int
+}
+
+func (p GCAdaptivePolicy) enabled() bool {
+	return p.MinInterval > 0 || p.MaxInterval > 0
+}
+
+// next returns the interval following a cycle that ran after interval curr and purged the given number of records.
+func (p GCAdaptivePolicy) next(curr time.Duration, purged uint64) time.Duration {
+	high := uint64(1)
+	if p.HighChurn > 0 {
+		high = uint64(p.HighChurn)
+	}
+	switch {
+	case purged == 0:
+		curr *= 2
+	case purged >= high:
+		curr /= 2
+	}
+	if curr < p.MinInterval {
+		curr = p.MinInterval
+	}
+	if curr > p.MaxInterval {
+		curr = p.MaxInterval
+	}
+	return curr
+}
+
 // dsAddrBookGc is responsible for garbage collection in a datastore-backed address book.
 type dsAddrBookGc struct {
 	ctx              context.Context
@@ -66,6 +105,20 @@ func newAddressBookGc(ctx context.Context, ab *dsAddrBook) (*dsAddrBookGc, error
 		return nil, fmt.Errorf("lookahead interval must be larger than purge interval, respectively: %s, %s",
 			ab.opts.GCLookaheadInterval, ab.opts.GCPurgeInterval)
 	}
+	if ab.opts.GCLookaheadInterval > 0 && ab.opts.GCExpiryIndex {
+		return nil, fmt.Errorf("expiry index cannot be combined with lookahead GC")
+	}
+	if adaptive := ab.opts.GCAdaptive; adaptive.enabled() {
+		if adaptive.MinInterval <= 0 || adaptive.MinInterval > ab.opts.GCPurgeInterval ||
+			adaptive.MaxInterval < ab.opts.GCPurgeInterval {
+			return nil, fmt.Errorf("adaptive GC bounds must surround the purge interval, respectively: %s, %s, %s",
+				adaptive.MinInterval, adaptive.MaxInterval, ab.opts.GCPurgeInterval)
+		}
+		if ab.opts.GCLookaheadInterval > 0 && adaptive.MaxInterval > ab.opts.GCLookaheadInterval {
+			return nil, fmt.Errorf("lookahead interval must be larger than the adaptive GC max interval, respectively: %s, %s",
+				ab.opts.GCLookaheadInterval, adaptive.MaxInterval)
+		}
+	}
 
 	lookaheadEnabled := ab.opts.GCLookaheadInterval > 0
 	gc := &dsAddrBookGc{
@@ -75,14 +128,18 @@ func newAddressBookGc(ctx context.Context, ab *dsAddrBook) (*dsAddrBookGc, error
 		lookaheadEnabled: lookaheadEnabled,
 	}
 
-	if lookaheadEnabled {
+	switch {
+	case lookaheadEnabled:
 		gc.purgeFunc = gc.purgeLookahead
-	} else {
+	case ab.expiries != nil:
+		gc.purgeFunc = gc.purgeIndexed
+	default:
 		gc.purgeFunc = gc.purgeStore
 	}
 
 	// do not start GC timers if purge is disabled; this GC can only be triggered manually.
 	if ab.opts.GCPurgeInterval > 0 {
+		atomic.StoreInt64(&ab.gcInterval, int64(ab.opts.GCPurgeInterval))
 		gc.ab.childrenDone.Add(1)
 		go gc.background()
 	}
@@ -101,7 +158,8 @@ func (gc *dsAddrBookGc) background() {
 		return
 	}
 
-	purgeTimer := time.NewTicker(gc.ab.opts.GCPurgeInterval)
+	interval := gc.ab.opts.GCPurgeInterval
+	purgeTimer := time.NewTimer(interval)
 	defer purgeTimer.Stop()
 
 	var lookaheadCh <-chan time.Time
@@ -115,7 +173,16 @@ func (gc *dsAddrBookGc) background() {
 	for {
 		select {
 		case <-purgeTimer.C:
+			start := time.Now()
+			visits, purges := atomic.LoadUint64(&gc.ab.gcVisits), atomic.LoadUint64(&gc.ab.gcPurges)
 			gc.purgeFunc()
+			visits, purges = atomic.LoadUint64(&gc.ab.gcVisits)-visits, atomic.LoadUint64(&gc.ab.gcPurges)-purges
+			if adaptive := gc.ab.opts.GCAdaptive; adaptive.enabled() {
+				interval = adaptive.next(interval, purges)
+				atomic.StoreInt64(&gc.ab.gcInterval, int64(interval))
+			}
+			log.Debugf("GC cycle visited %d records and purged %d in %s; next cycle in %s", visits, purges, time.Since(start), interval)
+			purgeTimer.Reset(interval)
 
 		case <-lookaheadCh:
 			// will never trigger if lookahead is disabled (nil Duration).
@@ -180,7 +247,7 @@ func (gc *dsAddrBookGc) purgeLookahead() {
 	}
 	defer results.Close()
 
-	now := time.Now().Unix()
+	now := gc.ab.clock.Now().Unix()
 
 	// keys: 	/peers/gc/addrs/<unix timestamp of next visit>/<peer ID b32>
 	// values: 	nil
@@ -189,7 +256,7 @@ func (gc *dsAddrBookGc) purgeLookahead() {
 		ts, err := strconv.ParseInt(gcKey.Parent().Name(), 10, 64)
 		if err != nil {
 			dropInError(gcKey, err, "parsing timestamp")
-			log.Warnf("failed while parsing timestamp from key: %v, err: %v", result.Key, err)
+			gc.ab.corrupt.report(gcKey, err)
 			continue
 		} else if ts > now {
 			// this is an ordered cursor; when we hit an entry with a timestamp beyond now, we can break.
@@ -199,23 +266,25 @@ func (gc *dsAddrBookGc) purgeLookahead() {
 		idb32, err := b32.RawStdEncoding.DecodeString(gcKey.Name())
 		if err != nil {
 			dropInError(gcKey, err, "parsing peer ID")
-			log.Warnf("failed while parsing b32 peer ID from key: %v, err: %v", result.Key, err)
+			gc.ab.corrupt.report(gcKey, err)
 			continue
 		}
 
 		id, err = peer.IDFromBytes(idb32)
 		if err != nil {
 			dropInError(gcKey, err, "decoding peer ID")
-			log.Warnf("failed while decoding peer ID from key: %v, err: %v", result.Key, err)
+			gc.ab.corrupt.report(gcKey, err)
 			continue
 		}
 
+		atomic.AddUint64(&gc.ab.gcVisits, 1)
+
 		// if the record is in cache, we clean it and flush it if necessary.
-		if e, ok := gc.ab.cache.Peek(id); ok {
-			cached := e.(*addrsRecord)
+		if cached := gc.ab.peekRecord(id); cached != nil {
 			cached.Lock()
-			if cached.clean() {
-				if err = cached.flush(batch); err != nil {
+			if gc.ab.cleanRecord(cached) {
+				atomic.AddUint64(&gc.ab.gcPurges, 1)
+				if err = gc.ab.records.flush(batch, cached); err != nil {
 					log.Warnf("failed to flush entry modified by GC for peer: &v, err: %v", id.Pretty(), err)
 				}
 			}
@@ -227,20 +296,21 @@ func (gc *dsAddrBookGc) purgeLookahead() {
 		record.Reset()
 
 		// otherwise, fetch it from the store, clean it and flush it.
-		entryKey := addrBookBase.ChildString(gcKey.Name())
-		val, err := gc.ab.ds.Get(entryKey)
-		if err != nil {
-			// captures all errors, including ErrNotFound.
-			dropInError(gcKey, err, "fetching entry")
-			continue
-		}
-		err = record.Unmarshal(val)
-		if err != nil {
+		found, err := gc.ab.records.load(gc.ab.ds, id, record)
+		if cerr, ok := err.(*corruptError); ok {
 			dropInError(gcKey, err, "unmarshalling entry")
+			gc.ab.corrupt.report(cerr.key, cerr.err)
+			continue
+		} else if err != nil || !found {
+			if err == nil {
+				err = ds.ErrNotFound
+			}
+			dropInError(gcKey, err, "fetching entry")
 			continue
 		}
-		if record.clean() {
-			err = record.flush(batch)
+		if gc.ab.cleanRecord(record) {
+			atomic.AddUint64(&gc.ab.gcPurges, 1)
+			err = gc.ab.records.flush(batch, record)
 			if err != nil {
 				log.Warnf("failed to flush entry modified by GC for peer: &v, err: %v", id.Pretty(), err)
 			}
@@ -262,33 +332,106 @@ func (gc *dsAddrBookGc) purgeStore() {
 		return
 	}
 
-	record := &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}} // empty record to reuse and avoid allocs.
-	batch, err := newCyclicBatch(gc.ab.ds, defaultOpsPerCyclicBatch)
-	if err != nil {
-		log.Warnf("failed while creating batch to purge GC entries: %v", err)
-	}
-
-	results, err := gc.ab.ds.Query(purgeStoreQuery)
+	q := purgeStoreQuery
+	q.Prefix = gc.ab.records.base().String()
+	results, err := gc.ab.ds.Query(q)
 	if err != nil {
 		log.Warnf("failed while opening iterator: %v", err)
 		return
 	}
 	defer results.Close()
 
+	// go-datastore offers no range queries, so we walk the store with a single cursor and fan records out to
+	// workers. Each worker owns a disjoint shard of the key space, so no two workers ever touch the same record.
+	workers := gc.ab.opts.GCConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		wg     sync.WaitGroup
+		shards = make([]chan []query.Entry, workers)
+	)
+	for i := range shards {
+		shards[i] = make(chan []query.Entry, 16)
+		wg.Add(1)
+		go func(in <-chan []query.Entry) {
+			defer wg.Done()
+			gc.purgeStoreShard(in)
+		}(shards[i])
+	}
+
 	// keys: 	/peers/addrs/<peer ID b32>
+	// or, in the per-address layout, where the entries of a peer are contiguous:
+	//		/peers/addrkeys/<peer ID b32>/<entry>
+	var (
+		name  string
+		group []query.Entry
+	)
+	dispatch := func() {
+		if len(group) == 0 {
+			return
+		}
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(name))
+		shards[h.Sum32()%uint32(workers)] <- group
+		group = nil
+	}
 	for result := range results.Next() {
+		if result.Error != nil {
+			log.Warnf("failed while iterating over entries to purge: %v", result.Error)
+			continue
+		}
+		if n := gc.ab.records.peerName(ds.RawKey(result.Key)); n != name {
+			dispatch()
+			name = n
+		}
+		group = append(group, result.Entry)
+	}
+	dispatch()
+
+	for _, ch := range shards {
+		close(ch)
+	}
+	wg.Wait()
+
+	if gc.ab.expiries != nil {
+		gc.ab.expiries.markSeeded()
+	}
+}
+
+// purgeStoreShard cleans and flushes the records it receives, each as the entries of a peer, committing the changes in
+// its own batch.
+func (gc *dsAddrBookGc) purgeStoreShard(in <-chan []query.Entry) {
+	record := &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}} // empty record to reuse and avoid allocs.
+	batch, err := newCyclicBatch(gc.ab.ds, defaultOpsPerCyclicBatch)
+	if err != nil {
+		log.Warnf("failed while creating batch to purge GC entries: %v", err)
+		// drain the input so the dispatcher doesn't block.
+		for range in {
+		}
+		return
+	}
+
+	for entries := range in {
+		atomic.AddUint64(&gc.ab.gcVisits, 1)
+
 		record.Reset()
-		if err = record.Unmarshal(result.Value); err != nil {
-			// TODO log
+		if err = gc.ab.records.decode(entries, record); err != nil {
+			gc.ab.corrupt.report(ds.RawKey(entries[0].Key), err)
 			continue
 		}
 
 		id := record.Id.ID
-		if !record.clean() {
+		changed := gc.ab.cleanRecord(record)
+		if gc.ab.expiries != nil && len(record.Addrs) > 0 {
+			gc.ab.expiries.seed(id, record.Addrs[0].Expiry)
+		}
+		if !changed {
 			continue
 		}
+		atomic.AddUint64(&gc.ab.gcPurges, 1)
 
-		if err := record.flush(batch); err != nil {
+		if err := gc.ab.records.flush(batch, record); err != nil {
 			log.Warnf("failed to flush entry modified by GC for peer: &v, err: %v", id, err)
 		}
 		gc.ab.cache.Remove(id)
@@ -299,6 +442,74 @@ func (gc *dsAddrBookGc) purgeStore() {
 	}
 }
 
+// purgeIndexed visits only the peers whose soonest expiry has passed according to the expiry index. Until the index
+// has been seeded, it falls back to a full store purge, which seeds it.
+func (gc *dsAddrBookGc) purgeIndexed() {
+	if !gc.ab.expiries.isSeeded() {
+		gc.purgeStore()
+		return
+	}
+
+	select {
+	case gc.running <- struct{}{}:
+		defer func() { <-gc.running }()
+	default:
+		// yield if something's running.
+		return
+	}
+
+	record := &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}} // empty record to reuse and avoid allocs.
+	batch, err := newCyclicBatch(gc.ab.ds, defaultOpsPerCyclicBatch)
+	if err != nil {
+		log.Warnf("failed while creating batch to purge GC entries: %v", err)
+		return
+	}
+
+	for _, id := range gc.ab.expiries.due(gc.ab.clock.Now().Unix()) {
+		atomic.AddUint64(&gc.ab.gcVisits, 1)
+
+		// if the record is in cache, clean it and flush it. The cached copy may have been cleaned on read without
+		// being written back (see Options.CompactionThreshold), so we flush regardless; the index tells us the
+		// stored copy has expired entries.
+		if cached := gc.ab.peekRecord(id); cached != nil {
+			cached.Lock()
+			gc.ab.cleanRecord(cached)
+			atomic.AddUint64(&gc.ab.gcPurges, 1)
+			if err = gc.ab.records.flush(batch, cached); err != nil {
+				log.Warnf("failed to flush entry modified by GC for peer: %v, err: %v", id.Pretty(), err)
+			}
+			gc.ab.indexRecord(cached)
+			cached.Unlock()
+			continue
+		}
+
+		record.Reset()
+
+		// otherwise, fetch it from the store, clean it and flush it.
+		found, err := gc.ab.records.load(gc.ab.ds, id, record)
+		if cerr, ok := err.(*corruptError); ok {
+			gc.ab.corrupt.report(cerr.key, cerr.err)
+			continue
+		} else if err != nil {
+			log.Warnf("failed while fetching entry to purge for peer: %v, err: %v", id.Pretty(), err)
+			continue
+		} else if !found {
+			continue
+		}
+		if gc.ab.cleanRecord(record) {
+			atomic.AddUint64(&gc.ab.gcPurges, 1)
+			if err = gc.ab.records.flush(batch, record); err != nil {
+				log.Warnf("failed to flush entry modified by GC for peer: %v, err: %v", id.Pretty(), err)
+			}
+		}
+		gc.ab.indexRecord(record)
+	}
+
+	if err = batch.Commit(); err != nil {
+		log.Warnf("failed to commit GC purge batch: %v", err)
+	}
+}
+
 // populateLookahead populates the lookahead window by scanning the entire store and picking entries whose earliest
 // expiration falls within the window period.
 //
@@ -317,11 +528,13 @@ func (gc *dsAddrBookGc) populateLookahead() {
 		return
 	}
 
-	until := time.Now().Add(gc.ab.opts.GCLookaheadInterval).Unix()
+	until := gc.ab.clock.Now().Add(gc.ab.opts.GCLookaheadInterval).Unix()
 
 	var id peer.ID
 	record := &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}}
-	results, err := gc.ab.ds.Query(populateLookaheadQuery)
+	q := populateLookaheadQuery
+	q.Prefix = gc.ab.records.base().String()
+	results, err := gc.ab.ds.Query(q)
 	if err != nil {
 		log.Warnf("failed while querying to populate lookahead GC window: %v", err)
 		return
@@ -334,20 +547,26 @@ func (gc *dsAddrBookGc) populateLookahead() {
 		return
 	}
 
+	var prev string
 	for result := range results.Next() {
-		idb32 := ds.RawKey(result.Key).Name()
+		// in the per-address layout, the keys of a peer are contiguous; visit it once.
+		idb32 := gc.ab.records.peerName(ds.RawKey(result.Key))
+		if idb32 == prev {
+			continue
+		}
+		prev = idb32
 		k, err := b32.RawStdEncoding.DecodeString(idb32)
 		if err != nil {
-			log.Warnf("failed while decoding peer ID from key: %v, err: %v", result.Key, err)
+			gc.ab.corrupt.report(ds.RawKey(result.Key), err)
 			continue
 		}
 		if id, err = peer.IDFromBytes(k); err != nil {
-			log.Warnf("failed while decoding peer ID from key: %v, err: %v", result.Key, err)
+			gc.ab.corrupt.report(ds.RawKey(result.Key), err)
+			continue
 		}
 
 		// if the record is in cache, use the cached version.
-		if e, ok := gc.ab.cache.Peek(id); ok {
-			cached := e.(*addrsRecord)
+		if cached := gc.ab.peekRecord(id); cached != nil {
 			cached.RLock()
 			if len(cached.Addrs) == 0 || cached.Addrs[0].Expiry > until {
 				cached.RUnlock()
@@ -363,13 +582,12 @@ func (gc *dsAddrBookGc) populateLookahead() {
 
 		record.Reset()
 
-		val, err := gc.ab.ds.Get(ds.RawKey(result.Key))
-		if err != nil {
-			log.Warnf("failed which getting record from store for peer: %v, err: %v", id.Pretty(), err)
-			continue
-		}
-		if err := record.Unmarshal(val); err != nil {
-			log.Warnf("failed while unmarshalling record from store for peer: %v, err: %v", id.Pretty(), err)
+		if _, err := gc.ab.records.load(gc.ab.ds, id, record); err != nil {
+			if cerr, ok := err.(*corruptError); ok {
+				gc.ab.corrupt.report(cerr.key, cerr.err)
+			} else {
+				log.Warnf("failed which getting record from store for peer: %v, err: %v", id.Pretty(), err)
+			}
 			continue
 		}
 		if len(record.Addrs) > 0 && record.Addrs[0].Expiry <= until {
@@ -1,10 +1,13 @@
 package pstoreds
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	ds "github.com/ipfs/go-datastore"
 	query "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
 	test "github.com/libp2p/go-libp2p-peerstore/test"
 	ma "github.com/multiformats/go-multiaddr"
@@ -252,3 +255,269 @@ func BenchmarkLookaheadCycle(b *testing.B) {
 		ab.(*dsAddrBook).gc.populateLookahead()
 	}
 }
+
+func TestGCPurgeConcurrent(t *testing.T) {
+	for _, layout := range []AddrLayout{AddrLayoutPerPeer, AddrLayoutPerAddr} {
+		layout := layout
+		t.Run(layout.String(), func(t *testing.T) {
+			testGCPurgeConcurrent(t, layout)
+		})
+	}
+}
+
+func testGCPurgeConcurrent(t *testing.T, layout AddrLayout) {
+	ids := test.GeneratePeerIDs(50)
+	addrs := test.GenerateAddrs(20)
+
+	opts := DefaultOpts()
+
+	// effectively disable automatic GC for this test.
+	opts.GCInitialDelay = 90 * time.Hour
+	opts.GCLookaheadInterval = 0
+	opts.GCPurgeInterval = 9 * time.Hour
+	opts.GCConcurrency = 4
+	opts.AddrLayout = layout
+
+	factory := addressBookFactory(t, badgerStore, opts)
+	ab, closeFn := factory()
+	defer closeFn()
+
+	// even peers: all addresses expire. odd peers: half of them expire.
+	for i, id := range ids {
+		ab.AddAddrs(id, addrs[:10], 500*time.Millisecond)
+		if i%2 == 1 {
+			ab.AddAddrs(id, addrs[10:], 10*time.Hour)
+		}
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	ab.(*dsAddrBook).gc.purgeFunc()
+
+	if n := len(ab.PeersWithAddrs()); n != len(ids)/2 {
+		t.Fatalf("expected %d peers to survive the purge, got %d", len(ids)/2, n)
+	}
+	for i, id := range ids {
+		if i%2 == 1 {
+			test.AssertAddressesEqual(t, addrs[10:], ab.Addrs(id))
+		}
+	}
+}
+
+func TestGCExpiryIndex(t *testing.T) {
+	ids := test.GeneratePeerIDs(25)
+	addrs := test.GenerateAddrs(2)
+
+	opts := DefaultOpts()
+
+	// effectively disable automatic GC for this test.
+	opts.GCInitialDelay = 90 * time.Hour
+	opts.GCLookaheadInterval = 0
+	opts.GCPurgeInterval = 9 * time.Hour
+	opts.GCExpiryIndex = true
+
+	factory := addressBookFactory(t, badgerStore, opts)
+	abi, closeFn := factory()
+	defer closeFn()
+	ab := abi.(*dsAddrBook)
+
+	// the first 5 peers expire soon; the rest stick around.
+	for i, id := range ids {
+		ttl := 10 * time.Hour
+		if i < 5 {
+			ttl = 2 * time.Second
+		}
+		ab.AddAddrs(id, addrs, ttl)
+	}
+
+	// the first cycle seeds the index with a full scan.
+	ab.gc.purgeFunc()
+	if v := ab.Stats().GCVisits; v != 25 {
+		t.Fatalf("expected the seeding cycle to visit all 25 records, got %d", v)
+	}
+
+	time.Sleep(3 * time.Second)
+
+	// subsequent cycles only visit the peers whose addresses expired.
+	ab.gc.purgeFunc()
+	if v := ab.Stats().GCVisits; v != 30 {
+		t.Fatalf("expected the indexed cycle to visit 5 records, got %d", v-25)
+	}
+	for i, id := range ids {
+		if i < 5 {
+			if n := storedAddrCount(t, ab, id); n != 0 {
+				t.Errorf("expected expired record to be purged, got %d stored entries", n)
+			}
+		} else {
+			test.AssertAddressesEqual(t, addrs, ab.Addrs(id))
+		}
+	}
+
+	// nothing is due now.
+	ab.gc.purgeFunc()
+	if v := ab.Stats().GCVisits; v != 30 {
+		t.Fatalf("expected no records to be visited, got %d", v-30)
+	}
+}
+
+func TestGCExpiryIndexWithLookahead(t *testing.T) {
+	opts := DefaultOpts()
+	opts.GCLookaheadInterval = 10 * time.Hour
+	opts.GCExpiryIndex = true
+
+	store, closeFn := badgerStore(t)
+	defer closeFn()
+	if _, err := NewAddrBook(context.Background(), store, opts); err == nil {
+		t.Fatal("expected an error when combining the expiry index with lookahead GC")
+	}
+}
+
+func TestGCProtectedPeers(t *testing.T) {
+	ids := test.GeneratePeerIDs(2)
+	addrs := test.GenerateAddrs(2)
+
+	opts := DefaultOpts()
+
+	// effectively disable automatic GC for this test.
+	opts.GCInitialDelay = 90 * time.Hour
+	opts.GCLookaheadInterval = 0
+	opts.GCPurgeInterval = 9 * time.Hour
+
+	factory := addressBookFactory(t, badgerStore, opts)
+	abi, closeFn := factory()
+	defer closeFn()
+	ab := abi.(*dsAddrBook)
+
+	ab.Protect(ids[0], "test")
+	for _, id := range ids {
+		ab.AddAddrs(id, addrs, time.Second)
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+	ab.gc.purgeFunc()
+
+	if n := storedAddrCount(t, ab, ids[0]); n != 2 {
+		t.Errorf("expected addresses of protected peer to be retained, got %d stored entries", n)
+	}
+	if n := storedAddrCount(t, ab, ids[1]); n != 0 {
+		t.Errorf("expected addresses of unprotected peer to be purged, got %d stored entries", n)
+	}
+
+	ab.Unprotect(ids[0], "test")
+	ab.gc.purgeFunc()
+	if n := storedAddrCount(t, ab, ids[0]); n != 0 {
+		t.Errorf("expected addresses of unprotected peer to be purged, got %d stored entries", n)
+	}
+}
+
+func TestExpiringBeforeIndexed(t *testing.T) {
+	ids := test.GeneratePeerIDs(10)
+	addrs := test.GenerateAddrs(2)
+
+	opts := DefaultOpts()
+	opts.GCInitialDelay = 90 * time.Hour
+	opts.GCPurgeInterval = 9 * time.Hour
+	opts.GCExpiryIndex = true
+
+	factory := addressBookFactory(t, badgerStore, opts)
+	abi, closeFn := factory()
+	defer closeFn()
+	ab := abi.(*dsAddrBook)
+
+	// the first 3 peers have an address expiring within the hour.
+	for i, id := range ids {
+		ab.AddAddr(id, addrs[0], 10*time.Hour)
+		if i < 3 {
+			ab.AddAddr(id, addrs[1], 30*time.Minute)
+		}
+	}
+
+	// seed the index, so that it serves the query.
+	ab.gc.purgeFunc()
+	if !ab.expiries.isSeeded() {
+		t.Fatal("expected the index to be seeded")
+	}
+
+	got := ab.ExpiringBefore(time.Now().Add(time.Hour))
+	if len(got) != 3 {
+		t.Fatalf("expected 3 peers, got %d", len(got))
+	}
+	for _, id := range ids[:3] {
+		test.AssertAddressesEqual(t, addrs[1:], got[id])
+	}
+
+	if got := ab.ExpiringBefore(time.Now().Add(11 * time.Hour)); len(got) != 10 {
+		t.Fatalf("expected 10 peers, got %d", len(got))
+	}
+}
+
+func TestGCAdaptivePolicy(t *testing.T) {
+	p := GCAdaptivePolicy{MinInterval: time.Minute, MaxInterval: time.Hour, HighChurn: 10}
+	for _, tc := range []struct {
+		curr   time.Duration
+		purged uint64
+		want   time.Duration
+	}{
+		{10 * time.Minute, 0, 20 * time.Minute},
+		{40 * time.Minute, 0, time.Hour},
+		{10 * time.Minute, 5, 10 * time.Minute},
+		{10 * time.Minute, 10, 5 * time.Minute},
+		{90 * time.Second, 100, time.Minute},
+	} {
+		if got := p.next(tc.curr, tc.purged); got != tc.want {
+			t.Errorf("after %s with %d purged: expected %s, got %s", tc.curr, tc.purged, tc.want, got)
+		}
+	}
+}
+
+func TestGCAdaptiveInterval(t *testing.T) {
+	ids := test.GeneratePeerIDs(10)
+	addrs := test.GenerateAddrs(1)
+	clock := test.NewMockClock()
+
+	opts := DefaultOpts()
+	opts.Clock = clock
+	opts.GCInitialDelay = 0
+	opts.GCPurgeInterval = 100 * time.Millisecond
+	opts.GCAdaptive = GCAdaptivePolicy{MinInterval: 50 * time.Millisecond, MaxInterval: 400 * time.Millisecond}
+
+	ab, err := NewAddrBook(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+
+	waitInterval := func(want time.Duration) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ab.Stats().GCInterval != want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected GC interval to reach %s, got %s", want, ab.Stats().GCInterval)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// idle cycles lengthen the interval up to the max.
+	waitInterval(400 * time.Millisecond)
+
+	// a cycle purging expired records shortens it.
+	for _, id := range ids {
+		ab.AddAddrs(id, addrs, time.Second)
+	}
+	clock.Add(2 * time.Second)
+	waitInterval(200 * time.Millisecond)
+	if p := ab.Stats().GCPurges; p != uint64(len(ids)) {
+		t.Fatalf("expected %d records to be purged, got %d", len(ids), p)
+	}
+
+	// and it grows back once there's nothing left to purge.
+	waitInterval(400 * time.Millisecond)
+}
+
+func TestGCAdaptiveBounds(t *testing.T) {
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = time.Hour
+	opts.GCAdaptive = GCAdaptivePolicy{MinInterval: 2 * time.Hour, MaxInterval: 4 * time.Hour}
+	if _, err := NewAddrBook(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts); err == nil {
+		t.Fatal("expected an error when the purge interval is out of the adaptive GC bounds")
+	}
+}
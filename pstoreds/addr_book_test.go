@@ -0,0 +1,325 @@
+package pstoreds
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/failstore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	b32 "github.com/multiformats/go-base32"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pb "github.com/libp2p/go-libp2p-peerstore/pb"
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+// storedAddrCount returns the number of address entries persisted for a peer, bypassing the cache.
+func storedAddrCount(t *testing.T, ab *dsAddrBook, p peer.ID) int {
+	t.Helper()
+	data, err := ab.ds.Get(addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(p))))
+	if err == ds.ErrNotFound {
+		return 0
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	rec := new(pb.AddrBookRecord)
+	if err := decodeRecord(data, rec); err != nil {
+		t.Fatal(err)
+	}
+	return len(rec.Addrs)
+}
+
+func TestCompactionThreshold(t *testing.T) {
+	opts := DefaultOpts()
+	opts.CacheSize = 0
+	opts.GCPurgeInterval = 0
+	opts.CompactionThreshold = 0.5
+
+	factory := addressBookFactory(t, badgerStore, opts)
+	abi, closeFn := factory()
+	defer closeFn()
+	ab := abi.(*dsAddrBook)
+
+	ids := test.GeneratePeerIDs(2)
+	addrs := test.GenerateAddrs(8)
+
+	// ids[0]: 1 of 4 addresses expires; ids[1]: 2 of 4 addresses expire.
+	ab.AddAddrs(ids[0], addrs[:1], time.Second)
+	ab.AddAddrs(ids[0], addrs[1:4], time.Hour)
+	ab.AddAddrs(ids[1], addrs[4:6], time.Second)
+	ab.AddAddrs(ids[1], addrs[6:], time.Hour)
+
+	time.Sleep(2100 * time.Millisecond)
+
+	test.AssertAddressesEqual(t, addrs[1:4], ab.Addrs(ids[0]))
+	if n := storedAddrCount(t, ab, ids[0]); n != 4 {
+		t.Errorf("expected record below the threshold to be left untouched, got %d stored entries", n)
+	}
+	if c := ab.Stats().Compactions; c != 0 {
+		t.Errorf("expected no compactions, got %d", c)
+	}
+
+	test.AssertAddressesEqual(t, addrs[6:], ab.Addrs(ids[1]))
+	if n := storedAddrCount(t, ab, ids[1]); n != 2 {
+		t.Errorf("expected record above the threshold to be compacted, got %d stored entries", n)
+	}
+	if c := ab.Stats().Compactions; c != 1 {
+		t.Errorf("expected 1 compaction, got %d", c)
+	}
+}
+
+func TestMutatorErrors(t *testing.T) {
+	errWrite := errors.New("write failed")
+	var failing bool
+	store := failstore.NewFailstore(dssync.MutexWrap(ds.NewMapDatastore()), func(op string) error {
+		if failing && (op == "put" || op == "delete") {
+			return errWrite
+		}
+		return nil
+	})
+
+	opts := DefaultOpts()
+	opts.CacheSize = 0
+	opts.GCPurgeInterval = 0
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+
+	id := test.GeneratePeerIDs(1)[0]
+	addrs := test.GenerateAddrs(2)
+	if err := ab.AddAddrsE(id, addrs[:1], time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	failing = true
+	if err := ab.AddAddrsE(id, addrs[1:], time.Hour); err != errWrite {
+		t.Errorf("expected write error when adding addresses, got %v", err)
+	}
+	if err := ab.SetAddrsE(id, addrs[1:], time.Hour); err != errWrite {
+		t.Errorf("expected write error when setting addresses, got %v", err)
+	}
+	if err := ab.ClearAddrsE(id); err != errWrite {
+		t.Errorf("expected write error when clearing addresses, got %v", err)
+	}
+
+	failing = false
+	test.AssertAddressesEqual(t, addrs[:1], ab.Addrs(id))
+}
+
+func TestPerAddrLayout(t *testing.T) {
+	var puts, deletes int
+	store := failstore.NewFailstore(dssync.MutexWrap(ds.NewMapDatastore()), func(op string) error {
+		switch op {
+		case "put", "batch-put":
+			puts++
+		case "delete", "batch-delete":
+			deletes++
+		}
+		return nil
+	})
+
+	opts := DefaultOpts()
+	opts.CacheSize = 0
+	opts.GCPurgeInterval = 0
+	opts.AddrLayout = AddrLayoutPerAddr
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+
+	id := test.GeneratePeerIDs(1)[0]
+	addrs := test.GenerateAddrs(11)
+	ab.AddAddrs(id, addrs[:10], time.Hour)
+	if puts != 10 {
+		t.Fatalf("expected 10 entries to be written, got %d", puts)
+	}
+
+	puts = 0
+	ab.AddAddr(id, addrs[10], time.Hour)
+	if puts != 1 || deletes != 0 {
+		t.Errorf("expected a single entry to be written when adding an address, got %d puts and %d deletes", puts, deletes)
+	}
+
+	puts = 0
+	ab.SetAddrs(id, addrs[:2], 0)
+	if puts != 0 || deletes != 2 {
+		t.Errorf("expected 2 entries to be deleted when removing addresses, got %d puts and %d deletes", puts, deletes)
+	}
+	test.AssertAddressesEqual(t, addrs[2:], ab.Addrs(id))
+
+	ab.ClearAddrs(id)
+	if peers := ab.PeersWithAddrs(); len(peers) != 0 {
+		t.Errorf("expected no peers with addresses after clearing, got %v", peers)
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+
+	id := test.GeneratePeerIDs(1)[0]
+	ab.AddAddrs(id, test.GenerateAddrs(2), time.Hour)
+	if err := ab.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a write bypassing the cache leaves it stale.
+	if err := store.Delete(addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(id)))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ab.CheckInvariants(); err == nil {
+		t.Fatal("expected a stale cached record to be reported")
+	}
+}
+
+func TestForEachAddr(t *testing.T) {
+	for _, cacheSize := range []uint{0, 1024} {
+		opts := DefaultOpts()
+		opts.CacheSize = cacheSize
+		opts.GCPurgeInterval = 0
+
+		factory := addressBookFactory(t, badgerStore, opts)
+		abi, closeFn := factory()
+		ab := abi.(*dsAddrBook)
+
+		id := test.GeneratePeerIDs(1)[0]
+		addrs := test.GenerateAddrs(10)
+		ab.AddAddrs(id, addrs[:1], time.Second)
+		ab.AddAddrs(id, addrs[1:], time.Hour)
+		time.Sleep(2100 * time.Millisecond)
+
+		var got []ma.Multiaddr
+		err := ab.ForEachAddr(id, func(a ma.Multiaddr, expiry time.Time) bool {
+			if !expiry.After(time.Now()) {
+				t.Errorf("got expired address %s", a)
+			}
+			got = append(got, a)
+			return len(got) < 3
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 {
+			t.Fatalf("expected iteration to stop after 3 addresses, got %d", len(got))
+		}
+		for _, a := range got {
+			if a.Equal(addrs[0]) {
+				t.Fatal("expired address should have been skipped")
+			}
+		}
+		closeFn()
+	}
+}
+
+func TestReadNoCache(t *testing.T) {
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+
+	// two address books sharing a datastore, as two processes would.
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	reader, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writer, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	id := test.GeneratePeerIDs(1)[0]
+	addrs := test.GenerateAddrs(2)
+	reader.AddAddrs(id, addrs[:1], time.Hour)
+	writer.AddAddrs(id, addrs[1:], time.Hour)
+
+	// the cache of the reader doesn't see the write.
+	test.AssertAddressesEqual(t, addrs[:1], reader.Addrs(id))
+	test.AssertAddressesEqual(t, addrs[:1], reader.AddrsOpt(id))
+
+	test.AssertAddressesEqual(t, addrs, reader.AddrsOpt(id, NoCache))
+	if got := reader.AddrsWithExpiryOpt(id, NoCache); len(got) != 2 || got[0].TTL != time.Hour {
+		t.Fatalf("unexpected addresses with expiry %v", got)
+	}
+	if got := reader.AddrsOpt(test.GeneratePeerIDs(1)[0], NoCache); got == nil || len(got) != 0 {
+		t.Fatalf("expected no addresses for an unknown peer, got %v", got)
+	}
+
+	// bypassing reads leave the cache as is.
+	test.AssertAddressesEqual(t, addrs[:1], reader.Addrs(id))
+}
+
+func TestCorruptRecords(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		skipped []ds.Key
+	)
+	opts := DefaultOpts()
+	opts.GCInitialDelay = 90 * time.Hour
+	opts.GCPurgeInterval = 9 * time.Hour
+	opts.OnCorruptRecord = func(key ds.Key, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		skipped = append(skipped, key)
+	}
+
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	ps, err := NewPeerstore(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	ids := test.GeneratePeerIDs(3)
+	ps.AddAddrs(ids[0], test.GenerateAddrs(1), time.Hour)
+	ps.AddAddrs(ids[1], test.GenerateAddrs(1), time.Hour)
+
+	// an undecodable record, a key that doesn't hold a peer ID, and a key book entry under such a key.
+	garbage := []byte("garbage")
+	for _, k := range []ds.Key{
+		addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(ids[2]))),
+		addrBookBase.ChildString("not-base32!"),
+		kbBase.ChildString("not-base32!").ChildString("pub"),
+	} {
+		if err := store.Put(k, garbage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the record of ids[2] can't be decoded, but its key is valid.
+	if peers := ps.Peers(); len(peers) != 3 {
+		t.Fatalf("expected 3 peers, got %v", peers)
+	}
+	if n := ps.dsAddrBook.Stats().CorruptRecords; n != 2 {
+		t.Fatalf("expected the 2 invalid keys to be skipped, got %d", n)
+	}
+
+	// GC skips both undecodable records, and leaves them in place.
+	ps.dsAddrBook.gc.purgeFunc()
+	if n := ps.dsAddrBook.Stats().CorruptRecords; n != 4 {
+		t.Fatalf("expected the 2 undecodable records to be skipped, got %d", n-2)
+	}
+	if _, err := store.Get(addrBookBase.ChildString("not-base32!")); err != nil {
+		t.Errorf("expected the corrupt record to be left in place, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(skipped) != 4 {
+		t.Errorf("expected the callback to be invoked 4 times, got %v", skipped)
+	}
+}
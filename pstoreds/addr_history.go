@@ -0,0 +1,228 @@
+package pstoreds
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	base32 "github.com/multiformats/go-base32"
+	ma "github.com/multiformats/go-multiaddr"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// The address history of a peer, when Options.AddrHistorySize is set, is rolled up into a single entry:
+// /peers/addrhistory/<b32 peer id no padding> -> gob-encoded []storedAddrEvent, oldest first.
+var addrHistoryBase = ds.NewKey("/peers/addrhistory")
+
+// addrHistoryRollupInterval is the interval at which the address events recorded in memory are rolled up into the
+// datastore.
+const addrHistoryRollupInterval = 10 * time.Second
+
+// storedAddrEvent is an address event as persisted.
+type storedAddrEvent struct {
+	Addr []byte
+	Type pstore.AddrEventType
+	Time int64 // unix nanoseconds.
+}
+
+// addrHistory keeps the latest address events of every peer. Events are recorded in memory, and rolled up into the
+// datastore every addrHistoryRollupInterval and when the address book is closed, so that the writes that record them
+// don't pay for another datastore write. Events recorded since the last roll-up are lost if the process crashes.
+type addrHistory struct {
+	ab      *dsAddrBook
+	size    int
+	pending *pstore.AddrChurnLog
+
+	// mu is held for the whole of a roll-up, so that queries and removals don't see events in between the memory and
+	// the datastore.
+	mu sync.Mutex
+}
+
+func newAddrHistory(ab *dsAddrBook, size int) (*addrHistory, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("negative address history size provided: %d", size)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	return &addrHistory{ab: ab, size: size, pending: pstore.NewAddrChurnLog(size)}, nil
+}
+
+func addrHistoryKey(p peer.ID) ds.Key {
+	return addrHistoryBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+// record records an event of p.
+func (h *addrHistory) record(p peer.ID, addr ma.Multiaddr, typ pstore.AddrEventType, t time.Time) {
+	if h == nil {
+		return
+	}
+	h.pending.Record(p, addr, typ, t)
+}
+
+// history returns the events of p timed at or after since, oldest first.
+func (h *addrHistory) history(p peer.ID, since time.Time) []pstore.AddrEvent {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stored, err := h.load(h.ab.ds, p)
+	if err != nil {
+		log.Errorf("failed to load the address history of peer %s: %s", p.Pretty(), err)
+	}
+	return pstore.FilterAddrEvents(h.merge(stored, h.pending.History(p, time.Time{})), since)
+}
+
+// load reads the stored events of p through r, oldest first. Undecodable entries are reported and taken as empty.
+func (h *addrHistory) load(r ds.Read, p peer.ID) ([]pstore.AddrEvent, error) {
+	key := addrHistoryKey(p)
+	v, err := r.Get(key)
+	if err == ds.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var stored []storedAddrEvent
+	if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&stored); err != nil {
+		h.ab.corrupt.report(key, err)
+		return nil, nil
+	}
+	events := make([]pstore.AddrEvent, 0, len(stored))
+	for _, e := range stored {
+		addr, err := ma.NewMultiaddrBytes(e.Addr)
+		if err != nil {
+			h.ab.corrupt.report(key, err)
+			continue
+		}
+		events = append(events, pstore.AddrEvent{Addr: addr, Type: e.Type, Time: time.Unix(0, e.Time)})
+	}
+	return events, nil
+}
+
+// merge appends the pending events to the stored ones, skipping the expiries already stored, and keeps the latest
+// size events, oldest first.
+func (h *addrHistory) merge(stored, pending []pstore.AddrEvent) []pstore.AddrEvent {
+	events := stored
+	for _, e := range pending {
+		if e.Type == pstore.AddrExpired && containsAddrEvent(stored, e) {
+			continue
+		}
+		events = append(events, e)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	if len(events) > h.size {
+		events = events[len(events)-h.size:]
+	}
+	return events
+}
+
+func containsAddrEvent(events []pstore.AddrEvent, e pstore.AddrEvent) bool {
+	for _, have := range events {
+		if have.Type == e.Type && have.Time.Equal(e.Time) && have.Addr.Equal(e.Addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove drops the events of p, deleting its stored events through w.
+func (h *addrHistory) remove(w ds.Write, p peer.ID) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending.Forget(p)
+	return w.Delete(addrHistoryKey(p))
+}
+
+// peers returns the peers with events, stored or pending.
+func (h *addrHistory) peers() peer.IDSlice {
+	if h == nil {
+		return nil
+	}
+	ids, err := uniquePeerIds(h.ab.ds, addrHistoryBase, h.ab.corrupt, h.ab.validateID, func(result query.Result) string {
+		return ds.RawKey(result.Key).Name()
+	})
+	if err != nil {
+		log.Errorf("error while retrieving peers with address history: %v", err)
+	}
+	return append(ids, h.pending.Peers()...)
+}
+
+// background rolls the pending events up every addrHistoryRollupInterval, and once more when the address book is
+// closed. It should be spawned as a goroutine.
+func (h *addrHistory) background() {
+	defer h.ab.childrenDone.Done()
+
+	ticker := time.NewTicker(addrHistoryRollupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.rollup(); err != nil {
+				log.Warnf("failed to roll up the address history: %s", err)
+			}
+		case <-h.ab.ctx.Done():
+			if err := h.rollup(); err != nil {
+				log.Warnf("failed to roll up the address history on close: %s", err)
+			}
+			return
+		}
+	}
+}
+
+// rollup merges the pending events into the stored events of their peers, in a single batch. Events are kept pending
+// if the batch fails, to be retried by the next roll-up.
+func (h *addrHistory) rollup() (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	drained := h.pending.Drain()
+	if len(drained) == 0 {
+		return nil
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for p, events := range drained {
+			for _, e := range events {
+				h.pending.Record(p, e.Addr, e.Type, e.Time)
+			}
+		}
+	}()
+
+	batch, err := h.ab.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for p, pending := range drained {
+		stored, err := h.load(h.ab.ds, p)
+		if err != nil {
+			return err
+		}
+		events := h.merge(stored, pending)
+		enc := make([]storedAddrEvent, len(events))
+		for i, e := range events {
+			enc[i] = storedAddrEvent{Addr: e.Addr.Bytes(), Type: e.Type, Time: e.Time.UnixNano()}
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(enc); err != nil {
+			return err
+		}
+		if err := batch.Put(addrHistoryKey(p), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
@@ -0,0 +1,65 @@
+package pstoreds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestAddrHistoryRollup(t *testing.T) {
+	clock := test.NewMockClock()
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+	opts.Clock = clock
+	opts.AddrHistorySize = 4
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	id := test.GeneratePeerIDs(1)[0]
+	addrs := test.GenerateAddrs(3)
+
+	ps, err := NewPeerstore(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.AddAddrs(id, addrs[:2], time.Minute)
+	clock.Add(2 * time.Minute)
+	ps.Addrs(id)
+	if err := ps.dsAddrBook.history.rollup(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ps.AddrHistory(id, time.Time{}); len(got) != 4 {
+		t.Fatalf("expected 4 events, got %v", got)
+	}
+	ps.AddAddr(id, addrs[2], time.Hour)
+	ps.Close()
+
+	// the events are rolled up on close, and bounded across roll-ups.
+	ps, err = NewPeerstore(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	got := ps.AddrHistory(id, time.Time{})
+	if len(got) != 4 || got[0].Type != pstore.AddrAdded || got[3].Type != pstore.AddrAdded || !got[3].Addr.Equal(addrs[2]) {
+		t.Fatalf("expected the latest 4 events, got %v", got)
+	}
+	if got := ps.allPeers(); len(got) != 1 || got[0] != id {
+		t.Fatalf("expected the peer to be known by its history, got %v", got)
+	}
+
+	ps.RemovePeer(id)
+	if got := ps.AddrHistory(id, time.Time{}); len(got) != 0 {
+		t.Fatalf("expected the history to be removed with the peer, got %v", got)
+	}
+	if err := ps.dsAddrBook.history.rollup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(addrHistoryKey(id)); err != ds.ErrNotFound {
+		t.Fatalf("expected the stored history to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package pstoreds
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pb "github.com/libp2p/go-libp2p-peerstore/pb"
+
+	b32 "github.com/multiformats/go-base32"
+)
+
+// AddrLayout is how the address book lays out the addresses of peers in the datastore.
+type AddrLayout int
+
+const (
+	// AddrLayoutPerPeer stores the addresses of a peer, along with its certified record, in a single record encoded
+	// with Options.Codec. Reads take a single datastore get, but every write rewrites the whole record: this layout
+	// suits read-heavy workloads, e.g. gateways.
+	AddrLayoutPerPeer AddrLayout = iota
+	// AddrLayoutPerAddr stores every address of a peer under its own key, and its certified record under another.
+	// Writes only touch the addresses that changed, but reads take a prefix query: this layout suits write-heavy
+	// workloads, e.g. crawlers. Entries are encoded in protobuf; Options.Codec and Options.Compression don't apply.
+	AddrLayoutPerAddr
+)
+
+func (l AddrLayout) String() string {
+	switch l {
+	case AddrLayoutPerPeer:
+		return "per-peer"
+	case AddrLayoutPerAddr:
+		return "per-addr"
+	default:
+		return fmt.Sprintf("AddrLayout(%d)", int(l))
+	}
+}
+
+var (
+	// In the per-address layout, addresses are stored under the following db key pattern:
+	// /peers/addrkeys/<b32 peer id no padding>/<b32 multiaddr no padding>
+	// and certified records under:
+	// /peers/addrkeys/<b32 peer id no padding>/certified
+	addrKeysBase = ds.NewKey("/peers/addrkeys")
+
+	// certifiedName is lowercase, so it can't collide with the name of an address, which is upper case base32.
+	certifiedName = "certified"
+)
+
+// corruptError is returned when loading a record that can't be decoded.
+type corruptError struct {
+	key ds.Key
+	err error
+}
+
+func (e *corruptError) Error() string {
+	return fmt.Sprintf("corrupt record %s: %s", e.key, e.err)
+}
+
+// addrStore reads and writes address records in a layout. Layouts use distinct keys: switching the layout of an
+// existing datastore starts over with an empty address book.
+type addrStore interface {
+	// base is the prefix of the keys holding address records.
+	base() ds.Key
+	// peerName returns the base32-encoded ID of the peer owning a key under base.
+	peerName(k ds.Key) string
+	// load reads the record of a peer through r into rec, and returns whether there was one. Records that can't be
+	// decoded are reported with a *corruptError.
+	load(r ds.Read, p peer.ID, rec *addrsRecord) (bool, error)
+	// decode decodes into rec the record held by entries, the results of a query over base for the keys of a single
+	// peer.
+	decode(entries []query.Entry, rec *addrsRecord) error
+	// flush writes rec through w, deleting it if it holds no addresses, and marks it clean. To be called within the
+	// lock of the record.
+	flush(w ds.Write, rec *addrsRecord) error
+	// remove deletes the record of a peer, whose keys are listed through r, through w.
+	remove(r ds.Read, w ds.Write, p peer.ID) error
+}
+
+func newAddrStore(layout AddrLayout, codec RecordCodec, compression RecordCompression) (addrStore, error) {
+	switch layout {
+	case AddrLayoutPerPeer:
+		return &perPeerStore{codec: codec, compression: compression}, nil
+	case AddrLayoutPerAddr:
+		return perAddrStore{}, nil
+	default:
+		return nil, fmt.Errorf("unknown address layout: %s", layout)
+	}
+}
+
+// perPeerStore implements AddrLayoutPerPeer.
+type perPeerStore struct {
+	codec       RecordCodec
+	compression RecordCompression
+}
+
+func (s *perPeerStore) base() ds.Key {
+	return addrBookBase
+}
+
+func (s *perPeerStore) peerName(k ds.Key) string {
+	return k.Name()
+}
+
+func (s *perPeerStore) key(p peer.ID) ds.Key {
+	return addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+func (s *perPeerStore) load(r ds.Read, p peer.ID, rec *addrsRecord) (bool, error) {
+	key := s.key(p)
+	data, err := r.Get(key)
+	switch err {
+	case nil:
+		if err := decodeRecord(data, rec.AddrBookRecord); err != nil {
+			return true, &corruptError{key: key, err: err}
+		}
+		return true, nil
+	case ds.ErrNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (s *perPeerStore) decode(entries []query.Entry, rec *addrsRecord) error {
+	if len(entries) != 1 {
+		return fmt.Errorf("expected a single record, got %d", len(entries))
+	}
+	return decodeRecord(entries[0].Value, rec.AddrBookRecord)
+}
+
+func (s *perPeerStore) flush(w ds.Write, rec *addrsRecord) (err error) {
+	key := s.key(rec.Id.ID)
+
+	if len(rec.Addrs) == 0 {
+		if err = w.Delete(key); err == nil {
+			rec.dirty = false
+		}
+		return err
+	}
+
+	data, err := encodeRecord(s.codec, s.compression, rec.AddrBookRecord)
+	if err != nil {
+		return err
+	}
+	if err = w.Put(key, data); err != nil {
+		return err
+	}
+	// write succeeded; record is no longer dirty.
+	rec.dirty = false
+	return nil
+}
+
+func (s *perPeerStore) remove(_ ds.Read, w ds.Write, p peer.ID) error {
+	return w.Delete(s.key(p))
+}
+
+// perAddrStore implements AddrLayoutPerAddr. To write only the entries that changed, records remember the encoding of
+// the entries they were loaded with or last flushed (see addrsRecord.stored).
+type perAddrStore struct{}
+
+func (perAddrStore) base() ds.Key {
+	return addrKeysBase
+}
+
+func (perAddrStore) peerName(k ds.Key) string {
+	return k.Parent().Name()
+}
+
+func (perAddrStore) prefix(p peer.ID) ds.Key {
+	return addrKeysBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+func (s perAddrStore) load(r ds.Read, p peer.ID, rec *addrsRecord) (bool, error) {
+	prefix := s.prefix(p)
+	results, err := r.Query(query.Query{Prefix: prefix.String()})
+	if err != nil {
+		return false, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return false, err
+	}
+	if len(entries) == 0 {
+		return false, nil
+	}
+	if err := s.decode(entries, rec); err != nil {
+		return true, &corruptError{key: prefix, err: err}
+	}
+	return true, nil
+}
+
+func (perAddrStore) decode(entries []query.Entry, rec *addrsRecord) error {
+	rec.stored = make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		k := ds.RawKey(e.Key)
+		if rec.Id == nil {
+			id, err := b32.RawStdEncoding.DecodeString(k.Parent().Name())
+			if err != nil {
+				return err
+			}
+			rec.Id = &pb.ProtoPeerID{ID: peer.ID(id)}
+		}
+		if k.Name() == certifiedName {
+			rec.CertifiedRecord = new(pb.AddrBookRecord_CertifiedRecord)
+			if err := rec.CertifiedRecord.Unmarshal(e.Value); err != nil {
+				return err
+			}
+		} else {
+			entry := new(pb.AddrBookRecord_AddrEntry)
+			if err := entry.Unmarshal(e.Value); err != nil {
+				return err
+			}
+			rec.Addrs = append(rec.Addrs, entry)
+		}
+		rec.stored[k.Name()] = e.Value
+	}
+	// records are kept sorted by expiry, see clean.
+	sort.Slice(rec.Addrs, func(i, j int) bool {
+		return rec.Addrs[i].Expiry < rec.Addrs[j].Expiry
+	})
+	return nil
+}
+
+func (s perAddrStore) flush(w ds.Write, rec *addrsRecord) error {
+	prefix := s.prefix(rec.Id.ID)
+
+	want := make(map[string][]byte, len(rec.Addrs)+1)
+	if len(rec.Addrs) > 0 {
+		for _, a := range rec.Addrs {
+			data, err := a.Marshal()
+			if err != nil {
+				return err
+			}
+			want[b32.RawStdEncoding.EncodeToString(a.Addr.Bytes())] = data
+		}
+		if rec.CertifiedRecord != nil {
+			data, err := rec.CertifiedRecord.Marshal()
+			if err != nil {
+				return err
+			}
+			want[certifiedName] = data
+		}
+	}
+
+	// write the changes in a single batch or transaction when writing to the datastore directly, so that the record
+	// is updated atomically.
+	write := func(w ds.Write) error {
+		for name := range rec.stored {
+			if _, ok := want[name]; !ok {
+				if err := w.Delete(prefix.ChildString(name)); err != nil {
+					return err
+				}
+			}
+		}
+		for name, data := range want {
+			if stored, ok := rec.stored[name]; ok && bytes.Equal(stored, data) {
+				continue
+			}
+			if err := w.Put(prefix.ChildString(name), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var err error
+	if store, ok := w.(ds.Datastore); ok {
+		err = multiWrite(store, func(_ ds.Read, w ds.Write) error { return write(w) })
+	} else {
+		err = write(w)
+	}
+	if err != nil {
+		return err
+	}
+	rec.stored = want
+	rec.dirty = false
+	return nil
+}
+
+func (s perAddrStore) remove(r ds.Read, w ds.Write, p peer.ID) error {
+	results, err := r.Query(query.Query{Prefix: s.prefix(p).String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	del := func(w ds.Write) error {
+		for _, e := range entries {
+			if err := w.Delete(ds.RawKey(e.Key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// as in flush, delete the entries in a single batch or transaction when writing to the datastore directly.
+	if store, ok := w.(ds.Datastore); ok {
+		return multiWrite(store, func(_ ds.Read, w ds.Write) error { return del(w) })
+	}
+	return del(w)
+}
@@ -0,0 +1,31 @@
+package pstoreds
+
+import (
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// auditor records audit events to the sink set in Options.AuditSink, if any.
+type auditor struct {
+	sink  pstore.AuditSink
+	clock pstore.Clock
+}
+
+func newAuditor(opts Options) auditor {
+	a := auditor{sink: opts.AuditSink, clock: opts.Clock}
+	if a.clock == nil {
+		a.clock = pstore.RealClock{}
+	}
+	return a
+}
+
+func (a auditor) enabled() bool {
+	return a.sink != nil
+}
+
+func (a auditor) audit(e pstore.AuditEvent) {
+	if a.sink == nil {
+		return
+	}
+	e.Time = a.clock.Now()
+	a.sink.Audit(e)
+}
@@ -0,0 +1,217 @@
+package pstoreds
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	base32 "github.com/multiformats/go-base32"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// BudgetStats holds the counters of the on-disk budget set in Options.MaxDiskBytes.
+type BudgetStats struct {
+	// Usage is the size, in bytes, of the keys and values held under the peerstore namespace, as of the last check.
+	Usage uint64
+
+	// Evictions is the number of peers removed to stay within the budget.
+	Evictions uint64
+
+	// EvictedBytes is the size of the entries of the peers removed to stay within the budget.
+	EvictedBytes uint64
+}
+
+// diskBudget periodically measures the size of the entries of a peerstore, and removes its least valuable peers while
+// the size exceeds the budget.
+type diskBudget struct {
+	// accessed atomically; keep first for 64-bit alignment.
+	usage, evictions, evictedBytes uint64
+
+	ps  *pstoreds
+	max uint64
+
+	cancel func()
+	done   chan struct{}
+}
+
+// budgetCandidate is a peer that may be evicted, along with what its value is judged on.
+type budgetCandidate struct {
+	id    peer.ID
+	bytes uint64
+
+	signed    bool
+	connected bool
+	lastSeen  time.Time
+}
+
+// lessValuable ranks peers by eviction order: peers without a signed record first, then those we were never connected
+// to, then those seen the longest ago. Among equals, the largest go first.
+func lessValuable(a, b *budgetCandidate) bool {
+	if a.signed != b.signed {
+		return !a.signed
+	}
+	if a.connected != b.connected {
+		return !a.connected
+	}
+	if !a.lastSeen.Equal(b.lastSeen) {
+		return a.lastSeen.Before(b.lastSeen)
+	}
+	return a.bytes > b.bytes
+}
+
+func newDiskBudget(ps *pstoreds, max int64) *diskBudget {
+	return &diskBudget{ps: ps, max: uint64(max)}
+}
+
+// start runs checks in the background every interval, until stop is called.
+func (b *diskBudget) start(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel, b.done = cancel, make(chan struct{})
+	go b.background(ctx, interval)
+}
+
+func (b *diskBudget) background(ctx context.Context, interval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.check(); err != nil {
+				log.Warnf("failed to enforce the on-disk budget: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop stops the background checks, if started.
+func (b *diskBudget) stop() {
+	if b.cancel == nil {
+		return
+	}
+	b.cancel()
+	<-b.done
+}
+
+// check measures the size of the peerstore, and evicts peers until it is within the budget.
+func (b *diskBudget) check() error {
+	usage, sizes, err := b.measure()
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint64(&b.usage, usage)
+	if usage <= b.max {
+		return nil
+	}
+
+	candidates := b.candidates(sizes)
+	sort.Slice(candidates, func(i, j int) bool { return lessValuable(candidates[i], candidates[j]) })
+
+	var evicted int
+	for _, c := range candidates {
+		if usage <= b.max {
+			break
+		}
+		b.ps.RemovePeer(c.id)
+		usage -= c.bytes
+		evicted++
+		atomic.AddUint64(&b.evictions, 1)
+		atomic.AddUint64(&b.evictedBytes, c.bytes)
+	}
+	atomic.StoreUint64(&b.usage, usage)
+
+	if usage > b.max {
+		log.Warnf("peerstore still uses %d bytes out of a budget of %d after evicting %d peers", usage, b.max, evicted)
+	} else if evicted > 0 {
+		log.Debugf("evicted %d peers to stay within the on-disk budget", evicted)
+	}
+	return nil
+}
+
+// measure returns the size of the entries held under the peerstore namespace, and that of the entries of every peer,
+// by encoded peer ID. Entries not removed along with their peer, such as group memberships, only count towards the
+// former.
+func (b *diskBudget) measure() (uint64, map[string]uint64, error) {
+	results, err := b.ps.dsAddrBook.ds.Query(query.Query{Prefix: peersBase.String()})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer results.Close()
+
+	var usage uint64
+	sizes := make(map[string]uint64)
+	for result := range results.Next() {
+		if result.Error != nil {
+			return 0, nil, result.Error
+		}
+		size := uint64(len(result.Key) + len(result.Value))
+		usage += size
+		if name, ok := tieredPeer(ds.RawKey(result.Key)); ok {
+			sizes[name] += size
+		}
+	}
+	return usage, sizes, nil
+}
+
+// candidates returns the peers among sizes that may be evicted: protected peers, and those we hold a private key for,
+// normally the local peer, are spared.
+func (b *diskBudget) candidates(sizes map[string]uint64) []*budgetCandidate {
+	candidates := make([]*budgetCandidate, 0, len(sizes))
+	for name, size := range sizes {
+		raw, err := base32.RawStdEncoding.DecodeString(name)
+		if err != nil {
+			continue
+		}
+		p := peer.ID(raw)
+		if p.Validate() != nil || b.ps.IsProtected(p, "") {
+			continue
+		}
+		if sk, _ := b.ps.PrivKeyE(p); sk != nil {
+			continue
+		}
+
+		c := &budgetCandidate{id: p, bytes: size}
+		// records are read around the cache, so that a check doesn't evict the working set.
+		pr, err := b.ps.dsAddrBook.readRecord(p)
+		if err != nil {
+			log.Debugf("failed to load the addresses of peer %s while checking the disk budget: %s", p, err)
+			candidates = append(candidates, c)
+			continue
+		}
+		now := b.ps.dsAddrBook.clock.Now().Unix()
+		pr.RLock()
+		for _, a := range pr.Addrs {
+			if a.Expiry <= now {
+				continue
+			}
+			c.signed = c.signed || (pr.CertifiedRecord != nil && len(pr.CertifiedRecord.Raw) > 0)
+			c.connected = c.connected || connectedTTL(time.Duration(a.Ttl))
+			if seen := entryLastSeen(a); seen.After(c.lastSeen) {
+				c.lastSeen = seen
+			}
+		}
+		pr.RUnlock()
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// connectedTTL returns whether ttl is one the host assigns to the addresses of the peers it is or was connected to.
+func connectedTTL(ttl time.Duration) bool {
+	return ttl == peerstore.ConnectedAddrTTL || ttl == peerstore.RecentlyConnectedAddrTTL
+}
+
+func (b *diskBudget) stats() BudgetStats {
+	return BudgetStats{
+		Usage:        atomic.LoadUint64(&b.usage),
+		Evictions:    atomic.LoadUint64(&b.evictions),
+		EvictedBytes: atomic.LoadUint64(&b.evictedBytes),
+	}
+}
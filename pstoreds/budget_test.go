@@ -0,0 +1,169 @@
+package pstoreds
+
+import (
+	"context"
+	"crypto/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	crypto "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestDiskBudgetRanking(t *testing.T) {
+	now := time.Now()
+	ids := pt.GeneratePeerIDs(5)
+	ranked := []*budgetCandidate{
+		{id: ids[0], lastSeen: now.Add(-time.Hour), bytes: 10},
+		{id: ids[1], lastSeen: now, bytes: 20},
+		{id: ids[2], lastSeen: now, bytes: 10},
+		{id: ids[3], connected: true, lastSeen: now.Add(-time.Hour)},
+		{id: ids[4], signed: true, lastSeen: now.Add(-2 * time.Hour)},
+	}
+	for i := range ranked {
+		for j := range ranked {
+			if got, want := lessValuable(ranked[i], ranked[j]), i < j; got != want {
+				t.Errorf("expected lessValuable(%d, %d) to be %t", i, j, want)
+			}
+		}
+	}
+}
+
+func TestDiskBudget(t *testing.T) {
+	clock := pt.NewMockClock()
+	opts := DefaultOpts()
+	opts.CacheSize = 0
+	opts.GCPurgeInterval = 0
+	opts.Clock = clock
+	opts.MaxDiskBytes = 1 << 30
+	opts.DiskBudgetInterval = time.Hour
+	ps, err := NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(4)
+	addrs := pt.GenerateAddrs(4)
+	// ids[0] and ids[1] were never connected, ids[1] seen more recently; ids[2] is connected, and ids[3] protected.
+	ps.AddAddr(ids[0], addrs[0], 24*time.Hour)
+	ps.AddAddr(ids[2], addrs[2], peerstore.ConnectedAddrTTL)
+	ps.AddAddr(ids[3], addrs[3], 24*time.Hour)
+	ps.Protect(ids[3], "test")
+	clock.Add(time.Minute)
+	ps.AddAddr(ids[1], addrs[1], 24*time.Hour)
+	for _, p := range ids {
+		if err := ps.Put(p, "AgentVersion", "test/1.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddPrivKey(self, sk); err != nil {
+		t.Fatal(err)
+	}
+
+	// within budget, nothing is evicted.
+	if err := ps.budget.check(); err != nil {
+		t.Fatal(err)
+	}
+	stats := ps.Stats().Budget
+	if stats.Usage == 0 || stats.Evictions != 0 {
+		t.Fatalf("expected usage to be measured without evictions, got %+v", stats)
+	}
+
+	// the oldest peer never connected to goes first.
+	ps.budget.max = stats.Usage - 1
+	if err := ps.budget.check(); err != nil {
+		t.Fatal(err)
+	}
+	if ps.Known(ids[0]) || !ps.Known(ids[1]) {
+		t.Fatal("expected only the oldest peer never connected to to be evicted")
+	}
+	stats = ps.Stats().Budget
+	if stats.Evictions != 1 || stats.Usage > ps.budget.max || stats.EvictedBytes == 0 {
+		t.Fatalf("unexpected stats after evicting a peer: %+v", stats)
+	}
+
+	// protected peers and the local peer are spared.
+	ps.budget.max = 0
+	if err := ps.budget.check(); err != nil {
+		t.Fatal(err)
+	}
+	if ps.Known(ids[1]) || ps.Known(ids[2]) {
+		t.Fatal("expected all evictable peers to be evicted")
+	}
+	if !ps.Known(ids[3]) || !ps.Known(self) {
+		t.Fatal("expected protected and local peers to be spared")
+	}
+	if stats = ps.Stats().Budget; stats.Evictions != 3 {
+		t.Fatalf("expected 3 evictions, got %d", stats.Evictions)
+	}
+}
+
+func TestDiskBudgetOptions(t *testing.T) {
+	opts := DefaultOpts()
+	opts.MaxDiskBytes = 1 << 20
+	opts.DiskBudgetInterval = 0
+	if _, err := NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts); err == nil {
+		t.Fatal("expected an error for a budget without a check interval")
+	}
+}
+
+func TestDiskBudgetLeavesCache(t *testing.T) {
+	opts := DefaultOpts()
+	opts.CacheSize = 2
+	opts.GCPurgeInterval = 0
+	opts.MaxDiskBytes = 1 << 30
+	opts.DiskBudgetInterval = time.Hour
+	ps, err := NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(4)
+	for _, p := range ids {
+		ps.AddAddrs(p, pt.GenerateAddrs(1), peerstore.ConnectedAddrTTL)
+	}
+	ps.Addrs(ids[0])
+	ps.Addrs(ids[1])
+	cached := func() map[interface{}]bool {
+		keys := make(map[interface{}]bool)
+		for _, k := range ps.dsAddrBook.cache.Keys() {
+			keys[k] = true
+		}
+		return keys
+	}
+	before := cached()
+
+	// candidates are ranked from every peer on disk, without loading them into the cache.
+	_, sizes, err := ps.budget.measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates := ps.budget.candidates(sizes)
+	if len(candidates) != len(ids) {
+		t.Fatalf("expected %d candidates, got %d", len(ids), len(candidates))
+	}
+	for _, c := range candidates {
+		if !c.connected {
+			t.Fatalf("expected peer %s to be seen as connected", c.id)
+		}
+	}
+	if after := cached(); !reflect.DeepEqual(before, after) {
+		t.Fatalf("expected the cache to be left as is, had %v, got %v", before, after)
+	}
+}
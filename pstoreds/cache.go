@@ -1,5 +1,9 @@
 package pstoreds
 
+import (
+	lru "github.com/hashicorp/golang-lru"
+)
+
 // cache abstracts all methods we access from ARCCache, to enable alternate
 // implementations such as a no-op one.
 type cache interface {
@@ -11,6 +15,31 @@ type cache interface {
 	Keys() []interface{}
 }
 
+// arcCache is the ARC cache of CacheAdmissionAlways, logging the evictions it makes.
+type arcCache struct {
+	*lru.ARCCache
+	size int
+}
+
+var _ cache = (*arcCache)(nil)
+
+func newARCCache(size int) (*arcCache, error) {
+	c, err := lru.NewARC(size)
+	if err != nil {
+		return nil, err
+	}
+	return &arcCache{ARCCache: c, size: size}, nil
+}
+
+// Add adds a value to the cache. ARC doesn't tell which record it evicts to make room, only that it does.
+func (c *arcCache) Add(key, value interface{}) {
+	evicts := !c.Contains(key) && c.Len() >= c.size
+	c.ARCCache.Add(key, value)
+	if evicts {
+		log.Debugf("cache evicted a record to admit that of peer %s", key)
+	}
+}
+
 // noopCache is a dummy implementation that's used when the cache is disabled.
 type noopCache struct {
 }
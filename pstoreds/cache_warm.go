@@ -0,0 +1,141 @@
+package pstoreds
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pb "github.com/libp2p/go-libp2p-peerstore/pb"
+)
+
+// The access log of the address book is stored under this key, see Options.CacheWarmPeers.
+var accessLogKey = ds.NewKey("/peers/accesslog")
+
+// accessCount is the number of accesses to the record of a peer, as persisted in the access log.
+type accessCount struct {
+	ID    peer.ID
+	Count uint64
+}
+
+// accessLog tracks the most frequently accessed peers in bounded space, using the space-saving algorithm: once full,
+// a peer that isn't tracked replaces the least accessed one, inheriting its count. Counts are thus overestimated by
+// at most the count of the replaced peer, but peers accessed more often than that are always tracked.
+type accessLog struct {
+	sync.Mutex
+	capacity int
+	counts   map[peer.ID]uint64
+}
+
+func newAccessLog(capacity int) *accessLog {
+	return &accessLog{capacity: capacity, counts: make(map[peer.ID]uint64, capacity)}
+}
+
+// record records an access to the record of a peer.
+func (l *accessLog) record(p peer.ID) {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, ok := l.counts[p]; ok || len(l.counts) < l.capacity {
+		l.counts[p]++
+		return
+	}
+	var (
+		min    peer.ID
+		minCnt uint64
+	)
+	for id, c := range l.counts {
+		if min == "" || c < minCnt {
+			min, minCnt = id, c
+		}
+	}
+	delete(l.counts, min)
+	l.counts[p] = minCnt + 1
+}
+
+// top returns the n most accessed peers, most accessed first.
+func (l *accessLog) top(n int) []accessCount {
+	l.Lock()
+	res := make([]accessCount, 0, len(l.counts))
+	for id, c := range l.counts {
+		res = append(res, accessCount{ID: id, Count: c})
+	}
+	l.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].ID < res[j].ID
+	})
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// load reads the access log persisted in store, if any, halving its counts so that accesses of previous runs weigh
+// less than those of this one. It returns the persisted entries.
+func (l *accessLog) load(store ds.Read) ([]accessCount, error) {
+	data, err := store.Get(accessLogKey)
+	if err == ds.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []accessCount
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	for i, e := range entries {
+		if i == l.capacity {
+			break
+		}
+		if c := e.Count / 2; c > 0 {
+			l.counts[e.ID] = c
+		}
+	}
+	return entries, nil
+}
+
+// save persists the n most accessed peers to store.
+func (l *accessLog) save(store ds.Write, n int) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(l.top(n)); err != nil {
+		return err
+	}
+	return store.Put(accessLogKey, buf.Bytes())
+}
+
+// warmCache loads the records of the hottest peers of previous runs into the cache, least accessed first so that
+// the hottest ones are the most recently used.
+func (ab *dsAddrBook) warmCache() error {
+	entries, err := ab.accesses.load(ab.ds)
+	if err != nil {
+		return err
+	}
+	if len(entries) > ab.opts.CacheWarmPeers {
+		entries = entries[:ab.opts.CacheWarmPeers]
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		// loaded directly rather than through loadRecord, so as not to count as accesses.
+		id := entries[i].ID
+		pr := &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}}
+		found, err := ab.records.load(ab.ds, id, pr)
+		if err != nil {
+			log.Warnf("failed to warm the cache with the addresses of peer %s: %s", id.Pretty(), err)
+			continue
+		} else if !found {
+			continue
+		}
+		ab.cleanRecord(pr)
+		ab.cache.Add(id, pr)
+	}
+	return nil
+}
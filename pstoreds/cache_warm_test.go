@@ -0,0 +1,70 @@
+package pstoreds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestAccessLog(t *testing.T) {
+	l := newAccessLog(2)
+	ids := test.GeneratePeerIDs(3)
+	for i := 0; i < 3; i++ {
+		l.record(ids[0])
+	}
+	l.record(ids[1])
+	// the least accessed peer is replaced, its successor inheriting its count.
+	l.record(ids[2])
+
+	top := l.top(3)
+	if len(top) != 2 || top[0] != (accessCount{ids[0], 3}) || top[1] != (accessCount{ids[2], 2}) {
+		t.Fatalf("unexpected top peers: %v", top)
+	}
+}
+
+func TestCacheWarming(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+	opts.CacheWarmPeers = 2
+
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := test.GeneratePeerIDs(3)
+	addrs := test.GenerateAddrs(3)
+	for i, id := range ids {
+		ab.AddAddr(id, addrs[i], time.Hour)
+	}
+	for i, n := range []int{5, 1, 3} {
+		for j := 0; j < n; j++ {
+			ab.Addrs(ids[i])
+		}
+	}
+	if err := ab.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ab, err = NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+	for i, want := range []bool{true, false, true} {
+		if _, ok := ab.cache.Peek(ids[i]); ok != want {
+			t.Errorf("expected peer %d to be cached on startup: %t, got %t", i, want, ok)
+		}
+	}
+	test.AssertAddressesEqual(t, addrs[:1], ab.Addrs(ids[0]))
+
+	// counts carry over, halved.
+	if top := ab.accesses.top(1); len(top) != 1 || top[0].ID != ids[0] {
+		t.Errorf("expected peer 0 to remain the most accessed, got %v", top)
+	}
+}
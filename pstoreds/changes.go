@@ -0,0 +1,163 @@
+package pstoreds
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	base32 "github.com/multiformats/go-base32"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// changesBase holds the change markers of the address records, when Options.ChangeNotifyInterval is set:
+// /peers/changes/<b32 peer id no padding> -> <writer id><unix nanoseconds, big endian>. The marker of a peer is
+// overwritten by every change, by whichever process makes it.
+var changesBase = ds.NewKey("/peers/changes")
+
+const (
+	changeWriterIDLen = 16
+	changeMarkerLen   = changeWriterIDLen + 8
+
+	// minChangeRetention is the least time change markers are kept for, so that processes polling less often than
+	// this one still see them.
+	minChangeRetention = time.Minute
+)
+
+// changeNotifier writes a marker whenever the address book changes the addresses of a peer, and polls the markers
+// written by the other processes sharing the datastore, dropping the cached records of the peers they changed.
+type changeNotifier struct {
+	invalidations uint64 // accessed atomically; keep first for 64-bit alignment.
+
+	ab        *dsAddrBook
+	writer    []byte
+	interval  time.Duration
+	retention time.Duration
+
+	// time of the previous poll; only accessed by the polling goroutine.
+	lastPoll time.Time
+}
+
+func newChangeNotifier(ab *dsAddrBook, interval time.Duration) (*changeNotifier, error) {
+	if interval < 0 {
+		return nil, fmt.Errorf("negative change notification interval provided: %s", interval)
+	}
+	if interval == 0 {
+		return nil, nil
+	}
+	writer := make([]byte, changeWriterIDLen)
+	if _, err := rand.Read(writer); err != nil {
+		return nil, err
+	}
+	retention := 10 * interval
+	if retention < minChangeRetention {
+		retention = minChangeRetention
+	}
+	return &changeNotifier{
+		ab:        ab,
+		writer:    writer,
+		interval:  interval,
+		retention: retention,
+		lastPoll:  ab.clock.Now(),
+	}, nil
+}
+
+// mark records through w that the addresses of p changed. Failures are logged: other processes then serve their
+// cached record until it is evicted.
+func (n *changeNotifier) mark(w ds.Write, p peer.ID) {
+	if n == nil {
+		return
+	}
+	v := make([]byte, changeMarkerLen)
+	copy(v, n.writer)
+	binary.BigEndian.PutUint64(v[changeWriterIDLen:], uint64(n.ab.clock.Now().UnixNano()))
+	if err := w.Put(changesBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p))), v); err != nil {
+		log.Warnf("failed to write the change marker of peer %s: %s", p, err)
+	}
+}
+
+// background polls the change markers every interval. It should be spawned as a goroutine.
+func (n *changeNotifier) background() {
+	defer n.ab.childrenDone.Done()
+
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := n.poll(); err != nil {
+				log.Warnf("failed to poll the change markers: %s", err)
+			}
+		case <-n.ab.ctx.Done():
+			return
+		}
+	}
+}
+
+// poll invalidates the peers changed by other processes since the previous poll, and deletes the markers older than
+// the retention. Markers written within an interval before the previous poll are considered again, as they may have
+// been written by a change still in flight at the time.
+func (n *changeNotifier) poll() error {
+	now := n.ab.clock.Now()
+	since := n.lastPoll.Add(-n.interval).UnixNano()
+	expired := now.Add(-n.retention).UnixNano()
+
+	results, err := n.ab.ds.Query(query.Query{Prefix: changesBase.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		k := ds.RawKey(e.Key)
+		if len(e.Value) != changeMarkerLen {
+			n.ab.corrupt.report(k, fmt.Errorf("change marker of %d bytes", len(e.Value)))
+			continue
+		}
+		stamp := int64(binary.BigEndian.Uint64(e.Value[changeWriterIDLen:]))
+		if stamp < expired {
+			if err := n.ab.ds.Delete(k); err != nil {
+				return err
+			}
+			continue
+		}
+		if stamp < since || bytes.Equal(e.Value[:changeWriterIDLen], n.writer) {
+			continue
+		}
+		raw, err := base32.RawStdEncoding.DecodeString(k.Name())
+		if err != nil {
+			n.ab.corrupt.report(k, err)
+			continue
+		}
+		n.ab.Invalidate(peer.ID(raw))
+		atomic.AddUint64(&n.invalidations, 1)
+	}
+
+	n.lastPoll = now
+	return nil
+}
+
+func (n *changeNotifier) stats() uint64 {
+	if n == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&n.invalidations)
+}
+
+// Invalidate drops the cached address record of a peer, so that the next read loads it from the datastore. Callers
+// sharing the datastore with other processes can call it when they learn, through their own channels, that another
+// process changed the addresses of the peer; see Options.ChangeNotifyInterval for the built-in polling.
+func (ab *dsAddrBook) Invalidate(p peer.ID) {
+	// the queued refreshes of the peer, if any, would overwrite the change.
+	ab.refreshes.forget(p)
+	ab.cache.Remove(p)
+	ab.debouncer.Forget(p)
+}
@@ -0,0 +1,152 @@
+package pstoreds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func countChangeMarkers(t *testing.T, store ds.Datastore) int {
+	t.Helper()
+	results, err := store.Query(query.Query{Prefix: changesBase.String(), KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := results.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(rest)
+}
+
+func TestChangeNotify(t *testing.T) {
+	clock := test.NewMockClock()
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+	opts.Clock = clock
+	// polls are driven by hand.
+	opts.ChangeNotifyInterval = time.Hour
+
+	// two address books sharing a datastore, as two processes would.
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	reader, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writer, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	ids := test.GeneratePeerIDs(2)
+	addrs := test.GenerateAddrs(3)
+	reader.AddAddrs(ids[0], addrs[:1], time.Hour)
+	reader.AddAddrs(ids[1], addrs[2:], time.Hour)
+	writer.AddAddrs(ids[0], addrs[1:2], time.Hour)
+	test.AssertAddressesEqual(t, addrs[:1], reader.Addrs(ids[0]))
+	if n := countChangeMarkers(t, store); n != 2 {
+		t.Fatalf("expected a change marker per peer, got %d", n)
+	}
+
+	// the reader only invalidates the peer changed by the writer.
+	if err := reader.changes.poll(); err != nil {
+		t.Fatal(err)
+	}
+	test.AssertAddressesEqual(t, addrs[:2], reader.Addrs(ids[0]))
+	if n := reader.Stats().Invalidations; n != 1 {
+		t.Fatalf("expected 1 invalidation, got %d", n)
+	}
+	if err := writer.changes.poll(); err != nil {
+		t.Fatal(err)
+	}
+	if n := writer.Stats().Invalidations; n != 1 {
+		t.Fatalf("expected 1 invalidation, got %d", n)
+	}
+
+	// removals are notified too.
+	writer.ClearAddrs(ids[1])
+	clock.Add(2 * time.Hour)
+	if err := reader.changes.poll(); err != nil {
+		t.Fatal(err)
+	}
+	if got := reader.Addrs(ids[1]); len(got) != 0 {
+		t.Fatalf("expected the cleared addresses to be gone, got %v", got)
+	}
+
+	// markers seen by the previous poll aren't considered again, and are deleted once past the retention.
+	before := reader.Stats().Invalidations
+	clock.Add(2 * time.Hour)
+	if err := reader.changes.poll(); err != nil {
+		t.Fatal(err)
+	}
+	if n := reader.Stats().Invalidations; n != before {
+		t.Fatalf("expected no new invalidations, got %d", n-before)
+	}
+	clock.Add(10 * time.Hour)
+	if err := reader.changes.poll(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countChangeMarkers(t, store); n != 0 {
+		t.Fatalf("expected expired change markers to be deleted, got %d", n)
+	}
+}
+
+func TestChangeNotifyBackground(t *testing.T) {
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+	opts.ChangeNotifyInterval = 10 * time.Millisecond
+
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	reader, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writer, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	id := test.GeneratePeerIDs(1)[0]
+	addrs := test.GenerateAddrs(2)
+	reader.AddAddrs(id, addrs[:1], time.Hour)
+	writer.AddAddrs(id, addrs[1:], time.Hour)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(reader.Addrs(id)) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the change of the writer to be picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChangeNotifyOptions(t *testing.T) {
+	opts := DefaultOpts()
+	opts.ChangeNotifyInterval = -time.Second
+	if _, err := NewAddrBook(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts); err == nil {
+		t.Fatal("expected a negative interval to be rejected")
+	}
+
+	opts = DefaultOpts()
+	opts.GCPurgeInterval = 0
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+	ab.AddAddrs(test.GeneratePeerIDs(1)[0], test.GenerateAddrs(1), time.Hour)
+	if n := countChangeMarkers(t, store); n != 0 {
+		t.Fatalf("expected no change markers when disabled, got %d", n)
+	}
+}
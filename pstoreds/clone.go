@@ -0,0 +1,82 @@
+package pstoreds
+
+import (
+	"context"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// peersBase is the common prefix of the keys of all books.
+var peersBase = ds.NewKey("/peers")
+
+var _ pstore.Cloner = (*pstoreds)(nil)
+
+// Clone copies the peerstore into a new in-memory datastore, see CloneTo.
+func (ps *pstoreds) Clone(ctx context.Context) (peerstore.Peerstore, error) {
+	return ps.CloneTo(ctx, dssync.MutexWrap(ds.NewMapDatastore()))
+}
+
+// CloneTo copies every entry of the peerstore to dst, which is expected to be empty, and opens a peerstore over it
+// with the same options, namespace included. To copy into another namespace, pass dst wrapped with
+// go-datastore/namespace. Protection tags are copied too; metrics and address streams are not.
+//
+// The copy is consistent per entry, but not across entries: writes made while it is taken may or may not be
+// included. If it fails or ctx is cancelled, dst is left with the entries copied so far.
+//
+// The entries held by Options.ColdStore are copied into dst along with the others, and the clone has no cold store,
+// so that it doesn't move entries out from under the original.
+func (ps *pstoreds) CloneTo(ctx context.Context, dst ds.Batching) (*pstoreds, error) {
+	batch, err := newCyclicBatch(namespaceStore(dst, ps.dsAddrBook.opts).(ds.Batching), defaultOpsPerCyclicBatch)
+	if err != nil {
+		return nil, err
+	}
+	// copy cold entries first, so that the hot ones win should a peer be promoted while copying.
+	if ps.tier != nil {
+		if err := copyEntries(ctx, ps.tier.cold, batch); err != nil {
+			return nil, err
+		}
+	}
+	// read through the wrapped store, which holds the writes retained by the retry policy.
+	if err := copyEntries(ctx, ps.dsAddrBook.ds, batch); err != nil {
+		return nil, err
+	}
+	if err := batch.Commit(); err != nil {
+		return nil, err
+	}
+
+	opts := ps.dsAddrBook.opts
+	opts.ColdStore = nil
+	clone, err := NewPeerstore(context.Background(), dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	ps.dsAddrBook.ProtectManager.CopyTo(clone.dsAddrBook.ProtectManager)
+	return clone, nil
+}
+
+// copyEntries writes the entries of all books held by src to w.
+func copyEntries(ctx context.Context, src ds.Read, w ds.Write) error {
+	results, err := src.Query(query.Query{Prefix: peersBase.String()})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	for result := range results.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := w.Put(ds.RawKey(result.Key), result.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package pstoreds
+
+import (
+	"fmt"
+	"sync"
+
+	cbor "github.com/fxamacker/cbor/v2"
+	snappy "github.com/golang/snappy"
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	pb "github.com/libp2p/go-libp2p-peerstore/pb"
+)
+
+// RecordCodec serializes address book records for storage in the datastore.
+//
+// Records written with a codec other than ProtobufCodec, or compressed, are prefixed by a short header that tags
+// the codec and compression used, so that the address book can read records regardless of the codec and
+// compression currently configured. Uncompressed records written by ProtobufCodec carry no header, keeping them
+// readable by older versions of this package.
+
+type RecordCodec interface {
+	// ID is the identifier of the codec in record headers. It must be unique across registered codecs.
+	ID() byte
+
+	Marshal(rec *pb.AddrBookRecord) ([]byte, error)
+	Unmarshal(data []byte, rec *pb.AddrBookRecord) error
+}
+
+// Record headers are laid out as: <magic> <codec ID> <flags>. A protobuf-encoded record can never start with a
+// zero byte (field number 0 is invalid), so the magic byte unambiguously distinguishes tagged records from
+// legacy untagged protobuf records. The low bits of the flags hold the RecordCompression of the encoded record.
+const (
+	recordHeaderMagic = 0x00
+	recordHeaderLen   = 3
+
+	recordFlagCompression = 0x03
+)
+
+// RecordCompression is the compression applied to address book records once encoded by their codec.
+type RecordCompression byte
+
+const (
+	// CompressionNone stores records as encoded by their codec.
+	CompressionNone RecordCompression = iota
+	// CompressionSnappy compresses records with snappy, which is cheap to decode and roughly halves the size of the
+	// records of peers with many similar addresses.
+	CompressionSnappy
+)
+
+func (c RecordCompression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("RecordCompression(%d)", byte(c))
+	}
+}
+
+func (c RecordCompression) valid() bool {
+	return c == CompressionNone || c == CompressionSnappy
+}
+
+var (
+	// ProtobufCodec encodes records in protobuf. This is the default codec.
+	ProtobufCodec RecordCodec = protobufCodec{}
+
+	// CBORCodec encodes records in CBOR (RFC 7049).
+	CBORCodec RecordCodec = cborCodec{}
+
+	// FlatbuffersCodec encodes records as flatbuffers. Fields are read in place from the stored bytes rather than
+	// parsed up front, and the signed peer record of a decoded record references the stored bytes instead of a copy
+	// of them, which suits read-heavy nodes.
+	FlatbuffersCodec RecordCodec = flatbuffersCodec{}
+)
+
+var codecs = struct {
+	sync.RWMutex
+	byID map[byte]RecordCodec
+}{byID: make(map[byte]RecordCodec)}
+
+func init() {
+	RegisterRecordCodec(ProtobufCodec)
+	RegisterRecordCodec(CBORCodec)
+	RegisterRecordCodec(FlatbuffersCodec)
+}
+
+// RegisterRecordCodec makes a codec available for decoding records tagged with its ID. Codecs need to be
+// registered before they are set in Options.Codec. It panics if a different codec with the same ID has
+// already been registered.
+func RegisterRecordCodec(c RecordCodec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if existing, ok := codecs.byID[c.ID()]; ok && existing != c {
+		panic(fmt.Sprintf("pstoreds: record codec with ID %d already registered", c.ID()))
+	}
+	codecs.byID[c.ID()] = c
+}
+
+func lookupCodec(id byte) (RecordCodec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.byID[id]
+	return c, ok
+}
+
+// encodeRecord serializes a record with the given codec and compression, prepending a header if needed. Records that
+// compression doesn't shrink are stored uncompressed.
+func encodeRecord(codec RecordCodec, compression RecordCompression, rec *pb.AddrBookRecord) ([]byte, error) {
+	data, err := codec.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if compression == CompressionSnappy {
+		// compress right after the room left for the header.
+		buf := make([]byte, recordHeaderLen+snappy.MaxEncodedLen(len(data)))
+		if n := len(snappy.Encode(buf[recordHeaderLen:], data)); n < len(data) {
+			buf[0], buf[1], buf[2] = recordHeaderMagic, codec.ID(), byte(CompressionSnappy)
+			return buf[:recordHeaderLen+n], nil
+		}
+	}
+	if codec.ID() == ProtobufCodec.ID() {
+		return data, nil
+	}
+	return append([]byte{recordHeaderMagic, codec.ID(), 0}, data...), nil
+}
+
+// decodeRecord deserializes a record written by any registered codec, with any compression, into rec.
+func decodeRecord(data []byte, rec *pb.AddrBookRecord) error {
+	if len(data) == 0 || data[0] != recordHeaderMagic {
+		// legacy, untagged protobuf record.
+		return rec.Unmarshal(data)
+	}
+	if len(data) < recordHeaderLen {
+		return fmt.Errorf("truncated record header")
+	}
+	codec, ok := lookupCodec(data[1])
+	if !ok {
+		return fmt.Errorf("unknown record codec: %d", data[1])
+	}
+	data, flags := data[recordHeaderLen:], data[2]
+	switch compression := RecordCompression(flags & recordFlagCompression); compression {
+	case CompressionNone:
+	case CompressionSnappy:
+		var err error
+		if data, err = snappy.Decode(nil, data); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown record compression: %s", compression)
+	}
+	return codec.Unmarshal(data, rec)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ID() byte {
+	return 1
+}
+
+func (protobufCodec) Marshal(rec *pb.AddrBookRecord) ([]byte, error) {
+	return rec.Marshal()
+}
+
+func (protobufCodec) Unmarshal(data []byte, rec *pb.AddrBookRecord) error {
+	return rec.Unmarshal(data)
+}
+
+type cborCodec struct{}
+
+type cborAddrEntry struct {
+	Addr     []byte `cbor:"1,keyasint"`
+	Expiry   int64  `cbor:"2,keyasint"`
+	TTL      int64  `cbor:"3,keyasint"`
+	LastSeen int64  `cbor:"4,keyasint,omitempty"`
+	Added    uint64 `cbor:"5,keyasint,omitempty"`
+}
+
+type cborCertifiedRecord struct {
+	Seq uint64 `cbor:"1,keyasint"`
+	Raw []byte `cbor:"2,keyasint"`
+}
+
+type cborRecord struct {
+	ID              []byte               `cbor:"1,keyasint"`
+	Addrs           []cborAddrEntry      `cbor:"2,keyasint,omitempty"`
+	CertifiedRecord *cborCertifiedRecord `cbor:"3,keyasint,omitempty"`
+}
+
+func (cborCodec) ID() byte {
+	return 2
+}
+
+func (cborCodec) Marshal(rec *pb.AddrBookRecord) ([]byte, error) {
+	var cr cborRecord
+	if rec.Id != nil {
+		cr.ID = []byte(rec.Id.ID)
+	}
+	cr.Addrs = make([]cborAddrEntry, 0, len(rec.Addrs))
+	for _, a := range rec.Addrs {
+		cr.Addrs = append(cr.Addrs, cborAddrEntry{Addr: a.Addr.Bytes(), Expiry: a.Expiry, TTL: a.Ttl, LastSeen: a.LastSeen, Added: a.Added})
+	}
+	if rec.CertifiedRecord != nil {
+		cr.CertifiedRecord = &cborCertifiedRecord{Seq: rec.CertifiedRecord.Seq, Raw: rec.CertifiedRecord.Raw}
+	}
+	return cbor.Marshal(cr)
+}
+
+func (cborCodec) Unmarshal(data []byte, rec *pb.AddrBookRecord) error {
+	var cr cborRecord
+	if err := cbor.Unmarshal(data, &cr); err != nil {
+		return err
+	}
+
+	rec.Reset()
+	rec.Id = &pb.ProtoPeerID{}
+	if err := rec.Id.Unmarshal(cr.ID); err != nil {
+		return err
+	}
+	rec.Addrs = make([]*pb.AddrBookRecord_AddrEntry, 0, len(cr.Addrs))
+	for _, a := range cr.Addrs {
+		addr := &pb.ProtoAddr{}
+		if err := addr.Unmarshal(a.Addr); err != nil {
+			return err
+		}
+		rec.Addrs = append(rec.Addrs, &pb.AddrBookRecord_AddrEntry{Addr: addr, Expiry: a.Expiry, Ttl: a.TTL, LastSeen: a.LastSeen, Added: a.Added})
+	}
+	if cr.CertifiedRecord != nil {
+		rec.CertifiedRecord = &pb.AddrBookRecord_CertifiedRecord{Seq: cr.CertifiedRecord.Seq, Raw: cr.CertifiedRecord.Raw}
+	}
+	return nil
+}
+
+// flatbuffersCodec lays records out following this schema:
+//
+//	table AddrEntry { addr:[ubyte]; expiry:long; ttl:long; last_seen:long; added:ulong; }
+//	table CertifiedRecord { seq:ulong; raw:[ubyte]; }
+//	table AddrBookRecord { id:[ubyte]; addrs:[AddrEntry]; certified_record:CertifiedRecord; }
+//	root_type AddrBookRecord;
+type flatbuffersCodec struct{}
+
+// fbSlot returns the vtable offset of the i-th field of a flatbuffers table.
+func fbSlot(i int) flatbuffers.VOffsetT {
+	return flatbuffers.VOffsetT(flatbuffers.VtableMetadataFields+i) * flatbuffers.SizeVOffsetT
+}
+
+func (flatbuffersCodec) ID() byte {
+	return 3
+}
+
+func (flatbuffersCodec) Marshal(rec *pb.AddrBookRecord) ([]byte, error) {
+	b := flatbuffers.NewBuilder(256)
+
+	// vectors and nested tables need to be built before the tables referencing them.
+	entries := make([]flatbuffers.UOffsetT, len(rec.Addrs))
+	for i, a := range rec.Addrs {
+		addr := b.CreateByteVector(a.Addr.Bytes())
+		b.StartObject(5)
+		b.PrependUOffsetTSlot(0, addr, 0)
+		b.PrependInt64Slot(1, a.Expiry, 0)
+		b.PrependInt64Slot(2, a.Ttl, 0)
+		b.PrependInt64Slot(3, a.LastSeen, 0)
+		b.PrependUint64Slot(4, a.Added, 0)
+		entries[i] = b.EndObject()
+	}
+	b.StartVector(flatbuffers.SizeUOffsetT, len(entries), flatbuffers.SizeUOffsetT)
+	for i := len(entries) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(entries[i])
+	}
+	addrs := b.EndVector(len(entries))
+
+	var certified flatbuffers.UOffsetT
+	if rec.CertifiedRecord != nil {
+		raw := b.CreateByteVector(rec.CertifiedRecord.Raw)
+		b.StartObject(2)
+		b.PrependUint64Slot(0, rec.CertifiedRecord.Seq, 0)
+		b.PrependUOffsetTSlot(1, raw, 0)
+		certified = b.EndObject()
+	}
+
+	var id flatbuffers.UOffsetT
+	if rec.Id != nil {
+		id = b.CreateByteVector([]byte(rec.Id.ID))
+	}
+
+	b.StartObject(3)
+	b.PrependUOffsetTSlot(0, id, 0)
+	b.PrependUOffsetTSlot(1, addrs, 0)
+	b.PrependUOffsetTSlot(2, certified, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes(), nil
+}
+
+func (flatbuffersCodec) Unmarshal(data []byte, rec *pb.AddrBookRecord) (err error) {
+	if len(data) < flatbuffers.SizeUOffsetT {
+		return fmt.Errorf("truncated flatbuffers record")
+	}
+	// flatbuffers accessors don't check offsets, so a corrupt record makes them go out of bounds.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed flatbuffers record: %v", r)
+		}
+	}()
+
+	root := &flatbuffers.Table{Bytes: data, Pos: flatbuffers.GetUOffsetT(data)}
+	rec.Reset()
+	rec.Id = &pb.ProtoPeerID{}
+	if o := flatbuffers.UOffsetT(root.Offset(fbSlot(0))); o != 0 {
+		if err := rec.Id.Unmarshal(root.ByteVector(root.Pos + o)); err != nil {
+			return err
+		}
+	}
+	if o := flatbuffers.UOffsetT(root.Offset(fbSlot(1))); o != 0 {
+		n, vec := root.VectorLen(o), root.Vector(o)
+		rec.Addrs = make([]*pb.AddrBookRecord_AddrEntry, 0, n)
+		for i := 0; i < n; i++ {
+			entry := &flatbuffers.Table{Bytes: data, Pos: root.Indirect(vec + flatbuffers.UOffsetT(i*flatbuffers.SizeUOffsetT))}
+			addr := &pb.ProtoAddr{}
+			if o := flatbuffers.UOffsetT(entry.Offset(fbSlot(0))); o != 0 {
+				if err := addr.Unmarshal(entry.ByteVector(entry.Pos + o)); err != nil {
+					return err
+				}
+			}
+			rec.Addrs = append(rec.Addrs, &pb.AddrBookRecord_AddrEntry{
+				Addr:     addr,
+				Expiry:   entry.GetInt64Slot(fbSlot(1), 0),
+				Ttl:      entry.GetInt64Slot(fbSlot(2), 0),
+				LastSeen: entry.GetInt64Slot(fbSlot(3), 0),
+				Added:    entry.GetUint64Slot(fbSlot(4), 0),
+			})
+		}
+	}
+	if o := flatbuffers.UOffsetT(root.Offset(fbSlot(2))); o != 0 {
+		certified := &flatbuffers.Table{Bytes: data, Pos: root.Indirect(root.Pos + o)}
+		rec.CertifiedRecord = &pb.AddrBookRecord_CertifiedRecord{Seq: certified.GetUint64Slot(fbSlot(0), 0)}
+		if o := flatbuffers.UOffsetT(certified.Offset(fbSlot(1))); o != 0 {
+			rec.CertifiedRecord.Raw = certified.ByteVector(certified.Pos + o)
+		}
+	}
+	return nil
+}
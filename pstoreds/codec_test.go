@@ -0,0 +1,223 @@
+package pstoreds
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pb "github.com/libp2p/go-libp2p-peerstore/pb"
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestMixedRecordCodecs(t *testing.T) {
+	store, closeStore := badgerStore(t)
+	defer closeStore()
+
+	ids := test.GeneratePeerIDs(3)
+	addrs := test.GenerateAddrs(6)
+
+	newBook := func(codec RecordCodec) *dsAddrBook {
+		opts := DefaultOpts()
+		opts.CacheSize = 0
+		opts.GCPurgeInterval = 0
+		opts.Codec = codec
+		ab, err := NewAddrBook(context.Background(), store, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ab
+	}
+
+	// write a record with protobuf.
+	ab := newBook(ProtobufCodec)
+	ab.AddAddrs(ids[0], addrs[:2], time.Hour)
+	ab.Close()
+
+	// read it back with CBOR configured, and write a new record in CBOR.
+	ab = newBook(CBORCodec)
+	test.AssertAddressesEqual(t, addrs[:2], ab.Addrs(ids[0]))
+	ab.AddAddrs(ids[1], addrs[2:4], time.Hour)
+	ab.Close()
+
+	// a flatbuffers-configured book reads both, and writes a new record in flatbuffers.
+	ab = newBook(FlatbuffersCodec)
+	test.AssertAddressesEqual(t, addrs[:2], ab.Addrs(ids[0]))
+	test.AssertAddressesEqual(t, addrs[2:4], ab.Addrs(ids[1]))
+	ab.AddAddrs(ids[2], addrs[4:], time.Hour)
+	ab.Close()
+
+	// a protobuf-configured book reads all three.
+	ab = newBook(ProtobufCodec)
+	defer ab.Close()
+	test.AssertAddressesEqual(t, addrs[:2], ab.Addrs(ids[0]))
+	test.AssertAddressesEqual(t, addrs[2:4], ab.Addrs(ids[1]))
+	test.AssertAddressesEqual(t, addrs[4:], ab.Addrs(ids[2]))
+}
+
+func TestFlatbuffersCodec(t *testing.T) {
+	rec := similarAddrsRecord(t, 4)
+	rec.Addrs[0].LastSeen = time.Now().Unix()
+	rec.Addrs[1].Added = 42
+	rec.CertifiedRecord = &pb.AddrBookRecord_CertifiedRecord{Seq: 7, Raw: []byte("envelope")}
+
+	data, err := FlatbuffersCodec.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(pb.AddrBookRecord)
+	if err := FlatbuffersCodec.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := rec.Marshal()
+	if got, _ := decoded.Marshal(); !bytes.Equal(got, want) {
+		t.Fatal("expected the record to survive a flatbuffers round trip")
+	}
+
+	// corrupt records are rejected rather than read out of bounds.
+	for _, bad := range [][]byte{nil, {1, 2}, {0xff, 0xff, 0xff, 0x7f}, data[:len(data)/2]} {
+		if err := FlatbuffersCodec.Unmarshal(bad, new(pb.AddrBookRecord)); err == nil {
+			t.Fatalf("expected an error decoding %x", bad)
+		}
+	}
+}
+
+type unregisteredCodec struct{ protobufCodec }
+
+func (unregisteredCodec) ID() byte { return 200 }
+
+func TestUnregisteredRecordCodec(t *testing.T) {
+	store, closeStore := badgerStore(t)
+	defer closeStore()
+
+	opts := DefaultOpts()
+	opts.Codec = unregisteredCodec{}
+	if _, err := NewAddrBook(context.Background(), store, opts); err == nil {
+		t.Fatal("expected an error when using an unregistered codec")
+	}
+}
+
+// similarAddrsRecord returns the record of a peer listening on n ports of the same IPv4 and IPv6 addresses.
+func similarAddrsRecord(tb testing.TB, n int) *pb.AddrBookRecord {
+	tb.Helper()
+	id := test.GeneratePeerIDs(1)[0]
+	rec := &pb.AddrBookRecord{Id: &pb.ProtoPeerID{ID: id}}
+	expiry := time.Now().Add(time.Hour).Unix()
+	for i := 0; i < n; i++ {
+		for _, s := range []string{"/ip4/192.168.1.24/tcp/%d", "/ip6/2001:db8::24/tcp/%d", "/ip4/192.168.1.24/udp/%d/quic"} {
+			a, err := ma.NewMultiaddr(fmt.Sprintf(s, 4001+i))
+			if err != nil {
+				tb.Fatal(err)
+			}
+			rec.Addrs = append(rec.Addrs, &pb.AddrBookRecord_AddrEntry{
+				Addr:   &pb.ProtoAddr{Multiaddr: a},
+				Expiry: expiry,
+				Ttl:    int64(time.Hour),
+			})
+		}
+	}
+	return rec
+}
+
+func TestRecordCompression(t *testing.T) {
+	rec := similarAddrsRecord(t, 16)
+	for _, codec := range []RecordCodec{ProtobufCodec, CBORCodec, FlatbuffersCodec} {
+		plain, err := encodeRecord(codec, CompressionNone, rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed, err := encodeRecord(codec, CompressionSnappy, rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(compressed) > len(plain)*3/4 {
+			t.Fatalf("%d: expected compression to shrink records of similar addresses, got %d bytes from %d", codec.ID(), len(compressed), len(plain))
+		}
+
+		decoded := new(pb.AddrBookRecord)
+		if err := decodeRecord(compressed, decoded); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := rec.Marshal()
+		if got, _ := decoded.Marshal(); !bytes.Equal(got, want) {
+			t.Fatalf("%d: expected the record to survive compression", codec.ID())
+		}
+	}
+
+	// records that don't shrink are stored as is.
+	small := similarAddrsRecord(t, 0)
+	plain, _ := encodeRecord(ProtobufCodec, CompressionNone, small)
+	if compressed, _ := encodeRecord(ProtobufCodec, CompressionSnappy, small); !bytes.Equal(compressed, plain) {
+		t.Fatal("expected an incompressible record to be stored uncompressed")
+	}
+
+	// unknown compressions are rejected.
+	bad := append([]byte{recordHeaderMagic, ProtobufCodec.ID(), 3}, plain...)
+	if err := decodeRecord(bad, new(pb.AddrBookRecord)); err == nil {
+		t.Fatal("expected an error decoding a record with an unknown compression")
+	}
+	opts := DefaultOpts()
+	opts.Compression = 3
+	if _, err := NewAddrBook(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts); err == nil {
+		t.Fatal("expected an error when using an unknown compression")
+	}
+}
+
+func TestMixedRecordCompression(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	ids := test.GeneratePeerIDs(2)
+	addrs := test.GenerateAddrs(32)
+
+	newBook := func(compression RecordCompression) *dsAddrBook {
+		opts := DefaultOpts()
+		opts.CacheSize = 0
+		opts.GCPurgeInterval = 0
+		opts.Compression = compression
+		ab, err := NewAddrBook(context.Background(), store, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ab
+	}
+
+	ab := newBook(CompressionNone)
+	ab.AddAddrs(ids[0], addrs[:16], time.Hour)
+	ab.Close()
+
+	ab = newBook(CompressionSnappy)
+	test.AssertAddressesEqual(t, addrs[:16], ab.Addrs(ids[0]))
+	ab.AddAddrs(ids[1], addrs[16:], time.Hour)
+	ab.Close()
+
+	ab = newBook(CompressionNone)
+	defer ab.Close()
+	test.AssertAddressesEqual(t, addrs[:16], ab.Addrs(ids[0]))
+	test.AssertAddressesEqual(t, addrs[16:], ab.Addrs(ids[1]))
+}
+
+func BenchmarkDecodeRecord(b *testing.B) {
+	rec := similarAddrsRecord(b, 16)
+	for _, codec := range []RecordCodec{ProtobufCodec, CBORCodec, FlatbuffersCodec} {
+		for _, compression := range []RecordCompression{CompressionNone, CompressionSnappy} {
+			data, err := encodeRecord(codec, compression, rec)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%d/%s", codec.ID(), compression), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(data)))
+				decoded := new(pb.AddrBookRecord)
+				for i := 0; i < b.N; i++ {
+					if err := decodeRecord(data, decoded); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package pstoreds
+
+import (
+	"sync/atomic"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+// corruptReporter counts the undecodable entries skipped while iterating the datastore, and reports them to
+// Options.OnCorruptRecord. The books of a peerstore share a single reporter.
+type corruptReporter struct {
+	count    uint64 // accessed atomically
+	callback func(key ds.Key, err error)
+}
+
+func newCorruptReporter(opts Options) *corruptReporter {
+	return &corruptReporter{callback: opts.OnCorruptRecord}
+}
+
+// report records that the entry at key was skipped because it couldn't be decoded.
+func (r *corruptReporter) report(key ds.Key, err error) {
+	atomic.AddUint64(&r.count, 1)
+	log.Warnf("skipping corrupt datastore entry %s: %v", key, err)
+	if r.callback != nil {
+		r.callback(key, err)
+	}
+}
+
+func (r *corruptReporter) reported() uint64 {
+	return atomic.LoadUint64(&r.count)
+}
@@ -4,14 +4,18 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
 	badger "github.com/ipfs/go-ds-badger"
 	leveldb "github.com/ipfs/go-ds-leveldb"
 
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
 	pt "github.com/libp2p/go-libp2p-peerstore/test"
 )
 
@@ -27,11 +31,22 @@ func TestDsPeerstore(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			pt.TestPeerstore(t, peerstoreFactory(t, dsFactory, DefaultOpts()))
 		})
+		t.Run(name+" PerAddr", func(t *testing.T) {
+			opts := DefaultOpts()
+			opts.AddrLayout = AddrLayoutPerAddr
+			pt.TestPeerstore(t, peerstoreFactory(t, dsFactory, opts))
+		})
+		t.Run(name+" Strict", func(t *testing.T) {
+			opts := DefaultOpts()
+			opts.StrictChecks = true
+			pt.TestPeerstore(t, peerstoreFactory(t, dsFactory, opts))
+		})
 	}
 }
 
 func TestDsAddrBook(t *testing.T) {
 	for name, dsFactory := range dstores {
+		dsFactory := dsFactory
 		t.Run(name+" Cacheful", func(t *testing.T) {
 			t.Parallel()
 
@@ -39,7 +54,7 @@ func TestDsAddrBook(t *testing.T) {
 			opts.GCPurgeInterval = 1 * time.Second
 			opts.CacheSize = 1024
 
-			pt.TestAddrBook(t, addressBookFactory(t, dsFactory, opts))
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
 		})
 
 		t.Run(name+" Cacheless", func(t *testing.T) {
@@ -49,11 +64,206 @@ func TestDsAddrBook(t *testing.T) {
 			opts.GCPurgeInterval = 1 * time.Second
 			opts.CacheSize = 0
 
-			pt.TestAddrBook(t, addressBookFactory(t, dsFactory, opts))
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" CBOR", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.Codec = CBORCodec
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" Flatbuffers", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.CacheSize = 0
+			opts.Codec = FlatbuffersCodec
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" Snappy", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.CacheSize = 0
+			opts.Compression = CompressionSnappy
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" Strict", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.StrictChecks = true
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" ChangeNotify", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.ChangeNotifyInterval = 100 * time.Millisecond
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" RefreshCoalescing", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.CacheSize = 0
+			opts.RefreshFlushInterval = 100 * time.Millisecond
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" ReadRepair", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.CacheSize = 1024
+			opts.CacheReconciliation = ReconcileReadRepair
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" TinyLFU", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.CacheSize = 1024
+			opts.CacheAdmission = CacheAdmissionTinyLFU
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" PerAddr", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.CacheSize = 0
+			opts.AddrLayout = AddrLayoutPerAddr
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
+		})
+
+		t.Run(name+" Debounced", func(t *testing.T) {
+			t.Parallel()
+
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.AddrDebounce = 100 * time.Millisecond
+
+			pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, dsFactory, opts))
 		})
 	}
 }
 
+// TestDsInjectedDatastore runs the suites against the datastore injected by them.
+func TestDsInjectedDatastore(t *testing.T) {
+	pt.TestPeerstoreWithDeps(t, depsPeerstoreFactory(t, DefaultOpts()))
+	pt.TestAddrBookWithDeps(t, depsAddressBookFactory(t, nil, DefaultOpts()))
+	pt.TestKeyBookWithDeps(t, func(deps *pt.Deps) (pstore.KeyBook, func()) {
+		kb, err := NewKeyBook(context.Background(), deps.Datastore, DefaultOpts())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kb, nil
+	})
+}
+
+func TestDsMemDifferential(t *testing.T) {
+	mem := func(deps *pt.Deps) (pstore.Peerstore, func()) {
+		ps := pstoremem.NewPeerstore(pstoremem.WithClock(deps.Clock))
+		return ps, func() { ps.Close() }
+	}
+	for _, layout := range []AddrLayout{AddrLayoutPerPeer, AddrLayoutPerAddr} {
+		layout := layout
+		t.Run(layout.String(), func(t *testing.T) {
+			opts := DefaultOpts()
+			opts.AddrLayout = layout
+			opts.GCPurgeInterval = 0
+			pt.TestDifferential(t, mem, depsPeerstoreFactory(t, opts), pt.DifferentialOptions{})
+		})
+	}
+}
+
+func TestDsClone(t *testing.T) {
+	pt.TestClone(t, peerstoreFactory(t, badgerStore, DefaultOpts()))
+}
+
+func TestDsNamespace(t *testing.T) {
+	opts := DefaultOpts()
+	opts.Namespace = ds.NewKey("/p2p/peerstore")
+	pt.TestPeerstoreWithDeps(t, depsPeerstoreFactory(t, opts))
+	pt.TestClone(t, peerstoreFactory(t, badgerStore, opts))
+
+	t.Run("Isolation", func(t *testing.T) {
+		store := dssync.MutexWrap(ds.NewMapDatastore())
+		open := func(ns string) *pstoreds {
+			opts := DefaultOpts()
+			opts.Namespace = ds.NewKey(ns)
+			ps, err := NewPeerstore(context.Background(), store, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ps
+		}
+		a, b, shared := open("/a"), open("/b"), open("/a")
+		defer a.Close()
+		defer b.Close()
+		defer shared.Close()
+
+		id := pt.GeneratePeerIDs(1)[0]
+		addrs := pt.GenerateAddrs(1)
+		a.AddAddrs(id, addrs, time.Hour)
+		if err := a.Put(id, "key", "value"); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(b.Peers()) != 0 || len(b.Addrs(id)) != 0 {
+			t.Fatal("expected peerstores in different namespaces to be isolated")
+		}
+		if _, err := b.Get(id, "key"); err != pstore.ErrNotFound {
+			t.Fatalf("expected metadata to be isolated, got %v", err)
+		}
+		pt.AssertAddressesEqual(t, addrs, shared.Addrs(id))
+		if v, err := shared.Get(id, "key"); err != nil || v != "value" {
+			t.Fatalf("expected metadata to be shared, got %v, %v", v, err)
+		}
+
+		for prefix, want := range map[string]bool{"/a/peers": true, "/peers": false} {
+			res, err := store.Query(query.Query{Prefix: prefix, KeysOnly: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := res.Rest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(entries) > 0) != want {
+				t.Errorf("expected entries under %s: %t, got %d", prefix, want, len(entries))
+			}
+		}
+	})
+}
+
 func TestDsKeyBook(t *testing.T) {
 	for name, dsFactory := range dstores {
 		t.Run(name, func(t *testing.T) {
@@ -87,6 +297,107 @@ func BenchmarkDsPeerstore(b *testing.B) {
 	}
 }
 
+// BenchmarkDsAddrLayout compares the address layouts when adding an address to a peer holding many, and when reading
+// them back without a cache. Bytes written to the datastore are reported per operation.
+func BenchmarkDsAddrLayout(b *testing.B) {
+	const held = 64
+
+	for _, layout := range []AddrLayout{AddrLayoutPerPeer, AddrLayoutPerAddr} {
+		opts := DefaultOpts()
+		opts.CacheSize = 0
+		opts.GCPurgeInterval = 0
+		opts.AddrLayout = layout
+
+		open := func(b *testing.B) (*dsAddrBook, *int64) {
+			var written int64
+			store := &countingStore{Batching: dssync.MutexWrap(ds.NewMapDatastore()), written: &written}
+			ab, err := NewAddrBook(context.Background(), store, opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			return ab, &written
+		}
+
+		b.Run(layout.String()+"/Add", func(b *testing.B) {
+			ab, written := open(b)
+			defer ab.Close()
+			p := pt.GeneratePeerIDs(1)[0]
+			addrs := pt.GenerateAddrs(2 * held)
+			ab.AddAddrs(p, addrs[:held], time.Hour)
+
+			*written = 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// a growing TTL makes every call update the expiry of the address, once it's been added.
+				ab.AddAddr(p, addrs[held+i%held], time.Hour+time.Duration(i)*time.Second)
+			}
+			b.ReportMetric(float64(*written)/float64(b.N), "B-written/op")
+		})
+
+		b.Run(layout.String()+"/Read", func(b *testing.B) {
+			ab, _ := open(b)
+			defer ab.Close()
+			p := pt.GeneratePeerIDs(1)[0]
+			ab.AddAddrs(p, pt.GenerateAddrs(held), time.Hour)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if len(ab.Addrs(p)) != held {
+					b.Fatal("unexpected number of addresses")
+				}
+			}
+		})
+	}
+}
+
+// countingStore counts the bytes written through it, batches included.
+type countingStore struct {
+	ds.Batching
+	written *int64
+}
+
+func (s *countingStore) Put(key ds.Key, value []byte) error {
+	atomic.AddInt64(s.written, int64(len(value)))
+	return s.Batching.Put(key, value)
+}
+
+func (s *countingStore) Batch() (ds.Batch, error) {
+	batch, err := s.Batching.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &countingBatch{Batch: batch, written: s.written}, nil
+}
+
+type countingBatch struct {
+	ds.Batch
+	written *int64
+}
+
+func (b *countingBatch) Put(key ds.Key, value []byte) error {
+	atomic.AddInt64(b.written, int64(len(value)))
+	return b.Batch.Put(key, value)
+}
+
+// BenchmarkDsOptionsMatrix compares cache sizes and GC purge intervals under a synthetic workload. Run it with
+// -benchtime=100000x or larger, so that the trace runs long enough for the GC to kick in.
+func BenchmarkDsOptionsMatrix(b *testing.B) {
+	trace := pt.GenerateWorkload(b, pt.DefaultWorkloadMix, 100000, 1)
+
+	for name, dsFactory := range dstores {
+		dsFactory := dsFactory
+		b.Run(name, func(b *testing.B) {
+			pt.BenchmarkOptionsMatrix(b, func(point pt.OptionsPoint) (pstore.Peerstore, func()) {
+				opts := DefaultOpts()
+				opts.CacheSize = uint(point.CacheSize)
+				opts.GCPurgeInterval = point.GCPurgeInterval
+				opts.GCInitialDelay = 0
+				return peerstoreFactory(b, dsFactory, opts)()
+			}, pt.DefaultOptionsMatrix, trace)
+		})
+	}
+}
+
 func badgerStore(tb testing.TB) (ds.Batching, func()) {
 	dataPath, err := ioutil.TempDir(os.TempDir(), "badger")
 	if err != nil {
@@ -159,3 +470,57 @@ func keyBookFactory(tb testing.TB, storeFactory datastoreFactory, opts Options)
 		return kb, storeCloseFn
 	}
 }
+
+// depsOptions returns opts wired to the dependencies injected by the suites.
+func depsOptions(opts Options, deps *pt.Deps) Options {
+	c := deps.Config
+	opts.Clock = deps.Clock
+	opts.TTLJitter = c.TTLJitter
+	opts.MaxAddrTTL = c.MaxAddrTTL
+	opts.MaxAddrLen = c.MaxAddrLen
+	opts.AddrOrder = c.AddrOrder
+	opts.AddrAliases = c.AddrAliases
+	opts.AddrHistorySize = c.AddrHistory
+	opts.ExoticAddrPolicy = c.ExoticAddrPolicy
+	opts.P2PAddrPolicy = c.P2PAddrPolicy
+	opts.AuditSink = c.AuditSink
+	opts.ChangeLogSize = c.ChangeLog
+	if c.TTLPolicy != nil {
+		opts.TTLPolicy = c.TTLPolicy
+	}
+	if c.IDValidator != nil {
+		opts.IDValidator = c.IDValidator
+	}
+	return opts
+}
+
+// depsAddressBookFactory creates address books wired to the clock injected by the suite. If storeFactory is nil,
+// the datastore injected by the suite is used.
+func depsAddressBookFactory(tb testing.TB, storeFactory datastoreFactory, opts Options) pt.AddrBookDepsFactory {
+	return func(deps *pt.Deps) (pstore.AddrBook, func()) {
+		opts := depsOptions(opts, deps)
+		store, closeFunc := ds.Batching(deps.Datastore), func() {}
+		if storeFactory != nil {
+			store, closeFunc = storeFactory(tb)
+		}
+		ab, err := NewAddrBook(context.Background(), store, opts)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		closer := func() {
+			ab.Close()
+			closeFunc()
+		}
+		return ab, closer
+	}
+}
+
+func depsPeerstoreFactory(tb testing.TB, opts Options) pt.PeerstoreDepsFactory {
+	return func(deps *pt.Deps) (pstore.Peerstore, func()) {
+		ps, err := NewPeerstore(context.Background(), deps.Datastore, depsOptions(opts, deps))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return ps, func() { ps.Close() }
+	}
+}
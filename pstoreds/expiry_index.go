@@ -0,0 +1,146 @@
+package pstoreds
+
+import (
+	"container/heap"
+	"sync"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// expiryIndex tracks the soonest address expiry of every peer in the address book, so that GC can visit only the
+// records that have something to purge.
+//
+// It is backed by a map holding the current expiry of each peer, and a min-heap ordered by expiry. Heap entries are
+// invalidated lazily: an update pushes a new entry and leaves the old one behind, which is discarded when popped if
+// it no longer matches the map.
+type expiryIndex struct {
+	sync.Mutex
+	expiries map[peer.ID]int64
+	queue    expiryHeap
+	seeded   bool
+}
+
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{expiries: make(map[peer.ID]int64)}
+}
+
+// update sets the soonest expiry of a peer.
+func (idx *expiryIndex) update(p peer.ID, expiry int64) {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.set(p, expiry)
+}
+
+// seed records an expiry read from the datastore while the index is being seeded. It keeps the soonest of the known
+// and the seeded expiries, as the seeded one may be stale if the record was written concurrently; an early expiry
+// only results in a spurious visit.
+func (idx *expiryIndex) seed(p peer.ID, expiry int64) {
+	idx.Lock()
+	defer idx.Unlock()
+	if curr, ok := idx.expiries[p]; !ok || expiry < curr {
+		idx.set(p, expiry)
+	}
+}
+
+func (idx *expiryIndex) set(p peer.ID, expiry int64) {
+	if curr, ok := idx.expiries[p]; ok && curr == expiry {
+		return
+	}
+	idx.expiries[p] = expiry
+	heap.Push(&idx.queue, expiryEntry{id: p, expiry: expiry})
+
+	// rebuild the heap when stale entries dominate it, to bound memory usage.
+	if len(idx.queue) > 2*len(idx.expiries)+64 {
+		idx.queue = idx.queue[:0]
+		for id, exp := range idx.expiries {
+			idx.queue = append(idx.queue, expiryEntry{id: id, expiry: exp})
+		}
+		heap.Init(&idx.queue)
+	}
+}
+
+// remove drops a peer from the index.
+func (idx *expiryIndex) remove(p peer.ID) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	// the heap entry will be discarded when popped.
+	delete(idx.expiries, p)
+}
+
+// due pops and returns the peers whose soonest expiry is at or before now. Returned peers are removed from the
+// index; they are expected to be re-indexed once their records have been cleaned.
+func (idx *expiryIndex) due(now int64) []peer.ID {
+	idx.Lock()
+	defer idx.Unlock()
+
+	var ids []peer.ID
+	for len(idx.queue) > 0 && idx.queue[0].expiry <= now {
+		e := heap.Pop(&idx.queue).(expiryEntry)
+		if curr, ok := idx.expiries[e.id]; !ok || curr != e.expiry {
+			// stale entry.
+			continue
+		}
+		delete(idx.expiries, e.id)
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+// before returns the peers whose soonest expiry is at or before limit, without removing them from the index.
+func (idx *expiryIndex) before(limit int64) []peer.ID {
+	idx.Lock()
+	defer idx.Unlock()
+
+	var ids []peer.ID
+	seen := make(map[peer.ID]struct{})
+	// walk the heap from its root, pruning subtrees whose root is past the limit, as their entries can only be later.
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(idx.queue) || idx.queue[i].expiry > limit {
+			continue
+		}
+		e := idx.queue[i]
+		if curr, ok := idx.expiries[e.id]; ok && curr == e.expiry {
+			if _, dup := seen[e.id]; !dup {
+				seen[e.id] = struct{}{}
+				ids = append(ids, e.id)
+			}
+		}
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+	return ids
+}
+
+func (idx *expiryIndex) isSeeded() bool {
+	idx.Lock()
+	defer idx.Unlock()
+	return idx.seeded
+}
+
+func (idx *expiryIndex) markSeeded() {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.seeded = true
+}
+
+type expiryEntry struct {
+	id     peer.ID
+	expiry int64
+}
+
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expiry < h[j].expiry }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryEntry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,139 @@
+package pstoreds
+
+import (
+	"context"
+	"sort"
+
+	base32 "github.com/multiformats/go-base32"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// Group memberships are stored twice, with empty values, so that both the members of a group and the groups of a peer
+// are listed with a single prefix query:
+// /peers/groups/<b32 group no padding>/<b32 peer id no padding>
+// /peers/peergroups/<b32 peer id no padding>/<b32 group no padding>
+var (
+	groupsBase     = ds.NewKey("/peers/groups")
+	peerGroupsBase = ds.NewKey("/peers/peergroups")
+)
+
+type dsGroupBook struct {
+	ds         ds.Datastore
+	corrupt    *corruptReporter
+	validateID pstore.IDValidator
+	gens       *pstore.Generations // set by NewPeerstore.
+}
+
+var _ pstore.GroupBook = (*dsGroupBook)(nil)
+
+// NewGroupBook creates a group book backed by a persistent db. It can be used on its own; the retry policy in opts
+// then applies if store implements ds.Batching.
+func NewGroupBook(_ context.Context, store ds.Datastore, opts Options) (*dsGroupBook, error) {
+	return &dsGroupBook{
+		ds:         bookStore(store, opts),
+		corrupt:    newCorruptReporter(opts),
+		validateID: idValidator(opts),
+	}, nil
+}
+
+func groupKeys(p peer.ID, group string) (member, membership ds.Key) {
+	b32p := base32.RawStdEncoding.EncodeToString([]byte(p))
+	b32g := base32.RawStdEncoding.EncodeToString([]byte(group))
+	return groupsBase.ChildString(b32g).ChildString(b32p), peerGroupsBase.ChildString(b32p).ChildString(b32g)
+}
+
+func (gb *dsGroupBook) AddToGroup(p peer.ID, group string) error {
+	if err := gb.validateID(p); err != nil {
+		return err
+	}
+	if group == "" {
+		return pstore.ErrEmptyGroup
+	}
+	member, membership := groupKeys(p, group)
+	err := multiWrite(gb.ds, func(_ ds.Read, w ds.Write) error {
+		if err := w.Put(member, []byte{}); err != nil {
+			return err
+		}
+		return w.Put(membership, []byte{})
+	})
+	if err != nil {
+		return err
+	}
+	gb.gens.Bump(p)
+	return nil
+}
+
+func (gb *dsGroupBook) RemoveFromGroup(p peer.ID, group string) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if group == "" {
+		return pstore.ErrEmptyGroup
+	}
+	member, membership := groupKeys(p, group)
+	err := multiWrite(gb.ds, func(_ ds.Read, w ds.Write) error {
+		if err := w.Delete(member); err != nil {
+			return err
+		}
+		return w.Delete(membership)
+	})
+	if err != nil {
+		return err
+	}
+	gb.gens.Bump(p)
+	return nil
+}
+
+func (gb *dsGroupBook) Groups(p peer.ID) []string {
+	prefix := peerGroupsBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+	results, err := gb.ds.Query(query.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		log.Errorf("failed to list the groups of peer %s: %s", p.Pretty(), err)
+		return nil
+	}
+	defer results.Close()
+
+	res := []string{}
+	for result := range results.Next() {
+		if result.Error != nil {
+			log.Errorf("failed to list the groups of peer %s: %s", p.Pretty(), result.Error)
+			break
+		}
+		k := ds.RawKey(result.Key)
+		g, err := base32.RawStdEncoding.DecodeString(k.Name())
+		if err != nil {
+			gb.corrupt.report(k, err)
+			continue
+		}
+		res = append(res, string(g))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// peers returns the peers that are members of any group.
+func (gb *dsGroupBook) peers() peer.IDSlice {
+	ids, err := uniquePeerIds(gb.ds, peerGroupsBase, gb.corrupt, gb.validateID, func(result query.Result) string {
+		return ds.RawKey(result.Key).Parent().Name()
+	})
+	if err != nil {
+		log.Errorf("error while retrieving peers with group memberships: %v", err)
+	}
+	return ids
+}
+
+func (gb *dsGroupBook) GroupMembers(group string) peer.IDSlice {
+	prefix := groupsBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(group)))
+	ids, err := uniquePeerIds(gb.ds, prefix, gb.corrupt, gb.validateID, func(result query.Result) string {
+		return ds.RawKey(result.Key).Name()
+	})
+	if err != nil {
+		log.Errorf("failed to list the members of group %s: %s", group, err)
+	}
+	return ids
+}
@@ -0,0 +1,111 @@
+package pstoreds
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	pb "github.com/libp2p/go-libp2p-peerstore/pb"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+var _ pstore.InvariantChecker = (*dsAddrBook)(nil)
+var _ pstore.InvariantChecker = (*pstoreds)(nil)
+
+// CheckInvariants verifies that:
+//
+// * cached records are sorted by expiry, and hold the non-expired entries of the records in the datastore;
+// * in the per-address layout, cached records know the entries stored for them, from which they compute their writes;
+// * the expiry index, if enabled, doesn't schedule a peer later than the soonest expiry of its record;
+// * reads don't return expired addresses, except for protected peers, which retain them.
+func (ab *dsAddrBook) CheckInvariants() error {
+	for _, k := range ab.cache.Keys() {
+		e, ok := ab.cache.Peek(k)
+		if !ok {
+			continue
+		}
+		if err := ab.checkCachedRecord(e.(*addrsRecord)); err != nil {
+			return err
+		}
+	}
+
+	now := ab.clock.Now()
+	for _, p := range ab.PeersWithAddrs() {
+		if ab.IsProtected(p, "") {
+			continue
+		}
+		for _, a := range ab.AddrsWithExpiry(p) {
+			if a.Expires.Unix() <= now.Unix() {
+				return fmt.Errorf("expired address %s returned for peer %s", a.Addr, p.Pretty())
+			}
+		}
+	}
+	return nil
+}
+
+func (ab *dsAddrBook) checkCachedRecord(cached *addrsRecord) error {
+	cached.RLock()
+	defer cached.RUnlock()
+
+	p := cached.Id.ID
+	for i := 1; i < len(cached.Addrs); i++ {
+		if cached.Addrs[i-1].Expiry > cached.Addrs[i].Expiry {
+			return fmt.Errorf("cached record of peer %s isn't sorted by expiry", p.Pretty())
+		}
+	}
+
+	stored := &addrsRecord{AddrBookRecord: new(pb.AddrBookRecord)}
+	if _, err := ab.records.load(ab.ds, p, stored); err != nil {
+		return fmt.Errorf("failed to load the record of peer %s: %s", p.Pretty(), err)
+	}
+	now := ab.clock.Now().Unix()
+	if ab.IsProtected(p, "") {
+		now = math.MinInt64
+	}
+	live := func(r *addrsRecord) map[string]int64 {
+		m := make(map[string]int64, len(r.Addrs))
+		for _, a := range r.Addrs {
+			if a.Expiry > now {
+				m[string(a.Addr.Bytes())] = a.Expiry
+			}
+		}
+		return m
+	}
+	want, got := live(stored), live(cached)
+	if len(want) != len(got) {
+		return fmt.Errorf("cached record of peer %s holds %d live addresses, but %d are stored", p.Pretty(), len(got), len(want))
+	}
+	for a, exp := range want {
+		if got[a] != exp {
+			return fmt.Errorf("cached record of peer %s disagrees with the stored one on the expiry of an address", p.Pretty())
+		}
+	}
+
+	if _, ok := ab.records.(perAddrStore); ok {
+		if len(cached.stored) != len(stored.stored) {
+			return fmt.Errorf("cached record of peer %s knows %d stored entries, but %d are stored", p.Pretty(), len(cached.stored), len(stored.stored))
+		}
+		for name, data := range stored.stored {
+			if !bytes.Equal(cached.stored[name], data) {
+				return fmt.Errorf("cached record of peer %s doesn't know the stored entry %s", p.Pretty(), name)
+			}
+		}
+	}
+
+	if ab.expiries != nil && len(cached.Addrs) > 0 {
+		soonest := int64(math.MaxInt64)
+		for _, exp := range want {
+			if exp < soonest {
+				soonest = exp
+			}
+		}
+		ab.expiries.Lock()
+		indexed, ok := ab.expiries.expiries[p]
+		ab.expiries.Unlock()
+		if ok && indexed > soonest {
+			return fmt.Errorf("peer %s is indexed to expire at %d, after its soonest expiry at %d", p.Pretty(), indexed, soonest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package pstoreds
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	ma "github.com/multiformats/go-multiaddr"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// The namespaces of the persistent peer store of js-libp2p. Entries are keyed by the peer ID encoded as a base32
+// CIDv1, and metadata entries by key below it: /peers/<namespace>/<cid>[/<metadata key>].
+var (
+	jsAddrsBase    = ds.NewKey("/peers/addrs")
+	jsKeysBase     = ds.NewKey("/peers/keys")
+	jsMetadataBase = ds.NewKey("/peers/metadata")
+	jsProtosBase   = ds.NewKey("/peers/protos")
+)
+
+// jsStringMetadata are the metadata keys js-libp2p persists as UTF-8 bytes that go-libp2p stores as strings.
+var jsStringMetadata = map[string]bool{
+	"AgentVersion":    true,
+	"ProtocolVersion": true,
+}
+
+// jsAddresses mirrors the Addresses message of js-libp2p's address book.
+type jsAddresses struct {
+	Addrs           []*jsAddress       `protobuf:"bytes,1,rep,name=addrs,proto3"`
+	CertifiedRecord *jsCertifiedRecord `protobuf:"bytes,2,opt,name=certified_record,proto3"`
+}
+
+type jsAddress struct {
+	Multiaddr   []byte `protobuf:"bytes,1,opt,name=multiaddr,proto3"`
+	IsCertified bool   `protobuf:"varint,2,opt,name=isCertified,proto3"`
+}
+
+type jsCertifiedRecord struct {
+	Seq uint64 `protobuf:"varint,1,opt,name=seq,proto3"`
+	Raw []byte `protobuf:"bytes,2,opt,name=raw,proto3"`
+}
+
+// jsProtocols mirrors the Protocols message of js-libp2p's proto book.
+type jsProtocols struct {
+	Protocols []string `protobuf:"bytes,1,rep,name=protocols,proto3"`
+}
+
+func (m *jsAddresses) Reset()         { *m = jsAddresses{} }
+func (m *jsAddresses) String() string { return proto.CompactTextString(m) }
+func (*jsAddresses) ProtoMessage()    {}
+
+func (m *jsAddress) Reset()         { *m = jsAddress{} }
+func (m *jsAddress) String() string { return proto.CompactTextString(m) }
+func (*jsAddress) ProtoMessage()    {}
+
+func (m *jsCertifiedRecord) Reset()         { *m = jsCertifiedRecord{} }
+func (m *jsCertifiedRecord) String() string { return proto.CompactTextString(m) }
+func (*jsCertifiedRecord) ProtoMessage()    {}
+
+func (m *jsProtocols) Reset()         { *m = jsProtocols{} }
+func (m *jsProtocols) String() string { return proto.CompactTextString(m) }
+func (*jsProtocols) ProtoMessage()    {}
+
+// ExportJS writes the addresses, public keys, protocols and metadata ps holds about its peers to dst, in the layout
+// of the persistent peer store of js-libp2p, so that a js-libp2p node opened over dst loads them. The certified
+// record of a peer, if any, is exported along with its addresses. Address expiries are not: js-libp2p keeps
+// addresses until they are replaced. Metadata values are exported only if they are strings or byte slices, the
+// metadata keys of peerstores that don't implement PeerStateReader are limited to the agent and protocol versions,
+// and private keys are never exported.
+func ExportJS(ctx context.Context, ps peerstore.Peerstore, dst ds.Datastore) error {
+	cab, _ := peerstore.GetCertifiedAddrBook(ps)
+	sr, _ := ps.(pstore.PeerStateReader)
+
+	for _, p := range ps.Peers() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := peer.ToCid(p).String()
+
+		if addrs := ps.Addrs(p); len(addrs) > 0 {
+			v, err := exportJSAddrs(cab, p, addrs)
+			if err != nil {
+				return fmt.Errorf("failed to export the addresses of peer %s: %s", p, err)
+			}
+			if err := dst.Put(jsAddrsBase.ChildString(name), v); err != nil {
+				return err
+			}
+		}
+
+		if pk := ps.PubKey(p); pk != nil {
+			v, err := ic.MarshalPublicKey(pk)
+			if err != nil {
+				return fmt.Errorf("failed to export the public key of peer %s: %s", p, err)
+			}
+			if err := dst.Put(jsKeysBase.ChildString(name), v); err != nil {
+				return err
+			}
+		}
+
+		if protos, err := ps.GetProtocols(p); err != nil {
+			return err
+		} else if len(protos) > 0 {
+			v, err := proto.Marshal(&jsProtocols{Protocols: protos})
+			if err != nil {
+				return err
+			}
+			if err := dst.Put(jsProtosBase.ChildString(name), v); err != nil {
+				return err
+			}
+		}
+
+		var keys []string
+		if sr != nil {
+			keys = sr.GetPeerState(p).MetadataKeys
+		} else {
+			for k := range jsStringMetadata {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			val, err := ps.Get(p, k)
+			if err == peerstore.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			var v []byte
+			switch val := val.(type) {
+			case []byte:
+				v = val
+			case string:
+				v = []byte(val)
+			default:
+				continue
+			}
+			if err := dst.Put(jsMetadataBase.ChildString(name).ChildString(k), v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func exportJSAddrs(cab peerstore.CertifiedAddrBook, p peer.ID, addrs []ma.Multiaddr) ([]byte, error) {
+	msg := &jsAddresses{Addrs: make([]*jsAddress, 0, len(addrs))}
+
+	certified := make(map[string]bool)
+	if cab != nil {
+		if env := cab.GetPeerRecord(p); env != nil {
+			rec, err := env.Record()
+			if err != nil {
+				return nil, err
+			}
+			pr, ok := rec.(*peer.PeerRecord)
+			if !ok {
+				return nil, fmt.Errorf("envelope did not contain PeerRecord")
+			}
+			raw, err := env.Marshal()
+			if err != nil {
+				return nil, err
+			}
+			msg.CertifiedRecord = &jsCertifiedRecord{Seq: pr.Seq, Raw: raw}
+			for _, a := range pr.Addrs {
+				certified[string(a.Bytes())] = true
+			}
+		}
+	}
+
+	for _, a := range addrs {
+		msg.Addrs = append(msg.Addrs, &jsAddress{Multiaddr: a.Bytes(), IsCertified: certified[string(a.Bytes())]})
+	}
+	return proto.Marshal(msg)
+}
+
+// ImportJS adds the peers persisted in src by the persistent peer store of js-libp2p to ps. js-libp2p doesn't persist
+// address expiries, so addresses are added with ttl; certified records are consumed with it too, and their
+// signatures checked. Metadata values are added as byte slices, except for the agent and protocol versions, added as
+// strings like go-libp2p does. Entries of unknown namespaces are skipped.
+func ImportJS(ctx context.Context, src ds.Datastore, ps peerstore.Peerstore, ttl time.Duration) error {
+	cab, _ := peerstore.GetCertifiedAddrBook(ps)
+
+	results, err := src.Query(query.Query{Prefix: peersBase.String()})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	for result := range results.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := importJSEntry(ps, cab, ds.RawKey(result.Key), result.Value, ttl); err != nil {
+			return fmt.Errorf("failed to import entry %s: %s", result.Key, err)
+		}
+	}
+	return nil
+}
+
+func importJSEntry(ps peerstore.Peerstore, cab peerstore.CertifiedAddrBook, k ds.Key, v []byte, ttl time.Duration) error {
+	list := k.List()
+	if len(list) < 3 {
+		return nil
+	}
+	ns := ds.NewKey(list[0]).ChildString(list[1])
+	switch {
+	case ns.Equal(jsMetadataBase):
+		if len(list) != 4 {
+			return nil
+		}
+	case ns.Equal(jsAddrsBase), ns.Equal(jsKeysBase), ns.Equal(jsProtosBase):
+		if len(list) != 3 {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	c, err := cid.Decode(list[2])
+	if err != nil {
+		return err
+	}
+	p, err := peer.FromCid(c)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case ns.Equal(jsAddrsBase):
+		var msg jsAddresses
+		if err := proto.Unmarshal(v, &msg); err != nil {
+			return err
+		}
+		if msg.CertifiedRecord != nil && len(msg.CertifiedRecord.Raw) > 0 && cab != nil {
+			env, err := record.ConsumeTypedEnvelope(msg.CertifiedRecord.Raw, &peer.PeerRecord{})
+			if err != nil {
+				return err
+			}
+			if _, err := cab.ConsumePeerRecord(env, ttl); err != nil {
+				return err
+			}
+		}
+		addrs := make([]ma.Multiaddr, 0, len(msg.Addrs))
+		for _, a := range msg.Addrs {
+			addr, err := ma.NewMultiaddrBytes(a.Multiaddr)
+			if err != nil {
+				log.Warnf("skipping invalid address of peer %s: %s", p, err)
+				continue
+			}
+			addrs = append(addrs, addr)
+		}
+		ps.AddAddrs(p, addrs, ttl)
+
+	case ns.Equal(jsKeysBase):
+		pk, err := ic.UnmarshalPublicKey(v)
+		if err != nil {
+			return err
+		}
+		return ps.AddPubKey(p, pk)
+
+	case ns.Equal(jsProtosBase):
+		var msg jsProtocols
+		if err := proto.Unmarshal(v, &msg); err != nil {
+			return err
+		}
+		if len(msg.Protocols) > 0 {
+			return ps.AddProtocols(p, msg.Protocols...)
+		}
+
+	case ns.Equal(jsMetadataBase):
+		key := list[3]
+		if jsStringMetadata[key] {
+			return ps.Put(p, key, string(v))
+		}
+		return ps.Put(p, key, append([]byte(nil), v...))
+	}
+	return nil
+}
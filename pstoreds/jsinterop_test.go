@@ -0,0 +1,172 @@
+package pstoreds
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ma "github.com/multiformats/go-multiaddr"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+)
+
+func newJSTestPeerstore(t *testing.T) *pstoreds {
+	t.Helper()
+	ps, err := NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func TestJSInteropRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newJSTestPeerstore(t)
+	defer src.Close()
+
+	sk, pk, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	unsigned := ma.StringCast("/ip4/1.2.3.4/udp/4001/quic")
+	env, err := record.Seal(peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{signed}}), sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.ConsumePeerRecord(env, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	src.AddAddr(id, unsigned, time.Hour)
+	if err := src.AddPubKey(id, pk); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.AddProtocols(id, "/ipfs/id/1.0.0", "/ipfs/ping/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Put(id, "AgentVersion", "go-ipfs/0.5.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Put(id, "raw", []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Put(id, "count", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := ds.NewMapDatastore()
+	if err := ExportJS(ctx, src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	name := peer.ToCid(id).String()
+	for _, k := range []ds.Key{
+		jsAddrsBase.ChildString(name),
+		jsKeysBase.ChildString(name),
+		jsProtosBase.ChildString(name),
+		jsMetadataBase.ChildString(name).ChildString("AgentVersion"),
+		jsMetadataBase.ChildString(name).ChildString("raw"),
+	} {
+		if ok, err := dst.Has(k); err != nil || !ok {
+			t.Fatalf("expected entry %s to be exported, err: %v", k, err)
+		}
+	}
+	if ok, _ := dst.Has(jsMetadataBase.ChildString(name).ChildString("count")); ok {
+		t.Fatal("expected non-byte metadata not to be exported")
+	}
+	if v, _ := dst.Get(jsMetadataBase.ChildString(name).ChildString("AgentVersion")); string(v) != "go-ipfs/0.5.0" {
+		t.Fatalf("unexpected agent version %q", v)
+	}
+
+	imported := newJSTestPeerstore(t)
+	defer imported.Close()
+	if err := ImportJS(ctx, dst, imported, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if addrs := imported.Addrs(id); len(addrs) != 2 {
+		t.Fatalf("expected 2 addresses, got %v", addrs)
+	}
+	if got := imported.GetPeerRecord(id); got == nil || !got.Equal(env) {
+		t.Fatal("expected the certified record to be imported")
+	}
+	if got := imported.PubKey(id); got == nil || !got.Equals(pk) {
+		t.Fatal("expected the public key to be imported")
+	}
+	if protos, _ := imported.GetProtocols(id); len(protos) != 2 {
+		t.Fatalf("expected 2 protocols, got %v", protos)
+	}
+	if v, err := imported.Get(id, "AgentVersion"); err != nil || v != "go-ipfs/0.5.0" {
+		t.Fatalf("expected the agent version as a string, got %v, err: %v", v, err)
+	}
+	if v, err := imported.Get(id, "raw"); err != nil || !bytes.Equal(v.([]byte), []byte{1, 2, 3}) {
+		t.Fatalf("expected raw metadata as bytes, got %v, err: %v", v, err)
+	}
+	if _, err := imported.Get(id, "count"); err != pstore.ErrNotFound {
+		t.Fatalf("expected non-byte metadata to be left out, err: %v", err)
+	}
+}
+
+func TestJSInteropImport(t *testing.T) {
+	_, pk, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := peer.ToCid(id).String()
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	// entries as js-libp2p encodes them.
+	src := ds.NewMapDatastore()
+	addrs := append([]byte{0x0a, byte(len(addr.Bytes()) + 2), 0x0a, byte(len(addr.Bytes()))}, addr.Bytes()...)
+	protos := append([]byte{0x0a, 4}, "/foo"...)
+	for k, v := range map[ds.Key][]byte{
+		jsAddrsBase.ChildString(name):                                addrs,
+		jsProtosBase.ChildString(name):                               protos,
+		jsMetadataBase.ChildString(name).ChildString("AgentVersion"): []byte("js-libp2p/0.28.0"),
+		ds.NewKey("/peers/unknown").ChildString(name):                {0xff},
+		ds.NewKey("/other"):                                          {0xff},
+	} {
+		if err := src.Put(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ps := newJSTestPeerstore(t)
+	defer ps.Close()
+	if err := ImportJS(context.Background(), src, ps, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got := ps.Addrs(id); len(got) != 1 || !got[0].Equal(addr) {
+		t.Fatalf("unexpected addresses %v", got)
+	}
+	if got, _ := ps.GetProtocols(id); len(got) != 1 || got[0] != "/foo" {
+		t.Fatalf("unexpected protocols %v", got)
+	}
+	if v, _ := ps.Get(id, "AgentVersion"); v != "js-libp2p/0.28.0" {
+		t.Fatalf("unexpected agent version %v", v)
+	}
+
+	// entries of peers that don't decode fail the import.
+	if err := src.Put(jsKeysBase.ChildString("notacid"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportJS(context.Background(), src, ps, time.Hour); err == nil {
+		t.Fatal("expected an error importing a malformed entry")
+	}
+}
@@ -3,6 +3,7 @@ package pstoreds
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	base32 "github.com/multiformats/go-base32"
 
@@ -10,8 +11,10 @@ import (
 	query "github.com/ipfs/go-datastore/query"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
 	peer "github.com/libp2p/go-libp2p-core/peer"
-	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
 // Public and private keys are stored under the following db key pattern:
@@ -23,54 +26,74 @@ var (
 )
 
 type dsKeyBook struct {
-	ds ds.Datastore
+	ds         ds.Datastore
+	auditor    auditor
+	zeroize    bool
+	corrupt    *corruptReporter
+	validateID pstore.IDValidator  // only used to list peers, as keys are checked against their peer ID.
+	gens       *pstore.Generations // set by NewPeerstore.
 }
 
-var _ pstore.KeyBook = (*dsKeyBook)(nil)
+var (
+	_ peerstore.KeyBook     = (*dsKeyBook)(nil)
+	_ pstore.KeyBookE       = (*dsKeyBook)(nil)
+	_ pstore.KeyTypeCounter = (*dsKeyBook)(nil)
+	_ pstore.KeyExporter    = (*dsKeyBook)(nil)
+)
 
-func NewKeyBook(_ context.Context, store ds.Datastore, _ Options) (*dsKeyBook, error) {
-	return &dsKeyBook{store}, nil
+// NewKeyBook creates a key book backed by a persistent db. It can be used on its own, by components that only need
+// keys; the retry policy in opts then applies if store implements ds.Batching.
+func NewKeyBook(_ context.Context, store ds.Datastore, opts Options) (*dsKeyBook, error) {
+	return &dsKeyBook{
+		ds:         bookStore(store, opts),
+		auditor:    newAuditor(opts),
+		zeroize:    opts.ZeroizeOnRemove,
+		corrupt:    newCorruptReporter(opts),
+		validateID: idValidator(opts),
+	}, nil
 }
 
+// PubKey returns the public key of a peer. Keys inlined in identity-hashed peer IDs (e.g. Ed25519) are extracted
+// from the ID when they haven't been added explicitly; they are not persisted, as they can be recovered at any time.
 func (kb *dsKeyBook) PubKey(p peer.ID) ic.PubKey {
+	pk, err := kb.PubKeyE(p)
+	if err != nil && err != peerstore.ErrNotFound {
+		log.Errorf("error when fetching pubkey for peer %s: %s\n", p.Pretty(), err)
+	}
+	return pk
+}
+
+// PubKeyE is like PubKey, but reports why the key could not be returned.
+func (kb *dsKeyBook) PubKeyE(p peer.ID) (ic.PubKey, error) {
 	key := kbBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p))).Child(pubSuffix)
 
-	var pk ic.PubKey
-	if value, err := kb.ds.Get(key); err == nil {
-		pk, err = ic.UnmarshalPublicKey(value)
+	value, err := kb.ds.Get(key)
+	switch err {
+	case nil:
+		pk, err := ic.UnmarshalPublicKey(value)
 		if err != nil {
-			log.Errorf("error when unmarshalling pubkey from datastore for peer %s: %s\n", p.Pretty(), err)
+			return nil, &pstore.KeyDecodeError{Peer: p, Err: err}
 		}
-	} else if err == ds.ErrNotFound {
-		pk, err = p.ExtractPublicKey()
+		return pk, nil
+	case ds.ErrNotFound:
+		pk, err := p.ExtractPublicKey()
 		switch err {
 		case nil:
+			return pk, nil
 		case peer.ErrNoPublicKey:
-			return nil
+			return nil, peerstore.ErrNotFound
 		default:
-			log.Errorf("error when extracting pubkey from peer ID for peer %s: %s\n", p.Pretty(), err)
-			return nil
-		}
-		pkb, err := pk.Bytes()
-		if err != nil {
-			log.Errorf("error when turning extracted pubkey into bytes for peer %s: %s\n", p.Pretty(), err)
-			return nil
-		}
-		err = kb.ds.Put(key, pkb)
-		if err != nil {
-			log.Errorf("error when adding extracted pubkey to peerstore for peer %s: %s\n", p.Pretty(), err)
-			return nil
+			return nil, &pstore.KeyDecodeError{Peer: p, Err: err}
 		}
-	} else {
-		log.Errorf("error when fetching pubkey from datastore for peer %s: %s\n", p.Pretty(), err)
+	default:
+		return nil, err
 	}
-
-	return pk
 }
 
 func (kb *dsKeyBook) AddPubKey(p peer.ID, pk ic.PubKey) error {
 	// check it's correct.
 	if !p.MatchesPublicKey(pk) {
+		kb.auditor.audit(pstore.AuditEvent{Type: pstore.AuditKeyMismatch, Peer: p})
 		return errors.New("peer ID does not match public key")
 	}
 
@@ -80,25 +103,33 @@ func (kb *dsKeyBook) AddPubKey(p peer.ID, pk ic.PubKey) error {
 		log.Errorf("error while converting pubkey byte string for peer %s: %s\n", p.Pretty(), err)
 		return err
 	}
-	err = kb.ds.Put(key, val)
+	return kb.putKey(p, key, val, false)
+}
+
+func (kb *dsKeyBook) PrivKey(p peer.ID) ic.PrivKey {
+	sk, err := kb.PrivKeyE(p)
 	if err != nil {
-		log.Errorf("error while updating pubkey in datastore for peer %s: %s\n", p.Pretty(), err)
+		log.Errorf("error while fetching privkey for peer %s: %s\n", p.Pretty(), err)
 	}
-	return err
+	return sk
 }
 
-func (kb *dsKeyBook) PrivKey(p peer.ID) ic.PrivKey {
+// PrivKeyE is like PrivKey, but reports why the key could not be returned.
+func (kb *dsKeyBook) PrivKeyE(p peer.ID) (ic.PrivKey, error) {
 	key := kbBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p))).Child(privSuffix)
 	value, err := kb.ds.Get(key)
-	if err != nil {
-		log.Errorf("error while fetching privkey from datastore for peer %s: %s\n", p.Pretty(), err)
-		return nil
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return nil, peerstore.ErrNotFound
+	default:
+		return nil, err
 	}
 	sk, err := ic.UnmarshalPrivateKey(value)
 	if err != nil {
-		return nil
+		return nil, &pstore.KeyDecodeError{Peer: p, Private: true, Err: err}
 	}
-	return sk
+	return sk, nil
 }
 
 func (kb *dsKeyBook) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
@@ -107,6 +138,7 @@ func (kb *dsKeyBook) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
 	}
 	// check it's correct.
 	if !p.MatchesPrivateKey(sk) {
+		kb.auditor.audit(pstore.AuditEvent{Type: pstore.AuditKeyMismatch, Peer: p, Private: true})
 		return errors.New("peer ID does not match private key")
 	}
 
@@ -116,15 +148,91 @@ func (kb *dsKeyBook) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
 		log.Errorf("error while converting privkey byte string for peer %s: %s\n", p.Pretty(), err)
 		return err
 	}
-	err = kb.ds.Put(key, val)
+	return kb.putKey(p, key, val, true)
+}
+
+// putKey stores a key, and audits it if it wasn't stored before.
+func (kb *dsKeyBook) putKey(p peer.ID, key ds.Key, val []byte, private bool) error {
+	kind := "pubkey"
+	if private {
+		kind = "privkey"
+	}
+	// only look the key up when auditing, to spare a read otherwise.
+	var found bool
+	if kb.auditor.enabled() {
+		found, _ = kb.ds.Has(key)
+	}
+	if err := kb.ds.Put(key, val); err != nil {
+		log.Errorf("error while updating %s in datastore for peer %s: %s\n", kind, p.Pretty(), err)
+		return err
+	}
+	if !found {
+		kb.auditor.audit(pstore.AuditEvent{Type: pstore.AuditKeyAdded, Peer: p, Private: private})
+	}
+	kb.gens.Bump(p)
+	return nil
+}
+
+// RemovePeer removes the keys of a peer.
+func (kb *dsKeyBook) RemovePeer(p peer.ID) {
+	if err := multiWrite(kb.ds, func(r ds.Read, w ds.Write) error { return kb.removePeer(r, w, p) }); err != nil {
+		log.Errorf("failed to remove keys for peer %s: %s", p.Pretty(), err)
+	}
+	kb.gens.Bump(p)
+}
+
+// removePeer deletes the keys of a peer through w. If zeroization is enabled, the private key, looked up through r,
+// is overwritten through w first, so that the overwrite is only applied along with the deletion.
+func (kb *dsKeyBook) removePeer(r ds.Read, w ds.Write, p peer.ID) error {
+	base := kbBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+	if kb.zeroize {
+		if err := wipe(r, w, base.Child(privSuffix)); err != nil {
+			return fmt.Errorf("failed to wipe %s key: %s", privSuffix.Name(), err)
+		}
+	}
+	for _, suffix := range []ds.Key{pubSuffix, privSuffix} {
+		if err := w.Delete(base.Child(suffix)); err != nil {
+			return fmt.Errorf("failed to remove %s key: %s", suffix.Name(), err)
+		}
+	}
+	return nil
+}
+
+// wipe overwrites the value of a key with zeros through w, so that datastores updating values in place don't retain
+// it once deleted.
+func wipe(r ds.Read, w ds.Write, key ds.Key) error {
+	size, err := r.GetSize(key)
+	switch err {
+	case nil:
+		return w.Put(key, make([]byte, size))
+	case ds.ErrNotFound:
+		return nil
+	default:
+		return err
+	}
+}
+
+// HasKey returns whether a public or private key was added for a peer. It checks for the keys without fetching them.
+func (kb *dsKeyBook) HasKey(p peer.ID) bool {
+	pub, priv, err := kb.hasKeys(kb.ds, p)
 	if err != nil {
-		log.Errorf("error while updating privkey in datastore for peer %s: %s\n", p.Pretty(), err)
+		log.Errorf("failed to check keys of peer %s: %s", p.Pretty(), err)
 	}
-	return err
+	return pub || priv
+}
+
+// hasKeys returns whether the public and private keys of a peer are stored, checking through r.
+func (kb *dsKeyBook) hasKeys(r ds.Read, p peer.ID) (pub bool, priv bool, err error) {
+	base := kbBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+	if pub, err = r.Has(base.Child(pubSuffix)); err != nil {
+		return false, false, err
+	}
+	priv, err = r.Has(base.Child(privSuffix))
+	return pub, priv, err
 }
 
 func (kb *dsKeyBook) PeersWithKeys() peer.IDSlice {
-	ids, err := uniquePeerIds(kb.ds, kbBase, func(result query.Result) string {
+	ids, err := uniquePeerIds(kb.ds, kbBase, kb.corrupt, kb.validateID, func(result query.Result) string {
 		return ds.RawKey(result.Key).Parent().Name()
 	})
 	if err != nil {
@@ -132,3 +240,50 @@ func (kb *dsKeyBook) PeersWithKeys() peer.IDSlice {
 	}
 	return ids
 }
+
+func (kb *dsKeyBook) KeyTypeCounts() pstore.KeyTypeCounts {
+	counts := pstore.KeyTypeCounts{
+		PubKeys:  make(map[pb.KeyType]int),
+		PrivKeys: make(map[pb.KeyType]int),
+	}
+
+	results, err := kb.ds.Query(query.Query{Prefix: kbBase.String()})
+	if err != nil {
+		log.Errorf("error while counting keys by type: %v", err)
+		return counts
+	}
+	defer results.Close()
+
+	// keys are decoded only as far as their type; the key material is left untouched.
+	for result := range results.Next() {
+		if result.Error != nil {
+			log.Errorf("error while counting keys by type: %v", result.Error)
+			continue
+		}
+		switch ds.RawKey(result.Key).Name() {
+		case pubSuffix.Name():
+			var pk pb.PublicKey
+			if err := pk.Unmarshal(result.Value); err == nil {
+				counts.PubKeys[pk.Type]++
+			}
+		case privSuffix.Name():
+			var sk pb.PrivateKey
+			if err := sk.Unmarshal(result.Value); err == nil {
+				counts.PrivKeys[sk.Type]++
+			}
+		}
+	}
+	return counts
+}
+
+// ExportPrivKey returns the private key of p encoded in format, protected by passphrase unless it is empty, so that
+// it can be backed up. It returns peerstore.ErrNotFound if there is no private key for p.
+func (kb *dsKeyBook) ExportPrivKey(p peer.ID, format pstore.KeyFormat, passphrase []byte) ([]byte, error) {
+	return pstore.ExportPrivKey(kb, p, format, passphrase)
+}
+
+// ImportPrivKey restores a private key exported by ExportPrivKey, or encoded by standard tools, adding it along with
+// its public key for the peer they identify, which it returns.
+func (kb *dsKeyBook) ImportPrivKey(data []byte, format pstore.KeyFormat, passphrase []byte) (peer.ID, error) {
+	return pstore.ImportPrivKey(kb, data, format, passphrase)
+}
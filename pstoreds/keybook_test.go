@@ -0,0 +1,106 @@
+package pstoreds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	pt "github.com/libp2p/go-libp2p-core/test"
+	base32 "github.com/multiformats/go-base32"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestKeyDecodeError(t *testing.T) {
+	store, closeFn := badgerStore(t)
+	defer closeFn()
+
+	kb, err := NewKeyBook(context.Background(), store, DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pub, err := pt.RandTestKeyPair(ic.RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kb.PrivKeyE(id); err != peerstore.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// corrupt both keys.
+	base := kbBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(id)))
+	for _, suffix := range []string{pubSuffix.Name(), privSuffix.Name()} {
+		if err := store.Put(base.ChildString(suffix), []byte("garbage")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var kerr *pstore.KeyDecodeError
+	if _, err := kb.PubKeyE(id); !errors.As(err, &kerr) || kerr.Private || kerr.Peer != id {
+		t.Errorf("expected a public key decode error, got %v", err)
+	}
+	if _, err := kb.PrivKeyE(id); !errors.As(err, &kerr) || !kerr.Private {
+		t.Errorf("expected a private key decode error, got %v", err)
+	}
+	if kb.PubKey(id) != nil || kb.PrivKey(id) != nil {
+		t.Error("expected nil keys when decoding fails")
+	}
+}
+
+// putRecorder records the values put in a datastore.
+type putRecorder struct {
+	ds.Datastore
+	puts map[ds.Key][][]byte
+}
+
+func (r *putRecorder) Put(key ds.Key, value []byte) error {
+	r.puts[key] = append(r.puts[key], append([]byte(nil), value...))
+	return r.Datastore.Put(key, value)
+}
+
+func TestZeroizeOnRemove(t *testing.T) {
+	store := &putRecorder{Datastore: ds.NewMapDatastore(), puts: make(map[ds.Key][][]byte)}
+	opts := DefaultOpts()
+	opts.ZeroizeOnRemove = true
+	kb, err := NewKeyBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv, _, err := pt.RandTestKeyPair(ic.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kb.AddPrivKey(id, priv); err != nil {
+		t.Fatal(err)
+	}
+	kb.RemovePeer(id)
+
+	key := kbBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(id))).Child(privSuffix)
+	puts := store.puts[key]
+	if len(puts) != 2 {
+		t.Fatalf("expected the key to be overwritten once, got %d puts", len(puts))
+	}
+	if len(puts[1]) != len(puts[0]) || !bytes.Equal(puts[1], make([]byte, len(puts[0]))) {
+		t.Fatal("expected the key to be overwritten with zeros")
+	}
+	if _, err := kb.PrivKeyE(id); err != peerstore.ErrNotFound {
+		t.Fatalf("expected key to be removed, got %v", err)
+	}
+}
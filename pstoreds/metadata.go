@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"fmt"
+	"sort"
+	"strings"
 
 	base32 "github.com/multiformats/go-base32"
 
 	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
 
 	pool "github.com/libp2p/go-buffer-pool"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
 // Metadata is stored under the following db key pattern:
@@ -19,10 +24,21 @@ import (
 var pmBase = ds.NewKey("/peers/metadata")
 
 type dsPeerMetadata struct {
-	ds ds.Datastore
+	ds         ds.Datastore
+	maxSize    int
+	corrupt    *corruptReporter
+	validateID peerstore.IDValidator
+	strict     *peerstore.StrictChecks
+	subs       *peerstore.MetadataSubManager
+	gens       *peerstore.Generations // set by NewPeerstore.
 }
 
-var _ pstore.PeerMetadata = (*dsPeerMetadata)(nil)
+var (
+	_ pstore.PeerMetadata         = (*dsPeerMetadata)(nil)
+	_ peerstore.PeerMetadataBatch = (*dsPeerMetadata)(nil)
+	_ peerstore.PeerMetadataSizer = (*dsPeerMetadata)(nil)
+	_ peerstore.MetadataWatcher   = (*dsPeerMetadata)(nil)
+)
 
 func init() {
 	// Gob registers basic types by default.
@@ -36,8 +52,18 @@ func init() {
 // See `init()` to learn which types are registered by default. Modules wishing to store
 // values of other types will need to `gob.Register()` them explicitly, or else callers
 // will receive runtime errors.
-func NewPeerMetadata(_ context.Context, store ds.Datastore, _ Options) (*dsPeerMetadata, error) {
-	return &dsPeerMetadata{store}, nil
+//
+// Values whose encoding exceeds Options.MaxMetadataValueSize are rejected with pstore.ErrValueTooLarge. Like the
+// other books, it can be used on its own; the retry policy in opts then applies if store implements ds.Batching.
+func NewPeerMetadata(_ context.Context, store ds.Datastore, opts Options) (*dsPeerMetadata, error) {
+	return &dsPeerMetadata{
+		ds:         bookStore(store, opts),
+		maxSize:    opts.MaxMetadataValueSize,
+		corrupt:    newCorruptReporter(opts),
+		validateID: idValidator(opts),
+		strict:     peerstore.NewStrictChecks(opts.StrictChecks),
+		subs:       peerstore.NewMetadataSubManager(),
+	}, nil
 }
 
 func (pm *dsPeerMetadata) Get(p peer.ID, key string) (interface{}, error) {
@@ -61,7 +87,10 @@ func (pm *dsPeerMetadata) Get(p peer.ID, key string) (interface{}, error) {
 }
 
 func (pm *dsPeerMetadata) Put(p peer.ID, key string, val interface{}) error {
-	if err := p.Validate(); err != nil {
+	if err := pm.validateID(p); err != nil {
+		return err
+	}
+	if err := pm.strict.Put(p, key, val); err != nil {
 		return err
 	}
 	k := pmBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p))).ChildString(key)
@@ -69,5 +98,212 @@ func (pm *dsPeerMetadata) Put(p peer.ID, key string, val interface{}) error {
 	if err := gob.NewEncoder(&buf).Encode(&val); err != nil {
 		return err
 	}
-	return pm.ds.Put(k, buf.Bytes())
+	if pm.maxSize > 0 && buf.Len() > pm.maxSize {
+		return peerstore.ErrValueTooLarge
+	}
+	if !pm.subs.Watched(key) {
+		if err := pm.ds.Put(k, buf.Bytes()); err != nil {
+			return err
+		}
+		pm.gens.Bump(p)
+		return nil
+	}
+
+	// values are compared in their encoding, to only report changes.
+	prev, err := pm.ds.Get(k)
+	if err != nil && err != ds.ErrNotFound {
+		return err
+	}
+	if err := pm.ds.Put(k, buf.Bytes()); err != nil {
+		return err
+	}
+	pm.gens.Bump(p)
+	if err == ds.ErrNotFound || !bytes.Equal(prev, buf.Bytes()) {
+		pm.subs.Broadcast(peerstore.MetadataEvent{Peer: p, Key: key, Value: val})
+	}
+	return nil
+}
+
+// WatchMetadata returns a channel of the changes of key, for all peers, until ctx is done. See
+// peerstore.MetadataWatcher.
+func (pm *dsPeerMetadata) WatchMetadata(ctx context.Context, key string) <-chan peerstore.MetadataEvent {
+	return pm.subs.WatchMetadata(ctx, key)
+}
+
+// notifyRemoved reports the removal of the given metadata keys of a peer to their subscriptions.
+func (pm *dsPeerMetadata) notifyRemoved(p peer.ID, keys []string) {
+	for _, key := range keys {
+		if pm.subs.Watched(key) {
+			pm.subs.Broadcast(peerstore.MetadataEvent{Peer: p, Key: key, Removed: true})
+		}
+	}
+}
+
+// GetMany returns the value stored under key for each of the given peers that has one. Values are read with a single
+// query over the metadata of all peers, rather than one read per peer.
+func (pm *dsPeerMetadata) GetMany(key string, peers []peer.ID) map[peer.ID]interface{} {
+	res := make(map[peer.ID]interface{}, len(peers))
+	wanted := make(map[string]peer.ID, len(peers))
+	for _, p := range peers {
+		if p.Validate() != nil {
+			continue
+		}
+		k := pmBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p))).ChildString(key)
+		wanted[k.String()] = p
+	}
+	if len(wanted) == 0 {
+		return res
+	}
+
+	results, err := pm.ds.Query(query.Query{Prefix: pmBase.String()})
+	if err != nil {
+		log.Errorf("failed to query metadata key %s: %s", key, err)
+		return res
+	}
+	defer results.Close()
+
+	for result := range results.Next() {
+		if result.Error != nil {
+			log.Errorf("failed to query metadata key %s: %s", key, result.Error)
+			break
+		}
+		p, ok := wanted[result.Key]
+		if !ok {
+			continue
+		}
+		var v interface{}
+		if err := gob.NewDecoder(bytes.NewReader(result.Value)).Decode(&v); err != nil {
+			log.Errorf("failed to decode metadata key %s of peer %s: %s", key, p.Pretty(), err)
+			continue
+		}
+		res[p] = v
+	}
+	return res
+}
+
+// MetadataSize returns the size of the metadata of a peer, as the size of its keys and encoded values, protocols
+// included.
+func (pm *dsPeerMetadata) MetadataSize(p peer.ID) int {
+	prefix := pmBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+	results, err := pm.ds.Query(query.Query{Prefix: prefix.String()})
+	if err != nil {
+		log.Errorf("failed to query metadata of peer %s: %s", p.Pretty(), err)
+		return 0
+	}
+	defer results.Close()
+
+	var size int
+	for result := range results.Next() {
+		if result.Error != nil {
+			log.Errorf("failed to query metadata of peer %s: %s", p.Pretty(), result.Error)
+			break
+		}
+		size += len(result.Key) - len(prefix.String()) - 1 + len(result.Value)
+	}
+	return size
+}
+
+// hasMetadata returns whether a peer has metadata, protocols included. Only keys are queried.
+func (pm *dsPeerMetadata) hasMetadata(p peer.ID) bool {
+	prefix := pmBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+	results, err := pm.ds.Query(query.Query{Prefix: prefix.String(), KeysOnly: true, Limit: 1})
+	if err != nil {
+		log.Errorf("failed to query metadata of peer %s: %s", p.Pretty(), err)
+		return false
+	}
+	defer results.Close()
+
+	for result := range results.Next() {
+		if result.Error != nil {
+			log.Errorf("failed to query metadata of peer %s: %s", p.Pretty(), result.Error)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// RemovePeer removes all metadata of a peer, protocols included.
+func (pm *dsPeerMetadata) RemovePeer(p peer.ID) {
+	var removed []string
+	err := multiWrite(pm.ds, func(r ds.Read, w ds.Write) (err error) {
+		removed, err = pm.removePeer(r, w, p)
+		return err
+	})
+	if err != nil {
+		log.Errorf("failed to remove metadata of peer %s: %s", p.Pretty(), err)
+		return
+	}
+	pm.gens.Bump(p)
+	pm.notifyRemoved(p, removed)
+}
+
+// removePeer deletes the metadata of a peer, listed through r, through w, and returns the keys deleted.
+func (pm *dsPeerMetadata) removePeer(r ds.Read, w ds.Write, p peer.ID) ([]string, error) {
+	prefix := pmBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+	results, err := r.Query(query.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if err := w.Delete(ds.RawKey(e.Key)); err != nil {
+			return nil, err
+		}
+		keys = append(keys, strings.TrimPrefix(e.Key, prefix.String()+"/"))
+	}
+	return keys, nil
+}
+
+// readState reads the protocols and the other metadata keys of a peer through r.
+func (pm *dsPeerMetadata) readState(r ds.Read, p peer.ID) (protos []string, keys []string, err error) {
+	prefix := pmBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+	results, err := r.Query(query.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		key := strings.TrimPrefix(e.Key, prefix.String()+"/")
+		if key != protocolsKey {
+			keys = append(keys, key)
+			continue
+		}
+		value, err := r.Get(ds.RawKey(e.Key))
+		if err != nil {
+			return nil, nil, err
+		}
+		var res interface{}
+		if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&res); err != nil {
+			return nil, nil, err
+		}
+		pmap, ok := res.(map[string]struct{})
+		if !ok {
+			return nil, nil, fmt.Errorf("stored protocol set was not a map")
+		}
+		for proto := range pmap {
+			protos = append(protos, proto)
+		}
+	}
+	sort.Strings(protos)
+	sort.Strings(keys)
+	return protos, keys, nil
+}
+
+// peers returns the peers with metadata.
+func (pm *dsPeerMetadata) peers() peer.IDSlice {
+	ids, err := uniquePeerIds(pm.ds, pmBase, pm.corrupt, pm.validateID, func(result query.Result) string {
+		return ds.RawKey(result.Key).Parent().Name()
+	})
+	if err != nil {
+		log.Errorf("error while retrieving peers with metadata: %v", err)
+	}
+	return ids
 }
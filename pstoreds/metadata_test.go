@@ -0,0 +1,43 @@
+package pstoreds
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestMetadataValueSize(t *testing.T) {
+	opts := DefaultOpts()
+	opts.MaxMetadataValueSize = 1024
+	pm, err := NewPeerMetadata(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := pt.GeneratePeerIDs(1)[0]
+	if err := pm.Put(id, "small", bytes.Repeat([]byte{1}, 512)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Put(id, "large", bytes.Repeat([]byte{1}, 2048)); err != peerstore.ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if _, err := pm.Get(id, "large"); err != pstore.ErrNotFound {
+		t.Fatalf("expected oversized value not to be stored, got %v", err)
+	}
+
+	// the limit applies to the encoding, whatever the type of the value.
+	large := make(map[string]struct{})
+	for i := 0; i < 100; i++ {
+		large[string(bytes.Repeat([]byte{'a' + byte(i%26)}, i))] = struct{}{}
+	}
+	if err := pm.Put(id, "protocols", large); err != peerstore.ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package pstoreds
+
+import (
+	"context"
+	"sync"
+
+	base32 "github.com/multiformats/go-base32"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// Names are stored twice, so that a peer is found by name and a name by peer with a single read:
+// /peers/names/<b32 name no padding> -> peer id bytes
+// /peers/peernames/<b32 peer id no padding> -> name bytes
+var (
+	namesBase     = ds.NewKey("/peers/names")
+	peerNamesBase = ds.NewKey("/peers/peernames")
+)
+
+type dsNameBook struct {
+	ds         ds.Datastore
+	corrupt    *corruptReporter
+	validateID pstore.IDValidator
+	gens       *pstore.Generations // set by NewPeerstore.
+
+	// serializes SetName, so that the uniqueness of names holds on datastores without transactions. Other processes
+	// sharing the datastore may still race.
+	mu sync.Mutex
+}
+
+var _ pstore.NameBook = (*dsNameBook)(nil)
+
+// NewNameBook creates a name book backed by a persistent db. It can be used on its own; the retry policy in opts
+// then applies if store implements ds.Batching.
+func NewNameBook(_ context.Context, store ds.Datastore, opts Options) (*dsNameBook, error) {
+	return &dsNameBook{
+		ds:         bookStore(store, opts),
+		corrupt:    newCorruptReporter(opts),
+		validateID: idValidator(opts),
+	}, nil
+}
+
+func nameKey(name string) ds.Key {
+	return namesBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(name)))
+}
+
+func peerNameKey(p peer.ID) ds.Key {
+	return peerNamesBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+func (nb *dsNameBook) SetName(p peer.ID, name string) error {
+	if name == "" {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	} else if err := nb.validateID(p); err != nil {
+		return err
+	}
+
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+
+	err := multiWrite(nb.ds, func(r ds.Read, w ds.Write) error {
+		if name != "" {
+			owner, err := r.Get(nameKey(name))
+			switch {
+			case err == ds.ErrNotFound:
+			case err != nil:
+				return err
+			case peer.ID(owner) != p:
+				return pstore.ErrNameTaken
+			}
+		}
+		old, err := nb.readName(r, p)
+		if err != nil {
+			return err
+		}
+		if old == name {
+			return nil
+		}
+		if old != "" {
+			if err := w.Delete(nameKey(old)); err != nil {
+				return err
+			}
+		}
+		if name == "" {
+			return w.Delete(peerNameKey(p))
+		}
+		if err := w.Put(nameKey(name), []byte(p)); err != nil {
+			return err
+		}
+		return w.Put(peerNameKey(p), []byte(name))
+	})
+	if err != nil {
+		return err
+	}
+	nb.gens.Bump(p)
+	return nil
+}
+
+// readName reads the name of a peer through r.
+func (nb *dsNameBook) readName(r ds.Read, p peer.ID) (string, error) {
+	name, err := r.Get(peerNameKey(p))
+	if err == ds.ErrNotFound {
+		return "", nil
+	}
+	return string(name), err
+}
+
+func (nb *dsNameBook) Name(p peer.ID) string {
+	name, err := nb.readName(nb.ds, p)
+	if err != nil {
+		log.Errorf("failed to get the name of peer %s: %s", p.Pretty(), err)
+	}
+	return name
+}
+
+func (nb *dsNameBook) PeerByName(name string) (peer.ID, bool) {
+	if name == "" {
+		return "", false
+	}
+	v, err := nb.ds.Get(nameKey(name))
+	if err != nil {
+		if err != ds.ErrNotFound {
+			log.Errorf("failed to look up the peer named %s: %s", name, err)
+		}
+		return "", false
+	}
+	p := peer.ID(v)
+	if err := nb.validateID(p); err != nil {
+		nb.corrupt.report(nameKey(name), err)
+		return "", false
+	}
+	return p, true
+}
+
+func (nb *dsNameBook) Names() map[peer.ID]string {
+	res := make(map[peer.ID]string)
+	results, err := nb.ds.Query(query.Query{Prefix: peerNamesBase.String()})
+	if err != nil {
+		log.Errorf("failed to list the names of peers: %s", err)
+		return res
+	}
+	defer results.Close()
+
+	for result := range results.Next() {
+		if result.Error != nil {
+			log.Errorf("failed to list the names of peers: %s", result.Error)
+			break
+		}
+		k := ds.RawKey(result.Key)
+		b, err := base32.RawStdEncoding.DecodeString(k.Name())
+		if err == nil {
+			err = nb.validateID(peer.ID(b))
+		}
+		if err != nil {
+			nb.corrupt.report(k, err)
+			continue
+		}
+		res[peer.ID(b)] = string(result.Value)
+	}
+	return res
+}
+
+// namedPeers returns the named peers.
+func (nb *dsNameBook) namedPeers() peer.IDSlice {
+	ids, err := uniquePeerIds(nb.ds, peerNamesBase, nb.corrupt, nb.validateID, func(result query.Result) string {
+		return ds.RawKey(result.Key).Name()
+	})
+	if err != nil {
+		log.Errorf("error while retrieving named peers: %v", err)
+	}
+	return ids
+}
@@ -0,0 +1,27 @@
+package pstoreds
+
+import (
+	ds "github.com/ipfs/go-datastore"
+	ktds "github.com/ipfs/go-datastore/keytransform"
+	namespace "github.com/ipfs/go-datastore/namespace"
+)
+
+// namespacedStore is a datastore nested under the namespace set in Options.Namespace.
+type namespacedStore struct {
+	*ktds.Datastore
+}
+
+var _ ds.Batching = (*namespacedStore)(nil)
+
+// namespaceStore nests store under the namespace set in opts, unless there is none or store is wrapped already.
+func namespaceStore(store ds.Datastore, opts Options) ds.Datastore {
+	switch store.(type) {
+	case *namespacedStore, *retryStore, *tieredStore, *timedStore, *timedTxnStore:
+		// retry, tiered and timed stores are only ever created over namespaced stores.
+		return store
+	}
+	if opts.Namespace.String() == "" || opts.Namespace.String() == "/" {
+		return store
+	}
+	return &namespacedStore{namespace.Wrap(store, opts.Namespace)}
+}
@@ -14,6 +14,7 @@ import (
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 // Configuration object for the peerstore.
@@ -32,6 +33,202 @@ type Options struct {
 	// Initial delay before GC processes start. Intended to give the system breathing room to fully boot
 	// before starting GC.
 	GCInitialDelay time.Duration
+
+	// Number of workers processing records in parallel during full-purge GC cycles (i.e. when lookahead is
+	// disabled). Values lower than 2 process records sequentially.
+	GCConcurrency int
+
+	// Maintain an in-memory index of the soonest expiry of every peer, so that full-purge GC cycles only visit the
+	// records with expired addresses. The first cycle after startup still traverses the entire datastore to seed the
+	// index. Cannot be combined with lookahead GC.
+	GCExpiryIndex bool
+
+	// Bounds within which the interval between GC purge cycles adapts to the number of records each cycle purges,
+	// starting from GCPurgeInterval. Disabled by default, so that cycles run every GCPurgeInterval.
+	GCAdaptive GCAdaptivePolicy
+
+	// Fraction (0-1) of expired entries a record must contain for a read to rewrite it in the datastore
+	// (compaction). Records below the threshold are cleaned in memory only, and are compacted on the next
+	// write or GC cycle. A zero value compacts on every read that finds expired entries.
+	CompactionThreshold float64
+
+	// Codec used to serialize address records. Records are always readable regardless of the codec they were
+	// written with, as long as it is registered. Defaults to ProtobufCodec when nil.
+	Codec RecordCodec
+
+	// Compression applied to address records once encoded by Codec. Like codecs, records are always readable
+	// regardless of the compression they were written with, so it can be changed on an existing datastore. Records
+	// that don't shrink are stored uncompressed. Defaults to CompressionNone.
+	Compression RecordCompression
+
+	// Source of time used to compute address expiry. Defaults to the system clock when nil. GC timers always run
+	// on the system clock.
+	Clock pstore.Clock
+
+	// Interval at which peers left without any live address are removed from all books, keys and metadata
+	// included. See pstore.PeerCollector for which peers are collected. A zero value disables peer GC.
+	PeerGCInterval time.Duration
+
+	// Retry policy and circuit breaker applied to datastore operations, so that transient datastore failures don't
+	// take the peerstore down. Disabled by default.
+	Retry RetryPolicy
+
+	// Maximum size, in bytes, of an encoded metadata value. Larger values are rejected with pstore.ErrValueTooLarge.
+	// A zero value disables the limit.
+	MaxMetadataValueSize int
+
+	// Sink recording security-relevant events, such as keys being added or rejected and stale signed peer records.
+	// Disabled when nil.
+	AuditSink pstore.AuditSink
+
+	// Overwrite private keys with zeros before deleting them from the datastore. The overwrite is part of the
+	// transaction or batch of the deletion, so datastores coalescing writes to the same key may skip it, and
+	// log-structured datastores may still retain old versions until compaction.
+	ZeroizeOnRemove bool
+
+	// Policy deciding how the TTL of an address is updated when the address is added again. Defaults to
+	// pstore.MaxTTL when nil.
+	TTLPolicy pstore.TTLPolicy
+
+	// Callback invoked for every entry skipped because it couldn't be decoded while listing peers or collecting
+	// garbage, such as a record written by a buggy version or damaged on disk. Corrupt records are left in place,
+	// while corrupt GC lookahead entries are dropped, as they are rebuilt. Skipped entries are counted in
+	// AddrBookStats.CorruptRecords. It may be called concurrently, and must not call back into the peerstore.
+	// Disabled when nil.
+	OnCorruptRecord func(key ds.Key, err error)
+
+	// Check applied to the peer IDs of addresses and metadata being written, to those of addresses being read or
+	// cleared, and to those read back when listing peers: stored IDs it rejects are skipped as corrupt. Keys are always
+	// checked against the peer ID they are added for. Defaults to pstore.RelaxedIDs when nil.
+	IDValidator pstore.IDValidator
+
+	// Link the addresses of a peer that refer to the same endpoint under different encodings (see addr.Endpoint):
+	// adding or setting one of them updates the TTL and expiry of all of them, and Addrs returns a single address per
+	// endpoint. Removing an address doesn't remove its aliases.
+	AddrAliases bool
+
+	// Policy deciding how addresses ending with a /p2p component are handled when added or set. Rejected addresses
+	// are dropped, and reported with pstore.ErrP2PAddrMismatch by AddAddrsE and SetAddrsE once the others have been
+	// stored. Defaults to pstore.P2PAddrKeep.
+	P2PAddrPolicy pstore.P2PAddrPolicy
+
+	// Policy deciding how the addresses of Tor, I2P, WebTransport and WebRTC are handled when added or set, see
+	// pstore.ExoticAddrPolicy. Rejected addresses are dropped, and reported by AddAddrsE and SetAddrsE once the others
+	// have been stored. The zero value stores them all, with the TTL of addresses carrying certificate hashes capped
+	// to pstore.DefaultCertHashAddrTTL.
+	ExoticAddrPolicy pstore.ExoticAddrPolicy
+
+	// Longest binary length of the addresses added or set, in bytes. Longer addresses are dropped, counted in
+	// AddrBookStats.TooLongAddrs, and reported with pstore.ErrAddrTooLong by AddAddrsE and SetAddrsE once the others
+	// have been stored. Zero applies pstore.DefaultMaxAddrLen, and a negative value disables the cap.
+	MaxAddrLen int
+
+	// Key all entries are nested under, e.g. /p2p/peerstore, so that the peerstore can share a datastore with other
+	// components. Peerstores opened with the same namespace share their entries, while those with different ones are
+	// isolated. The zero value stores entries at the root of the datastore, under /peers, like earlier versions.
+	Namespace ds.Key
+
+	// Fraction (0-1) of their TTL by which the lifetime of addresses is randomly shortened, so that addresses added at
+	// the same time don't all expire at once, see pstore.TTLJitter. A zero value disables jitter.
+	TTLJitter float64
+
+	// Layout of addresses in the datastore: one record per peer, rewritten on every change, or one entry per address,
+	// so that writes only touch the addresses that changed. See AddrLayout for the trade-offs. Layouts are stored
+	// under distinct keys, so changing the layout of an existing datastore discards the addresses it holds. Defaults
+	// to AddrLayoutPerPeer.
+	AddrLayout AddrLayout
+
+	// Policy deciding which records enter the address book cache once it is full. See AddrBookStats.Cache for the
+	// counters validating it. Ignored when the cache is disabled. Defaults to CacheAdmissionAlways.
+	CacheAdmission CacheAdmission
+
+	// Number of the most frequently accessed peers whose address records are loaded into the cache on startup, to
+	// cut cold-start latency. Access frequencies are tracked in bounded memory and persisted when the address book is
+	// closed. Ignored when the cache is disabled. A zero value disables tracking and warming.
+	CacheWarmPeers int
+
+	// Window within which the additions of an address repeated for the same peer, with a TTL of the same class, are
+	// suppressed before touching the cache or the datastore; see pstore.AddrDebouncer. Suppressed additions are
+	// counted in AddrBookStats.DebouncedAddrs. A zero value disables suppression.
+	AddrDebounce time.Duration
+
+	// Reject the writes with arguments that are silently ignored otherwise, such as nil addresses, non-positive or
+	// absurd TTLs, empty metadata keys and empty protocols, with a *pstore.ArgumentError; see pstore.StrictChecks.
+	// Meant for tests and development, to catch integration bugs. Disabled by default.
+	StrictChecks bool
+
+	// Second, typically slower or compressed, datastore to which the entries of peers left unaccessed for ColdAfter
+	// are moved, so that the main datastore stays small while what is known about rarely seen peers is retained. Any
+	// access to such a peer moves its entries back. Cold peers aren't listed by Peers nor collected by GC; they are
+	// listed by ColdPeers. Group memberships always stay in the main datastore. Only applies to NewPeerstore; disabled
+	// when nil.
+	ColdStore ds.Batching
+
+	// Duration after which peers left unaccessed are moved to ColdStore. Must be positive if ColdStore is set.
+	ColdAfter time.Duration
+
+	// Budget, in bytes, for the size of the keys and values held in the datastore, which approximates the on-disk
+	// footprint of the peerstore before the overhead of the datastore itself. When a check finds the budget exceeded,
+	// the least valuable peers are removed until it is met again: peers without a signed peer record first, then those
+	// never connected to, then those seen the longest ago. Protected peers and peers we hold a private key for are
+	// never removed. See AddrBookStats.Budget for the counters. Only applies to NewPeerstore; a zero value disables
+	// the budget.
+	MaxDiskBytes int64
+
+	// Interval between checks of MaxDiskBytes. Must be positive if MaxDiskBytes is set.
+	DiskBudgetInterval time.Duration
+
+	// Longest TTL addresses are stored with: longer TTLs are clamped to it when addresses are added, set or updated,
+	// except for ConnectedAddrTTL and above, which pin addresses; see pstore.ClampTTL. Clamped addresses hold
+	// MaxAddrTTL as their TTL, which UpdateAddrs matches for any old TTL above it. A zero value disables the clamp.
+	MaxAddrTTL time.Duration
+
+	// Order in which the addresses of a peer are returned by Addrs, AddrsWithExpiry and ExpiringBefore, see
+	// pstore.AddrOrder, so that dials and the caches of callers are reproducible. Addresses stored by versions that
+	// didn't track the insertion order come first, sorted by their binary representation. Defaults to
+	// pstore.AddrOrderNone, returning addresses sorted by expiry.
+	AddrOrder pstore.AddrOrder
+
+	// Interval at which to poll for the address changes made by other processes sharing the datastore, so that their
+	// changes invalidate the cached records of this one. When set, every change of the addresses of a peer also writes
+	// a small marker entry, which processes poll and delete after ten intervals, or a minute; processes sharing a
+	// datastore should set the same interval. Reads may be stale for up to an interval: see NoCache for reads that
+	// can't be. A zero value disables notifications.
+	ChangeNotifyInterval time.Duration
+
+	// Interval at which to write the address records whose only changes are TTL refreshes of addresses they already
+	// held, such as those made by identify on every connection, so that a record refreshed many times within an
+	// interval is written once, in a batch with the others. Refreshes are served from memory until then, so they are
+	// lost if the process crashes, and are only seen by NoCache reads, GetPeerState and other processes sharing the
+	// datastore once written. Additions of new addresses are written immediately. A zero value writes every refresh
+	// immediately.
+	RefreshFlushInterval time.Duration
+
+	// Time the datastore operations of the peerstore by kind, as returned by BackendTimings, so that
+	// pstore.SlowCallPeerstore can tell how much of a slow call was spent in the datastore. Timing costs two clock
+	// reads per operation. Disabled by default.
+	BackendTimings bool
+
+	// Policy deciding what happens when a cached address record and the datastore disagree, as they may when other
+	// processes share the datastore or writes are deferred, see CacheReconciliation and AddrBookStats.Reconciliation
+	// for the divergences detected. Every policy but ReconcileNone reads the datastore on cache hits, at least once
+	// per ReconcileTTL. Ignored when the cache is disabled. Defaults to ReconcileNone.
+	CacheReconciliation CacheReconciliation
+
+	// Time for which cached records are served without comparing them with the datastore, under
+	// ReconcileTrustCacheWithinTTL, which requires it to be positive.
+	ReconcileTTL time.Duration
+
+	// Number of address events (additions, expiries and removals) kept per peer, as returned by AddrHistory, see
+	// pstore.AddrChurnBook. Events are recorded in memory and rolled up into a single entry per peer every 10 seconds
+	// and on Close, so the latest are lost if the process crashes. Expiries are recorded when the expired addresses are
+	// dropped, by reads or GC, and timed at their expiry. The events of a peer are kept until it is removed, e.g. by
+	// peer GC. A zero value disables the history.
+	AddrHistorySize int
+
+	// Number of changes of the peerstore kept in memory, as returned by Changes, see pstore.ChangeFeed. Changes are
+	// numbered from 1 on every start, and only cover the writes of this process. A zero value disables the log.
+	ChangeLogSize int
 }
 
 // DefaultOpts returns the default options for a persistent peerstore, with the full-purge GC algorithm:
@@ -40,26 +237,125 @@ type Options struct {
 // * GC purge interval: 2 hours.
 // * GC lookahead interval: disabled.
 // * GC initial delay: 60 seconds.
+// * GC concurrency: 1.
+// * GC expiry index: disabled.
+// * Adaptive GC interval: disabled.
+// * Compaction threshold: 0 (compact on every read that finds expired entries).
+// * Codec: protobuf.
+// * Compression: none.
+// * Clock: system clock.
+// * Peer GC interval: disabled.
+// * Retry: disabled.
+// * Max metadata value size: disabled.
+// * Audit sink: none.
+// * Zeroize on remove: disabled.
+// * TTL policy: max.
+// * Address aliases: disabled.
+// * Corrupt record callback: none.
+// * ID validator: relaxed.
+// * /p2p address policy: keep.
+// * Exotic address policy: store all, certificate hash addresses capped to 14 days.
+// * Max address length: 1024 bytes.
+// * Namespace: none (root of the datastore).
+// * TTL jitter: disabled.
+// * Address layout: one record per peer.
+// * Cache admission: always.
+// * Cache warm peers: 0 (disabled).
+// * Address debounce: disabled.
+// * Strict checks: disabled.
+// * Cold store: none.
+// * On-disk budget: none, checked every minute once set.
+// * Max address TTL: none.
+// * Address order: none (by expiry).
+// * Change notification: disabled.
+// * Refresh flush interval: disabled (written immediately).
+// * Backend timings: disabled.
+// * Cache reconciliation: none (the cache is trusted).
+// * Address history: disabled.
+// * Change log: disabled.
 func DefaultOpts() Options {
 	return Options{
 		CacheSize:           1024,
 		GCPurgeInterval:     2 * time.Hour,
 		GCLookaheadInterval: 0,
 		GCInitialDelay:      60 * time.Second,
+		GCConcurrency:       1,
+		GCExpiryIndex:       false,
+		CompactionThreshold: 0,
+		Codec:               ProtobufCodec,
+		Clock:               pstore.RealClock{},
+		PeerGCInterval:      0,
+		TTLPolicy:           pstore.MaxTTL,
+		IDValidator:         pstore.RelaxedIDs,
+		DiskBudgetInterval:  time.Minute,
 	}
 }
 
+// idValidator returns the ID validator set in opts, or the default one.
+func idValidator(opts Options) pstore.IDValidator {
+	if opts.IDValidator == nil {
+		return pstore.RelaxedIDs
+	}
+	return opts.IDValidator
+}
+
 type pstoreds struct {
 	peerstore.Metrics
 
-	dsKeyBook
-	dsAddrBook
-	dsProtoBook
-	dsPeerMetadata
+	*dsKeyBook
+	*dsAddrBook
+	*dsProtoBook
+	*dsPeerMetadata
+	*dsGroupBook
+	*dsNameBook
+	*pstore.PeerLocks
+
+	gens   *pstore.Generations
+	peerGC *pstore.PeerCollector
+	tier   *tieredStore // nil unless Options.ColdStore is set.
+	budget *diskBudget  // nil unless Options.MaxDiskBytes is set.
 }
 
+var (
+	_ pstore.PeerRemover          = (*pstoreds)(nil)
+	_ pstore.LatencyDistributions = (*pstoreds)(nil)
+	_ pstore.LatencyStatistics    = (*pstoreds)(nil)
+	_ pstore.TransportLatencies   = (*pstoreds)(nil)
+	_ pstore.ProtocolPeers        = (*pstoreds)(nil)
+	_ pstore.GroupBook            = (*pstoreds)(nil)
+	_ pstore.NameBook             = (*pstoreds)(nil)
+	_ pstore.AddrChurnBook        = (*pstoreds)(nil)
+	_ pstore.PeerLocker           = (*pstoreds)(nil)
+	_ pstore.GenerationTracker    = (*pstoreds)(nil)
+	_ pstore.ChangeFeed           = (*pstoreds)(nil)
+	_ pstore.PeerExistence        = (*pstoreds)(nil)
+	_ pstore.KnownFilter          = (*pstoreds)(nil)
+	_ pstore.KeyExporter          = (*pstoreds)(nil)
+	_ pstore.PeerStateReader      = (*pstoreds)(nil)
+	_ pstore.BackendTimer         = (*pstoreds)(nil)
+)
+
 // NewPeerstore creates a peerstore backed by the provided persistent datastore.
 func NewPeerstore(ctx context.Context, store ds.Batching, opts Options) (*pstoreds, error) {
+	if opts.MaxDiskBytes > 0 && opts.DiskBudgetInterval <= 0 {
+		return nil, fmt.Errorf("on-disk budget requires a positive check interval: %s", opts.DiskBudgetInterval)
+	}
+
+	var tier *tieredStore
+	if opts.ColdStore != nil {
+		if opts.ColdAfter <= 0 {
+			return nil, fmt.Errorf("cold store requires a positive cold-after duration: %s", opts.ColdAfter)
+		}
+		hot := namespaceStore(store, opts).(ds.Batching)
+		cold := namespaceStore(opts.ColdStore, opts).(ds.Batching)
+		var err error
+		if tier, err = newTieredStore(hot, cold, opts.ColdAfter, opts.Clock); err != nil {
+			return nil, err
+		}
+		store = tier
+	}
+	store = wrapStore(store, opts)
+
 	addrBook, err := NewAddrBook(ctx, store, opts)
 	if err != nil {
 		return nil, err
@@ -75,47 +371,102 @@ func NewPeerstore(ctx context.Context, store ds.Batching, opts Options) (*pstore
 		return nil, err
 	}
 
+	groupBook, err := NewGroupBook(ctx, store, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	nameBook, err := NewNameBook(ctx, store, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// share a single corruption counter, so that the address book stats cover all books.
+	keyBook.corrupt = addrBook.corrupt
+	peerMetadata.corrupt = addrBook.corrupt
+	groupBook.corrupt = addrBook.corrupt
+	nameBook.corrupt = addrBook.corrupt
+
+	// number the writes of all books together.
+	gens := pstore.NewGenerations(opts.ChangeLogSize)
+	keyBook.gens = gens
+	addrBook.gens = gens
+	peerMetadata.gens = gens
+	groupBook.gens = gens
+	nameBook.gens = gens
+
 	protoBook := NewProtoBook(peerMetadata)
+	protoBook.strict = pstore.NewStrictChecks(opts.StrictChecks)
 
 	ps := &pstoreds{
 		Metrics:        pstore.NewMetrics(),
-		dsKeyBook:      *keyBook,
-		dsAddrBook:     *addrBook,
-		dsPeerMetadata: *peerMetadata,
-		dsProtoBook:    *protoBook,
+		dsKeyBook:      keyBook,
+		dsAddrBook:     addrBook,
+		dsPeerMetadata: peerMetadata,
+		dsProtoBook:    protoBook,
+		dsGroupBook:    groupBook,
+		dsNameBook:     nameBook,
+		PeerLocks:      new(pstore.PeerLocks),
+		gens:           gens,
+		tier:           tier,
+	}
+	if opts.PeerGCInterval > 0 {
+		if ps.peerGC, err = pstore.NewPeerCollector(ps, ps.allPeers, opts.PeerGCInterval); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MaxDiskBytes > 0 {
+		ps.budget = newDiskBudget(ps, opts.MaxDiskBytes)
+		addrBook.budget = ps.budget
+		ps.budget.start(opts.DiskBudgetInterval)
+	}
+	if tier != nil {
+		interval := opts.ColdAfter / 2
+		if interval == 0 {
+			interval = opts.ColdAfter
+		}
+		tier.start(interval)
 	}
 	return ps, nil
 }
 
-// uniquePeerIds extracts and returns unique peer IDs from database keys.
-func uniquePeerIds(ds ds.Datastore, prefix ds.Key, extractor func(result query.Result) string) (peer.IDSlice, error) {
+// uniquePeerIds extracts and returns unique peer IDs from database keys. Keys that don't hold a peer ID accepted by
+// validate are skipped and reported to corrupt.
+func uniquePeerIds(store ds.Datastore, prefix ds.Key, corrupt *corruptReporter, validate pstore.IDValidator, extractor func(result query.Result) string) (peer.IDSlice, error) {
 	var (
 		q       = query.Query{Prefix: prefix.String(), KeysOnly: true}
 		results query.Results
 		err     error
 	)
 
-	if results, err = ds.Query(q); err != nil {
-		log.Error(err)
+	if results, err = store.Query(q); err != nil {
+		log.Errorf("failed to query peer IDs under %s: %s", prefix, err)
 		return nil, err
 	}
 
 	defer results.Close()
 
-	idset := make(map[string]struct{})
+	idset := make(map[string]query.Result)
 	for result := range results.Next() {
-		k := extractor(result)
-		idset[k] = struct{}{}
-	}
-
-	if len(idset) == 0 {
-		return peer.IDSlice{}, nil
+		if result.Error != nil {
+			log.Warnf("failed while iterating peers: %v", result.Error)
+			continue
+		}
+		idset[extractor(result)] = result
 	}
 
 	ids := make(peer.IDSlice, 0, len(idset))
-	for id := range idset {
-		pid, _ := base32.RawStdEncoding.DecodeString(id)
-		id, _ := peer.IDFromBytes(pid)
+	for k, result := range idset {
+		pid, err := base32.RawStdEncoding.DecodeString(k)
+		if err != nil {
+			corrupt.report(ds.RawKey(result.Key), err)
+			continue
+		}
+		id := peer.ID(pid)
+		if err := validate(id); err != nil {
+			corrupt.report(ds.RawKey(result.Key), err)
+			continue
+		}
 		ids = append(ids, id)
 	}
 	return ids, nil
@@ -131,6 +482,15 @@ func (ps *pstoreds) Close() (err error) {
 		}
 	}
 
+	if ps.peerGC != nil {
+		ps.peerGC.Close()
+	}
+	if ps.budget != nil {
+		ps.budget.stop()
+	}
+	if ps.tier != nil {
+		ps.tier.stop()
+	}
 	weakClose("keybook", ps.dsKeyBook)
 	weakClose("addressbook", ps.dsAddrBook)
 	weakClose("protobook", ps.dsProtoBook)
@@ -142,6 +502,16 @@ func (ps *pstoreds) Close() (err error) {
 	return nil
 }
 
+// ColdPeers returns the peers whose entries were moved to Options.ColdStore, and haven't been accessed since. It
+// returns nil if no cold store is set.
+func (ps *pstoreds) ColdPeers() peer.IDSlice {
+	if ps.tier == nil {
+		return nil
+	}
+	return ps.tier.coldPeers(ps.dsAddrBook.validateID)
+}
+
+// Peers returns the peers with addresses or keys, each once. The order is not defined, and may change between calls.
 func (ps *pstoreds) Peers() peer.IDSlice {
 	set := map[peer.ID]struct{}{}
 	for _, p := range ps.PeersWithKeys() {
@@ -158,9 +528,168 @@ func (ps *pstoreds) Peers() peer.IDSlice {
 	return pps
 }
 
+// Known returns whether any book holds data about a peer. Protocols are held in the peer metadata.
+func (ps *pstoreds) Known(p peer.ID) bool {
+	return ps.HasAddrs(p) || ps.HasKey(p) || ps.dsPeerMetadata.hasMetadata(p)
+}
+
+// GetPeerState returns what the peerstore holds about a peer. Books are read in a single read-only transaction if the
+// datastore supports them, so that the state is consistent; otherwise they are read in turn, see Capabilities.
+func (ps *pstoreds) GetPeerState(p peer.ID) pstore.PeerState {
+	state := pstore.PeerState{ID: p, Latency: ps.LatencyEWMA(p)}
+	if err := p.Validate(); err != nil {
+		return state
+	}
+
+	err := multiRead(ps.dsKeyBook.ds, func(r ds.Read) (err error) {
+		if state.Addrs, err = ps.dsAddrBook.readExpiringAddrs(r, p); err != nil {
+			return err
+		}
+		if state.HasPubKey, state.HasPrivKey, err = ps.dsKeyBook.hasKeys(r, p); err != nil {
+			return err
+		}
+		if state.Name, err = ps.dsNameBook.readName(r, p); err != nil {
+			return err
+		}
+		state.Protocols, state.MetadataKeys, err = ps.dsPeerMetadata.readState(r, p)
+		return err
+	})
+	if err != nil {
+		log.Errorf("failed to read the state of peer %s: %s", p.Pretty(), err)
+	}
+	return state
+}
+
 func (ps *pstoreds) PeerInfo(p peer.ID) peer.AddrInfo {
 	return peer.AddrInfo{
 		ID:    p,
 		Addrs: ps.dsAddrBook.Addrs(p),
 	}
 }
+
+// RemovePeer removes everything known about a peer from all books, address history included, except for its protection
+// tags, group memberships and name. Its entries are removed at once, in a single transaction or batch depending on the
+// datastore, see Capabilities.
+func (ps *pstoreds) RemovePeer(p peer.ID) {
+	var removed []string
+	err := multiWrite(ps.dsKeyBook.ds, func(r ds.Read, w ds.Write) (err error) {
+		if err := ps.dsKeyBook.removePeer(r, w, p); err != nil {
+			return err
+		}
+		if err := ps.dsAddrBook.clearAddrs(r, w, p); err != nil {
+			return err
+		}
+		if err := ps.dsAddrBook.history.remove(w, p); err != nil {
+			return err
+		}
+		removed, err = ps.dsPeerMetadata.removePeer(r, w, p)
+		return err
+	})
+	if err != nil {
+		log.Errorf("failed to remove peer %s: %s", p.Pretty(), err)
+	} else {
+		ps.dsPeerMetadata.notifyRemoved(p, removed)
+	}
+	if rm, ok := ps.Metrics.(pstore.PeerRemover); ok {
+		rm.RemovePeer(p)
+	}
+	ps.gens.Remove(p)
+}
+
+// Generation returns the generation of a peer, which changes on every write to the data held about it by this
+// peerstore, see pstore.GenerationTracker.
+func (ps *pstoreds) Generation(p peer.ID) uint64 {
+	return ps.gens.Generation(p)
+}
+
+// ChangeSeq returns the sequence number of the latest change made by this peerstore, see pstore.ChangeFeed.
+func (ps *pstoreds) ChangeSeq() uint64 {
+	return ps.gens.ChangeSeq()
+}
+
+// Changes returns the changes made by this peerstore after since, oldest first, if Options.ChangeLogSize is set. See
+// pstore.ChangeFeed.
+func (ps *pstoreds) Changes(since uint64) []pstore.ChangeRecord {
+	return ps.gens.Changes(since)
+}
+
+// PeerLatencyHistograms returns a snapshot of the latency histogram of every peer with measurements.
+func (ps *pstoreds) PeerLatencyHistograms() map[peer.ID]pstore.LatencyHistogram {
+	if ld, ok := ps.Metrics.(pstore.LatencyDistributions); ok {
+		return ld.PeerLatencyHistograms()
+	}
+	return nil
+}
+
+// LatencyStats returns the mean, variance and number of the latency measurements of a peer.
+func (ps *pstoreds) LatencyStats(p peer.ID) pstore.LatencyStats {
+	if ls, ok := ps.Metrics.(pstore.LatencyStatistics); ok {
+		return ls.LatencyStats(p)
+	}
+	return pstore.LatencyStats{Mean: ps.Metrics.LatencyEWMA(p)}
+}
+
+// RecordLatencyVia records a latency measurement taken over the address a. Metrics that don't track transports
+// record it like RecordLatency.
+func (ps *pstoreds) RecordLatencyVia(p peer.ID, a ma.Multiaddr, next time.Duration) {
+	if tl, ok := ps.Metrics.(pstore.TransportLatencies); ok {
+		tl.RecordLatencyVia(p, a, next)
+		return
+	}
+	ps.Metrics.RecordLatency(p, next)
+}
+
+// TransportLatencyStats returns the latency statistics of a peer by transport.
+func (ps *pstoreds) TransportLatencyStats(p peer.ID) map[string]pstore.LatencyStats {
+	if tl, ok := ps.Metrics.(pstore.TransportLatencies); ok {
+		return tl.TransportLatencyStats(p)
+	}
+	return nil
+}
+
+// LatencyHistogram returns a snapshot of the histogram of all latency measurements.
+func (ps *pstoreds) LatencyHistogram() pstore.LatencyHistogram {
+	if ld, ok := ps.Metrics.(pstore.LatencyDistributions); ok {
+		return ld.LatencyHistogram()
+	}
+	return pstore.LatencyHistogram{}
+}
+
+// PeersWithProtocols returns the peers supporting any of the given protocols. Protocols are held in the peer
+// metadata, so this reads the protocols of every peer with metadata.
+func (ps *pstoreds) PeersWithProtocols(protos ...string) peer.IDSlice {
+	var pids peer.IDSlice
+	for _, p := range ps.dsPeerMetadata.peers() {
+		if proto, err := ps.dsProtoBook.FirstSupportedProtocol(p, protos...); err == nil && proto != "" {
+			pids = append(pids, p)
+		}
+	}
+	return pids
+}
+
+// allPeers returns the peers known to any book, including those with protocols, metadata, group memberships, names or
+// address history only.
+func (ps *pstoreds) allPeers() peer.IDSlice {
+	set := map[peer.ID]struct{}{}
+	for _, p := range ps.Peers() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.dsPeerMetadata.peers() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.dsGroupBook.peers() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.dsNameBook.namedPeers() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.dsAddrBook.history.peers() {
+		set[p] = struct{}{}
+	}
+
+	pps := make(peer.IDSlice, 0, len(set))
+	for p := range set {
+		pps = append(pps, p)
+	}
+	return pps
+}
@@ -7,8 +7,12 @@ import (
 	peer "github.com/libp2p/go-libp2p-core/peer"
 
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
+// protocolsKey is the metadata key the protocols of a peer are stored under.
+const protocolsKey = "protocols"
+
 type protoSegment struct {
 	sync.RWMutex
 }
@@ -22,6 +26,7 @@ func (s *protoSegments) get(p peer.ID) *protoSegment {
 type dsProtoBook struct {
 	segments protoSegments
 	meta     pstore.PeerMetadata
+	strict   *peerstore.StrictChecks
 }
 
 var _ pstore.ProtoBook = (*dsProtoBook)(nil)
@@ -42,6 +47,9 @@ func (pb *dsProtoBook) SetProtocols(p peer.ID, protos ...string) error {
 	if err := p.Validate(); err != nil {
 		return err
 	}
+	if err := pb.strict.Protocols("SetProtocols", p, protos); err != nil {
+		return err
+	}
 
 	s := pb.segments.get(p)
 	s.Lock()
@@ -52,13 +60,16 @@ func (pb *dsProtoBook) SetProtocols(p peer.ID, protos ...string) error {
 		protomap[proto] = struct{}{}
 	}
 
-	return pb.meta.Put(p, "protocols", protomap)
+	return pb.meta.Put(p, protocolsKey, protomap)
 }
 
 func (pb *dsProtoBook) AddProtocols(p peer.ID, protos ...string) error {
 	if err := p.Validate(); err != nil {
 		return err
 	}
+	if err := pb.strict.Protocols("AddProtocols", p, protos); err != nil {
+		return err
+	}
 
 	s := pb.segments.get(p)
 	s.Lock()
@@ -73,7 +84,7 @@ func (pb *dsProtoBook) AddProtocols(p peer.ID, protos ...string) error {
 		pmap[proto] = struct{}{}
 	}
 
-	return pb.meta.Put(p, "protocols", pmap)
+	return pb.meta.Put(p, protocolsKey, pmap)
 }
 
 func (pb *dsProtoBook) GetProtocols(p peer.ID) ([]string, error) {
@@ -167,11 +178,11 @@ func (pb *dsProtoBook) RemoveProtocols(p peer.ID, protos ...string) error {
 		delete(pmap, proto)
 	}
 
-	return pb.meta.Put(p, "protocols", pmap)
+	return pb.meta.Put(p, protocolsKey, pmap)
 }
 
 func (pb *dsProtoBook) getProtocolMap(p peer.ID) (map[string]struct{}, error) {
-	iprotomap, err := pb.meta.Get(p, "protocols")
+	iprotomap, err := pb.meta.Get(p, protocolsKey)
 	switch err {
 	default:
 		return nil, err
@@ -0,0 +1,181 @@
+package pstoreds
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/libp2p/go-libp2p-peerstore/pb"
+)
+
+// CacheReconciliation is the policy deciding what the address book does when a cached record and the record held by
+// the datastore disagree, as they may once other processes share the datastore, or writes are retained by the retry
+// policy. Records are compared on their addresses, with their expiry and TTL, and on the sequence number of their
+// signed peer record, once expired addresses are dropped from both.
+type CacheReconciliation int
+
+const (
+	// ReconcileNone trusts the cache: cached records are served without reading the datastore.
+	ReconcileNone CacheReconciliation = iota
+	// ReconcileTrustDatastore compares every cached record served with the datastore, and replaces it with the stored
+	// record if they differ. Caching then only saves decoding records that didn't change.
+	ReconcileTrustDatastore
+	// ReconcileTrustCacheWithinTTL serves cached records without comparing them for Options.ReconcileTTL after they
+	// were read from the datastore or last compared, and like ReconcileTrustDatastore past that.
+	ReconcileTrustCacheWithinTTL
+	// ReconcileReadRepair compares every cached record served with the datastore, and merges them if they differ, like
+	// the retry policy merges the writes it retained: the addresses of both are kept, each with its latest expiry and
+	// largest TTL, and the signed peer record with the highest sequence number wins. The merged record is written
+	// back. Addresses removed by another process are thus restored if the cache still holds them.
+	ReconcileReadRepair
+)
+
+func (c CacheReconciliation) String() string {
+	switch c {
+	case ReconcileNone:
+		return "none"
+	case ReconcileTrustDatastore:
+		return "trust-datastore"
+	case ReconcileTrustCacheWithinTTL:
+		return "trust-cache-within-ttl"
+	case ReconcileReadRepair:
+		return "read-repair"
+	default:
+		return fmt.Sprintf("CacheReconciliation(%d)", int(c))
+	}
+}
+
+// ReconcileStats holds the counters of the cache reconciliation policy.
+type ReconcileStats struct {
+	// Checks is the number of cached records compared with the datastore.
+	Checks uint64
+	// Divergences is the number of cached records found to differ from the datastore.
+	Divergences uint64
+	// Repairs is the number of merged records written back by ReconcileReadRepair.
+	Repairs uint64
+}
+
+// cacheReconciler compares cached records with the datastore, as set by Options.CacheReconciliation.
+type cacheReconciler struct {
+	checks      uint64 // accessed atomically; keep first for 64-bit alignment.
+	divergences uint64 // accessed atomically.
+	repairs     uint64 // accessed atomically.
+
+	ab     *dsAddrBook
+	policy CacheReconciliation
+	ttl    time.Duration
+}
+
+func newReconciler(ab *dsAddrBook, policy CacheReconciliation, ttl time.Duration) (*cacheReconciler, error) {
+	switch policy {
+	case ReconcileNone:
+		return nil, nil
+	case ReconcileTrustDatastore, ReconcileReadRepair:
+	case ReconcileTrustCacheWithinTTL:
+		if ttl <= 0 {
+			return nil, fmt.Errorf("reconciliation policy %s requires a positive TTL: %s", policy, ttl)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cache reconciliation policy: %s", policy)
+	}
+	return &cacheReconciler{ab: ab, policy: policy, ttl: ttl}, nil
+}
+
+// loaded records that a record was just read from the datastore. To be called within the lock of the record.
+func (r *cacheReconciler) loaded(pr *addrsRecord) {
+	if r == nil {
+		return
+	}
+	pr.checked = r.ab.clock.Now().UnixNano()
+}
+
+// check compares a cached record with the datastore, if the policy calls for it, and reconciles them. Records with
+// queued refreshes are ahead of the datastore by design, and aren't compared. To be called within the lock of the
+// record.
+func (r *cacheReconciler) check(pr *addrsRecord) error {
+	if r == nil {
+		return nil
+	}
+	now := r.ab.clock.Now()
+	if r.policy == ReconcileTrustCacheWithinTTL && now.Sub(time.Unix(0, pr.checked)) < r.ttl {
+		return nil
+	}
+
+	stored := &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}}
+	found, err := r.ab.records.load(r.ab.ds, pr.Id.ID, stored)
+	if err != nil {
+		return err
+	}
+	if !found {
+		stored.Id = pr.Id
+	}
+	atomic.AddUint64(&r.checks, 1)
+	pr.checked = now.UnixNano()
+
+	r.ab.cleanRecord(pr)
+	r.ab.cleanRecord(stored)
+	if sameRecord(pr.AddrBookRecord, stored.AddrBookRecord) {
+		return nil
+	}
+	atomic.AddUint64(&r.divergences, 1)
+	log.Debugf("cached address record of peer %s differs from the datastore, reconciling with policy %s",
+		pr.Id.ID.Pretty(), r.policy)
+
+	if r.policy != ReconcileReadRepair {
+		pr.AddrBookRecord, pr.stored, pr.dirty = stored.AddrBookRecord, stored.stored, false
+		r.ab.indexRecord(pr)
+		r.ab.gens.Bump(pr.Id.ID)
+		return nil
+	}
+
+	pr.Addrs = mergeAddrEntries(pr.Addrs, stored.Addrs)
+	if stored.CertifiedRecord != nil && (pr.CertifiedRecord == nil || stored.CertifiedRecord.Seq > pr.CertifiedRecord.Seq) {
+		pr.CertifiedRecord = stored.CertifiedRecord
+	}
+	// the per-address layout writes the entries that differ from those stored.
+	pr.stored = stored.stored
+	pr.dirty = true
+	r.ab.cleanRecord(pr)
+	if err := r.ab.records.flush(r.ab.ds, pr); err != nil {
+		return err
+	}
+	r.ab.indexRecord(pr)
+	r.ab.changes.mark(r.ab.ds, pr.Id.ID)
+	r.ab.gens.Bump(pr.Id.ID)
+	atomic.AddUint64(&r.repairs, 1)
+	return nil
+}
+
+func (r *cacheReconciler) stats() ReconcileStats {
+	if r == nil {
+		return ReconcileStats{}
+	}
+	return ReconcileStats{
+		Checks:      atomic.LoadUint64(&r.checks),
+		Divergences: atomic.LoadUint64(&r.divergences),
+		Repairs:     atomic.LoadUint64(&r.repairs),
+	}
+}
+
+// sameRecord returns whether two clean records hold the same addresses, with the same expiry and TTL, and signed peer
+// records of the same sequence number.
+func sameRecord(a, b *pb.AddrBookRecord) bool {
+	if len(a.Addrs) != len(b.Addrs) {
+		return false
+	}
+	if (a.CertifiedRecord == nil) != (b.CertifiedRecord == nil) ||
+		a.CertifiedRecord != nil && a.CertifiedRecord.Seq != b.CertifiedRecord.Seq {
+		return false
+	}
+	entries := make(map[string]*pb.AddrBookRecord_AddrEntry, len(a.Addrs))
+	for _, e := range a.Addrs {
+		entries[string(e.Addr.Bytes())] = e
+	}
+	for _, e := range b.Addrs {
+		have, ok := entries[string(e.Addr.Bytes())]
+		if !ok || have.Expiry != e.Expiry || have.Ttl != e.Ttl {
+			return false
+		}
+	}
+	return true
+}
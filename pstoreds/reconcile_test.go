@@ -0,0 +1,123 @@
+package pstoreds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+// newSharedAddrBooks returns two address books sharing a datastore, as two processes would, the first with the
+// reconciliation policy under test.
+func newSharedAddrBooks(t *testing.T, policy CacheReconciliation, clock *test.MockClock) (reader, writer *dsAddrBook, closeFn func()) {
+	t.Helper()
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+	opts.Clock = clock
+	opts.CacheReconciliation = policy
+	opts.ReconcileTTL = time.Minute
+
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	reader, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.CacheReconciliation = ReconcileNone
+	writer, err = NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		reader.Close()
+		t.Fatal(err)
+	}
+	return reader, writer, func() {
+		reader.Close()
+		writer.Close()
+	}
+}
+
+func TestCacheReconciliation(t *testing.T) {
+	id := test.GeneratePeerIDs(1)[0]
+	addrs := test.GenerateAddrs(3)
+
+	t.Run("None", func(t *testing.T) {
+		reader, writer, closeFn := newSharedAddrBooks(t, ReconcileNone, test.NewMockClock())
+		defer closeFn()
+
+		reader.AddAddrs(id, addrs[:1], time.Hour)
+		writer.AddAddrs(id, addrs[1:2], time.Hour)
+		test.AssertAddressesEqual(t, addrs[:1], reader.Addrs(id))
+		if s := reader.Stats().Reconciliation; s != (ReconcileStats{}) {
+			t.Fatalf("expected no reconciliation, got %+v", s)
+		}
+	})
+
+	t.Run("TrustDatastore", func(t *testing.T) {
+		reader, writer, closeFn := newSharedAddrBooks(t, ReconcileTrustDatastore, test.NewMockClock())
+		defer closeFn()
+
+		reader.AddAddrs(id, addrs[:1], time.Hour)
+		test.AssertAddressesEqual(t, addrs[:1], reader.Addrs(id))
+		writer.AddAddrs(id, addrs[1:2], time.Hour)
+		test.AssertAddressesEqual(t, addrs[:2], reader.Addrs(id))
+		writer.ClearAddrs(id)
+		if got := reader.Addrs(id); len(got) != 0 {
+			t.Fatalf("expected the removal to be seen, got %v", got)
+		}
+		if s := reader.Stats().Reconciliation; s.Checks != 3 || s.Divergences != 2 || s.Repairs != 0 {
+			t.Fatalf("unexpected counters %+v", s)
+		}
+	})
+
+	t.Run("TrustCacheWithinTTL", func(t *testing.T) {
+		clock := test.NewMockClock()
+		reader, writer, closeFn := newSharedAddrBooks(t, ReconcileTrustCacheWithinTTL, clock)
+		defer closeFn()
+
+		reader.AddAddrs(id, addrs[:1], time.Hour)
+		writer.AddAddrs(id, addrs[1:2], time.Hour)
+		test.AssertAddressesEqual(t, addrs[:1], reader.Addrs(id))
+		clock.Add(time.Minute)
+		test.AssertAddressesEqual(t, addrs[:2], reader.Addrs(id))
+		if s := reader.Stats().Reconciliation; s.Checks != 1 || s.Divergences != 1 {
+			t.Fatalf("unexpected counters %+v", s)
+		}
+	})
+
+	t.Run("ReadRepair", func(t *testing.T) {
+		reader, writer, closeFn := newSharedAddrBooks(t, ReconcileReadRepair, test.NewMockClock())
+		defer closeFn()
+
+		reader.AddAddrs(id, addrs[:1], time.Hour)
+		test.AssertAddressesEqual(t, addrs[:1], reader.Addrs(id))
+		// the writer replaces the addresses behind the back of the reader.
+		writer.SetAddrs(id, addrs[:1], 0)
+		writer.AddAddrs(id, addrs[1:3], 2*time.Hour)
+		test.AssertAddressesEqual(t, addrs, reader.Addrs(id))
+		if s := reader.Stats().Reconciliation; s.Divergences != 1 || s.Repairs != 1 {
+			t.Fatalf("unexpected counters %+v", s)
+		}
+
+		// the merged record was written back.
+		writer.cache.Remove(id)
+		test.AssertAddressesEqual(t, addrs, writer.Addrs(id))
+		test.AssertAddressesEqual(t, addrs, reader.Addrs(id))
+		if s := reader.Stats().Reconciliation; s.Divergences != 1 {
+			t.Fatalf("expected the repaired record to match the datastore, got %+v", s)
+		}
+	})
+}
+
+func TestCacheReconciliationOptions(t *testing.T) {
+	opts := DefaultOpts()
+	opts.CacheReconciliation = ReconcileTrustCacheWithinTTL
+	if _, err := NewAddrBook(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts); err == nil {
+		t.Fatal("expected an error without a reconciliation TTL")
+	}
+	opts.CacheReconciliation = ReconcileReadRepair + 1
+	if _, err := NewAddrBook(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts); err == nil {
+		t.Fatal("expected an error for an unknown policy")
+	}
+}
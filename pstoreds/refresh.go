@@ -0,0 +1,133 @@
+package pstoreds
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// refreshQueue holds the records whose latest changes are TTL refreshes of addresses they already held, as made by
+// identify on every connection, until the next flush writes them all in a single batch. Until then, reads are served
+// from the queued records, which are kept out of the datastore.
+type refreshQueue struct {
+	deferred uint64 // accessed atomically; keep first for 64-bit alignment.
+	written  uint64 // accessed atomically.
+
+	ab       *dsAddrBook
+	interval time.Duration
+
+	// mu is held for the whole of a flush, and before the lock of any record.
+	mu      sync.Mutex
+	pending map[peer.ID]*addrsRecord
+}
+
+func newRefreshQueue(ab *dsAddrBook, interval time.Duration) (*refreshQueue, error) {
+	if interval < 0 {
+		return nil, fmt.Errorf("negative refresh flush interval provided: %s", interval)
+	}
+	if interval == 0 {
+		return nil, nil
+	}
+	return &refreshQueue{ab: ab, interval: interval, pending: make(map[peer.ID]*addrsRecord)}, nil
+}
+
+// add queues the record of p until the next flush. It must not be called with the lock of a record held.
+func (q *refreshQueue) add(p peer.ID, pr *addrsRecord) {
+	atomic.AddUint64(&q.deferred, 1)
+	q.mu.Lock()
+	q.pending[p] = pr
+	q.mu.Unlock()
+}
+
+// get returns the queued record of p, if any.
+func (q *refreshQueue) get(p peer.ID) *addrsRecord {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending[p]
+}
+
+// forget drops the queued record of p, if any, without writing it, when the record is removed or known to be stale.
+// Once it returns, no flush writes the record.
+func (q *refreshQueue) forget(p peer.ID) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	delete(q.pending, p)
+	q.mu.Unlock()
+}
+
+// background flushes the queued records every interval, and once more when the address book is closed. It should be
+// spawned as a goroutine.
+func (q *refreshQueue) background() {
+	defer q.ab.childrenDone.Done()
+
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := q.flush(); err != nil {
+				log.Warnf("failed to flush the refreshed address records: %s", err)
+			}
+		case <-q.ab.ctx.Done():
+			if err := q.flush(); err != nil {
+				log.Warnf("failed to flush the refreshed address records on close: %s", err)
+			}
+			return
+		}
+	}
+}
+
+// flush writes the queued records in a single batch. Records stay queued if the batch fails, to be retried by the
+// next flush.
+func (q *refreshQueue) flush() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return nil
+	}
+
+	batch, err := q.ab.ds.Batch()
+	if err != nil {
+		return err
+	}
+
+	// records stay locked until the batch is committed, so that no write of theirs lands in between and is then
+	// overwritten by the batch.
+	locked := make([]*addrsRecord, 0, len(q.pending))
+	defer func() {
+		for _, pr := range locked {
+			pr.Unlock()
+		}
+	}()
+	for p, pr := range q.pending {
+		pr.Lock()
+		locked = append(locked, pr)
+		if err := q.ab.records.flush(batch, pr); err != nil {
+			return err
+		}
+		q.ab.indexRecord(pr)
+		q.ab.changes.mark(batch, p)
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&q.written, uint64(len(q.pending)))
+	q.pending = make(map[peer.ID]*addrsRecord)
+	return nil
+}
+
+func (q *refreshQueue) stats() (deferred, written uint64) {
+	if q == nil {
+		return 0, 0
+	}
+	return atomic.LoadUint64(&q.deferred), atomic.LoadUint64(&q.written)
+}
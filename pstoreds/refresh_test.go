@@ -0,0 +1,123 @@
+package pstoreds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+
+	pb "github.com/libp2p/go-libp2p-peerstore/pb"
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+// storedExpiry returns the latest expiry of the addresses of p in the datastore.
+func storedExpiry(t *testing.T, ab *dsAddrBook, store ds.Read, p peer.ID) (n int, latest int64) {
+	t.Helper()
+	rec := &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}}
+	if _, err := ab.records.load(store, p, rec); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range rec.Addrs {
+		if a.Expiry > latest {
+			latest = a.Expiry
+		}
+	}
+	return len(rec.Addrs), latest
+}
+
+func TestRefreshCoalescing(t *testing.T) {
+	clock := test.NewMockClock()
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+	opts.Clock = clock
+	// flushes are driven by hand.
+	opts.RefreshFlushInterval = time.Hour
+
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+
+	ids := test.GeneratePeerIDs(2)
+	addrs := test.GenerateAddrs(3)
+	ab.AddAddrs(ids[0], addrs[:1], time.Hour)
+	ab.AddAddrs(ids[1], addrs[2:], time.Hour)
+	_, before := storedExpiry(t, ab, store, ids[0])
+
+	// refreshes are served from memory, even once evicted from the cache, but not written.
+	clock.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		ab.AddAddrs(ids[0], addrs[:1], 2*time.Hour)
+		ab.AddAddrs(ids[1], addrs[2:], 2*time.Hour)
+	}
+	if _, exp := storedExpiry(t, ab, store, ids[0]); exp != before {
+		t.Fatal("expected the refresh not to be written before the flush")
+	}
+	ab.cache.Remove(ids[0])
+	want := clock.Now().Add(2 * time.Hour).Unix()
+	if got := ab.AddrsWithExpiry(ids[0]); len(got) != 1 || got[0].Expires.Unix() != want {
+		t.Fatalf("expected the refreshed expiry to be served, got %v", got)
+	}
+
+	// new addresses are written immediately.
+	ab.AddAddrs(ids[0], addrs[1:2], time.Hour)
+	if n, _ := storedExpiry(t, ab, store, ids[0]); n != 2 {
+		t.Fatalf("expected the new address to be written, got %d stored addresses", n)
+	}
+
+	// cleared peers aren't written back by the flush.
+	ab.ClearAddrs(ids[1])
+
+	if err := ab.refreshes.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, exp := storedExpiry(t, ab, store, ids[0]); exp != want {
+		t.Fatal("expected the refresh to be written by the flush")
+	}
+	if n, _ := storedExpiry(t, ab, store, ids[1]); n != 0 {
+		t.Fatalf("expected the cleared peer to stay cleared, got %d stored addresses", n)
+	}
+
+	stats := ab.Stats()
+	if stats.DeferredRefreshes != 6 || stats.RefreshWrites != 1 {
+		t.Fatalf("expected 6 deferred refreshes and 1 write, got %d and %d", stats.DeferredRefreshes, stats.RefreshWrites)
+	}
+}
+
+func TestRefreshCoalescingFlushOnClose(t *testing.T) {
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+	opts.RefreshFlushInterval = time.Hour
+
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := test.GeneratePeerIDs(1)[0]
+	addrs := test.GenerateAddrs(1)
+	ab.AddAddrs(id, addrs, time.Hour)
+	ab.AddAddrs(id, addrs, 2*time.Hour)
+	if err := ab.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exp := storedExpiry(t, ab, store, id); time.Until(time.Unix(exp, 0)) < 90*time.Minute {
+		t.Fatal("expected the refresh to be written on close")
+	}
+}
+
+func TestRefreshCoalescingOptions(t *testing.T) {
+	opts := DefaultOpts()
+	opts.RefreshFlushInterval = -time.Second
+	if _, err := NewAddrBook(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts); err == nil {
+		t.Fatal("expected a negative interval to be rejected")
+	}
+}
@@ -0,0 +1,633 @@
+package pstoreds
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+// RetryPolicy configures how datastore operations are retried, and when to stop hitting a failing datastore
+// altogether. The zero value disables both retries and the circuit breaker.
+type RetryPolicy struct {
+	// Maximum number of attempts per operation, the first one included. Values lower than 2 disable retries.
+	MaxAttempts int
+
+	// Delay before the first retry, doubled on every subsequent retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Number of consecutive failed operations after which the circuit breaker opens, and the peerstore enters
+	// degraded mode. A zero value disables the circuit breaker.
+	//
+	// In degraded mode, the peerstore operates on memory only: writes are held in memory, and reads are served from
+	// the address book cache and the held writes. Iterating over peers fails, as the persisted ones can't be listed;
+	// once the breaker closes, held writes not flushed yet are merged into the listing. When the breaker closes, held
+	// writes are reconciled with the datastore: address records written while degraded are merged with their persisted
+	// counterparts, and other values overwrite them.
+	BreakerThreshold int
+
+	// Time the circuit breaker stays open before letting an operation through to probe the datastore.
+	BreakerCooldown time.Duration
+
+	// Notify is called when the peerstore enters or leaves degraded mode. It is called synchronously, from the
+	// goroutine whose operation caused the transition, so it must not block nor call back into the peerstore.
+	Notify func(DatastoreEvent)
+}
+
+// DatastoreEvent reports a transition into or out of degraded mode.
+type DatastoreEvent struct {
+	// Degraded is true when entering degraded mode, and false when leaving it.
+	Degraded bool
+
+	// Err is the datastore error that caused entering degraded mode.
+	Err error
+
+	// Pending is the number of held writes that could not be flushed when leaving degraded mode. They are retried
+	// on subsequent operations.
+	Pending int
+}
+
+func (p RetryPolicy) enabled() bool {
+	return p.MaxAttempts > 1 || p.BreakerThreshold > 0
+}
+
+// DatastoreStats is a snapshot of the counters maintained by the retry policy.
+type DatastoreStats struct {
+	// Failures is the number of failed datastore calls, retries included.
+	Failures uint64
+
+	// Retries is the number of retried datastore calls.
+	Retries uint64
+
+	// BreakerTrips is the number of times the circuit breaker opened.
+	BreakerTrips uint64
+
+	// FallbackOps is the number of operations served from memory while the circuit breaker was open.
+	FallbackOps uint64
+}
+
+var errBreakerOpen = errors.New("datastore circuit breaker open")
+
+// retryStore wraps a datastore with a RetryPolicy.
+type retryStore struct {
+	// accessed atomically; keep first for 64-bit alignment.
+	stats DatastoreStats
+
+	// accessed atomically; set while the operation probing the datastore after the cooldown is in flight.
+	probing int32
+
+	child  ds.Batching
+	policy RetryPolicy
+
+	mu          sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	open        bool
+
+	// writes held while the breaker is open; deletes are held as tombstones. holds counts the held writes, so that
+	// a flush replaying a snapshot of them can tell whether others were held meanwhile.
+	overlay    ds.Batching
+	tombstones map[ds.Key]struct{}
+	held       bool
+	holds      uint64
+	flushing   bool
+
+	// keys read while the breaker was open, whose held values were built without knowledge of the persisted ones.
+	blind       map[ds.Key]struct{}
+	reconcilers []reconciler
+}
+
+// reconciler merges a value written blind while the breaker was open with the persisted value, for keys under
+// prefix.
+type reconciler struct {
+	prefix ds.Key
+	merge  func(key ds.Key, held, persisted []byte) ([]byte, error)
+}
+
+var _ ds.Batching = (*retryStore)(nil)
+
+// wrapStore nests store under the namespace in opts, times it if Options.BackendTimings is set, and applies the retry
+// policy in opts to it, unless it is disabled or store is wrapped already.
+func wrapStore(store ds.Batching, opts Options) ds.Batching {
+	switch store.(type) {
+	case *retryStore, *timedStore, *timedTxnStore:
+		return store
+	}
+	store = timeStore(namespaceStore(store, opts).(ds.Batching), opts)
+	if !opts.Retry.enabled() {
+		return store
+	}
+	return newRetryStore(store, opts.Retry)
+}
+
+func newRetryStore(store ds.Batching, policy RetryPolicy) *retryStore {
+	return &retryStore{
+		child:      store,
+		policy:     policy,
+		overlay:    dssync.MutexWrap(ds.NewMapDatastore()),
+		tombstones: make(map[ds.Key]struct{}),
+		blind:      make(map[ds.Key]struct{}),
+	}
+}
+
+// bookStore prepares store for a book constructed on its own: stores implementing ds.Batching are wrapped like the
+// store of a peerstore, others are only nested under the namespace in opts, as the retry policy needs batching.
+func bookStore(store ds.Datastore, opts Options) ds.Datastore {
+	if batching, ok := store.(ds.Batching); ok {
+		return wrapStore(batching, opts)
+	}
+	return namespaceStore(store, opts)
+}
+
+// addReconciler registers a merge function for blind writes to keys under prefix.
+func (rs *retryStore) addReconciler(prefix ds.Key, merge func(key ds.Key, held, persisted []byte) ([]byte, error)) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.reconcilers = append(rs.reconcilers, reconciler{prefix: prefix, merge: merge})
+}
+
+func (rs *retryStore) Stats() DatastoreStats {
+	return DatastoreStats{
+		Failures:     atomic.LoadUint64(&rs.stats.Failures),
+		Retries:      atomic.LoadUint64(&rs.stats.Retries),
+		BreakerTrips: atomic.LoadUint64(&rs.stats.BreakerTrips),
+		FallbackOps:  atomic.LoadUint64(&rs.stats.FallbackOps),
+	}
+}
+
+// ready returns whether the breaker is closed, or its cooldown has elapsed.
+func (rs *retryStore) ready() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return !rs.open || !time.Now().Before(rs.openUntil)
+}
+
+// allow returns whether an operation may hit the datastore, and whether it's the probe. Once the cooldown has elapsed,
+// a single operation is let through to probe the datastore, while others keep being served from memory: the breaker
+// closes if the probe succeeds, and re-opens if it fails.
+func (rs *retryStore) allow() (allowed, probe bool) {
+	rs.mu.Lock()
+	open, openUntil := rs.open, rs.openUntil
+	rs.mu.Unlock()
+	if !open {
+		return true, false
+	}
+	if time.Now().Before(openUntil) || !atomic.CompareAndSwapInt32(&rs.probing, 0, 1) {
+		return false, false
+	}
+	return true, true
+}
+
+// do runs op against the datastore, retrying it as per the policy. Not found errors are not failures.
+func (rs *retryStore) do(op func() error) error {
+	allowed, probe := rs.allow()
+	if !allowed {
+		return errBreakerOpen
+	}
+	if probe {
+		// released once succeeded or failed has closed or re-opened the breaker.
+		defer atomic.StoreInt32(&rs.probing, 0)
+	}
+
+	backoff := rs.policy.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = op(); err == nil || err == ds.ErrNotFound {
+			rs.succeeded()
+			return err
+		}
+		atomic.AddUint64(&rs.stats.Failures, 1)
+		if attempt >= rs.policy.MaxAttempts {
+			break
+		}
+
+		atomic.AddUint64(&rs.stats.Retries, 1)
+		time.Sleep(backoff)
+		if backoff *= 2; rs.policy.MaxBackoff > 0 && backoff > rs.policy.MaxBackoff {
+			backoff = rs.policy.MaxBackoff
+		}
+	}
+	rs.failed(err)
+	return err
+}
+
+func (rs *retryStore) succeeded() {
+	rs.mu.Lock()
+	rs.consecutive = 0
+	wasOpen, held := rs.open, rs.held
+	rs.open = false
+	rs.mu.Unlock()
+
+	if !wasOpen && !held {
+		return
+	}
+	pending := rs.flush()
+	if wasOpen {
+		log.Infof("datastore recovered; leaving degraded mode with %d writes pending", pending)
+		rs.notify(DatastoreEvent{Degraded: false, Pending: pending})
+	}
+}
+
+func (rs *retryStore) failed(err error) {
+	if rs.policy.BreakerThreshold <= 0 {
+		return
+	}
+
+	rs.mu.Lock()
+	rs.consecutive++
+	tripped := !rs.open && rs.consecutive >= rs.policy.BreakerThreshold
+	if rs.open || tripped {
+		rs.open = true
+		rs.openUntil = time.Now().Add(rs.policy.BreakerCooldown)
+	}
+	consecutive := rs.consecutive
+	rs.mu.Unlock()
+
+	if tripped {
+		atomic.AddUint64(&rs.stats.BreakerTrips, 1)
+		log.Warnf("datastore failed %d consecutive operations; entering degraded mode: %s", consecutive, err)
+		rs.notify(DatastoreEvent{Degraded: true, Err: err})
+	}
+}
+
+func (rs *retryStore) notify(evt DatastoreEvent) {
+	if rs.policy.Notify != nil {
+		rs.policy.Notify(evt)
+	}
+}
+
+// heldOp is a write held while the breaker was open, as replayed by flush. merge is set for blind writes a reconciler
+// applies to.
+type heldOp struct {
+	batchOp
+	merge func(key ds.Key, held, persisted []byte) ([]byte, error)
+}
+
+// flush writes the operations held while the breaker was open to the datastore, reconciling blind writes, and
+// returns the number of operations that failed. These are held until the next flush.
+//
+// The held operations are snapshotted under the lock, and replayed outside of it so that a slow datastore doesn't
+// stall reads and writes. Writes held again meanwhile, under the same keys or others, are kept for the next flush.
+func (rs *retryStore) flush() (pending int) {
+	ops, holds, pending := rs.heldOps()
+	if ops == nil {
+		return pending
+	}
+
+	var flushed []heldOp
+	for _, op := range ops {
+		if err := rs.replay(op); err != nil {
+			log.Warnf("failed to flush held write for key %s: %s", op.key, err)
+			pending++
+			continue
+		}
+		flushed = append(flushed, op)
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.flushing = false
+	for _, op := range flushed {
+		if op.delete {
+			if _, ok := rs.tombstones[op.key]; !ok {
+				continue
+			}
+			delete(rs.tombstones, op.key)
+		} else {
+			if value, err := rs.overlay.Get(op.key); err != nil || !bytes.Equal(value, op.value) {
+				continue
+			}
+			_ = rs.overlay.Delete(op.key)
+		}
+		delete(rs.blind, op.key)
+	}
+	rs.held = pending > 0 || rs.holds != holds
+	if !rs.held {
+		// writes issued from now on are built with knowledge of the persisted values.
+		rs.blind = make(map[ds.Key]struct{})
+	}
+	return pending
+}
+
+// heldOps snapshots the held operations for flush, and the number of writes held so far. It returns no operations,
+// along with the number of those pending, if there's nothing to flush, or another flush is in progress.
+func (rs *retryStore) heldOps() (ops []heldOp, holds uint64, pending int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if !rs.held || rs.flushing {
+		return nil, 0, 0
+	}
+
+	results, err := rs.overlay.Query(query.Query{})
+	if err != nil {
+		return nil, 0, len(rs.tombstones)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, 0, len(rs.tombstones)
+	}
+
+	ops = make([]heldOp, 0, len(entries)+len(rs.tombstones))
+	for _, e := range entries {
+		k := ds.RawKey(e.Key)
+		ops = append(ops, heldOp{batchOp: batchOp{key: k, value: e.Value}, merge: rs.reconciler(k)})
+	}
+	for k := range rs.tombstones {
+		ops = append(ops, heldOp{batchOp: batchOp{key: k, delete: true}})
+	}
+	rs.flushing = true
+	return ops, rs.holds, 0
+}
+
+// replay writes a held operation to the datastore, merging a blind write with the persisted value.
+func (rs *retryStore) replay(op heldOp) error {
+	if op.delete {
+		if err := rs.child.Delete(op.key); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+		return nil
+	}
+
+	value := op.value
+	if op.merge != nil {
+		persisted, err := rs.child.Get(op.key)
+		switch err {
+		case nil:
+			if value, err = op.merge(op.key, op.value, persisted); err != nil {
+				return err
+			}
+		case ds.ErrNotFound:
+		default:
+			return err
+		}
+	}
+	return rs.child.Put(op.key, value)
+}
+
+// reconciler returns the merge function of the reconciler applying to a key written blind, if any. To be called
+// within the lock.
+func (rs *retryStore) reconciler(key ds.Key) func(key ds.Key, held, persisted []byte) ([]byte, error) {
+	if _, ok := rs.blind[key]; !ok {
+		return nil
+	}
+	for _, r := range rs.reconcilers {
+		if r.prefix.IsAncestorOf(key) {
+			return r.merge
+		}
+	}
+	return nil
+}
+
+// heldGet returns the value of a key written while the breaker was open, if any.
+func (rs *retryStore) heldGet(key ds.Key) (value []byte, found bool, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if !rs.held {
+		return nil, false, nil
+	}
+	if _, ok := rs.tombstones[key]; ok {
+		return nil, true, ds.ErrNotFound
+	}
+	if value, err = rs.overlay.Get(key); err == ds.ErrNotFound {
+		return nil, false, nil
+	}
+	return value, true, err
+}
+
+func (rs *retryStore) holdPut(key ds.Key, value []byte) error {
+	atomic.AddUint64(&rs.stats.FallbackOps, 1)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.held = true
+	rs.holds++
+	delete(rs.tombstones, key)
+	return rs.overlay.Put(key, value)
+}
+
+func (rs *retryStore) holdDelete(key ds.Key) error {
+	atomic.AddUint64(&rs.stats.FallbackOps, 1)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.held = true
+	rs.holds++
+	rs.tombstones[key] = struct{}{}
+	_ = rs.overlay.Delete(key)
+	return nil
+}
+
+func (rs *retryStore) Get(key ds.Key) (value []byte, err error) {
+	if _, found, _ := rs.heldGet(key); found && rs.ready() {
+		// the breaker may let an operation through; probe the datastore, which flushes held writes on success.
+		_ = rs.do(func() error {
+			_, err := rs.child.Has(key)
+			return err
+		})
+	}
+	if value, found, err := rs.heldGet(key); found {
+		return value, err
+	}
+	err = rs.do(func() (err error) {
+		value, err = rs.child.Get(key)
+		return err
+	})
+	if err == errBreakerOpen {
+		atomic.AddUint64(&rs.stats.FallbackOps, 1)
+		rs.mu.Lock()
+		rs.blind[key] = struct{}{}
+		rs.mu.Unlock()
+		return nil, ds.ErrNotFound
+	}
+	return value, err
+}
+
+func (rs *retryStore) Has(key ds.Key) (exists bool, err error) {
+	return ds.GetBackedHas(rs, key)
+}
+
+func (rs *retryStore) GetSize(key ds.Key) (size int, err error) {
+	return ds.GetBackedSize(rs, key)
+}
+
+func (rs *retryStore) Put(key ds.Key, value []byte) error {
+	err := rs.do(func() error { return rs.child.Put(key, value) })
+	if err == errBreakerOpen {
+		return rs.holdPut(key, value)
+	}
+	return err
+}
+
+func (rs *retryStore) Delete(key ds.Key) error {
+	err := rs.do(func() error { return rs.child.Delete(key) })
+	if err == errBreakerOpen {
+		return rs.holdDelete(key)
+	}
+	return err
+}
+
+// Query queries the datastore, merging the writes held in memory over its results. It fails while the breaker is
+// open, as the persisted entries can't be queried.
+func (rs *retryStore) Query(q query.Query) (res query.Results, err error) {
+	if rs.isHeld() && rs.ready() {
+		// the breaker may let an operation through; probe the datastore, so that held writes are flushed before querying.
+		_ = rs.do(func() error {
+			_, err := rs.child.Has(ds.NewKey(q.Prefix))
+			return err
+		})
+	}
+	if !rs.isHeld() {
+		err = rs.do(func() (err error) {
+			res, err = rs.child.Query(q)
+			return err
+		})
+		return res, err
+	}
+
+	// orders, offset and limit apply to the merged entries.
+	inner := q
+	inner.Orders, inner.Offset, inner.Limit = nil, 0, 0
+	err = rs.do(func() (err error) {
+		res, err = rs.child.Query(inner)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	merged, err := rs.mergeHeld(inner, res)
+	if err != nil {
+		_ = res.Close()
+		return nil, err
+	}
+	merged = query.NaiveQueryApply(query.Query{Orders: q.Orders, Offset: q.Offset, Limit: q.Limit}, merged)
+	return query.ResultsReplaceQuery(merged, q), nil
+}
+
+func (rs *retryStore) isHeld() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.held
+}
+
+// mergeHeld returns the results of the datastore for q, with the writes held in memory merged over them: held values
+// replace persisted ones, and held deletes hide them.
+func (rs *retryStore) mergeHeld(q query.Query, res query.Results) (query.Results, error) {
+	rs.mu.Lock()
+	results, err := rs.overlay.Query(q)
+	if err != nil {
+		rs.mu.Unlock()
+		return nil, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		rs.mu.Unlock()
+		return nil, err
+	}
+	shadowed := make(map[string]struct{}, len(entries)+len(rs.tombstones))
+	for _, e := range entries {
+		shadowed[e.Key] = struct{}{}
+	}
+	for k := range rs.tombstones {
+		shadowed[k.String()] = struct{}{}
+	}
+	rs.mu.Unlock()
+
+	return query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			for {
+				r, ok := res.NextSync()
+				if !ok {
+					break
+				}
+				if _, ok := shadowed[r.Key]; ok && r.Error == nil {
+					continue
+				}
+				return r, true
+			}
+			if len(entries) == 0 {
+				return query.Result{}, false
+			}
+			e := entries[0]
+			entries = entries[1:]
+			return query.Result{Entry: e}, true
+		},
+		Close: res.Close,
+	}), nil
+}
+
+func (rs *retryStore) Sync(prefix ds.Key) error {
+	err := rs.do(func() error { return rs.child.Sync(prefix) })
+	if err == errBreakerOpen {
+		return nil
+	}
+	return err
+}
+
+func (rs *retryStore) Close() error {
+	return rs.child.Close()
+}
+
+func (rs *retryStore) Batch() (ds.Batch, error) {
+	return &retryBatch{rs: rs}, nil
+}
+
+type batchOp struct {
+	key    ds.Key
+	value  []byte
+	delete bool
+}
+
+// retryBatch accumulates operations, and commits them to a fresh batch of the datastore on every attempt.
+type retryBatch struct {
+	rs  *retryStore
+	ops []batchOp
+}
+
+func (b *retryBatch) Put(key ds.Key, value []byte) error {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+	return nil
+}
+
+func (b *retryBatch) Delete(key ds.Key) error {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+	return nil
+}
+
+func (b *retryBatch) Commit() error {
+	err := b.rs.do(func() error {
+		batch, err := b.rs.child.Batch()
+		if err != nil {
+			return err
+		}
+		for _, op := range b.ops {
+			if op.delete {
+				err = batch.Delete(op.key)
+			} else {
+				err = batch.Put(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return batch.Commit()
+	})
+	if err != errBreakerOpen {
+		return err
+	}
+
+	for _, op := range b.ops {
+		if op.delete {
+			err = b.rs.holdDelete(op.key)
+		} else {
+			err = b.rs.holdPut(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package pstoreds
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-datastore/failstore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	coretest "github.com/libp2p/go-libp2p-core/test"
+	b32 "github.com/multiformats/go-base32"
+
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+// flakyStore returns a datastore failing every operation while *down is non-zero, along with the backing store.
+func flakyStore(down *int32) (ds.Batching, ds.Datastore) {
+	backing := dssync.MutexWrap(ds.NewMapDatastore())
+	return failstore.NewFailstore(backing, func(string) error {
+		if atomic.LoadInt32(down) != 0 {
+			return errors.New("datastore unavailable")
+		}
+		return nil
+	}), backing
+}
+
+func addrBookKey(p peer.ID) ds.Key {
+	return addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	var failures int32 = 2
+	backing := dssync.MutexWrap(ds.NewMapDatastore())
+	store := failstore.NewFailstore(backing, func(string) error {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			return errors.New("blip")
+		}
+		return nil
+	})
+
+	opts := DefaultOpts()
+	opts.CacheSize = 0
+	opts.GCPurgeInterval = 0
+	opts.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+
+	id := test.GeneratePeerIDs(1)[0]
+	addrs := test.GenerateAddrs(1)
+	if err := ab.AddAddrsE(id, addrs, time.Hour); err != nil {
+		t.Fatalf("expected transient failures to be retried, got %s", err)
+	}
+	test.AssertAddressesEqual(t, addrs, ab.Addrs(id))
+
+	stats := ab.Stats().Datastore
+	if stats.Failures != 2 || stats.Retries != 2 || stats.BreakerTrips != 0 {
+		t.Fatalf("unexpected datastore stats: %+v", stats)
+	}
+}
+
+func TestRetryStandaloneKeyBook(t *testing.T) {
+	var failures int32 = 2
+	store := failstore.NewFailstore(dssync.MutexWrap(ds.NewMapDatastore()), func(string) error {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			return errors.New("blip")
+		}
+		return nil
+	})
+
+	opts := DefaultOpts()
+	opts.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	kb, err := NewKeyBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pub, err := coretest.RandTestKeyPair(ic.ECDSA, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kb.AddPubKey(id, pub); err != nil {
+		t.Fatalf("expected transient failures to be retried, got %s", err)
+	}
+	if !kb.PubKey(id).Equals(pub) {
+		t.Fatal("expected stored public key")
+	}
+}
+
+func TestRetryCircuitBreaker(t *testing.T) {
+	var down int32
+	store, backing := flakyStore(&down)
+
+	opts := DefaultOpts()
+	opts.CacheSize = 0
+	opts.GCPurgeInterval = 0
+	opts.Retry = RetryPolicy{
+		MaxAttempts:      2,
+		InitialBackoff:   time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooldown:  100 * time.Millisecond,
+	}
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+
+	ids := test.GeneratePeerIDs(3)
+	addrs := test.GenerateAddrs(3)
+	ab.AddAddrs(ids[0], addrs[:1], time.Hour)
+
+	// two failed operations open the breaker.
+	atomic.StoreInt32(&down, 1)
+	for i := 0; i < 2; i++ {
+		if err := ab.AddAddrsE(ids[1], addrs[1:2], time.Hour); err == nil {
+			t.Fatal("expected datastore failure")
+		}
+	}
+	if trips := ab.Stats().Datastore.BreakerTrips; trips != 1 {
+		t.Fatalf("expected breaker to trip once, got %d", trips)
+	}
+
+	// while open, writes are held in memory and reads see them.
+	if err := ab.AddAddrsE(ids[2], addrs[2:], time.Hour); err != nil {
+		t.Fatalf("expected write to fall back to memory, got %s", err)
+	}
+	test.AssertAddressesEqual(t, addrs[2:], ab.Addrs(ids[2]))
+	if ab.Stats().Datastore.FallbackOps == 0 {
+		t.Fatal("expected fallback operations to be counted")
+	}
+	if has, _ := backing.Has(addrBookKey(ids[2])); has {
+		t.Fatal("expected held write not to reach the datastore")
+	}
+
+	// once the datastore recovers and the cooldown elapses, held writes are flushed.
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(150 * time.Millisecond)
+	test.AssertAddressesEqual(t, addrs[:1], ab.Addrs(ids[0]))
+	if has, _ := backing.Has(addrBookKey(ids[2])); !has {
+		t.Fatal("expected held write to be flushed to the datastore")
+	}
+	test.AssertAddressesEqual(t, addrs[2:], ab.Addrs(ids[2]))
+}
+
+func TestDegradedModeReconciliation(t *testing.T) {
+	var down int32
+	store, _ := flakyStore(&down)
+
+	var events []DatastoreEvent
+	opts := DefaultOpts()
+	opts.CacheSize = 0
+	opts.GCPurgeInterval = 0
+	opts.Retry = RetryPolicy{
+		MaxAttempts:      1,
+		BreakerThreshold: 1,
+		BreakerCooldown:  100 * time.Millisecond,
+		Notify:           func(evt DatastoreEvent) { events = append(events, evt) },
+	}
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ab.Close()
+
+	id := test.GeneratePeerIDs(1)[0]
+	addrs := test.GenerateAddrs(2)
+	ab.AddAddrs(id, addrs[:1], time.Hour)
+
+	atomic.StoreInt32(&down, 1)
+	if err := ab.AddAddrsE(id, addrs[1:], time.Hour); err == nil {
+		t.Fatal("expected datastore failure")
+	}
+	if len(events) != 1 || !events[0].Degraded || events[0].Err == nil {
+		t.Fatalf("expected event entering degraded mode, got %+v", events)
+	}
+
+	// the persisted record can't be read while degraded, so only the held write is visible.
+	if err := ab.AddAddrsE(id, addrs[1:], time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	test.AssertAddressesEqual(t, addrs[1:], ab.Addrs(id))
+
+	// on recovery, the held record is merged with the persisted one.
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(150 * time.Millisecond)
+	test.AssertAddressesEqual(t, addrs, ab.Addrs(id))
+	if len(events) != 2 || events[1].Degraded || events[1].Pending != 0 {
+		t.Fatalf("expected event leaving degraded mode, got %+v", events)
+	}
+}
+
+// gatedStore returns a datastore failing every operation while *down is non-zero. Once *armed is set, the next
+// operation named op signals entered, and blocks until release is closed.
+func gatedStore(down, armed *int32, op string, entered, release chan struct{}) (ds.Batching, ds.Datastore) {
+	backing := dssync.MutexWrap(ds.NewMapDatastore())
+	return failstore.NewFailstore(backing, func(o string) error {
+		if o == op && atomic.CompareAndSwapInt32(armed, 1, 0) {
+			close(entered)
+			<-release
+		}
+		if atomic.LoadInt32(down) != 0 {
+			return errors.New("datastore unavailable")
+		}
+		return nil
+	}), backing
+}
+
+func TestRetryHalfOpenProbe(t *testing.T) {
+	var down, armed int32 = 1, 0
+	entered, release := make(chan struct{}), make(chan struct{})
+	store, backing := gatedStore(&down, &armed, "get", entered, release)
+	rs := newRetryStore(store, RetryPolicy{MaxAttempts: 1, BreakerThreshold: 1, BreakerCooldown: 20 * time.Millisecond})
+
+	if err := rs.Put(ds.NewKey("/a"), []byte("a")); err == nil {
+		t.Fatal("expected datastore failure")
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// once the cooldown has elapsed, a single operation probes the still failing datastore.
+	atomic.StoreInt32(&armed, 1)
+	probed := make(chan error)
+	go func() {
+		_, err := rs.Get(ds.NewKey("/a"))
+		probed <- err
+	}()
+	<-entered
+	if err := rs.Put(ds.NewKey("/b"), []byte("b")); err != nil {
+		t.Fatalf("expected write to fall back to memory while probing, got %s", err)
+	}
+	if has, _ := backing.Has(ds.NewKey("/b")); has {
+		t.Fatal("expected write not to reach the datastore while probing")
+	}
+	close(release)
+	if err := <-probed; err == nil || err == ds.ErrNotFound {
+		t.Fatalf("expected probe to fail, got %v", err)
+	}
+
+	// the failed probe re-opened the breaker for another cooldown.
+	atomic.StoreInt32(&down, 0)
+	if err := rs.Put(ds.NewKey("/c"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := backing.Has(ds.NewKey("/c")); has {
+		t.Fatal("expected breaker to re-open after the failed probe")
+	}
+	if stats := rs.Stats(); stats.Failures != 2 || stats.FallbackOps != 2 {
+		t.Fatalf("unexpected datastore stats: %+v", stats)
+	}
+}
+
+func TestRetryFlushUnlocked(t *testing.T) {
+	var down, armed int32 = 1, 0
+	entered, release := make(chan struct{}), make(chan struct{})
+	store, backing := gatedStore(&down, &armed, "put", entered, release)
+	rs := newRetryStore(store, RetryPolicy{MaxAttempts: 1, BreakerThreshold: 1, BreakerCooldown: 20 * time.Millisecond})
+
+	if _, err := rs.Get(ds.NewKey("/a")); err == nil || err == ds.ErrNotFound {
+		t.Fatal("expected datastore failure")
+	}
+	if err := rs.Put(ds.NewKey("/b"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(30 * time.Millisecond)
+
+	// the probe succeeds, and flushes the held write to a slow datastore.
+	atomic.StoreInt32(&armed, 1)
+	flushed := make(chan struct{})
+	go func() {
+		_, _ = rs.Get(ds.NewKey("/a"))
+		close(flushed)
+	}()
+	<-entered
+
+	// reads and writes don't wait for the flush.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, err := rs.Get(ds.NewKey("/b")); err != nil || string(v) != "b" {
+			t.Errorf("expected held value while flushing, got %q, %v", v, err)
+		}
+		if err := rs.holdPut(ds.NewKey("/c"), []byte("c")); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("operations blocked by the flush")
+	}
+
+	close(release)
+	<-flushed
+	if v, err := backing.Get(ds.NewKey("/b")); err != nil || string(v) != "b" {
+		t.Fatalf("expected held write to be flushed, got %q, %v", v, err)
+	}
+	// the write held during the flush is kept, and flushed by the next probe.
+	if v, err := rs.Get(ds.NewKey("/c")); err != nil || string(v) != "c" {
+		t.Fatalf("expected write held during the flush to be kept, got %q, %v", v, err)
+	}
+	if has, _ := backing.Has(ds.NewKey("/c")); !has {
+		t.Fatal("expected write held during the flush to be flushed")
+	}
+}
+
+func TestRetryQueryMergesHeldWrites(t *testing.T) {
+	var down, armed int32 = 0, 0
+	entered, release := make(chan struct{}), make(chan struct{})
+	store, backing := gatedStore(&down, &armed, "put", entered, release)
+	rs := newRetryStore(store, RetryPolicy{MaxAttempts: 1, BreakerThreshold: 1, BreakerCooldown: 20 * time.Millisecond})
+
+	for _, k := range []string{"/a", "/b"} {
+		if err := backing.Put(ds.NewKey(k), []byte("persisted")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	atomic.StoreInt32(&down, 1)
+	if err := rs.Put(ds.NewKey("/b"), []byte("held")); err == nil {
+		t.Fatal("expected datastore failure")
+	}
+	if err := rs.Put(ds.NewKey("/b"), []byte("held")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Put(ds.NewKey("/c"), []byte("held")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Delete(ds.NewKey("/a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// persisted entries can't be listed while the breaker is open.
+	if _, err := rs.Query(query.Query{}); err == nil {
+		t.Fatal("expected query to fail while the breaker is open")
+	}
+
+	// while held writes are being flushed, they're merged over the persisted entries.
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(30 * time.Millisecond)
+	atomic.StoreInt32(&armed, 1)
+	flushed := make(chan struct{})
+	go func() {
+		_, _ = rs.Get(ds.NewKey("/d"))
+		close(flushed)
+	}()
+	<-entered
+	defer func() {
+		close(release)
+		<-flushed
+	}()
+
+	res, err := rs.Query(query.Query{Orders: []query.Order{query.OrderByKey{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Key+"="+string(e.Value))
+	}
+	if want := []string{"/b=held", "/c=held"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	res, err = rs.Query(query.Query{Orders: []query.Order{query.OrderByKey{}}, Offset: 1, KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = res.Rest(); err != nil || len(entries) != 1 || entries[0].Key != "/c" {
+		t.Fatalf("expected offset to apply to merged entries, got %v, %v", entries, err)
+	}
+}
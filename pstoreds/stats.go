@@ -0,0 +1,57 @@
+package pstoreds
+
+import "time"
+
+// AddrBookStats is a snapshot of the counters maintained by a datastore-backed address book.
+type AddrBookStats struct {
+	// Compactions is the number of records rewritten on read to drop expired entries.
+	Compactions uint64
+
+	// GCVisits is the number of records inspected by GC purge cycles.
+	GCVisits uint64
+
+	// GCPurges is the number of records from which GC purge cycles dropped expired entries.
+	GCPurges uint64
+
+	// GCInterval is the current interval between GC purge cycles, which varies if Options.GCAdaptive is set. Zero if
+	// GC doesn't run automatically.
+	GCInterval time.Duration
+
+	// CorruptRecords is the number of undecodable entries skipped while listing peers or collecting garbage, see
+	// Options.OnCorruptRecord. When the address book is part of a peerstore, this includes the entries skipped by the
+	// other books.
+	CorruptRecords uint64
+
+	// DebouncedAddrs is the number of address additions suppressed as repeated within Options.AddrDebounce.
+	DebouncedAddrs uint64
+
+	// TooLongAddrs is the number of addresses rejected as longer than Options.MaxAddrLen.
+	TooLongAddrs uint64
+
+	// Invalidations is the number of cached records dropped as changed by other processes sharing the datastore, see
+	// Options.ChangeNotifyInterval.
+	Invalidations uint64
+
+	// DeferredRefreshes is the number of address additions that only refreshed the TTLs of addresses already present,
+	// and were left for the next refresh flush to write; see Options.RefreshFlushInterval.
+	DeferredRefreshes uint64
+
+	// RefreshWrites is the number of records written by refresh flushes. The fewer they are relative to
+	// DeferredRefreshes, the more writes were saved.
+	RefreshWrites uint64
+
+	// Reconciliation holds the counters of the cache reconciliation policy, if set in Options.CacheReconciliation.
+	Reconciliation ReconcileStats
+
+	// Cache holds the counters of the cache admission policy, if set to CacheAdmissionTinyLFU in
+	// Options.CacheAdmission.
+	Cache CacheStats
+
+	// Budget holds the counters of the on-disk budget, if set in Options.MaxDiskBytes. The budget covers all books of
+	// a peerstore, so these are only maintained when the address book is part of one.
+	Budget BudgetStats
+
+	// Datastore holds the counters of the retry policy, if enabled in Options.Retry. The datastore is shared by all
+	// books of a peerstore, so these count the operations of all of them.
+	Datastore DatastoreStats
+}
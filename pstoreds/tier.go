@@ -0,0 +1,451 @@
+package pstoreds
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	base32 "github.com/multiformats/go-base32"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// tieredBases are the prefixes of the entries that belong to a single peer, moved between tiers as a whole:
+// /<base>/<b32 peer id no padding>[/...]. Group memberships, GC lookahead entries and the access log stay hot.
+var tieredBases = map[string]ds.Key{
+	addrBookBase.Name(): addrBookBase,
+	addrKeysBase.Name(): addrKeysBase,
+	kbBase.Name():       kbBase,
+	pmBase.Name():       pmBase,
+}
+
+// tieredPeer returns the encoded peer ID a key belongs to, if it is the entry of a single peer.
+func tieredPeer(k ds.Key) (string, bool) {
+	list := k.List()
+	if len(list) < 3 || list[0] != peersBase.Name() {
+		return "", false
+	}
+	if _, ok := tieredBases[list[1]]; !ok {
+		return "", false
+	}
+	return list[2], true
+}
+
+// tieredStore keeps the entries of the peers accessed recently in a hot datastore, and moves those of the peers left
+// unaccessed for a while to a cold one. Any access to a peer not known to be hot first moves its entries back from
+// the cold store, without overwriting those written to the hot store since. Queries spanning several peers, such as
+// listing peers or GC, only cover the hot store. They are iterated lazily, without holding off moves: the entries they
+// yield once a move happened are checked against the hot store first, see Query.
+type tieredStore struct {
+	ds.Batching // the hot store.
+
+	cold  ds.Batching
+	after time.Duration
+	clock pstore.Clock
+
+	// moves hold mu exclusively, other operations hold it shared.
+	mu sync.RWMutex
+	// number of moves so far, guarded by mu.
+	moves uint64
+
+	// last access of the peers whose entries are hot, by encoded peer ID.
+	seenMu sync.Mutex
+	seen   map[string]time.Time
+
+	cancel func()
+	done   chan struct{}
+}
+
+var _ ds.Batching = (*tieredStore)(nil)
+
+// newTieredStore layers cold under hot, seeding the peers known to be hot with those listed in hot. Peers left
+// unaccessed for after are moved to cold by sweeps, see start.
+func newTieredStore(hot, cold ds.Batching, after time.Duration, clock pstore.Clock) (*tieredStore, error) {
+	if clock == nil {
+		clock = pstore.RealClock{}
+	}
+	ts := &tieredStore{
+		Batching: hot,
+		cold:     cold,
+		after:    after,
+		clock:    clock,
+		seen:     make(map[string]time.Time),
+	}
+
+	now := clock.Now()
+	for _, base := range tieredBases {
+		results, err := hot.Query(query.Query{Prefix: base.String(), KeysOnly: true})
+		if err != nil {
+			return nil, err
+		}
+		for result := range results.Next() {
+			if result.Error != nil {
+				results.Close()
+				return nil, result.Error
+			}
+			if name, ok := tieredPeer(ds.RawKey(result.Key)); ok {
+				ts.seen[name] = now
+			}
+		}
+		results.Close()
+	}
+
+	return ts, nil
+}
+
+// start runs sweeps in the background every interval, until stop is called.
+func (ts *tieredStore) start(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ts.cancel, ts.done = cancel, make(chan struct{})
+	go ts.background(ctx, interval)
+}
+
+func (ts *tieredStore) background(ctx context.Context, interval time.Duration) {
+	defer close(ts.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ts.sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop stops the background sweeps, if started. It leaves both stores open.
+func (ts *tieredStore) stop() {
+	if ts.cancel == nil {
+		return
+	}
+	ts.cancel()
+	<-ts.done
+}
+
+// sweep moves the peers left unaccessed for longer than the configured duration to the cold store.
+func (ts *tieredStore) sweep() {
+	cutoff := ts.clock.Now().Add(-ts.after)
+	ts.seenMu.Lock()
+	var stale []string
+	for name, t := range ts.seen {
+		if t.Before(cutoff) {
+			stale = append(stale, name)
+		}
+	}
+	ts.seenMu.Unlock()
+
+	for _, name := range stale {
+		if err := ts.demote(name, cutoff); err != nil {
+			log.Warnf("failed to move peer %s to the cold store: %s", name, err)
+		}
+	}
+}
+
+// ensureHot records an access to a peer, moving its entries back from the cold store first if it isn't known to be
+// hot.
+func (ts *tieredStore) ensureHot(name string) {
+	now := ts.clock.Now()
+	ts.seenMu.Lock()
+	_, hot := ts.seen[name]
+	if hot {
+		ts.seen[name] = now
+	}
+	ts.seenMu.Unlock()
+	if hot {
+		return
+	}
+	if err := ts.promote(name, now); err != nil {
+		log.Warnf("failed to move peer %s back from the cold store: %s", name, err)
+	}
+}
+
+// promote moves the entries of a peer from the cold store to the hot one, keeping the hot entries written since.
+func (ts *tieredStore) promote(name string, now time.Time) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.seenMu.Lock()
+	_, hot := ts.seen[name]
+	ts.seen[name] = now
+	ts.seenMu.Unlock()
+	if hot {
+		// promoted concurrently.
+		return nil
+	}
+
+	entries, err := peerEntries(ts.cold, name)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	ts.moves++
+	err = moveEntries(ts.cold, ts.Batching, entries, func(k ds.Key) (bool, error) {
+		return ts.Batching.Has(k)
+	})
+	if err != nil {
+		// the peer may be partly moved; make sure the next access retries.
+		ts.seenMu.Lock()
+		delete(ts.seen, name)
+		ts.seenMu.Unlock()
+	}
+	return err
+}
+
+// demote moves the entries of a peer to the cold store, unless it was accessed since cutoff.
+func (ts *tieredStore) demote(name string, cutoff time.Time) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.seenMu.Lock()
+	t, hot := ts.seen[name]
+	if !hot || !t.Before(cutoff) {
+		ts.seenMu.Unlock()
+		return nil
+	}
+	delete(ts.seen, name)
+	ts.seenMu.Unlock()
+
+	entries, err := peerEntries(ts.Batching, name)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	ts.moves++
+	return moveEntries(ts.Batching, ts.cold, entries, nil)
+}
+
+// peerEntries returns the entries of a peer held by store.
+func peerEntries(store ds.Read, name string) ([]query.Entry, error) {
+	var entries []query.Entry
+	for _, base := range tieredBases {
+		k := base.ChildString(name)
+		// prefixes only match the keys below them.
+		if v, err := store.Get(k); err == nil {
+			entries = append(entries, query.Entry{Key: k.String(), Value: v})
+		} else if err != ds.ErrNotFound {
+			return nil, err
+		}
+		results, err := store.Query(query.Query{Prefix: k.String()})
+		if err != nil {
+			return nil, err
+		}
+		rest, err := results.Rest()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, rest...)
+	}
+	return entries, nil
+}
+
+// moveEntries writes entries to dst, skipping those for which skip returns true, then deletes them from src.
+func moveEntries(src, dst ds.Batching, entries []query.Entry, skip func(ds.Key) (bool, error)) error {
+	batch, err := dst.Batch()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		k := ds.RawKey(e.Key)
+		if skip != nil {
+			if ok, err := skip(k); err != nil {
+				return err
+			} else if ok {
+				continue
+			}
+		}
+		if err := batch.Put(k, e.Value); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+
+	if batch, err = src.Batch(); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := batch.Delete(ds.RawKey(e.Key)); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+func (ts *tieredStore) Get(k ds.Key) ([]byte, error) {
+	if name, ok := tieredPeer(k); ok {
+		ts.ensureHot(name)
+	}
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.Batching.Get(k)
+}
+
+func (ts *tieredStore) Has(k ds.Key) (bool, error) {
+	if name, ok := tieredPeer(k); ok {
+		ts.ensureHot(name)
+	}
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.Batching.Has(k)
+}
+
+func (ts *tieredStore) GetSize(k ds.Key) (int, error) {
+	if name, ok := tieredPeer(k); ok {
+		ts.ensureHot(name)
+	}
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.Batching.GetSize(k)
+}
+
+func (ts *tieredStore) Put(k ds.Key, v []byte) error {
+	if name, ok := tieredPeer(k); ok {
+		ts.ensureHot(name)
+	}
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.Batching.Put(k, v)
+}
+
+func (ts *tieredStore) Delete(k ds.Key) error {
+	if name, ok := tieredPeer(k); ok {
+		ts.ensureHot(name)
+	}
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.Batching.Delete(k)
+}
+
+// Query runs q over the hot store, moving the peer it is restricted to back from the cold store first, if any. Results
+// are iterated lazily, and moves may interleave with them: once one did, the entries of peers are looked up again in
+// the hot store before being yielded, so that those moved to the cold store are skipped rather than acted upon.
+func (ts *tieredStore) Query(q query.Query) (query.Results, error) {
+	if name, ok := tieredPeer(ds.NewKey(q.Prefix)); ok {
+		ts.ensureHot(name)
+	}
+	ts.mu.RLock()
+	moves := ts.moves
+	results, err := ts.Batching.Query(q)
+	ts.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			for {
+				r, ok := results.NextSync()
+				if !ok || r.Error != nil {
+					return r, ok
+				}
+				e, found, err := ts.recheck(r.Entry, moves, q.KeysOnly)
+				if err != nil {
+					return query.Result{Error: err}, true
+				}
+				if found {
+					return query.Result{Entry: e}, true
+				}
+			}
+		},
+		Close: results.Close,
+	}), nil
+}
+
+// recheck returns the current state of an entry yielded by a query of the hot store started after the given number of
+// moves, and false if the entry was moved to the cold store since.
+func (ts *tieredStore) recheck(e query.Entry, moves uint64, keysOnly bool) (query.Entry, bool, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	if ts.moves == moves {
+		return e, true, nil
+	}
+	k := ds.RawKey(e.Key)
+	if _, ok := tieredPeer(k); !ok {
+		// only the entries of single peers are moved.
+		return e, true, nil
+	}
+	if keysOnly {
+		found, err := ts.Batching.Has(k)
+		return e, found, err
+	}
+	v, err := ts.Batching.Get(k)
+	switch err {
+	case nil:
+		e.Value, e.Size = v, len(v)
+		return e, true, nil
+	case ds.ErrNotFound:
+		return e, false, nil
+	default:
+		return e, false, err
+	}
+}
+
+func (ts *tieredStore) Batch() (ds.Batch, error) {
+	b, err := ts.Batching.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &tieredBatch{Batch: b, ts: ts}, nil
+}
+
+// tieredBatch makes sure the peers written to are hot before their writes are buffered.
+type tieredBatch struct {
+	ds.Batch
+	ts *tieredStore
+}
+
+func (tb *tieredBatch) Put(k ds.Key, v []byte) error {
+	if name, ok := tieredPeer(k); ok {
+		tb.ts.ensureHot(name)
+	}
+	return tb.Batch.Put(k, v)
+}
+
+func (tb *tieredBatch) Delete(k ds.Key) error {
+	if name, ok := tieredPeer(k); ok {
+		tb.ts.ensureHot(name)
+	}
+	return tb.Batch.Delete(k)
+}
+
+func (tb *tieredBatch) Commit() error {
+	tb.ts.mu.RLock()
+	defer tb.ts.mu.RUnlock()
+	return tb.Batch.Commit()
+}
+
+// coldPeers returns the peers whose entries are in the cold store.
+func (ts *tieredStore) coldPeers(validate pstore.IDValidator) peer.IDSlice {
+	set := make(map[string]struct{})
+	for _, base := range tieredBases {
+		results, err := ts.cold.Query(query.Query{Prefix: base.String(), KeysOnly: true})
+		if err != nil {
+			log.Errorf("failed to list cold peers: %s", err)
+			return nil
+		}
+		for result := range results.Next() {
+			if result.Error != nil {
+				log.Errorf("failed to list cold peers: %s", result.Error)
+				break
+			}
+			if name, ok := tieredPeer(ds.RawKey(result.Key)); ok {
+				set[name] = struct{}{}
+			}
+		}
+		results.Close()
+	}
+
+	ids := make(peer.IDSlice, 0, len(set))
+	for name := range set {
+		b, err := base32.RawStdEncoding.DecodeString(name)
+		if err != nil {
+			continue
+		}
+		if id := peer.ID(b); validate(id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
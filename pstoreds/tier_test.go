@@ -0,0 +1,198 @@
+package pstoreds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestDsColdStorePeerstore(t *testing.T) {
+	pt.TestPeerstore(t, func() (pstore.Peerstore, func()) {
+		opts := DefaultOpts()
+		opts.ColdStore = dssync.MutexWrap(ds.NewMapDatastore())
+		opts.ColdAfter = time.Hour
+		ps, err := NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ps, func() { ps.Close() }
+	})
+}
+
+// countPeerEntries returns the number of entries of all books held by store.
+func countPeerEntries(t *testing.T, store ds.Datastore) int {
+	t.Helper()
+	results, err := store.Query(query.Query{Prefix: peersBase.String(), KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := results.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(rest)
+}
+
+func TestDsColdStore(t *testing.T) {
+	for _, layout := range []AddrLayout{AddrLayoutPerPeer, AddrLayoutPerAddr} {
+		hot := dssync.MutexWrap(ds.NewMapDatastore())
+		cold := dssync.MutexWrap(ds.NewMapDatastore())
+		clock := pt.NewMockClock()
+
+		opts := DefaultOpts()
+		opts.AddrLayout = layout
+		opts.CacheSize = 0
+		opts.Clock = clock
+		opts.ColdStore = cold
+		opts.ColdAfter = time.Hour
+		ps, err := NewPeerstore(context.Background(), hot, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := pt.GeneratePeerIDs(2)
+		addrs := pt.GenerateAddrs(2)
+		for _, id := range ids {
+			ps.AddAddrs(id, addrs, 24*time.Hour)
+			if err := ps.Put(id, "k", "v"); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.AddProtocols(id, "/proto/1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ps.AddToGroup(ids[0], "g"); err != nil {
+			t.Fatal(err)
+		}
+
+		// only peers left unaccessed are moved to the cold store.
+		clock.Add(45 * time.Minute)
+		ps.Addrs(ids[1])
+		clock.Add(30 * time.Minute)
+		ps.tier.sweep()
+		if cold := ps.ColdPeers(); len(cold) != 1 || cold[0] != ids[0] {
+			t.Fatalf("expected only the first peer to be cold, got %v", cold)
+		}
+		for _, p := range ps.Peers() {
+			if p == ids[0] {
+				t.Fatal("expected cold peer not to be listed")
+			}
+		}
+		// group memberships stay hot.
+		if members := ps.GroupMembers("g"); len(members) != 1 {
+			t.Fatalf("expected group membership to stay hot, got %v", members)
+		}
+
+		// writing to a cold peer moves it back first, keeping both the old and new entries.
+		if err := ps.Put(ids[0], "k2", "v2"); err != nil {
+			t.Fatal(err)
+		}
+		if len(ps.ColdPeers()) != 0 || countPeerEntries(t, cold) != 0 {
+			t.Fatal("expected the cold store to be emptied")
+		}
+		pt.AssertAddressesEqual(t, addrs, ps.Addrs(ids[0]))
+		if v, err := ps.Get(ids[0], "k"); err != nil || v != "v" {
+			t.Fatalf("expected metadata to be moved back, got %v (%v)", v, err)
+		}
+		if v, err := ps.Get(ids[0], "k2"); err != nil || v != "v2" {
+			t.Fatalf("expected metadata written while cold to be kept, got %v (%v)", v, err)
+		}
+
+		// reading a cold peer moves it back too, including after a restart.
+		clock.Add(2 * time.Hour)
+		ps.tier.sweep()
+		if n := len(ps.ColdPeers()); n != 2 {
+			t.Fatalf("expected both peers to be cold, got %d", n)
+		}
+		hotEntries := countPeerEntries(t, hot)
+		ps.Close()
+
+		if ps, err = NewPeerstore(context.Background(), hot, opts); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(ps.ColdPeers()); n != 2 {
+			t.Fatalf("expected both peers to stay cold across restarts, got %d", n)
+		}
+		if protos, err := ps.GetProtocols(ids[1]); err != nil || len(protos) != 1 {
+			t.Fatalf("expected protocols to be moved back, got %v (%v)", protos, err)
+		}
+		if countPeerEntries(t, hot) <= hotEntries {
+			t.Fatal("expected the entries of the peer to be moved back to the hot store")
+		}
+		if cold := ps.ColdPeers(); len(cold) != 1 || cold[0] != ids[0] {
+			t.Fatalf("expected the first peer to stay cold, got %v", cold)
+		}
+
+		// clones hold cold peers as well, in their own store.
+		clone, err := ps.CloneTo(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pt.AssertAddressesEqual(t, addrs, clone.Addrs(ids[0]))
+		if len(ps.ColdPeers()) != 1 {
+			t.Fatal("expected the clone not to move entries out of the original")
+		}
+		clone.Close()
+		ps.Close()
+	}
+}
+
+func TestDsColdStoreOptions(t *testing.T) {
+	opts := DefaultOpts()
+	opts.ColdStore = dssync.MutexWrap(ds.NewMapDatastore())
+	if _, err := NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts); err == nil {
+		t.Fatal("expected an error for a cold store without a cold-after duration")
+	}
+}
+
+func TestDsColdStoreLazyQuery(t *testing.T) {
+	hot := dssync.MutexWrap(ds.NewMapDatastore())
+	clock := pt.NewMockClock()
+	ts, err := newTieredStore(hot, dssync.MutexWrap(ds.NewMapDatastore()), time.Hour, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"aaaa", "bbbb", "cccc"}
+	for _, name := range names {
+		if err := ts.Put(pmBase.ChildString(name).ChildString("k"), []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := ts.Query(query.Query{Prefix: pmBase.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer results.Close()
+	first, ok := results.NextSync()
+	if !ok || first.Error != nil {
+		t.Fatalf("expected a first entry, got %v", first.Error)
+	}
+
+	// moves aren't held off by open queries, and the entries moved since are skipped.
+	clock.Add(2 * time.Hour)
+	done := make(chan struct{})
+	go func() {
+		ts.sweep()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the sweep not to wait for the query")
+	}
+	rest, err := results.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Fatalf("expected the entries moved to the cold store to be skipped, got %v", rest)
+	}
+}
@@ -0,0 +1,217 @@
+package pstoreds
+
+import (
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// kinds of the datastore operations timed when Options.BackendTimings is set.
+const (
+	timedGet = iota
+	timedPut
+	timedDelete
+	timedQuery
+	timedCommit
+	timedSync
+
+	timedOpMax
+)
+
+var timedOpNames = [timedOpMax]string{
+	timedGet:    "datastore get",
+	timedPut:    "datastore put",
+	timedDelete: "datastore delete",
+	timedQuery:  "datastore query",
+	timedCommit: "datastore commit",
+	timedSync:   "datastore sync",
+}
+
+// storeTimings counts the datastore operations of every kind, and the time spent in them.
+type storeTimings struct {
+	// accessed atomically; keep first for 64-bit alignment.
+	counts [timedOpMax]uint64
+	nanos  [timedOpMax]int64
+}
+
+// since accounts for an operation of kind op started at start. It is meant to be deferred.
+func (st *storeTimings) since(op int, start time.Time) {
+	atomic.AddUint64(&st.counts[op], 1)
+	atomic.AddInt64(&st.nanos[op], int64(time.Since(start)))
+}
+
+func (st *storeTimings) snapshot() []pstore.BackendTiming {
+	out := make([]pstore.BackendTiming, timedOpMax)
+	for op := range out {
+		out[op] = pstore.BackendTiming{
+			Op:       timedOpNames[op],
+			Count:    atomic.LoadUint64(&st.counts[op]),
+			Duration: time.Duration(atomic.LoadInt64(&st.nanos[op])),
+		}
+	}
+	return out
+}
+
+// timedStore wraps a datastore, timing its operations. Queries are timed until they return their results, not
+// while the results are iterated.
+type timedStore struct {
+	child   ds.Batching
+	timings *storeTimings
+}
+
+// timedTxnStore is a timedStore over a datastore supporting transactions, so that timing doesn't disable them.
+type timedTxnStore struct {
+	*timedStore
+}
+
+var (
+	_ ds.Batching     = (*timedStore)(nil)
+	_ ds.TxnDatastore = (*timedTxnStore)(nil)
+)
+
+// timeStore wraps store in a timedStore if Options.BackendTimings is set in opts.
+func timeStore(store ds.Batching, opts Options) ds.Batching {
+	if !opts.BackendTimings {
+		return store
+	}
+	ts := &timedStore{child: store, timings: new(storeTimings)}
+	if _, ok := store.(ds.TxnDatastore); ok {
+		return &timedTxnStore{ts}
+	}
+	return ts
+}
+
+// timingsOf returns the timings of store, nil if it isn't timed.
+func timingsOf(store ds.Datastore) *storeTimings {
+	if rs, ok := store.(*retryStore); ok {
+		store = rs.child
+	}
+	switch s := store.(type) {
+	case *timedStore:
+		return s.timings
+	case *timedTxnStore:
+		return s.timings
+	}
+	return nil
+}
+
+func (s *timedStore) Get(key ds.Key) (value []byte, err error) {
+	defer s.timings.since(timedGet, time.Now())
+	return s.child.Get(key)
+}
+
+func (s *timedStore) Has(key ds.Key) (exists bool, err error) {
+	defer s.timings.since(timedGet, time.Now())
+	return s.child.Has(key)
+}
+
+func (s *timedStore) GetSize(key ds.Key) (size int, err error) {
+	defer s.timings.since(timedGet, time.Now())
+	return s.child.GetSize(key)
+}
+
+func (s *timedStore) Put(key ds.Key, value []byte) error {
+	defer s.timings.since(timedPut, time.Now())
+	return s.child.Put(key, value)
+}
+
+func (s *timedStore) Delete(key ds.Key) error {
+	defer s.timings.since(timedDelete, time.Now())
+	return s.child.Delete(key)
+}
+
+func (s *timedStore) Query(q query.Query) (query.Results, error) {
+	defer s.timings.since(timedQuery, time.Now())
+	return s.child.Query(q)
+}
+
+func (s *timedStore) Sync(prefix ds.Key) error {
+	defer s.timings.since(timedSync, time.Now())
+	return s.child.Sync(prefix)
+}
+
+func (s *timedStore) Close() error {
+	return s.child.Close()
+}
+
+func (s *timedStore) Batch() (ds.Batch, error) {
+	b, err := s.child.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &timedBatch{Batch: b, timings: s.timings}, nil
+}
+
+func (s *timedTxnStore) NewTransaction(readOnly bool) (ds.Txn, error) {
+	txn, err := s.child.(ds.TxnDatastore).NewTransaction(readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &timedTxn{Txn: txn, timings: s.timings}, nil
+}
+
+// timedBatch times the commit of a batch; puts and deletes are only buffered until then.
+type timedBatch struct {
+	ds.Batch
+	timings *storeTimings
+}
+
+func (b *timedBatch) Commit() error {
+	defer b.timings.since(timedCommit, time.Now())
+	return b.Batch.Commit()
+}
+
+// timedTxn times the operations of a transaction like those of the datastore.
+type timedTxn struct {
+	ds.Txn
+	timings *storeTimings
+}
+
+func (t *timedTxn) Get(key ds.Key) (value []byte, err error) {
+	defer t.timings.since(timedGet, time.Now())
+	return t.Txn.Get(key)
+}
+
+func (t *timedTxn) Has(key ds.Key) (exists bool, err error) {
+	defer t.timings.since(timedGet, time.Now())
+	return t.Txn.Has(key)
+}
+
+func (t *timedTxn) GetSize(key ds.Key) (size int, err error) {
+	defer t.timings.since(timedGet, time.Now())
+	return t.Txn.GetSize(key)
+}
+
+func (t *timedTxn) Put(key ds.Key, value []byte) error {
+	defer t.timings.since(timedPut, time.Now())
+	return t.Txn.Put(key, value)
+}
+
+func (t *timedTxn) Delete(key ds.Key) error {
+	defer t.timings.since(timedDelete, time.Now())
+	return t.Txn.Delete(key)
+}
+
+func (t *timedTxn) Query(q query.Query) (query.Results, error) {
+	defer t.timings.since(timedQuery, time.Now())
+	return t.Txn.Query(q)
+}
+
+func (t *timedTxn) Commit() error {
+	defer t.timings.since(timedCommit, time.Now())
+	return t.Txn.Commit()
+}
+
+// BackendTimings returns the count and cumulative duration of the datastore operations of the address book by kind,
+// when Options.BackendTimings is set, and nil otherwise. The books of a peerstore share their datastore, so that
+// those of a peerstore cover all books.
+func (ab *dsAddrBook) BackendTimings() []pstore.BackendTiming {
+	if st := timingsOf(ab.ds); st != nil {
+		return st.snapshot()
+	}
+	return nil
+}
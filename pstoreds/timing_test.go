@@ -0,0 +1,73 @@
+package pstoreds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestBackendTimings(t *testing.T) {
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+	opts.CacheSize = 0
+	opts.BackendTimings = true
+
+	ps, err := NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	count := func(op string) uint64 {
+		t.Helper()
+		for _, b := range ps.BackendTimings() {
+			if b.Op == op {
+				return b.Count
+			}
+		}
+		t.Fatalf("no timing for %s", op)
+		return 0
+	}
+
+	id := test.GeneratePeerIDs(1)[0]
+	ps.AddAddrs(id, test.GenerateAddrs(1), time.Hour)
+	gets, puts := count("datastore get"), count("datastore put")
+	if puts == 0 {
+		t.Fatal("expected the address record write to be timed")
+	}
+	ps.Addrs(id)
+	if n := count("datastore get"); n <= gets {
+		t.Fatal("expected the uncached read to be timed")
+	}
+
+	// slow calls are broken down by the operations they ran.
+	var calls []pstore.SlowCall
+	sp := pstore.NewSlowCallPeerstore(ps, -1, pstore.SlowCallLoggerFunc(func(c pstore.SlowCall) {
+		calls = append(calls, c)
+	}))
+	sp.Addrs(id)
+	if len(calls) != 1 || len(calls[0].Backend) != 1 || calls[0].Backend[0].Op != "datastore get" {
+		t.Fatalf("expected a call broken down into datastore reads, got %v", calls)
+	}
+}
+
+func TestBackendTimingsDisabled(t *testing.T) {
+	opts := DefaultOpts()
+	opts.GCPurgeInterval = 0
+
+	ps, err := NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	if timings := ps.BackendTimings(); timings != nil {
+		t.Fatalf("expected no timings when disabled, got %v", timings)
+	}
+}
@@ -0,0 +1,256 @@
+package pstoreds
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// CacheAdmission is the policy deciding which records enter the address book cache once it is full.
+type CacheAdmission int
+
+const (
+	// CacheAdmissionAlways admits every record read from the datastore into the cache, an adaptive replacement cache
+	// (ARC).
+	CacheAdmissionAlways CacheAdmission = iota
+	// CacheAdmissionTinyLFU admits a record into a full cache, an LRU, only if its peer has been accessed more
+	// frequently than the peer it would evict. Access frequencies are estimated by an approximate counting sketch,
+	// aged periodically. This keeps peers seen once, e.g. during DHT traversals, from evicting frequently accessed ones.
+	CacheAdmissionTinyLFU
+)
+
+func (a CacheAdmission) String() string {
+	switch a {
+	case CacheAdmissionAlways:
+		return "always"
+	case CacheAdmissionTinyLFU:
+		return "tinylfu"
+	default:
+		return fmt.Sprintf("CacheAdmission(%d)", int(a))
+	}
+}
+
+// CacheStats holds the counters of the cache admission policy. They are only maintained by CacheAdmissionTinyLFU.
+type CacheStats struct {
+	// Admissions is the number of records added to the cache.
+	Admissions uint64
+	// Rejections is the number of records kept out of the cache by the admission policy.
+	Rejections uint64
+	// Evictions is the number of records evicted from the cache to admit others.
+	Evictions uint64
+}
+
+const (
+	// number of rows of the count-min sketch.
+	sketchDepth = 4
+	// counters saturate at this value.
+	sketchMaxCount = 15
+	// number of counters per row for each entry of the cache.
+	sketchCountersPerEntry = 4
+	// number of doorkeeper bits for each access of the sample period.
+	sketchDoorkeeperBits = 8
+	// the sketch is aged once it has recorded this many accesses per entry of the cache.
+	sketchSampleFactor = 10
+)
+
+// frequencySketch is a count-min sketch estimating how often keys are accessed, fronted by a doorkeeper bloom filter
+// so that keys seen once don't take up counters. Counts are halved every sample period, so that estimates reflect
+// recent accesses. Not safe for concurrent use.
+type frequencySketch struct {
+	rows       [sketchDepth][]uint8
+	doorkeeper []uint64
+	mask       uint64 // of counter indexes; doorkeeper indexes span the full hash.
+	additions  int
+	sampleSize int
+}
+
+func newFrequencySketch(capacity int) *frequencySketch {
+	width := 64
+	for width < sketchCountersPerEntry*capacity {
+		width <<= 1
+	}
+	sampleSize := sketchSampleFactor * capacity
+	s := &frequencySketch{
+		doorkeeper: make([]uint64, sketchDoorkeeperBits*sampleSize/64+1),
+		mask:       uint64(width - 1),
+		sampleSize: sampleSize,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// indexes derives sketchDepth indexes from a hash, by double hashing. They are masked by the caller.
+func indexes(h uint64) (idx [sketchDepth]uint64) {
+	h1, h2 := h, h>>32|h<<32
+	for i := range idx {
+		idx[i] = h1 + uint64(i)*h2
+	}
+	return idx
+}
+
+func (s *frequencySketch) doorkeeperBit(i uint64) (word int, bit uint64) {
+	i %= uint64(len(s.doorkeeper) * 64)
+	return int(i / 64), 1 << (i % 64)
+}
+
+func (s *frequencySketch) inDoorkeeper(idx [sketchDepth]uint64) bool {
+	for _, i := range idx {
+		if w, b := s.doorkeeperBit(i); s.doorkeeper[w]&b == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// increment records an access to the key hashed to h.
+func (s *frequencySketch) increment(h uint64) {
+	idx := indexes(h)
+	if !s.inDoorkeeper(idx) {
+		for _, i := range idx {
+			w, b := s.doorkeeperBit(i)
+			s.doorkeeper[w] |= b
+		}
+	} else {
+		for row, i := range idx {
+			if c := &s.rows[row][i&s.mask]; *c < sketchMaxCount {
+				*c++
+			}
+		}
+	}
+
+	if s.additions++; s.additions >= s.sampleSize {
+		s.age()
+	}
+}
+
+// estimate returns the estimated number of accesses to the key hashed to h.
+func (s *frequencySketch) estimate(h uint64) int {
+	idx := indexes(h)
+	min := uint8(sketchMaxCount)
+	for row, i := range idx {
+		if c := s.rows[row][i&s.mask]; c < min {
+			min = c
+		}
+	}
+	if s.inDoorkeeper(idx) {
+		return int(min) + 1
+	}
+	return int(min)
+}
+
+// age halves all counters and clears the doorkeeper.
+func (s *frequencySketch) age() {
+	for _, row := range s.rows {
+		for i := range row {
+			row[i] >>= 1
+		}
+	}
+	for i := range s.doorkeeper {
+		s.doorkeeper[i] = 0
+	}
+	s.additions = 0
+}
+
+// tinyLFUCache is an LRU cache fronted by the TinyLFU admission policy, see CacheAdmissionTinyLFU.
+type tinyLFUCache struct {
+	mu     sync.Mutex
+	size   int
+	lru    *simplelru.LRU
+	sketch *frequencySketch
+
+	admissions, rejections, evictions uint64
+}
+
+var _ cache = (*tinyLFUCache)(nil)
+
+func newTinyLFUCache(size int) (*tinyLFUCache, error) {
+	lru, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tinyLFUCache{size: size, lru: lru, sketch: newFrequencySketch(size)}, nil
+}
+
+func hashKey(key interface{}) uint64 {
+	h := fnv.New64a()
+	switch k := key.(type) {
+	case peer.ID:
+		_, _ = h.Write([]byte(k))
+	case string:
+		_, _ = h.Write([]byte(k))
+	default:
+		_, _ = fmt.Fprint(h, k)
+	}
+	return h.Sum64()
+}
+
+// Get returns the value of a key, recording the access whether it hits or not.
+func (c *tinyLFUCache) Get(key interface{}) (value interface{}, ok bool) {
+	h := hashKey(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sketch.increment(h)
+	return c.lru.Get(key)
+}
+
+// Add adds a value to the cache, unless the cache is full and the key is accessed less frequently than the least
+// recently used key, which would be evicted.
+func (c *tinyLFUCache) Add(key, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var victim interface{}
+	if !c.lru.Contains(key) {
+		if oldest, _, ok := c.lru.GetOldest(); ok && c.lru.Len() >= c.size {
+			victim = oldest
+			if c.sketch.estimate(hashKey(key)) <= c.sketch.estimate(hashKey(victim)) {
+				atomic.AddUint64(&c.rejections, 1)
+				log.Debugf("cache rejected the record of peer %s, accessed less often than that of peer %s", key, victim)
+				return
+			}
+		}
+		atomic.AddUint64(&c.admissions, 1)
+	}
+	if c.lru.Add(key, value) {
+		atomic.AddUint64(&c.evictions, 1)
+		log.Debugf("cache evicted the record of peer %s to admit that of peer %s", victim, key)
+	}
+}
+
+func (c *tinyLFUCache) Remove(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Remove(key)
+}
+
+func (c *tinyLFUCache) Contains(key interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Contains(key)
+}
+
+func (c *tinyLFUCache) Peek(key interface{}) (value interface{}, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Peek(key)
+}
+
+func (c *tinyLFUCache) Keys() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Keys()
+}
+
+func (c *tinyLFUCache) stats() CacheStats {
+	return CacheStats{
+		Admissions: atomic.LoadUint64(&c.admissions),
+		Rejections: atomic.LoadUint64(&c.rejections),
+		Evictions:  atomic.LoadUint64(&c.evictions),
+	}
+}
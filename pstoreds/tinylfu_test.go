@@ -0,0 +1,75 @@
+package pstoreds
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestTinyLFUScanResistance(t *testing.T) {
+	c, err := newTinyLFUCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// read-through access, as done by loadRecord.
+	access := func(p peer.ID) {
+		if _, ok := c.Get(p); !ok {
+			c.Add(p, struct{}{})
+		}
+	}
+
+	hot := test.GeneratePeerIDs(100)
+	for i := 0; i < 3; i++ {
+		for _, p := range hot {
+			access(p)
+		}
+	}
+
+	// a traversal touching many peers once each.
+	for _, p := range test.GeneratePeerIDs(500) {
+		access(p)
+	}
+
+	for _, p := range hot {
+		if !c.Contains(p) {
+			t.Errorf("expected hot peer %s to survive the scan", p)
+		}
+	}
+	stats := c.stats()
+	if stats.Admissions != 100 || stats.Rejections != 500 || stats.Evictions != 0 {
+		t.Errorf("expected 100 admissions, 500 rejections and no evictions, got %+v", stats)
+	}
+
+	// peers accessed more often than the least recently used one displace it.
+	newcomer := test.GeneratePeerIDs(1)[0]
+	for i := 0; i < 5; i++ {
+		access(newcomer)
+	}
+	if !c.Contains(newcomer) {
+		t.Fatal("expected frequently accessed peer to be admitted")
+	}
+	if stats := c.stats(); stats.Evictions != 1 {
+		t.Errorf("expected 1 eviction, got %d", stats.Evictions)
+	}
+}
+
+func TestFrequencySketchAging(t *testing.T) {
+	s := newFrequencySketch(4)
+	h := hashKey(peer.ID("peer"))
+	for i := 0; i < 8; i++ {
+		s.increment(h)
+	}
+	if n := s.estimate(h); n != 8 {
+		t.Fatalf("expected an estimate of 8, got %d", n)
+	}
+
+	// the sample period is 40 accesses; fill it up with other keys.
+	for i := 0; s.additions != 0; i++ {
+		s.increment(hashKey(peer.ID(rune(i))))
+	}
+	if n := s.estimate(h); n > 4 {
+		t.Fatalf("expected the estimate to be halved after aging, got %d", n)
+	}
+}
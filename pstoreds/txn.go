@@ -0,0 +1,89 @@
+package pstoreds
+
+import (
+	ds "github.com/ipfs/go-datastore"
+)
+
+// WriteMode is how a peerstore applies the operations spanning several datastore keys, such as removing a peer.
+type WriteMode int
+
+const (
+	// WriteBatched applies multi-key operations in batches, on a best-effort basis: if the datastore fails midway,
+	// they may be left partially applied. This is the mode for datastores that don't support transactions.
+	WriteBatched WriteMode = iota
+	// WriteTransactional applies multi-key operations in datastore transactions, entirely or not at all.
+	WriteTransactional
+)
+
+func (m WriteMode) String() string {
+	if m == WriteTransactional {
+		return "transactional"
+	}
+	return "batched"
+}
+
+// Capabilities describes how a peerstore makes use of its datastore.
+type Capabilities struct {
+	// WriteMode is how operations spanning several keys are applied. With AddrLayoutPerPeer, the addresses of a peer
+	// are held in a single record, so address book writes are atomic in either mode; with AddrLayoutPerAddr, they
+	// are applied in this mode too.
+	WriteMode WriteMode
+}
+
+// Capabilities returns how the peerstore makes use of its datastore. Transactions are used when the datastore
+// implements ds.TxnDatastore, unless the peerstore is namespaced or has a retry policy, as neither supports them.
+func (ps *pstoreds) Capabilities() Capabilities {
+	return Capabilities{WriteMode: writeMode(ps.dsKeyBook.ds)}
+}
+
+// writeMode returns the mode multi-key operations are applied in over store.
+func writeMode(store ds.Datastore) WriteMode {
+	if _, ok := store.(ds.TxnDatastore); ok {
+		return WriteTransactional
+	}
+	return WriteBatched
+}
+
+// multiRead calls fn to read several keys from store consistently: in a read-only transaction if store supports them,
+// directly otherwise.
+func multiRead(store ds.Datastore, fn func(r ds.Read) error) error {
+	s, ok := store.(ds.TxnDatastore)
+	if !ok {
+		return fn(store)
+	}
+	txn, err := s.NewTransaction(true)
+	if err != nil {
+		return err
+	}
+	defer txn.Discard()
+	return fn(txn)
+}
+
+// multiWrite calls fn to apply an operation spanning several keys to store: in a transaction if store supports them,
+// in a batch otherwise. fn reads through r, which is the transaction itself when there is one, so that reads are
+// consistent with the writes.
+func multiWrite(store ds.Datastore, fn func(r ds.Read, w ds.Write) error) error {
+	switch s := store.(type) {
+	case ds.TxnDatastore:
+		txn, err := s.NewTransaction(false)
+		if err != nil {
+			return err
+		}
+		defer txn.Discard()
+		if err := fn(txn, txn); err != nil {
+			return err
+		}
+		return txn.Commit()
+	case ds.Batching:
+		batch, err := s.Batch()
+		if err != nil {
+			return err
+		}
+		if err := fn(s, batch); err != nil {
+			return err
+		}
+		return batch.Commit()
+	default:
+		return fn(store, store)
+	}
+}
@@ -0,0 +1,142 @@
+package pstoreds
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	coretest "github.com/libp2p/go-libp2p-core/test"
+
+	test "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+// commitFailStore is a transactional datastore whose transactions fail to commit while *fail is non-zero.
+type commitFailStore struct {
+	ds.Batching
+	txn  ds.TxnDatastore
+	fail *int32
+}
+
+func (s *commitFailStore) NewTransaction(readOnly bool) (ds.Txn, error) {
+	txn, err := s.txn.NewTransaction(readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &commitFailTxn{Txn: txn, fail: s.fail}, nil
+}
+
+type commitFailTxn struct {
+	ds.Txn
+	fail *int32
+}
+
+func (t *commitFailTxn) Commit() error {
+	if atomic.LoadInt32(t.fail) != 0 {
+		return errors.New("commit failed")
+	}
+	return t.Txn.Commit()
+}
+
+func TestCapabilities(t *testing.T) {
+	badgerDs, closeFunc := badgerStore(t)
+	defer closeFunc()
+
+	namespaced := DefaultOpts()
+	namespaced.Namespace = ds.NewKey("/ns")
+	timed := DefaultOpts()
+	timed.BackendTimings = true
+
+	cases := []struct {
+		name  string
+		store ds.Batching
+		opts  Options
+		want  WriteMode
+	}{
+		{"Map", dssync.MutexWrap(ds.NewMapDatastore()), DefaultOpts(), WriteBatched},
+		{"Badger", badgerDs, DefaultOpts(), WriteTransactional},
+		{"BadgerNamespaced", badgerDs, namespaced, WriteBatched},
+		{"BadgerTimed", badgerDs, timed, WriteTransactional},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ps, err := NewPeerstore(context.Background(), c.store, c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ps.Close()
+			if m := ps.Capabilities().WriteMode; m != c.want {
+				t.Fatalf("expected write mode %s, got %s", c.want, m)
+			}
+		})
+	}
+}
+
+func TestTransactionalRemovePeer(t *testing.T) {
+	for _, zeroize := range []bool{false, true} {
+		name := "NoZeroize"
+		if zeroize {
+			name = "Zeroize"
+		}
+		t.Run(name, func(t *testing.T) { testTransactionalRemovePeer(t, zeroize) })
+	}
+}
+
+func testTransactionalRemovePeer(t *testing.T, zeroize bool) {
+	badgerDs, closeFunc := badgerStore(t)
+	defer closeFunc()
+	var fail int32 = 1
+	store := &commitFailStore{Batching: badgerDs, txn: badgerDs.(ds.TxnDatastore), fail: &fail}
+
+	opts := DefaultOpts()
+	opts.ZeroizeOnRemove = zeroize
+	ps, err := NewPeerstore(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	sk, _, err := coretest.RandTestKeyPair(ic.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := test.GenerateAddrs(2)
+	if err := ps.AddPrivKey(p, sk); err != nil {
+		t.Fatal(err)
+	}
+	ps.AddAddrs(p, addrs, time.Hour)
+	if err := ps.Put(p, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed transaction leaves everything in place.
+	ps.RemovePeer(p)
+	if len(ps.PeersWithKeys()) != 1 {
+		t.Fatal("expected the keys to survive a failed removal")
+	}
+	if priv := ps.PrivKey(p); priv == nil || !priv.Equals(sk) {
+		t.Fatal("expected the private key to survive a failed removal intact")
+	}
+	test.AssertAddressesEqual(t, addrs, ps.Addrs(p))
+	if _, err := ps.Get(p, "key"); err != nil {
+		t.Fatalf("expected the metadata to survive a failed removal, got %s", err)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	ps.RemovePeer(p)
+	if len(ps.PeersWithKeys()) != 0 || len(ps.Addrs(p)) != 0 {
+		t.Fatal("expected the keys and addresses to be removed")
+	}
+	if _, err := ps.Get(p, "key"); err == nil {
+		t.Fatal("expected the metadata to be removed")
+	}
+}
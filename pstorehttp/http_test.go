@@ -0,0 +1,133 @@
+package pstorehttp
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+// remoteFactory serves an in-memory peerstore wired to deps, and returns a client for it.
+func remoteFactory(deps *pt.Deps) (*pstorehttp, func()) {
+	c := deps.Config
+	opts := []pstoremem.Option{
+		pstoremem.WithTTLJitter(c.TTLJitter),
+		pstoremem.WithMaxAddrTTL(c.MaxAddrTTL),
+		pstoremem.WithMaxAddrLen(c.MaxAddrLen),
+		pstoremem.WithAddrOrder(c.AddrOrder),
+		pstoremem.WithAddrHistory(c.AddrHistory),
+		pstoremem.WithExoticAddrPolicy(c.ExoticAddrPolicy),
+		pstoremem.WithP2PAddrPolicy(c.P2PAddrPolicy),
+		pstoremem.WithAuditSink(c.AuditSink),
+		pstoremem.WithChangeLog(c.ChangeLog),
+	}
+	if deps.Clock != nil {
+		opts = append(opts, pstoremem.WithClock(deps.Clock))
+	}
+	if c.TTLPolicy != nil {
+		opts = append(opts, pstoremem.WithTTLPolicy(c.TTLPolicy))
+	}
+	if c.IDValidator != nil {
+		opts = append(opts, pstoremem.WithIDValidator(c.IDValidator))
+	}
+	if c.AddrAliases {
+		opts = append(opts, pstoremem.WithAddrAliases())
+	}
+	local := pstoremem.NewPeerstore(opts...)
+	srv := httptest.NewServer(NewServer(local, WithPrivateKeys()))
+	ps := NewPeerstore(srv.URL, srv.Client())
+	return ps, func() {
+		ps.Close()
+		srv.Close()
+		local.Close()
+	}
+}
+
+func TestHTTPPeerstore(t *testing.T) {
+	pt.TestPeerstoreWithDeps(t, func(deps *pt.Deps) (pstore.Peerstore, func()) {
+		return remoteFactory(deps)
+	})
+}
+
+func TestHTTPAddrBook(t *testing.T) {
+	pt.TestAddrBookWithDeps(t, func(deps *pt.Deps) (pstore.AddrBook, func()) {
+		return remoteFactory(deps)
+	})
+}
+
+func TestHTTPKeyBook(t *testing.T) {
+	pt.TestKeyBookWithDeps(t, func(deps *pt.Deps) (pstore.KeyBook, func()) {
+		return remoteFactory(deps)
+	})
+}
+
+func TestUnknownMethod(t *testing.T) {
+	ps, closer := remoteFactory(&pt.Deps{})
+	defer closer()
+
+	if _, err := ps.call("Nope", &request{}); err == nil {
+		t.Fatal("expected an error calling an unknown method")
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	local := pstoremem.NewPeerstore()
+	defer local.Close()
+	deny := WithAuthorizer(func(r *http.Request, method string) error {
+		if method == "RemovePeer" {
+			return errors.New("denied")
+		}
+		return nil
+	})
+	srv := httptest.NewServer(NewServer(local, WithMaxRequestSize(64), deny))
+	defer srv.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"Valid", http.MethodPost, "/Peers", "{}", http.StatusOK},
+		{"NotPost", http.MethodGet, "/Peers", "", http.StatusMethodNotAllowed},
+		{"MalformedJSON", http.MethodPost, "/Peers", "{", http.StatusBadRequest},
+		{"WrongTypes", http.MethodPost, "/Peers", `{"TTL": "soon"}`, http.StatusBadRequest},
+		{"Oversized", http.MethodPost, "/Peers", `{"Key": "` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge},
+		{"UnknownMethod", http.MethodPost, "/Nope", "{}", http.StatusNotFound},
+		{"PrivateKeys", http.MethodPost, "/PrivKey", "{}", http.StatusForbidden},
+		{"Unauthorized", http.MethodPost, "/RemovePeer", "{}", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, srv.URL+c.path, bytes.NewBufferString(c.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != c.status {
+				t.Fatalf("expected status %d, got %d", c.status, resp.StatusCode)
+			}
+		})
+	}
+
+	// errors of the peerstore are returned in the response, and sentinels are recognised by the client.
+	ps := NewPeerstore(srv.URL, srv.Client())
+	defer ps.Close()
+	if _, err := ps.PubKeyE(pt.GeneratePeerIDs(1)[0]); err != pstore.ErrNotFound {
+		t.Fatalf("expected %s, got %v", pstore.ErrNotFound, err)
+	}
+	if _, err := ps.PrivKeyE(pt.GeneratePeerIDs(1)[0]); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected private keys not to be served, got %v", err)
+	}
+}
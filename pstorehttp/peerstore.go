@@ -0,0 +1,448 @@
+package pstorehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// pstorehttp is a peerstore backed by a Server in another process.
+type pstorehttp struct {
+	url    string
+	client *http.Client
+}
+
+var (
+	_ peerstore.Peerstore         = (*pstorehttp)(nil)
+	_ peerstore.CertifiedAddrBook = (*pstorehttp)(nil)
+	_ pstore.ExpiringAddrBook     = (*pstorehttp)(nil)
+	_ pstore.AddrBookE            = (*pstorehttp)(nil)
+	_ pstore.KeyBookE             = (*pstorehttp)(nil)
+	_ pstore.PeerRemover          = (*pstorehttp)(nil)
+	_ pstore.PeerProtector        = (*pstorehttp)(nil)
+)
+
+// NewPeerstore creates a peerstore backed by the Server at url, e.g. "http://127.0.0.1:4001/peerstore", so that
+// lightweight processes can share the peer knowledge of a node. Calls are made with client, or with
+// http.DefaultClient if nil.
+//
+// The methods of the peerstore interfaces that can't return errors log them. Metadata values are gob-encoded like in
+// pstoreds, so the types stored must be registered with gob, in both processes.
+func NewPeerstore(url string, client *http.Client) *pstorehttp {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &pstorehttp{url: strings.TrimSuffix(url, "/"), client: client}
+}
+
+func (ps *pstorehttp) call(method string, req *request) (*response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ps.client.Post(ps.url+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(r.Body)
+		return nil, fmt.Errorf("%s failed with status %d: %s", method, r.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var resp response
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, decodeError(resp.Error)
+}
+
+func (ps *pstorehttp) callPeers(method string) peer.IDSlice {
+	resp, err := ps.call(method, &request{})
+	if err != nil {
+		log.Errorf("failed to call %s: %s", method, err)
+		return peer.IDSlice{}
+	}
+	return decodePeers(resp.Peers)
+}
+
+// Close releases idle connections to the server. The remote peerstore is left open.
+func (ps *pstorehttp) Close() error {
+	if t, ok := ps.client.Transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+	return nil
+}
+
+func (ps *pstorehttp) Peers() peer.IDSlice {
+	return ps.callPeers("Peers")
+}
+
+func (ps *pstorehttp) PeerInfo(p peer.ID) peer.AddrInfo {
+	return peer.AddrInfo{ID: p, Addrs: ps.Addrs(p)}
+}
+
+// RemovePeer removes all the data held about a peer, if the remote peerstore supports it.
+func (ps *pstorehttp) RemovePeer(p peer.ID) {
+	if _, err := ps.call("RemovePeer", &request{Peer: []byte(p)}); err != nil {
+		log.Errorf("failed to remove peer %s: %s", p.Pretty(), err)
+	}
+}
+
+// AddrBook
+
+func (ps *pstorehttp) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.AddAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+func (ps *pstorehttp) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	if err := ps.AddAddrsE(p, addrs, ttl); err != nil {
+		log.Errorf("failed to add addresses for peer %s: %s", p.Pretty(), err)
+	}
+}
+
+// AddAddrsE is like AddAddrs, but returns the error of the call, if any.
+func (ps *pstorehttp) AddAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	_, err := ps.call("AddAddrs", &request{Peer: []byte(p), Addrs: encodeAddrs(addrs), TTL: ttl})
+	return err
+}
+
+func (ps *pstorehttp) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.SetAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+func (ps *pstorehttp) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	if err := ps.SetAddrsE(p, addrs, ttl); err != nil {
+		log.Errorf("failed to set addresses for peer %s: %s", p.Pretty(), err)
+	}
+}
+
+// SetAddrsE is like SetAddrs, but returns the error of the call, if any.
+func (ps *pstorehttp) SetAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	_, err := ps.call("SetAddrs", &request{Peer: []byte(p), Addrs: encodeAddrs(addrs), TTL: ttl})
+	return err
+}
+
+func (ps *pstorehttp) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
+	if _, err := ps.call("UpdateAddrs", &request{Peer: []byte(p), OldTTL: oldTTL, TTL: newTTL}); err != nil {
+		log.Errorf("failed to update addresses for peer %s: %s", p.Pretty(), err)
+	}
+}
+
+func (ps *pstorehttp) ClearAddrs(p peer.ID) {
+	if err := ps.ClearAddrsE(p); err != nil {
+		log.Errorf("failed to clear addresses for peer %s: %s", p.Pretty(), err)
+	}
+}
+
+// ClearAddrsE is like ClearAddrs, but returns the error of the call, if any.
+func (ps *pstorehttp) ClearAddrsE(p peer.ID) error {
+	_, err := ps.call("ClearAddrs", &request{Peer: []byte(p)})
+	return err
+}
+
+func (ps *pstorehttp) Addrs(p peer.ID) []ma.Multiaddr {
+	resp, err := ps.call("Addrs", &request{Peer: []byte(p)})
+	if err != nil {
+		log.Errorf("failed to get addresses for peer %s: %s", p.Pretty(), err)
+		return nil
+	}
+	addrs, err := decodeAddrs(resp.Addrs)
+	if err != nil {
+		log.Errorf("failed to decode addresses for peer %s: %s", p.Pretty(), err)
+		return nil
+	}
+	return addrs
+}
+
+func (ps *pstorehttp) AddrsWithExpiry(p peer.ID) []pstore.ExpiringAddr {
+	resp, err := ps.call("AddrsWithExpiry", &request{Peer: []byte(p)})
+	if err != nil {
+		log.Errorf("failed to get addresses for peer %s: %s", p.Pretty(), err)
+		return nil
+	}
+	res := make([]pstore.ExpiringAddr, 0, len(resp.Expiring))
+	for _, e := range resp.Expiring {
+		a, err := ma.NewMultiaddrBytes(e.Addr)
+		if err != nil {
+			log.Errorf("failed to decode address for peer %s: %s", p.Pretty(), err)
+			continue
+		}
+		res = append(res, pstore.ExpiringAddr{Addr: a, TTL: e.TTL, Expires: e.Expires})
+	}
+	return res
+}
+
+// AddrStream streams the addresses of a peer over a long-lived request, which is cancelled with ctx. The channel is
+// closed when ctx is done, or when the connection to the server is lost.
+func (ps *pstorehttp) AddrStream(ctx context.Context, p peer.ID) <-chan ma.Multiaddr {
+	out := make(chan ma.Multiaddr)
+
+	body, err := json.Marshal(&request{Peer: []byte(p)})
+	if err != nil {
+		close(out)
+		return out
+	}
+	req, err := http.NewRequest(http.MethodPost, ps.url+"/AddrStream", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("failed to stream addresses for peer %s: %s", p.Pretty(), err)
+		close(out)
+		return out
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	go func() {
+		defer close(out)
+
+		r, err := ps.client.Do(req)
+		if err != nil {
+			log.Debugf("failed to stream addresses for peer %s: %s", p.Pretty(), err)
+			return
+		}
+		defer r.Body.Close()
+		if r.StatusCode != http.StatusOK {
+			log.Errorf("failed to stream addresses for peer %s: status %d", p.Pretty(), r.StatusCode)
+			return
+		}
+
+		dec := json.NewDecoder(r.Body)
+		for {
+			var raw []byte
+			if err := dec.Decode(&raw); err != nil {
+				return
+			}
+			a, err := ma.NewMultiaddrBytes(raw)
+			if err != nil {
+				log.Errorf("failed to decode address for peer %s: %s", p.Pretty(), err)
+				continue
+			}
+			select {
+			case out <- a:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (ps *pstorehttp) PeersWithAddrs() peer.IDSlice {
+	return ps.callPeers("PeersWithAddrs")
+}
+
+// CertifiedAddrBook
+
+func (ps *pstorehttp) ConsumePeerRecord(s *record.Envelope, ttl time.Duration) (bool, error) {
+	env, err := s.Marshal()
+	if err != nil {
+		return false, err
+	}
+	resp, err := ps.call("ConsumePeerRecord", &request{Value: env, TTL: ttl})
+	if err != nil {
+		return false, err
+	}
+	return resp.Bool, nil
+}
+
+func (ps *pstorehttp) GetPeerRecord(p peer.ID) *record.Envelope {
+	resp, err := ps.call("GetPeerRecord", &request{Peer: []byte(p)})
+	if err != nil {
+		log.Errorf("failed to get peer record for peer %s: %s", p.Pretty(), err)
+		return nil
+	}
+	if resp.Value == nil {
+		return nil
+	}
+	env, err := record.ConsumeTypedEnvelope(resp.Value, &peer.PeerRecord{})
+	if err != nil {
+		log.Errorf("failed to decode peer record for peer %s: %s", p.Pretty(), err)
+		return nil
+	}
+	return env
+}
+
+// KeyBook
+
+func (ps *pstorehttp) PubKey(p peer.ID) ic.PubKey {
+	pk, err := ps.PubKeyE(p)
+	if err != nil && err != peerstore.ErrNotFound {
+		log.Errorf("failed to get public key for peer %s: %s", p.Pretty(), err)
+	}
+	return pk
+}
+
+// PubKeyE is like PubKey, but returns peerstore.ErrNotFound if there is no key, or the error of the call.
+func (ps *pstorehttp) PubKeyE(p peer.ID) (ic.PubKey, error) {
+	resp, err := ps.call("PubKey", &request{Peer: []byte(p)})
+	if err != nil {
+		return nil, err
+	}
+	return ic.UnmarshalPublicKey(resp.Value)
+}
+
+func (ps *pstorehttp) AddPubKey(p peer.ID, pk ic.PubKey) error {
+	b, err := ic.MarshalPublicKey(pk)
+	if err != nil {
+		return err
+	}
+	_, err = ps.call("AddPubKey", &request{Peer: []byte(p), Value: b})
+	return err
+}
+
+func (ps *pstorehttp) PrivKey(p peer.ID) ic.PrivKey {
+	sk, err := ps.PrivKeyE(p)
+	if err != nil && err != peerstore.ErrNotFound {
+		log.Errorf("failed to get private key for peer %s: %s", p.Pretty(), err)
+	}
+	return sk
+}
+
+// PrivKeyE is like PrivKey, but returns peerstore.ErrNotFound if there is no key, or the error of the call.
+func (ps *pstorehttp) PrivKeyE(p peer.ID) (ic.PrivKey, error) {
+	resp, err := ps.call("PrivKey", &request{Peer: []byte(p)})
+	if err != nil {
+		return nil, err
+	}
+	return ic.UnmarshalPrivateKey(resp.Value)
+}
+
+func (ps *pstorehttp) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
+	b, err := ic.MarshalPrivateKey(sk)
+	if err != nil {
+		return err
+	}
+	_, err = ps.call("AddPrivKey", &request{Peer: []byte(p), Value: b})
+	return err
+}
+
+func (ps *pstorehttp) PeersWithKeys() peer.IDSlice {
+	return ps.callPeers("PeersWithKeys")
+}
+
+// Metrics
+
+func (ps *pstorehttp) RecordLatency(p peer.ID, d time.Duration) {
+	if _, err := ps.call("RecordLatency", &request{Peer: []byte(p), Latency: d}); err != nil {
+		log.Errorf("failed to record latency for peer %s: %s", p.Pretty(), err)
+	}
+}
+
+func (ps *pstorehttp) LatencyEWMA(p peer.ID) time.Duration {
+	resp, err := ps.call("LatencyEWMA", &request{Peer: []byte(p)})
+	if err != nil {
+		log.Errorf("failed to get latency for peer %s: %s", p.Pretty(), err)
+		return 0
+	}
+	return resp.Latency
+}
+
+// ProtoBook
+
+func (ps *pstorehttp) callProtocols(method string, p peer.ID, protos []string) ([]string, error) {
+	resp, err := ps.call(method, &request{Peer: []byte(p), Protocols: protos})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Protocols, nil
+}
+
+func (ps *pstorehttp) GetProtocols(p peer.ID) ([]string, error) {
+	protos, err := ps.callProtocols("GetProtocols", p, nil)
+	if err == nil && protos == nil {
+		protos = []string{}
+	}
+	return protos, err
+}
+
+func (ps *pstorehttp) AddProtocols(p peer.ID, protos ...string) error {
+	_, err := ps.callProtocols("AddProtocols", p, protos)
+	return err
+}
+
+func (ps *pstorehttp) SetProtocols(p peer.ID, protos ...string) error {
+	_, err := ps.callProtocols("SetProtocols", p, protos)
+	return err
+}
+
+func (ps *pstorehttp) RemoveProtocols(p peer.ID, protos ...string) error {
+	_, err := ps.callProtocols("RemoveProtocols", p, protos)
+	return err
+}
+
+func (ps *pstorehttp) SupportsProtocols(p peer.ID, protos ...string) ([]string, error) {
+	supported, err := ps.callProtocols("SupportsProtocols", p, protos)
+	if err == nil && supported == nil {
+		supported = []string{}
+	}
+	return supported, err
+}
+
+func (ps *pstorehttp) FirstSupportedProtocol(p peer.ID, protos ...string) (string, error) {
+	supported, err := ps.callProtocols("FirstSupportedProtocol", p, protos)
+	if err != nil || len(supported) == 0 {
+		return "", err
+	}
+	return supported[0], nil
+}
+
+// PeerMetadata
+
+func (ps *pstorehttp) Get(p peer.ID, key string) (interface{}, error) {
+	resp, err := ps.call("Get", &request{Peer: []byte(p), Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(resp.Value)
+}
+
+func (ps *pstorehttp) Put(p peer.ID, key string, val interface{}) error {
+	b, err := encodeValue(val)
+	if err != nil {
+		return err
+	}
+	_, err = ps.call("Put", &request{Peer: []byte(p), Key: key, Value: b})
+	return err
+}
+
+// PeerProtector
+
+func (ps *pstorehttp) Protect(p peer.ID, tag string) {
+	if _, err := ps.call("Protect", &request{Peer: []byte(p), Key: tag}); err != nil {
+		log.Errorf("failed to protect peer %s: %s", p.Pretty(), err)
+	}
+}
+
+func (ps *pstorehttp) Unprotect(p peer.ID, tag string) bool {
+	resp, err := ps.call("Unprotect", &request{Peer: []byte(p), Key: tag})
+	if err != nil {
+		log.Errorf("failed to unprotect peer %s: %s", p.Pretty(), err)
+		return false
+	}
+	return resp.Bool
+}
+
+func (ps *pstorehttp) IsProtected(p peer.ID, tag string) bool {
+	resp, err := ps.call("IsProtected", &request{Peer: []byte(p), Key: tag})
+	if err != nil {
+		log.Errorf("failed to check protection of peer %s: %s", p.Pretty(), err)
+		return false
+	}
+	return resp.Bool
+}
+
+func (ps *pstorehttp) ProtectedPeers() peer.IDSlice {
+	return ps.callPeers("ProtectedPeers")
+}
@@ -0,0 +1,465 @@
+package pstorehttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+var log = pstore.SubsystemLogger("peerstore/http")
+
+type handler func(p peer.ID, req *request, resp *response) error
+
+// Server exposes a peerstore over HTTP, so that other processes can share it through the peerstore returned by
+// NewPeerstore. Calls are served at <prefix>/<method>: mount the server with http.StripPrefix to serve it under a
+// prefix.
+//
+// The server does no authentication of its own: unless it's given an authorizer with WithAuthorizer, it must only be
+// reachable by trusted processes, e.g. over a loopback interface or a unix socket. Private keys are only served if
+// enabled with WithPrivateKeys.
+type Server struct {
+	ps       peerstore.Peerstore
+	handlers map[string]handler
+
+	maxRequestSize int64
+	authorize      func(r *http.Request, method string) error
+	privateKeys    bool
+}
+
+var _ http.Handler = (*Server)(nil)
+
+// DefaultMaxRequestSize is the default cap on the size of request bodies, in bytes, far above that of any call.
+const DefaultMaxRequestSize = 1 << 20
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithMaxRequestSize caps the size of request bodies, in bytes; larger requests are rejected with status 413. Defaults
+// to DefaultMaxRequestSize.
+func WithMaxRequestSize(n int64) ServerOption {
+	return func(s *Server) {
+		s.maxRequestSize = n
+	}
+}
+
+// WithAuthorizer makes the server call authorize with every request and the method it calls, AddrStream included,
+// before serving it. Requests it returns an error for are rejected with status 403, and the message of the error. All
+// requests are served by default.
+func WithAuthorizer(authorize func(r *http.Request, method string) error) ServerOption {
+	return func(s *Server) {
+		s.authorize = authorize
+	}
+}
+
+// WithPrivateKeys exposes PrivKey and AddPrivKey, which serve and store private keys. Calls to them are rejected with
+// status 403 by default.
+func WithPrivateKeys() ServerOption {
+	return func(s *Server) {
+		s.privateKeys = true
+	}
+}
+
+// privateKeyMethods are the methods only exposed with WithPrivateKeys.
+var privateKeyMethods = map[string]bool{
+	"PrivKey":    true,
+	"AddPrivKey": true,
+}
+
+// NewServer creates a Server for ps. Optional interfaces of ps, such as peerstore.CertifiedAddrBook or
+// pstore.PeerRemover, are exposed if ps implements them; calls to the others fail.
+func NewServer(ps peerstore.Peerstore, opts ...ServerOption) *Server {
+	s := &Server{ps: ps, maxRequestSize: DefaultMaxRequestSize}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.handlers = map[string]handler{
+		"AddAddrs":               s.addAddrs,
+		"SetAddrs":               s.setAddrs,
+		"UpdateAddrs":            s.updateAddrs,
+		"ClearAddrs":             s.clearAddrs,
+		"Addrs":                  s.addrs,
+		"AddrsWithExpiry":        s.addrsWithExpiry,
+		"PeersWithAddrs":         s.peersWithAddrs,
+		"ConsumePeerRecord":      s.consumePeerRecord,
+		"GetPeerRecord":          s.getPeerRecord,
+		"PubKey":                 s.pubKey,
+		"AddPubKey":              s.addPubKey,
+		"PrivKey":                s.privKey,
+		"AddPrivKey":             s.addPrivKey,
+		"PeersWithKeys":          s.peersWithKeys,
+		"RecordLatency":          s.recordLatency,
+		"LatencyEWMA":            s.latencyEWMA,
+		"GetProtocols":           s.getProtocols,
+		"AddProtocols":           s.addProtocols,
+		"SetProtocols":           s.setProtocols,
+		"RemoveProtocols":        s.removeProtocols,
+		"SupportsProtocols":      s.supportsProtocols,
+		"FirstSupportedProtocol": s.firstSupportedProtocol,
+		"Get":                    s.get,
+		"Put":                    s.put,
+		"Peers":                  s.peers,
+		"RemovePeer":             s.removePeer,
+		"Protect":                s.protect,
+		"Unprotect":              s.unprotect,
+		"IsProtected":            s.isProtected,
+		"ProtectedPeers":         s.protectedPeers,
+	}
+	return s
+}
+
+// ServeHTTP serves a call.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/")
+	if privateKeyMethods[method] && !s.privateKeys {
+		http.Error(w, fmt.Sprintf("method %q not exposed", method), http.StatusForbidden)
+		return
+	}
+	if s.authorize != nil {
+		if err := s.authorize(r, method); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.maxRequestSize))
+	if err != nil {
+		if int64(len(body)) >= s.maxRequestSize {
+			http.Error(w, fmt.Sprintf("request larger than %d bytes", s.maxRequestSize), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, fmt.Sprintf("failed to read request: %s", err), http.StatusBadRequest)
+		}
+		return
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("malformed request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if method == "AddrStream" {
+		s.addrStream(w, r, &req)
+		return
+	}
+	h, ok := s.handlers[method]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown method %q", method), http.StatusNotFound)
+		return
+	}
+
+	var resp response
+	resp.Error = encodeError(h(peer.ID(req.Peer), &req, &resp))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		log.Debugf("failed to write response to %s: %s", method, err)
+	}
+}
+
+// addrStream streams the addresses of a peer, one JSON-encoded address per line, until the client goes away.
+func (s *Server) addrStream(w http.ResponseWriter, r *http.Request, req *request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	p := peer.ID(req.Peer)
+	if err := p.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for a := range s.ps.AddrStream(r.Context(), p) {
+		if err := enc.Encode(a.Bytes()); err != nil {
+			log.Debugf("failed to stream address of peer %s: %s", p.Pretty(), err)
+			// drain the stream until the request context is cancelled.
+			continue
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *Server) addrBookE() pstore.AddrBookE {
+	if ab, ok := s.ps.(pstore.AddrBookE); ok {
+		return ab
+	}
+	return nil
+}
+
+func (s *Server) addAddrs(p peer.ID, req *request, _ *response) error {
+	addrs, err := decodeAddrs(req.Addrs)
+	if err != nil {
+		return err
+	}
+	if ab := s.addrBookE(); ab != nil {
+		return ab.AddAddrsE(p, addrs, req.TTL)
+	}
+	s.ps.AddAddrs(p, addrs, req.TTL)
+	return nil
+}
+
+func (s *Server) setAddrs(p peer.ID, req *request, _ *response) error {
+	addrs, err := decodeAddrs(req.Addrs)
+	if err != nil {
+		return err
+	}
+	if ab := s.addrBookE(); ab != nil {
+		return ab.SetAddrsE(p, addrs, req.TTL)
+	}
+	s.ps.SetAddrs(p, addrs, req.TTL)
+	return nil
+}
+
+func (s *Server) updateAddrs(p peer.ID, req *request, _ *response) error {
+	s.ps.UpdateAddrs(p, req.OldTTL, req.TTL)
+	return nil
+}
+
+func (s *Server) clearAddrs(p peer.ID, _ *request, _ *response) error {
+	if ab := s.addrBookE(); ab != nil {
+		return ab.ClearAddrsE(p)
+	}
+	s.ps.ClearAddrs(p)
+	return nil
+}
+
+func (s *Server) addrs(p peer.ID, _ *request, resp *response) error {
+	resp.Addrs = encodeAddrs(s.ps.Addrs(p))
+	return nil
+}
+
+func (s *Server) addrsWithExpiry(p peer.ID, _ *request, resp *response) error {
+	eab, ok := s.ps.(pstore.ExpiringAddrBook)
+	if !ok {
+		return fmt.Errorf("peerstore does not expose address expiry")
+	}
+	for _, a := range eab.AddrsWithExpiry(p) {
+		resp.Expiring = append(resp.Expiring, expiringAddr{Addr: a.Addr.Bytes(), TTL: a.TTL, Expires: a.Expires})
+	}
+	return nil
+}
+
+func (s *Server) peersWithAddrs(_ peer.ID, _ *request, resp *response) error {
+	resp.Peers = encodePeers(s.ps.PeersWithAddrs())
+	return nil
+}
+
+func (s *Server) certifiedAddrBook() (peerstore.CertifiedAddrBook, error) {
+	cab, ok := s.ps.(peerstore.CertifiedAddrBook)
+	if !ok {
+		return nil, fmt.Errorf("peerstore does not support signed peer records")
+	}
+	return cab, nil
+}
+
+func (s *Server) consumePeerRecord(p peer.ID, req *request, resp *response) error {
+	cab, err := s.certifiedAddrBook()
+	if err != nil {
+		return err
+	}
+	env, err := record.ConsumeTypedEnvelope(req.Value, &peer.PeerRecord{})
+	if err != nil {
+		return err
+	}
+	resp.Bool, err = cab.ConsumePeerRecord(env, req.TTL)
+	return err
+}
+
+func (s *Server) getPeerRecord(p peer.ID, _ *request, resp *response) error {
+	cab, err := s.certifiedAddrBook()
+	if err != nil {
+		return err
+	}
+	env := cab.GetPeerRecord(p)
+	if env == nil {
+		return nil
+	}
+	resp.Value, err = env.Marshal()
+	return err
+}
+
+func (s *Server) pubKey(p peer.ID, _ *request, resp *response) error {
+	var (
+		pk  ic.PubKey
+		err error
+	)
+	if kb, ok := s.ps.(pstore.KeyBookE); ok {
+		pk, err = kb.PubKeyE(p)
+	} else if pk = s.ps.PubKey(p); pk == nil {
+		err = peerstore.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	resp.Value, err = ic.MarshalPublicKey(pk)
+	return err
+}
+
+func (s *Server) addPubKey(p peer.ID, req *request, _ *response) error {
+	pk, err := ic.UnmarshalPublicKey(req.Value)
+	if err != nil {
+		return err
+	}
+	return s.ps.AddPubKey(p, pk)
+}
+
+func (s *Server) privKey(p peer.ID, _ *request, resp *response) error {
+	var (
+		sk  ic.PrivKey
+		err error
+	)
+	if kb, ok := s.ps.(pstore.KeyBookE); ok {
+		sk, err = kb.PrivKeyE(p)
+	} else if sk = s.ps.PrivKey(p); sk == nil {
+		err = peerstore.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	resp.Value, err = ic.MarshalPrivateKey(sk)
+	return err
+}
+
+func (s *Server) addPrivKey(p peer.ID, req *request, _ *response) error {
+	sk, err := ic.UnmarshalPrivateKey(req.Value)
+	if err != nil {
+		return err
+	}
+	return s.ps.AddPrivKey(p, sk)
+}
+
+func (s *Server) peersWithKeys(_ peer.ID, _ *request, resp *response) error {
+	resp.Peers = encodePeers(s.ps.PeersWithKeys())
+	return nil
+}
+
+func (s *Server) recordLatency(p peer.ID, req *request, _ *response) error {
+	s.ps.RecordLatency(p, req.Latency)
+	return nil
+}
+
+func (s *Server) latencyEWMA(p peer.ID, _ *request, resp *response) error {
+	resp.Latency = s.ps.LatencyEWMA(p)
+	return nil
+}
+
+func (s *Server) getProtocols(p peer.ID, _ *request, resp *response) (err error) {
+	resp.Protocols, err = s.ps.GetProtocols(p)
+	return err
+}
+
+func (s *Server) addProtocols(p peer.ID, req *request, _ *response) error {
+	return s.ps.AddProtocols(p, req.Protocols...)
+}
+
+func (s *Server) setProtocols(p peer.ID, req *request, _ *response) error {
+	return s.ps.SetProtocols(p, req.Protocols...)
+}
+
+func (s *Server) removeProtocols(p peer.ID, req *request, _ *response) error {
+	return s.ps.RemoveProtocols(p, req.Protocols...)
+}
+
+func (s *Server) supportsProtocols(p peer.ID, req *request, resp *response) (err error) {
+	resp.Protocols, err = s.ps.SupportsProtocols(p, req.Protocols...)
+	return err
+}
+
+func (s *Server) firstSupportedProtocol(p peer.ID, req *request, resp *response) error {
+	proto, err := s.ps.FirstSupportedProtocol(p, req.Protocols...)
+	if proto != "" {
+		resp.Protocols = []string{proto}
+	}
+	return err
+}
+
+func (s *Server) get(p peer.ID, req *request, resp *response) error {
+	val, err := s.ps.Get(p, req.Key)
+	if err != nil {
+		return err
+	}
+	resp.Value, err = encodeValue(val)
+	return err
+}
+
+func (s *Server) put(p peer.ID, req *request, _ *response) error {
+	val, err := decodeValue(req.Value)
+	if err != nil {
+		return err
+	}
+	return s.ps.Put(p, req.Key, val)
+}
+
+func (s *Server) peers(_ peer.ID, _ *request, resp *response) error {
+	resp.Peers = encodePeers(s.ps.Peers())
+	return nil
+}
+
+func (s *Server) removePeer(p peer.ID, _ *request, _ *response) error {
+	rm, ok := s.ps.(pstore.PeerRemover)
+	if !ok {
+		return fmt.Errorf("peerstore does not support removing peers")
+	}
+	rm.RemovePeer(p)
+	return nil
+}
+
+func (s *Server) peerProtector() (pstore.PeerProtector, error) {
+	pp, ok := s.ps.(pstore.PeerProtector)
+	if !ok {
+		return nil, fmt.Errorf("peerstore does not support protecting peers")
+	}
+	return pp, nil
+}
+
+func (s *Server) protect(p peer.ID, req *request, _ *response) error {
+	pp, err := s.peerProtector()
+	if err != nil {
+		return err
+	}
+	pp.Protect(p, req.Key)
+	return nil
+}
+
+func (s *Server) unprotect(p peer.ID, req *request, resp *response) error {
+	pp, err := s.peerProtector()
+	if err != nil {
+		return err
+	}
+	resp.Bool = pp.Unprotect(p, req.Key)
+	return nil
+}
+
+func (s *Server) isProtected(p peer.ID, req *request, resp *response) error {
+	pp, err := s.peerProtector()
+	if err != nil {
+		return err
+	}
+	resp.Bool = pp.IsProtected(p, req.Key)
+	return nil
+}
+
+func (s *Server) protectedPeers(_ peer.ID, _ *request, resp *response) error {
+	pp, err := s.peerProtector()
+	if err != nil {
+		return err
+	}
+	resp.Peers = encodePeers(pp.ProtectedPeers())
+	return nil
+}
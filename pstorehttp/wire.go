@@ -0,0 +1,155 @@
+package pstorehttp
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/addr"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+// Calls are POSTed to <url>/<method>, with a JSON-encoded request as body, and answered with a JSON-encoded response.
+// Requests and responses are shared by all methods, each using the fields it needs.
+//
+// Peer IDs are sent as raw bytes rather than in their JSON encoding, which only accepts valid multihashes.
+type request struct {
+	Peer   []byte        `json:",omitempty"`
+	Addrs  [][]byte      `json:",omitempty"`
+	TTL    time.Duration `json:",omitempty"`
+	OldTTL time.Duration `json:",omitempty"`
+	// Key is the metadata key, or the protection tag.
+	Key string `json:",omitempty"`
+	// Value is a gob-encoded metadata value, a marshalled key or a marshalled envelope.
+	Value     []byte        `json:",omitempty"`
+	Protocols []string      `json:",omitempty"`
+	Latency   time.Duration `json:",omitempty"`
+}
+
+type response struct {
+	// Error is the message of the error returned by the peerstore, if any.
+	Error     string         `json:",omitempty"`
+	Peers     [][]byte       `json:",omitempty"`
+	Addrs     [][]byte       `json:",omitempty"`
+	Expiring  []expiringAddr `json:",omitempty"`
+	Value     []byte         `json:",omitempty"`
+	Protocols []string       `json:",omitempty"`
+	Bool      bool           `json:",omitempty"`
+	Latency   time.Duration  `json:",omitempty"`
+}
+
+type expiringAddr struct {
+	Addr    []byte
+	TTL     time.Duration
+	Expires time.Time
+}
+
+// sentinels are the errors that are recognised by their message, so that callers can compare them.
+var sentinels = []error{
+	peerstore.ErrNotFound,
+	pstore.ErrValueTooLarge,
+	pstore.ErrQuotaExceeded,
+	pstore.ErrAddrTooLong,
+	pstore.ErrExoticAddrDropped,
+	pstore.ErrP2PAddrMismatch,
+	pstoremem.ErrAddrBookFull,
+}
+
+// wrappedSentinels are the errors that are recognised as the prefix of the messages of the errors wrapping them, so
+// that callers can tell them apart with errors.Is.
+var wrappedSentinels = []error{
+	addr.ErrMalformedExotic,
+}
+
+func encodeError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func decodeError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	for _, err := range sentinels {
+		if err.Error() == msg {
+			return err
+		}
+	}
+	for _, err := range wrappedSentinels {
+		if strings.HasPrefix(msg, err.Error()+" ") {
+			return fmt.Errorf("%w%s", err, msg[len(err.Error()):])
+		}
+	}
+	return errors.New(msg)
+}
+
+func encodeAddrs(addrs []ma.Multiaddr) [][]byte {
+	res := make([][]byte, 0, len(addrs))
+	for _, a := range addrs {
+		if a == nil {
+			continue
+		}
+		res = append(res, a.Bytes())
+	}
+	return res
+}
+
+func decodeAddrs(raw [][]byte) ([]ma.Multiaddr, error) {
+	res := make([]ma.Multiaddr, 0, len(raw))
+	for _, b := range raw {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+func init() {
+	// register the complex types used by the peerstore itself, like pstoreds does.
+	gob.Register(make(map[string]struct{}))
+}
+
+// metadata values are gob-encoded like in pstoreds, so the same types must be registered.
+func encodeValue(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeValue(b []byte) (interface{}, error) {
+	var res interface{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func encodePeers(peers peer.IDSlice) [][]byte {
+	res := make([][]byte, len(peers))
+	for i, p := range peers {
+		res[i] = []byte(p)
+	}
+	return res
+}
+
+func decodePeers(raw [][]byte) peer.IDSlice {
+	res := make(peer.IDSlice, len(raw))
+	for i, b := range raw {
+		res[i] = peer.ID(b)
+	}
+	return res
+}
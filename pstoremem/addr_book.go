@@ -5,23 +5,27 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-core/peer"
-	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/record"
 	ma "github.com/multiformats/go-multiaddr"
 
+	pstore "github.com/libp2p/go-libp2p-peerstore"
 	"github.com/libp2p/go-libp2p-peerstore/addr"
 )
 
-var log = logging.Logger("peerstore")
+var log = pstore.SubsystemLogger("peerstore")
 
 type expiringAddr struct {
-	Addr    ma.Multiaddr
-	TTL     time.Duration
-	Expires time.Time
+	Addr     ma.Multiaddr
+	TTL      time.Duration
+	Expires  time.Time
+	LastSeen time.Time
+	// position of the address in the order the addresses of the peer were added.
+	Added uint64
 }
 
 func (e *expiringAddr) ExpiredBy(t time.Time) bool {
@@ -44,6 +48,13 @@ type addrSegment struct {
 	addrs map[peer.ID]map[string]*expiringAddr
 
 	signedPeerRecords map[peer.ID]*peerRecordState
+
+	// peak lengths of addrs and signedPeerRecords, see Vacuum.
+	addrsPeak   mapPeak
+	recordsPeak mapPeak
+
+	// view holds the published segmentView of the segment in read-mostly mode.
+	view atomic.Value
 }
 
 func (segments *addrSegments) get(p peer.ID) *addrSegment {
@@ -58,12 +69,51 @@ type memoryAddrBook struct {
 	cancel func()
 
 	subManager *AddrSubManager
+	limiter    *addrLimiter
+	clock      pstore.Clock
+	order      *ordering
+	auditor    auditor
+	ttlPolicy  pstore.TTLPolicy
+	aliases    bool
+	validateID pstore.IDValidator
+	p2pPolicy  pstore.P2PAddrPolicy
+	exotic     pstore.ExoticAddrPolicy
+	lengthCap  *pstore.AddrLengthCap
+	jitter     *pstore.TTLJitter
+	debouncer  *pstore.AddrDebouncer
+	strict     *pstore.StrictChecks
+	maxTTL     time.Duration
+	addrOrder  pstore.AddrOrder
+	churn      *pstore.AddrChurnLog // nil unless WithAddrHistory is set.
+	readMostly bool
+	autoVacuum float64
+	gens       *pstore.Generations // set by NewPeerstore.
+	*ProtectManager
+
+	// in deterministic mode, GC runs on writes once the clock reaches nextGC.
+	inlineGC bool
+	gcLk     sync.Mutex
+	nextGC   time.Time
 }
 
-var _ pstore.AddrBook = (*memoryAddrBook)(nil)
-var _ pstore.CertifiedAddrBook = (*memoryAddrBook)(nil)
-
-func NewAddrBook() *memoryAddrBook {
+var _ peerstore.AddrBook = (*memoryAddrBook)(nil)
+var _ peerstore.CertifiedAddrBook = (*memoryAddrBook)(nil)
+var _ pstore.ExpiringAddrBook = (*memoryAddrBook)(nil)
+var _ pstore.ExpiryTimeline = (*memoryAddrBook)(nil)
+var _ pstore.RecencyAddrBook = (*memoryAddrBook)(nil)
+var _ pstore.AddrBookE = (*memoryAddrBook)(nil)
+var _ pstore.KnownFilter = (*memoryAddrBook)(nil)
+var _ pstore.AddrChurnBook = (*memoryAddrBook)(nil)
+
+// gcInterval is the interval at which expired addresses are garbage collected.
+const gcInterval = 1 * time.Hour
+
+// NewAddrBook creates an in-memory address book. It accepts the WithAddrLimits, WithClock, WithDeterminism,
+// WithAuditSink, WithTTLPolicy, WithAddrAliases, WithIDValidator, WithP2PAddrPolicy, WithExoticAddrPolicy,
+// WithMaxAddrLen, WithTTLJitter, WithAddrDebounce, WithStrictChecks, WithMaxAddrTTL, WithAddrOrder, WithAddrHistory,
+// WithReadMostly and WithAutoVacuum options.
+func NewAddrBook(opts ...Option) *memoryAddrBook {
+	o := newOptions(opts)
 	ctx, cancel := context.WithCancel(context.Background())
 
 	ab := &memoryAddrBook{
@@ -75,18 +125,46 @@ func NewAddrBook() *memoryAddrBook {
 			}
 			return ret
 		}(),
-		subManager: NewAddrSubManager(),
-		ctx:        ctx,
-		cancel:     cancel,
+		subManager:     NewAddrSubManager(),
+		limiter:        newAddrLimiter(o.addrLimits),
+		clock:          o.clock,
+		order:          newOrdering(o),
+		auditor:        newAuditor(o),
+		ttlPolicy:      o.ttlPolicy,
+		aliases:        o.aliases,
+		validateID:     o.validateID,
+		p2pPolicy:      o.p2pPolicy,
+		exotic:         o.exoticPolicy,
+		lengthCap:      pstore.NewAddrLengthCap(o.maxAddrLen),
+		jitter:         newTTLJitter(o),
+		debouncer:      pstore.NewAddrDebouncer(o.debounce, o.clock),
+		strict:         pstore.NewStrictChecks(o.strict),
+		maxTTL:         o.maxAddrTTL,
+		addrOrder:      o.addrOrder,
+		churn:          pstore.NewAddrChurnLog(o.addrHistory),
+		readMostly:     o.readMostly,
+		autoVacuum:     o.autoVacuum,
+		ProtectManager: NewProtectManager(),
+		ctx:            ctx,
+		cancel:         cancel,
 	}
 
-	go ab.background()
+	for _, s := range ab.segments {
+		s.view.Store(segmentView{})
+	}
+
+	if o.deterministic {
+		ab.inlineGC = true
+		ab.nextGC = ab.clock.Now().Add(gcInterval)
+	} else {
+		go ab.background()
+	}
 	return ab
 }
 
 // background periodically schedules a gc
 func (mab *memoryAddrBook) background() {
-	ticker := time.NewTicker(1 * time.Hour)
+	ticker := time.NewTicker(gcInterval)
 	defer ticker.Stop()
 
 	for {
@@ -100,37 +178,79 @@ func (mab *memoryAddrBook) background() {
 	}
 }
 
+// Reconfigure changes the limits of the address book at runtime, e.g. on a configuration reload. Only the
+// WithAddrLimits option applies; limits are left unchanged if it isn't passed. Threshold crossings caused by the new
+// limits are notified right away, and new peers are rejected, or accepted again, from the next write on.
+func (mab *memoryAddrBook) Reconfigure(opts ...Option) {
+	o := &options{addrLimits: mab.limiter.get()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	mab.limiter.set(o.addrLimits)
+}
+
 func (mab *memoryAddrBook) Close() error {
 	mab.cancel()
 	return nil
 }
 
+// maybeGC garbage collects the address book in deterministic mode, if due.
+func (mab *memoryAddrBook) maybeGC() {
+	if !mab.inlineGC {
+		return
+	}
+	mab.gcLk.Lock()
+	defer mab.gcLk.Unlock()
+	now := mab.clock.Now()
+	if now.Before(mab.nextGC) {
+		return
+	}
+	mab.nextGC = now.Add(gcInterval)
+	mab.gc()
+}
+
 // gc garbage collects the in-memory address book.
 func (mab *memoryAddrBook) gc() {
-	now := time.Now()
+	now := mab.clock.Now()
+	var expired, collected int
+	defer func() {
+		log.Debugf("GC removed %d expired addresses and %d peers left without any", expired, collected)
+	}()
 	for _, s := range mab.segments {
 		s.Lock()
 		var collectedPeers []peer.ID
 		for p, amap := range s.addrs {
+			if mab.IsProtected(p, "") {
+				continue
+			}
 			for k, addr := range amap {
 				if addr.ExpiredBy(now) {
 					delete(amap, k)
+					mab.limiter.add(-1)
+					mab.churn.Record(p, addr.Addr, pstore.AddrExpired, addr.Expires)
+					expired++
 				}
 			}
 			if len(amap) == 0 {
-				delete(s.addrs, p)
+				s.deletePeerUnlocked(p)
 				collectedPeers = append(collectedPeers, p)
 			}
 		}
 		// remove signed records for peers whose signed addrs have all been removed
 		for _, p := range collectedPeers {
-			delete(s.signedPeerRecords, p)
+			s.deleteRecordUnlocked(p)
 		}
+		mab.autoVacuumUnlocked(s)
+		mab.republishUnlocked(s)
+		collected += len(collectedPeers)
 		s.Unlock()
 	}
 }
 
 func (mab *memoryAddrBook) PeersWithAddrs() peer.IDSlice {
+	if mab.readMostly {
+		return mab.readPeersWithAddrs()
+	}
 	// deduplicate, since the same peer could have both signed & unsigned addrs
 	pidSet := peer.NewSet()
 	for _, s := range mab.segments {
@@ -142,7 +262,9 @@ func (mab *memoryAddrBook) PeersWithAddrs() peer.IDSlice {
 		}
 		s.RUnlock()
 	}
-	return pidSet.Peers()
+	pids := pidSet.Peers()
+	mab.order.peers(pids)
+	return pids
 }
 
 // AddAddr calls AddAddrs(p, []ma.Multiaddr{addr}, ttl)
@@ -152,14 +274,16 @@ func (mab *memoryAddrBook) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Durati
 
 // AddAddrs gives memoryAddrBook addresses to use, with a given ttl
 // (time-to-live), after which the address is no longer valid.
-// This function never reduces the TTL or expiration of an address.
+// Addresses already held keep the TTL and expiration chosen by the TTL policy, which by default never reduces them.
 func (mab *memoryAddrBook) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
 	// if we have a valid peer record, ignore unsigned addrs
 	// peerRec := mab.GetPeerRecord(p)
 	// if peerRec != nil {
 	// 	return
 	// }
-	mab.addAddrs(p, addrs, ttl)
+	if err := mab.AddAddrsE(p, addrs, ttl); err != nil {
+		log.Warnf("failed to add addrs for peer %s: %s", p, err)
+	}
 }
 
 // ConsumePeerRecord adds addresses from a signed peer.PeerRecord (contained in
@@ -175,42 +299,86 @@ func (mab *memoryAddrBook) ConsumePeerRecord(recordEnvelope *record.Envelope, tt
 		return false, fmt.Errorf("unable to process envelope: not a PeerRecord")
 	}
 	if !rec.PeerID.MatchesPublicKey(recordEnvelope.PublicKey) {
+		mab.auditor.audit(pstore.AuditEvent{Type: pstore.AuditRecordKeyMismatch, Peer: rec.PeerID, Seq: rec.Seq})
 		return false, fmt.Errorf("signing key does not match PeerID in PeerRecord")
 	}
+	mab.maybeGC()
 
 	// ensure seq is greater than, or equal to, the last received
 	s := mab.segments.get(rec.PeerID)
 	s.Lock()
 	defer s.Unlock()
+	if mab.rejectPeerUnlocked(s, rec.PeerID) {
+		return false, ErrAddrBookFull
+	}
 	lastState, found := s.signedPeerRecords[rec.PeerID]
 	if found && lastState.Seq > rec.Seq {
+		mab.auditor.audit(pstore.AuditEvent{
+			Type:      pstore.AuditRecordStale,
+			Peer:      rec.PeerID,
+			Seq:       rec.Seq,
+			StoredSeq: lastState.Seq,
+		})
+		log.Debugf("ignored signed peer record %d of peer %s, older than the stored record %d", rec.Seq, rec.PeerID, lastState.Seq)
 		return false, nil
 	}
+	if found {
+		log.Debugf("replacing signed peer record %d of peer %s with record %d", lastState.Seq, rec.PeerID, rec.Seq)
+	}
 	s.signedPeerRecords[rec.PeerID] = &peerRecordState{
 		Envelope: recordEnvelope,
 		Seq:      rec.Seq,
 	}
-	mab.addAddrsUnlocked(s, rec.PeerID, rec.Addrs, ttl, true)
+	// the addresses of records are subject to the same policies as added ones; the record is kept as signed.
+	addrs, perr := mab.applyPolicies(rec.PeerID, rec.Addrs)
+	if perr != nil {
+		log.Debugf("dropped addresses of signed peer record %d of peer %s: %s", rec.Seq, rec.PeerID, perr)
+	}
+	if err := mab.addAddrsUnlocked(s, rec.PeerID, addrs, ttl, true); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
-func (mab *memoryAddrBook) addAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
-	if err := p.Validate(); err != nil {
-		log.Warningf("tried to set addrs for invalid peer ID %s: %s", p, err)
-		return
+// AddAddrsE is like AddAddrs, but returns an error if the peer ID is invalid, ErrAddrBookFull if the addresses
+// were dropped because the address book is full, pstore.ErrP2PAddrMismatch if some were rejected by the /p2p
+// address policy, the reason of the first rejected by the exotic address policy, pstore.ErrAddrTooLong if some were
+// longer than the length cap, or a *pstore.ArgumentError if strict checks are enabled and reject the arguments.
+func (mab *memoryAddrBook) AddAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	if err := mab.validateID(p); err != nil {
+		return err
+	}
+	if err := mab.strict.AddAddrs(p, addrs, ttl); err != nil {
+		return err
+	}
+	mab.maybeGC()
+
+	addrs, perr := mab.applyPolicies(p, addrs)
+	if ttl > 0 && len(addrs) > 0 {
+		if addrs = mab.debouncer.Filter(p, addrs, ttl); len(addrs) == 0 {
+			return perr
+		}
 	}
 
 	s := mab.segments.get(p)
 	s.Lock()
 	defer s.Unlock()
 
-	mab.addAddrsUnlocked(s, p, addrs, ttl, false)
+	if err := mab.addAddrsUnlocked(s, p, addrs, ttl, false); err != nil {
+		mab.debouncer.Forget(p)
+		return err
+	}
+	return perr
 }
 
-func (mab *memoryAddrBook) addAddrsUnlocked(s *addrSegment, p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, signed bool) {
+func (mab *memoryAddrBook) addAddrsUnlocked(s *addrSegment, p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, signed bool) error {
 	// if ttl is zero, exit. nothing to do.
 	if ttl <= 0 {
-		return
+		return nil
+	}
+	ttl = pstore.ClampTTL(ttl, mab.maxTTL)
+	if mab.rejectPeerUnlocked(s, p) {
+		return ErrAddrBookFull
 	}
 
 	amap, ok := s.addrs[p]
@@ -219,41 +387,84 @@ func (mab *memoryAddrBook) addAddrsUnlocked(s *addrSegment, p peer.ID, addrs []m
 		s.addrs[p] = amap
 	}
 
-	exp := time.Now().Add(ttl)
+	now, validAt := mab.clock.Now(), mab.validAt(p)
+	next := nextAdded(amap)
 	addrSet := make(map[string]struct{}, len(addrs))
 	for _, addr := range addrs {
 		if addr == nil {
 			log.Warnf("was passed nil multiaddr for %s", p)
 			continue
 		}
+		// the exotic address policy caps the TTL of some addresses.
+		ttl := mab.exotic.TTL(addr, ttl)
+		exp := mab.jitter.Expiry(now, ttl)
 		k := string(addr.Bytes())
 		addrSet[k] = struct{}{}
 
-		// find the highest TTL and Expiry time between
-		// existing records and function args
 		a, found := amap[k] // won't allocate.
 
 		if !found {
 			// not found, announce it.
-			entry := &expiringAddr{Addr: addr, Expires: exp, TTL: ttl}
-			amap[k] = entry
+			a = &expiringAddr{Addr: addr, Expires: exp, TTL: ttl, LastSeen: now, Added: next}
+			next++
+			amap[k] = a
+			mab.limiter.add(1)
+			mab.churn.Record(p, addr, pstore.AddrAdded, now)
+			mab.subManager.BroadcastAddr(p, addr)
+		} else if a.ExpiredBy(validAt) {
+			// expired but not yet collected, re-add it as if it were new.
+			mab.churn.Record(p, addr, pstore.AddrExpired, a.Expires)
+			mab.churn.Record(p, addr, pstore.AddrAdded, now)
+			a.TTL, a.Expires, a.LastSeen, a.Added = ttl, exp, now, next
+			next++
 			mab.subManager.BroadcastAddr(p, addr)
 		} else {
-			// update ttl & exp to whichever is greater between new and existing entry
-			if ttl > a.TTL {
-				a.TTL = ttl
-			}
-			if exp.After(a.Expires) {
-				a.Expires = exp
-			}
+			// resolve the conflicting TTLs according to the policy.
+			merged := mab.ttlPolicy(
+				pstore.ExpiringAddr{Addr: a.Addr, TTL: a.TTL, Expires: a.Expires},
+				pstore.ExpiringAddr{Addr: addr, TTL: ttl, Expires: exp},
+			)
+			a.TTL, a.Expires = merged.TTL, merged.Expires
+			a.LastSeen = now
+		}
+		mab.syncAliasesUnlocked(amap, a)
+	}
+	mab.publishUnlocked(s, p)
+	mab.gens.Bump(p)
+	return nil
+}
+
+// applyPolicies applies the length cap and the /p2p and exotic address policies to addrs, returning the addresses to
+// store and the reason the first rejected one was rejected, if any.
+func (mab *memoryAddrBook) applyPolicies(p peer.ID, addrs []ma.Multiaddr) ([]ma.Multiaddr, error) {
+	addrs, lerr := mab.lengthCap.Filter(addrs)
+	addrs, perr := mab.p2pPolicy.ApplyAll(p, addrs)
+	addrs, eerr := mab.exotic.ApplyAll(addrs)
+	for _, err := range []error{lerr, perr, eerr} {
+		if err != nil {
+			return addrs, err
+		}
+	}
+	return addrs, nil
+}
+
+// syncAliasesUnlocked copies the TTL, expiry and last seen time of an address to its aliases, if aliases are enabled.
+// To be called with the segment locked.
+func (mab *memoryAddrBook) syncAliasesUnlocked(amap map[string]*expiringAddr, e *expiringAddr) {
+	if !mab.aliases {
+		return
+	}
+	for _, a := range amap {
+		if addr.IsAlias(a.Addr, e.Addr) {
+			a.TTL, a.Expires, a.LastSeen = e.TTL, e.Expires, e.LastSeen
 		}
 	}
 }
 
 // SetAddr calls mgr.SetAddrs(p, addr, ttl)
 func (mab *memoryAddrBook) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
-	if err := p.Validate(); err != nil {
-		log.Warningf("tried to set addrs for invalid peer ID %s: %s", p, err)
+	if err := mab.validateID(p); err != nil {
+		log.Warnf("tried to set addrs for invalid peer ID %s: %s", p, err)
 		return
 	}
 
@@ -263,90 +474,363 @@ func (mab *memoryAddrBook) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Durati
 // SetAddrs sets the ttl on addresses. This clears any TTL there previously.
 // This is used when we receive the best estimate of the validity of an address.
 func (mab *memoryAddrBook) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
-	if err := p.Validate(); err != nil {
-		log.Warningf("tried to set addrs for invalid peer ID %s: %s", p, err)
-		return
+	if err := mab.SetAddrsE(p, addrs, ttl); err != nil {
+		log.Warnf("failed to set addrs for peer %s: %s", p, err)
 	}
+}
+
+// SetAddrsE is like SetAddrs, but returns an error if the peer ID is invalid, ErrAddrBookFull if the addresses
+// were dropped because the address book is full, pstore.ErrP2PAddrMismatch if some were rejected by the /p2p
+// address policy, the reason of the first rejected by the exotic address policy, pstore.ErrAddrTooLong if some were
+// longer than the length cap, or a *pstore.ArgumentError if strict checks are enabled and reject the arguments.
+func (mab *memoryAddrBook) SetAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	if err := mab.validateID(p); err != nil {
+		return err
+	}
+	if err := mab.strict.SetAddrs(p, addrs, ttl); err != nil {
+		return err
+	}
+	ttl = pstore.ClampTTL(ttl, mab.maxTTL)
+	mab.maybeGC()
+	addrs, perr := mab.applyPolicies(p, addrs)
 
 	s := mab.segments.get(p)
 	s.Lock()
 	defer s.Unlock()
 
+	if ttl > 0 && mab.rejectPeerUnlocked(s, p) {
+		return ErrAddrBookFull
+	}
+
 	amap, ok := s.addrs[p]
 	if !ok {
 		amap = make(map[string]*expiringAddr)
 		s.addrs[p] = amap
 	}
 
-	exp := time.Now().Add(ttl)
+	now, validAt := mab.clock.Now(), mab.validAt(p)
+	next := nextAdded(amap)
 	for _, addr := range addrs {
 		if addr == nil {
 			log.Warnf("was passed nil multiaddr for %s", p)
 			continue
 		}
+		// the exotic address policy caps the TTL of some addresses.
+		ttl := mab.exotic.TTL(addr, ttl)
+		exp := mab.jitter.Expiry(now, ttl)
 		aBytes := addr.Bytes()
 		key := string(aBytes)
 
 		// re-set all of them for new ttl.
+		old, existed := amap[key]
 		if ttl > 0 {
-			amap[key] = &expiringAddr{Addr: addr, Expires: exp, TTL: ttl}
+			e := &expiringAddr{Addr: addr, Expires: exp, TTL: ttl, LastSeen: now}
+			if existed && !old.ExpiredBy(validAt) {
+				e.Added = old.Added
+			} else {
+				if existed {
+					mab.churn.Record(p, addr, pstore.AddrExpired, old.Expires)
+				}
+				mab.churn.Record(p, addr, pstore.AddrAdded, now)
+				e.Added = next
+				next++
+			}
+			amap[key] = e
+			mab.syncAliasesUnlocked(amap, e)
+			if !existed {
+				mab.limiter.add(1)
+			}
 			mab.subManager.BroadcastAddr(p, addr)
-		} else {
+		} else if existed {
 			delete(amap, key)
+			mab.limiter.add(-1)
+			mab.recordDropUnlocked(p, old, validAt, now)
 		}
 	}
 
 	// if we've expired all the signed addresses for a peer, remove their signed routing state record
 	if len(amap) == 0 {
-		delete(s.signedPeerRecords, p)
+		s.deleteRecordUnlocked(p)
+		mab.autoVacuumUnlocked(s)
 	}
+	mab.publishUnlocked(s, p)
+	mab.gens.Bump(p)
+	mab.debouncer.Forget(p)
+	return perr
 }
 
 // UpdateAddrs updates the addresses associated with the given peer that have
 // the given oldTTL to have the given newTTL.
 func (mab *memoryAddrBook) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
-	if err := p.Validate(); err != nil {
-		log.Warningf("tried to set addrs for invalid peer ID %s: %s", p, err)
+	if err := mab.validateID(p); err != nil {
+		log.Warnf("tried to set addrs for invalid peer ID %s: %s", p, err)
+		return
+	}
+	if err := mab.strict.UpdateAddrs(p, oldTTL, newTTL); err != nil {
+		log.Warnf("failed to update addrs: %s", err)
 		return
 	}
+	// addresses added with a TTL above the clamp hold the clamped TTL.
+	oldTTL, newTTL = pstore.ClampTTL(oldTTL, mab.maxTTL), pstore.ClampTTL(newTTL, mab.maxTTL)
+	mab.maybeGC()
 
 	s := mab.segments.get(p)
 	s.Lock()
 	defer s.Unlock()
-	exp := time.Now().Add(newTTL)
+	now, validAt := mab.clock.Now(), mab.validAt(p)
 	amap, found := s.addrs[p]
 	if found {
 		for k, a := range amap {
-			if oldTTL == a.TTL {
-				a.TTL = newTTL
-				a.Expires = exp
-				amap[k] = a
+			// expired addresses are left for the GC, rather than revived. Addresses added with a TTL above the cap of
+			// the exotic address policy hold the capped TTL.
+			if mab.exotic.TTL(a.Addr, oldTTL) != a.TTL || a.ExpiredBy(validAt) {
+				continue
 			}
+			if newTTL <= 0 {
+				// drop the address rather than letting it expire, as protected peers retain expired addresses.
+				delete(amap, k)
+				mab.limiter.add(-1)
+				mab.churn.Record(p, a.Addr, pstore.AddrRemoved, now)
+				continue
+			}
+			a.TTL = mab.exotic.TTL(a.Addr, newTTL)
+			a.Expires = mab.jitter.Expiry(now, a.TTL)
+			amap[k] = a
 		}
 	}
 
 	// if we've expired all the signed addresses for a peer, remove their signed routing state record
 	if len(amap) == 0 {
-		delete(s.signedPeerRecords, p)
+		s.deleteRecordUnlocked(p)
+		mab.autoVacuumUnlocked(s)
 	}
+	mab.publishUnlocked(s, p)
+	mab.gens.Bump(p)
+	mab.debouncer.Forget(p)
+}
+
+// AddrCount returns the total number of addresses held by the address book, including expired addresses pending
+// garbage collection.
+func (mab *memoryAddrBook) AddrCount() int {
+	return mab.limiter.count()
+}
+
+// TooLongAddrs returns the number of addresses rejected so far as longer than the length cap, see WithMaxAddrLen.
+func (mab *memoryAddrBook) TooLongAddrs() uint64 {
+	return mab.lengthCap.Rejected()
+}
+
+// rejectPeerUnlocked returns whether addresses for a peer should be rejected because it's a new peer and the
+// address book is full. To be called with the segment locked.
+func (mab *memoryAddrBook) rejectPeerUnlocked(s *addrSegment, p peer.ID) bool {
+	return mab.limiter.rejectNew() && len(s.addrs[p]) == 0
 }
 
 // Addrs returns all known (and valid) addresses for a given peer
 func (mab *memoryAddrBook) Addrs(p peer.ID) []ma.Multiaddr {
-	if err := p.Validate(); err != nil {
+	if err := mab.validateID(p); err != nil {
 		// invalid peer ID = no addrs
 		return nil
 	}
 
+	if mab.readMostly {
+		return mab.readAddrs(p)
+	}
+
+	s := mab.segments.get(p)
+	s.RLock()
+	defer s.RUnlock()
+
+	entries := validEntries(s.addrs[p], mab.validAt(p))
+	mab.sortEntries(entries)
+	addrs := make([]ma.Multiaddr, len(entries))
+	for i, e := range entries {
+		addrs[i] = e.Addr
+	}
+	if mab.aliases {
+		addrs = addr.DedupAliases(addrs)
+	}
+	return addrs
+}
+
+// HasAddrs returns whether a peer has non-expired addresses, without copying them.
+func (mab *memoryAddrBook) HasAddrs(p peer.ID) bool {
+	if err := mab.validateID(p); err != nil {
+		return false
+	}
+	if mab.readMostly {
+		return mab.readHasAddrs(p)
+	}
+
 	s := mab.segments.get(p)
 	s.RLock()
 	defer s.RUnlock()
 
-	return validAddrs(s.addrs[p])
+	now := mab.validAt(p)
+	for _, m := range s.addrs[p] {
+		if !m.ExpiredBy(now) {
+			return true
+		}
+	}
+	return false
 }
 
-func validAddrs(amap map[string]*expiringAddr) []ma.Multiaddr {
-	now := time.Now()
+// FilterKnown splits peers into those with non-expired addresses and the others, each in the order they were passed
+// in. Peers are checked segment by segment, so that each segment is locked once.
+func (mab *memoryAddrBook) FilterKnown(peers []peer.ID) (known, unknown []peer.ID) {
+	bySegment := make(map[*addrSegment][]int)
+	for i, p := range peers {
+		if mab.validateID(p) != nil {
+			continue
+		}
+		s := mab.segments.get(p)
+		bySegment[s] = append(bySegment[s], i)
+	}
+
+	has := make([]bool, len(peers))
+	for s, idx := range bySegment {
+		s.RLock()
+		for _, i := range idx {
+			now := mab.validAt(peers[i])
+			for _, m := range s.addrs[peers[i]] {
+				if !m.ExpiredBy(now) {
+					has[i] = true
+					break
+				}
+			}
+		}
+		s.RUnlock()
+	}
+
+	for i, p := range peers {
+		if has[i] {
+			known = append(known, p)
+		} else {
+			unknown = append(unknown, p)
+		}
+	}
+	return known, unknown
+}
+
+// validAt returns the time against which the addresses of a peer are checked for expiry. Protected peers retain
+// their addresses past expiry.
+func (mab *memoryAddrBook) validAt(p peer.ID) time.Time {
+	if mab.IsProtected(p, "") {
+		return time.Time{}
+	}
+	return mab.clock.Now()
+}
+
+// AddrsWithExpiry returns all known (and valid) addresses for a given peer, along with their TTLs and expiry times.
+func (mab *memoryAddrBook) AddrsWithExpiry(p peer.ID) []pstore.ExpiringAddr {
+	if err := mab.validateID(p); err != nil {
+		return nil
+	}
+
+	s := mab.segments.get(p)
+	s.RLock()
+	defer s.RUnlock()
+	return mab.expiringAddrsUnlocked(s, p)
+}
+
+// expiringAddrsUnlocked returns the valid addresses of a peer, along with their TTLs and expiry times. To be called
+// with the lock of s held.
+func (mab *memoryAddrBook) expiringAddrsUnlocked(s *addrSegment, p peer.ID) []pstore.ExpiringAddr {
+	entries := validEntries(s.addrs[p], mab.validAt(p))
+	mab.sortEntries(entries)
+	res := make([]pstore.ExpiringAddr, len(entries))
+	for i, e := range entries {
+		res[i] = pstore.ExpiringAddr{Addr: e.Addr, TTL: e.TTL, Expires: e.Expires}
+	}
+	return res
+}
+
+// AddrsByRecency returns the valid addresses of a peer, most recently added or confirmed first.
+func (mab *memoryAddrBook) AddrsByRecency(p peer.ID) []pstore.RecentAddr {
+	if err := mab.validateID(p); err != nil {
+		return nil
+	}
+
+	s := mab.segments.get(p)
+	s.RLock()
+	defer s.RUnlock()
+
+	now := mab.validAt(p)
+	amap := s.addrs[p]
+	res := make([]pstore.RecentAddr, 0, len(amap))
+	for _, m := range amap {
+		if !m.ExpiredBy(now) {
+			res = append(res, pstore.RecentAddr{Addr: m.Addr, LastSeen: m.LastSeen})
+		}
+	}
+	pstore.SortByRecency(res)
+	return res
+}
+
+// ExpiringBefore returns the valid addresses of every peer that will expire before t.
+func (mab *memoryAddrBook) ExpiringBefore(t time.Time) map[peer.ID][]ma.Multiaddr {
+	res := make(map[peer.ID][]ma.Multiaddr)
+	for _, s := range mab.segments {
+		s.RLock()
+		for p, amap := range s.addrs {
+			now := mab.validAt(p)
+			var entries []*expiringAddr
+			for _, m := range amap {
+				if !m.ExpiredBy(now) && m.Expires.Before(t) {
+					entries = append(entries, m)
+				}
+			}
+			if len(entries) > 0 {
+				mab.sortEntries(entries)
+				addrs := make([]ma.Multiaddr, len(entries))
+				for i, e := range entries {
+					addrs[i] = e.Addr
+				}
+				res[p] = addrs
+			}
+		}
+		s.RUnlock()
+	}
+	return res
+}
+
+// validEntries returns the entries of amap that aren't expired by now.
+func validEntries(amap map[string]*expiringAddr, now time.Time) []*expiringAddr {
+	res := make([]*expiringAddr, 0, len(amap))
+	for _, m := range amap {
+		if !m.ExpiredBy(now) {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// sortEntries sorts the entries of a peer in the order set by WithAddrOrder, or else in the order derived from the
+// seed of WithDeterminism. Without either, entries are left in map iteration order.
+func (mab *memoryAddrBook) sortEntries(entries []*expiringAddr) {
+	if mab.addrOrder != pstore.AddrOrderNone {
+		sort.Slice(entries, func(i, j int) bool {
+			return mab.addrOrder.Less(entries[i].Addr, entries[i].Added, entries[j].Addr, entries[j].Added)
+		})
+		return
+	}
+	if mab.order != nil {
+		mab.order.sort(len(entries), func(i int) []byte { return entries[i].Addr.Bytes() }, func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+	}
+}
+
+// nextAdded returns the position in the insertion order of the next address added to amap.
+func nextAdded(amap map[string]*expiringAddr) uint64 {
+	var next uint64
+	for _, a := range amap {
+		if a.Added >= next {
+			next = a.Added + 1
+		}
+	}
+	return next
+}
+
+func validAddrs(amap map[string]*expiringAddr, now time.Time) []ma.Multiaddr {
 	good := make([]ma.Multiaddr, 0, len(amap))
 	if amap == nil {
 		return good
@@ -364,7 +848,7 @@ func validAddrs(amap map[string]*expiringAddr) []ma.Multiaddr {
 // given peer id, if one exists.
 // Returns nil if no signed PeerRecord exists for the peer.
 func (mab *memoryAddrBook) GetPeerRecord(p peer.ID) *record.Envelope {
-	if err := p.Validate(); err != nil {
+	if err := mab.validateID(p); err != nil {
 		// invalid peer ID = no addrs
 		return nil
 	}
@@ -376,7 +860,7 @@ func (mab *memoryAddrBook) GetPeerRecord(p peer.ID) *record.Envelope {
 	// although the signed record gets garbage collected when all addrs inside it are expired,
 	// we may be in between the expiration time and the GC interval
 	// so, we check to see if we have any valid signed addrs before returning the record
-	if len(validAddrs(s.addrs[p])) == 0 {
+	if len(validAddrs(s.addrs[p], mab.validAt(p))) == 0 {
 		return nil
 	}
 
@@ -389,24 +873,56 @@ func (mab *memoryAddrBook) GetPeerRecord(p peer.ID) *record.Envelope {
 
 // ClearAddrs removes all previously stored addresses
 func (mab *memoryAddrBook) ClearAddrs(p peer.ID) {
-	if err := p.Validate(); err != nil {
-		// nothing to clear
-		return
+	// an invalid peer has nothing to clear.
+	_ = mab.ClearAddrsE(p)
+}
+
+// ClearAddrsE is like ClearAddrs, but returns an error if the peer ID is invalid.
+func (mab *memoryAddrBook) ClearAddrsE(p peer.ID) error {
+	if err := mab.validateID(p); err != nil {
+		return err
 	}
 
 	s := mab.segments.get(p)
 	s.Lock()
 	defer s.Unlock()
 
-	delete(s.addrs, p)
-	delete(s.signedPeerRecords, p)
+	if mab.churn != nil {
+		now, validAt := mab.clock.Now(), mab.validAt(p)
+		for _, a := range s.addrs[p] {
+			mab.recordDropUnlocked(p, a, validAt, now)
+		}
+	}
+	mab.limiter.add(-len(s.addrs[p]))
+	s.deletePeerUnlocked(p)
+	s.deleteRecordUnlocked(p)
+	mab.autoVacuumUnlocked(s)
+	mab.publishUnlocked(s, p)
+	mab.gens.Bump(p)
+	mab.debouncer.Forget(p)
+	return nil
+}
+
+// recordDropUnlocked records the removal of an address at now, or its expiry if it had expired by validAt. To be called
+// with the segment locked.
+func (mab *memoryAddrBook) recordDropUnlocked(p peer.ID, a *expiringAddr, validAt, now time.Time) {
+	if a.ExpiredBy(validAt) {
+		mab.churn.Record(p, a.Addr, pstore.AddrExpired, a.Expires)
+	} else {
+		mab.churn.Record(p, a.Addr, pstore.AddrRemoved, now)
+	}
+}
+
+// AddrHistory returns the address events of a peer timed at or after since, oldest first, if WithAddrHistory is set.
+func (mab *memoryAddrBook) AddrHistory(p peer.ID, since time.Time) []pstore.AddrEvent {
+	return mab.churn.History(p, since)
 }
 
 // AddrStream returns a channel on which all new addresses discovered for a
 // given peer ID will be published.
 func (mab *memoryAddrBook) AddrStream(ctx context.Context, p peer.ID) <-chan ma.Multiaddr {
-	if err := p.Validate(); err != nil {
-		log.Warningf("tried to get addrs for invalid peer ID %s: %s", p, err)
+	if err := mab.validateID(p); err != nil {
+		log.Warnf("tried to get addrs for invalid peer ID %s: %s", p, err)
 		ch := make(chan ma.Multiaddr)
 		close(ch)
 		return ch
@@ -430,6 +946,10 @@ type addrSub struct {
 	lk     sync.Mutex
 	buffer []ma.Multiaddr
 	ctx    context.Context
+
+	// mgr holds the *AddrSubManager the subscription is registered with. It only changes when the subscription is
+	// transplanted, with the lock of the old manager held.
+	mgr atomic.Value
 }
 
 func (s *addrSub) pubAddr(a ma.Multiaddr) {
@@ -454,11 +974,22 @@ func NewAddrSubManager() *AddrSubManager {
 }
 
 // Used internally by the address stream coroutine to remove a subscription
-// from the manager.
-func (mgr *AddrSubManager) removeSub(p peer.ID, s *addrSub) {
-	mgr.mu.Lock()
-	defer mgr.mu.Unlock()
+// from the manager it is registered with.
+func (s *addrSub) remove(p peer.ID) {
+	for {
+		mgr := s.mgr.Load().(*AddrSubManager)
+		mgr.mu.Lock()
+		// the subscription may have been transplanted before we got the lock.
+		if s.mgr.Load().(*AddrSubManager) == mgr {
+			mgr.removeSubUnlocked(p, s)
+			mgr.mu.Unlock()
+			return
+		}
+		mgr.mu.Unlock()
+	}
+}
 
+func (mgr *AddrSubManager) removeSubUnlocked(p peer.ID, s *addrSub) {
 	subs := mgr.subs[p]
 	if len(subs) == 1 {
 		if subs[0] != s {
@@ -494,6 +1025,7 @@ func (mgr *AddrSubManager) BroadcastAddr(p peer.ID, addr ma.Multiaddr) {
 // channel with any addresses we might already have on file.
 func (mgr *AddrSubManager) AddrStream(ctx context.Context, p peer.ID, initial []ma.Multiaddr) <-chan ma.Multiaddr {
 	sub := &addrSub{pubch: make(chan ma.Multiaddr), ctx: ctx}
+	sub.mgr.Store(mgr)
 	out := make(chan ma.Multiaddr)
 
 	mgr.mu.Lock()
@@ -546,7 +1078,7 @@ func (mgr *AddrSubManager) AddrStream(ctx context.Context, p peer.ID, initial []
 					buffer = append(buffer, naddr)
 				}
 			case <-ctx.Done():
-				mgr.removeSub(p, sub)
+				sub.remove(p)
 				return
 			}
 		}
@@ -555,3 +1087,70 @@ func (mgr *AddrSubManager) AddrStream(ctx context.Context, p peer.ID, initial []
 
 	return out
 }
+
+// transplantLk serializes transplants, so that concurrent transplants between the same managers can't deadlock.
+var transplantLk sync.Mutex
+
+// Transplant moves all the subscriptions of the manager to dst: the streams returned by AddrStream keep running, and
+// receive the addresses broadcast by dst from then on, instead of those broadcast by mgr. To make sure streams don't
+// miss updates made around the transplant, addrs is called for every subscribed peer after the move, and the
+// addresses it returns are broadcast by dst; streams skip those they have already sent.
+func (mgr *AddrSubManager) Transplant(dst *AddrSubManager, addrs func(peer.ID) []ma.Multiaddr) {
+	if mgr == dst {
+		return
+	}
+
+	transplantLk.Lock()
+	mgr.mu.Lock()
+	dst.mu.Lock()
+	peers := make([]peer.ID, 0, len(mgr.subs))
+	for p, subs := range mgr.subs {
+		for _, sub := range subs {
+			sub.mgr.Store(dst)
+		}
+		dst.subs[p] = append(dst.subs[p], subs...)
+		peers = append(peers, p)
+	}
+	mgr.subs = make(map[peer.ID][]*addrSub)
+	dst.mu.Unlock()
+	mgr.mu.Unlock()
+	transplantLk.Unlock()
+
+	if addrs == nil {
+		return
+	}
+	for _, p := range peers {
+		for _, a := range addrs(p) {
+			dst.BroadcastAddr(p, a)
+		}
+	}
+}
+
+// AddrSubProvider is implemented by the address books publishing address streams through an AddrSubManager, such as
+// those of this package and of pstoreds.
+type AddrSubProvider interface {
+	AddrSubManager() *AddrSubManager
+}
+
+var _ AddrSubProvider = (*memoryAddrBook)(nil)
+
+// AddrSubManager returns the manager of the address streams of the address book.
+func (mab *memoryAddrBook) AddrSubManager() *AddrSubManager {
+	return mab.subManager
+}
+
+// TransplantAddrSubs moves the address streams of from to to, e.g. when the address book of a node is swapped at
+// runtime, so that long-lived consumers of AddrStream keep receiving updates. The current addresses of to are sent on
+// the streams, except those already sent. Both address books must implement AddrSubProvider.
+func TransplantAddrSubs(from, to peerstore.AddrBook) error {
+	src, ok := from.(AddrSubProvider)
+	if !ok {
+		return fmt.Errorf("address book %T does not expose its address subscriptions", from)
+	}
+	dst, ok := to.(AddrSubProvider)
+	if !ok {
+		return fmt.Errorf("address book %T does not expose its address subscriptions", to)
+	}
+	src.AddrSubManager().Transplant(dst.AddrSubManager(), to.Addrs)
+	return nil
+}
@@ -0,0 +1,23 @@
+package pstoremem
+
+import (
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// auditor records audit events to an optional sink.
+type auditor struct {
+	sink  pstore.AuditSink
+	clock pstore.Clock
+}
+
+func newAuditor(o *options) auditor {
+	return auditor{sink: o.auditSink, clock: o.clock}
+}
+
+func (a auditor) audit(e pstore.AuditEvent) {
+	if a.sink == nil {
+		return
+	}
+	e.Time = a.clock.Now()
+	a.sink.Audit(e)
+}
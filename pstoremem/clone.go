@@ -0,0 +1,47 @@
+package pstoremem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// Clone returns an independent copy of the peerstore, created with the same options. Everything a snapshot holds is
+// copied (see WriteSnapshot), along with the protection tags; latency histograms and address streams are not.
+// Metadata values are copied by gob-encoding them, so their types must be registered with gob, and the copy fails if
+// any of them can't be.
+func (ps *pstoremem) Clone(ctx context.Context) (peerstore.Peerstore, error) {
+	var buf bytes.Buffer
+	if err := ps.WriteSnapshot(&ctxWriter{ctx: ctx, w: &buf}); err != nil {
+		return nil, err
+	}
+
+	clone := NewPeerstore(ps.opts...)
+	// protect peers first, so that they retain their expired addresses.
+	ps.memoryAddrBook.ProtectManager.CopyTo(clone.memoryAddrBook.ProtectManager)
+	skipped, err := clone.RestoreSnapshot(&buf)
+	if err == nil && skipped > 0 {
+		err = fmt.Errorf("failed to copy %d items", skipped)
+	}
+	if err != nil {
+		clone.Close()
+		return nil, err
+	}
+	return clone, nil
+}
+
+// ctxWriter fails writes once its context is done.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw *ctxWriter) Write(b []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(b)
+}
@@ -0,0 +1,83 @@
+package pstoremem
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ordering sorts the results of the books in an order derived from a seed, so that the same seed and the same
+// contents yield the same order. A nil ordering leaves results in map iteration order.
+type ordering struct {
+	seed [8]byte
+}
+
+func newOrdering(o *options) *ordering {
+	if !o.deterministic {
+		return nil
+	}
+	ord := new(ordering)
+	binary.BigEndian.PutUint64(ord.seed[:], uint64(o.seed))
+	return ord
+}
+
+func (o *ordering) rank(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(o.seed[:])
+	h.Write(b)
+	return h.Sum64()
+}
+
+// sort sorts n items by rank, ties broken by their bytes.
+func (o *ordering) sort(n int, key func(i int) []byte, swap func(i, j int)) {
+	ranks := make([]uint64, n)
+	for i := range ranks {
+		ranks[i] = o.rank(key(i))
+	}
+	sort.Sort(&rankSorter{ranks: ranks, key: key, swap: swap})
+}
+
+func (o *ordering) peers(ids peer.IDSlice) {
+	if o == nil {
+		return
+	}
+	o.sort(len(ids), func(i int) []byte { return []byte(ids[i]) }, func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+}
+
+func (o *ordering) addrs(addrs []ma.Multiaddr) {
+	if o == nil {
+		return
+	}
+	o.sort(len(addrs), func(i int) []byte { return addrs[i].Bytes() }, func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+}
+
+func (o *ordering) strings(ss []string) {
+	if o == nil {
+		return
+	}
+	o.sort(len(ss), func(i int) []byte { return []byte(ss[i]) }, func(i, j int) { ss[i], ss[j] = ss[j], ss[i] })
+}
+
+type rankSorter struct {
+	ranks []uint64
+	key   func(i int) []byte
+	swap  func(i, j int)
+}
+
+func (s *rankSorter) Len() int { return len(s.ranks) }
+
+func (s *rankSorter) Less(i, j int) bool {
+	if s.ranks[i] != s.ranks[j] {
+		return s.ranks[i] < s.ranks[j]
+	}
+	return bytes.Compare(s.key(i), s.key(j)) < 0
+}
+
+func (s *rankSorter) Swap(i, j int) {
+	s.ranks[i], s.ranks[j] = s.ranks[j], s.ranks[i]
+	s.swap(i, j)
+}
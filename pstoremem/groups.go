@@ -0,0 +1,125 @@
+package pstoremem
+
+import (
+	"sort"
+	"sync"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// memoryGroupBook holds group memberships, indexed both by group and by peer.
+type memoryGroupBook struct {
+	mu      sync.RWMutex
+	members map[string]map[peer.ID]struct{}
+	groups  map[peer.ID]map[string]struct{}
+
+	order      *ordering
+	validateID peerstore.IDValidator
+	gens       *peerstore.Generations // set by NewPeerstore.
+}
+
+var _ peerstore.GroupBook = (*memoryGroupBook)(nil)
+
+// NewGroupBook creates an in-memory group book. It accepts the WithDeterminism and WithIDValidator options.
+func NewGroupBook(opts ...Option) *memoryGroupBook {
+	o := newOptions(opts)
+	return &memoryGroupBook{
+		members:    make(map[string]map[peer.ID]struct{}),
+		groups:     make(map[peer.ID]map[string]struct{}),
+		order:      newOrdering(o),
+		validateID: o.validateID,
+	}
+}
+
+func (gb *memoryGroupBook) AddToGroup(p peer.ID, group string) error {
+	if err := gb.validateID(p); err != nil {
+		return err
+	}
+	if group == "" {
+		return peerstore.ErrEmptyGroup
+	}
+
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+
+	members, ok := gb.members[group]
+	if !ok {
+		members = make(map[peer.ID]struct{})
+		gb.members[group] = members
+	}
+	members[p] = struct{}{}
+
+	groups, ok := gb.groups[p]
+	if !ok {
+		groups = make(map[string]struct{})
+		gb.groups[p] = groups
+	}
+	groups[group] = struct{}{}
+	gb.gens.Bump(p)
+	return nil
+}
+
+func (gb *memoryGroupBook) RemoveFromGroup(p peer.ID, group string) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if group == "" {
+		return peerstore.ErrEmptyGroup
+	}
+
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+
+	if members, ok := gb.members[group]; ok {
+		delete(members, p)
+		if len(members) == 0 {
+			delete(gb.members, group)
+		}
+	}
+	if groups, ok := gb.groups[p]; ok {
+		delete(groups, group)
+		if len(groups) == 0 {
+			delete(gb.groups, p)
+		}
+	}
+	gb.gens.Bump(p)
+	return nil
+}
+
+func (gb *memoryGroupBook) Groups(p peer.ID) []string {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+
+	res := make([]string, 0, len(gb.groups[p]))
+	for g := range gb.groups[p] {
+		res = append(res, g)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (gb *memoryGroupBook) GroupMembers(group string) peer.IDSlice {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+
+	res := make(peer.IDSlice, 0, len(gb.members[group]))
+	for p := range gb.members[group] {
+		res = append(res, p)
+	}
+	gb.order.peers(res)
+	return res
+}
+
+// peers returns the peers that are members of any group.
+func (gb *memoryGroupBook) peers() peer.IDSlice {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+
+	pids := make(peer.IDSlice, 0, len(gb.groups))
+	for p := range gb.groups {
+		pids = append(pids, p)
+	}
+	return pids
+}
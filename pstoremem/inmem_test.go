@@ -1,10 +1,26 @@
 package pstoremem
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
 	"testing"
+	"time"
 
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/addr"
 	pt "github.com/libp2p/go-libp2p-peerstore/test"
+	ma "github.com/multiformats/go-multiaddr"
 
 	"go.uber.org/goleak"
 )
@@ -18,6 +34,33 @@ func TestFuzzInMemoryPeerstore(t *testing.T) {
 	}
 }
 
+// depsOptions returns the options wiring an address book or peerstore to the dependencies injected by the suites.
+func depsOptions(deps *pt.Deps) []Option {
+	c := deps.Config
+	opts := []Option{
+		WithClock(deps.Clock),
+		WithTTLJitter(c.TTLJitter),
+		WithMaxAddrTTL(c.MaxAddrTTL),
+		WithMaxAddrLen(c.MaxAddrLen),
+		WithAddrOrder(c.AddrOrder),
+		WithAddrHistory(c.AddrHistory),
+		WithExoticAddrPolicy(c.ExoticAddrPolicy),
+		WithP2PAddrPolicy(c.P2PAddrPolicy),
+		WithAuditSink(c.AuditSink),
+		WithChangeLog(c.ChangeLog),
+	}
+	if c.TTLPolicy != nil {
+		opts = append(opts, WithTTLPolicy(c.TTLPolicy))
+	}
+	if c.IDValidator != nil {
+		opts = append(opts, WithIDValidator(c.IDValidator))
+	}
+	if c.AddrAliases {
+		opts = append(opts, WithAddrAliases())
+	}
+	return opts
+}
+
 func TestInMemoryPeerstore(t *testing.T) {
 	pt.TestPeerstore(t, func() (pstore.Peerstore, func()) {
 		ps := NewPeerstore()
@@ -25,13 +68,192 @@ func TestInMemoryPeerstore(t *testing.T) {
 	})
 }
 
-func TestInMemoryAddrBook(t *testing.T) {
-	pt.TestAddrBook(t, func() (pstore.AddrBook, func()) {
+func TestInMemoryClone(t *testing.T) {
+	pt.TestClone(t, func() (pstore.Peerstore, func()) {
 		ps := NewPeerstore()
 		return ps, func() { ps.Close() }
 	})
 }
 
+func TestInMemoryAddrBook(t *testing.T) {
+	pt.TestAddrBookWithDeps(t, func(deps *pt.Deps) (pstore.AddrBook, func()) {
+		ps := NewPeerstore(depsOptions(deps)...)
+		return ps, func() { ps.Close() }
+	})
+}
+
+func TestInMemoryReadMostly(t *testing.T) {
+	t.Run("AddrBook", func(t *testing.T) {
+		pt.TestAddrBookWithDeps(t, func(deps *pt.Deps) (pstore.AddrBook, func()) {
+			ps := NewPeerstore(append(depsOptions(deps), WithReadMostly())...)
+			return ps, func() { ps.Close() }
+		})
+	})
+	t.Run("Peerstore", func(t *testing.T) {
+		pt.TestPeerstoreWithDeps(t, func(deps *pt.Deps) (pstore.Peerstore, func()) {
+			ps := NewPeerstore(append(depsOptions(deps), WithReadMostly())...)
+			return ps, func() { ps.Close() }
+		})
+	})
+}
+
+func TestReadMostlyConcurrentReads(t *testing.T) {
+	clock := pt.NewMockClock()
+	ps := NewPeerstore(WithClock(clock), WithReadMostly())
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(8)
+	addrs := pt.GenerateAddrs(4)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			p := ids[i%len(ids)]
+			ps.AddAddrs(p, addrs, time.Hour)
+			ps.SetAddr(p, addrs[i%len(addrs)], 0)
+			if i%10 == 0 {
+				ps.ClearAddrs(p)
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		for _, p := range ps.Peers() {
+			if got := ps.Addrs(p); len(got) > len(addrs) {
+				t.Fatalf("read %d addresses for peer %s", len(got), p)
+			}
+		}
+	}
+	<-done
+	if err := ps.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	// protected peers retain their expired addresses; others don't.
+	for _, p := range ids[:2] {
+		ps.ClearAddrs(p)
+		ps.AddAddrs(p, addrs[:1], time.Minute)
+	}
+	ps.Protect(ids[0], "test")
+	clock.Add(2 * time.Minute)
+	if !ps.HasAddrs(ids[0]) || len(ps.Addrs(ids[0])) == 0 {
+		t.Fatal("expected the protected peer to retain its expired addresses")
+	}
+	if ps.HasAddrs(ids[1]) || len(ps.Addrs(ids[1])) != 0 {
+		t.Fatal("expected the expired addresses of an unprotected peer to be hidden")
+	}
+}
+
+func TestSegmentView(t *testing.T) {
+	ids := pt.GeneratePeerIDs(300)
+	addrs := pt.GenerateAddrs(1)
+	hashes := map[string]func(peer.ID) uint64{
+		"FNV": viewHash,
+		// hashes differing in their first and last bits only, to exercise deep tries.
+		"Deep":      func(p peer.ID) uint64 { return viewHash(p) & 0xf00000000000001f },
+		"Colliding": func(peer.ID) uint64 { return 42 },
+	}
+	for name, hash := range hashes {
+		t.Run(name, func(t *testing.T) {
+			var view segmentView
+			model := make(map[peer.ID][]expiringAddr)
+			check := func(view segmentView, model map[peer.ID][]expiringAddr) {
+				t.Helper()
+				if view.len != len(model) {
+					t.Fatalf("expected %d peers, got %d", len(model), view.len)
+				}
+				for _, p := range ids {
+					if got, want := view.getHash(hash(p), p), model[p]; !reflect.DeepEqual(got, want) {
+						t.Fatalf("expected %v for peer %s, got %v", want, p, got)
+					}
+				}
+				seen := make(map[peer.ID]bool)
+				view.forEach(func(p peer.ID, got []expiringAddr) {
+					if seen[p] || !reflect.DeepEqual(got, model[p]) {
+						t.Fatalf("unexpected entry %v for peer %s", got, p)
+					}
+					seen[p] = true
+				})
+			}
+
+			for i := 0; i < 3000; i++ {
+				p := ids[(i*7919)%len(ids)]
+				var value []expiringAddr
+				if i%3 != 0 {
+					value = []expiringAddr{{Addr: addrs[0], Expires: time.Unix(int64(i), 0)}}
+				}
+
+				// updates leave the view they're applied to untouched.
+				before := make(map[peer.ID][]expiringAddr, len(model))
+				for q, v := range model {
+					before[q] = v
+				}
+				next := view.withHash(hash(p), p, value)
+				if i%100 == 0 {
+					check(view, before)
+				}
+				if value != nil {
+					model[p] = value
+				} else {
+					delete(model, p)
+				}
+				view = next
+				if i%100 == 0 {
+					check(view, model)
+				}
+			}
+			for _, p := range ids {
+				view = view.withHash(hash(p), p, nil)
+			}
+			if view.root != nil || view.len != 0 {
+				t.Fatalf("expected empty view, got %d peers", view.len)
+			}
+		})
+	}
+}
+
+func TestInMemoryAddrBookDebounced(t *testing.T) {
+	pt.TestAddrBookWithDeps(t, func(deps *pt.Deps) (pstore.AddrBook, func()) {
+		ab := NewAddrBook(append(depsOptions(deps), WithAddrDebounce(100*time.Millisecond))...)
+		return ab, func() { ab.Close() }
+	})
+}
+
+func TestInMemoryStrictChecks(t *testing.T) {
+	pt.TestPeerstore(t, func() (pstore.Peerstore, func()) {
+		ps := NewPeerstore(WithStrictChecks())
+		return ps, func() { ps.Close() }
+	})
+	pt.TestAddrBookWithDeps(t, func(deps *pt.Deps) (pstore.AddrBook, func()) {
+		ps := NewPeerstore(append(depsOptions(deps), WithStrictChecks())...)
+		return ps, func() { ps.Close() }
+	})
+
+	ps := NewPeerstore(WithStrictChecks())
+	defer ps.Close()
+	p, addrs := pt.GeneratePeerIDs(1)[0], pt.GenerateAddrs(1)
+
+	// rejected calls store nothing.
+	if err := ps.AddAddrsE(p, []ma.Multiaddr{addrs[0], nil}, time.Hour); !errors.Is(err, peerstore.ErrInvalidArgument) {
+		t.Fatalf("expected nil addresses to be rejected, got %v", err)
+	}
+	if err := ps.Put(p, "", "value"); !errors.Is(err, peerstore.ErrInvalidArgument) {
+		t.Fatalf("expected empty metadata keys to be rejected, got %v", err)
+	}
+	if err := ps.AddProtocols(p, ""); !errors.Is(err, peerstore.ErrInvalidArgument) {
+		t.Fatalf("expected empty protocols to be rejected, got %v", err)
+	}
+	if ps.Known(p) {
+		t.Fatal("expected rejected calls to store nothing")
+	}
+}
+
+func TestInMemoryPeerstoreWithClock(t *testing.T) {
+	pt.TestPeerstoreWithDeps(t, func(deps *pt.Deps) (pstore.Peerstore, func()) {
+		ps := NewPeerstore(depsOptions(deps)...)
+		return ps, func() { ps.Close() }
+	})
+}
+
 func TestInMemoryKeyBook(t *testing.T) {
 	pt.TestKeyBook(t, func() (pstore.KeyBook, func()) {
 		ps := NewPeerstore()
@@ -39,6 +261,61 @@ func TestInMemoryKeyBook(t *testing.T) {
 	})
 }
 
+func TestAddrLimits(t *testing.T) {
+	var events []AddrLimitEvent
+	ab := NewAddrBook(WithAddrLimits(AddrLimits{
+		Soft:           3,
+		Hard:           5,
+		Notify:         func(e AddrLimitEvent) { events = append(events, e) },
+		RejectNewPeers: true,
+	}))
+	defer ab.Close()
+
+	ids := pt.GeneratePeerIDs(4)
+	addrs := pt.GenerateAddrs(6)
+
+	ab.AddAddrs(ids[0], addrs[:2], time.Hour)
+	if len(events) != 0 {
+		t.Fatalf("expected no events below the soft threshold, got %v", events)
+	}
+
+	ab.AddAddrs(ids[1], addrs[2:3], time.Hour)
+	expectEvent := func(e AddrLimitEvent) {
+		t.Helper()
+		if len(events) == 0 || events[len(events)-1] != e {
+			t.Fatalf("expected event %+v, got %v", e, events)
+		}
+	}
+	expectEvent(AddrLimitEvent{Level: SoftLimit, Total: 3, Exceeded: true})
+
+	ab.SetAddrs(ids[1], addrs[3:5], time.Hour)
+	expectEvent(AddrLimitEvent{Level: HardLimit, Total: 5, Exceeded: true})
+	if n := ab.AddrCount(); n != 5 {
+		t.Fatalf("expected 5 addresses, got %d", n)
+	}
+
+	// new peers are rejected while full, known peers aren't.
+	ab.AddAddrs(ids[2], addrs[5:], time.Hour)
+	if len(ab.Addrs(ids[2])) != 0 {
+		t.Error("expected addresses for new peer to be rejected")
+	}
+	ab.AddAddrs(ids[0], addrs[5:], time.Hour)
+	if len(ab.Addrs(ids[0])) != 3 {
+		t.Error("expected addresses for known peer to be accepted")
+	}
+
+	ab.ClearAddrs(ids[0])
+	expectEvent(AddrLimitEvent{Level: HardLimit, Total: 3, Exceeded: false})
+	ab.SetAddr(ids[1], addrs[2], -1)
+	expectEvent(AddrLimitEvent{Level: SoftLimit, Total: 2, Exceeded: false})
+
+	// hard threshold recovered; the new peer is accepted now.
+	ab.AddAddrs(ids[3], addrs[5:], time.Hour)
+	if len(ab.Addrs(ids[3])) != 1 {
+		t.Error("expected addresses for new peer to be accepted")
+	}
+}
+
 func BenchmarkInMemoryPeerstore(b *testing.B) {
 	pt.BenchmarkPeerstore(b, func() (pstore.Peerstore, func()) {
 		ps := NewPeerstore()
@@ -46,6 +323,42 @@ func BenchmarkInMemoryPeerstore(b *testing.B) {
 	}, "InMem")
 }
 
+func BenchmarkInMemoryPeerstoreReadMostly(b *testing.B) {
+	pt.BenchmarkPeerstore(b, func() (pstore.Peerstore, func()) {
+		ps := NewPeerstore(WithReadMostly())
+		return ps, func() { ps.Close() }
+	}, "InMemReadMostly")
+}
+
+// BenchmarkReadMostlyWrites measures the cost of publishing the view of a segment on writes, as the number of peers
+// in the address book grows.
+func BenchmarkReadMostlyWrites(b *testing.B) {
+	addrs := pt.GenerateAddrs(1)
+	for _, n := range []int{256, 4096, 65536} {
+		for _, readMostly := range []bool{false, true} {
+			name := fmt.Sprintf("Peers=%d", n)
+			var opts []Option
+			if readMostly {
+				name += "/ReadMostly"
+				opts = append(opts, WithReadMostly())
+			}
+			b.Run(name, func(b *testing.B) {
+				ab := NewAddrBook(opts...)
+				defer ab.Close()
+				ids := pt.GeneratePeerIDs(n)
+				for _, p := range ids {
+					ab.AddAddrs(p, addrs, time.Hour)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					ab.SetAddr(ids[i%n], addrs[0], time.Duration(i%2+1)*time.Hour)
+				}
+			})
+		}
+	}
+}
+
 func BenchmarkInMemoryKeyBook(b *testing.B) {
 	pt.BenchmarkKeyBook(b, func() (pstore.KeyBook, func()) {
 		ps := NewPeerstore()
@@ -53,9 +366,529 @@ func BenchmarkInMemoryKeyBook(b *testing.B) {
 	})
 }
 
+func TestReconfigure(t *testing.T) {
+	ps := NewPeerstore()
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(3)
+	addrs := pt.GenerateAddrs(5)
+	ps.AddAddrs(ids[0], addrs[:4], time.Hour)
+
+	var events []AddrLimitEvent
+	limits := AddrLimits{
+		Hard:           3,
+		Notify:         func(e AddrLimitEvent) { events = append(events, e) },
+		RejectNewPeers: true,
+	}
+	ps.Reconfigure(WithAddrLimits(limits))
+	if len(events) != 1 || events[0] != (AddrLimitEvent{Level: HardLimit, Total: 4, Exceeded: true}) {
+		t.Fatalf("expected the new hard limit to be reported exceeded, got %v", events)
+	}
+	if err := ps.AddAddrsE(ids[1], addrs[4:], time.Hour); err != ErrAddrBookFull {
+		t.Fatalf("expected ErrAddrBookFull, got %v", err)
+	}
+
+	// options that aren't passed keep their value.
+	ps.Reconfigure(WithMaxMetadataValueSize(2))
+	if err := ps.AddAddrsE(ids[1], addrs[4:], time.Hour); err != ErrAddrBookFull {
+		t.Fatalf("expected ErrAddrBookFull, got %v", err)
+	}
+	if err := ps.Put(ids[0], "key", "abc"); err != peerstore.ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+
+	limits.Hard = 10
+	ps.Reconfigure(WithAddrLimits(limits))
+	if len(events) != 2 || events[1] != (AddrLimitEvent{Level: HardLimit, Total: 4, Exceeded: false}) {
+		t.Fatalf("expected the raised hard limit to be reported cleared, got %v", events)
+	}
+	if err := ps.AddAddrsE(ids[2], addrs[4:], time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZeroizeOnRemove(t *testing.T) {
+	kb := NewKeyBook(WithZeroizeOnRemove())
+	sk, _, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// re-adding the same key doesn't wipe it.
+	if err := kb.AddPrivKey(id, sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := kb.AddPrivKey(id, sk); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := sk.Raw()
+	if bytes.Equal(raw[:32], make([]byte, 32)) {
+		t.Fatal("expected key re-added to be kept intact")
+	}
+
+	kb.RemovePeer(id)
+	raw, _ = sk.Raw()
+	if !bytes.Equal(raw[:32], make([]byte, 32)) {
+		t.Fatal("expected removed key to be zeroized")
+	}
+}
+
+func TestPeerGC(t *testing.T) {
+	ps := NewPeerstore(WithPeerGC(10 * time.Millisecond))
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(2)
+	addrs := pt.GenerateAddrs(1)
+
+	// peers with only metadata or protocols are collected too.
+	if err := ps.Put(ids[0], "AgentVersion", "test"); err != nil {
+		t.Fatal(err)
+	}
+	ps.AddAddrs(ids[1], addrs, time.Hour)
+	if err := ps.SetProtocols(ids[1], "/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := ps.Get(ids[0], "AgentVersion"); err == pstore.ErrNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected peer without addresses to be collected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if protos, _ := ps.GetProtocols(ids[1]); len(protos) != 1 {
+		t.Fatalf("expected peer with addresses to be retained, got protocols %v", protos)
+	}
+}
+
+func TestMetadataValueSize(t *testing.T) {
+	pm := NewPeerMetadata(WithMaxMetadataValueSize(4))
+	id := pt.GeneratePeerIDs(1)[0]
+
+	if err := pm.Put(id, "small", "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Put(id, "large", []byte("abcde")); err != peerstore.ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if _, err := pm.Get(id, "large"); err != pstore.ErrNotFound {
+		t.Fatalf("expected oversized value not to be stored, got %v", err)
+	}
+}
+
+func TestDeterminism(t *testing.T) {
+	ids := pt.GeneratePeerIDs(20)
+	addrs := pt.GenerateAddrs(10)
+	protos := []string{"/a", "/b", "/c", "/d", "/e"}
+
+	build := func(seed int64, reverse bool) (*pstoremem, *pt.MockClock) {
+		clock := pt.NewMockClock()
+		ps := NewPeerstore(WithDeterminism(seed), WithClock(clock))
+		for i := range ids {
+			if reverse {
+				i = len(ids) - 1 - i
+			}
+			ps.AddAddrs(ids[i], addrs, time.Minute)
+			if err := ps.SetProtocols(ids[i], protos...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return ps, clock
+	}
+
+	goroutines := runtime.NumGoroutine()
+	ps1, clock := build(42, false)
+	defer ps1.Close()
+	if n := runtime.NumGoroutine(); n != goroutines {
+		t.Fatalf("expected no background goroutines, got %d more", n-goroutines)
+	}
+	ps2, _ := build(42, true)
+	defer ps2.Close()
+	ps3, _ := build(43, false)
+	defer ps3.Close()
+
+	// the same seed yields the same order, whatever the order of insertion.
+	if !reflect.DeepEqual(ps1.Peers(), ps2.Peers()) {
+		t.Fatal("expected the same order of peers for the same seed")
+	}
+	if !reflect.DeepEqual(ps1.Addrs(ids[0]), ps2.Addrs(ids[0])) {
+		t.Fatal("expected the same order of addresses for the same seed")
+	}
+	protos1, _ := ps1.GetProtocols(ids[0])
+	protos2, _ := ps2.GetProtocols(ids[0])
+	if !reflect.DeepEqual(protos1, protos2) {
+		t.Fatal("expected the same order of protocols for the same seed")
+	}
+	if reflect.DeepEqual(ps1.Peers(), ps3.Peers()) {
+		t.Fatal("expected another seed to yield another order of peers")
+	}
+
+	// expired addresses are collected on the first write an hour later.
+	clock.Add(time.Hour)
+	if n := ps1.AddrCount(); n != len(ids)*len(addrs) {
+		t.Fatalf("expected expired addresses to be held until the next write, got %d", n)
+	}
+	ps1.AddAddrs(ids[0], addrs[:1], time.Hour)
+	if n := ps1.AddrCount(); n != 1 {
+		t.Fatalf("expected expired addresses to be collected on write, got %d", n)
+	}
+}
+
+func TestTransplantAddrSubs(t *testing.T) {
+	from, to := NewAddrBook(), NewAddrBook()
+	defer from.Close()
+	defer to.Close()
+
+	id := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	from.AddAddr(id, addrs[0], time.Hour)
+	stream := from.AddrStream(ctx, id)
+	receive := func(want ma.Multiaddr) {
+		t.Helper()
+		select {
+		case a := <-stream:
+			if !a.Equal(want) {
+				t.Fatalf("expected %s, got %s", want, a)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s, got nothing", want)
+		}
+	}
+	receive(addrs[0])
+
+	// the addresses of the new book are sent on transplant, except those already sent.
+	to.AddAddrs(id, addrs[:2], time.Hour)
+	if err := TransplantAddrSubs(from, to); err != nil {
+		t.Fatal(err)
+	}
+	receive(addrs[1])
+
+	from.AddAddr(id, addrs[2], time.Hour)
+	to.AddAddr(id, addrs[3], time.Hour)
+	receive(addrs[3])
+
+	// the stream is closed once its subscription is removed.
+	cancel()
+	for range stream {
+	}
+	to.subManager.mu.RLock()
+	defer to.subManager.mu.RUnlock()
+	if len(to.subManager.subs) != 0 {
+		t.Fatal("expected the subscription to be removed from the new book")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	clock := pt.NewMockClock()
+	ps := NewPeerstore(WithClock(clock))
+	defer ps.Close()
+
+	sk, pk, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(3)
+
+	ps.AddAddrs(id, addrs[:2], time.Hour)
+	ps.AddAddr(id, addrs[2], time.Minute)
+	env, err := record.Seal(&peer.PeerRecord{PeerID: id, Addrs: addrs[:1], Seq: 1}, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.ConsumePeerRecord(env, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddPrivKey(id, sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetProtocols(id, "/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Put(id, "AgentVersion", "test/1.0"); err != nil {
+		t.Fatal(err)
+	}
+	ps.RecordLatency(id, 20*time.Millisecond)
+	// peers with protocols or metadata only are included.
+	if err := ps.Put(other, "n", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ps.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+
+	// the short-lived address expires before the snapshot is restored.
+	clock.Add(2 * time.Minute)
+	restored := NewPeerstore(WithClock(clock))
+	defer restored.Close()
+	if skipped, err := restored.RestoreSnapshot(bytes.NewReader(snapshot)); err != nil || skipped != 0 {
+		t.Fatalf("unexpected restore result: %d skipped, %v", skipped, err)
+	}
+
+	pt.AssertAddressesEqual(t, addrs[:2], restored.Addrs(id))
+	want := ps.AddrsWithExpiry(id)
+	got := restored.AddrsWithExpiry(id)
+	sort.Slice(want, func(i, j int) bool { return want[i].Addr.String() < want[j].Addr.String() })
+	sort.Slice(got, func(i, j int) bool { return got[i].Addr.String() < got[j].Addr.String() })
+	if len(got) != 2 || len(want) != 2 || !got[0].Expires.Equal(want[0].Expires) || got[0].TTL != want[0].TTL {
+		t.Fatalf("expected addresses to keep their expiry, got %v, want %v", got, want)
+	}
+	if rec := restored.GetPeerRecord(id); rec == nil || !rec.Equal(env) {
+		t.Fatal("expected the signed peer record to be restored")
+	}
+	if !restored.PrivKey(id).Equals(sk) || !restored.PubKey(id).Equals(pk) {
+		t.Fatal("expected the keys to be restored")
+	}
+	if protos, _ := restored.GetProtocols(id); len(protos) != 2 {
+		t.Fatalf("expected protocols to be restored, got %v", protos)
+	}
+	if v, err := restored.Get(id, "AgentVersion"); err != nil || v != "test/1.0" {
+		t.Fatalf("expected metadata to be restored, got %v, %v", v, err)
+	}
+	if v, err := restored.Get(other, "n"); err != nil || v != 42 {
+		t.Fatalf("expected metadata to be restored, got %v, %v", v, err)
+	}
+	if lat := restored.LatencyEWMA(id); lat != 20*time.Millisecond {
+		t.Fatalf("expected latency to be restored, got %s", lat)
+	}
+}
+
+func TestSnapshotPartialRestore(t *testing.T) {
+	ids := pt.GeneratePeerIDs(3)
+	addrs := pt.GenerateAddrs(1)
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(&snapshotHeader{Magic: snapshotMagic, Version: snapshotVersion}); err != nil {
+		t.Fatal(err)
+	}
+	peers := []snapshotPeer{
+		{ID: []byte(ids[0]), Protocols: []string{"/a"}, Metadata: map[string][]byte{"bad": []byte("garbage")}},
+		{ID: nil, Protocols: []string{"/b"}},
+		{ID: []byte(ids[1]), Addrs: []snapshotAddr{
+			{Addr: addrs[0].Bytes(), TTL: time.Hour, Expires: time.Now().Add(time.Hour)},
+			{Addr: []byte("garbage"), TTL: time.Hour, Expires: time.Now().Add(time.Hour)},
+		}},
+		{ID: []byte(ids[2]), Protocols: []string{"/c"}},
+	}
+	for i := range peers {
+		if err := enc.Encode(&peers[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	full := buf.Bytes()
+
+	ps := NewPeerstore()
+	defer ps.Close()
+	skipped, err := ps.RestoreSnapshot(bytes.NewReader(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 3 {
+		t.Fatalf("expected 3 items to be skipped, got %d", skipped)
+	}
+	if protos, _ := ps.GetProtocols(ids[0]); len(protos) != 1 {
+		t.Fatalf("expected the rest of the peer to be restored, got %v", protos)
+	}
+	pt.AssertAddressesEqual(t, addrs, ps.Addrs(ids[1]))
+
+	// peers read before a truncation remain restored.
+	truncated := NewPeerstore()
+	defer truncated.Close()
+	if _, err := truncated.RestoreSnapshot(bytes.NewReader(full[:len(full)-4])); err == nil {
+		t.Fatal("expected an error restoring a truncated snapshot")
+	}
+	pt.AssertAddressesEqual(t, addrs, truncated.Addrs(ids[1]))
+	if protos, _ := truncated.GetProtocols(ids[2]); len(protos) != 0 {
+		t.Fatalf("expected the truncated peer not to be restored, got %v", protos)
+	}
+
+	// newer versions are rejected.
+	buf.Reset()
+	if err := gob.NewEncoder(&buf).Encode(&snapshotHeader{Magic: snapshotMagic, Version: snapshotVersion + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.RestoreSnapshot(&buf); err == nil {
+		t.Fatal("expected an error restoring a newer snapshot version")
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	ab := NewAddrBook()
+	defer ab.Close()
+
+	id := pt.GeneratePeerIDs(1)[0]
+	ab.AddAddrs(id, pt.GenerateAddrs(2), time.Hour)
+	if err := ab.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	ab.limiter.add(1)
+	if err := ab.CheckInvariants(); err == nil {
+		t.Fatal("expected a mismatched address count to be reported")
+	}
+}
+
 func TestMain(m *testing.M) {
 	goleak.VerifyTestMain(
 		m,
 		goleak.IgnoreTopFunction("github.com/ipfs/go-log/writer.(*MirrorWriter).logRoutine"),
 	)
 }
+
+func TestAddrDebounceForgetsChangedPeers(t *testing.T) {
+	clock := pt.NewMockClock()
+	ab := NewAddrBook(WithClock(clock), WithAddrDebounce(time.Minute))
+	defer ab.Close()
+	id := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(1)
+
+	ab.AddAddrs(id, addrs, time.Hour)
+	ab.AddAddrs(id, addrs, time.Hour)
+	if n := ab.debouncer.Suppressed(); n != 1 {
+		t.Fatalf("expected the repeated addition to be suppressed, got %d suppressed", n)
+	}
+
+	// additions following a removal within the window go through.
+	ab.SetAddrs(id, addrs, 0)
+	ab.AddAddrs(id, addrs, time.Hour)
+	pt.AssertAddressesEqual(t, addrs, ab.Addrs(id))
+	ab.ClearAddrs(id)
+	ab.AddAddrs(id, addrs, time.Hour)
+	pt.AssertAddressesEqual(t, addrs, ab.Addrs(id))
+	ab.UpdateAddrs(id, time.Hour, 0)
+	ab.AddAddrs(id, addrs, time.Hour)
+	pt.AssertAddressesEqual(t, addrs, ab.Addrs(id))
+}
+
+func TestGeneratePeerstore(t *testing.T) {
+	generate := func() (*pstoremem, []peer.ID) {
+		ps := NewPeerstore()
+		ids, err := pt.GeneratePeerstore(ps, 300, 3, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ps, ids
+	}
+
+	ps, ids := generate()
+	defer ps.Close()
+	if len(ids) != 300 || len(ps.PeersWithAddrs()) != 300 {
+		t.Fatalf("expected 300 peers with addresses, got %d and %d", len(ids), len(ps.PeersWithAddrs()))
+	}
+
+	var withKeys int
+	transports := make(map[string]bool)
+	for _, id := range ids {
+		if len(ps.Addrs(id)) != 3 {
+			t.Fatalf("expected 3 addresses for peer %s, got %v", id, ps.Addrs(id))
+		}
+		for _, a := range ps.Addrs(id) {
+			transports[addr.Transport(a)] = true
+		}
+		if ps.PubKey(id) != nil {
+			withKeys++
+		}
+	}
+	if withKeys < 100 || withKeys > 200 {
+		t.Fatalf("expected about half of the peers to have keys, got %d", withKeys)
+	}
+	if len(transports) < 5 {
+		t.Fatalf("expected varied transports, got %v", transports)
+	}
+
+	// the same arguments generate the same dataset.
+	again, againIDs := generate()
+	defer again.Close()
+	if !reflect.DeepEqual(ids, againIDs) {
+		t.Fatal("expected the same peers on every call")
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	ps := NewPeerstore()
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(1000)
+	addrs := pt.GenerateAddrs(1)
+	for _, id := range ids {
+		ps.AddAddrs(id, addrs, time.Hour)
+		if err := ps.AddProtocols(id, "/proto/1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.Put(id, "key", "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := ps.Vacuum(); n != 0 {
+		t.Fatalf("expected nothing to reclaim before removing peers, got %d bytes", n)
+	}
+
+	for _, id := range ids[10:] {
+		ps.RemovePeer(id)
+	}
+	if n := ps.Vacuum(); n <= 0 {
+		t.Fatalf("expected memory to be reclaimed, got %d bytes", n)
+	}
+	if n := ps.Vacuum(); n != 0 {
+		t.Fatalf("expected nothing left to reclaim, got %d bytes", n)
+	}
+	for _, id := range ids[:10] {
+		pt.AssertAddressesEqual(t, addrs, ps.Addrs(id))
+		if v, err := ps.Get(id, "key"); err != nil || v != "value" {
+			t.Fatalf("expected the metadata of remaining peers to be kept, got %v (%v)", v, err)
+		}
+		if protos, err := ps.GetProtocols(id); err != nil || len(protos) != 1 {
+			t.Fatalf("expected the protocols of remaining peers to be kept, got %v (%v)", protos, err)
+		}
+	}
+}
+
+func TestAutoVacuum(t *testing.T) {
+	md := NewPeerMetadata(WithAutoVacuum(0.5))
+	ids := pt.GeneratePeerIDs(100)
+	for _, id := range ids {
+		if err := md.Put(id, "key", "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range ids[:80] {
+		md.RemovePeer(id)
+	}
+	// the map was reallocated once below 50 peers, and is left alone since, being too small.
+	if md.dsPeak != 49 {
+		t.Fatalf("expected the map to be reallocated at 49 peers, got a peak of %d", md.dsPeak)
+	}
+
+	// small maps are left alone.
+	md = NewPeerMetadata(WithAutoVacuum(0.5))
+	for _, id := range ids[:10] {
+		if err := md.Put(id, "key", "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range ids[:9] {
+		md.RemovePeer(id)
+	}
+	if md.dsPeak != 10 {
+		t.Fatalf("expected a small map to be left alone, got a peak of %d", md.dsPeak)
+	}
+}
@@ -0,0 +1,95 @@
+package pstoremem
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+var _ pstore.InvariantChecker = (*memoryAddrBook)(nil)
+var _ pstore.InvariantChecker = (*pstoremem)(nil)
+
+// CheckInvariants verifies that:
+//
+// * every peer is held by the segment it hashes to, and every address under the key of its encoding;
+// * the address count maintained for the limits matches the number of addresses held;
+// * in read-mostly mode, the published view of every segment holds the addresses of its peers;
+// * reads don't return expired addresses, except for protected peers, which retain them.
+func (mab *memoryAddrBook) CheckInvariants() error {
+	var total int
+	for i, s := range mab.segments {
+		s.RLock()
+		for p, amap := range s.addrs {
+			if mab.segments.get(p) != s {
+				s.RUnlock()
+				return fmt.Errorf("peer %s held by segment %d, which it doesn't hash to", p.Pretty(), i)
+			}
+			for k, a := range amap {
+				if a == nil || a.Addr == nil {
+					s.RUnlock()
+					return fmt.Errorf("nil address entry for peer %s", p.Pretty())
+				}
+				if k != string(a.Addr.Bytes()) {
+					s.RUnlock()
+					return fmt.Errorf("address %s of peer %s held under the key of another address", a.Addr, p.Pretty())
+				}
+			}
+			total += len(amap)
+		}
+		if err := mab.checkViewUnlocked(s); err != nil {
+			s.RUnlock()
+			return fmt.Errorf("segment %d: %s", i, err)
+		}
+		s.RUnlock()
+	}
+	if n := mab.limiter.count(); n != total {
+		return fmt.Errorf("address count is %d, but %d addresses are held", n, total)
+	}
+
+	now := mab.clock.Now()
+	for _, p := range mab.PeersWithAddrs() {
+		if mab.IsProtected(p, "") {
+			continue
+		}
+		for _, a := range mab.AddrsWithExpiry(p) {
+			if a.Expires.Before(now) {
+				return fmt.Errorf("expired address %s returned for peer %s", a.Addr, p.Pretty())
+			}
+		}
+	}
+	return nil
+}
+
+// checkViewUnlocked verifies that the published view of a segment holds the addresses it holds, in read-mostly mode.
+// To be called with the segment locked.
+func (mab *memoryAddrBook) checkViewUnlocked(s *addrSegment) error {
+	if !mab.readMostly {
+		return nil
+	}
+	view := s.loadView()
+	for p, amap := range s.addrs {
+		if len(amap) > 0 && len(view.get(p)) == 0 {
+			return fmt.Errorf("addresses of peer %s not published", p.Pretty())
+		}
+	}
+	var err error
+	view.forEach(func(p peer.ID, addrs []expiringAddr) {
+		if err != nil {
+			return
+		}
+		if len(addrs) != len(s.addrs[p]) {
+			err = fmt.Errorf("published %d addresses for peer %s, which has %d", len(addrs), p.Pretty(), len(s.addrs[p]))
+			return
+		}
+		for _, a := range addrs {
+			held, ok := s.addrs[p][string(a.Addr.Bytes())]
+			if !ok || !held.Expires.Equal(a.Expires) {
+				err = fmt.Errorf("stale address %s published for peer %s", a.Addr, p.Pretty())
+				return
+			}
+		}
+	})
+	return err
+}
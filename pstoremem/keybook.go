@@ -3,30 +3,64 @@ package pstoremem
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 
-	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
 type memoryKeyBook struct {
 	sync.RWMutex // same lock. wont happen a ton.
 	pks          map[peer.ID]ic.PubKey
 	sks          map[peer.ID]ic.PrivKey
+	order        *ordering
+	auditor      auditor
+	zeroize      bool
+
+	// in read-mostly mode, view holds the published keyView.
+	readMostly bool
+	view       atomic.Value
+
+	// peak lengths of pks and sks, see Vacuum.
+	pksPeak    mapPeak
+	sksPeak    mapPeak
+	autoVacuum float64
+
+	gens *pstore.Generations // set by NewPeerstore.
 }
 
-var _ pstore.KeyBook = (*memoryKeyBook)(nil)
+var (
+	_ peerstore.KeyBook     = (*memoryKeyBook)(nil)
+	_ pstore.KeyBookE       = (*memoryKeyBook)(nil)
+	_ pstore.KeyTypeCounter = (*memoryKeyBook)(nil)
+	_ pstore.KeyExporter    = (*memoryKeyBook)(nil)
+)
 
-// noop new, but in the future we may want to do some init work.
-func NewKeyBook() *memoryKeyBook {
-	return &memoryKeyBook{
-		pks: map[peer.ID]ic.PubKey{},
-		sks: map[peer.ID]ic.PrivKey{},
+// NewKeyBook creates an in-memory key book. It accepts the WithDeterminism, WithAuditSink, WithZeroizeOnRemove,
+// WithReadMostly and WithAutoVacuum options.
+func NewKeyBook(opts ...Option) *memoryKeyBook {
+	o := newOptions(opts)
+	kb := &memoryKeyBook{
+		pks:        map[peer.ID]ic.PubKey{},
+		sks:        map[peer.ID]ic.PrivKey{},
+		order:      newOrdering(o),
+		auditor:    newAuditor(o),
+		zeroize:    o.zeroize,
+		readMostly: o.readMostly,
+		autoVacuum: o.autoVacuum,
 	}
+	kb.view.Store(keyView{})
+	return kb
 }
 
 func (mkb *memoryKeyBook) PeersWithKeys() peer.IDSlice {
+	if mkb.readMostly {
+		return mkb.readPeersWithKeys()
+	}
 	mkb.RLock()
 	ps := make(peer.IDSlice, 0, len(mkb.pks)+len(mkb.sks))
 	for p := range mkb.pks {
@@ -38,34 +72,61 @@ func (mkb *memoryKeyBook) PeersWithKeys() peer.IDSlice {
 		}
 	}
 	mkb.RUnlock()
+	mkb.order.peers(ps)
 	return ps
 }
 
+// HasKey returns whether a public or private key was added for a peer.
+func (mkb *memoryKeyBook) HasKey(p peer.ID) bool {
+	mkb.RLock()
+	defer mkb.RUnlock()
+	_, hasPub := mkb.pks[p]
+	_, hasPriv := mkb.sks[p]
+	return hasPub || hasPriv
+}
+
+// PubKey returns the public key of a peer. Keys inlined in identity-hashed peer IDs (e.g. Ed25519) are extracted
+// from the ID when they haven't been added explicitly; they are not stored, as they can be recovered at any time.
 func (mkb *memoryKeyBook) PubKey(p peer.ID) ic.PubKey {
+	pk, _ := mkb.PubKeyE(p)
+	return pk
+}
+
+// PubKeyE is like PubKey, but reports why the key could not be returned.
+func (mkb *memoryKeyBook) PubKeyE(p peer.ID) (ic.PubKey, error) {
 	mkb.RLock()
 	pk := mkb.pks[p]
 	mkb.RUnlock()
 	if pk != nil {
-		return pk
+		return pk, nil
 	}
 	pk, err := p.ExtractPublicKey()
-	if err == nil {
-		mkb.Lock()
-		mkb.pks[p] = pk
-		mkb.Unlock()
+	switch err {
+	case nil:
+		return pk, nil
+	case peer.ErrNoPublicKey:
+		return nil, peerstore.ErrNotFound
+	default:
+		return nil, &pstore.KeyDecodeError{Peer: p, Err: err}
 	}
-	return pk
 }
 
 func (mkb *memoryKeyBook) AddPubKey(p peer.ID, pk ic.PubKey) error {
 	// check it's correct first
 	if !p.MatchesPublicKey(pk) {
+		mkb.auditor.audit(pstore.AuditEvent{Type: pstore.AuditKeyMismatch, Peer: p})
 		return errors.New("ID does not match PublicKey")
 	}
 
 	mkb.Lock()
+	_, found := mkb.pks[p]
 	mkb.pks[p] = pk
+	mkb.publishUnlocked(p)
+	mkb.gens.Bump(p)
 	mkb.Unlock()
+	if !found {
+		mkb.auditor.audit(pstore.AuditEvent{Type: pstore.AuditKeyAdded, Peer: p})
+	}
 	return nil
 }
 
@@ -76,6 +137,14 @@ func (mkb *memoryKeyBook) PrivKey(p peer.ID) ic.PrivKey {
 	return sk
 }
 
+// PrivKeyE is like PrivKey, but reports why the key could not be returned.
+func (mkb *memoryKeyBook) PrivKeyE(p peer.ID) (ic.PrivKey, error) {
+	if sk := mkb.PrivKey(p); sk != nil {
+		return sk, nil
+	}
+	return nil, peerstore.ErrNotFound
+}
+
 func (mkb *memoryKeyBook) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
 	if sk == nil {
 		return errors.New("sk is nil (PrivKey)")
@@ -83,11 +152,67 @@ func (mkb *memoryKeyBook) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
 
 	// check it's correct first
 	if !p.MatchesPrivateKey(sk) {
+		mkb.auditor.audit(pstore.AuditEvent{Type: pstore.AuditKeyMismatch, Peer: p, Private: true})
 		return errors.New("ID does not match PrivateKey")
 	}
 
 	mkb.Lock()
+	old, found := mkb.sks[p]
 	mkb.sks[p] = sk
+	mkb.publishUnlocked(p)
+	mkb.gens.Bump(p)
 	mkb.Unlock()
+	if found && mkb.zeroize && !pstore.KeyEqual(old, sk) {
+		pstore.ZeroizePrivKey(old)
+	}
+	if !found {
+		mkb.auditor.audit(pstore.AuditEvent{Type: pstore.AuditKeyAdded, Peer: p, Private: true})
+	}
 	return nil
 }
+
+// RemovePeer removes the keys of a peer.
+func (mkb *memoryKeyBook) RemovePeer(p peer.ID) {
+	mkb.Lock()
+	sk := mkb.sks[p]
+	mkb.pksPeak.note(len(mkb.pks))
+	mkb.sksPeak.note(len(mkb.sks))
+	delete(mkb.pks, p)
+	delete(mkb.sks, p)
+	mkb.autoVacuumUnlocked()
+	mkb.publishUnlocked(p)
+	mkb.gens.Bump(p)
+	mkb.Unlock()
+	if sk != nil && mkb.zeroize {
+		pstore.ZeroizePrivKey(sk)
+	}
+}
+
+func (mkb *memoryKeyBook) KeyTypeCounts() pstore.KeyTypeCounts {
+	counts := pstore.KeyTypeCounts{
+		PubKeys:  make(map[pb.KeyType]int),
+		PrivKeys: make(map[pb.KeyType]int),
+	}
+
+	mkb.RLock()
+	defer mkb.RUnlock()
+	for _, pk := range mkb.pks {
+		counts.PubKeys[pk.Type()]++
+	}
+	for _, sk := range mkb.sks {
+		counts.PrivKeys[sk.Type()]++
+	}
+	return counts
+}
+
+// ExportPrivKey returns the private key of p encoded in format, protected by passphrase unless it is empty, so that
+// it can be backed up. It returns peerstore.ErrNotFound if there is no private key for p.
+func (mkb *memoryKeyBook) ExportPrivKey(p peer.ID, format pstore.KeyFormat, passphrase []byte) ([]byte, error) {
+	return pstore.ExportPrivKey(mkb, p, format, passphrase)
+}
+
+// ImportPrivKey restores a private key exported by ExportPrivKey, or encoded by standard tools, adding it along with
+// its public key for the peer they identify, which it returns.
+func (mkb *memoryKeyBook) ImportPrivKey(data []byte, format pstore.KeyFormat, passphrase []byte) (peer.ID, error) {
+	return pstore.ImportPrivKey(mkb, data, format, passphrase)
+}
@@ -0,0 +1,135 @@
+package pstoremem
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrAddrBookFull is returned when addresses for a new peer are rejected because the address book is above its
+// hard limit. See AddrLimits.RejectNewPeers.
+var ErrAddrBookFull = errors.New("address book is full")
+
+// LimitLevel identifies the threshold an AddrLimitEvent refers to.
+type LimitLevel int
+
+const (
+	SoftLimit LimitLevel = iota
+	HardLimit
+)
+
+func (l LimitLevel) String() string {
+	switch l {
+	case SoftLimit:
+		return "soft"
+	case HardLimit:
+		return "hard"
+	default:
+		return "unknown"
+	}
+}
+
+// AddrLimitEvent is emitted when the total number of addresses crosses a threshold.
+type AddrLimitEvent struct {
+	Level LimitLevel
+	// Total is the number of addresses held when the threshold was crossed.
+	Total int
+	// Exceeded is true when the threshold was reached, and false when the total dropped back below it.
+	Exceeded bool
+}
+
+// AddrLimits configures thresholds on the total number of addresses held by the address book, expired addresses
+// pending garbage collection included. A zero threshold is disabled.
+type AddrLimits struct {
+	// Soft is the number of addresses above which an early warning is emitted.
+	Soft int
+	// Hard is the number of addresses above which the address book is considered full.
+	Hard int
+
+	// Notify is called whenever a threshold is crossed, in either direction. It is called synchronously while the
+	// address book is locked, so it must not block nor call back into the address book.
+	Notify func(AddrLimitEvent)
+
+	// RejectNewPeers drops addresses for peers without any known address while the hard threshold is exceeded.
+	// Addresses of known peers are still accepted.
+	RejectNewPeers bool
+}
+
+// addrLimiter keeps the running total of addresses and tracks threshold crossings.
+type addrLimiter struct {
+	// accessed atomically; keep first for 64-bit alignment.
+	total int64
+
+	// holds an AddrLimits, replaced on reconfiguration.
+	limits atomic.Value
+
+	mu         sync.Mutex
+	soft, hard bool
+}
+
+func newAddrLimiter(limits AddrLimits) *addrLimiter {
+	l := new(addrLimiter)
+	l.limits.Store(limits)
+	return l
+}
+
+func (l *addrLimiter) get() AddrLimits {
+	return l.limits.Load().(AddrLimits)
+}
+
+// set replaces the limits, notifying the threshold crossings caused by the new thresholds.
+func (l *addrLimiter) set(limits AddrLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits.Store(limits)
+
+	// thresholds being disabled are reset silently.
+	if limits.Soft <= 0 {
+		l.soft = false
+	}
+	if limits.Hard <= 0 {
+		l.hard = false
+	}
+	total := l.count()
+	l.soft = l.check(limits, SoftLimit, limits.Soft, l.soft, total)
+	l.hard = l.check(limits, HardLimit, limits.Hard, l.hard, total)
+}
+
+func (l *addrLimiter) count() int {
+	return int(atomic.LoadInt64(&l.total))
+}
+
+// add adjusts the total by delta, notifying any threshold crossings.
+func (l *addrLimiter) add(delta int) {
+	if delta == 0 {
+		return
+	}
+	total := int(atomic.AddInt64(&l.total, int64(delta)))
+	if limits := l.get(); limits.Soft <= 0 && limits.Hard <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// reload under the lock, in case of a concurrent reconfiguration.
+	limits := l.get()
+	l.soft = l.check(limits, SoftLimit, limits.Soft, l.soft, total)
+	l.hard = l.check(limits, HardLimit, limits.Hard, l.hard, total)
+}
+
+func (l *addrLimiter) check(limits AddrLimits, level LimitLevel, threshold int, exceeded bool, total int) bool {
+	if threshold <= 0 || exceeded == (total >= threshold) {
+		return exceeded
+	}
+	exceeded = !exceeded
+	if limits.Notify != nil {
+		limits.Notify(AddrLimitEvent{Level: level, Total: total, Exceeded: exceeded})
+	}
+	return exceeded
+}
+
+// rejectNew returns whether addresses for new peers should be rejected.
+func (l *addrLimiter) rejectNew() bool {
+	limits := l.get()
+	return limits.RejectNewPeers && limits.Hard > 0 && l.count() >= limits.Hard
+}
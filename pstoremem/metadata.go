@@ -1,10 +1,15 @@
 package pstoremem
 
 import (
+	"context"
+	"encoding/gob"
+	"reflect"
 	"sync"
+	"sync/atomic"
 
 	peer "github.com/libp2p/go-libp2p-core/peer"
-	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
 var internKeys = map[string]bool{
@@ -12,34 +17,55 @@ var internKeys = map[string]bool{
 	"ProtocolVersion": true,
 }
 
-type metakey struct {
-	id  peer.ID
-	key string
-}
-
 type memoryPeerMetadata struct {
 	// store other data, like versions
 	//ds ds.ThreadSafeDatastore
-	ds       map[metakey]interface{}
+	ds       map[peer.ID]map[string]interface{}
+	dsPeak   mapPeak // see Vacuum.
 	dslock   sync.RWMutex
 	interned map[string]interface{}
+	// accessed atomically.
+	maxSize    int64
+	validateID pstore.IDValidator
+	strict     *pstore.StrictChecks
+	subs       *pstore.MetadataSubManager
+	autoVacuum float64
+	gens       *pstore.Generations // set by NewPeerstore.
 }
 
-var _ pstore.PeerMetadata = (*memoryPeerMetadata)(nil)
+var (
+	_ peerstore.PeerMetadata   = (*memoryPeerMetadata)(nil)
+	_ pstore.PeerMetadataBatch = (*memoryPeerMetadata)(nil)
+	_ pstore.PeerMetadataSizer = (*memoryPeerMetadata)(nil)
+	_ pstore.MetadataWatcher   = (*memoryPeerMetadata)(nil)
+)
 
-func NewPeerMetadata() *memoryPeerMetadata {
+// NewPeerMetadata creates an in-memory metadata store. It accepts the WithMaxMetadataValueSize, WithIDValidator,
+// WithStrictChecks and WithAutoVacuum options.
+func NewPeerMetadata(opts ...Option) *memoryPeerMetadata {
+	o := newOptions(opts)
 	return &memoryPeerMetadata{
-		ds:       make(map[metakey]interface{}),
-		interned: make(map[string]interface{}),
+		ds:         make(map[peer.ID]map[string]interface{}),
+		interned:   make(map[string]interface{}),
+		maxSize:    int64(o.maxValueSize),
+		validateID: o.validateID,
+		strict:     pstore.NewStrictChecks(o.strict),
+		subs:       pstore.NewMetadataSubManager(),
+		autoVacuum: o.autoVacuum,
 	}
 }
 
 func (ps *memoryPeerMetadata) Put(p peer.ID, key string, val interface{}) error {
-	if err := p.Validate(); err != nil {
+	if err := ps.validateID(p); err != nil {
 		return err
 	}
+	if err := ps.strict.Put(p, key, val); err != nil {
+		return err
+	}
+	if ps.tooLarge(val) {
+		return pstore.ErrValueTooLarge
+	}
 	ps.dslock.Lock()
-	defer ps.dslock.Unlock()
 	if vals, ok := val.(string); ok && internKeys[key] {
 		if interned, ok := ps.interned[vals]; ok {
 			val = interned
@@ -47,19 +73,143 @@ func (ps *memoryPeerMetadata) Put(p peer.ID, key string, val interface{}) error
 			ps.interned[vals] = val
 		}
 	}
-	ps.ds[metakey{p, key}] = val
+	m, ok := ps.ds[p]
+	if !ok {
+		m = make(map[string]interface{})
+		ps.ds[p] = m
+	}
+	prev, had := m[key]
+	m[key] = val
+	ps.gens.Bump(p)
+	ps.dslock.Unlock()
+
+	if ps.subs.Watched(key) && !(had && reflect.DeepEqual(prev, val)) {
+		ps.subs.Broadcast(pstore.MetadataEvent{Peer: p, Key: key, Value: val})
+	}
 	return nil
 }
 
+// WatchMetadata returns a channel of the changes of key, for all peers, until ctx is done. See
+// pstore.MetadataWatcher.
+func (ps *memoryPeerMetadata) WatchMetadata(ctx context.Context, key string) <-chan pstore.MetadataEvent {
+	return ps.subs.WatchMetadata(ctx, key)
+}
+
 func (ps *memoryPeerMetadata) Get(p peer.ID, key string) (interface{}, error) {
 	if err := p.Validate(); err != nil {
 		return nil, err
 	}
 	ps.dslock.RLock()
 	defer ps.dslock.RUnlock()
-	i, ok := ps.ds[metakey{p, key}]
+	i, ok := ps.ds[p][key]
 	if !ok {
-		return nil, pstore.ErrNotFound
+		return nil, peerstore.ErrNotFound
 	}
 	return i, nil
 }
+
+// Reconfigure changes the limits of the metadata book at runtime. Only the WithMaxMetadataValueSize option applies;
+// the limit is left unchanged if it isn't passed. Values already stored are kept.
+func (ps *memoryPeerMetadata) Reconfigure(opts ...Option) {
+	o := &options{maxValueSize: int(atomic.LoadInt64(&ps.maxSize))}
+	for _, opt := range opts {
+		opt(o)
+	}
+	atomic.StoreInt64(&ps.maxSize, int64(o.maxValueSize))
+}
+
+func (ps *memoryPeerMetadata) tooLarge(val interface{}) bool {
+	max := int(atomic.LoadInt64(&ps.maxSize))
+	if max <= 0 {
+		return false
+	}
+	switch v := val.(type) {
+	case string:
+		return len(v) > max
+	case []byte:
+		return len(v) > max
+	}
+	return false
+}
+
+// MetadataSize estimates the size of the metadata of a peer, as the size of its keys and of the gob encoding of its
+// values. Values that cannot be encoded are not counted.
+func (ps *memoryPeerMetadata) MetadataSize(p peer.ID) int {
+	ps.dslock.RLock()
+	defer ps.dslock.RUnlock()
+	var size int
+	for k, v := range ps.ds[p] {
+		size += len(k) + valueSize(v)
+	}
+	return size
+}
+
+func valueSize(v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+	var n byteCounter
+	if err := gob.NewEncoder(&n).Encode(v); err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// byteCounter is a writer counting the bytes written to it.
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// RemovePeer removes all metadata of a peer.
+func (ps *memoryPeerMetadata) RemovePeer(p peer.ID) {
+	ps.dslock.Lock()
+	removed := ps.ds[p]
+	ps.dsPeak.note(len(ps.ds))
+	delete(ps.ds, p)
+	ps.autoVacuumUnlocked()
+	ps.gens.Bump(p)
+	ps.dslock.Unlock()
+
+	for key := range removed {
+		if ps.subs.Watched(key) {
+			ps.subs.Broadcast(pstore.MetadataEvent{Peer: p, Key: key, Removed: true})
+		}
+	}
+}
+
+// hasMetadata returns whether a peer has metadata.
+func (ps *memoryPeerMetadata) hasMetadata(p peer.ID) bool {
+	ps.dslock.RLock()
+	defer ps.dslock.RUnlock()
+	return len(ps.ds[p]) > 0
+}
+
+// peers returns the peers with metadata.
+func (ps *memoryPeerMetadata) peers() peer.IDSlice {
+	ps.dslock.RLock()
+	defer ps.dslock.RUnlock()
+	pids := make(peer.IDSlice, 0, len(ps.ds))
+	for p := range ps.ds {
+		pids = append(pids, p)
+	}
+	return pids
+}
+
+// GetMany returns the value stored under key for each of the given peers that has one.
+func (ps *memoryPeerMetadata) GetMany(key string, peers []peer.ID) map[peer.ID]interface{} {
+	ps.dslock.RLock()
+	defer ps.dslock.RUnlock()
+	res := make(map[peer.ID]interface{}, len(peers))
+	for _, p := range peers {
+		if v, ok := ps.ds[p][key]; ok {
+			res[p] = v
+		}
+	}
+	return res
+}
@@ -0,0 +1,94 @@
+package pstoremem
+
+import (
+	"sync"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// memoryNameBook holds the names of peers, indexed both by peer and by name.
+type memoryNameBook struct {
+	mu    sync.RWMutex
+	names map[peer.ID]string
+	peers map[string]peer.ID
+
+	validateID peerstore.IDValidator
+	gens       *peerstore.Generations // set by NewPeerstore.
+}
+
+var _ peerstore.NameBook = (*memoryNameBook)(nil)
+
+// NewNameBook creates an in-memory name book. It accepts the WithIDValidator option.
+func NewNameBook(opts ...Option) *memoryNameBook {
+	o := newOptions(opts)
+	return &memoryNameBook{
+		names:      make(map[peer.ID]string),
+		peers:      make(map[string]peer.ID),
+		validateID: o.validateID,
+	}
+}
+
+func (nb *memoryNameBook) SetName(p peer.ID, name string) error {
+	if name == "" {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	} else if err := nb.validateID(p); err != nil {
+		return err
+	}
+
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+
+	if owner, ok := nb.peers[name]; ok && owner != p {
+		return peerstore.ErrNameTaken
+	}
+	if old, ok := nb.names[p]; ok {
+		delete(nb.peers, old)
+		delete(nb.names, p)
+	}
+	if name != "" {
+		nb.names[p] = name
+		nb.peers[name] = p
+	}
+	nb.gens.Bump(p)
+	return nil
+}
+
+func (nb *memoryNameBook) Name(p peer.ID) string {
+	nb.mu.RLock()
+	defer nb.mu.RUnlock()
+	return nb.names[p]
+}
+
+func (nb *memoryNameBook) PeerByName(name string) (peer.ID, bool) {
+	nb.mu.RLock()
+	defer nb.mu.RUnlock()
+	p, ok := nb.peers[name]
+	return p, ok
+}
+
+func (nb *memoryNameBook) Names() map[peer.ID]string {
+	nb.mu.RLock()
+	defer nb.mu.RUnlock()
+
+	res := make(map[peer.ID]string, len(nb.names))
+	for p, name := range nb.names {
+		res[p] = name
+	}
+	return res
+}
+
+// namedPeers returns the named peers.
+func (nb *memoryNameBook) namedPeers() peer.IDSlice {
+	nb.mu.RLock()
+	defer nb.mu.RUnlock()
+
+	pids := make(peer.IDSlice, 0, len(nb.names))
+	for p := range nb.names {
+		pids = append(pids, p)
+	}
+	return pids
+}
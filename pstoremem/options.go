@@ -0,0 +1,257 @@
+package pstoremem
+
+import (
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// Option configures an in-memory peerstore, or one of its components. Options that don't apply to a component are
+// ignored by it.
+type Option func(*options)
+
+type options struct {
+	addrLimits     AddrLimits
+	clock          pstore.Clock
+	peerGCInterval time.Duration
+	maxValueSize   int
+	deterministic  bool
+	seed           int64
+	auditSink      pstore.AuditSink
+	zeroize        bool
+	ttlPolicy      pstore.TTLPolicy
+	aliases        bool
+	validateID     pstore.IDValidator
+	p2pPolicy      pstore.P2PAddrPolicy
+	exoticPolicy   pstore.ExoticAddrPolicy
+	maxAddrLen     int
+	ttlJitter      float64
+	debounce       time.Duration
+	strict         bool
+	maxAddrTTL     time.Duration
+	addrOrder      pstore.AddrOrder
+	addrHistory    int
+	readMostly     bool
+	changeLog      int
+	autoVacuum     float64
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{clock: pstore.RealClock{}, ttlPolicy: pstore.MaxTTL, validateID: pstore.RelaxedIDs}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithAddrLimits sets thresholds on the total number of addresses held by the address book.
+func WithAddrLimits(limits AddrLimits) Option {
+	return func(o *options) {
+		o.addrLimits = limits
+	}
+}
+
+// WithClock sets the source of time used to compute address expiry. Defaults to the system clock.
+func WithClock(clock pstore.Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithPeerGC enables garbage collection of peers left without any live address, sweeping at the given interval. See
+// pstore.PeerCollector for which peers are collected. Only applies to the peerstore; disabled by default.
+func WithPeerGC(interval time.Duration) Option {
+	return func(o *options) {
+		o.peerGCInterval = interval
+	}
+}
+
+// WithMaxMetadataValueSize rejects metadata values larger than size bytes with pstore.ErrValueTooLarge. Values are
+// held as is rather than encoded, so only the length of string and []byte values is checked. Only applies to the
+// metadata book; disabled by default.
+func WithMaxMetadataValueSize(size int) Option {
+	return func(o *options) {
+		o.maxValueSize = size
+	}
+}
+
+// WithDeterminism makes the peerstore behave deterministically, for simulations that need reproducible runs. Peers,
+// addresses and protocols are returned in an order derived from seed, rather than in map iteration order, and no
+// background goroutines are started: expired addresses are garbage collected on writes instead, once an hour of clock
+// time has elapsed since the last collection, and peer GC (see WithPeerGC) is disabled.
+//
+// Combine with WithClock and a mock clock so that expiry is deterministic too. Address streams (AddrStream) still
+// run a goroutine per stream.
+func WithDeterminism(seed int64) Option {
+	return func(o *options) {
+		o.deterministic = true
+		o.seed = seed
+	}
+}
+
+// WithAuditSink records security-relevant events, such as keys being added or rejected and stale signed peer
+// records, to sink. Applies to the key and address books.
+func WithAuditSink(sink pstore.AuditSink) Option {
+	return func(o *options) {
+		o.auditSink = sink
+	}
+}
+
+// WithZeroizeOnRemove wipes the material of private keys when they are removed or replaced by another key, see
+// pstore.ZeroizePrivKey. The key book then owns the private keys added to it: callers must not use them once the
+// peer is removed. Only applies to the key book; disabled by default.
+func WithZeroizeOnRemove() Option {
+	return func(o *options) {
+		o.zeroize = true
+	}
+}
+
+// WithTTLPolicy sets how the TTL of an address is updated when the address is added again, see pstore.TTLPolicy.
+// Only applies to the address book; defaults to pstore.MaxTTL.
+func WithTTLPolicy(policy pstore.TTLPolicy) Option {
+	return func(o *options) {
+		o.ttlPolicy = policy
+	}
+}
+
+// WithAddrAliases links the addresses of a peer that refer to the same endpoint under different encodings, such as
+// /ip4/1.2.3.4/tcp/4001 and /ip4/1.2.3.4/tcp/4001/ws (see addr.Endpoint). Adding or setting one of them updates the
+// TTL and expiry of all of them, and Addrs returns a single address per endpoint. Removing an address doesn't remove
+// its aliases. Only applies to the address book; disabled by default.
+func WithAddrAliases() Option {
+	return func(o *options) {
+		o.aliases = true
+	}
+}
+
+// WithIDValidator sets the check applied to the peer IDs of addresses, protocols and metadata being written, and to
+// those of addresses being read or cleared, see pstore.IDValidator. Keys are always checked against the peer ID they
+// are added for. Defaults to pstore.RelaxedIDs.
+func WithIDValidator(validate pstore.IDValidator) Option {
+	return func(o *options) {
+		o.validateID = validate
+	}
+}
+
+// WithP2PAddrPolicy sets how addresses ending with a /p2p component are handled when added or set, see
+// pstore.P2PAddrPolicy. Rejected addresses are dropped, and reported with pstore.ErrP2PAddrMismatch by AddAddrsE and
+// SetAddrsE once the others have been stored. Only applies to the address book; defaults to pstore.P2PAddrKeep.
+func WithP2PAddrPolicy(policy pstore.P2PAddrPolicy) Option {
+	return func(o *options) {
+		o.p2pPolicy = policy
+	}
+}
+
+// WithExoticAddrPolicy sets how the addresses of Tor, I2P, WebTransport and WebRTC are handled when added or set, see
+// pstore.ExoticAddrPolicy. Rejected addresses are dropped, and reported by AddAddrsE and SetAddrsE once the others have
+// been stored. Only applies to the address book; defaults to the zero policy, which stores them all, with the TTL of
+// addresses carrying certificate hashes capped to pstore.DefaultCertHashAddrTTL.
+func WithExoticAddrPolicy(policy pstore.ExoticAddrPolicy) Option {
+	return func(o *options) {
+		o.exoticPolicy = policy
+	}
+}
+
+// WithMaxAddrLen caps the binary length of the addresses added or set, in bytes. Longer addresses are dropped, counted,
+// and reported with pstore.ErrAddrTooLong by AddAddrsE and SetAddrsE once the others have been stored. A negative value
+// disables the cap. Only applies to the address book; defaults to pstore.DefaultMaxAddrLen.
+func WithMaxAddrLen(n int) Option {
+	return func(o *options) {
+		o.maxAddrLen = n
+	}
+}
+
+// WithTTLJitter shortens the lifetime of every address by a random amount of up to fraction (0-1) of its TTL, so that
+// addresses added at the same time don't all expire at once, see pstore.TTLJitter. With WithDeterminism, jitter is
+// drawn from the seed. Only applies to the address book; disabled by default.
+func WithTTLJitter(fraction float64) Option {
+	return func(o *options) {
+		o.ttlJitter = fraction
+	}
+}
+
+// WithAddrDebounce suppresses the additions of an address repeated for the same peer, with a TTL of the same class,
+// within window, before the address book is locked; see pstore.AddrDebouncer. Only applies to the address book;
+// disabled by default.
+func WithAddrDebounce(window time.Duration) Option {
+	return func(o *options) {
+		o.debounce = window
+	}
+}
+
+// WithStrictChecks rejects the writes with arguments that are silently ignored otherwise, such as nil addresses,
+// non-positive or absurd TTLs, empty metadata keys and empty protocols, see pstore.StrictChecks. Meant for tests and
+// development, to catch integration bugs. Applies to the address, metadata and protocol books; disabled by default.
+func WithStrictChecks() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithMaxAddrTTL clamps the TTL of the addresses added, set or updated to max, except for those of ConnectedAddrTTL and
+// above, which pin addresses; see pstore.ClampTTL. Clamped addresses hold max as their TTL, which UpdateAddrs matches
+// for any old TTL above it. Only applies to the address book; disabled by default.
+func WithMaxAddrTTL(max time.Duration) Option {
+	return func(o *options) {
+		o.maxAddrTTL = max
+	}
+}
+
+// WithAddrOrder sets the order in which the addresses of a peer are returned by Addrs, AddrsWithExpiry and
+// ExpiringBefore, see pstore.AddrOrder, so that dials and the caches of callers are reproducible. It takes precedence
+// over the order of WithDeterminism for addresses. Only applies to the address book; defaults to pstore.AddrOrderNone.
+func WithAddrOrder(order pstore.AddrOrder) Option {
+	return func(o *options) {
+		o.addrOrder = order
+	}
+}
+
+// WithAddrHistory keeps the latest size address events (additions, expiries and removals) of every peer, as returned
+// by AddrHistory, see pstore.AddrChurnBook. The events of a peer are kept until it is removed, e.g. by peer GC (see
+// WithPeerGC), which otherwise takes them as data about the peer. Only applies to the address book; disabled by
+// default.
+func WithAddrHistory(size int) Option {
+	return func(o *options) {
+		o.addrHistory = size
+	}
+}
+
+// WithReadMostly makes Addrs, HasAddrs, PeersWithAddrs, PeersWithKeys and thus Peers lock-free, for workloads made
+// almost only of reads, such as relays and gateways. The addresses of every segment of the address book, and the
+// peers with keys, are then also held in immutable copies, which writers replace atomically with updated copies: every
+// write to the addresses of a peer copies the path to the peer in the trie indexing its segment, one of 256, which
+// grows logarithmically with the number of peers, while adding or removing the keys of a peer copies the set of peers
+// with keys. Reads of peers whose addresses have expired still check their protection, which takes a lock.
+// Applies to the address and key books; disabled by default.
+func WithReadMostly() Option {
+	return func(o *options) {
+		o.readMostly = true
+	}
+}
+
+// WithChangeLog keeps the latest size changes of the peerstore, as returned by Changes, see pstore.ChangeFeed. Changes
+// are numbered whether or not they are kept. Only applies to the peerstore; disabled by default.
+func WithChangeLog(size int) Option {
+	return func(o *options) {
+		o.changeLog = size
+	}
+}
+
+// WithAutoVacuum reallocates the maps indexing peers once they shrink below ratio (0-1) of their peak length, e.g. with
+// 0.25 once three quarters of the peers they held were removed or expired, rather than waiting for Vacuum to be called.
+// Maps that never held 64 peers are left alone. Applies to the address, key, protocol and metadata books; disabled by
+// default.
+func WithAutoVacuum(ratio float64) Option {
+	return func(o *options) {
+		o.autoVacuum = ratio
+	}
+}
+
+// newTTLJitter returns the TTL jitter configured in o, nil if disabled.
+func newTTLJitter(o *options) *pstore.TTLJitter {
+	seed := time.Now().UnixNano()
+	if o.deterministic {
+		seed = o.seed
+	}
+	return pstore.NewTTLJitter(o.ttlJitter, seed)
+}
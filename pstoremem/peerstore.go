@@ -6,6 +6,10 @@ import (
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 	"io"
+	"sort"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 type pstoremem struct {
@@ -15,17 +19,72 @@ type pstoremem struct {
 	*memoryAddrBook
 	*memoryProtoBook
 	*memoryPeerMetadata
+	*memoryGroupBook
+	*memoryNameBook
+	*pstore.PeerLocks
+
+	gens   *pstore.Generations
+	peerGC *pstore.PeerCollector
+	order  *ordering
+	opts   []Option // kept for Clone.
 }
 
-// NewPeerstore creates an in-memory threadsafe collection of peers.
-func NewPeerstore() *pstoremem {
-	return &pstoremem{
+var (
+	_ pstore.PeerRemover          = (*pstoremem)(nil)
+	_ pstore.LatencyDistributions = (*pstoremem)(nil)
+	_ pstore.LatencyStatistics    = (*pstoremem)(nil)
+	_ pstore.TransportLatencies   = (*pstoremem)(nil)
+	_ pstore.Cloner               = (*pstoremem)(nil)
+	_ pstore.ProtocolPeers        = (*pstoremem)(nil)
+	_ pstore.PeerExistence        = (*pstoremem)(nil)
+	_ pstore.KnownFilter          = (*pstoremem)(nil)
+	_ pstore.KeyExporter          = (*pstoremem)(nil)
+	_ pstore.PeerStateReader      = (*pstoremem)(nil)
+	_ pstore.GroupBook            = (*pstoremem)(nil)
+	_ pstore.NameBook             = (*pstoremem)(nil)
+	_ pstore.AddrChurnBook        = (*pstoremem)(nil)
+	_ pstore.PeerLocker           = (*pstoremem)(nil)
+	_ pstore.GenerationTracker    = (*pstoremem)(nil)
+	_ pstore.ChangeFeed           = (*pstoremem)(nil)
+	_ pstore.Vacuumer             = (*pstoremem)(nil)
+)
+
+// NewPeerstore creates an in-memory threadsafe collection of peers. Options are passed on to its components.
+func NewPeerstore(opts ...Option) *pstoremem {
+	o := newOptions(opts)
+	ps := &pstoremem{
 		Metrics:            pstore.NewMetrics(),
-		memoryKeyBook:      NewKeyBook(),
-		memoryAddrBook:     NewAddrBook(),
-		memoryProtoBook:    NewProtoBook(),
-		memoryPeerMetadata: NewPeerMetadata(),
+		memoryKeyBook:      NewKeyBook(opts...),
+		memoryAddrBook:     NewAddrBook(opts...),
+		memoryProtoBook:    NewProtoBook(opts...),
+		memoryPeerMetadata: NewPeerMetadata(opts...),
+		memoryGroupBook:    NewGroupBook(opts...),
+		memoryNameBook:     NewNameBook(opts...),
+		PeerLocks:          new(pstore.PeerLocks),
+		gens:               pstore.NewGenerations(o.changeLog),
+		order:              newOrdering(o),
+		opts:               append([]Option(nil), opts...),
 	}
+	ps.memoryKeyBook.gens = ps.gens
+	ps.memoryAddrBook.gens = ps.gens
+	ps.memoryProtoBook.gens = ps.gens
+	ps.memoryPeerMetadata.gens = ps.gens
+	ps.memoryGroupBook.gens = ps.gens
+	ps.memoryNameBook.gens = ps.gens
+	if o.peerGCInterval > 0 && !o.deterministic {
+		// cannot fail, as we implement PeerRemover.
+		ps.peerGC, _ = pstore.NewPeerCollector(ps, ps.allPeers, o.peerGCInterval)
+	}
+	return ps
+}
+
+// Reconfigure changes the limits of the peerstore at runtime, e.g. on a configuration reload, without disrupting
+// ongoing operations. The WithAddrLimits and WithMaxMetadataValueSize options apply; limits that aren't passed are
+// left unchanged, and other options are ignored. Books are split into a fixed number of segments, keyed by peer ID,
+// so there is no sharding to resize.
+func (ps *pstoremem) Reconfigure(opts ...Option) {
+	ps.memoryAddrBook.Reconfigure(opts...)
+	ps.memoryPeerMetadata.Reconfigure(opts...)
 }
 
 func (ps *pstoremem) Close() (err error) {
@@ -38,6 +97,9 @@ func (ps *pstoremem) Close() (err error) {
 		}
 	}
 
+	if ps.peerGC != nil {
+		ps.peerGC.Close()
+	}
 	weakClose("keybook", ps.memoryKeyBook)
 	weakClose("addressbook", ps.memoryAddrBook)
 	weakClose("protobook", ps.memoryProtoBook)
@@ -49,6 +111,8 @@ func (ps *pstoremem) Close() (err error) {
 	return nil
 }
 
+// Peers returns the peers with addresses or keys, each once. The order is not defined, and may change between calls,
+// unless WithDeterminism is set.
 func (ps *pstoremem) Peers() peer.IDSlice {
 	set := map[peer.ID]struct{}{}
 	for _, p := range ps.PeersWithKeys() {
@@ -62,12 +126,155 @@ func (ps *pstoremem) Peers() peer.IDSlice {
 	for p := range set {
 		pps = append(pps, p)
 	}
+	ps.order.peers(pps)
 	return pps
 }
 
+// Known returns whether any book holds data about a peer.
+func (ps *pstoremem) Known(p peer.ID) bool {
+	return ps.HasAddrs(p) || ps.HasKey(p) || ps.memoryProtoBook.hasProtocols(p) || ps.memoryPeerMetadata.hasMetadata(p)
+}
+
+// GetPeerState returns what the peerstore holds about a peer. The locks of all books covering the peer are held at
+// once, so that the state is consistent.
+func (ps *pstoremem) GetPeerState(p peer.ID) pstore.PeerState {
+	state := pstore.PeerState{ID: p, Name: ps.memoryNameBook.Name(p), Latency: ps.LatencyEWMA(p)}
+	if err := p.Validate(); err != nil {
+		return state
+	}
+
+	// books never hold each other's locks, so taking them all in a fixed order can't deadlock.
+	as := ps.memoryAddrBook.segments.get(p)
+	as.RLock()
+	defer as.RUnlock()
+	ps.memoryKeyBook.RLock()
+	defer ps.memoryKeyBook.RUnlock()
+	prs := ps.memoryProtoBook.segments.get(p)
+	prs.RLock()
+	defer prs.RUnlock()
+	ps.memoryPeerMetadata.dslock.RLock()
+	defer ps.memoryPeerMetadata.dslock.RUnlock()
+
+	state.Addrs = ps.memoryAddrBook.expiringAddrsUnlocked(as, p)
+	_, state.HasPubKey = ps.memoryKeyBook.pks[p]
+	_, state.HasPrivKey = ps.memoryKeyBook.sks[p]
+	for proto := range prs.protocols[p] {
+		state.Protocols = append(state.Protocols, proto)
+	}
+	for key := range ps.memoryPeerMetadata.ds[p] {
+		state.MetadataKeys = append(state.MetadataKeys, key)
+	}
+	sort.Strings(state.Protocols)
+	sort.Strings(state.MetadataKeys)
+	return state
+}
+
 func (ps *pstoremem) PeerInfo(p peer.ID) peer.AddrInfo {
 	return peer.AddrInfo{
 		ID:    p,
 		Addrs: ps.memoryAddrBook.Addrs(p),
 	}
 }
+
+// RemovePeer removes everything known about a peer from all books, address history included, except for its
+// protection tags, group memberships and name.
+func (ps *pstoremem) RemovePeer(p peer.ID) {
+	ps.memoryKeyBook.RemovePeer(p)
+	ps.memoryAddrBook.ClearAddrs(p)
+	ps.memoryAddrBook.churn.Forget(p)
+	ps.memoryProtoBook.RemovePeer(p)
+	ps.memoryPeerMetadata.RemovePeer(p)
+	if rm, ok := ps.Metrics.(pstore.PeerRemover); ok {
+		rm.RemovePeer(p)
+	}
+	ps.gens.Remove(p)
+}
+
+// Generation returns the generation of a peer, which changes on every write to the data held about it, see
+// pstore.GenerationTracker.
+func (ps *pstoremem) Generation(p peer.ID) uint64 {
+	return ps.gens.Generation(p)
+}
+
+// ChangeSeq returns the sequence number of the latest change of the peerstore, see pstore.ChangeFeed.
+func (ps *pstoremem) ChangeSeq() uint64 {
+	return ps.gens.ChangeSeq()
+}
+
+// Changes returns the changes of the peerstore after since, oldest first, if WithChangeLog is set. See
+// pstore.ChangeFeed.
+func (ps *pstoremem) Changes(since uint64) []pstore.ChangeRecord {
+	return ps.gens.Changes(since)
+}
+
+// PeerLatencyHistograms returns a snapshot of the latency histogram of every peer with measurements.
+func (ps *pstoremem) PeerLatencyHistograms() map[peer.ID]pstore.LatencyHistogram {
+	if ld, ok := ps.Metrics.(pstore.LatencyDistributions); ok {
+		return ld.PeerLatencyHistograms()
+	}
+	return nil
+}
+
+// LatencyStats returns the mean, variance and number of the latency measurements of a peer.
+func (ps *pstoremem) LatencyStats(p peer.ID) pstore.LatencyStats {
+	if ls, ok := ps.Metrics.(pstore.LatencyStatistics); ok {
+		return ls.LatencyStats(p)
+	}
+	return pstore.LatencyStats{Mean: ps.Metrics.LatencyEWMA(p)}
+}
+
+// RecordLatencyVia records a latency measurement taken over the address a. Metrics that don't track transports
+// record it like RecordLatency.
+func (ps *pstoremem) RecordLatencyVia(p peer.ID, a ma.Multiaddr, next time.Duration) {
+	if tl, ok := ps.Metrics.(pstore.TransportLatencies); ok {
+		tl.RecordLatencyVia(p, a, next)
+		return
+	}
+	ps.Metrics.RecordLatency(p, next)
+}
+
+// TransportLatencyStats returns the latency statistics of a peer by transport.
+func (ps *pstoremem) TransportLatencyStats(p peer.ID) map[string]pstore.LatencyStats {
+	if tl, ok := ps.Metrics.(pstore.TransportLatencies); ok {
+		return tl.TransportLatencyStats(p)
+	}
+	return nil
+}
+
+// LatencyHistogram returns a snapshot of the histogram of all latency measurements.
+func (ps *pstoremem) LatencyHistogram() pstore.LatencyHistogram {
+	if ld, ok := ps.Metrics.(pstore.LatencyDistributions); ok {
+		return ld.LatencyHistogram()
+	}
+	return pstore.LatencyHistogram{}
+}
+
+// allPeers returns the peers known to any book, including those with protocols, metadata, group memberships, names or
+// address history only.
+func (ps *pstoremem) allPeers() peer.IDSlice {
+	set := map[peer.ID]struct{}{}
+	for _, p := range ps.Peers() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.memoryProtoBook.peers() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.memoryPeerMetadata.peers() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.memoryGroupBook.peers() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.memoryNameBook.namedPeers() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.memoryAddrBook.churn.Peers() {
+		set[p] = struct{}{}
+	}
+
+	pps := make(peer.IDSlice, 0, len(set))
+	for p := range set {
+		pps = append(pps, p)
+	}
+	return pps
+}
@@ -0,0 +1,89 @@
+package pstoremem
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// ProtectManager tracks the tags protecting peers from eviction. It is shared with other peerstore
+// implementations.
+type ProtectManager struct {
+	mu        sync.RWMutex
+	protected map[peer.ID]map[string]struct{}
+}
+
+var _ pstore.PeerProtector = (*ProtectManager)(nil)
+
+// NewProtectManager initializes a ProtectManager.
+func NewProtectManager() *ProtectManager {
+	return &ProtectManager{protected: make(map[peer.ID]map[string]struct{})}
+}
+
+func (pm *ProtectManager) Protect(p peer.ID, tag string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	tags, ok := pm.protected[p]
+	if !ok {
+		tags = make(map[string]struct{}, 1)
+		pm.protected[p] = tags
+	}
+	tags[tag] = struct{}{}
+}
+
+func (pm *ProtectManager) Unprotect(p peer.ID, tag string) (protected bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	tags, ok := pm.protected[p]
+	if !ok {
+		return false
+	}
+	delete(tags, tag)
+	if len(tags) == 0 {
+		delete(pm.protected, p)
+		return false
+	}
+	return true
+}
+
+func (pm *ProtectManager) IsProtected(p peer.ID, tag string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	tags, ok := pm.protected[p]
+	if !ok {
+		return false
+	}
+	if tag == "" {
+		return true
+	}
+	_, ok = tags[tag]
+	return ok
+}
+
+func (pm *ProtectManager) ProtectedPeers() peer.IDSlice {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	ps := make(peer.IDSlice, 0, len(pm.protected))
+	for p := range pm.protected {
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// CopyTo adds the protection tags to dst, which tracks them independently from then on.
+func (pm *ProtectManager) CopyTo(dst *ProtectManager) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	for p, tags := range pm.protected {
+		for tag := range tags {
+			dst.Protect(p, tag)
+		}
+	}
+}
@@ -6,11 +6,15 @@ import (
 	peer "github.com/libp2p/go-libp2p-core/peer"
 
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
 type protoSegment struct {
 	sync.RWMutex
 	protocols map[peer.ID]map[string]struct{}
+
+	// peak length of protocols, see Vacuum.
+	protocolsPeak mapPeak
 }
 
 type protoSegments [256]*protoSegment
@@ -24,13 +28,29 @@ type memoryProtoBook struct {
 
 	lk       sync.RWMutex
 	interned map[string]string
+
+	order      *ordering
+	validateID peerstore.IDValidator
+	strict     *peerstore.StrictChecks
+	autoVacuum float64
+	gens       *peerstore.Generations // set by NewPeerstore.
 }
 
-var _ pstore.ProtoBook = (*memoryProtoBook)(nil)
+var (
+	_ pstore.ProtoBook        = (*memoryProtoBook)(nil)
+	_ peerstore.ProtocolPeers = (*memoryProtoBook)(nil)
+)
 
-func NewProtoBook() *memoryProtoBook {
+// NewProtoBook creates an in-memory protocol book. It accepts the WithDeterminism, WithIDValidator, WithStrictChecks
+// and WithAutoVacuum options.
+func NewProtoBook(opts ...Option) *memoryProtoBook {
+	o := newOptions(opts)
 	return &memoryProtoBook{
-		interned: make(map[string]string, 256),
+		order:      newOrdering(o),
+		validateID: o.validateID,
+		strict:     peerstore.NewStrictChecks(o.strict),
+		autoVacuum: o.autoVacuum,
+		interned:   make(map[string]string, 256),
 		segments: func() (ret protoSegments) {
 			for i := range ret {
 				ret[i] = &protoSegment{
@@ -67,7 +87,10 @@ func (pb *memoryProtoBook) internProtocol(proto string) string {
 }
 
 func (pb *memoryProtoBook) SetProtocols(p peer.ID, protos ...string) error {
-	if err := p.Validate(); err != nil {
+	if err := pb.validateID(p); err != nil {
+		return err
+	}
+	if err := pb.strict.Protocols("SetProtocols", p, protos); err != nil {
 		return err
 	}
 
@@ -81,12 +104,16 @@ func (pb *memoryProtoBook) SetProtocols(p peer.ID, protos ...string) error {
 	}
 
 	s.protocols[p] = newprotos
+	pb.gens.Bump(p)
 
 	return nil
 }
 
 func (pb *memoryProtoBook) AddProtocols(p peer.ID, protos ...string) error {
-	if err := p.Validate(); err != nil {
+	if err := pb.validateID(p); err != nil {
+		return err
+	}
+	if err := pb.strict.Protocols("AddProtocols", p, protos); err != nil {
 		return err
 	}
 
@@ -103,6 +130,7 @@ func (pb *memoryProtoBook) AddProtocols(p peer.ID, protos ...string) error {
 	for _, proto := range protos {
 		protomap[pb.internProtocol(proto)] = struct{}{}
 	}
+	pb.gens.Bump(p)
 
 	return nil
 }
@@ -120,6 +148,7 @@ func (pb *memoryProtoBook) GetProtocols(p peer.ID) ([]string, error) {
 	for k := range s.protocols[p] {
 		out = append(out, k)
 	}
+	pb.order.strings(out)
 
 	return out, nil
 }
@@ -128,6 +157,9 @@ func (pb *memoryProtoBook) RemoveProtocols(p peer.ID, protos ...string) error {
 	if err := p.Validate(); err != nil {
 		return err
 	}
+	if err := pb.strict.Protocols("RemoveProtocols", p, protos); err != nil {
+		return err
+	}
 
 	s := pb.segments.get(p)
 	s.Lock()
@@ -142,6 +174,7 @@ func (pb *memoryProtoBook) RemoveProtocols(p peer.ID, protos ...string) error {
 	for _, proto := range protos {
 		delete(protomap, pb.internProtocol(proto))
 	}
+	pb.gens.Bump(p)
 	return nil
 }
 
@@ -180,3 +213,60 @@ func (pb *memoryProtoBook) FirstSupportedProtocol(p peer.ID, protos ...string) (
 	}
 	return "", nil
 }
+
+// RemovePeer removes the protocols of a peer.
+func (pb *memoryProtoBook) RemovePeer(p peer.ID) {
+	if err := p.Validate(); err != nil {
+		return
+	}
+	s := pb.segments.get(p)
+	s.Lock()
+	s.protocolsPeak.note(len(s.protocols))
+	delete(s.protocols, p)
+	pb.autoVacuumUnlocked(s)
+	pb.gens.Bump(p)
+	s.Unlock()
+}
+
+// PeersWithProtocols returns the peers supporting any of the given protocols.
+func (pb *memoryProtoBook) PeersWithProtocols(protos ...string) peer.IDSlice {
+	var pids peer.IDSlice
+	for _, s := range pb.segments {
+		s.RLock()
+		for p, protomap := range s.protocols {
+			for _, proto := range protos {
+				if _, ok := protomap[proto]; ok {
+					pids = append(pids, p)
+					break
+				}
+			}
+		}
+		s.RUnlock()
+	}
+	pb.order.peers(pids)
+	return pids
+}
+
+// hasProtocols returns whether a peer has protocols.
+func (pb *memoryProtoBook) hasProtocols(p peer.ID) bool {
+	if err := p.Validate(); err != nil {
+		return false
+	}
+	s := pb.segments.get(p)
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.protocols[p]) > 0
+}
+
+// peers returns the peers with protocols.
+func (pb *memoryProtoBook) peers() peer.IDSlice {
+	var pids peer.IDSlice
+	for _, s := range pb.segments {
+		s.RLock()
+		for p := range s.protocols {
+			pids = append(pids, p)
+		}
+		s.RUnlock()
+	}
+	return pids
+}
@@ -0,0 +1,154 @@
+package pstoremem
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/libp2p/go-libp2p-peerstore/addr"
+)
+
+// In read-mostly mode (see WithReadMostly), the addresses of every segment are also published as an immutable view,
+// swapped atomically by writers under the lock of the segment, RCU-style: readers load the view without taking any
+// lock, and a writer replaces it with an updated copy, which shares all but the changed peers with it (see
+// segmentView). Views are never modified once published, so readers
+// may hold on to them for as long as they like; the garbage collector reclaims them once the last reader is done.
+
+// loadView returns the published view of a segment, which maps the peers of the segment holding addresses, expired
+// ones included, to copies of their addresses, in the order set by sortEntries.
+func (s *addrSegment) loadView() segmentView {
+	v, _ := s.view.Load().(segmentView)
+	return v
+}
+
+// publishUnlocked publishes the addresses of the given peers held by a segment, in an updated copy of its view. To be
+// called with the segment locked, after changing the addresses of peers.
+func (mab *memoryAddrBook) publishUnlocked(s *addrSegment, peers ...peer.ID) {
+	if !mab.readMostly {
+		return
+	}
+	next := s.loadView()
+	for _, p := range peers {
+		next = next.with(p, mab.viewAddrsUnlocked(s, p))
+	}
+	s.view.Store(next)
+}
+
+// republishUnlocked publishes all the addresses held by a segment, in a new view. To be called with the segment
+// locked, after changing the addresses of many peers, e.g. by GC.
+func (mab *memoryAddrBook) republishUnlocked(s *addrSegment) {
+	if !mab.readMostly {
+		return
+	}
+	var next segmentView
+	for p := range s.addrs {
+		next = next.with(p, mab.viewAddrsUnlocked(s, p))
+	}
+	s.view.Store(next)
+}
+
+// viewAddrsUnlocked returns copies of the addresses of a peer, sorted, or nil if it has none. To be called with the
+// segment locked.
+func (mab *memoryAddrBook) viewAddrsUnlocked(s *addrSegment, p peer.ID) []expiringAddr {
+	amap := s.addrs[p]
+	if len(amap) == 0 {
+		return nil
+	}
+	entries := make([]*expiringAddr, 0, len(amap))
+	for _, a := range amap {
+		entries = append(entries, a)
+	}
+	mab.sortEntries(entries)
+	res := make([]expiringAddr, len(entries))
+	for i, e := range entries {
+		res[i] = *e
+	}
+	return res
+}
+
+// readAddrs returns the valid addresses of a peer from the published view of its segment. It takes no lock unless
+// some of the addresses have expired, in which case the protection of the peer is checked, as protected peers retain
+// their expired addresses.
+func (mab *memoryAddrBook) readAddrs(p peer.ID) []ma.Multiaddr {
+	view := mab.segments.get(p).loadView().get(p)
+	now := mab.clock.Now()
+	addrs := make([]ma.Multiaddr, 0, len(view))
+	var expired bool
+	for i := range view {
+		if view[i].ExpiredBy(now) {
+			expired = true
+			continue
+		}
+		addrs = append(addrs, view[i].Addr)
+	}
+	if expired && mab.IsProtected(p, "") {
+		addrs = addrs[:0]
+		for i := range view {
+			addrs = append(addrs, view[i].Addr)
+		}
+	}
+	if mab.aliases {
+		addrs = addr.DedupAliases(addrs)
+	}
+	return addrs
+}
+
+// readHasAddrs is the counterpart of readAddrs for HasAddrs.
+func (mab *memoryAddrBook) readHasAddrs(p peer.ID) bool {
+	view := mab.segments.get(p).loadView().get(p)
+	now := mab.clock.Now()
+	for i := range view {
+		if !view[i].ExpiredBy(now) {
+			return true
+		}
+	}
+	return len(view) > 0 && mab.IsProtected(p, "")
+}
+
+// readPeersWithAddrs is the counterpart of readAddrs for PeersWithAddrs.
+func (mab *memoryAddrBook) readPeersWithAddrs() peer.IDSlice {
+	var pids peer.IDSlice
+	for _, s := range mab.segments {
+		s.loadView().forEach(func(p peer.ID, _ []expiringAddr) {
+			pids = append(pids, p)
+		})
+	}
+	mab.order.peers(pids)
+	return pids
+}
+
+// keyView is the published set of the peers with keys of a key book in read-mostly mode.
+type keyView map[peer.ID]struct{}
+
+// publishUnlocked publishes the peers with keys, if they changed. To be called with the key book locked.
+func (mkb *memoryKeyBook) publishUnlocked(p peer.ID) {
+	if !mkb.readMostly {
+		return
+	}
+	old, _ := mkb.view.Load().(keyView)
+	_, hasPub := mkb.pks[p]
+	_, hasPriv := mkb.sks[p]
+	if _, published := old[p]; published == (hasPub || hasPriv) {
+		return
+	}
+	next := make(keyView, len(old)+1)
+	for q := range old {
+		next[q] = struct{}{}
+	}
+	if hasPub || hasPriv {
+		next[p] = struct{}{}
+	} else {
+		delete(next, p)
+	}
+	mkb.view.Store(next)
+}
+
+// readPeersWithKeys is the counterpart of PeersWithKeys in read-mostly mode, and takes no lock.
+func (mkb *memoryKeyBook) readPeersWithKeys() peer.IDSlice {
+	view, _ := mkb.view.Load().(keyView)
+	ps := make(peer.IDSlice, 0, len(view))
+	for p := range view {
+		ps = append(ps, p)
+	}
+	mkb.order.peers(ps)
+	return ps
+}
@@ -0,0 +1,321 @@
+package pstoremem
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// snapshotMagic identifies snapshots of an in-memory peerstore.
+const snapshotMagic = "libp2p-peerstore-mem-snapshot"
+
+// snapshotVersion is the version of the snapshot format. Fields can be added to snapshotPeer without changing the
+// version, as gob ignores the fields it doesn't know and leaves missing fields zero; incompatible changes must bump
+// it.
+const snapshotVersion = 1
+
+type snapshotHeader struct {
+	Magic   string
+	Version int
+}
+
+// snapshotPeer holds everything known about a peer. Keys, addresses and metadata values are encoded individually, so
+// that one that can't be decoded, e.g. a metadata value of a type not registered with gob, doesn't prevent restoring
+// the others.
+type snapshotPeer struct {
+	ID         []byte
+	Addrs      []snapshotAddr
+	PeerRecord []byte
+	PubKey     []byte
+	PrivKey    []byte
+	Protocols  []string
+	Metadata   map[string][]byte
+	Latency    time.Duration
+	Groups     []string
+	Name       string
+}
+
+type snapshotAddr struct {
+	Addr     []byte
+	TTL      time.Duration
+	Expires  time.Time
+	LastSeen time.Time
+	Added    uint64
+}
+
+// WriteSnapshot writes a snapshot of the peerstore to w: the live addresses, signed peer records, keys, protocols,
+// metadata, latency EWMA, group memberships and names of every peer, so that it can be restored with RestoreSnapshot,
+// e.g. across restarts. Metadata values are gob-encoded, so their types must be registered with gob, like for pstoreds.
+//
+// The snapshot is consistent per peer, but not across peers: writes made while it is taken may or may not be
+// included.
+func (ps *pstoremem) WriteSnapshot(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(&snapshotHeader{Magic: snapshotMagic, Version: snapshotVersion}); err != nil {
+		return err
+	}
+
+	peers := ps.allPeers()
+	sort.Sort(peers)
+	for _, p := range peers {
+		sp, err := ps.snapshotPeer(p)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot peer %s: %s", p.Pretty(), err)
+		}
+		if err := enc.Encode(sp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps *pstoremem) snapshotPeer(p peer.ID) (*snapshotPeer, error) {
+	sp := &snapshotPeer{ID: []byte(p), Latency: ps.LatencyEWMA(p), Groups: ps.memoryGroupBook.Groups(p), Name: ps.memoryNameBook.Name(p)}
+
+	for _, a := range ps.memoryAddrBook.snapshotAddrs(p) {
+		sp.Addrs = append(sp.Addrs, snapshotAddr{Addr: a.Addr.Bytes(), TTL: a.TTL, Expires: a.Expires, LastSeen: a.LastSeen, Added: a.Added})
+	}
+	if env := ps.memoryAddrBook.GetPeerRecord(p); env != nil {
+		b, err := env.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		sp.PeerRecord = b
+	}
+
+	// only snapshot keys that were added explicitly, not those extracted from peer IDs.
+	ps.memoryKeyBook.RLock()
+	pk, sk := ps.memoryKeyBook.pks[p], ps.memoryKeyBook.sks[p]
+	ps.memoryKeyBook.RUnlock()
+	var err error
+	if pk != nil {
+		if sp.PubKey, err = ic.MarshalPublicKey(pk); err != nil {
+			return nil, err
+		}
+	}
+	if sk != nil {
+		if sp.PrivKey, err = ic.MarshalPrivateKey(sk); err != nil {
+			return nil, err
+		}
+	}
+
+	if sp.Protocols, err = ps.memoryProtoBook.GetProtocols(p); err != nil {
+		return nil, err
+	}
+
+	ps.memoryPeerMetadata.dslock.RLock()
+	defer ps.memoryPeerMetadata.dslock.RUnlock()
+	for k, v := range ps.memoryPeerMetadata.ds[p] {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+			return nil, fmt.Errorf("failed to encode metadata %q: %s", k, err)
+		}
+		if sp.Metadata == nil {
+			sp.Metadata = make(map[string][]byte)
+		}
+		sp.Metadata[k] = buf.Bytes()
+	}
+	return sp, nil
+}
+
+// RestoreSnapshot restores a snapshot written by WriteSnapshot into the peerstore, which is expected to be empty.
+// Addresses that expired in the meantime are dropped, and addresses keep their original expiry.
+//
+// Restoring is tolerant of partial failures: items that can't be restored, such as undecodable metadata values or
+// invalid keys, are skipped and counted, and the rest of the peer is restored. If the snapshot is truncated or
+// corrupted, the peers read up to that point remain restored, and the error is returned. Snapshots written by a newer,
+// incompatible version of the format are rejected.
+func (ps *pstoremem) RestoreSnapshot(r io.Reader) (skipped int, err error) {
+	dec := gob.NewDecoder(r)
+	var h snapshotHeader
+	if err := dec.Decode(&h); err != nil {
+		return 0, fmt.Errorf("failed to read snapshot header: %s", err)
+	}
+	if h.Magic != snapshotMagic {
+		return 0, fmt.Errorf("not a peerstore snapshot")
+	}
+	if h.Version > snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d, at most %d is supported", h.Version, snapshotVersion)
+	}
+
+	for {
+		var sp snapshotPeer
+		if err := dec.Decode(&sp); err == io.EOF {
+			return skipped, nil
+		} else if err != nil {
+			return skipped, fmt.Errorf("failed to read snapshot: %s", err)
+		}
+		skipped += ps.restorePeer(&sp)
+	}
+}
+
+// restorePeer restores a peer, and returns the number of items skipped.
+func (ps *pstoremem) restorePeer(sp *snapshotPeer) (skipped int) {
+	p := peer.ID(sp.ID)
+	if err := ps.memoryAddrBook.validateID(p); err != nil {
+		log.Warnf("skipping invalid peer in snapshot: %s", err)
+		return 1
+	}
+	skip := func(what string, err error) {
+		log.Warnf("failed to restore %s of peer %s: %s", what, p.Pretty(), err)
+		skipped++
+	}
+
+	addrs := make([]expiringAddr, 0, len(sp.Addrs))
+	for _, a := range sp.Addrs {
+		addr, err := ma.NewMultiaddrBytes(a.Addr)
+		if err != nil {
+			skip("an address", err)
+			continue
+		}
+		lastSeen := a.LastSeen
+		if lastSeen.IsZero() {
+			// snapshots written before last seen times were tracked; assume the TTL was set then.
+			lastSeen = a.Expires.Add(-a.TTL)
+		}
+		addrs = append(addrs, expiringAddr{Addr: addr, TTL: a.TTL, Expires: a.Expires, LastSeen: lastSeen, Added: a.Added})
+	}
+	if err := ps.memoryAddrBook.restoreAddrs(p, addrs); err != nil {
+		skipped += len(addrs)
+		log.Warnf("failed to restore the addresses of peer %s: %s", p.Pretty(), err)
+	}
+	if sp.PeerRecord != nil {
+		if err := ps.memoryAddrBook.restorePeerRecord(sp.PeerRecord); err != nil {
+			skip("the signed peer record", err)
+		}
+	}
+
+	if sp.PubKey != nil {
+		pk, err := ic.UnmarshalPublicKey(sp.PubKey)
+		if err == nil {
+			err = ps.memoryKeyBook.AddPubKey(p, pk)
+		}
+		if err != nil {
+			skip("the public key", err)
+		}
+	}
+	if sp.PrivKey != nil {
+		sk, err := ic.UnmarshalPrivateKey(sp.PrivKey)
+		if err == nil {
+			err = ps.memoryKeyBook.AddPrivKey(p, sk)
+		}
+		if err != nil {
+			skip("the private key", err)
+		}
+	}
+
+	if len(sp.Protocols) > 0 {
+		if err := ps.memoryProtoBook.AddProtocols(p, sp.Protocols...); err != nil {
+			skip("the protocols", err)
+		}
+	}
+
+	for k, b := range sp.Metadata {
+		var v interface{}
+		err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+		if err == nil {
+			err = ps.memoryPeerMetadata.Put(p, k, v)
+		}
+		if err != nil {
+			skip(fmt.Sprintf("metadata %q", k), err)
+		}
+	}
+
+	if sp.Latency > 0 {
+		ps.RecordLatency(p, sp.Latency)
+	}
+
+	for _, g := range sp.Groups {
+		if err := ps.memoryGroupBook.AddToGroup(p, g); err != nil {
+			skip(fmt.Sprintf("group %q", g), err)
+		}
+	}
+	if sp.Name != "" {
+		if err := ps.memoryNameBook.SetName(p, sp.Name); err != nil {
+			skip(fmt.Sprintf("name %q", sp.Name), err)
+		}
+	}
+	return skipped
+}
+
+// restoreAddrs adds addresses with their original TTL and expiry, dropping those that have expired, unless the peer
+// is protected.
+func (mab *memoryAddrBook) restoreAddrs(p peer.ID, addrs []expiringAddr) error {
+	now := mab.validAt(p)
+	s := mab.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
+
+	if len(addrs) == 0 {
+		return nil
+	}
+	if mab.rejectPeerUnlocked(s, p) {
+		return ErrAddrBookFull
+	}
+	amap, ok := s.addrs[p]
+	if !ok {
+		amap = make(map[string]*expiringAddr)
+		s.addrs[p] = amap
+	}
+	for _, a := range addrs {
+		if now.After(a.Expires) {
+			continue
+		}
+		k := string(a.Addr.Bytes())
+		if _, found := amap[k]; !found {
+			mab.limiter.add(1)
+			mab.subManager.BroadcastAddr(p, a.Addr)
+		}
+		e := a
+		amap[k] = &e
+	}
+	if len(amap) == 0 {
+		s.deletePeerUnlocked(p)
+	}
+	mab.publishUnlocked(s, p)
+	mab.gens.Bump(p)
+	return nil
+}
+
+// snapshotAddrs returns copies of the valid addresses of a peer.
+func (mab *memoryAddrBook) snapshotAddrs(p peer.ID) []expiringAddr {
+	s := mab.segments.get(p)
+	s.RLock()
+	defer s.RUnlock()
+
+	entries := validEntries(s.addrs[p], mab.validAt(p))
+	mab.sortEntries(entries)
+	res := make([]expiringAddr, len(entries))
+	for i, e := range entries {
+		res[i] = *e
+	}
+	return res
+}
+
+// restorePeerRecord restores a signed peer record, without adding its addresses, which are restored separately.
+func (mab *memoryAddrBook) restorePeerRecord(b []byte) error {
+	var rec peer.PeerRecord
+	env, err := record.ConsumeTypedEnvelope(b, &rec)
+	if err != nil {
+		return err
+	}
+	if !rec.PeerID.MatchesPublicKey(env.PublicKey) {
+		return fmt.Errorf("signing key does not match PeerID in PeerRecord")
+	}
+
+	s := mab.segments.get(rec.PeerID)
+	s.Lock()
+	defer s.Unlock()
+	if last, found := s.signedPeerRecords[rec.PeerID]; !found || last.Seq <= rec.Seq {
+		s.signedPeerRecords[rec.PeerID] = &peerRecordState{Envelope: env, Seq: rec.Seq}
+	}
+	return nil
+}
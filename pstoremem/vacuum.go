@@ -0,0 +1,233 @@
+package pstoremem
+
+import (
+	"unsafe"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Go maps never release their buckets as entries are deleted, so a map holds the memory of its peak length until it's
+// reallocated. The maps indexing peers, which grow with the number of known peers, track their peak so that Vacuum,
+// or auto-vacuum (see WithAutoVacuum), can tell which ones are worth reallocating, and how much memory that releases.
+
+// vacuumMinPeak is the smallest peak length of the maps reallocated by auto-vacuum; smaller maps aren't worth it.
+const vacuumMinPeak = 64
+
+// Sizes of the slots of the maps indexing peers, by value type.
+var (
+	ptrSlotBytes   = slotBytes(unsafe.Sizeof(peer.ID("")), unsafe.Sizeof(uintptr(0)))
+	ifaceSlotBytes = slotBytes(unsafe.Sizeof(peer.ID("")), unsafe.Sizeof(ic.PubKey(nil)))
+)
+
+// slotBytes estimates the memory held per entry by a map at its peak: buckets hold 8 keys, 8 values and 8 bytes of
+// hash, and grow once 6.5 entries per bucket are held on average.
+func slotBytes(key, val uintptr) int64 {
+	return int64(key+val+1) * 8 / 6
+}
+
+// mapPeak is the peak length of a map. It's noted before deleting entries, which is enough: a map only grows through
+// insertions, so it can only be longer than its noted peak while nothing was deleted since.
+type mapPeak int
+
+// note records n as the length of the map, before deleting entries.
+func (m *mapPeak) note(n int) {
+	if n > int(*m) {
+		*m = mapPeak(n)
+	}
+}
+
+// due returns whether a map of n entries, which held at least minPeak, shrank below ratio of its peak.
+func (m mapPeak) due(n int, ratio float64, minPeak int) bool {
+	return int(m) >= minPeak && n < int(m) && float64(n) < ratio*float64(m)
+}
+
+// reclaim resets the peak to n, the length of the reallocated map, and returns an estimate of the bytes released.
+func (m *mapPeak) reclaim(n int, slot int64) int64 {
+	reclaimed := int64(int(*m)-n) * slot
+	*m = mapPeak(n)
+	return reclaimed
+}
+
+// Vacuum reallocates the maps indexing the peers of the address book that shrank since their peak, and returns an
+// estimate of the bytes reclaimed. See pstore.Vacuumer.
+func (mab *memoryAddrBook) Vacuum() int64 {
+	var reclaimed int64
+	for _, s := range mab.segments {
+		s.Lock()
+		reclaimed += s.vacuumUnlocked(1, 0)
+		s.Unlock()
+	}
+	return reclaimed
+}
+
+// autoVacuumUnlocked vacuums a segment, if auto-vacuum is enabled and due. To be called with the segment locked, after
+// deleting peers.
+func (mab *memoryAddrBook) autoVacuumUnlocked(s *addrSegment) {
+	if mab.autoVacuum <= 0 {
+		return
+	}
+	if reclaimed := s.vacuumUnlocked(mab.autoVacuum, vacuumMinPeak); reclaimed > 0 {
+		log.Debugf("auto-vacuum of the address book reclaimed about %d bytes", reclaimed)
+	}
+}
+
+// deletePeerUnlocked deletes the addresses of a peer from a segment. To be called with the segment locked.
+func (s *addrSegment) deletePeerUnlocked(p peer.ID) {
+	s.addrsPeak.note(len(s.addrs))
+	delete(s.addrs, p)
+}
+
+// deleteRecordUnlocked deletes the signed peer record of a peer from a segment. To be called with the segment locked.
+func (s *addrSegment) deleteRecordUnlocked(p peer.ID) {
+	s.recordsPeak.note(len(s.signedPeerRecords))
+	delete(s.signedPeerRecords, p)
+}
+
+// vacuumUnlocked reallocates the maps of a segment which are due, and returns an estimate of the bytes reclaimed. To
+// be called with the segment locked.
+func (s *addrSegment) vacuumUnlocked(ratio float64, minPeak int) int64 {
+	var reclaimed int64
+	s.addrsPeak.note(len(s.addrs))
+	if s.addrsPeak.due(len(s.addrs), ratio, minPeak) {
+		addrs := make(map[peer.ID]map[string]*expiringAddr, len(s.addrs))
+		for p, amap := range s.addrs {
+			addrs[p] = amap
+		}
+		s.addrs = addrs
+		reclaimed += s.addrsPeak.reclaim(len(addrs), ptrSlotBytes)
+	}
+	s.recordsPeak.note(len(s.signedPeerRecords))
+	if s.recordsPeak.due(len(s.signedPeerRecords), ratio, minPeak) {
+		records := make(map[peer.ID]*peerRecordState, len(s.signedPeerRecords))
+		for p, rec := range s.signedPeerRecords {
+			records[p] = rec
+		}
+		s.signedPeerRecords = records
+		reclaimed += s.recordsPeak.reclaim(len(records), ptrSlotBytes)
+	}
+	return reclaimed
+}
+
+// Vacuum reallocates the maps of keys that shrank since their peak, and returns an estimate of the bytes reclaimed.
+// See pstore.Vacuumer.
+func (mkb *memoryKeyBook) Vacuum() int64 {
+	mkb.Lock()
+	defer mkb.Unlock()
+	return mkb.vacuumUnlocked(1, 0)
+}
+
+// vacuumUnlocked reallocates the maps of keys which are due, and returns an estimate of the bytes reclaimed. To be
+// called with the key book locked.
+func (mkb *memoryKeyBook) vacuumUnlocked(ratio float64, minPeak int) int64 {
+	var reclaimed int64
+	mkb.pksPeak.note(len(mkb.pks))
+	if mkb.pksPeak.due(len(mkb.pks), ratio, minPeak) {
+		pks := make(map[peer.ID]ic.PubKey, len(mkb.pks))
+		for p, pk := range mkb.pks {
+			pks[p] = pk
+		}
+		mkb.pks = pks
+		reclaimed += mkb.pksPeak.reclaim(len(pks), ifaceSlotBytes)
+	}
+	mkb.sksPeak.note(len(mkb.sks))
+	if mkb.sksPeak.due(len(mkb.sks), ratio, minPeak) {
+		sks := make(map[peer.ID]ic.PrivKey, len(mkb.sks))
+		for p, sk := range mkb.sks {
+			sks[p] = sk
+		}
+		mkb.sks = sks
+		reclaimed += mkb.sksPeak.reclaim(len(sks), ifaceSlotBytes)
+	}
+	return reclaimed
+}
+
+// autoVacuumUnlocked vacuums the key book, if auto-vacuum is enabled and due. To be called with the key book locked,
+// after deleting peers.
+func (mkb *memoryKeyBook) autoVacuumUnlocked() {
+	if mkb.autoVacuum <= 0 {
+		return
+	}
+	if reclaimed := mkb.vacuumUnlocked(mkb.autoVacuum, vacuumMinPeak); reclaimed > 0 {
+		log.Debugf("auto-vacuum of the key book reclaimed about %d bytes", reclaimed)
+	}
+}
+
+// Vacuum reallocates the map of metadata if it shrank since its peak, and returns an estimate of the bytes reclaimed.
+// See pstore.Vacuumer.
+func (ps *memoryPeerMetadata) Vacuum() int64 {
+	ps.dslock.Lock()
+	defer ps.dslock.Unlock()
+	return ps.vacuumUnlocked(1, 0)
+}
+
+// vacuumUnlocked reallocates the map of metadata if due, and returns an estimate of the bytes reclaimed. To be called
+// with dslock held.
+func (ps *memoryPeerMetadata) vacuumUnlocked(ratio float64, minPeak int) int64 {
+	ps.dsPeak.note(len(ps.ds))
+	if !ps.dsPeak.due(len(ps.ds), ratio, minPeak) {
+		return 0
+	}
+	ds := make(map[peer.ID]map[string]interface{}, len(ps.ds))
+	for p, m := range ps.ds {
+		ds[p] = m
+	}
+	ps.ds = ds
+	return ps.dsPeak.reclaim(len(ds), ptrSlotBytes)
+}
+
+// autoVacuumUnlocked vacuums the metadata, if auto-vacuum is enabled and due. To be called with dslock held, after
+// deleting peers.
+func (ps *memoryPeerMetadata) autoVacuumUnlocked() {
+	if ps.autoVacuum <= 0 {
+		return
+	}
+	if reclaimed := ps.vacuumUnlocked(ps.autoVacuum, vacuumMinPeak); reclaimed > 0 {
+		log.Debugf("auto-vacuum of the metadata reclaimed about %d bytes", reclaimed)
+	}
+}
+
+// Vacuum reallocates the maps indexing the peers of the protocol book that shrank since their peak, and returns an
+// estimate of the bytes reclaimed. See pstore.Vacuumer.
+func (pb *memoryProtoBook) Vacuum() int64 {
+	var reclaimed int64
+	for _, s := range pb.segments {
+		s.Lock()
+		reclaimed += s.vacuumUnlocked(1, 0)
+		s.Unlock()
+	}
+	return reclaimed
+}
+
+// autoVacuumUnlocked vacuums a segment, if auto-vacuum is enabled and due. To be called with the segment locked, after
+// deleting peers.
+func (pb *memoryProtoBook) autoVacuumUnlocked(s *protoSegment) {
+	if pb.autoVacuum <= 0 {
+		return
+	}
+	if reclaimed := s.vacuumUnlocked(pb.autoVacuum, vacuumMinPeak); reclaimed > 0 {
+		log.Debugf("auto-vacuum of the protocol book reclaimed about %d bytes", reclaimed)
+	}
+}
+
+// vacuumUnlocked reallocates the map of a segment if due, and returns an estimate of the bytes reclaimed. To be called
+// with the segment locked.
+func (s *protoSegment) vacuumUnlocked(ratio float64, minPeak int) int64 {
+	s.protocolsPeak.note(len(s.protocols))
+	if !s.protocolsPeak.due(len(s.protocols), ratio, minPeak) {
+		return 0
+	}
+	protocols := make(map[peer.ID]map[string]struct{}, len(s.protocols))
+	for p, protos := range s.protocols {
+		protocols[p] = protos
+	}
+	s.protocols = protocols
+	return s.protocolsPeak.reclaim(len(protocols), ptrSlotBytes)
+}
+
+// Vacuum reallocates the maps indexing peers in all books that shrank since their peak, and returns an estimate of the
+// bytes reclaimed. See pstore.Vacuumer.
+func (ps *pstoremem) Vacuum() int64 {
+	return ps.memoryAddrBook.Vacuum() + ps.memoryKeyBook.Vacuum() + ps.memoryProtoBook.Vacuum() +
+		ps.memoryPeerMetadata.Vacuum()
+}
@@ -0,0 +1,238 @@
+package pstoremem
+
+import (
+	"math/bits"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// segmentView is a persistent map from peers to their published addresses: a hash array mapped trie, whose updates
+// copy the path to the updated peer only, and share the rest of the trie with the view they're applied to. Updating a
+// segment of n peers thus costs O(log32 n) allocations and copies, rather than the O(n) of copying a map. The zero
+// value is the empty view.
+type segmentView struct {
+	root *viewNode
+	len  int
+}
+
+const (
+	viewBits = 5
+	viewMask = 1<<viewBits - 1
+)
+
+// viewNode is an inner node of a segmentView, consuming viewBits bits of the hashes of peers. Its children, each a
+// *viewNode or a *viewLeaf, are held in the order of their bits in bitmap.
+type viewNode struct {
+	bitmap   uint32
+	children []interface{}
+}
+
+// viewLeaf holds the peers sharing a hash, almost always a single one.
+type viewLeaf struct {
+	hash  uint64
+	peers []viewEntry
+}
+
+type viewEntry struct {
+	id    peer.ID
+	addrs []expiringAddr
+}
+
+// viewHash returns the FNV-1a hash of a peer ID.
+func viewHash(p peer.ID) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(p); i++ {
+		h ^= uint64(p[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// get returns the addresses of a peer, or nil if it has none.
+func (v segmentView) get(p peer.ID) []expiringAddr {
+	return v.getHash(viewHash(p), p)
+}
+
+func (v segmentView) getHash(h uint64, p peer.ID) []expiringAddr {
+	n := v.root
+	for shift := uint(0); n != nil; shift += viewBits {
+		bit := uint32(1) << ((h >> shift) & viewMask)
+		if n.bitmap&bit == 0 {
+			return nil
+		}
+		switch c := n.children[bits.OnesCount32(n.bitmap&(bit-1))].(type) {
+		case *viewNode:
+			n = c
+		case *viewLeaf:
+			if c.hash != h {
+				return nil
+			}
+			for _, e := range c.peers {
+				if e.id == p {
+					return e.addrs
+				}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// with returns a copy of the view holding the given addresses for a peer, or not holding the peer if addrs is nil.
+func (v segmentView) with(p peer.ID, addrs []expiringAddr) segmentView {
+	return v.withHash(viewHash(p), p, addrs)
+}
+
+func (v segmentView) withHash(h uint64, p peer.ID, addrs []expiringAddr) segmentView {
+	if addrs == nil {
+		if v.root == nil {
+			return v
+		}
+		root, removed := v.root.remove(0, h, p)
+		if removed {
+			v.root, v.len = root, v.len-1
+		}
+		return v
+	}
+	root := v.root
+	if root == nil {
+		root = &viewNode{}
+	}
+	root, added := root.put(0, h, viewEntry{id: p, addrs: addrs})
+	v.root = root
+	if added {
+		v.len++
+	}
+	return v
+}
+
+// forEach calls f with every peer of the view and its addresses.
+func (v segmentView) forEach(f func(p peer.ID, addrs []expiringAddr)) {
+	if v.root != nil {
+		v.root.forEach(f)
+	}
+}
+
+func (n *viewNode) forEach(f func(p peer.ID, addrs []expiringAddr)) {
+	for _, c := range n.children {
+		switch c := c.(type) {
+		case *viewNode:
+			c.forEach(f)
+		case *viewLeaf:
+			for _, e := range c.peers {
+				f(e.id, e.addrs)
+			}
+		}
+	}
+}
+
+// put returns a copy of n holding e, whose peer hashes to h, and whether the peer was added rather than updated.
+func (n *viewNode) put(shift uint, h uint64, e viewEntry) (*viewNode, bool) {
+	bit := uint32(1) << ((h >> shift) & viewMask)
+	i := bits.OnesCount32(n.bitmap & (bit - 1))
+	if n.bitmap&bit == 0 {
+		children := make([]interface{}, len(n.children)+1)
+		copy(children, n.children[:i])
+		children[i] = &viewLeaf{hash: h, peers: []viewEntry{e}}
+		copy(children[i+1:], n.children[i:])
+		return &viewNode{bitmap: n.bitmap | bit, children: children}, true
+	}
+
+	switch c := n.children[i].(type) {
+	case *viewNode:
+		child, added := c.put(shift+viewBits, h, e)
+		return n.replace(i, child), added
+	case *viewLeaf:
+		if c.hash == h {
+			leaf, added := c.put(e)
+			return n.replace(i, leaf), added
+		}
+		// the hashes differ further down; push the leaf into a new node.
+		child := &viewNode{bitmap: uint32(1) << ((c.hash >> (shift + viewBits)) & viewMask), children: []interface{}{c}}
+		child, _ = child.put(shift+viewBits, h, e)
+		return n.replace(i, child), true
+	}
+	panic("unreachable")
+}
+
+// remove returns a copy of n not holding p, which hashes to h, or nil if it's left empty, and whether p was removed.
+func (n *viewNode) remove(shift uint, h uint64, p peer.ID) (*viewNode, bool) {
+	bit := uint32(1) << ((h >> shift) & viewMask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	i := bits.OnesCount32(n.bitmap & (bit - 1))
+
+	var child interface{}
+	switch c := n.children[i].(type) {
+	case *viewNode:
+		next, removed := c.remove(shift+viewBits, h, p)
+		if !removed {
+			return n, false
+		}
+		if next != nil {
+			child = next
+			if leaf, ok := next.children[0].(*viewLeaf); ok && len(next.children) == 1 {
+				// a node left with a single leaf is replaced with the leaf.
+				child = leaf
+			}
+		}
+	case *viewLeaf:
+		if c.hash != h {
+			return n, false
+		}
+		next, removed := c.remove(p)
+		if !removed {
+			return n, false
+		}
+		if next != nil {
+			child = next
+		}
+	}
+	if child != nil {
+		return n.replace(i, child), true
+	}
+
+	if n.bitmap == bit {
+		return nil, true
+	}
+	children := make([]interface{}, len(n.children)-1)
+	copy(children, n.children[:i])
+	copy(children[i:], n.children[i+1:])
+	return &viewNode{bitmap: n.bitmap &^ bit, children: children}, true
+}
+
+// replace returns a copy of n with its i-th child replaced.
+func (n *viewNode) replace(i int, child interface{}) *viewNode {
+	children := make([]interface{}, len(n.children))
+	copy(children, n.children)
+	children[i] = child
+	return &viewNode{bitmap: n.bitmap, children: children}
+}
+
+func (l *viewLeaf) put(e viewEntry) (*viewLeaf, bool) {
+	peers := make([]viewEntry, len(l.peers), len(l.peers)+1)
+	copy(peers, l.peers)
+	for i := range peers {
+		if peers[i].id == e.id {
+			peers[i] = e
+			return &viewLeaf{hash: l.hash, peers: peers}, false
+		}
+	}
+	return &viewLeaf{hash: l.hash, peers: append(peers, e)}, true
+}
+
+func (l *viewLeaf) remove(p peer.ID) (*viewLeaf, bool) {
+	for i := range l.peers {
+		if l.peers[i].id != p {
+			continue
+		}
+		if len(l.peers) == 1 {
+			return nil, true
+		}
+		peers := make([]viewEntry, 0, len(l.peers)-1)
+		peers = append(peers, l.peers[:i]...)
+		return &viewLeaf{hash: l.hash, peers: append(peers, l.peers[i+1:]...)}, true
+	}
+	return l, false
+}
@@ -3,11 +3,12 @@ package queue
 import (
 	"context"
 
-	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-core/peer"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
-var log = logging.Logger("peerqueue")
+var log = pstore.SubsystemLogger("peerqueue")
 
 // ChanQueue makes any PeerQueue synchronizable through channels.
 type ChanQueue struct {
@@ -32,8 +33,8 @@ func (cq *ChanQueue) process(ctx context.Context) {
 	cq.DeqChan = deqChan
 
 	go func() {
-		log.Debug("processing")
-		defer log.Debug("closed")
+		log.Debugf("processing")
+		defer log.Debugf("closed")
 		defer close(deqChan)
 
 		var next peer.ID
@@ -0,0 +1,158 @@
+package peerstore
+
+import (
+	"encoding/gob"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// ReachabilityKey is the metadata key under which ReachabilityBook stores the reachability of a peer.
+const ReachabilityKey = "reachability"
+
+// ReachabilityFailureThreshold is the number of consecutive failed direct dials after which a peer is no longer
+// considered publicly reachable.
+var ReachabilityFailureThreshold = 3
+
+func init() {
+	// allow datastore-backed metadata books to persist reachability.
+	gob.Register(PeerReachability{})
+}
+
+// Reachability is how a peer can be reached.
+type Reachability int
+
+const (
+	// ReachabilityUnknown means there isn't enough evidence to tell how the peer can be reached.
+	ReachabilityUnknown Reachability = iota
+	// ReachabilityPublic means the peer can be dialed directly.
+	ReachabilityPublic
+	// ReachabilityPrivate means the peer is behind a NAT: it can't be dialed directly without help, e.g. hole
+	// punching.
+	ReachabilityPrivate
+	// ReachabilityRelayOnly means the peer can only be reached through a relay.
+	ReachabilityRelayOnly
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case ReachabilityPublic:
+		return "public"
+	case ReachabilityPrivate:
+		return "private"
+	case ReachabilityRelayOnly:
+		return "relay-only"
+	default:
+		return "unknown"
+	}
+}
+
+// ReachabilityEvent is an observation about how a peer can be reached, fed to ReachabilityBook.
+type ReachabilityEvent int
+
+const (
+	// DirectDialSucceeded is observed when the peer was dialed on a direct (non-relayed) address.
+	DirectDialSucceeded ReachabilityEvent = iota
+	// DirectDialFailed is observed when dialing all the direct addresses of the peer failed.
+	DirectDialFailed
+	// RelayDialSucceeded is observed when the peer was dialed through a relay.
+	RelayDialSucceeded
+	// ReportedPublic is observed when the peer is reported to be publicly reachable, e.g. by AutoNAT.
+	ReportedPublic
+	// ReportedPrivate is observed when the peer is reported to be behind a NAT, e.g. by AutoNAT.
+	ReportedPrivate
+)
+
+// PeerReachability is the reachability state of a peer, as stored by ReachabilityBook.
+type PeerReachability struct {
+	State Reachability
+	// Failures is the number of consecutive failed direct dials.
+	Failures int
+	// Updated is a Unix timestamp in nanoseconds of the last observation.
+	Updated int64
+}
+
+// next returns the state after observing ev.
+func (pr PeerReachability) next(ev ReachabilityEvent) PeerReachability {
+	switch ev {
+	case DirectDialSucceeded:
+		pr.State, pr.Failures = ReachabilityPublic, 0
+	case DirectDialFailed:
+		pr.Failures++
+		if pr.State == ReachabilityPublic && pr.Failures >= ReachabilityFailureThreshold {
+			pr.State = ReachabilityUnknown
+		}
+	case RelayDialSucceeded:
+		// reaching a peer through a relay says nothing about direct dials, unless those keep failing.
+		if pr.State != ReachabilityPublic && pr.Failures >= ReachabilityFailureThreshold {
+			pr.State = ReachabilityRelayOnly
+		}
+	case ReportedPublic:
+		pr.State, pr.Failures = ReachabilityPublic, 0
+	case ReportedPrivate:
+		// a peer we can only reach through relays is behind a NAT too; keep the more specific state.
+		if pr.State != ReachabilityRelayOnly {
+			pr.State = ReachabilityPrivate
+		}
+	}
+	return pr
+}
+
+// ReachabilityBook tracks the reachability of peers in the metadata of a peerstore, under ReachabilityKey, so that
+// subsystems share a single view of it, which persists along with the peerstore. The state of a peer moves as
+// observations are recorded:
+//
+// * Direct dials succeeding, or reports of public reachability, make the peer public.
+// * ReachabilityFailureThreshold consecutive failed direct dials make a public peer unknown.
+// * Relayed dials succeeding after as many failed direct dials make the peer relay-only, unless it is public.
+// * Reports of the peer being behind a NAT make it private, unless it is relay-only.
+//
+// Writes are serialized by the ReachabilityBook, which should thus be shared by all writers of a peerstore.
+type ReachabilityBook struct {
+	md    pstore.PeerMetadata
+	clock Clock
+
+	mu sync.Mutex
+}
+
+// NewReachabilityBook creates a ReachabilityBook backed by md.
+func NewReachabilityBook(md pstore.PeerMetadata) *ReachabilityBook {
+	return &ReachabilityBook{md: md, clock: RealClock{}}
+}
+
+// Reachability returns the reachability of a peer, ReachabilityUnknown if nothing was recorded for it.
+func (rb *ReachabilityBook) Reachability(p peer.ID) Reachability {
+	return rb.load(p).State
+}
+
+// PeerReachability returns the full reachability state of a peer.
+func (rb *ReachabilityBook) PeerReachability(p peer.ID) PeerReachability {
+	return rb.load(p)
+}
+
+// Record records an observation about how a peer can be reached, and returns its resulting reachability.
+func (rb *ReachabilityBook) Record(p peer.ID, ev ReachabilityEvent) (Reachability, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	pr := rb.load(p).next(ev)
+	pr.Updated = rb.clock.Now().UnixNano()
+	if err := rb.md.Put(p, ReachabilityKey, pr); err != nil {
+		return ReachabilityUnknown, err
+	}
+	return pr.State, nil
+}
+
+func (rb *ReachabilityBook) load(p peer.ID) PeerReachability {
+	v, err := rb.md.Get(p, ReachabilityKey)
+	if err != nil {
+		return PeerReachability{}
+	}
+	pr, ok := v.(PeerReachability)
+	if !ok {
+		log.Debugf("unexpected type %T under metadata key %s for peer %s", v, ReachabilityKey, p.Pretty())
+		return PeerReachability{}
+	}
+	return pr
+}
@@ -0,0 +1,90 @@
+package peerstore_test
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestReachabilityBook(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+	rb := pstore.NewReachabilityBook(ps)
+	ids := pt.GeneratePeerIDs(3)
+
+	record := func(p peer.ID, want pstore.Reachability, evs ...pstore.ReachabilityEvent) {
+		t.Helper()
+		var (
+			got pstore.Reachability
+			err error
+		)
+		for _, ev := range evs {
+			if got, err = rb.Record(p, ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got != want || rb.Reachability(p) != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+
+	if r := rb.Reachability(ids[0]); r != pstore.ReachabilityUnknown {
+		t.Fatalf("expected unknown peer to be %s, got %s", pstore.ReachabilityUnknown, r)
+	}
+
+	// a public peer is demoted once direct dials keep failing, and promoted again on success.
+	record(ids[0], pstore.ReachabilityPublic, pstore.DirectDialSucceeded)
+	record(ids[0], pstore.ReachabilityPublic, pstore.DirectDialFailed, pstore.DirectDialFailed)
+	record(ids[0], pstore.ReachabilityUnknown, pstore.DirectDialFailed)
+	record(ids[0], pstore.ReachabilityPublic, pstore.DirectDialSucceeded)
+	if n := rb.PeerReachability(ids[0]).Failures; n != 0 {
+		t.Fatalf("expected failures to be reset, got %d", n)
+	}
+
+	// relayed dials only make a peer relay-only once direct dials failed.
+	record(ids[1], pstore.ReachabilityUnknown, pstore.RelayDialSucceeded)
+	record(ids[1], pstore.ReachabilityPrivate, pstore.ReportedPrivate)
+	record(ids[1], pstore.ReachabilityRelayOnly,
+		pstore.DirectDialFailed, pstore.DirectDialFailed, pstore.DirectDialFailed, pstore.RelayDialSucceeded)
+	record(ids[1], pstore.ReachabilityRelayOnly, pstore.ReportedPrivate)
+	record(ids[1], pstore.ReachabilityPublic, pstore.ReportedPublic)
+
+	// the state is shared by all books of a peerstore.
+	if r := pstore.NewReachabilityBook(ps).Reachability(ids[1]); r != pstore.ReachabilityPublic {
+		t.Fatalf("expected another book to see %s, got %s", pstore.ReachabilityPublic, r)
+	}
+	if r := rb.Reachability(ids[2]); r != pstore.ReachabilityUnknown {
+		t.Fatalf("expected untouched peer to be %s, got %s", pstore.ReachabilityUnknown, r)
+	}
+}
+
+func TestReachabilityBookPersistence(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	p := pt.GeneratePeerIDs(1)[0]
+
+	ps, err := pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pstore.NewReachabilityBook(ps).Record(p, pstore.ReportedPrivate); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+
+	ps, err = pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	if r := pstore.NewReachabilityBook(ps).Reachability(p); r != pstore.ReachabilityPrivate {
+		t.Fatalf("expected reachability to persist as %s, got %s", pstore.ReachabilityPrivate, r)
+	}
+}
@@ -0,0 +1,196 @@
+package peerstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Refresher looks up the current addresses of a peer, e.g. through a DHT FindPeer query.
+type Refresher func(ctx context.Context, p peer.ID) ([]ma.Multiaddr, error)
+
+// RefresherOption configures an AddrRefresher.
+type RefresherOption func(*refresherConfig)
+
+type refresherConfig struct {
+	interval time.Duration
+	lead     time.Duration
+	ttl      time.Duration
+	timeout  time.Duration
+}
+
+// WithRefreshInterval sets how often watched peers are checked. Defaults to one minute.
+func WithRefreshInterval(d time.Duration) RefresherOption {
+	return func(cfg *refresherConfig) {
+		cfg.interval = d
+	}
+}
+
+// WithRefreshLead sets how long before their last address expires peers are refreshed. Defaults to five minutes.
+func WithRefreshLead(d time.Duration) RefresherOption {
+	return func(cfg *refresherConfig) {
+		cfg.lead = d
+	}
+}
+
+// WithRefreshTTL sets the TTL refreshed addresses are added with. It must be larger than the refresh lead.
+// Defaults to AddressTTL.
+func WithRefreshTTL(d time.Duration) RefresherOption {
+	return func(cfg *refresherConfig) {
+		cfg.ttl = d
+	}
+}
+
+// WithRefreshTimeout bounds the duration of each call to the Refresher. Defaults to 30 seconds.
+func WithRefreshTimeout(d time.Duration) RefresherOption {
+	return func(cfg *refresherConfig) {
+		cfg.timeout = d
+	}
+}
+
+// AddrRefresher proactively renews the addresses of a set of watched peers, e.g. pinned or otherwise important
+// peers, by invoking a Refresher shortly before their last known address expires. Peers without any known
+// addresses are refreshed on every check.
+//
+// The address book must implement ExpiringAddrBook.
+type AddrRefresher struct {
+	ab      pstore.AddrBook
+	eab     ExpiringAddrBook
+	refresh Refresher
+	cfg     refresherConfig
+
+	mu    sync.Mutex
+	peers map[peer.ID]struct{}
+
+	ctx    context.Context
+	cancel func()
+	done   chan struct{}
+}
+
+// NewAddrRefresher creates an AddrRefresher and starts its background process. It must be closed when no longer
+// needed.
+func NewAddrRefresher(ab pstore.AddrBook, refresh Refresher, opts ...RefresherOption) (*AddrRefresher, error) {
+	eab, ok := ab.(ExpiringAddrBook)
+	if !ok {
+		return nil, fmt.Errorf("address book does not expose address expiry")
+	}
+
+	cfg := refresherConfig{
+		interval: time.Minute,
+		lead:     5 * time.Minute,
+		ttl:      pstore.AddressTTL,
+		timeout:  30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.interval <= 0 {
+		return nil, fmt.Errorf("refresh interval must be positive: %s", cfg.interval)
+	}
+	if cfg.ttl <= cfg.lead {
+		return nil, fmt.Errorf("refresh TTL must be larger than refresh lead, respectively: %s, %s", cfg.ttl, cfg.lead)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &AddrRefresher{
+		ab:      ab,
+		eab:     eab,
+		refresh: refresh,
+		cfg:     cfg,
+		peers:   make(map[peer.ID]struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go r.background()
+	return r, nil
+}
+
+// Watch adds peers to the set of peers whose addresses are kept fresh.
+func (r *AddrRefresher) Watch(peers ...peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range peers {
+		r.peers[p] = struct{}{}
+	}
+}
+
+// Unwatch removes peers from the set of peers whose addresses are kept fresh.
+func (r *AddrRefresher) Unwatch(peers ...peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range peers {
+		delete(r.peers, p)
+	}
+}
+
+// Watched returns the peers whose addresses are kept fresh.
+func (r *AddrRefresher) Watched() peer.IDSlice {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ps := make(peer.IDSlice, 0, len(r.peers))
+	for p := range r.peers {
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// Close stops the background process, cancelling in-flight refreshes.
+func (r *AddrRefresher) Close() error {
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+func (r *AddrRefresher) background() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.refreshDue()
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshDue refreshes all watched peers whose addresses are about to expire.
+func (r *AddrRefresher) refreshDue() {
+	deadline := time.Now().Add(r.cfg.lead)
+	for _, p := range r.Watched() {
+		if r.ctx.Err() != nil {
+			return
+		}
+		if !r.due(p, deadline) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(r.ctx, r.cfg.timeout)
+		addrs, err := r.refresh(ctx, p)
+		cancel()
+		if err != nil {
+			log.Debugf("failed to refresh addresses for peer %s: %s", p.Pretty(), err)
+			continue
+		}
+		r.ab.AddAddrs(p, addrs, r.cfg.ttl)
+	}
+}
+
+// due returns whether the last known address of a peer expires before the deadline.
+func (r *AddrRefresher) due(p peer.ID, deadline time.Time) bool {
+	for _, a := range r.eab.AddrsWithExpiry(p) {
+		if a.Expires.After(deadline) {
+			return false
+		}
+	}
+	return true
+}
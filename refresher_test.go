@@ -0,0 +1,77 @@
+package peerstore_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestAddrRefresher(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(3)
+	addrs := pt.GenerateAddrs(4)
+
+	// ids[0] is about to expire, ids[1] is fresh, ids[2] is about to expire but not watched.
+	ps.AddAddr(ids[0], addrs[0], 30*time.Second)
+	ps.AddAddr(ids[1], addrs[1], time.Hour)
+	ps.AddAddr(ids[2], addrs[2], 30*time.Second)
+
+	var (
+		mu        sync.Mutex
+		refreshed = make(map[peer.ID]int)
+	)
+	refresh := func(ctx context.Context, p peer.ID) ([]ma.Multiaddr, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		refreshed[p]++
+		return []ma.Multiaddr{addrs[3]}, nil
+	}
+
+	r, err := pstore.NewAddrRefresher(ps, refresh,
+		pstore.WithRefreshInterval(10*time.Millisecond),
+		pstore.WithRefreshLead(time.Minute),
+		pstore.WithRefreshTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Watch(ids[0], ids[1])
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if refreshed[ids[0]] != 1 {
+		t.Errorf("expected peer about to expire to be refreshed once, got %d", refreshed[ids[0]])
+	}
+	if refreshed[ids[1]] != 0 {
+		t.Error("expected fresh peer not to be refreshed")
+	}
+	if refreshed[ids[2]] != 0 {
+		t.Error("expected unwatched peer not to be refreshed")
+	}
+	pt.AssertAddressesEqual(t, []ma.Multiaddr{addrs[0], addrs[3]}, ps.Addrs(ids[0]))
+}
+
+func TestAddrRefresherOptions(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	noop := func(context.Context, peer.ID) ([]ma.Multiaddr, error) { return nil, nil }
+	if _, err := pstore.NewAddrRefresher(ps, noop, pstore.WithRefreshLead(time.Hour), pstore.WithRefreshTTL(time.Minute)); err == nil {
+		t.Error("expected error when the refresh TTL is shorter than the lead")
+	}
+	if _, err := pstore.NewAddrRefresher(ps, noop, pstore.WithRefreshInterval(0)); err == nil {
+		t.Error("expected error for a zero refresh interval")
+	}
+}
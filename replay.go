@@ -0,0 +1,162 @@
+package peerstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ReplayOption configures ReplayTrace.
+type ReplayOption func(*replayConfig)
+
+type replayConfig struct {
+	speed float64
+}
+
+// WithReplaySpeed paces the replay after the recorded timestamps, sped up by factor; e.g. a factor of 2 replays an
+// hour of traffic in 30 minutes. By default, entries are replayed back to back, as fast as possible.
+func WithReplaySpeed(factor float64) ReplayOption {
+	return func(cfg *replayConfig) {
+		cfg.speed = factor
+	}
+}
+
+// ReplayStats summarises a replay.
+type ReplayStats struct {
+	// Ops is the number of calls made to the peerstore.
+	Ops int
+	// Skipped is the number of entries that could not be replayed, i.e. AddPubKey and AddPrivKey calls, as traces
+	// don't carry keys.
+	Skipped int
+	// Elapsed is the wall time the replay took.
+	Elapsed time.Duration
+}
+
+// ReplayTrace drives ps with the calls recorded in the trace, until the trace is exhausted.
+//
+// Traces only record the shape of calls, so payloads are synthesised: peers get as many addresses or protocols as
+// were passed in the recorded call, drawn from a fixed set of placeholders, and metadata values are placeholders.
+func ReplayTrace(ps pstore.Peerstore, tr *TraceReader, opts ...ReplayOption) (ReplayStats, error) {
+	var cfg replayConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.speed < 0 {
+		return ReplayStats{}, fmt.Errorf("replay speed must not be negative: %f", cfg.speed)
+	}
+
+	var (
+		stats   ReplayStats
+		start   = time.Now()
+		first   time.Time
+		payload replayPayload
+	)
+	for {
+		e, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			stats.Elapsed = time.Since(start)
+			return stats, err
+		}
+
+		if cfg.speed > 0 {
+			if first.IsZero() {
+				first = e.Time
+			}
+			offset := time.Duration(float64(e.Time.Sub(first)) / cfg.speed)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		if replayEntry(ps, e, &payload) {
+			stats.Ops++
+		} else {
+			stats.Skipped++
+		}
+	}
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+// replayEntry makes the call recorded by the entry, returning false if it can't be replayed.
+func replayEntry(ps pstore.Peerstore, e TraceEntry, payload *replayPayload) bool {
+	p := e.Peer
+	switch e.Op {
+	case TraceAddAddrs:
+		ps.AddAddrs(p, payload.addrs(e.Count), e.TTL)
+	case TraceSetAddrs:
+		ps.SetAddrs(p, payload.addrs(e.Count), e.TTL)
+	case TraceUpdateAddrs:
+		ps.UpdateAddrs(p, e.OldTTL, e.TTL)
+	case TraceAddrs:
+		ps.Addrs(p)
+	case TraceAddrStream:
+		ctx, cancel := context.WithCancel(context.Background())
+		ps.AddrStream(ctx, p)
+		cancel()
+	case TraceClearAddrs:
+		ps.ClearAddrs(p)
+	case TracePeersWithAddrs:
+		ps.PeersWithAddrs()
+	case TracePubKey:
+		ps.PubKey(p)
+	case TracePrivKey:
+		ps.PrivKey(p)
+	case TracePeersWithKeys:
+		ps.PeersWithKeys()
+	case TraceGet:
+		ps.Get(p, replayMetadataKey)
+	case TracePut:
+		ps.Put(p, replayMetadataKey, e.Count)
+	case TraceRecordLatency:
+		ps.RecordLatency(p, e.TTL)
+	case TraceLatencyEWMA:
+		ps.LatencyEWMA(p)
+	case TraceGetProtocols:
+		ps.GetProtocols(p)
+	case TraceAddProtocols:
+		ps.AddProtocols(p, payload.protos(e.Count)...)
+	case TraceSetProtocols:
+		ps.SetProtocols(p, payload.protos(e.Count)...)
+	case TraceRemoveProtocols:
+		ps.RemoveProtocols(p, payload.protos(e.Count)...)
+	case TraceSupportsProtocols:
+		ps.SupportsProtocols(p, payload.protos(e.Count)...)
+	case TracePeerInfo:
+		ps.PeerInfo(p)
+	case TracePeers:
+		ps.Peers()
+	default:
+		return false
+	}
+	return true
+}
+
+const replayMetadataKey = "replay"
+
+// replayPayload lazily generates the placeholder addresses and protocols passed in replayed calls.
+type replayPayload struct {
+	addrSet  []ma.Multiaddr
+	protoSet []string
+}
+
+func (rp *replayPayload) addrs(n int) []ma.Multiaddr {
+	for i := len(rp.addrSet); i < n; i++ {
+		rp.addrSet = append(rp.addrSet, ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", 1024+i)))
+	}
+	return rp.addrSet[:n]
+}
+
+func (rp *replayPayload) protos(n int) []string {
+	for i := len(rp.protoSet); i < n; i++ {
+		rp.protoSet = append(rp.protoSet, fmt.Sprintf("/replay/%d", i))
+	}
+	return rp.protoSet[:n]
+}
@@ -0,0 +1,79 @@
+package peerstore
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// Metadata keys under which RoutingMetrics stores per-peer routing state. Values are int64 Unix timestamps in
+// nanoseconds, which every PeerMetadata implementation can persist without registering types. Other subsystems may
+// read these keys, but should write them through RoutingMetrics.
+const (
+	// LastUsefulKey holds the last time a peer was useful to us, e.g. by answering a routing query with closer peers.
+	LastUsefulKey = "routing:LastUseful"
+	// LastSuccessfulOutboundQueryKey holds the last time we successfully queried a peer.
+	LastSuccessfulOutboundQueryKey = "routing:LastSuccessfulOutboundQuery"
+)
+
+// RoutingMetrics adapts a peerstore to the per-peer queries of routing tables such as go-libp2p-kbucket, so they can
+// read latency from the peerstore Metrics and usefulness from its metadata, instead of maintaining parallel maps.
+type RoutingMetrics struct {
+	ps    pstore.Peerstore
+	clock Clock
+}
+
+// NewRoutingMetrics creates a RoutingMetrics backed by ps.
+func NewRoutingMetrics(ps pstore.Peerstore) *RoutingMetrics {
+	return &RoutingMetrics{ps: ps, clock: RealClock{}}
+}
+
+// PeerLatency returns the latency EWMA of the peer, or zero if it was never measured.
+func (rm *RoutingMetrics) PeerLatency(p peer.ID) time.Duration {
+	return rm.ps.LatencyEWMA(p)
+}
+
+// LastUsefulAt returns the last time the peer was useful, or the zero time if it never was.
+func (rm *RoutingMetrics) LastUsefulAt(p peer.ID) time.Time {
+	return rm.getTime(p, LastUsefulKey)
+}
+
+// MarkUseful records that the peer was just useful.
+func (rm *RoutingMetrics) MarkUseful(p peer.ID) error {
+	return rm.putTime(p, LastUsefulKey, rm.clock.Now())
+}
+
+// LastSuccessfulOutboundQueryAt returns the last time we successfully queried the peer, or the zero time if we
+// never did.
+func (rm *RoutingMetrics) LastSuccessfulOutboundQueryAt(p peer.ID) time.Time {
+	return rm.getTime(p, LastSuccessfulOutboundQueryKey)
+}
+
+// MarkSuccessfulOutboundQuery records that we just successfully queried the peer.
+func (rm *RoutingMetrics) MarkSuccessfulOutboundQuery(p peer.ID) error {
+	return rm.putTime(p, LastSuccessfulOutboundQueryKey, rm.clock.Now())
+}
+
+// FilterKnown splits candidate peers into those with addresses in the peerstore and the others, so that queries can
+// skip the peers they couldn't dial. See the package-level FilterKnown.
+func (rm *RoutingMetrics) FilterKnown(peers []peer.ID) (known, unknown []peer.ID) {
+	return FilterKnown(rm.ps, peers)
+}
+
+func (rm *RoutingMetrics) getTime(p peer.ID, key string) time.Time {
+	v, err := rm.ps.Get(p, key)
+	if err != nil {
+		return time.Time{}
+	}
+	ns, ok := v.(int64)
+	if !ok {
+		log.Debugf("unexpected type %T under metadata key %s for peer %s", v, key, p.Pretty())
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (rm *RoutingMetrics) putTime(p peer.ID, key string, t time.Time) error {
+	return rm.ps.Put(p, key, t.UnixNano())
+}
@@ -0,0 +1,85 @@
+package peerstore_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestRoutingMetrics(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	p := pt.GeneratePeerIDs(1)[0]
+	rm := pstore.NewRoutingMetrics(ps)
+
+	if !rm.LastUsefulAt(p).IsZero() || !rm.LastSuccessfulOutboundQueryAt(p).IsZero() {
+		t.Fatal("expected zero times for unknown peer")
+	}
+	if lat := rm.PeerLatency(p); lat != 0 {
+		t.Fatalf("expected no latency, got %s", lat)
+	}
+
+	before := time.Now()
+	if err := rm.MarkUseful(p); err != nil {
+		t.Fatal(err)
+	}
+	if at := rm.LastUsefulAt(p); at.Before(before) || at.After(time.Now()) {
+		t.Fatalf("unexpected last useful time %s", at)
+	}
+	if !rm.LastSuccessfulOutboundQueryAt(p).IsZero() {
+		t.Fatal("expected last successful query to be unset")
+	}
+
+	if err := rm.MarkSuccessfulOutboundQuery(p); err != nil {
+		t.Fatal(err)
+	}
+	if at := rm.LastSuccessfulOutboundQueryAt(p); at.Before(before) {
+		t.Fatalf("unexpected last successful query time %s", at)
+	}
+
+	// shared with the peerstore.
+	ps.RecordLatency(p, 10*time.Millisecond)
+	if lat := rm.PeerLatency(p); lat != 10*time.Millisecond {
+		t.Fatalf("expected latency of 10ms, got %s", lat)
+	}
+	if v, err := ps.Get(p, pstore.LastUsefulKey); err != nil || v.(int64) != rm.LastUsefulAt(p).UnixNano() {
+		t.Fatalf("unexpected metadata value %v (err: %v)", v, err)
+	}
+}
+
+func TestRoutingFilterKnown(t *testing.T) {
+	mem := pstoremem.NewPeerstore()
+	defer mem.Close()
+	// the generic peerstore implements neither KnownFilter nor PeerExistence.
+	ab := pstoremem.NewAddrBook()
+	defer ab.Close()
+	generic := pstore.NewPeerstore(pstoremem.NewKeyBook(), ab, pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+
+	ids := pt.GeneratePeerIDs(4)
+	addrs := pt.GenerateAddrs(1)
+	mem.AddAddrs(ids[1], addrs, time.Hour)
+	mem.AddAddrs(ids[3], addrs, time.Hour)
+	generic.AddAddrs(ids[1], addrs, time.Hour)
+	generic.AddAddrs(ids[3], addrs, time.Hour)
+
+	for name, rm := range map[string]*pstore.RoutingMetrics{
+		"KnownFilter": pstore.NewRoutingMetrics(mem),
+		"Fallback":    pstore.NewRoutingMetrics(generic),
+	} {
+		candidates := []peer.ID{ids[3], ids[0], ids[1], ids[2]}
+		known, unknown := rm.FilterKnown(candidates)
+		if !reflect.DeepEqual(known, []peer.ID{ids[3], ids[1]}) {
+			t.Fatalf("%s: unexpected known peers %v", name, known)
+		}
+		if !reflect.DeepEqual(unknown, []peer.ID{ids[0], ids[2]}) {
+			t.Fatalf("%s: unexpected unknown peers %v", name, unknown)
+		}
+	}
+}
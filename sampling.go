@@ -0,0 +1,143 @@
+package peerstore
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// unmeasuredLatency is the latency assumed for peers without measurements when weighting samples by latency.
+const unmeasuredLatency = time.Second
+
+// SampleOption configures the behaviour of SamplePeers.
+type SampleOption func(*sampleConfig)
+
+type sampleConfig struct {
+	weight func(peer.ID) float64
+	protos []string
+	rng    *rand.Rand
+}
+
+// WithSampleWeight weights the probability of selecting a peer by the value returned by fn. Peers with a weight <= 0
+// are never selected.
+func WithSampleWeight(fn func(peer.ID) float64) SampleOption {
+	return func(cfg *sampleConfig) {
+		cfg.weight = fn
+	}
+}
+
+// WeightByLatency favours peers with a lower latency EWMA. Peers that have never been measured are weighted as if they
+// had a latency of one second.
+func WeightByLatency(m pstore.Metrics) SampleOption {
+	return WithSampleWeight(func(p peer.ID) float64 {
+		lat := m.LatencyEWMA(p)
+		if lat <= 0 {
+			lat = unmeasuredLatency
+		}
+		return 1 / lat.Seconds()
+	})
+}
+
+// WeightByRecency favours peers seen more recently, according to the supplied lastSeen function. The weight of a peer
+// halves every halfLife. Peers for which lastSeen returns the zero time are never selected.
+func WeightByRecency(lastSeen func(peer.ID) time.Time, halfLife time.Duration) SampleOption {
+	return WithSampleWeight(func(p peer.ID) float64 {
+		t := lastSeen(p)
+		if t.IsZero() {
+			return 0
+		}
+		age := time.Since(t)
+		if age < 0 {
+			age = 0
+		}
+		return math.Exp2(-float64(age) / float64(halfLife))
+	})
+}
+
+// WithSampleProtocols restricts the sample to peers that support at least one of the given protocols.
+func WithSampleProtocols(protos ...string) SampleOption {
+	return func(cfg *sampleConfig) {
+		cfg.protos = protos
+	}
+}
+
+// WithSampleRand sets the source of randomness used for sampling. Useful to obtain reproducible samples in tests.
+func WithSampleRand(rng *rand.Rand) SampleOption {
+	return func(cfg *sampleConfig) {
+		cfg.rng = rng
+	}
+}
+
+// SamplePeers returns a random subset of at most n peers known to the peerstore. By default, all peers are equally
+// likely to be selected; options can be used to weight the selection and to filter by protocol.
+//
+// Sampling is performed in a single pass with a weighted reservoir, so only n candidates are held in memory and
+// per-peer data is consulted once per peer.
+func SamplePeers(ps pstore.Peerstore, n int, opts ...SampleOption) peer.IDSlice {
+	if n <= 0 {
+		return peer.IDSlice{}
+	}
+
+	cfg := &sampleConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	random := rand.Float64
+	if cfg.rng != nil {
+		random = cfg.rng.Float64
+	}
+
+	// Weighted random sampling with a reservoir (Efraimidis & Spirakis): each peer draws a key u^(1/w), and the n peers
+	// with the largest keys are kept.
+	res := make(sampleHeap, 0, n)
+	for _, p := range ps.Peers() {
+		w := 1.0
+		if cfg.weight != nil {
+			if w = cfg.weight(p); w <= 0 || math.IsNaN(w) {
+				continue
+			}
+		}
+		if len(cfg.protos) > 0 {
+			if supported, err := ps.SupportsProtocols(p, cfg.protos...); err != nil || len(supported) == 0 {
+				continue
+			}
+		}
+
+		key := math.Pow(random(), 1/w)
+		if len(res) < n {
+			heap.Push(&res, sampleEntry{p, key})
+		} else if key > res[0].key {
+			res[0] = sampleEntry{p, key}
+			heap.Fix(&res, 0)
+		}
+	}
+
+	out := make(peer.IDSlice, len(res))
+	for i, e := range res {
+		out[i] = e.id
+	}
+	return out
+}
+
+type sampleEntry struct {
+	id  peer.ID
+	key float64
+}
+
+// sampleHeap is a min-heap of sample entries, ordered by key.
+type sampleHeap []sampleEntry
+
+func (h sampleHeap) Len() int            { return len(h) }
+func (h sampleHeap) Less(i, j int) bool  { return h[i].key < h[j].key }
+func (h sampleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x interface{}) { *h = append(*h, x.(sampleEntry)) }
+func (h *sampleHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,91 @@
+package peerstore_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestSamplePeers(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(10)
+	addrs := pt.GenerateAddrs(10)
+	for i, id := range ids {
+		ps.AddAddr(id, addrs[i], time.Hour)
+	}
+
+	if s := pstore.SamplePeers(ps, 0); len(s) != 0 {
+		t.Fatalf("expected empty sample, got %d peers", len(s))
+	}
+	if s := pstore.SamplePeers(ps, 20); len(s) != 10 {
+		t.Fatalf("expected all 10 peers, got %d", len(s))
+	}
+
+	s := pstore.SamplePeers(ps, 5)
+	if len(s) != 5 {
+		t.Fatalf("expected 5 peers, got %d", len(s))
+	}
+	seen := make(map[peer.ID]struct{})
+	for _, p := range s {
+		if _, ok := seen[p]; ok {
+			t.Fatal("sample contains duplicates")
+		}
+		seen[p] = struct{}{}
+	}
+
+	// only two peers support the protocol.
+	if err := ps.AddProtocols(ids[3], "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddProtocols(ids[7], "/foo", "/bar"); err != nil {
+		t.Fatal(err)
+	}
+	s = pstore.SamplePeers(ps, 5, pstore.WithSampleProtocols("/foo"))
+	if len(s) != 2 {
+		t.Fatalf("expected 2 peers supporting the protocol, got %d", len(s))
+	}
+
+	// peers with zero weight are never selected.
+	s = pstore.SamplePeers(ps, 10, pstore.WithSampleWeight(func(p peer.ID) float64 {
+		if p == ids[0] {
+			return 1
+		}
+		return 0
+	}))
+	if len(s) != 1 || s[0] != ids[0] {
+		t.Fatalf("expected only the weighted peer, got %v", s)
+	}
+}
+
+func TestSamplePeersWeightByLatency(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	ids := pt.GeneratePeerIDs(2)
+	addrs := pt.GenerateAddrs(2)
+	for i, id := range ids {
+		ps.AddAddr(id, addrs[i], time.Hour)
+	}
+	ps.RecordLatency(ids[0], 10*time.Millisecond)
+	ps.RecordLatency(ids[1], time.Second)
+
+	rng := rand.New(rand.NewSource(42))
+	fast := 0
+	for i := 0; i < 1000; i++ {
+		s := pstore.SamplePeers(ps, 1, pstore.WeightByLatency(ps), pstore.WithSampleRand(rng))
+		if s[0] == ids[0] {
+			fast++
+		}
+	}
+	if fast < 900 {
+		t.Fatalf("expected the low latency peer to dominate the samples, selected %d/1000 times", fast)
+	}
+}
@@ -0,0 +1,278 @@
+package peerstore
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// scopeReconcileInterval is the minimum interval between reconciliations of the usage of a scope on writes.
+const scopeReconcileInterval = time.Second
+
+// ErrQuotaExceeded is returned when addresses are rejected because the scope adding them is over its quota.
+var ErrQuotaExceeded = errors.New("scope quota exceeded")
+
+// ScopeQuota bounds the share of the peerstore a scope may use. A zero limit is disabled.
+type ScopeQuota struct {
+	// MaxPeers is the number of peers the scope may add addresses for.
+	MaxPeers int
+	// MaxAddrs is the number of addresses the scope may add, across all its peers.
+	MaxAddrs int
+}
+
+// ScopeStats reports the usage of a scope.
+type ScopeStats struct {
+	Quota ScopeQuota
+	// Peers and Addrs count the live peers and addresses added through the scope.
+	Peers int
+	Addrs int
+	// Rejected is the number of addresses rejected because the scope was over its quota.
+	Rejected uint64
+}
+
+// Scopes hands out scoped views of a peerstore, one per subsystem, so that a runaway subsystem (e.g. an experimental
+// discovery module) cannot use up the peerstore for the rest of the node.
+type Scopes struct {
+	ps pstore.Peerstore
+
+	mu     sync.Mutex
+	scopes map[string]*Scope
+}
+
+// NewScopes creates a Scopes for ps.
+func NewScopes(ps pstore.Peerstore) *Scopes {
+	return &Scopes{ps: ps, scopes: make(map[string]*Scope)}
+}
+
+// Scope returns the view of the named scope, creating it if needed. The quota of an existing scope is updated.
+func (s *Scopes) Scope(name string, quota ScopeQuota) *Scope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.scopes[name]
+	if !ok {
+		sc = &Scope{Peerstore: s.ps, name: name, addrs: make(map[peer.ID]map[string]struct{})}
+		s.scopes[name] = sc
+	}
+	sc.mu.Lock()
+	sc.quota = quota
+	sc.mu.Unlock()
+	return sc
+}
+
+// Stats returns the usage of every scope, by name.
+func (s *Scopes) Stats() map[string]ScopeStats {
+	s.mu.Lock()
+	scopes := make([]*Scope, 0, len(s.scopes))
+	for _, sc := range s.scopes {
+		scopes = append(scopes, sc)
+	}
+	s.mu.Unlock()
+
+	stats := make(map[string]ScopeStats, len(scopes))
+	for _, sc := range scopes {
+		stats[sc.name] = sc.Stats()
+	}
+	return stats
+}
+
+// Scope is a view of a peerstore that attributes the addresses added through it to a subsystem, and holds them to
+// the quota of the subsystem. Reads, and writes other than address additions, go straight to the peerstore.
+//
+// Quotas are soft: the usage of a scope only counts the addresses it added that are still live in the peerstore, so
+// addresses that have expired, or were removed or re-added by other subsystems, are eventually freed up.
+type Scope struct {
+	pstore.Peerstore
+	name string
+
+	mu       sync.Mutex
+	quota    ScopeQuota
+	addrs    map[peer.ID]map[string]struct{}
+	naddrs   int
+	rejected uint64
+
+	reconciled time.Time
+}
+
+var _ AddrBookE = (*Scope)(nil)
+
+// Name returns the name of the scope.
+func (s *Scope) Name() string {
+	return s.name
+}
+
+// AddAddr is like AddAddrs, for a single address.
+func (s *Scope) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	s.AddAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+// AddAddrs adds addresses within the quota of the scope; addresses beyond it are dropped.
+func (s *Scope) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	if err := s.AddAddrsE(p, addrs, ttl); err != nil {
+		log.Debugf("scope %s: failed to add addresses for peer %s: %s", s.name, p.Pretty(), err)
+	}
+}
+
+// SetAddr is like SetAddrs, for a single address.
+func (s *Scope) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	s.SetAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+// SetAddrs sets addresses within the quota of the scope; addresses beyond it are dropped.
+func (s *Scope) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	if err := s.SetAddrsE(p, addrs, ttl); err != nil {
+		log.Debugf("scope %s: failed to set addresses for peer %s: %s", s.name, p.Pretty(), err)
+	}
+}
+
+// AddAddrsE adds the addresses that fit within the quota of the scope, and returns ErrQuotaExceeded if any didn't.
+func (s *Scope) AddAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	return s.write(p, addrs, ttl, func(admitted []ma.Multiaddr) error {
+		if ab, ok := s.Peerstore.(AddrBookE); ok {
+			return ab.AddAddrsE(p, admitted, ttl)
+		}
+		s.Peerstore.AddAddrs(p, admitted, ttl)
+		return nil
+	})
+}
+
+// SetAddrsE is like AddAddrsE, but sets the addresses. Setting a zero TTL removes addresses and is never rejected.
+func (s *Scope) SetAddrsE(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	return s.write(p, addrs, ttl, func(admitted []ma.Multiaddr) error {
+		if ab, ok := s.Peerstore.(AddrBookE); ok {
+			return ab.SetAddrsE(p, admitted, ttl)
+		}
+		s.Peerstore.SetAddrs(p, admitted, ttl)
+		return nil
+	})
+}
+
+// ClearAddrs removes all the addresses of a peer, and releases them from the scope.
+func (s *Scope) ClearAddrs(p peer.ID) {
+	if err := s.ClearAddrsE(p); err != nil {
+		log.Debugf("scope %s: failed to clear addresses for peer %s: %s", s.name, p.Pretty(), err)
+	}
+}
+
+// ClearAddrsE is like ClearAddrs, but returns the error of the peerstore, if any.
+func (s *Scope) ClearAddrsE(p peer.ID) error {
+	var err error
+	if ab, ok := s.Peerstore.(AddrBookE); ok {
+		err = ab.ClearAddrsE(p)
+	} else {
+		s.Peerstore.ClearAddrs(p)
+	}
+	s.mu.Lock()
+	s.release(p)
+	s.mu.Unlock()
+	return err
+}
+
+// Stats returns the usage of the scope.
+func (s *Scope) Stats() ScopeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconcile()
+	return ScopeStats{Quota: s.quota, Peers: len(s.addrs), Addrs: s.naddrs, Rejected: s.rejected}
+}
+
+func (s *Scope) write(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, write func([]ma.Multiaddr) error) error {
+	if ttl <= 0 {
+		return write(addrs)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	admitted, rejected := s.admit(p, addrs)
+	if rejected > 0 && time.Since(s.reconciled) >= scopeReconcileInterval {
+		// usage may be stale; reconcile it with the peerstore before rejecting anything.
+		s.reconcile()
+		admitted, rejected = s.admit(p, addrs)
+	}
+	s.rejected += uint64(rejected)
+
+	if len(admitted) > 0 {
+		if err := write(admitted); err != nil {
+			return err
+		}
+		s.track(p, admitted)
+	}
+	if rejected > 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// admit returns the addresses that fit within the quota, and the number of those that don't.
+func (s *Scope) admit(p peer.ID, addrs []ma.Multiaddr) (admitted []ma.Multiaddr, rejected int) {
+	known, ok := s.addrs[p]
+	if !ok && s.quota.MaxPeers > 0 && len(s.addrs) >= s.quota.MaxPeers {
+		return nil, len(addrs)
+	}
+	admitted = make([]ma.Multiaddr, 0, len(addrs))
+	seen := make(map[string]struct{}, len(addrs))
+	free := s.quota.MaxAddrs - s.naddrs
+	for _, a := range addrs {
+		if a == nil {
+			continue
+		}
+		k := string(a.Bytes())
+		if _, dup := seen[k]; dup {
+			continue
+		}
+		if _, counted := known[k]; !counted {
+			if s.quota.MaxAddrs > 0 && free <= 0 {
+				rejected++
+				continue
+			}
+			free--
+		}
+		seen[k] = struct{}{}
+		admitted = append(admitted, a)
+	}
+	return admitted, rejected
+}
+
+func (s *Scope) track(p peer.ID, addrs []ma.Multiaddr) {
+	known, ok := s.addrs[p]
+	if !ok {
+		known = make(map[string]struct{}, len(addrs))
+		s.addrs[p] = known
+	}
+	for _, a := range addrs {
+		k := string(a.Bytes())
+		if _, counted := known[k]; !counted {
+			known[k] = struct{}{}
+			s.naddrs++
+		}
+	}
+}
+
+func (s *Scope) release(p peer.ID) {
+	s.naddrs -= len(s.addrs[p])
+	delete(s.addrs, p)
+}
+
+// reconcile drops the addresses that are no longer live in the peerstore from the usage of the scope.
+func (s *Scope) reconcile() {
+	s.reconciled = time.Now()
+	for p, known := range s.addrs {
+		live := make(map[string]struct{})
+		for _, a := range s.Peerstore.Addrs(p) {
+			live[string(a.Bytes())] = struct{}{}
+		}
+		for k := range known {
+			if _, ok := live[k]; !ok {
+				delete(known, k)
+				s.naddrs--
+			}
+		}
+		if len(known) == 0 {
+			delete(s.addrs, p)
+		}
+	}
+}
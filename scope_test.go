@@ -0,0 +1,94 @@
+package peerstore_test
+
+import (
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestScopeQuotas(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	scopes := pstore.NewScopes(ps)
+	discovery := scopes.Scope("discovery", pstore.ScopeQuota{MaxPeers: 2, MaxAddrs: 3})
+	dht := scopes.Scope("dht", pstore.ScopeQuota{})
+
+	ids := pt.GeneratePeerIDs(4)
+	addrs := pt.GenerateAddrs(4)
+
+	// the second peer only fits one of its addresses.
+	if err := discovery.AddAddrsE(ids[0], addrs[:2], time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := discovery.AddAddrsE(ids[1], addrs[2:], time.Hour); err != pstore.ErrQuotaExceeded {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if n := len(ps.Addrs(ids[1])); n != 1 {
+		t.Fatalf("expected 1 address to be admitted, got %d", n)
+	}
+
+	// re-adding known addresses doesn't count against the quota.
+	if err := discovery.AddAddrsE(ids[0], addrs[:2], 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// the third peer is over the peer quota.
+	discovery.AddAddr(ids[2], addrs[0], time.Hour)
+	if n := len(ps.Addrs(ids[2])); n != 0 {
+		t.Fatalf("expected no address to be admitted, got %d", n)
+	}
+
+	// other scopes are unaffected.
+	if err := dht.AddAddrsE(ids[3], addrs, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := scopes.Stats()
+	if s := stats["discovery"]; s.Peers != 2 || s.Addrs != 3 || s.Rejected != 2 {
+		t.Fatalf("unexpected discovery stats: %+v", s)
+	}
+	if s := stats["dht"]; s.Peers != 1 || s.Addrs != 4 || s.Rejected != 0 {
+		t.Fatalf("unexpected dht stats: %+v", s)
+	}
+
+	// clearing a peer frees up its usage.
+	discovery.ClearAddrs(ids[0])
+	if err := discovery.AddAddrsE(ids[2], addrs[:2], time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if s := discovery.Stats(); s.Peers != 2 || s.Addrs != 3 {
+		t.Fatalf("unexpected discovery stats: %+v", s)
+	}
+}
+
+func TestScopeReconcile(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+
+	sc := pstore.NewScopes(ps).Scope("discovery", pstore.ScopeQuota{MaxAddrs: 2})
+	ids := pt.GeneratePeerIDs(2)
+	addrs := pt.GenerateAddrs(2)
+
+	if err := sc.AddAddrsE(ids[0], addrs, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// addresses removed by another subsystem are freed up.
+	ps.SetAddr(ids[0], addrs[0], 0)
+	if s := sc.Stats(); s.Peers != 1 || s.Addrs != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+
+	// expired addresses too.
+	time.Sleep(200 * time.Millisecond)
+	if s := sc.Stats(); s.Peers != 0 || s.Addrs != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if err := sc.AddAddrsE(ids[1], addrs, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,232 @@
+package peerstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// SeedBundleDomain is the signature domain of seed bundles.
+const SeedBundleDomain = "libp2p-peerstore-seed-bundle"
+
+// SeedBundleCodec is the payload type of the envelopes carrying seed bundles.
+var SeedBundleCodec = []byte("/libp2p-peerstore/seed-bundle")
+
+// seedBundleVersion is the version of the seed bundle encoding, and its first byte.
+const seedBundleVersion = 1
+
+var (
+	// ErrBadSeedBundle is returned when decoding a malformed or unsupported seed bundle.
+	ErrBadSeedBundle = errors.New("malformed seed bundle")
+
+	// ErrUntrustedSeedBundle is returned when a seed bundle is not signed by the expected key.
+	ErrUntrustedSeedBundle = errors.New("seed bundle not signed by a trusted key")
+)
+
+func init() {
+	record.RegisterType(&SeedBundle{})
+}
+
+// SeedPeer is a well-known peer carried by a seed bundle.
+type SeedPeer struct {
+	ID    peer.ID
+	Addrs []ma.Multiaddr
+	// PubKey is the public key of the peer, if it isn't inlined in its ID. Optional.
+	PubKey ic.PubKey
+}
+
+// SeedBundle is a signed list of well-known peers, such as bootstrap nodes and gateways, that applications embed in
+// their binaries and load at startup, typically into the fallback of a ChainedPeerstore.
+//
+// Bundles are built with NewSeedBundle, signed with Seal, and verified with OpenSeedBundle. They are encoded
+// compactly, as a versioned sequence of length-prefixed fields, and sealed in a signed record.Envelope.
+type SeedBundle struct {
+	// Seq orders the bundles signed by the same key; it defaults to the creation time.
+	Seq   uint64
+	Peers []SeedPeer
+}
+
+var _ record.Record = (*SeedBundle)(nil)
+
+// NewSeedBundle builds a bundle with the addresses and public keys held by ps for peers. Peers without addresses are
+// left out.
+func NewSeedBundle(ps pstore.Peerstore, peers ...peer.ID) *SeedBundle {
+	b := &SeedBundle{Seq: peer.TimestampSeq()}
+	for _, p := range peers {
+		addrs := ps.Addrs(p)
+		if len(addrs) == 0 {
+			continue
+		}
+		sp := SeedPeer{ID: p, Addrs: addrs}
+		// keys inlined in the ID don't need to be shipped.
+		if _, err := p.ExtractPublicKey(); err != nil {
+			sp.PubKey = ps.PubKey(p)
+		}
+		b.Peers = append(b.Peers, sp)
+	}
+	return b
+}
+
+// Seal signs the bundle with key, returning the bytes to embed.
+func (b *SeedBundle) Seal(key ic.PrivKey) ([]byte, error) {
+	env, err := record.Seal(b, key)
+	if err != nil {
+		return nil, err
+	}
+	return env.Marshal()
+}
+
+// OpenSeedBundle decodes a sealed bundle, verifying that it was signed by trusted.
+func OpenSeedBundle(data []byte, trusted ic.PubKey) (*SeedBundle, error) {
+	var b SeedBundle
+	env, err := record.ConsumeTypedEnvelope(data, &b)
+	if err != nil {
+		return nil, err
+	}
+	if !env.PublicKey.Equals(trusted) {
+		return nil, ErrUntrustedSeedBundle
+	}
+	return &b, nil
+}
+
+// Load adds the peers of the bundle to ps, their addresses with the given TTL. It fails on the first public key that
+// doesn't match its peer ID, or that ps rejects.
+func (b *SeedBundle) Load(ps pstore.Peerstore, ttl time.Duration) error {
+	for _, sp := range b.Peers {
+		if sp.PubKey != nil {
+			if err := ps.AddPubKey(sp.ID, sp.PubKey); err != nil {
+				return fmt.Errorf("failed to load the key of seed peer %s: %s", sp.ID.Pretty(), err)
+			}
+		}
+		ps.AddAddrs(sp.ID, sp.Addrs, ttl)
+	}
+	return nil
+}
+
+func (b *SeedBundle) Domain() string {
+	return SeedBundleDomain
+}
+
+func (b *SeedBundle) Codec() []byte {
+	return SeedBundleCodec
+}
+
+func (b *SeedBundle) MarshalRecord() ([]byte, error) {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(scratch[:], v)
+		buf.Write(scratch[:n])
+	}
+	putBytes := func(b []byte) {
+		putUvarint(uint64(len(b)))
+		buf.Write(b)
+	}
+
+	buf.WriteByte(seedBundleVersion)
+	putUvarint(b.Seq)
+	putUvarint(uint64(len(b.Peers)))
+	for _, sp := range b.Peers {
+		putBytes([]byte(sp.ID))
+		var pk []byte
+		if sp.PubKey != nil {
+			var err error
+			if pk, err = ic.MarshalPublicKey(sp.PubKey); err != nil {
+				return nil, err
+			}
+		}
+		putBytes(pk)
+		putUvarint(uint64(len(sp.Addrs)))
+		for _, a := range sp.Addrs {
+			putBytes(a.Bytes())
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (b *SeedBundle) UnmarshalRecord(data []byte) error {
+	r := bytes.NewReader(data)
+	readUvarint := func() (uint64, error) {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, ErrBadSeedBundle
+		}
+		return v, nil
+	}
+	readBytes := func() ([]byte, error) {
+		l, err := readUvarint()
+		if err != nil || l > uint64(r.Len()) {
+			return nil, ErrBadSeedBundle
+		}
+		res := make([]byte, l)
+		if _, err := io.ReadFull(r, res); err != nil {
+			return nil, ErrBadSeedBundle
+		}
+		return res, nil
+	}
+
+	if v, err := r.ReadByte(); err != nil || v != seedBundleVersion {
+		return ErrBadSeedBundle
+	}
+	seq, err := readUvarint()
+	if err != nil {
+		return err
+	}
+	count, err := readUvarint()
+	// every peer takes at least 3 bytes.
+	if err != nil || count > uint64(r.Len())/3 {
+		return ErrBadSeedBundle
+	}
+
+	peers := make([]SeedPeer, 0, count)
+	for i := uint64(0); i < count; i++ {
+		id, err := readBytes()
+		if err != nil {
+			return err
+		}
+		sp := SeedPeer{ID: peer.ID(id)}
+		if err := sp.ID.Validate(); err != nil {
+			return ErrBadSeedBundle
+		}
+		pk, err := readBytes()
+		if err != nil {
+			return err
+		}
+		if len(pk) > 0 {
+			if sp.PubKey, err = ic.UnmarshalPublicKey(pk); err != nil {
+				return ErrBadSeedBundle
+			}
+		}
+		naddrs, err := readUvarint()
+		if err != nil || naddrs > uint64(r.Len()) {
+			return ErrBadSeedBundle
+		}
+		sp.Addrs = make([]ma.Multiaddr, 0, naddrs)
+		for j := uint64(0); j < naddrs; j++ {
+			ab, err := readBytes()
+			if err != nil {
+				return err
+			}
+			a, err := ma.NewMultiaddrBytes(ab)
+			if err != nil {
+				return ErrBadSeedBundle
+			}
+			sp.Addrs = append(sp.Addrs, a)
+		}
+		peers = append(peers, sp)
+	}
+	if r.Len() != 0 {
+		return ErrBadSeedBundle
+	}
+	b.Seq, b.Peers = seq, peers
+	return nil
+}
@@ -0,0 +1,110 @@
+package peerstore_test
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestSeedBundle(t *testing.T) {
+	src := pstoremem.NewPeerstore()
+	defer src.Close()
+
+	// an RSA peer, whose key must be shipped, and an Ed25519 peer, whose key is inlined in its ID.
+	_, rsaPub, err := test.RandTestKeyPair(ic.RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edPub, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []peer.ID
+	for _, pk := range []ic.PubKey{rsaPub, edPub} {
+		id, err := peer.IDFromPublicKey(pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := src.AddPubKey(id, pk); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	addrs := pt.GenerateAddrs(3)
+	src.AddAddrs(ids[0], addrs[:2], time.Hour)
+	src.AddAddrs(ids[1], addrs[2:], time.Hour)
+	unknown := pt.GeneratePeerIDs(1)[0]
+
+	bundle := pstore.NewSeedBundle(src, ids[0], ids[1], unknown)
+	if len(bundle.Peers) != 2 {
+		t.Fatalf("expected peers without addresses to be left out, got %d peers", len(bundle.Peers))
+	}
+	if bundle.Peers[1].PubKey != nil {
+		t.Error("expected the inlined key not to be shipped")
+	}
+
+	signer, trusted, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := bundle.Seal(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, other, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pstore.OpenSeedBundle(data, other); err != pstore.ErrUntrustedSeedBundle {
+		t.Fatalf("expected ErrUntrustedSeedBundle, got %v", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if _, err := pstore.OpenSeedBundle(data, trusted); err == nil {
+		t.Fatal("expected a tampered bundle to be rejected")
+	}
+	data[len(data)-1] ^= 0xff
+
+	opened, err := pstore.OpenSeedBundle(data, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Seq != bundle.Seq {
+		t.Errorf("expected seq %d, got %d", bundle.Seq, opened.Seq)
+	}
+
+	dst := pstoremem.NewPeerstore()
+	defer dst.Close()
+	if err := opened.Load(dst, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	pt.AssertAddressesEqual(t, addrs[:2], dst.Addrs(ids[0]))
+	pt.AssertAddressesEqual(t, addrs[2:], dst.Addrs(ids[1]))
+	if !rsaPub.Equals(dst.PubKey(ids[0])) || !edPub.Equals(dst.PubKey(ids[1])) {
+		t.Error("expected the keys of the seed peers to be available")
+	}
+}
+
+func TestSeedBundleMalformed(t *testing.T) {
+	b := &pstore.SeedBundle{Seq: 1, Peers: []pstore.SeedPeer{{ID: pt.GeneratePeerIDs(1)[0], Addrs: pt.GenerateAddrs(2)}}}
+	data, err := b.MarshalRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(data); i++ {
+		if err := new(pstore.SeedBundle).UnmarshalRecord(data[:i]); err != pstore.ErrBadSeedBundle {
+			t.Fatalf("expected a bundle truncated to %d bytes to be rejected, got %v", i, err)
+		}
+	}
+	if err := new(pstore.SeedBundle).UnmarshalRecord(append(data, 0)); err != pstore.ErrBadSeedBundle {
+		t.Fatalf("expected trailing bytes to be rejected, got %v", err)
+	}
+}
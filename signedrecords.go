@@ -0,0 +1,176 @@
+package peerstore
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+)
+
+// SignedRecordsKey is the metadata key under which SignedRecordBook stores the signed records of a peer.
+const SignedRecordsKey = "signed-records"
+
+// ErrUnsequencedRecord is returned when consuming a signed record that carries no sequence number.
+var ErrUnsequencedRecord = errors.New("record has no sequence number")
+
+func init() {
+	// allow datastore-backed metadata books to persist signed records.
+	gob.Register(PeerSignedRecords{})
+}
+
+// SequencedRecord is implemented by records that supersede each other by sequence number. peer.PeerRecord is
+// supported without implementing it.
+type SequencedRecord interface {
+	record.Record
+	// Sequence returns the sequence number of the record. Records with higher sequence numbers replace those with
+	// lower ones.
+	Sequence() uint64
+}
+
+// SignedRecordEntry is a signed record of a peer, as stored by SignedRecordBook.
+type SignedRecordEntry struct {
+	// Envelope is the serialized envelope of the record.
+	Envelope []byte
+	// Domain is the signature domain of the record, used to verify the envelope when it is read back.
+	Domain string
+	Seq    uint64
+}
+
+// PeerSignedRecords are the signed records of a peer, keyed by payload type.
+type PeerSignedRecords map[string]SignedRecordEntry
+
+// SignedRecordBook stores the signed records of any registered payload type (see record.RegisterType) in the metadata
+// of a peerstore, under SignedRecordsKey, keeping the record with the highest sequence number of each type. Records
+// are filed under the peer that signed them. This generalizes the handling of signed peer records by certified
+// address books, which keep storing those, to other certified records such as reachability attestations.
+//
+// Writes are serialized by the SignedRecordBook, which should thus be shared by all writers of a peerstore.
+type SignedRecordBook struct {
+	md pstore.PeerMetadata
+
+	mu sync.Mutex
+}
+
+// NewSignedRecordBook creates a SignedRecordBook backed by md.
+func NewSignedRecordBook(md pstore.PeerMetadata) *SignedRecordBook {
+	return &SignedRecordBook{md: md}
+}
+
+// ConsumeSignedRecord stores a signed record, unless a record of the same payload type with an equal or higher
+// sequence number is already stored for the peer that signed it. It returns whether the record was stored.
+//
+// The record must be a peer.PeerRecord or implement SequencedRecord; peer records must be signed by the peer they
+// describe.
+func (b *SignedRecordBook) ConsumeSignedRecord(env *record.Envelope) (bool, error) {
+	rec, err := env.Record()
+	if err != nil {
+		return false, fmt.Errorf("unable to process envelope: %s", err)
+	}
+	p, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return false, err
+	}
+
+	var seq uint64
+	switch r := rec.(type) {
+	case *peer.PeerRecord:
+		if r.PeerID != p {
+			return false, fmt.Errorf("signing key does not match PeerID in PeerRecord")
+		}
+		seq = r.Seq
+	case SequencedRecord:
+		seq = r.Sequence()
+	default:
+		return false, ErrUnsequencedRecord
+	}
+
+	data, err := env.Marshal()
+	if err != nil {
+		return false, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stored := b.load(p)
+	typ := string(env.PayloadType)
+	if cur, ok := stored[typ]; ok && cur.Seq >= seq {
+		return false, nil
+	}
+	// the stored value may be shared with the metadata book, so it's never modified in place.
+	updated := make(PeerSignedRecords, len(stored)+1)
+	for t, e := range stored {
+		updated[t] = e
+	}
+	updated[typ] = SignedRecordEntry{Envelope: data, Domain: rec.Domain(), Seq: seq}
+	if err := b.md.Put(p, SignedRecordsKey, updated); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SignedRecord returns the envelope of the stored record of a peer with the given payload type, or nil if there is
+// none.
+func (b *SignedRecordBook) SignedRecord(p peer.ID, payloadType []byte) *record.Envelope {
+	e, ok := b.load(p)[string(payloadType)]
+	if !ok {
+		return nil
+	}
+	env, _, err := record.ConsumeEnvelope(e.Envelope, e.Domain)
+	if err != nil {
+		log.Errorf("invalid signed record of peer %s: %s", p.Pretty(), err)
+		return nil
+	}
+	return env
+}
+
+// PayloadTypes returns the payload types of the stored records of a peer, sorted.
+func (b *SignedRecordBook) PayloadTypes(p peer.ID) [][]byte {
+	stored := b.load(p)
+	types := make([]string, 0, len(stored))
+	for t := range stored {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	res := make([][]byte, len(types))
+	for i, t := range types {
+		res[i] = []byte(t)
+	}
+	return res
+}
+
+// RemoveSignedRecord removes the stored record of a peer with the given payload type.
+func (b *SignedRecordBook) RemoveSignedRecord(p peer.ID, payloadType []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stored := b.load(p)
+	if _, ok := stored[string(payloadType)]; !ok {
+		return nil
+	}
+	updated := make(PeerSignedRecords, len(stored))
+	for t, e := range stored {
+		if t != string(payloadType) {
+			updated[t] = e
+		}
+	}
+	return b.md.Put(p, SignedRecordsKey, updated)
+}
+
+func (b *SignedRecordBook) load(p peer.ID) PeerSignedRecords {
+	v, err := b.md.Get(p, SignedRecordsKey)
+	if err != nil {
+		return nil
+	}
+	records, ok := v.(PeerSignedRecords)
+	if !ok {
+		log.Debugf("unexpected type %T under metadata key %s for peer %s", v, SignedRecordsKey, p.Pretty())
+		return nil
+	}
+	return records
+}
@@ -0,0 +1,151 @@
+package peerstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+// attestation is a sequenced record of a custom payload type.
+type attestation struct {
+	seq uint64
+}
+
+func init() {
+	record.RegisterType(&attestation{})
+}
+
+func (a *attestation) Domain() string   { return "test-attestation" }
+func (a *attestation) Codec() []byte    { return []byte("/test/attestation") }
+func (a *attestation) Sequence() uint64 { return a.seq }
+
+func (a *attestation) MarshalRecord() ([]byte, error) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, a.seq)
+	return buf, nil
+}
+
+func (a *attestation) UnmarshalRecord(data []byte) error {
+	if len(data) != 8 {
+		return errors.New("invalid attestation")
+	}
+	a.seq = binary.BigEndian.Uint64(data)
+	return nil
+}
+
+func TestSignedRecordBook(t *testing.T) {
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+	b := pstore.NewSignedRecordBook(ps)
+
+	sk, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seal := func(rec record.Record) *record.Envelope {
+		t.Helper()
+		env, err := record.Seal(rec, sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return env
+	}
+	consume := func(env *record.Envelope, want bool) {
+		t.Helper()
+		ok, err := b.ConsumeSignedRecord(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Fatalf("expected record to be stored: %t, got %t", want, ok)
+		}
+	}
+	attestationType := (&attestation{}).Codec()
+
+	v2 := seal(&attestation{seq: 2})
+	consume(v2, true)
+	consume(seal(&attestation{seq: 1}), false)
+	consume(seal(&attestation{seq: 2}), false)
+	// records of other types are replaced independently.
+	consume(seal(&peer.PeerRecord{PeerID: p, Seq: 1}), true)
+
+	if env := b.SignedRecord(p, attestationType); env == nil || !env.Equal(v2) {
+		t.Fatalf("expected the attestation with the highest sequence number, got %v", env)
+	}
+	v3 := seal(&attestation{seq: 3})
+	consume(v3, true)
+	if env := b.SignedRecord(p, attestationType); env == nil || !env.Equal(v3) {
+		t.Fatalf("expected the attestation to be replaced, got %v", env)
+	}
+
+	types := b.PayloadTypes(p)
+	if len(types) != 2 || !bytes.Equal(types[0], peer.PeerRecordEnvelopePayloadType) || !bytes.Equal(types[1], attestationType) {
+		t.Fatalf("expected payload types %q and %q, got %q", peer.PeerRecordEnvelopePayloadType, attestationType, types)
+	}
+
+	// peer records must be signed by the peer they describe.
+	if _, err := b.ConsumeSignedRecord(seal(&peer.PeerRecord{PeerID: "other", Seq: 2})); err == nil {
+		t.Fatal("expected a peer record of another peer to be rejected")
+	}
+
+	if err := b.RemoveSignedRecord(p, attestationType); err != nil {
+		t.Fatal(err)
+	}
+	if env := b.SignedRecord(p, attestationType); env != nil {
+		t.Fatal("expected the attestation to be removed")
+	}
+	if env := b.SignedRecord(p, peer.PeerRecordEnvelopePayloadType); env == nil {
+		t.Fatal("expected the peer record to be kept")
+	}
+}
+
+func TestSignedRecordBookPersistence(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	sk, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := record.Seal(&attestation{seq: 1}, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pstore.NewSignedRecordBook(ps).ConsumeSignedRecord(env); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+
+	ps, err = pstoreds.NewPeerstore(context.Background(), store, pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	if got := pstore.NewSignedRecordBook(ps).SignedRecord(p, (&attestation{}).Codec()); got == nil || !got.Equal(env) {
+		t.Fatalf("expected the attestation to survive a restart, got %v", got)
+	}
+}
@@ -0,0 +1,260 @@
+package peerstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// BackendTiming is the time a peerstore spent in one kind of backend operation, such as datastore reads.
+type BackendTiming struct {
+	Op       string
+	Count    uint64
+	Duration time.Duration
+}
+
+// BackendTimer is implemented by peerstores that time the operations of their backend, such as those of pstoreds
+// with Options.BackendTimings set, so that SlowCallPeerstore can break slow calls down.
+type BackendTimer interface {
+	// BackendTimings returns the count and cumulative duration of the backend operations of every kind since the
+	// peerstore was created, always in the same order.
+	BackendTimings() []BackendTiming
+}
+
+// SlowCall is a peerstore call that took longer than the threshold of a SlowCallPeerstore.
+type SlowCall struct {
+	Time     time.Time
+	Op       TraceOp
+	Peer     peer.ID // empty for calls that don't take a peer.
+	Duration time.Duration
+
+	// Backend holds the backend operations of every kind run during the call, if the peerstore is a BackendTimer.
+	// Kinds without any operation are left out. Operations are counted by when they ran, not by who ran them, so
+	// the breakdown of a call includes the operations of calls made concurrently with it.
+	Backend []BackendTiming
+}
+
+func (c SlowCall) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s", c.Op)
+	if c.Peer != "" {
+		fmt.Fprintf(&sb, " of peer %s", c.Peer.Pretty())
+	}
+	fmt.Fprintf(&sb, " took %s", c.Duration)
+	for i, b := range c.Backend {
+		sep := ", "
+		if i == 0 {
+			sep = "; backend: "
+		}
+		fmt.Fprintf(&sb, "%s%d %s in %s", sep, b.Count, b.Op, b.Duration)
+	}
+	return sb.String()
+}
+
+// SlowCallLogger receives the slow calls of a SlowCallPeerstore. LogSlowCall is called synchronously, once the call
+// returns, so it must not block nor call back into the peerstore.
+type SlowCallLogger interface {
+	LogSlowCall(SlowCall)
+}
+
+// SlowCallLoggerFunc adapts a function to a SlowCallLogger.
+type SlowCallLoggerFunc func(SlowCall)
+
+// LogSlowCall calls f(c).
+func (f SlowCallLoggerFunc) LogSlowCall(c SlowCall) {
+	f(c)
+}
+
+// defaultSlowCallLogger logs slow calls as warnings of the package logger.
+var defaultSlowCallLogger = SlowCallLoggerFunc(func(c SlowCall) {
+	log.Warnf("slow peerstore call: %s", c)
+})
+
+// SlowCallPeerstore is a Peerstore middleware that reports the calls taking longer than a threshold, with the time
+// the wrapped Peerstore spent in its backend if it is a BackendTimer, e.g. to trace slow dials back to the datastore.
+// Calls returning a stream, such as AddrStream, are timed until the stream is returned.
+type SlowCallPeerstore struct {
+	pstore.Peerstore
+
+	threshold time.Duration
+	logger    SlowCallLogger
+	timer     BackendTimer // nil unless the wrapped peerstore is one.
+	clock     Clock
+}
+
+var _ pstore.Peerstore = (*SlowCallPeerstore)(nil)
+
+// NewSlowCallPeerstore wraps ps, reporting the calls taking longer than threshold to logger. Calls are logged as
+// warnings of the package logger when logger is nil.
+func NewSlowCallPeerstore(ps pstore.Peerstore, threshold time.Duration, logger SlowCallLogger) *SlowCallPeerstore {
+	if logger == nil {
+		logger = defaultSlowCallLogger
+	}
+	sp := &SlowCallPeerstore{Peerstore: ps, threshold: threshold, logger: logger, clock: RealClock{}}
+	sp.timer, _ = ps.(BackendTimer)
+	return sp
+}
+
+// slowCallStart is the state of the peerstore at the start of a call.
+type slowCallStart struct {
+	at      time.Time
+	backend []BackendTiming
+}
+
+func (sp *SlowCallPeerstore) start() slowCallStart {
+	s := slowCallStart{at: time.Now()}
+	if sp.timer != nil {
+		s.backend = sp.timer.BackendTimings()
+	}
+	return s
+}
+
+// done reports the call started at s if it took longer than the threshold. It is meant to be deferred.
+func (sp *SlowCallPeerstore) done(op TraceOp, p peer.ID, s slowCallStart) {
+	d := time.Since(s.at)
+	if d <= sp.threshold {
+		return
+	}
+	c := SlowCall{Time: sp.clock.Now(), Op: op, Peer: p, Duration: d}
+	if sp.timer != nil {
+		for i, b := range sp.timer.BackendTimings() {
+			if i < len(s.backend) {
+				b.Count -= s.backend[i].Count
+				b.Duration -= s.backend[i].Duration
+			}
+			if b.Count > 0 {
+				c.Backend = append(c.Backend, b)
+			}
+		}
+	}
+	sp.logger.LogSlowCall(c)
+}
+
+func (sp *SlowCallPeerstore) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	sp.AddAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+func (sp *SlowCallPeerstore) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	defer sp.done(TraceAddAddrs, p, sp.start())
+	sp.Peerstore.AddAddrs(p, addrs, ttl)
+}
+
+func (sp *SlowCallPeerstore) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	sp.SetAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+func (sp *SlowCallPeerstore) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	defer sp.done(TraceSetAddrs, p, sp.start())
+	sp.Peerstore.SetAddrs(p, addrs, ttl)
+}
+
+func (sp *SlowCallPeerstore) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
+	defer sp.done(TraceUpdateAddrs, p, sp.start())
+	sp.Peerstore.UpdateAddrs(p, oldTTL, newTTL)
+}
+
+func (sp *SlowCallPeerstore) Addrs(p peer.ID) []ma.Multiaddr {
+	defer sp.done(TraceAddrs, p, sp.start())
+	return sp.Peerstore.Addrs(p)
+}
+
+func (sp *SlowCallPeerstore) AddrStream(ctx context.Context, p peer.ID) <-chan ma.Multiaddr {
+	defer sp.done(TraceAddrStream, p, sp.start())
+	return sp.Peerstore.AddrStream(ctx, p)
+}
+
+func (sp *SlowCallPeerstore) ClearAddrs(p peer.ID) {
+	defer sp.done(TraceClearAddrs, p, sp.start())
+	sp.Peerstore.ClearAddrs(p)
+}
+
+func (sp *SlowCallPeerstore) PeersWithAddrs() peer.IDSlice {
+	defer sp.done(TracePeersWithAddrs, "", sp.start())
+	return sp.Peerstore.PeersWithAddrs()
+}
+
+func (sp *SlowCallPeerstore) PubKey(p peer.ID) ic.PubKey {
+	defer sp.done(TracePubKey, p, sp.start())
+	return sp.Peerstore.PubKey(p)
+}
+
+func (sp *SlowCallPeerstore) AddPubKey(p peer.ID, pk ic.PubKey) error {
+	defer sp.done(TraceAddPubKey, p, sp.start())
+	return sp.Peerstore.AddPubKey(p, pk)
+}
+
+func (sp *SlowCallPeerstore) PrivKey(p peer.ID) ic.PrivKey {
+	defer sp.done(TracePrivKey, p, sp.start())
+	return sp.Peerstore.PrivKey(p)
+}
+
+func (sp *SlowCallPeerstore) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
+	defer sp.done(TraceAddPrivKey, p, sp.start())
+	return sp.Peerstore.AddPrivKey(p, sk)
+}
+
+func (sp *SlowCallPeerstore) PeersWithKeys() peer.IDSlice {
+	defer sp.done(TracePeersWithKeys, "", sp.start())
+	return sp.Peerstore.PeersWithKeys()
+}
+
+func (sp *SlowCallPeerstore) Get(p peer.ID, key string) (interface{}, error) {
+	defer sp.done(TraceGet, p, sp.start())
+	return sp.Peerstore.Get(p, key)
+}
+
+func (sp *SlowCallPeerstore) Put(p peer.ID, key string, val interface{}) error {
+	defer sp.done(TracePut, p, sp.start())
+	return sp.Peerstore.Put(p, key, val)
+}
+
+func (sp *SlowCallPeerstore) RecordLatency(p peer.ID, d time.Duration) {
+	defer sp.done(TraceRecordLatency, p, sp.start())
+	sp.Peerstore.RecordLatency(p, d)
+}
+
+func (sp *SlowCallPeerstore) LatencyEWMA(p peer.ID) time.Duration {
+	defer sp.done(TraceLatencyEWMA, p, sp.start())
+	return sp.Peerstore.LatencyEWMA(p)
+}
+
+func (sp *SlowCallPeerstore) GetProtocols(p peer.ID) ([]string, error) {
+	defer sp.done(TraceGetProtocols, p, sp.start())
+	return sp.Peerstore.GetProtocols(p)
+}
+
+func (sp *SlowCallPeerstore) AddProtocols(p peer.ID, protos ...string) error {
+	defer sp.done(TraceAddProtocols, p, sp.start())
+	return sp.Peerstore.AddProtocols(p, protos...)
+}
+
+func (sp *SlowCallPeerstore) SetProtocols(p peer.ID, protos ...string) error {
+	defer sp.done(TraceSetProtocols, p, sp.start())
+	return sp.Peerstore.SetProtocols(p, protos...)
+}
+
+func (sp *SlowCallPeerstore) RemoveProtocols(p peer.ID, protos ...string) error {
+	defer sp.done(TraceRemoveProtocols, p, sp.start())
+	return sp.Peerstore.RemoveProtocols(p, protos...)
+}
+
+func (sp *SlowCallPeerstore) SupportsProtocols(p peer.ID, protos ...string) ([]string, error) {
+	defer sp.done(TraceSupportsProtocols, p, sp.start())
+	return sp.Peerstore.SupportsProtocols(p, protos...)
+}
+
+func (sp *SlowCallPeerstore) PeerInfo(p peer.ID) peer.AddrInfo {
+	defer sp.done(TracePeerInfo, p, sp.start())
+	return sp.Peerstore.PeerInfo(p)
+}
+
+func (sp *SlowCallPeerstore) Peers() peer.IDSlice {
+	defer sp.done(TracePeers, "", sp.start())
+	return sp.Peerstore.Peers()
+}
@@ -0,0 +1,82 @@
+package peerstore_test
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	core "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+// slowBackendPeerstore is a peerstore whose address reads run a backend operation taking delay.
+type slowBackendPeerstore struct {
+	core.Peerstore
+	delay time.Duration
+	reads int64
+}
+
+func (ps *slowBackendPeerstore) Addrs(p peer.ID) []ma.Multiaddr {
+	time.Sleep(ps.delay)
+	atomic.AddInt64(&ps.reads, 1)
+	return ps.Peerstore.Addrs(p)
+}
+
+func (ps *slowBackendPeerstore) BackendTimings() []pstore.BackendTiming {
+	n := atomic.LoadInt64(&ps.reads)
+	return []pstore.BackendTiming{
+		{Op: "read", Count: uint64(n), Duration: time.Duration(n) * ps.delay},
+		{Op: "write"},
+	}
+}
+
+func TestSlowCallPeerstore(t *testing.T) {
+	backend := &slowBackendPeerstore{Peerstore: pstoremem.NewPeerstore(), delay: 20 * time.Millisecond}
+	var calls []pstore.SlowCall
+	ps := pstore.NewSlowCallPeerstore(backend, 10*time.Millisecond, pstore.SlowCallLoggerFunc(func(c pstore.SlowCall) {
+		// other calls may be slow too on a loaded machine.
+		if c.Op == pstore.TraceAddrs {
+			calls = append(calls, c)
+		}
+	}))
+	defer ps.Close()
+
+	id := pt.GeneratePeerIDs(1)[0]
+	ps.AddAddrs(id, pt.GenerateAddrs(2), time.Hour)
+	ps.Addrs(id)
+	ps.Addrs(id)
+
+	if len(calls) != 2 {
+		t.Fatalf("expected both reads to be slow, got %v", calls)
+	}
+	c := calls[0]
+	if c.Op != pstore.TraceAddrs || c.Peer != id || c.Duration < backend.delay {
+		t.Fatalf("unexpected slow call %+v", c)
+	}
+	// only the operations of the call itself are reported.
+	if len(c.Backend) != 1 || c.Backend[0].Op != "read" || c.Backend[0].Count != 1 || c.Backend[0].Duration != backend.delay {
+		t.Fatalf("expected a single backend read, got %v", c.Backend)
+	}
+	if s := c.String(); !strings.Contains(s, "Addrs of peer") || !strings.Contains(s, "1 read in 20ms") {
+		t.Fatalf("unexpected description %q", s)
+	}
+}
+
+func TestSlowCallPeerstoreWithoutTimings(t *testing.T) {
+	var calls []pstore.SlowCall
+	ps := pstore.NewSlowCallPeerstore(pstoremem.NewPeerstore(), -1, pstore.SlowCallLoggerFunc(func(c pstore.SlowCall) {
+		calls = append(calls, c)
+	}))
+	defer ps.Close()
+
+	ps.Peers()
+	if len(calls) != 1 || calls[0].Op != pstore.TracePeers || calls[0].Peer != "" || calls[0].Backend != nil {
+		t.Fatalf("expected a call without backend breakdown, got %v", calls)
+	}
+}
@@ -0,0 +1,143 @@
+package peerstore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrInvalidArgument is wrapped by the errors of StrictChecks, so that callers can tell them apart with errors.Is.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// MaxSaneTTL is the longest address TTL StrictChecks accept, besides ConnectedAddrTTL and PermanentAddrTTL.
+const MaxSaneTTL = 10 * 365 * 24 * time.Hour
+
+// ArgumentError reports an argument rejected by StrictChecks.
+type ArgumentError struct {
+	// Op is the peerstore method called.
+	Op     string
+	Peer   peer.ID
+	Reason string
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("%s for peer %s: %s", e.Op, e.Peer, e.Reason)
+}
+
+func (e *ArgumentError) Unwrap() error {
+	return ErrInvalidArgument
+}
+
+// StrictChecks validate the arguments of peerstore writes aggressively, rejecting calls that are silently ignored
+// otherwise, such as adding nil addresses, addresses with a TTL that adds nothing or makes no sense, or empty
+// metadata keys and protocols. They are meant to catch integration bugs in development and tests: books rejecting a
+// call store nothing, and return an *ArgumentError, or log it from the methods that can't return errors.
+//
+// A nil *StrictChecks accepts everything, beyond the checks books always apply.
+type StrictChecks struct{}
+
+// NewStrictChecks returns StrictChecks if enabled is set, nil otherwise.
+func NewStrictChecks(enabled bool) *StrictChecks {
+	if !enabled {
+		return nil
+	}
+	return &StrictChecks{}
+}
+
+// AddAddrs checks the arguments of AddAddrs: the TTL must be positive, as nothing would be added otherwise.
+func (sc *StrictChecks) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	if sc == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		return argumentError("AddAddrs", p, "non-positive TTL %s adds nothing", ttl)
+	}
+	return checkAddrs("AddAddrs", p, addrs, ttl)
+}
+
+// SetAddrs checks the arguments of SetAddrs. Non-positive TTLs are accepted, as they remove the addresses.
+func (sc *StrictChecks) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	if sc == nil {
+		return nil
+	}
+	return checkAddrs("SetAddrs", p, addrs, ttl)
+}
+
+// UpdateAddrs checks the arguments of UpdateAddrs: no address can have a non-positive TTL to update. Non-positive new
+// TTLs are accepted, as they remove the addresses.
+func (sc *StrictChecks) UpdateAddrs(p peer.ID, oldTTL, newTTL time.Duration) error {
+	if sc == nil {
+		return nil
+	}
+	if err := p.Validate(); err != nil {
+		return argumentError("UpdateAddrs", p, "%s", err)
+	}
+	if oldTTL <= 0 {
+		return argumentError("UpdateAddrs", p, "no address has the non-positive TTL %s", oldTTL)
+	}
+	if !saneTTL(oldTTL) || !saneTTL(newTTL) {
+		return argumentError("UpdateAddrs", p, "TTL beyond %s", MaxSaneTTL)
+	}
+	return nil
+}
+
+// Put checks the arguments of PeerMetadata.Put: the key must not be empty, nor the value nil.
+func (sc *StrictChecks) Put(p peer.ID, key string, val interface{}) error {
+	if sc == nil {
+		return nil
+	}
+	if err := p.Validate(); err != nil {
+		return argumentError("Put", p, "%s", err)
+	}
+	if key == "" {
+		return argumentError("Put", p, "empty metadata key")
+	}
+	if val == nil {
+		return argumentError("Put", p, "nil value for metadata key %s", key)
+	}
+	return nil
+}
+
+// Protocols checks the arguments of the ProtoBook method op: protocols must not be empty.
+func (sc *StrictChecks) Protocols(op string, p peer.ID, protos []string) error {
+	if sc == nil {
+		return nil
+	}
+	if err := p.Validate(); err != nil {
+		return argumentError(op, p, "%s", err)
+	}
+	for _, proto := range protos {
+		if proto == "" {
+			return argumentError(op, p, "empty protocol")
+		}
+	}
+	return nil
+}
+
+func checkAddrs(op string, p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) error {
+	if err := p.Validate(); err != nil {
+		return argumentError(op, p, "%s", err)
+	}
+	if !saneTTL(ttl) {
+		return argumentError(op, p, "TTL %s beyond %s", ttl, MaxSaneTTL)
+	}
+	for i, a := range addrs {
+		if a == nil {
+			return argumentError(op, p, "nil address at index %d", i)
+		}
+	}
+	return nil
+}
+
+// saneTTL returns whether ttl is no longer than MaxSaneTTL, or one of the TTLs meaning forever.
+func saneTTL(ttl time.Duration) bool {
+	return ttl <= MaxSaneTTL || ttl >= pstore.ConnectedAddrTTL
+}
+
+func argumentError(op string, p peer.ID, format string, args ...interface{}) error {
+	return &ArgumentError{Op: op, Peer: p, Reason: fmt.Sprintf(format, args...)}
+}
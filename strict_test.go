@@ -0,0 +1,63 @@
+package peerstore_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	core "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestStrictChecks(t *testing.T) {
+	p := pt.GeneratePeerIDs(1)[0]
+	addrs := pt.GenerateAddrs(2)
+	sc := pstore.NewStrictChecks(true)
+
+	rejected := map[string]error{
+		"nil address":        sc.AddAddrs(p, []ma.Multiaddr{addrs[0], nil}, time.Hour),
+		"empty peer ID":      sc.AddAddrs("", addrs, time.Hour),
+		"zero TTL":           sc.AddAddrs(p, addrs, 0),
+		"absurd TTL":         sc.AddAddrs(p, addrs, 100*365*24*time.Hour),
+		"nil address set":    sc.SetAddrs(p, []ma.Multiaddr{nil}, time.Hour),
+		"no address has TTL": sc.UpdateAddrs(p, 0, time.Hour),
+		"absurd new TTL":     sc.UpdateAddrs(p, time.Hour, pstore.MaxSaneTTL+1),
+		"empty key":          sc.Put(p, "", 1),
+		"nil value":          sc.Put(p, "key", nil),
+		"empty protocol":     sc.Protocols("AddProtocols", p, []string{"/a", ""}),
+	}
+	for name, err := range rejected {
+		if !errors.Is(err, pstore.ErrInvalidArgument) {
+			t.Errorf("%s: expected an invalid argument error, got %v", name, err)
+		}
+		var aerr *pstore.ArgumentError
+		if !errors.As(err, &aerr) || aerr.Peer == "" && name != "empty peer ID" {
+			t.Errorf("%s: expected an argument error naming the peer, got %v", name, err)
+		}
+	}
+
+	accepted := map[string]error{
+		"add":           sc.AddAddrs(p, addrs, time.Hour),
+		"permanent TTL": sc.AddAddrs(p, addrs, core.PermanentAddrTTL),
+		"connected TTL": sc.AddAddrs(p, addrs, core.ConnectedAddrTTL),
+		"removal":       sc.SetAddrs(p, addrs, 0),
+		"update":        sc.UpdateAddrs(p, core.ConnectedAddrTTL, core.RecentlyConnectedAddrTTL),
+		"put":           sc.Put(p, "key", "value"),
+		"protocols":     sc.Protocols("AddProtocols", p, []string{"/a"}),
+	}
+	for name, err := range accepted {
+		if err != nil {
+			t.Errorf("%s: expected no error, got %s", name, err)
+		}
+	}
+
+	// disabled checks accept anything.
+	var disabled *pstore.StrictChecks
+	if err := disabled.AddAddrs(peer.ID(""), []ma.Multiaddr{nil}, -1); err != nil {
+		t.Fatalf("expected disabled checks to accept anything, got %s", err)
+	}
+}
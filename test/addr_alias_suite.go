@@ -0,0 +1,69 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// testAddrAliases checks that an address book created with Config.AddrAliases keeps the TTLs of aliases in sync, and
+// returns a single address per endpoint.
+func testAddrAliases(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		eab, ok := ab.(peerstore.ExpiringAddrBook)
+		if !ok {
+			t.Skip("address book does not expose address expiry")
+		}
+
+		id := GeneratePeerIDs(1)[0]
+		tcp := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+		ws := ma.StringCast("/ip4/1.2.3.4/tcp/4001/ws")
+		other := ma.StringCast("/ip4/1.2.3.4/tcp/4002")
+
+		ab.AddAddrs(id, []ma.Multiaddr{tcp, other}, time.Hour)
+		deps.sleep(time.Minute)
+		ab.AddAddr(id, ws, 2*time.Hour)
+
+		// tcp follows ws, its alias; other is left alone.
+		for _, a := range eab.AddrsWithExpiry(id) {
+			want := 2 * time.Hour
+			if a.Addr.Equal(other) {
+				want = time.Hour
+			}
+			if a.TTL != want {
+				t.Errorf("expected TTL %s for %s, got %s", want, a.Addr, a.TTL)
+			}
+		}
+		if n := len(eab.AddrsWithExpiry(id)); n != 3 {
+			t.Fatalf("expected all 3 addresses to be held, got %d", n)
+		}
+
+		addrs := ab.Addrs(id)
+		if len(addrs) != 2 {
+			t.Fatalf("expected one address per endpoint, got %v", addrs)
+		}
+		var hasOther bool
+		for _, a := range addrs {
+			hasOther = hasOther || a.Equal(other)
+		}
+		if !hasOther {
+			t.Errorf("expected %s to be returned, got %v", other, addrs)
+		}
+
+		// setting an alias updates the group too.
+		ab.SetAddr(id, tcp, 10*time.Minute)
+		for _, a := range eab.AddrsWithExpiry(id) {
+			if a.Addr.Equal(ws) && a.TTL != 10*time.Minute {
+				t.Errorf("expected the TTL of %s to follow its alias, got %s", ws, a.TTL)
+			}
+		}
+
+		// removing an address keeps its aliases.
+		ab.SetAddr(id, tcp, -1)
+		AssertAddressesEqual(t, []ma.Multiaddr{ws, other}, ab.Addrs(id))
+	}
+}
@@ -10,38 +10,110 @@ import (
 	"time"
 
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/addr"
 )
 
-var addressBookSuite = map[string]func(book pstore.AddrBook) func(*testing.T){
+var addressBookSuite = map[string]func(book pstore.AddrBook, deps *Deps) func(*testing.T){
 	"AddAddress":           testAddAddress,
 	"Clear":                testClearWorks,
 	"SetNegativeTTLClears": testSetNegativeTTLClears,
 	"UpdateTTLs":           testUpdateTTLs,
 	"NilAddrsDontBreak":    testNilAddrsDontBreak,
 	"AddressesExpire":      testAddressesExpire,
+	"ExpiredNotRevived":    testExpiredNotRevived,
 	"ClearWithIter":        testClearWithIterator,
 	"PeersWithAddresses":   testPeersWithAddrs,
 	"CertifiedAddresses":   testCertifiedAddresses,
+	"AddrsWithExpiry":      testAddrsWithExpiry,
+	"ExpiringBefore":       testExpiringBefore,
+	"AddrsByRecency":       testAddrsByRecency,
+	"MutatorErrors":        testMutatorErrors,
+	"ExoticAddrs":          testExoticAddrsStored,
+}
+
+// addressBookConfigSuite holds the tests of address book options, each run against an address book created with the
+// Config set by configure, or the default one if configure is nil. Only TestAddrBookWithDeps runs them, as plain
+// factories can't be configured.
+var addressBookConfigSuite = map[string]struct {
+	configure func(*Config)
+	test      func(book pstore.AddrBook, deps *Deps) func(*testing.T)
+}{
+	"TTLPolicyMax": {func(c *Config) { c.TTLPolicy = peerstore.MaxTTL },
+		testTTLPolicy(0, ttlWrite{time.Hour, 0}, ttlWrite{10 * time.Minute, 0})},
+	"TTLPolicyMaxLaterExpiry": {func(c *Config) { c.TTLPolicy = peerstore.MaxTTL },
+		testTTLPolicy(1, ttlWrite{time.Hour, 0}, ttlWrite{time.Hour, time.Minute})},
+	"TTLPolicyLatest": {func(c *Config) { c.TTLPolicy = peerstore.LatestTTL },
+		testTTLPolicy(1, ttlWrite{time.Hour, 0}, ttlWrite{10 * time.Minute, 0})},
+	"TTLPolicySourcePriorityHigher": {func(c *Config) {
+		c.TTLPolicy = peerstore.SourcePriorityTTL(peerstore.DefaultTTLRank)
+	}, testTTLPolicy(0, ttlWrite{pstore.ConnectedAddrTTL, 0}, ttlWrite{pstore.TempAddrTTL, 0})},
+	"TTLPolicySourcePriorityEqual": {func(c *Config) {
+		c.TTLPolicy = peerstore.SourcePriorityTTL(peerstore.DefaultTTLRank)
+	}, testTTLPolicy(1, ttlWrite{time.Hour, 0}, ttlWrite{10 * time.Minute, 0})},
+	"TTLJitter":          {func(c *Config) { c.TTLJitter = 0.5 }, testTTLJitter},
+	"MaxAddrTTL":         {func(c *Config) { c.MaxAddrTTL = 10 * time.Minute }, testMaxAddrTTL},
+	"MaxAddrLen":         {func(c *Config) { c.MaxAddrLen = 64 }, testMaxAddrLen},
+	"AddrOrderInsertion": {func(c *Config) { c.AddrOrder = peerstore.AddrOrderInsertion }, testAddrOrderInsertion},
+	"AddrOrderBytes":     {func(c *Config) { c.AddrOrder = peerstore.AddrOrderBytes }, testAddrOrderBytes},
+	"AddrAliases":        {func(c *Config) { c.AddrAliases = true }, testAddrAliases},
+	"AddrHistory":        {func(c *Config) { c.AddrHistory = 16 }, testAddrHistory},
+	"AddrHistoryBounded": {func(c *Config) { c.AddrHistory = 3 }, testAddrHistoryBounded},
+	"ExoticCertHashTTL":  {nil, testExoticCertHashTTL},
+	"ExoticAddrsRejected": {func(c *Config) {
+		c.ExoticAddrPolicy = peerstore.ExoticAddrPolicy{Validate: true, Drop: []addr.ExoticKind{addr.Onion}}
+	}, testExoticAddrsRejected},
+	"P2PAddrKeep":   {func(c *Config) { c.P2PAddrPolicy = peerstore.P2PAddrKeep }, testP2PAddrPolicy},
+	"P2PAddrStrip":  {func(c *Config) { c.P2PAddrPolicy = peerstore.P2PAddrStrip }, testP2PAddrPolicy},
+	"P2PAddrReject": {func(c *Config) { c.P2PAddrPolicy = peerstore.P2PAddrReject }, testP2PAddrPolicy},
 }
 
 type AddrBookFactory func() (pstore.AddrBook, func())
 
+// AddrBookDepsFactory creates an address book wired to the injected dependencies.
+type AddrBookDepsFactory func(deps *Deps) (pstore.AddrBook, func())
+
+// TestAddrBook runs the address book suite in real time.
 func TestAddrBook(t *testing.T, factory AddrBookFactory) {
+	runAddrBookSuite(t, func(*Deps) (pstore.AddrBook, func()) { return factory() }, realDeps)
+}
+
+// TestAddrBookWithDeps runs the address book suite, and the tests of address book options, against address books
+// wired to fresh dependencies, created for each test. Address books must source time from Deps.Clock, and be created
+// with Deps.Config.
+func TestAddrBookWithDeps(t *testing.T, factory AddrBookDepsFactory) {
+	runAddrBookSuite(t, factory, newDeps)
+	for name, c := range addressBookConfigSuite {
+		deps := newDeps()
+		if c.configure != nil {
+			c.configure(&deps.Config)
+		}
+		runAddrBookTest(t, name, c.test, factory, deps)
+	}
+}
+
+func runAddrBookSuite(t *testing.T, factory AddrBookDepsFactory, mkDeps func() *Deps) {
 	for name, test := range addressBookSuite {
-		// Create a new peerstore.
-		ab, closeFunc := factory()
+		runAddrBookTest(t, name, test, factory, mkDeps())
+	}
+}
 
-		// Run the test.
-		t.Run(name, test(ab))
+func runAddrBookTest(t *testing.T, name string, test func(pstore.AddrBook, *Deps) func(*testing.T),
+	factory AddrBookDepsFactory, deps *Deps) {
+	// Create a new address book.
+	ab, closeFunc := factory(deps)
 
-		// Cleanup.
-		if closeFunc != nil {
-			closeFunc()
-		}
+	// Run the test.
+	t.Run(name, test(ab, deps))
+	CheckInvariants(t, name, ab)
+
+	// Cleanup.
+	if closeFunc != nil {
+		closeFunc()
 	}
 }
 
-func testAddAddress(ab pstore.AddrBook) func(*testing.T) {
+func testAddAddress(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
 	return func(t *testing.T) {
 		t.Run("add a single address", func(t *testing.T) {
 			id := GeneratePeerIDs(1)[0]
@@ -90,7 +162,7 @@ func testAddAddress(ab pstore.AddrBook) func(*testing.T) {
 			ab.AddAddrs(id, addrs[2:], time.Hour)
 
 			// after the initial TTL has expired, check that only the third address is present.
-			time.Sleep(1200 * time.Millisecond)
+			deps.sleep(1200 * time.Millisecond)
 			AssertAddressesEqual(t, addrs[2:], ab.Addrs(id))
 
 			// make sure we actually set the TTL
@@ -109,7 +181,7 @@ func testAddAddress(ab pstore.AddrBook) func(*testing.T) {
 
 			// after the initial TTL has expired, check that all three addresses are still present (i.e. the TTL on
 			// the modified one was not shortened).
-			time.Sleep(2100 * time.Millisecond)
+			deps.sleep(2100 * time.Millisecond)
 			AssertAddressesEqual(t, addrs, ab.Addrs(id))
 		})
 
@@ -119,11 +191,11 @@ func testAddAddress(ab pstore.AddrBook) func(*testing.T) {
 
 			ab.AddAddrs(id, addrs, 4*time.Second)
 			// 4 seconds left
-			time.Sleep(3 * time.Second)
+			deps.sleep(3 * time.Second)
 			// 1 second left
 			ab.AddAddrs(id, addrs, 3*time.Second)
 			// 3 seconds left
-			time.Sleep(2)
+			deps.sleep(2)
 			// 1 seconds left.
 
 			// We still have the address.
@@ -136,7 +208,7 @@ func testAddAddress(ab pstore.AddrBook) func(*testing.T) {
 	}
 }
 
-func testClearWorks(ab pstore.AddrBook) func(t *testing.T) {
+func testClearWorks(ab pstore.AddrBook, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		ids := GeneratePeerIDs(2)
 		addrs := GenerateAddrs(5)
@@ -157,7 +229,7 @@ func testClearWorks(ab pstore.AddrBook) func(t *testing.T) {
 	}
 }
 
-func testSetNegativeTTLClears(m pstore.AddrBook) func(t *testing.T) {
+func testSetNegativeTTLClears(m pstore.AddrBook, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		id := GeneratePeerIDs(1)[0]
 		addrs := GenerateAddrs(100)
@@ -201,7 +273,7 @@ func testSetNegativeTTLClears(m pstore.AddrBook) func(t *testing.T) {
 	}
 }
 
-func testUpdateTTLs(m pstore.AddrBook) func(t *testing.T) {
+func testUpdateTTLs(m pstore.AddrBook, deps *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		t.Run("update ttl of peer with no addrs", func(t *testing.T) {
 			id := GeneratePeerIDs(1)[0]
@@ -234,7 +306,7 @@ func testUpdateTTLs(m pstore.AddrBook) func(t *testing.T) {
 			AssertAddressesEqual(t, addrs2, m.Addrs(ids[1]))
 
 			// After a wait, addrs[0] is gone.
-			time.Sleep(1500 * time.Millisecond)
+			deps.sleep(1500 * time.Millisecond)
 			AssertAddressesEqual(t, addrs1[1:2], m.Addrs(ids[0]))
 			AssertAddressesEqual(t, addrs2, m.Addrs(ids[1]))
 
@@ -245,7 +317,7 @@ func testUpdateTTLs(m pstore.AddrBook) func(t *testing.T) {
 			AssertAddressesEqual(t, addrs1[1:2], m.Addrs(ids[0]))
 			AssertAddressesEqual(t, addrs2, m.Addrs(ids[1]))
 
-			time.Sleep(1500 * time.Millisecond)
+			deps.sleep(1500 * time.Millisecond)
 
 			// First addrs is gone in both.
 			AssertAddressesEqual(t, addrs1[1:], m.Addrs(ids[0]))
@@ -255,7 +327,7 @@ func testUpdateTTLs(m pstore.AddrBook) func(t *testing.T) {
 	}
 }
 
-func testNilAddrsDontBreak(m pstore.AddrBook) func(t *testing.T) {
+func testNilAddrsDontBreak(m pstore.AddrBook, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		id := GeneratePeerIDs(1)[0]
 
@@ -264,7 +336,7 @@ func testNilAddrsDontBreak(m pstore.AddrBook) func(t *testing.T) {
 	}
 }
 
-func testAddressesExpire(m pstore.AddrBook) func(t *testing.T) {
+func testAddressesExpire(m pstore.AddrBook, deps *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		ids := GeneratePeerIDs(2)
 		addrs1 := GenerateAddrs(3)
@@ -283,33 +355,33 @@ func testAddressesExpire(m pstore.AddrBook) func(t *testing.T) {
 		AssertAddressesEqual(t, addrs2, m.Addrs(ids[1]))
 
 		m.SetAddr(ids[0], addrs1[0], 100*time.Microsecond)
-		<-time.After(100 * time.Millisecond)
+		deps.sleep(100 * time.Millisecond)
 		AssertAddressesEqual(t, addrs1[1:3], m.Addrs(ids[0]))
 		AssertAddressesEqual(t, addrs2, m.Addrs(ids[1]))
 
 		m.SetAddr(ids[0], addrs1[2], 100*time.Microsecond)
-		<-time.After(100 * time.Millisecond)
+		deps.sleep(100 * time.Millisecond)
 		AssertAddressesEqual(t, addrs1[1:2], m.Addrs(ids[0]))
 		AssertAddressesEqual(t, addrs2, m.Addrs(ids[1]))
 
 		m.SetAddr(ids[1], addrs2[0], 100*time.Microsecond)
-		<-time.After(100 * time.Millisecond)
+		deps.sleep(100 * time.Millisecond)
 		AssertAddressesEqual(t, addrs1[1:2], m.Addrs(ids[0]))
 		AssertAddressesEqual(t, addrs2[1:], m.Addrs(ids[1]))
 
 		m.SetAddr(ids[1], addrs2[1], 100*time.Microsecond)
-		<-time.After(100 * time.Millisecond)
+		deps.sleep(100 * time.Millisecond)
 		AssertAddressesEqual(t, addrs1[1:2], m.Addrs(ids[0]))
 		AssertAddressesEqual(t, nil, m.Addrs(ids[1]))
 
 		m.SetAddr(ids[0], addrs1[1], 100*time.Microsecond)
-		<-time.After(100 * time.Millisecond)
+		deps.sleep(100 * time.Millisecond)
 		AssertAddressesEqual(t, nil, m.Addrs(ids[0]))
 		AssertAddressesEqual(t, nil, m.Addrs(ids[1]))
 	}
 }
 
-func testClearWithIterator(m pstore.AddrBook) func(t *testing.T) {
+func testClearWithIterator(m pstore.AddrBook, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		ids := GeneratePeerIDs(2)
 		addrs := GenerateAddrs(100)
@@ -336,7 +408,7 @@ func testClearWithIterator(m pstore.AddrBook) func(t *testing.T) {
 	}
 }
 
-func testPeersWithAddrs(m pstore.AddrBook) func(t *testing.T) {
+func testPeersWithAddrs(m pstore.AddrBook, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		// cannot run in parallel as the store is modified.
 		// go runs sequentially in the specified order
@@ -362,7 +434,7 @@ func testPeersWithAddrs(m pstore.AddrBook) func(t *testing.T) {
 	}
 }
 
-func testCertifiedAddresses(m pstore.AddrBook) func(*testing.T) {
+func testCertifiedAddresses(m pstore.AddrBook, deps *Deps) func(*testing.T) {
 	return func(t *testing.T) {
 		cab := m.(pstore.CertifiedAddrBook)
 
@@ -467,7 +539,7 @@ func testCertifiedAddresses(m pstore.AddrBook) func(*testing.T) {
 		test.AssertNilError(t, err)
 		AssertAddressesEqual(t, certifiedAddrs, m.Addrs(id))
 
-		time.Sleep(2 * time.Second)
+		deps.sleep(2 * time.Second)
 		if cab.GetPeerRecord(id) != nil {
 			t.Error("expected signed peer record to be removed when addresses expire")
 		}
@@ -484,3 +556,174 @@ func testCertifiedAddresses(m pstore.AddrBook) func(*testing.T) {
 		}
 	}
 }
+
+func testAddrsWithExpiry(m pstore.AddrBook, deps *Deps) func(t *testing.T) {
+	return func(t *testing.T) {
+		eab, ok := m.(peerstore.ExpiringAddrBook)
+		if !ok {
+			t.Skip("address book does not implement ExpiringAddrBook")
+		}
+
+		id := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(2)
+
+		start := deps.now()
+		m.AddAddr(id, addrs[0], time.Hour)
+		m.AddAddr(id, addrs[1], 2*time.Hour)
+
+		got := eab.AddrsWithExpiry(id)
+		if len(got) != 2 {
+			t.Fatalf("expected 2 addresses, got %d", len(got))
+		}
+		for _, a := range got {
+			ttl := time.Hour
+			if a.Addr.Equal(addrs[1]) {
+				ttl = 2 * time.Hour
+			} else if !a.Addr.Equal(addrs[0]) {
+				t.Fatalf("unexpected address %s", a.Addr)
+			}
+			if a.TTL != ttl {
+				t.Errorf("expected TTL %s for %s, got %s", ttl, a.Addr, a.TTL)
+			}
+			if !expiresWithin(a.Expires, start.Add(ttl), start.Add(ttl)) {
+				t.Errorf("unexpected expiry for %s: %s", a.Addr, a.Expires)
+			}
+		}
+
+		m.SetAddr(id, addrs[0], -1)
+		if got := eab.AddrsWithExpiry(id); len(got) != 1 || !got[0].Addr.Equal(addrs[1]) {
+			t.Errorf("expected only the remaining address, got %v", got)
+		}
+	}
+}
+
+// testExpiredNotRevived checks that addresses that expired, but weren't garbage collected yet, are treated as absent by
+// the mutators: UpdateAddrs leaves them expired, and re-adding them doesn't merge with their stale TTL.
+func testExpiredNotRevived(m pstore.AddrBook, deps *Deps) func(t *testing.T) {
+	return func(t *testing.T) {
+		id := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(2)
+
+		m.AddAddr(id, addrs[0], time.Second)
+		m.AddAddr(id, addrs[1], 2*time.Second)
+		deps.sleep(3 * time.Second)
+
+		m.UpdateAddrs(id, time.Second, time.Hour)
+		m.AddAddr(id, addrs[1], time.Second)
+		AssertAddressesEqual(t, addrs[1:], m.Addrs(id))
+
+		if eab, ok := m.(peerstore.ExpiringAddrBook); ok {
+			if got := eab.AddrsWithExpiry(id); len(got) != 1 || got[0].TTL != time.Second {
+				t.Errorf("expected the re-added address with its new TTL, got %v", got)
+			}
+		}
+	}
+}
+
+func testExpiringBefore(m pstore.AddrBook, deps *Deps) func(t *testing.T) {
+	return func(t *testing.T) {
+		et, ok := m.(peerstore.ExpiryTimeline)
+		if !ok {
+			t.Skip("address book does not implement ExpiryTimeline")
+		}
+
+		ids := GeneratePeerIDs(2)
+		addrs := GenerateAddrs(3)
+
+		start := deps.now()
+		m.AddAddr(ids[0], addrs[0], time.Hour)
+		m.AddAddr(ids[0], addrs[1], 2*time.Hour)
+		m.AddAddr(ids[1], addrs[2], 3*time.Hour)
+
+		got := et.ExpiringBefore(start.Add(90 * time.Minute))
+		if len(got) != 1 {
+			t.Fatalf("expected a single peer, got %v", got)
+		}
+		AssertAddressesEqual(t, addrs[:1], got[ids[0]])
+
+		got = et.ExpiringBefore(start.Add(4 * time.Hour))
+		if len(got) != 2 {
+			t.Fatalf("expected both peers, got %v", got)
+		}
+		AssertAddressesEqual(t, addrs[:2], got[ids[0]])
+		AssertAddressesEqual(t, addrs[2:], got[ids[1]])
+
+		// removed addresses are no longer listed.
+		m.SetAddr(ids[0], addrs[0], -1)
+		got = et.ExpiringBefore(start.Add(90 * time.Minute))
+		if len(got) != 0 {
+			t.Errorf("expected no peers, got %v", got)
+		}
+	}
+}
+
+func testMutatorErrors(m pstore.AddrBook, _ *Deps) func(t *testing.T) {
+	return func(t *testing.T) {
+		abe, ok := m.(peerstore.AddrBookE)
+		if !ok {
+			t.Skip("address book does not implement AddrBookE")
+		}
+
+		id := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(3)
+
+		if err := abe.AddAddrsE(id, addrs[:2], time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := abe.SetAddrsE(id, addrs[2:], time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		AssertAddressesEqual(t, addrs, m.Addrs(id))
+
+		if err := abe.SetAddrsE(id, addrs[:1], -1); err != nil {
+			t.Fatal(err)
+		}
+		AssertAddressesEqual(t, addrs[1:], m.Addrs(id))
+
+		if err := abe.ClearAddrsE(id); err != nil {
+			t.Fatal(err)
+		}
+		AssertAddressesEqual(t, nil, m.Addrs(id))
+
+		if err := abe.ClearAddrsE(""); err == nil {
+			t.Error("expected an error when clearing addresses of an invalid peer")
+		}
+	}
+}
+
+func testAddrsByRecency(m pstore.AddrBook, deps *Deps) func(t *testing.T) {
+	return func(t *testing.T) {
+		rab, ok := m.(peerstore.RecencyAddrBook)
+		if !ok {
+			t.Skip("address book does not implement RecencyAddrBook")
+		}
+
+		id := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(3)
+
+		// timestamps may be stored with second granularity.
+		m.AddAddr(id, addrs[0], time.Hour)
+		deps.sleep(1100 * time.Millisecond)
+		m.AddAddr(id, addrs[1], time.Hour)
+		deps.sleep(1100 * time.Millisecond)
+		m.SetAddr(id, addrs[2], time.Hour)
+		deps.sleep(1100 * time.Millisecond)
+
+		// adding an address again confirms it, even if its TTL is left unchanged.
+		confirmed := deps.now()
+		m.AddAddr(id, addrs[0], time.Minute)
+
+		got := rab.AddrsByRecency(id)
+		if len(got) != 3 {
+			t.Fatalf("expected 3 addresses, got %v", got)
+		}
+		for i, want := range []int{0, 2, 1} {
+			if !got[i].Addr.Equal(addrs[want]) {
+				t.Fatalf("expected address %d at position %d, got %v", want, i, got)
+			}
+		}
+		if d := got[0].LastSeen.Sub(confirmed); d < -time.Second || d > time.Second {
+			t.Fatalf("expected the address to be last seen at %s, got %s", confirmed, got[0].LastSeen)
+		}
+	}
+}
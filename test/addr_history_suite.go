@@ -0,0 +1,101 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// testAddrHistory checks that an address book records the additions, expiries and removals of the addresses of a
+// peer, but not the refreshes of their TTLs.
+func testAddrHistory(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		cb, ok := ab.(peerstore.AddrChurnBook)
+		if !ok {
+			t.Skip("address book does not keep an address history")
+		}
+
+		id := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(3)
+		t0 := deps.now()
+
+		ab.AddAddrs(id, addrs[:2], time.Hour)
+		deps.sleep(time.Minute)
+		ab.AddAddr(id, addrs[0], time.Hour)
+		t1 := deps.now()
+		ab.SetAddr(id, addrs[1], 0)
+		ab.AddAddr(id, addrs[2], 10*time.Minute)
+		deps.sleep(20 * time.Minute)
+		// the expired address is dropped as it's added again.
+		ab.AddAddr(id, addrs[2], time.Hour)
+		t2 := deps.now()
+		ab.ClearAddrs(id)
+
+		type event struct {
+			addr ma.Multiaddr
+			typ  peerstore.AddrEventType
+			time time.Time
+		}
+		want := []event{
+			{addrs[0], peerstore.AddrAdded, t0},
+			{addrs[1], peerstore.AddrAdded, t0},
+			{addrs[1], peerstore.AddrRemoved, t1},
+			{addrs[2], peerstore.AddrAdded, t1},
+			{addrs[2], peerstore.AddrExpired, t1.Add(10 * time.Minute)},
+			{addrs[2], peerstore.AddrAdded, t2},
+			{nil, peerstore.AddrRemoved, t2},
+			{nil, peerstore.AddrRemoved, t2},
+		}
+		got := cb.AddrHistory(id, time.Time{})
+		if len(got) != len(want) {
+			t.Fatalf("expected %d events, got %v", len(want), got)
+		}
+		for i, w := range want {
+			g := got[i]
+			if g.Type != w.typ || (w.addr != nil && !g.Addr.Equal(w.addr)) ||
+				!expiresWithin(g.Time, w.time, w.time) || g.Time.After(w.time) {
+				t.Fatalf("event %d: expected %s %s at %s, got %s", i, w.typ, w.addr, w.time, g)
+			}
+		}
+		if got[6].Addr.Equal(got[7].Addr) {
+			t.Fatalf("expected both remaining addresses to be removed, got %v", got[6:])
+		}
+
+		if got := cb.AddrHistory(id, t1); len(got) != 6 || got[0].Type != peerstore.AddrRemoved {
+			t.Fatalf("expected the events since the removal, got %v", got)
+		}
+		if got := cb.AddrHistory(id, t2.Add(time.Second)); len(got) != 0 {
+			t.Fatalf("expected no event after the last one, got %v", got)
+		}
+	}
+}
+
+// testAddrHistoryBounded checks that an address book keeps the latest Config.AddrHistory address events of a peer only.
+func testAddrHistoryBounded(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		size := deps.Config.AddrHistory
+		cb, ok := ab.(peerstore.AddrChurnBook)
+		if !ok {
+			t.Skip("address book does not keep an address history")
+		}
+
+		id := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(size + 2)
+		for _, a := range addrs {
+			ab.AddAddr(id, a, time.Hour)
+			deps.sleep(time.Second)
+		}
+		got := cb.AddrHistory(id, time.Time{})
+		if len(got) != size {
+			t.Fatalf("expected the latest %d events, got %v", size, got)
+		}
+		for i, e := range got {
+			if !e.Addr.Equal(addrs[2+i]) {
+				t.Fatalf("expected the additions of the latest addresses, got %v", got)
+			}
+		}
+	}
+}
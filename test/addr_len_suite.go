@@ -0,0 +1,45 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// testMaxAddrLen checks that an address book drops the addresses longer than Config.MaxAddrLen, whether added, set or
+// consumed from signed peer records, stores the others, and reports the rejection if it reports errors.
+func testMaxAddrLen(ab pstore.AddrBook, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		id := GeneratePeerIDs(1)[0]
+		short := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+		long := ma.StringCast("/dns4/" + strings.Repeat("a", 100) + ".example.com/tcp/4001")
+		ab.AddAddrs(id, []ma.Multiaddr{short, long}, time.Hour)
+		AssertAddressesEqual(t, []ma.Multiaddr{short}, ab.Addrs(id))
+
+		// the addresses of signed peer records are capped too.
+		if cab, ok := ab.(pstore.CertifiedAddrBook); ok {
+			priv, recID := GenerateIdentity(t)
+			env := SealPeerRecord(t, priv, []ma.Multiaddr{long, short})
+			if accepted, err := cab.ConsumePeerRecord(env, time.Hour); !accepted || err != nil {
+				t.Fatalf("expected the record to be accepted, got %t (%v)", accepted, err)
+			}
+			AssertAddressesEqual(t, []ma.Multiaddr{short}, ab.Addrs(recID))
+		}
+
+		abe, ok := ab.(peerstore.AddrBookE)
+		if !ok {
+			return
+		}
+		if err := abe.AddAddrsE(id, []ma.Multiaddr{long}, time.Hour); err != peerstore.ErrAddrTooLong {
+			t.Fatalf("expected the long address to be rejected, got %v", err)
+		}
+		if err := abe.SetAddrsE(id, []ma.Multiaddr{long, short}, time.Hour); err != peerstore.ErrAddrTooLong {
+			t.Fatalf("expected the long address to be rejected, got %v", err)
+		}
+		AssertAddressesEqual(t, []ma.Multiaddr{short}, ab.Addrs(id))
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// testAddrOrderInsertion checks that an address book created with peerstore.AddrOrderInsertion returns the addresses
+// of a peer in insertion order, across adds, sets, removals and expiry.
+func testAddrOrderInsertion(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		id := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(6)
+		ab.AddAddrs(id, []ma.Multiaddr{addrs[3], addrs[1]}, time.Hour)
+		ab.AddAddr(id, addrs[0], time.Minute)
+		ab.SetAddr(id, addrs[2], time.Hour)
+		assertAddrOrder(t, []ma.Multiaddr{addrs[3], addrs[1], addrs[0], addrs[2]}, ab.Addrs(id))
+
+		// addresses added or set again keep their position.
+		ab.AddAddr(id, addrs[3], 2*time.Hour)
+		ab.SetAddr(id, addrs[1], 2*time.Hour)
+		assertAddrOrder(t, []ma.Multiaddr{addrs[3], addrs[1], addrs[0], addrs[2]}, ab.Addrs(id))
+
+		if eab, ok := ab.(peerstore.ExpiringAddrBook); ok {
+			var got []ma.Multiaddr
+			for _, a := range eab.AddrsWithExpiry(id) {
+				got = append(got, a.Addr)
+			}
+			assertAddrOrder(t, []ma.Multiaddr{addrs[3], addrs[1], addrs[0], addrs[2]}, got)
+		}
+
+		// removed and expired addresses move to the end when added again.
+		ab.SetAddr(id, addrs[3], 0)
+		deps.sleep(2 * time.Minute)
+		ab.AddAddrs(id, []ma.Multiaddr{addrs[0], addrs[4], addrs[3]}, time.Hour)
+		assertAddrOrder(t, []ma.Multiaddr{addrs[1], addrs[2], addrs[0], addrs[4], addrs[3]}, ab.Addrs(id))
+
+		if tl, ok := ab.(peerstore.ExpiryTimeline); ok {
+			got := tl.ExpiringBefore(deps.now().Add(90 * time.Minute))[id]
+			assertAddrOrder(t, []ma.Multiaddr{addrs[2], addrs[0], addrs[4], addrs[3]}, got)
+		}
+	}
+}
+
+// testAddrOrderBytes checks that an address book created with peerstore.AddrOrderBytes returns the addresses of a peer
+// in byte order.
+func testAddrOrderBytes(ab pstore.AddrBook, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		id := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(8)
+		ab.AddAddrs(id, addrs[:4], time.Hour)
+		ab.AddAddrs(id, addrs[4:], time.Minute)
+
+		got := ab.Addrs(id)
+		if len(got) != len(addrs) {
+			t.Fatalf("expected %d addresses, got %d", len(addrs), len(got))
+		}
+		for i := 1; i < len(got); i++ {
+			if bytes.Compare(got[i-1].Bytes(), got[i].Bytes()) >= 0 {
+				t.Fatalf("expected addresses sorted by bytes, got %v", got)
+			}
+		}
+		// the order is stable across reads.
+		assertAddrOrder(t, got, ab.Addrs(id))
+	}
+}
+
+func assertAddrOrder(t *testing.T, exp, act []ma.Multiaddr) {
+	t.Helper()
+	if len(exp) != len(act) {
+		t.Fatalf("expected %v, got %v", exp, act)
+	}
+	for i := range exp {
+		if !exp[i].Equal(act[i]) {
+			t.Fatalf("expected %v, got %v", exp, act)
+		}
+	}
+}
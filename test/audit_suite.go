@@ -0,0 +1,110 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/stretchr/testify/require"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// auditLog is an AuditSink recording the events it's given.
+type auditLog struct {
+	mu     sync.Mutex
+	events []peerstore.AuditEvent
+}
+
+func (l *auditLog) Audit(e peerstore.AuditEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+// take returns the events recorded since the last call.
+func (l *auditLog) take() []peerstore.AuditEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.events
+	l.events = nil
+	return events
+}
+
+// testAuditSink checks that a peerstore records the security-relevant events documented by peerstore.AuditEventType
+// to Config.AuditSink, which must be an *auditLog.
+func testAuditSink(ps pstore.Peerstore, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		sink := deps.Config.AuditSink.(*auditLog)
+		expect := func(want ...peerstore.AuditEvent) {
+			t.Helper()
+			events := sink.take()
+			require.Len(t, events, len(want), "events: %v", events)
+			for i, e := range events {
+				require.False(t, e.Time.IsZero())
+				e.Time = time.Time{}
+				require.Equal(t, want[i], e)
+			}
+		}
+
+		priv, pub, err := test.RandTestKeyPair(crypto.RSA, 2048)
+		require.NoError(t, err)
+		id, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+		other := GeneratePeerIDs(1)[0]
+
+		// keys are audited when first added only.
+		require.NoError(t, ps.AddPubKey(id, pub))
+		require.NoError(t, ps.AddPubKey(id, pub))
+		require.NoError(t, ps.AddPrivKey(id, priv))
+		expect(
+			peerstore.AuditEvent{Type: peerstore.AuditKeyAdded, Peer: id},
+			peerstore.AuditEvent{Type: peerstore.AuditKeyAdded, Peer: id, Private: true},
+		)
+
+		require.Error(t, ps.AddPubKey(other, pub))
+		require.Error(t, ps.AddPrivKey(other, priv))
+		expect(
+			peerstore.AuditEvent{Type: peerstore.AuditKeyMismatch, Peer: other},
+			peerstore.AuditEvent{Type: peerstore.AuditKeyMismatch, Peer: other, Private: true},
+		)
+
+		cab, ok := ps.(pstore.CertifiedAddrBook)
+		if !ok {
+			return
+		}
+		seal := func(seq uint64, key crypto.PrivKey) *record.Envelope {
+			rec := peer.NewPeerRecord()
+			rec.PeerID = id
+			rec.Addrs = GenerateAddrs(1)
+			rec.Seq = seq
+			env, err := record.Seal(rec, key)
+			require.NoError(t, err)
+			return env
+		}
+
+		accepted, err := cab.ConsumePeerRecord(seal(2, priv), time.Hour)
+		require.NoError(t, err)
+		require.True(t, accepted)
+		accepted, err = cab.ConsumePeerRecord(seal(2, priv), time.Hour)
+		require.NoError(t, err)
+		require.True(t, accepted)
+		expect()
+
+		accepted, err = cab.ConsumePeerRecord(seal(1, priv), time.Hour)
+		require.NoError(t, err)
+		require.False(t, accepted)
+		expect(peerstore.AuditEvent{Type: peerstore.AuditRecordStale, Peer: id, Seq: 1, StoredSeq: 2})
+
+		otherPriv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+		require.NoError(t, err)
+		_, err = cab.ConsumePeerRecord(seal(3, otherPriv), time.Hour)
+		require.Error(t, err)
+		expect(peerstore.AuditEvent{Type: peerstore.AuditRecordKeyMismatch, Peer: id, Seq: 3})
+	}
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/stretchr/testify/require"
+)
+
+// testChangeFeed checks that a peerstore logs its writes and removals in a single sequence, keeping the latest
+// Config.ChangeLog changes, and that consumers can tell when they fell behind the log.
+func testChangeFeed(ps pstore.Peerstore, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		size := deps.Config.ChangeLog
+		cf, ok := ps.(peerstore.ChangeFeed)
+		if !ok {
+			t.Skip("peerstore does not implement ChangeFeed")
+		}
+
+		ids := GeneratePeerIDs(2)
+		addrs := GenerateAddrs(1)
+		require.Zero(t, cf.ChangeSeq())
+		require.Empty(t, cf.Changes(0))
+
+		ps.AddAddrs(ids[0], addrs, time.Hour)
+		require.NoError(t, ps.Put(ids[1], "key", "value"))
+		seq := cf.ChangeSeq()
+		changes := cf.Changes(0)
+		require.Len(t, changes, 2)
+		require.Equal(t, ids[0], changes[0].Peer)
+		require.Equal(t, ids[1], changes[1].Peer)
+		require.Equal(t, changes[0].Seq+1, changes[1].Seq)
+		require.Equal(t, seq, changes[1].Seq)
+		if gt, ok := ps.(peerstore.GenerationTracker); ok {
+			require.Equal(t, changes[0].Seq, gt.Generation(ids[0]))
+		}
+
+		// reads aren't changes.
+		ps.Addrs(ids[0])
+		ps.Get(ids[1], "key")
+		require.Equal(t, seq, cf.ChangeSeq())
+		require.Empty(t, cf.Changes(seq))
+
+		if rm, ok := ps.(peerstore.PeerRemover); ok {
+			rm.RemovePeer(ids[0])
+			changes = cf.Changes(seq)
+			require.NotEmpty(t, changes)
+			last := changes[len(changes)-1]
+			require.Equal(t, ids[0], last.Peer)
+			require.True(t, last.Removed)
+			seq = last.Seq
+		}
+
+		// the log keeps the latest changes only; a consumer behind them sees a gap after the sequence number it synced.
+		for i := 0; i < size+2; i++ {
+			require.NoError(t, ps.Put(ids[1], "key", i))
+		}
+		changes = cf.Changes(seq)
+		require.Len(t, changes, size)
+		require.NotEqual(t, seq+1, changes[0].Seq)
+		require.Equal(t, cf.ChangeSeq(), changes[size-1].Seq)
+		require.Len(t, cf.Changes(cf.ChangeSeq()-2), 2)
+	}
+}
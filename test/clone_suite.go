@@ -0,0 +1,107 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// TestClone checks that a peerstore implementing peerstore.Cloner produces an independent copy of itself.
+func TestClone(t *testing.T, factory PeerstoreFactory) {
+	ps, closeFunc := factory()
+	if closeFunc != nil {
+		defer closeFunc()
+	}
+	cloner, ok := ps.(peerstore.Cloner)
+	if !ok {
+		t.Skip("peerstore does not implement Cloner")
+	}
+	protector, _ := ps.(peerstore.PeerProtector)
+	groups, _ := ps.(peerstore.GroupBook)
+	names, _ := ps.(peerstore.NameBook)
+
+	_, pub, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := GenerateAddrs(3)
+
+	ps.AddAddrs(id, addrs[:2], time.Hour)
+	if err := ps.AddPubKey(id, pub); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddProtocols(id, "/test/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Put(id, "agent", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if protector != nil {
+		protector.Protect(id, "test")
+	}
+	if groups != nil {
+		if err := groups.AddToGroup(id, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names != nil {
+		if err := names.SetName(id, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clone, err := cloner.Clone(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+
+	AssertAddressesEqual(t, addrs[:2], clone.Addrs(id))
+	if !pub.Equals(clone.PubKey(id)) {
+		t.Error("expected the public key to be copied")
+	}
+	if protos, err := clone.GetProtocols(id); err != nil || len(protos) != 1 || protos[0] != "/test/1.0.0" {
+		t.Errorf("expected the protocols to be copied, got %v, %v", protos, err)
+	}
+	if v, err := clone.Get(id, "agent"); err != nil || v != "test" {
+		t.Errorf("expected the metadata to be copied, got %v, %v", v, err)
+	}
+	if protector != nil && !clone.(peerstore.PeerProtector).IsProtected(id, "test") {
+		t.Error("expected the protection tags to be copied")
+	}
+	if groups != nil {
+		if g := clone.(peerstore.GroupBook).Groups(id); len(g) != 1 || g[0] != "test" {
+			t.Errorf("expected the group memberships to be copied, got %v", g)
+		}
+	}
+	if names != nil {
+		if name := clone.(peerstore.NameBook).Name(id); name != "test" {
+			t.Errorf("expected the name to be copied, got %q", name)
+		}
+	}
+
+	// writes to either peerstore are not seen by the other.
+	clone.AddAddr(id, addrs[2], time.Hour)
+	ps.ClearAddrs(id)
+	AssertAddressesEqual(t, addrs, clone.Addrs(id))
+	if len(ps.Addrs(id)) != 0 {
+		t.Error("expected the addresses added to the clone not to be seen by the original")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if c, err := cloner.Clone(ctx); err == nil {
+		c.Close()
+		t.Error("expected cloning with a cancelled context to fail")
+	}
+}
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// MockClock is a manually advanced Clock.
+type MockClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+var _ peerstore.Clock = (*MockClock)(nil)
+
+// NewMockClock returns a MockClock set to the current system time.
+func NewMockClock() *MockClock {
+	return &MockClock{now: time.Now()}
+}
+
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Add advances the clock by d.
+func (c *MockClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Deps are the dependencies the suites inject into the implementation under test.
+type Deps struct {
+	// Clock is the source of time the implementation must use for address expiry; suites advance it instead of
+	// sleeping. It is nil when suites run with a plain factory, in which case they sleep in real time.
+	Clock *MockClock
+
+	// Datastore is a fresh, empty datastore the implementation may persist its data to.
+	Datastore ds.Batching
+
+	// Config is the configuration the implementation must be created with, set by the suite tests of options.
+	Config Config
+}
+
+// Config lists the options of address books and peerstores that suites exercise, for factories to map to the options
+// of their implementation. The zero value of every field is the default of the implementation.
+type Config struct {
+	TTLPolicy        peerstore.TTLPolicy
+	TTLJitter        float64
+	MaxAddrTTL       time.Duration
+	MaxAddrLen       int
+	AddrOrder        peerstore.AddrOrder
+	AddrAliases      bool
+	AddrHistory      int
+	ExoticAddrPolicy peerstore.ExoticAddrPolicy
+	P2PAddrPolicy    peerstore.P2PAddrPolicy
+
+	// Peerstore options.
+	IDValidator peerstore.IDValidator
+	AuditSink   peerstore.AuditSink
+	ChangeLog   int
+}
+
+func newDeps() *Deps {
+	return &Deps{
+		Clock:     NewMockClock(),
+		Datastore: dssync.MutexWrap(ds.NewMapDatastore()),
+	}
+}
+
+// realDeps returns dependencies for suites running in real time.
+func realDeps() *Deps {
+	return &Deps{}
+}
+
+// sleep lets d elapse, on the injected clock if there is one.
+func (d *Deps) sleep(dur time.Duration) {
+	if d.Clock == nil {
+		time.Sleep(dur)
+		return
+	}
+	d.Clock.Add(dur)
+}
+
+// now returns the current time, on the injected clock if there is one.
+func (d *Deps) now() time.Time {
+	if d.Clock == nil {
+		return time.Now()
+	}
+	return d.Clock.Now()
+}
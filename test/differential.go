@@ -0,0 +1,289 @@
+package test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// DifferentialOptions configure TestDifferential.
+type DifferentialOptions struct {
+	// Steps is the number of random operations to run. Defaults to 500.
+	Steps int
+	// Seed seeds the random operation sequence. Failures report it, so that they can be replayed. Defaults to the
+	// current time.
+	Seed int64
+	// Peers, Addrs and Protocols are the sizes of the pools operations draw from. Small pools make operations
+	// collide, which is where implementations tend to diverge. Default to 4, 8 and 4.
+	Peers, Addrs, Protocols int
+}
+
+// TestDifferential runs the same random sequence of operations against two peerstores, typically a reference
+// implementation and the one under test, and fails at the first step after which their observable state differs:
+// the peers with addresses, and the addresses (with their TTL and expiry when both peerstores expose them),
+// protocols and metadata of every peer.
+//
+// Both peerstores share the injected clock, which the sequence advances, and must source time from it. Expiries are
+// compared at a one-second resolution, as persistent implementations may store them as Unix timestamps: operations
+// happen half way through a second, and TTLs end three quarters through one, so that no address expires at a whole
+// second or on the step it's observed.
+func TestDifferential(t *testing.T, a, b PeerstoreDepsFactory, opts DifferentialOptions) {
+	if opts.Steps == 0 {
+		opts.Steps = 500
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	if opts.Peers == 0 {
+		opts.Peers = 4
+	}
+	if opts.Addrs == 0 {
+		opts.Addrs = 8
+	}
+	if opts.Protocols == 0 {
+		opts.Protocols = 4
+	}
+
+	depsA := newDeps()
+	now := depsA.Clock.Now()
+	depsA.Clock.Add(time.Unix(now.Unix()+1, int64(500*time.Millisecond)).Sub(now))
+	depsB := &Deps{Clock: depsA.Clock, Datastore: dssync.MutexWrap(ds.NewMapDatastore())}
+
+	psA, closeA := a(depsA)
+	if closeA != nil {
+		defer closeA()
+	}
+	psB, closeB := b(depsB)
+	if closeB != nil {
+		defer closeB()
+	}
+
+	d := &differential{
+		rng:    rand.New(rand.NewSource(opts.Seed)),
+		clock:  depsA.Clock,
+		ids:    GeneratePeerIDs(opts.Peers),
+		addrs:  GenerateAddrs(opts.Addrs),
+		protos: make([]string, opts.Protocols),
+	}
+	for i := range d.protos {
+		d.protos[i] = fmt.Sprintf("/proto/%d", i)
+	}
+
+	var history []string
+	for step := 0; step < opts.Steps; step++ {
+		op := d.next()
+		history = append(history, op.desc)
+		if op.apply != nil {
+			op.apply(psA)
+			op.apply(psB)
+		}
+		if op.once != nil {
+			op.once()
+		}
+
+		if diff := d.compare(psA, psB); diff != "" {
+			if len(history) > 20 {
+				history = history[len(history)-20:]
+			}
+			t.Fatalf("peerstores diverged at step %d (seed %d): %s\nlast operations:\n\t%s",
+				step, opts.Seed, diff, strings.Join(history, "\n\t"))
+		}
+	}
+}
+
+type differential struct {
+	rng    *rand.Rand
+	clock  *MockClock
+	ids    []peer.ID
+	addrs  []ma.Multiaddr
+	protos []string
+}
+
+// diffOp is an operation applied to both peerstores, or run once for both.
+type diffOp struct {
+	desc  string
+	apply func(ps pstore.Peerstore)
+	once  func()
+}
+
+// ttls are the TTLs operations draw from; they all end three quarters through a second, see TestDifferential.
+var diffTTLs = []time.Duration{
+	750 * time.Millisecond,
+	5*time.Second + 750*time.Millisecond,
+	30*time.Second + 750*time.Millisecond,
+	time.Hour + 750*time.Millisecond,
+}
+
+func (d *differential) peer() peer.ID {
+	return d.ids[d.rng.Intn(len(d.ids))]
+}
+
+func (d *differential) addrSubset() []ma.Multiaddr {
+	var res []ma.Multiaddr
+	for _, a := range d.addrs {
+		if d.rng.Intn(3) == 0 {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+func (d *differential) protoSubset() []string {
+	var res []string
+	for _, p := range d.protos {
+		if d.rng.Intn(2) == 0 {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func (d *differential) ttl() time.Duration {
+	return diffTTLs[d.rng.Intn(len(diffTTLs))]
+}
+
+// next draws the next operation.
+func (d *differential) next() diffOp {
+	p := d.peer()
+	switch d.rng.Intn(10) {
+	case 0, 1:
+		addrs, ttl := d.addrSubset(), d.ttl()
+		return diffOp{desc: fmt.Sprintf("AddAddrs(%s, %v, %s)", p, addrs, ttl), apply: func(ps pstore.Peerstore) {
+			ps.AddAddrs(p, addrs, ttl)
+		}}
+	case 2:
+		addrs, ttl := d.addrSubset(), d.ttl()
+		if d.rng.Intn(4) == 0 {
+			ttl = 0
+		}
+		return diffOp{desc: fmt.Sprintf("SetAddrs(%s, %v, %s)", p, addrs, ttl), apply: func(ps pstore.Peerstore) {
+			ps.SetAddrs(p, addrs, ttl)
+		}}
+	case 3:
+		oldTTL, newTTL := d.ttl(), d.ttl()
+		if d.rng.Intn(4) == 0 {
+			newTTL = 0
+		}
+		return diffOp{desc: fmt.Sprintf("UpdateAddrs(%s, %s, %s)", p, oldTTL, newTTL), apply: func(ps pstore.Peerstore) {
+			ps.UpdateAddrs(p, oldTTL, newTTL)
+		}}
+	case 4:
+		return diffOp{desc: fmt.Sprintf("ClearAddrs(%s)", p), apply: func(ps pstore.Peerstore) {
+			ps.ClearAddrs(p)
+		}}
+	case 5:
+		protos := d.protoSubset()
+		switch d.rng.Intn(3) {
+		case 0:
+			return diffOp{desc: fmt.Sprintf("SetProtocols(%s, %v)", p, protos), apply: func(ps pstore.Peerstore) {
+				_ = ps.SetProtocols(p, protos...)
+			}}
+		case 1:
+			return diffOp{desc: fmt.Sprintf("AddProtocols(%s, %v)", p, protos), apply: func(ps pstore.Peerstore) {
+				_ = ps.AddProtocols(p, protos...)
+			}}
+		default:
+			return diffOp{desc: fmt.Sprintf("RemoveProtocols(%s, %v)", p, protos), apply: func(ps pstore.Peerstore) {
+				_ = ps.RemoveProtocols(p, protos...)
+			}}
+		}
+	case 6:
+		key, val := fmt.Sprintf("key%d", d.rng.Intn(3)), fmt.Sprintf("value%d", d.rng.Intn(100))
+		return diffOp{desc: fmt.Sprintf("Put(%s, %s, %s)", p, key, val), apply: func(ps pstore.Peerstore) {
+			_ = ps.Put(p, key, val)
+		}}
+	case 7:
+		return diffOp{desc: fmt.Sprintf("RemovePeer(%s)", p), apply: func(ps pstore.Peerstore) {
+			if r, ok := ps.(peerstore.PeerRemover); ok {
+				r.RemovePeer(p)
+			}
+		}}
+	default:
+		dur := time.Duration(1+d.rng.Intn(40)) * time.Second
+		return diffOp{desc: fmt.Sprintf("advance clock by %s", dur), once: func() { d.clock.Add(dur) }}
+	}
+}
+
+// peerState is the observable state of a peer.
+type peerState struct {
+	Addrs     []string
+	Protocols []string
+	Metadata  map[string]interface{}
+}
+
+func (d *differential) observe(ps pstore.Peerstore, p peer.ID) peerState {
+	var st peerState
+	if eab, ok := ps.(peerstore.ExpiringAddrBook); ok {
+		for _, a := range eab.AddrsWithExpiry(p) {
+			st.Addrs = append(st.Addrs, fmt.Sprintf("%s ttl=%s expires=%d", a.Addr, a.TTL, a.Expires.Unix()))
+		}
+	} else {
+		for _, a := range ps.Addrs(p) {
+			st.Addrs = append(st.Addrs, a.String())
+		}
+	}
+	sort.Strings(st.Addrs)
+
+	st.Protocols, _ = ps.GetProtocols(p)
+	sort.Strings(st.Protocols)
+	if len(st.Protocols) == 0 {
+		st.Protocols = nil
+	}
+
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if v, err := ps.Get(p, key); err == nil {
+			if st.Metadata == nil {
+				st.Metadata = make(map[string]interface{})
+			}
+			st.Metadata[key] = v
+		}
+	}
+	return st
+}
+
+// compare returns a description of the first difference between the observable states of two peerstores, or an
+// empty string if there is none.
+func (d *differential) compare(a, b pstore.Peerstore) string {
+	expiring := func(ps pstore.Peerstore) bool {
+		_, ok := ps.(peerstore.ExpiringAddrBook)
+		return ok
+	}
+	if expiring(a) != expiring(b) {
+		// fall back to comparing addresses only.
+		a, b = struct{ pstore.Peerstore }{a}, struct{ pstore.Peerstore }{b}
+	}
+
+	for _, p := range d.ids {
+		if sa, sb := d.observe(a, p), d.observe(b, p); !reflect.DeepEqual(sa, sb) {
+			return fmt.Sprintf("state of peer %s differs:\n%+v\nvs\n%+v", p, sa, sb)
+		}
+	}
+
+	withAddrs := func(ps pstore.Peerstore) []string {
+		var res []string
+		for _, p := range ps.PeersWithAddrs() {
+			if len(ps.Addrs(p)) > 0 {
+				res = append(res, p.String())
+			}
+		}
+		sort.Strings(res)
+		return res
+	}
+	if pa, pb := withAddrs(a), withAddrs(b); !reflect.DeepEqual(pa, pb) {
+		return fmt.Sprintf("peers with live addresses differ: %v vs %v", pa, pb)
+	}
+	return ""
+}
@@ -0,0 +1,22 @@
+// Package test is a conformance kit for peerstore implementations.
+//
+// The suites exercise implementations purely through the go-libp2p-core peerstore interfaces, plus the optional
+// interfaces declared by this module, which are type-asserted and skipped when not implemented. They don't depend on
+// any implementation in this module, so third-party peerstores can run them as-is.
+//
+// Implementations that accept a clock and a datastore should be tested through the *WithDeps entry points, which
+// hand a Deps to the factory on every run: time-dependent tests then advance the injected clock instead of sleeping.
+// The plain entry points run the same tests in real time.
+//
+// Implementations of peerstore.InvariantChecker have their invariants checked after every test.
+//
+// TestDifferential runs a random sequence of operations against two implementations, and fails when their observable
+// state diverges; this module uses it to check pstoreds against pstoremem.
+//
+// The package is a module of its own, github.com/libp2p/go-libp2p-peerstore/test, tagged test/vX.Y.Z independently of
+// the peerstore module and following semantic versioning: within a major version, the entry points, Deps, Config and
+// the factory types keep their signatures, and suites only gain tests. A new test may still fail an implementation
+// that passed the previous version, when it covers behaviour the earlier suites left untested. Each tag requires the
+// version of the peerstore module whose interfaces its suites exercise; the replace directives of both go.mod files
+// only apply to development within this repository.
+package test
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/addr"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const testCertHash = "/certhash/uEiAGKYQy6AZrKeIiO8wjqpUEtWrlCPq_NDVQiGm5wxkOIg"
+
+var exoticAddrs = []string{
+	"/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:1234",
+	"/onion/timaq4ygg2iegci7:1234",
+	"/garlic32/566niximlxdzpanmn4qouucvua3k7neniwss47li5r6ugoertzuq",
+	"/garlic64/jT~IyXaoauTni6N4517EG8mrFUKpy0IlgZh-EY9csMAk82Odatmzr~YTZy8Hv7u~wvkg75EFNOyqb~nAPg-khyp2TS~ObUz8WlqYAM2VlEzJ7wJB91P-cUlKF18zSzVoJFmsrcQHZCirSbWoOknS6iNmsGRh5KVZsBEfp1Dg3gwTipTRIx7Vl5Vy~1OSKQVjYiGZS9q8RL0MF~7xFiKxZDLbPxk0AK9TzGGqm~wMTI2HS0Gm4Ycy8LYPVmLvGonIBYndg2bJC7WLuF6tVjVquiokSVDKFwq70BCUU5AU-EvdOD5KEOAM7mPfw-gJUG4tm1TtvcobrObqoRnmhXPTBTN5H7qDD12AvlwFGnfAlBXjuP4xOUAISL5SRLiulrsMSiT4GcugSI80mF6sdB0zWRgL1yyvoVWeTBn1TqjO27alr95DGTluuSqrNAxgpQzCKEWAyzrQkBfo2avGAmmz2NaHaAvYbOg0QSJz1PLjv2jdPW~ofiQmrGWM1cd~1cCqAAAA",
+	"/ip4/1.2.3.4/udp/443/quic-v1/webtransport" + testCertHash,
+	"/ip6/::1/udp/443/webrtc-direct" + testCertHash,
+	"/ip4/1.2.3.4/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit/webrtc",
+}
+
+// testExoticAddrsStored checks that an address book stores the addresses of Tor, I2P, WebTransport and WebRTC as they
+// are.
+func testExoticAddrsStored(ab pstore.AddrBook, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		id := GeneratePeerIDs(1)[0]
+		addrs := make([]ma.Multiaddr, 0, len(exoticAddrs))
+		for _, s := range exoticAddrs {
+			addrs = append(addrs, ma.StringCast(s))
+		}
+		ab.AddAddrs(id, addrs, time.Hour)
+		got := ab.Addrs(id)
+		AssertAddressesEqual(t, addrs, got)
+		stored := make(map[string]bool, len(got))
+		for _, a := range got {
+			stored[a.String()] = true
+		}
+		for _, s := range exoticAddrs {
+			if !stored[s] {
+				t.Fatalf("address %s was altered, got %v", s, got)
+			}
+		}
+	}
+}
+
+// testExoticCertHashTTL checks that an address book caps the TTL of the addresses carrying certificate hashes.
+func testExoticCertHashTTL(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		id := GeneratePeerIDs(1)[0]
+		onion, wt := ma.StringCast(exoticAddrs[0]), ma.StringCast(exoticAddrs[4])
+		ab.AddAddrs(id, []ma.Multiaddr{onion, wt}, pstore.PermanentAddrTTL)
+		deps.sleep(peerstore.DefaultCertHashAddrTTL + time.Hour)
+		AssertAddressesEqual(t, []ma.Multiaddr{onion}, ab.Addrs(id))
+
+		// connected addresses are capped too, and can still be updated by their uncapped TTL.
+		ab.AddAddr(id, wt, pstore.ConnectedAddrTTL)
+		ab.UpdateAddrs(id, pstore.ConnectedAddrTTL, pstore.RecentlyConnectedAddrTTL)
+		deps.sleep(pstore.RecentlyConnectedAddrTTL + time.Minute)
+		AssertAddressesEqual(t, []ma.Multiaddr{onion}, ab.Addrs(id))
+
+		ab.SetAddr(id, wt, pstore.PermanentAddrTTL)
+		ab.UpdateAddrs(id, pstore.PermanentAddrTTL, 0)
+		AssertAddressesEqual(t, nil, ab.Addrs(id))
+	}
+}
+
+// testExoticAddrsRejected checks that an address book created with an exotic address policy validating addresses and
+// dropping onion ones rejects those, whether added, set or consumed from signed peer records.
+func testExoticAddrsRejected(ab pstore.AddrBook, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		abe, ok := ab.(peerstore.AddrBookE)
+		if !ok {
+			t.Skip("address book does not report errors")
+		}
+
+		id := GeneratePeerIDs(1)[0]
+		wt := ma.StringCast(exoticAddrs[4])
+		malformed := ma.StringCast("/ip4/1.2.3.4/udp/443/webtransport")
+		onion := ma.StringCast(exoticAddrs[0])
+		err := abe.AddAddrsE(id, []ma.Multiaddr{wt, malformed, onion}, time.Hour)
+		if !errors.Is(err, addr.ErrMalformedExotic) {
+			t.Fatalf("expected the malformed address to be reported, got %v", err)
+		}
+		AssertAddressesEqual(t, []ma.Multiaddr{wt}, ab.Addrs(id))
+
+		if err := abe.SetAddrsE(id, []ma.Multiaddr{onion}, time.Hour); err != peerstore.ErrExoticAddrDropped {
+			t.Fatalf("expected the onion address to be dropped, got %v", err)
+		}
+		AssertAddressesEqual(t, []ma.Multiaddr{wt}, ab.Addrs(id))
+
+		// the addresses of signed peer records are subject to the policy too.
+		cab, ok := ab.(pstore.CertifiedAddrBook)
+		if !ok {
+			return
+		}
+		priv, recID := GenerateIdentity(t)
+		env := SealPeerRecord(t, priv, []ma.Multiaddr{wt, malformed, onion})
+		if accepted, err := cab.ConsumePeerRecord(env, time.Hour); !accepted || err != nil {
+			t.Fatalf("expected the record to be accepted, got %t (%v)", accepted, err)
+		}
+		AssertAddressesEqual(t, []ma.Multiaddr{wt}, ab.Addrs(recID))
+	}
+}
@@ -0,0 +1,18 @@
+module github.com/libp2p/go-libp2p-peerstore/test
+
+require (
+	github.com/ipfs/go-datastore v0.4.4
+	github.com/libp2p/go-libp2p-core v0.5.4
+	github.com/libp2p/go-libp2p-peerstore v0.2.4
+	github.com/multiformats/go-multiaddr v0.2.1
+	github.com/multiformats/go-multihash v0.0.13
+	github.com/stretchr/testify v1.4.0
+)
+
+// The peerstore module requires this one back for its own tests; both resolve to this repository during development.
+replace (
+	github.com/libp2p/go-libp2p-peerstore => ../
+	github.com/libp2p/go-libp2p-peerstore/test => ./
+)
+
+go 1.13
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// writeIDs writes addresses, protocols and metadata for p, returning the errors of each write.
+func writeIDs(ps pstore.Peerstore, p peer.ID, addrs []ma.Multiaddr) []error {
+	return []error{
+		ps.(peerstore.AddrBookE).AddAddrsE(p, addrs, time.Hour),
+		ps.AddProtocols(p, "/test/1.0.0"),
+		ps.Put(p, "key", "value"),
+	}
+}
+
+func hasPeer(ps pstore.Peerstore, p peer.ID) bool {
+	for _, id := range ps.Peers() {
+		if id == p {
+			return true
+		}
+	}
+	return false
+}
+
+// testIDValidatorRelaxed checks that a peerstore created with peerstore.RelaxedIDs accepts shortened peer IDs. Address
+// books apply the validator to every path, so that the addresses of an accepted ID can be set, updated, read and
+// cleared too. Peerstores must implement peerstore.AddrBookE.
+func testIDValidatorRelaxed(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		short := peer.ID("test-peer")
+		addrs := GenerateAddrs(1)
+		for _, err := range writeIDs(ps, short, addrs) {
+			if err != nil {
+				t.Fatalf("expected the shortened ID to be accepted, got %s", err)
+			}
+		}
+		AssertAddressesEqual(t, addrs, ps.Addrs(short))
+		if !hasPeer(ps, short) {
+			t.Error("expected the shortened ID to be listed")
+		}
+
+		other := GenerateAddrs(2)[1]
+		ps.SetAddr(short, other, time.Hour)
+		AssertAddressesEqual(t, []ma.Multiaddr{addrs[0], other}, ps.Addrs(short))
+		ps.UpdateAddrs(short, time.Hour, 0)
+		AssertAddressesEqual(t, nil, ps.Addrs(short))
+		ps.AddAddrs(short, addrs, time.Hour)
+		if err := ps.(peerstore.AddrBookE).ClearAddrsE(short); err != nil {
+			t.Fatalf("expected the addresses of the shortened ID to be cleared, got %s", err)
+		}
+		AssertAddressesEqual(t, nil, ps.Addrs(short))
+	}
+}
+
+// testIDValidatorStrict checks that a peerstore created with peerstore.StrictIDs rejects shortened peer IDs on all
+// write paths, and accepts valid ones. Peerstores must implement peerstore.AddrBookE.
+func testIDValidatorStrict(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		short := peer.ID("test-peer")
+		valid := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(1)
+		for i, err := range writeIDs(ps, short, addrs) {
+			if err == nil {
+				t.Errorf("expected write %d of the shortened ID to be rejected", i)
+			}
+		}
+		ps.SetAddr(short, addrs[0], time.Hour)
+		if len(ps.Addrs(short)) != 0 || hasPeer(ps, short) {
+			t.Error("expected nothing to be stored for the shortened ID")
+		}
+		if err := ps.(peerstore.AddrBookE).ClearAddrsE(short); err == nil {
+			t.Error("expected clearing the shortened ID to be rejected")
+		}
+		for _, err := range writeIDs(ps, valid, addrs) {
+			if err != nil {
+				t.Fatalf("expected a valid ID to be accepted, got %s", err)
+			}
+		}
+		if !hasPeer(ps, valid) {
+			t.Error("expected the valid ID to be listed")
+		}
+	}
+}
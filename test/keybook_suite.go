@@ -1,29 +1,43 @@
 package test
 
 import (
+	"reflect"
 	"sort"
 	"testing"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	pt "github.com/libp2p/go-libp2p-core/test"
 
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
 var keyBookSuite = map[string]func(kb pstore.KeyBook) func(*testing.T){
-	"AddGetPrivKey":         testKeybookPrivKey,
-	"AddGetPubKey":          testKeyBookPubKey,
-	"PeersWithKeys":         testKeyBookPeers,
-	"PubKeyAddedOnRetrieve": testInlinedPubKeyAddedOnRetrieve,
+	"AddGetPrivKey":     testKeybookPrivKey,
+	"AddGetPubKey":      testKeyBookPubKey,
+	"PeersWithKeys":     testKeyBookPeers,
+	"InlinedPubKey":     testInlinedPubKey,
+	"AddGetEd25519Keys": testKeyBookEd25519,
+	"KeyTypes":          testKeyBookKeyTypes,
+	"ExportImport":      testKeyBookExportImport,
 }
 
 type KeyBookFactory func() (pstore.KeyBook, func())
 
+// KeyBookDepsFactory creates a key book wired to the injected dependencies.
+type KeyBookDepsFactory func(deps *Deps) (pstore.KeyBook, func())
+
 func TestKeyBook(t *testing.T, factory KeyBookFactory) {
+	TestKeyBookWithDeps(t, func(*Deps) (pstore.KeyBook, func()) { return factory() })
+}
+
+// TestKeyBookWithDeps runs the key book suite against key books wired to fresh dependencies, created for each test.
+func TestKeyBookWithDeps(t *testing.T, factory KeyBookDepsFactory) {
 	for name, test := range keyBookSuite {
 		// Create a new peerstore.
-		kb, closeFunc := factory()
+		kb, closeFunc := factory(newDeps())
 
 		// Run the test.
 		t.Run(name, test(kb))
@@ -147,10 +161,8 @@ func testKeyBookPeers(kb pstore.KeyBook) func(t *testing.T) {
 	}
 }
 
-func testInlinedPubKeyAddedOnRetrieve(kb pstore.KeyBook) func(t *testing.T) {
+func testInlinedPubKey(kb pstore.KeyBook) func(t *testing.T) {
 	return func(t *testing.T) {
-		t.Skip("key inlining disabled for now: see libp2p/specs#111")
-
 		if peers := kb.PeersWithKeys(); len(peers) > 0 {
 			t.Error("expected peers to be empty on init")
 		}
@@ -158,18 +170,173 @@ func testInlinedPubKeyAddedOnRetrieve(kb pstore.KeyBook) func(t *testing.T) {
 		// Key small enough for inlining.
 		_, pub, err := ic.GenerateKeyPair(ic.Ed25519, 256)
 		if err != nil {
-			t.Error(err)
+			t.Fatal(err)
 		}
 
 		id, err := peer.IDFromPublicKey(pub)
 		if err != nil {
-			t.Error(err)
+			t.Fatal(err)
 		}
 
 		pubKey := kb.PubKey(id)
-		if !pubKey.Equals(pub) {
+		if !pub.Equals(pubKey) {
 			t.Error("mismatch between original public key and keybook-calculated one")
 		}
+
+		// inlined keys can be recovered from the peer ID at any time, so they are not stored on retrieval.
+		if peers := kb.PeersWithKeys(); len(peers) > 0 {
+			t.Error("expected key extracted from peer ID not to be stored")
+		}
+
+		// peer IDs hashed with sha256 carry no key.
+		_, rsaPub, err := pt.RandTestKeyPair(ic.RSA, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsaID, err := peer.IDFromPublicKey(rsaPub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := kb.PubKey(rsaID); res != nil {
+			t.Error("expected no public key for a hashed peer ID")
+		}
+	}
+}
+
+func testKeyBookEd25519(kb pstore.KeyBook) func(t *testing.T) {
+	return func(t *testing.T) {
+		if peers := kb.PeersWithKeys(); len(peers) > 0 {
+			t.Error("expected peers to be empty on init")
+		}
+
+		priv, pub, err := pt.RandTestKeyPair(ic.Ed25519, 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, err := peer.IDFromPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := kb.AddPubKey(id, pub); err != nil {
+			t.Fatal(err)
+		}
+		if err := kb.AddPrivKey(id, priv); err != nil {
+			t.Fatal(err)
+		}
+
+		if res := kb.PubKey(id); !pub.Equals(res) {
+			t.Error("retrieved public key did not match stored public key")
+		}
+		if res := kb.PrivKey(id); !priv.Equals(res) {
+			t.Error("retrieved private key did not match stored private key")
+		}
+
+		// explicitly added keys are tracked, even if they could be extracted from the peer ID.
+		if peers := kb.PeersWithKeys(); len(peers) != 1 || peers[0] != id {
+			t.Error("list of peers did not include test peer")
+		}
+
+		// keys that don't match the identity multihash are rejected.
+		otherPriv, otherPub, err := pt.RandTestKeyPair(ic.Ed25519, 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := kb.AddPubKey(id, otherPub); err == nil {
+			t.Error("expected mismatching public key to be rejected")
+		}
+		if err := kb.AddPrivKey(id, otherPriv); err == nil {
+			t.Error("expected mismatching private key to be rejected")
+		}
+		if res := kb.PubKey(id); !pub.Equals(res) {
+			t.Error("public key was overwritten by a mismatching key")
+		}
+	}
+}
+
+func testKeyBookKeyTypes(kb pstore.KeyBook) func(t *testing.T) {
+	return func(t *testing.T) {
+		if peers := kb.PeersWithKeys(); len(peers) > 0 {
+			t.Error("expected peers to be empty on init")
+		}
+
+		keyTypes := []struct {
+			name string
+			typ  int
+			bits int
+		}{
+			{"RSA-min", ic.RSA, ic.MinRsaKeyBits},
+			{"RSA-4096", ic.RSA, 4096},
+			{"Ed25519", ic.Ed25519, 256},
+			{"Secp256k1", ic.Secp256k1, 256},
+			{"ECDSA", ic.ECDSA, 256},
+		}
+
+		for _, kt := range keyTypes {
+			priv, pub, err := pt.RandTestKeyPair(kt.typ, kt.bits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := peer.IDFromPublicKey(pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := kb.AddPubKey(id, pub); err != nil {
+				t.Fatalf("%s: %s", kt.name, err)
+			}
+			if err := kb.AddPrivKey(id, priv); err != nil {
+				t.Fatalf("%s: %s", kt.name, err)
+			}
+			if res := kb.PubKey(id); !pub.Equals(res) {
+				t.Errorf("%s: retrieved public key did not match stored public key", kt.name)
+			}
+			if res := kb.PrivKey(id); !priv.Equals(res) {
+				t.Errorf("%s: retrieved private key did not match stored private key", kt.name)
+			}
+
+			if kbe, ok := kb.(peerstore.KeyBookE); ok {
+				if res, err := kbe.PubKeyE(id); err != nil || !pub.Equals(res) {
+					t.Errorf("%s: failed to retrieve public key: %v", kt.name, err)
+				}
+				if res, err := kbe.PrivKeyE(id); err != nil || !priv.Equals(res) {
+					t.Errorf("%s: failed to retrieve private key: %v", kt.name, err)
+				}
+			}
+		}
+
+		if peers := kb.PeersWithKeys(); len(peers) != len(keyTypes) {
+			t.Errorf("expected %d peers with keys, got %d", len(keyTypes), len(peers))
+		}
+
+		if kbe, ok := kb.(peerstore.KeyBookE); ok {
+			_, pub, err := pt.RandTestKeyPair(ic.RSA, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := peer.IDFromPublicKey(pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := kbe.PubKeyE(id); err != pstore.ErrNotFound {
+				t.Errorf("expected ErrNotFound for unknown public key, got %v", err)
+			}
+			if _, err := kbe.PrivKeyE(id); err != pstore.ErrNotFound {
+				t.Errorf("expected ErrNotFound for unknown private key, got %v", err)
+			}
+		}
+
+		if c, ok := kb.(peerstore.KeyTypeCounter); ok {
+			counts := c.KeyTypeCounts()
+			want := map[pb.KeyType]int{pb.KeyType_RSA: 2, pb.KeyType_Ed25519: 1, pb.KeyType_Secp256k1: 1, pb.KeyType_ECDSA: 1}
+			if !reflect.DeepEqual(counts.PubKeys, want) {
+				t.Errorf("unexpected public key counts: %v", counts.PubKeys)
+			}
+			if !reflect.DeepEqual(counts.PrivKeys, want) {
+				t.Errorf("unexpected private key counts: %v", counts.PrivKeys)
+			}
+		}
 	}
 }
 
@@ -313,3 +480,53 @@ func benchmarkPeersWithKeys(kb pstore.KeyBook) func(*testing.B) {
 		}
 	}
 }
+
+func testKeyBookExportImport(kb pstore.KeyBook) func(t *testing.T) {
+	return func(t *testing.T) {
+		ke, ok := kb.(peerstore.KeyExporter)
+		if !ok {
+			t.Skip("KeyExporter not implemented")
+		}
+
+		if _, err := ke.ExportPrivKey(GeneratePeerIDs(1)[0], peerstore.KeyFormatPEM, nil); err == nil {
+			t.Fatal("expected exporting a missing key to fail")
+		}
+
+		for _, typ := range []int{ic.Ed25519, ic.Secp256k1, ic.ECDSA} {
+			priv, _, err := pt.RandTestKeyPair(typ, 256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := peerstore.MarshalPrivKey(priv, peerstore.KeyFormatPEM, []byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// importing adds the key pair under the ID it derives.
+			id, err := ke.ImportPrivKey(data, peerstore.KeyFormatPEM, []byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !id.MatchesPrivateKey(priv) {
+				t.Fatalf("imported key under the wrong ID %s", id)
+			}
+			if !priv.Equals(kb.PrivKey(id)) || !priv.GetPublic().Equals(kb.PubKey(id)) {
+				t.Fatal("expected the imported key pair to be stored")
+			}
+
+			for _, format := range []peerstore.KeyFormat{peerstore.KeyFormatProtobuf, peerstore.KeyFormatPEM, peerstore.KeyFormatRaw} {
+				exported, err := ke.ExportPrivKey(id, format, nil)
+				if err != nil {
+					t.Fatalf("%s: %s", format, err)
+				}
+				sk, err := peerstore.UnmarshalPrivKey(exported, format, nil)
+				if err != nil {
+					t.Fatalf("%s: %s", format, err)
+				}
+				if !priv.Equals(sk) {
+					t.Fatalf("%s: exported key doesn't match the stored key", format)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// testMaxAddrTTL checks that an address book clamps the TTLs of the addresses added, set and updated to
+// Config.MaxAddrTTL, except for those that pin addresses, and that clamped addresses can still be updated.
+func testMaxAddrTTL(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		max := deps.Config.MaxAddrTTL
+		eab, ok := ab.(peerstore.ExpiringAddrBook)
+		if !ok {
+			t.Skip("address book does not expose address expiry")
+		}
+
+		ids := GeneratePeerIDs(2)
+		addrs := GenerateAddrs(3)
+		now := deps.now()
+
+		check := func(want ...time.Duration) {
+			t.Helper()
+			got := eab.AddrsWithExpiry(ids[0])
+			if len(got) != len(want) {
+				t.Fatalf("expected %d addresses, got %d", len(want), len(got))
+			}
+			for _, a := range got {
+				found := false
+				for _, w := range want {
+					found = found || a.TTL == w
+				}
+				if !found {
+					t.Fatalf("unexpected TTL %s, expected one of %v", a.TTL, want)
+				}
+				if a.TTL == max && !expiresWithin(a.Expires, now, now.Add(max)) {
+					t.Fatalf("expected a clamped expiry, got %s", a.Expires)
+				}
+			}
+		}
+
+		ab.AddAddr(ids[0], addrs[0], time.Hour)
+		ab.AddAddr(ids[0], addrs[1], time.Minute)
+		ab.AddAddr(ids[0], addrs[2], pstore.PermanentAddrTTL)
+		check(max, time.Minute, pstore.PermanentAddrTTL)
+
+		// clamped addresses are matched by the TTL they were added with.
+		ab.UpdateAddrs(ids[0], time.Hour, pstore.ConnectedAddrTTL)
+		check(pstore.ConnectedAddrTTL, time.Minute, pstore.PermanentAddrTTL)
+
+		ab.SetAddrs(ids[1], addrs, 24*time.Hour)
+		for _, a := range eab.AddrsWithExpiry(ids[1]) {
+			if a.TTL != max {
+				t.Fatalf("expected set addresses to be clamped to %s, got %s", max, a.TTL)
+			}
+		}
+		ab.UpdateAddrs(ids[1], pstore.ConnectedAddrTTL, time.Hour)
+		ab.UpdateAddrs(ids[1], max, 0)
+		if got := ab.Addrs(ids[1]); len(got) != 0 {
+			t.Fatalf("expected clamped addresses to be removable, got %v", got)
+		}
+	}
+}
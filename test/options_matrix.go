@@ -0,0 +1,214 @@
+package test
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// OpKind is the kind of a workload operation.
+type OpKind int
+
+const (
+	OpAddAddrs OpKind = iota
+	OpSetAddrs
+	OpUpdateAddrs
+	OpGetAddrs
+	OpClearAddrs
+	OpPeersWithAddrs
+)
+
+func (k OpKind) String() string {
+	switch k {
+	case OpAddAddrs:
+		return "add"
+	case OpSetAddrs:
+		return "set"
+	case OpUpdateAddrs:
+		return "update"
+	case OpGetAddrs:
+		return "get"
+	case OpClearAddrs:
+		return "clear"
+	case OpPeersWithAddrs:
+		return "peers"
+	default:
+		return "unknown"
+	}
+}
+
+// Op is a single operation of a workload trace.
+type Op struct {
+	Kind  OpKind
+	Peer  peer.ID
+	Addrs []ma.Multiaddr
+	TTL   time.Duration
+}
+
+// WorkloadTrace is a sequence of operations replayed against a peerstore.
+type WorkloadTrace []Op
+
+// WorkloadMix configures the synthetic workload generated by GenerateWorkload. Weights are relative; a zero weight
+// excludes the operation.
+type WorkloadMix struct {
+	Peers        int
+	AddrsPerPeer int
+	// TTL of the addresses written by the trace. Short TTLs give the GC something to purge.
+	TTL     time.Duration
+	Weights map[OpKind]int
+}
+
+// DefaultWorkloadMix is a read-heavy workload over 1000 peers, resembling a node that keeps dialing known peers.
+var DefaultWorkloadMix = WorkloadMix{
+	Peers:        1000,
+	AddrsPerPeer: 5,
+	TTL:          time.Hour,
+	Weights: map[OpKind]int{
+		OpAddAddrs:       20,
+		OpSetAddrs:       5,
+		OpUpdateAddrs:    5,
+		OpGetAddrs:       65,
+		OpClearAddrs:     4,
+		OpPeersWithAddrs: 1,
+	},
+}
+
+// GenerateWorkload generates a trace of n operations following the mix. The same seed yields the same operations,
+// up to peer IDs and addresses, which are random.
+func GenerateWorkload(b *testing.B, mix WorkloadMix, n int, seed int64) WorkloadTrace {
+	rng := rand.New(rand.NewSource(seed))
+
+	peers := make([]peer.ID, mix.Peers)
+	addrs := make([][]ma.Multiaddr, mix.Peers)
+	for i := range peers {
+		pp := RandomPeer(b, mix.AddrsPerPeer)
+		peers[i], addrs[i] = pp.ID, pp.Addr
+	}
+
+	kinds := make([]OpKind, 0, len(mix.Weights))
+	for k := range mix.Weights {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+
+	var total int
+	for _, k := range kinds {
+		total += mix.Weights[k]
+	}
+
+	trace := make(WorkloadTrace, n)
+	for i := range trace {
+		kind, r := kinds[len(kinds)-1], rng.Intn(total)
+		for _, k := range kinds {
+			if r < mix.Weights[k] {
+				kind = k
+				break
+			}
+			r -= mix.Weights[k]
+		}
+		idx := rng.Intn(mix.Peers)
+		trace[i] = Op{Kind: kind, Peer: peers[idx], Addrs: addrs[idx], TTL: mix.TTL}
+	}
+	return trace
+}
+
+// Apply runs the operation against the peerstore.
+func (op Op) Apply(ps pstore.Peerstore) {
+	switch op.Kind {
+	case OpAddAddrs:
+		ps.AddAddrs(op.Peer, op.Addrs, op.TTL)
+	case OpSetAddrs:
+		ps.SetAddrs(op.Peer, op.Addrs, op.TTL)
+	case OpUpdateAddrs:
+		ps.UpdateAddrs(op.Peer, op.TTL, op.TTL)
+	case OpGetAddrs:
+		_ = ps.Addrs(op.Peer)
+	case OpClearAddrs:
+		ps.ClearAddrs(op.Peer)
+	case OpPeersWithAddrs:
+		_ = ps.PeersWithAddrs()
+	}
+}
+
+// OptionsPoint is a point of the options grid explored by BenchmarkOptionsMatrix.
+type OptionsPoint struct {
+	CacheSize       int
+	GCPurgeInterval time.Duration
+}
+
+func (p OptionsPoint) String() string {
+	return fmt.Sprintf("Cache%d-GC%s", p.CacheSize, p.GCPurgeInterval)
+}
+
+// OptionsMatrix is the grid of options explored by BenchmarkOptionsMatrix: every cache size is combined with every
+// GC purge interval.
+type OptionsMatrix struct {
+	CacheSizes       []int
+	GCPurgeIntervals []time.Duration
+}
+
+// DefaultOptionsMatrix covers a cacheless store up to a large cache, with GC disabled, frequent and infrequent.
+var DefaultOptionsMatrix = OptionsMatrix{
+	CacheSizes:       []int{0, 1024, 16384},
+	GCPurgeIntervals: []time.Duration{0, time.Second, time.Minute},
+}
+
+// Points returns the points of the grid.
+func (m OptionsMatrix) Points() []OptionsPoint {
+	points := make([]OptionsPoint, 0, len(m.CacheSizes)*len(m.GCPurgeIntervals))
+	for _, size := range m.CacheSizes {
+		for _, interval := range m.GCPurgeIntervals {
+			points = append(points, OptionsPoint{CacheSize: size, GCPurgeInterval: interval})
+		}
+	}
+	return points
+}
+
+// OptionsPeerstoreFactory creates a peerstore configured as per the options point.
+type OptionsPeerstoreFactory func(OptionsPoint) (pstore.Peerstore, func())
+
+// BenchmarkOptionsMatrix replays the trace against a fresh peerstore for every point of the matrix, and reports the
+// throughput (ops/s) and the median and tail latencies of the operations (p50-ns, p99-ns).
+func BenchmarkOptionsMatrix(b *testing.B, factory OptionsPeerstoreFactory, matrix OptionsMatrix, trace WorkloadTrace) {
+	if len(trace) == 0 {
+		b.Fatal("empty workload trace")
+	}
+
+	for _, point := range matrix.Points() {
+		point := point
+		b.Run(point.String(), func(b *testing.B) {
+			ps, closeFunc := factory(point)
+			if closeFunc != nil {
+				defer closeFunc()
+			}
+
+			latencies := make([]time.Duration, b.N)
+
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				opStart := time.Now()
+				trace[i%len(trace)].Apply(ps)
+				latencies[i] = time.Since(opStart)
+			}
+			elapsed := time.Since(start)
+			b.StopTimer()
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(b.N)/elapsed.Seconds(), "ops/s")
+			b.ReportMetric(float64(percentile(latencies, 0.50).Nanoseconds()), "p50-ns")
+			b.ReportMetric(float64(percentile(latencies, 0.99).Nanoseconds()), "p99-ns")
+		})
+	}
+}
+
+// percentile returns the q-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
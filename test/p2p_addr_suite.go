@@ -0,0 +1,73 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// testP2PAddrPolicy checks that an address book keeps, strips or rejects the /p2p-suffixed addresses being added, set
+// or consumed from signed peer records according to Config.P2PAddrPolicy.
+func testP2PAddrPolicy(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		abe, ok := ab.(peerstore.AddrBookE)
+		if !ok {
+			t.Skip("address book does not report errors")
+		}
+
+		priv, id := GenerateIdentity(t)
+		other := GeneratePeerIDs(1)[0]
+
+		plain := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+		self := ma.StringCast("/ip4/1.2.3.4/tcp/2/p2p/" + id.Pretty())
+		foreign := ma.StringCast("/ip4/1.2.3.4/tcp/3/p2p/" + other.Pretty())
+		// the relay of a circuit address is another peer, but it isn't the last component.
+		circuit := ma.StringCast("/ip4/1.2.3.4/tcp/4/p2p/" + other.Pretty() + "/p2p-circuit")
+		addrs := []ma.Multiaddr{plain, self, foreign, circuit}
+
+		var want []ma.Multiaddr
+		var wantErr error
+		switch deps.Config.P2PAddrPolicy {
+		case peerstore.P2PAddrKeep:
+			want = addrs
+		case peerstore.P2PAddrStrip:
+			want, wantErr = []ma.Multiaddr{plain, ma.StringCast("/ip4/1.2.3.4/tcp/2"), circuit}, peerstore.ErrP2PAddrMismatch
+		case peerstore.P2PAddrReject:
+			want, wantErr = []ma.Multiaddr{plain, self, circuit}, peerstore.ErrP2PAddrMismatch
+		}
+
+		if err := abe.AddAddrsE(id, addrs, time.Hour); err != wantErr {
+			t.Fatalf("expected error %v when adding, got %v", wantErr, err)
+		}
+		AssertAddressesEqual(t, want, ab.Addrs(id))
+
+		ab.ClearAddrs(id)
+		if err := abe.SetAddrsE(id, addrs, time.Hour); err != wantErr {
+			t.Fatalf("expected error %v when setting, got %v", wantErr, err)
+		}
+		AssertAddressesEqual(t, want, ab.Addrs(id))
+
+		// addresses are removed in the form they were stored in.
+		if err := abe.SetAddrsE(id, addrs, 0); err != wantErr {
+			t.Fatalf("expected error %v when removing, got %v", wantErr, err)
+		}
+		AssertAddressesEqual(t, nil, ab.Addrs(id))
+		if len(ab.Addrs(other)) != 0 {
+			t.Fatal("expected no address to be stored for the other peer")
+		}
+
+		// the addresses of signed peer records are subject to the policy too.
+		cab, ok := ab.(pstore.CertifiedAddrBook)
+		if !ok {
+			return
+		}
+		if accepted, err := cab.ConsumePeerRecord(SealPeerRecord(t, priv, addrs), time.Hour); !accepted || err != nil {
+			t.Fatalf("expected the record to be accepted, got %t (%v)", accepted, err)
+		}
+		AssertAddressesEqual(t, want, ab.Addrs(id))
+	}
+}
@@ -11,40 +11,96 @@ import (
 
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
 	ma "github.com/multiformats/go-multiaddr"
 
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
 	"github.com/stretchr/testify/require"
 )
 
-var peerstoreSuite = map[string]func(pstore.Peerstore) func(*testing.T){
-	"AddrStream":               testAddrStream,
-	"GetStreamBeforePeerAdded": testGetStreamBeforePeerAdded,
-	"AddStreamDuplicates":      testAddrStreamDuplicates,
-	"PeerstoreProtoStore":      testPeerstoreProtoStore,
-	"BasicPeerstore":           testBasicPeerstore,
-	"Metadata":                 testMetadata,
-	"CertifiedAddrBook":        testCertifiedAddrBook,
+var peerstoreSuite = map[string]func(pstore.Peerstore, *Deps) func(*testing.T){
+	"AddrStream":                testAddrStream,
+	"GetStreamBeforePeerAdded":  testGetStreamBeforePeerAdded,
+	"AddStreamDuplicates":       testAddrStreamDuplicates,
+	"PeerstoreProtoStore":       testPeerstoreProtoStore,
+	"BasicPeerstore":            testBasicPeerstore,
+	"Metadata":                  testMetadata,
+	"MetadataGetMany":           testMetadataGetMany,
+	"MetadataWatch":             testMetadataWatch,
+	"CertifiedAddrBook":         testCertifiedAddrBook,
+	"InlinedKeyCertifiedRecord": testInlinedKeyCertifiedRecord,
+	"ProtectPeers":              testProtectPeers,
+	"RemovePeer":                testRemovePeer,
+	"PeerCollector":             testPeerCollector,
+	"PeerFilter":                testPeerFilter,
+	"PeerExistence":             testPeerExistence,
+	"PeersUnique":               testPeersUnique,
+	"FilterKnown":               testFilterKnown,
+	"PeerState":                 testPeerState,
+	"TransportLatency":          testTransportLatency,
+	"Groups":                    testGroups,
+	"Names":                     testNames,
+	"PeerLocks":                 testPeerLocks,
+	"Generations":               testGenerations,
+}
+
+// peerstoreConfigSuite holds the tests of peerstore options, each run against a peerstore created with the Config set
+// by configure. Only TestPeerstoreWithDeps runs them, as plain factories can't be configured.
+var peerstoreConfigSuite = map[string]struct {
+	configure func(*Config)
+	test      func(pstore.Peerstore, *Deps) func(*testing.T)
+}{
+	"AuditSink":          {func(c *Config) { c.AuditSink = &auditLog{} }, testAuditSink},
+	"ChangeFeed":         {func(c *Config) { c.ChangeLog = 4 }, testChangeFeed},
+	"IDValidatorRelaxed": {func(c *Config) { c.IDValidator = peerstore.RelaxedIDs }, testIDValidatorRelaxed},
+	"IDValidatorStrict":  {func(c *Config) { c.IDValidator = peerstore.StrictIDs }, testIDValidatorStrict},
 }
 
 type PeerstoreFactory func() (pstore.Peerstore, func())
 
+// PeerstoreDepsFactory creates a peerstore wired to the injected dependencies.
+type PeerstoreDepsFactory func(deps *Deps) (pstore.Peerstore, func())
+
+// TestPeerstore runs the peerstore suite in real time.
 func TestPeerstore(t *testing.T, factory PeerstoreFactory) {
+	runPeerstoreSuite(t, func(*Deps) (pstore.Peerstore, func()) { return factory() }, realDeps)
+}
+
+// TestPeerstoreWithDeps runs the peerstore suite, and the tests of peerstore options, against peerstores wired to
+// fresh dependencies, created for each test. Peerstores must source time from Deps.Clock, and be created with
+// Deps.Config.
+func TestPeerstoreWithDeps(t *testing.T, factory PeerstoreDepsFactory) {
+	runPeerstoreSuite(t, factory, newDeps)
+	for name, c := range peerstoreConfigSuite {
+		deps := newDeps()
+		c.configure(&deps.Config)
+		runPeerstoreTest(t, name, c.test, factory, deps)
+	}
+}
+
+func runPeerstoreSuite(t *testing.T, factory PeerstoreDepsFactory, mkDeps func() *Deps) {
 	for name, test := range peerstoreSuite {
-		// Create a new peerstore.
-		ps, closeFunc := factory()
+		runPeerstoreTest(t, name, test, factory, mkDeps())
+	}
+}
 
-		// Run the test.
-		t.Run(name, test(ps))
+func runPeerstoreTest(t *testing.T, name string, test func(pstore.Peerstore, *Deps) func(*testing.T),
+	factory PeerstoreDepsFactory, deps *Deps) {
+	// Create a new peerstore.
+	ps, closeFunc := factory(deps)
 
-		// Cleanup.
-		if closeFunc != nil {
-			closeFunc()
-		}
+	// Run the test.
+	t.Run(name, test(ps, deps))
+	CheckInvariants(t, name, ps)
+
+	// Cleanup.
+	if closeFunc != nil {
+		closeFunc()
 	}
 }
 
-func testAddrStream(ps pstore.Peerstore) func(t *testing.T) {
+func testAddrStream(ps pstore.Peerstore, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		addrs, pid := getAddrs(t, 100), peer.ID("testpeer")
 		ps.AddAddrs(pid, addrs[:10], time.Hour)
@@ -118,7 +174,7 @@ func testAddrStream(ps pstore.Peerstore) func(t *testing.T) {
 	}
 }
 
-func testGetStreamBeforePeerAdded(ps pstore.Peerstore) func(t *testing.T) {
+func testGetStreamBeforePeerAdded(ps pstore.Peerstore, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		addrs, pid := getAddrs(t, 10), peer.ID("testpeer")
 
@@ -167,7 +223,7 @@ func testGetStreamBeforePeerAdded(ps pstore.Peerstore) func(t *testing.T) {
 	}
 }
 
-func testAddrStreamDuplicates(ps pstore.Peerstore) func(t *testing.T) {
+func testAddrStreamDuplicates(ps pstore.Peerstore, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		addrs, pid := getAddrs(t, 10), peer.ID("testpeer")
 
@@ -205,7 +261,7 @@ func testAddrStreamDuplicates(ps pstore.Peerstore) func(t *testing.T) {
 	}
 }
 
-func testPeerstoreProtoStore(ps pstore.Peerstore) func(t *testing.T) {
+func testPeerstoreProtoStore(ps pstore.Peerstore, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		p1, protos := peer.ID("TESTPEER"), []string{"a", "b", "c", "d"}
 
@@ -318,7 +374,7 @@ func testPeerstoreProtoStore(ps pstore.Peerstore) func(t *testing.T) {
 	}
 }
 
-func testBasicPeerstore(ps pstore.Peerstore) func(t *testing.T) {
+func testBasicPeerstore(ps pstore.Peerstore, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		var pids []peer.ID
 		addrs := getAddrs(t, 10)
@@ -352,7 +408,7 @@ func testBasicPeerstore(ps pstore.Peerstore) func(t *testing.T) {
 	}
 }
 
-func testMetadata(ps pstore.Peerstore) func(t *testing.T) {
+func testMetadata(ps pstore.Peerstore, _ *Deps) func(t *testing.T) {
 	return func(t *testing.T) {
 		pids := make([]peer.ID, 10)
 		for i := range pids {
@@ -404,7 +460,75 @@ func testMetadata(ps pstore.Peerstore) func(t *testing.T) {
 	}
 }
 
-func testCertifiedAddrBook(ps pstore.Peerstore) func(*testing.T) {
+func testMetadataGetMany(ps pstore.Peerstore, _ *Deps) func(t *testing.T) {
+	return func(t *testing.T) {
+		if _, ok := ps.(peerstore.PeerMetadataBatch); !ok {
+			t.Skip("peerstore does not implement PeerMetadataBatch")
+		}
+
+		pids := GeneratePeerIDs(4)
+		for i, p := range pids[:3] {
+			require.NoError(t, ps.Put(p, "AgentVersion", fmt.Sprintf("agent/%d", i)))
+			require.NoError(t, ps.Put(p, "other", i))
+		}
+		// pids[2] isn't asked for, and pids[3] has no value.
+		res := peerstore.GetMany(ps, "AgentVersion", []peer.ID{pids[0], pids[1], pids[3], ""})
+		require.Equal(t, map[peer.ID]interface{}{
+			pids[0]: "agent/0",
+			pids[1]: "agent/1",
+		}, res)
+
+		require.Empty(t, peerstore.GetMany(ps, "missing", pids))
+		require.Empty(t, peerstore.GetMany(ps, "AgentVersion", nil))
+	}
+}
+
+func testMetadataWatch(ps pstore.Peerstore, _ *Deps) func(t *testing.T) {
+	return func(t *testing.T) {
+		mw, ok := ps.(peerstore.MetadataWatcher)
+		if !ok {
+			t.Skip("peerstore does not implement MetadataWatcher")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := mw.WatchMetadata(ctx, "AgentVersion")
+		next := func() peerstore.MetadataEvent {
+			t.Helper()
+			select {
+			case ev := <-events:
+				return ev
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for a metadata event")
+				return peerstore.MetadataEvent{}
+			}
+		}
+
+		pids := GeneratePeerIDs(2)
+		require.NoError(t, ps.Put(pids[0], "AgentVersion", "agent/1"))
+		require.Equal(t, peerstore.MetadataEvent{Peer: pids[0], Key: "AgentVersion", Value: "agent/1"}, next())
+
+		// unchanged values and other keys aren't reported.
+		require.NoError(t, ps.Put(pids[0], "AgentVersion", "agent/1"))
+		require.NoError(t, ps.Put(pids[0], "other", 1))
+		require.NoError(t, ps.Put(pids[1], "AgentVersion", "agent/2"))
+		require.Equal(t, peerstore.MetadataEvent{Peer: pids[1], Key: "AgentVersion", Value: "agent/2"}, next())
+
+		require.NoError(t, ps.Put(pids[0], "AgentVersion", "agent/2"))
+		require.Equal(t, peerstore.MetadataEvent{Peer: pids[0], Key: "AgentVersion", Value: "agent/2"}, next())
+
+		if rm, ok := ps.(peerstore.PeerRemover); ok {
+			rm.RemovePeer(pids[0])
+			require.Equal(t, peerstore.MetadataEvent{Peer: pids[0], Key: "AgentVersion", Removed: true}, next())
+		}
+
+		cancel()
+		for range events {
+		}
+	}
+}
+
+func testCertifiedAddrBook(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
 	return func(t *testing.T) {
 		_, ok := ps.(pstore.CertifiedAddrBook)
 		if !ok {
@@ -413,6 +537,195 @@ func testCertifiedAddrBook(ps pstore.Peerstore) func(*testing.T) {
 	}
 }
 
+// testInlinedKeyCertifiedRecord checks that the public key of a peer with an identity-hashed (Ed25519) ID is
+// consistently served from the key book once its certified record has been consumed, without being stored.
+func testInlinedKeyCertifiedRecord(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		cab, ok := ps.(pstore.CertifiedAddrBook)
+		if !ok {
+			t.Skip("peerstore does not implement CertifiedAddrBook")
+		}
+
+		priv, pub, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+		require.NoError(t, err)
+		id, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+
+		rec := peer.NewPeerRecord()
+		rec.PeerID = id
+		rec.Addrs = getAddrs(t, 3)
+		env, err := record.Seal(rec, priv)
+		require.NoError(t, err)
+
+		accepted, err := cab.ConsumePeerRecord(env, time.Hour)
+		require.NoError(t, err)
+		require.True(t, accepted, "expected signed peer record to be accepted")
+
+		got := cab.GetPeerRecord(id)
+		require.NotNil(t, got, "expected to retrieve the signed peer record")
+		require.True(t, got.PublicKey.Equals(pub), "envelope key does not match the peer key")
+		require.True(t, pub.Equals(ps.PubKey(id)), "key book did not return the inlined public key")
+		require.NotContains(t, ps.PeersWithKeys(), id, "expected inlined public key not to be stored")
+
+		// a record for this peer signed by any other key is rejected.
+		otherPriv, _, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano() + 1)))
+		require.NoError(t, err)
+		rec.Seq++
+		forged, err := record.Seal(rec, otherPriv)
+		require.NoError(t, err)
+		_, err = cab.ConsumePeerRecord(forged, time.Hour)
+		require.Error(t, err, "expected record signed by a foreign key to be rejected")
+		require.True(t, cab.GetPeerRecord(id).Equal(env), "forged record replaced the original one")
+	}
+}
+
+func testProtectPeers(ps pstore.Peerstore, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		pp, ok := ps.(peerstore.PeerProtector)
+		if !ok {
+			t.Skip("peerstore does not implement PeerProtector")
+		}
+
+		ids := GeneratePeerIDs(2)
+		addrs := getAddrs(t, 3)
+
+		pp.Protect(ids[0], "bootstrap")
+		pp.Protect(ids[0], "cluster")
+		require.True(t, pp.IsProtected(ids[0], ""))
+		require.True(t, pp.IsProtected(ids[0], "cluster"))
+		require.False(t, pp.IsProtected(ids[0], "billing"))
+		require.False(t, pp.IsProtected(ids[1], ""))
+		require.Equal(t, peer.IDSlice{ids[0]}, pp.ProtectedPeers())
+
+		// addresses of protected peers outlive their TTL; those of other peers don't.
+		for _, id := range ids {
+			ps.AddAddrs(id, addrs[:2], time.Second)
+		}
+		deps.sleep(2100 * time.Millisecond)
+		AssertAddressesEqual(t, addrs[:2], ps.Addrs(ids[0]))
+		AssertAddressesEqual(t, nil, ps.Addrs(ids[1]))
+
+		// explicit removals still apply.
+		ps.SetAddr(ids[0], addrs[0], -1)
+		AssertAddressesEqual(t, addrs[1:2], ps.Addrs(ids[0]))
+		ps.AddAddr(ids[0], addrs[2], time.Hour)
+		ps.UpdateAddrs(ids[0], time.Hour, 0)
+		AssertAddressesEqual(t, addrs[1:2], ps.Addrs(ids[0]))
+
+		// the peer stays protected until all tags are removed.
+		require.True(t, pp.Unprotect(ids[0], "bootstrap"))
+		require.False(t, pp.Unprotect(ids[0], "cluster"))
+		require.Empty(t, pp.ProtectedPeers())
+		AssertAddressesEqual(t, nil, ps.Addrs(ids[0]))
+	}
+}
+
+func testRemovePeer(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		rm, ok := ps.(peerstore.PeerRemover)
+		if !ok {
+			t.Skip("peerstore does not implement PeerRemover")
+		}
+
+		priv, pub, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+		require.NoError(t, err)
+		id, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+		other := GeneratePeerIDs(1)[0]
+
+		for _, p := range []peer.ID{id, other} {
+			ps.AddAddrs(p, getAddrs(t, 2), time.Hour)
+			require.NoError(t, ps.SetProtocols(p, "/a", "/b"))
+			require.NoError(t, ps.Put(p, "AgentVersion", "test"))
+			ps.RecordLatency(p, time.Millisecond)
+		}
+		require.NoError(t, ps.AddPubKey(id, pub))
+		require.NoError(t, ps.AddPrivKey(id, priv))
+
+		rm.RemovePeer(id)
+
+		require.Empty(t, ps.Addrs(id))
+		require.NotContains(t, ps.PeersWithKeys(), id)
+		protos, err := ps.GetProtocols(id)
+		require.NoError(t, err)
+		require.Empty(t, protos)
+		_, err = ps.Get(id, "AgentVersion")
+		require.Equal(t, pstore.ErrNotFound, err)
+		require.Zero(t, ps.LatencyEWMA(id))
+		require.NotContains(t, ps.Peers(), id)
+
+		// other peers are untouched.
+		require.Len(t, ps.Addrs(other), 2)
+		protos, err = ps.GetProtocols(other)
+		require.NoError(t, err)
+		require.Len(t, protos, 2)
+		v, err := ps.Get(other, "AgentVersion")
+		require.NoError(t, err)
+		require.Equal(t, "test", v)
+	}
+}
+
+func testPeerCollector(ps pstore.Peerstore, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		c, err := peerstore.NewPeerCollector(ps, nil, 0)
+		if err != nil {
+			t.Skip("peerstore does not implement PeerRemover")
+		}
+		defer c.Close()
+
+		priv, _, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+		require.NoError(t, err)
+		local, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		require.NoError(t, ps.AddPrivKey(local, priv))
+
+		// ids[0] has live addresses, the addresses of the others expire; ids[2] is protected.
+		ids := make([]peer.ID, 4)
+		for i := range ids {
+			_, pub, err := crypto.GenerateRSAKeyPair(crypto.MinRsaKeyBits, rand.New(rand.NewSource(time.Now().UnixNano()+int64(i))))
+			require.NoError(t, err)
+			ids[i], err = peer.IDFromPublicKey(pub)
+			require.NoError(t, err)
+			require.NoError(t, ps.AddPubKey(ids[i], pub))
+			require.NoError(t, ps.SetProtocols(ids[i], "/a"))
+		}
+		ps.AddAddrs(ids[0], getAddrs(t, 1), time.Hour)
+		for _, p := range ids[1:] {
+			ps.AddAddrs(p, getAddrs(t, 1), time.Second)
+		}
+		protected := false
+		if pp, ok := ps.(peerstore.PeerProtector); ok {
+			pp.Protect(ids[2], "test")
+			protected = true
+		}
+
+		deps.sleep(2100 * time.Millisecond)
+
+		// peers are only collected on the second sweep that finds them without addresses.
+		require.Zero(t, c.Collect())
+
+		// ids[3] regains an address in the meantime.
+		ps.AddAddrs(ids[3], getAddrs(t, 1), time.Hour)
+
+		removed := c.Collect()
+		if protected {
+			require.Equal(t, 1, removed)
+		} else {
+			require.Equal(t, 2, removed)
+		}
+		require.NotNil(t, ps.PubKey(ids[0]))
+		require.Nil(t, ps.PubKey(ids[1]))
+		require.NotNil(t, ps.PubKey(ids[3]))
+		require.NotNil(t, ps.PrivKey(local))
+		if protected {
+			require.NotNil(t, ps.PubKey(ids[2]))
+		}
+		protos, err := ps.GetProtocols(ids[1])
+		require.NoError(t, err)
+		require.Empty(t, protos)
+	}
+}
+
 func getAddrs(t *testing.T, n int) []ma.Multiaddr {
 	var addrs []ma.Multiaddr
 	for i := 0; i < n; i++ {
@@ -425,3 +738,437 @@ func getAddrs(t *testing.T, n int) []ma.Multiaddr {
 	}
 	return addrs
 }
+
+func testPeerFilter(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		// withAll has addresses, keys and protocols, the others one of them each, except for withAddrs which also
+		// supports another protocol.
+		newKey := func() (crypto.PrivKey, crypto.PubKey, peer.ID) {
+			priv, pub, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+			require.NoError(t, err)
+			id, err := peer.IDFromPublicKey(pub)
+			require.NoError(t, err)
+			return priv, pub, id
+		}
+		priv, pub, withAll := newKey()
+		_, keyPub, withKeys := newKey()
+		ids := GeneratePeerIDs(2)
+		withAddrs, withProto := ids[0], ids[1]
+
+		for _, p := range []peer.ID{withAll, withAddrs} {
+			ps.AddAddrs(p, getAddrs(t, 1), time.Hour)
+		}
+		require.NoError(t, ps.AddPrivKey(withAll, priv))
+		require.NoError(t, ps.AddPubKey(withAll, pub))
+		require.NoError(t, ps.AddPubKey(withKeys, keyPub))
+		for _, p := range []peer.ID{withAll, withProto} {
+			require.NoError(t, ps.SetProtocols(p, "/a"))
+		}
+		require.NoError(t, ps.SetProtocols(withAddrs, "/b"))
+
+		if pp, ok := ps.(peerstore.ProtocolPeers); ok {
+			require.ElementsMatch(t, peer.IDSlice{withAll, withProto}, pp.PeersWithProtocols("/a"))
+			require.ElementsMatch(t, peer.IDSlice{withAll, withProto, withAddrs}, pp.PeersWithProtocols("/a", "/b"))
+			require.Empty(t, pp.PeersWithProtocols("/c"))
+		}
+
+		require.ElementsMatch(t, peer.IDSlice{withAll, withAddrs}, peerstore.Peers(ps, peerstore.Filter{HasAddrs: true}))
+		require.ElementsMatch(t, peer.IDSlice{withAll, withKeys}, peerstore.Peers(ps, peerstore.Filter{HasKeys: true}))
+		require.ElementsMatch(t, peer.IDSlice{withAll}, peerstore.Peers(ps, peerstore.Filter{HasAddrs: true, HasKeys: true}))
+		require.ElementsMatch(t, peer.IDSlice{withAll}, peerstore.Peers(ps, peerstore.Filter{HasKeys: true, SupportsProto: "/a"}))
+		require.ElementsMatch(t, peer.IDSlice{withAddrs}, peerstore.Peers(ps, peerstore.Filter{HasAddrs: true, SupportsProto: "/b"}))
+		require.Empty(t, peerstore.Peers(ps, peerstore.Filter{HasKeys: true, SupportsProto: "/b"}))
+		require.ElementsMatch(t, ps.Peers(), peerstore.Peers(ps, peerstore.Filter{}))
+	}
+}
+
+func testPeerExistence(ps pstore.Peerstore, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		pe, ok := ps.(peerstore.PeerExistence)
+		if !ok {
+			t.Skip("peerstore does not implement PeerExistence")
+		}
+
+		_, pub, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+		require.NoError(t, err)
+		withKey, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+		ids := GeneratePeerIDs(4)
+		withAddrs, withProto, withMeta, unknown := ids[0], ids[1], ids[2], ids[3]
+
+		ps.AddAddrs(withAddrs, getAddrs(t, 1), time.Hour)
+		require.NoError(t, ps.AddPubKey(withKey, pub))
+		require.NoError(t, ps.SetProtocols(withProto, "/a"))
+		require.NoError(t, ps.Put(withMeta, "AgentVersion", "test"))
+
+		require.True(t, pe.HasAddrs(withAddrs))
+		require.False(t, pe.HasAddrs(withKey))
+		require.True(t, pe.HasKey(withKey))
+		require.False(t, pe.HasKey(withAddrs))
+		for _, p := range []peer.ID{withAddrs, withKey, withProto, withMeta} {
+			require.True(t, pe.Known(p), "expected peer %s to be known", p)
+		}
+		require.False(t, pe.Known(unknown))
+
+		// expired addresses don't count; only check with an injected clock, to avoid sleeping.
+		if deps.Clock != nil {
+			deps.sleep(2 * time.Hour)
+			require.False(t, pe.HasAddrs(withAddrs))
+		}
+	}
+}
+
+func testFilterKnown(ps pstore.Peerstore, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		kf, ok := ps.(peerstore.KnownFilter)
+		if !ok {
+			t.Skip("peerstore does not implement KnownFilter")
+		}
+
+		_, pub, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+		require.NoError(t, err)
+		withKey, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+		ids := GeneratePeerIDs(3)
+		withAddrs, expiring, unknown := ids[0], ids[1], ids[2]
+
+		ps.AddAddrs(withAddrs, getAddrs(t, 2), time.Hour)
+		ps.AddAddrs(expiring, getAddrs(t, 1), time.Minute)
+		require.NoError(t, ps.AddPubKey(withKey, pub))
+
+		known, others := kf.FilterKnown([]peer.ID{unknown, expiring, "", withKey, withAddrs})
+		require.Equal(t, []peer.ID{expiring, withAddrs}, known)
+		require.Equal(t, []peer.ID{unknown, "", withKey}, others)
+
+		// expired addresses don't count; only check with an injected clock, to avoid sleeping.
+		if deps.Clock != nil {
+			deps.sleep(2 * time.Minute)
+			known, _ = kf.FilterKnown([]peer.ID{expiring, withAddrs})
+			require.Equal(t, []peer.ID{withAddrs}, known)
+		}
+
+		known, others = kf.FilterKnown(nil)
+		require.Empty(t, known)
+		require.Empty(t, others)
+	}
+}
+
+// testPeersUnique checks that peers held by several books, or by several entries of a book, are listed once. The order
+// of the listings isn't defined, so they are compared as sets.
+func testPeersUnique(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		priv, pub, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+		require.NoError(t, err)
+		everywhere, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+		ids := GeneratePeerIDs(2)
+		addrsOnly, protosOnly := ids[0], ids[1]
+
+		addrs := getAddrs(t, 5)
+		ps.AddAddrs(everywhere, addrs, time.Hour)
+		ps.AddAddrs(everywhere, addrs[:2], pstore.PermanentAddrTTL)
+		require.NoError(t, ps.AddPubKey(everywhere, pub))
+		require.NoError(t, ps.AddPrivKey(everywhere, priv))
+		require.NoError(t, ps.SetProtocols(everywhere, "/a", "/b"))
+		require.NoError(t, ps.Put(everywhere, "AgentVersion", "test"))
+		ps.AddAddrs(addrsOnly, addrs[2:], time.Hour)
+		require.NoError(t, ps.SetProtocols(protosOnly, "/a"))
+
+		// listings are stable across calls, whatever their order.
+		for i := 0; i < 3; i++ {
+			all := requireUniquePeers(t, ps.Peers())
+			require.Contains(t, all, everywhere)
+			require.Contains(t, all, addrsOnly)
+			require.Equal(t, map[peer.ID]int{everywhere: 1, addrsOnly: 1}, requireUniquePeers(t, ps.PeersWithAddrs()))
+			require.Equal(t, map[peer.ID]int{everywhere: 1}, requireUniquePeers(t, ps.PeersWithKeys()))
+		}
+
+		// removing the data of a book leaves the peer listed once.
+		ps.ClearAddrs(everywhere)
+		require.Equal(t, map[peer.ID]int{addrsOnly: 1}, requireUniquePeers(t, ps.PeersWithAddrs()))
+		require.Contains(t, requireUniquePeers(t, ps.Peers()), everywhere)
+	}
+}
+
+// requireUniquePeers fails if ids holds a peer more than once, and returns the set of the peers it holds.
+func requireUniquePeers(t *testing.T, ids peer.IDSlice) map[peer.ID]int {
+	t.Helper()
+	set := make(map[peer.ID]int, len(ids))
+	for _, p := range ids {
+		set[p]++
+		if set[p] > 1 {
+			t.Fatalf("peer %s listed more than once in %v", p, ids)
+		}
+	}
+	return set
+}
+
+func testPeerState(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		sr, ok := ps.(peerstore.PeerStateReader)
+		if !ok {
+			t.Skip("peerstore does not implement PeerStateReader")
+		}
+
+		priv, pub, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+		require.NoError(t, err)
+		id, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+
+		state := sr.GetPeerState(id)
+		require.Equal(t, id, state.ID)
+		require.Empty(t, state.Addrs)
+		require.Empty(t, state.Protocols)
+		require.False(t, state.HasPubKey || state.HasPrivKey)
+		require.Empty(t, state.MetadataKeys)
+
+		addrs := getAddrs(t, 2)
+		ps.AddAddr(id, addrs[0], time.Hour)
+		ps.AddAddr(id, addrs[1], 2*time.Hour)
+		require.NoError(t, ps.AddPrivKey(id, priv))
+		require.NoError(t, ps.SetProtocols(id, "/b", "/a"))
+		require.NoError(t, ps.Put(id, "ProtocolVersion", "test"))
+		require.NoError(t, ps.Put(id, "AgentVersion", "test"))
+		ps.RecordLatency(id, time.Millisecond)
+
+		state = sr.GetPeerState(id)
+		require.Len(t, state.Addrs, 2)
+		ttls := map[string]time.Duration{}
+		for _, a := range state.Addrs {
+			ttls[a.Addr.String()] = a.TTL
+		}
+		require.Equal(t, map[string]time.Duration{addrs[0].String(): time.Hour, addrs[1].String(): 2 * time.Hour}, ttls)
+		require.Equal(t, []string{"/a", "/b"}, state.Protocols)
+		require.False(t, state.HasPubKey)
+		require.True(t, state.HasPrivKey)
+		require.Equal(t, ps.LatencyEWMA(id), state.Latency)
+		require.Equal(t, []string{"AgentVersion", "ProtocolVersion"}, state.MetadataKeys)
+	}
+}
+
+func testTransportLatency(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		tl, ok := ps.(peerstore.TransportLatencies)
+		if !ok {
+			t.Skip("peerstore does not implement TransportLatencies")
+		}
+
+		_, pub, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+		require.NoError(t, err)
+		id, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+
+		tcp := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+		quic := ma.StringCast("/ip4/1.2.3.4/udp/4001/quic")
+		tl.RecordLatencyVia(id, tcp, 50*time.Millisecond)
+		tl.RecordLatencyVia(id, quic, 10*time.Millisecond)
+
+		stats := tl.TransportLatencyStats(id)
+		require.Len(t, stats, 2)
+		require.Equal(t, 50*time.Millisecond, stats["tcp"].Mean)
+		require.Equal(t, 10*time.Millisecond, stats["udp/quic"].Mean)
+		require.NotZero(t, ps.LatencyEWMA(id))
+
+		addrs := []ma.Multiaddr{tcp, quic}
+		peerstore.RankAddrsByLatency(addrs, stats)
+		require.Equal(t, []ma.Multiaddr{quic, tcp}, addrs)
+
+		if rm, ok := ps.(peerstore.PeerRemover); ok {
+			rm.RemovePeer(id)
+			require.Empty(t, tl.TransportLatencyStats(id))
+		}
+	}
+}
+
+func testGroups(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		gb, ok := ps.(peerstore.GroupBook)
+		if !ok {
+			t.Skip("peerstore does not implement GroupBook")
+		}
+
+		ids := GeneratePeerIDs(3)
+		require.NoError(t, gb.AddToGroup(ids[0], "cluster-a"))
+		require.NoError(t, gb.AddToGroup(ids[0], "cluster-b"))
+		require.NoError(t, gb.AddToGroup(ids[1], "cluster-a"))
+		require.NoError(t, gb.AddToGroup(ids[1], "cluster-a"))
+		require.Equal(t, peerstore.ErrEmptyGroup, gb.AddToGroup(ids[2], ""))
+
+		require.ElementsMatch(t, peer.IDSlice{ids[0], ids[1]}, gb.GroupMembers("cluster-a"))
+		require.ElementsMatch(t, peer.IDSlice{ids[0]}, gb.GroupMembers("cluster-b"))
+		require.Empty(t, gb.GroupMembers("cluster"))
+		require.Equal(t, []string{"cluster-a", "cluster-b"}, gb.Groups(ids[0]))
+		require.Empty(t, gb.Groups(ids[2]))
+
+		// groups combine with the other criteria of filters.
+		ps.AddAddrs(ids[1], getAddrs(t, 1), time.Hour)
+		require.ElementsMatch(t, peer.IDSlice{ids[1]}, peerstore.Peers(ps, peerstore.Filter{Group: "cluster-a", HasAddrs: true}))
+
+		// memberships outlive the removal of the peer.
+		if rm, ok := ps.(peerstore.PeerRemover); ok {
+			rm.RemovePeer(ids[1])
+			require.ElementsMatch(t, peer.IDSlice{ids[0], ids[1]}, gb.GroupMembers("cluster-a"))
+		}
+
+		require.NoError(t, gb.RemoveFromGroup(ids[0], "cluster-a"))
+		require.NoError(t, gb.RemoveFromGroup(ids[2], "cluster-a"))
+		require.ElementsMatch(t, peer.IDSlice{ids[1]}, gb.GroupMembers("cluster-a"))
+		require.Equal(t, []string{"cluster-b"}, gb.Groups(ids[0]))
+	}
+}
+
+func testNames(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		nb, ok := ps.(peerstore.NameBook)
+		if !ok {
+			t.Skip("peerstore does not implement NameBook")
+		}
+
+		ids := GeneratePeerIDs(3)
+		require.NoError(t, nb.SetName(ids[0], "bootstrap-eu-1"))
+		require.NoError(t, nb.SetName(ids[1], "bootstrap-us-1"))
+		require.NoError(t, nb.SetName(ids[1], "bootstrap-us-1"))
+		require.Equal(t, peerstore.ErrNameTaken, nb.SetName(ids[2], "bootstrap-eu-1"))
+
+		require.Equal(t, "bootstrap-eu-1", nb.Name(ids[0]))
+		require.Empty(t, nb.Name(ids[2]))
+		p, ok := nb.PeerByName("bootstrap-us-1")
+		require.True(t, ok)
+		require.Equal(t, ids[1], p)
+		_, ok = nb.PeerByName("bootstrap")
+		require.False(t, ok)
+		require.Equal(t, map[peer.ID]string{ids[0]: "bootstrap-eu-1", ids[1]: "bootstrap-us-1"}, nb.Names())
+
+		require.Equal(t, "bootstrap-eu-1 ("+ids[0].ShortString()+")", peerstore.DisplayName(ps, ids[0]))
+		require.Equal(t, ids[2].Pretty(), peerstore.DisplayName(ps, ids[2]))
+		if sr, ok := ps.(peerstore.PeerStateReader); ok {
+			require.Equal(t, "bootstrap-eu-1", sr.GetPeerState(ids[0]).Name)
+		}
+
+		// renaming a peer frees its previous name.
+		require.NoError(t, nb.SetName(ids[0], "bootstrap-eu-2"))
+		require.NoError(t, nb.SetName(ids[2], "bootstrap-eu-1"))
+		p, _ = nb.PeerByName("bootstrap-eu-2")
+		require.Equal(t, ids[0], p)
+
+		// names outlive the removal of the peer.
+		if rm, ok := ps.(peerstore.PeerRemover); ok {
+			rm.RemovePeer(ids[1])
+			require.Equal(t, "bootstrap-us-1", nb.Name(ids[1]))
+		}
+
+		require.NoError(t, nb.SetName(ids[1], ""))
+		require.Empty(t, nb.Name(ids[1]))
+		_, ok = nb.PeerByName("bootstrap-us-1")
+		require.False(t, ok)
+		require.Len(t, nb.Names(), 2)
+	}
+}
+
+func testPeerLocks(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		pl, ok := ps.(peerstore.PeerLocker)
+		if !ok {
+			t.Skip("peerstore does not implement PeerLocker")
+		}
+
+		id := GeneratePeerIDs(1)[0]
+		require.NoError(t, ps.Put(id, "counter", 0))
+
+		// the read-modify-write sequences of concurrent holders of the lock don't interleave, and the books remain
+		// usable within them.
+		const workers, rounds = 8, 20
+		errs := make(chan error, workers)
+		for i := 0; i < workers; i++ {
+			go func() {
+				var err error
+				for j := 0; j < rounds && err == nil; j++ {
+					err = pl.WithPeerLocked(id, func() error {
+						v, err := ps.Get(id, "counter")
+						if err != nil {
+							return err
+						}
+						return ps.Put(id, "counter", v.(int)+1)
+					})
+				}
+				errs <- err
+			}()
+		}
+		for i := 0; i < workers; i++ {
+			require.NoError(t, <-errs)
+		}
+		v, err := ps.Get(id, "counter")
+		require.NoError(t, err)
+		require.Equal(t, workers*rounds, v)
+
+		// the locks of distinct peers are independent.
+		other := GeneratePeerIDs(1)[0]
+		pl.LockPeer(id)
+		require.NoError(t, pl.WithPeerLocked(other, func() error { return nil }))
+		pl.UnlockPeer(id)
+
+		errStop := fmt.Errorf("stop")
+		require.Equal(t, errStop, pl.WithPeerLocked(id, func() error { return errStop }))
+	}
+}
+
+func testGenerations(ps pstore.Peerstore, _ *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		gt, ok := ps.(peerstore.GenerationTracker)
+		if !ok {
+			t.Skip("peerstore does not implement GenerationTracker")
+		}
+
+		priv, pub, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(time.Now().UnixNano())))
+		require.NoError(t, err)
+		id, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+		other := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(2)
+		// peers without data share a generation, which removals change.
+		ps.AddAddrs(other, addrs, time.Hour)
+
+		seen := map[uint64]bool{gt.Generation(id): true}
+		// changed asserts that fn moves the peer to a generation it never had, and leaves the other peer alone.
+		changed := func(what string, fn func()) {
+			before := gt.Generation(other)
+			fn()
+			gen := gt.Generation(id)
+			require.False(t, seen[gen], "generation didn't change on %s", what)
+			seen[gen] = true
+			require.Equal(t, before, gt.Generation(other), "generation of another peer changed on %s", what)
+		}
+
+		changed("AddAddrs", func() { ps.AddAddrs(id, addrs, time.Hour) })
+		changed("SetAddr", func() { ps.SetAddr(id, addrs[0], 0) })
+		changed("UpdateAddrs", func() { ps.UpdateAddrs(id, time.Hour, 2*time.Hour) })
+		changed("AddPubKey", func() { require.NoError(t, ps.AddPubKey(id, pub)) })
+		changed("AddPrivKey", func() { require.NoError(t, ps.AddPrivKey(id, priv)) })
+		changed("AddProtocols", func() { require.NoError(t, ps.AddProtocols(id, "/a")) })
+		changed("RemoveProtocols", func() { require.NoError(t, ps.RemoveProtocols(id, "/a")) })
+		changed("Put", func() { require.NoError(t, ps.Put(id, "key", "value")) })
+		if gb, ok := ps.(peerstore.GroupBook); ok {
+			changed("AddToGroup", func() { require.NoError(t, gb.AddToGroup(id, "group")) })
+		}
+		if nb, ok := ps.(peerstore.NameBook); ok {
+			changed("SetName", func() { require.NoError(t, nb.SetName(id, "name")) })
+		}
+
+		// reads leave the generation alone.
+		gen := gt.Generation(id)
+		ps.Addrs(id)
+		ps.PubKey(id)
+		ps.PrivKey(id)
+		ps.GetProtocols(id)
+		ps.Get(id, "key")
+		ps.PeersWithAddrs()
+		require.Equal(t, gen, gt.Generation(id))
+
+		changed("ClearAddrs", func() { ps.ClearAddrs(id) })
+		if rm, ok := ps.(peerstore.PeerRemover); ok {
+			changed("RemovePeer", func() { rm.RemovePeer(id) })
+			// a removed peer added again doesn't go back to any of its former generations.
+			changed("AddAddrs after RemovePeer", func() { ps.AddAddrs(id, addrs, time.Hour) })
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// syntheticTTLs are the address TTLs of synthetic peers, weighted roughly like those of a public DHT node: most
+// addresses come from the DHT and identify, some from connections, few are permanent.
+var syntheticTTLs = []struct {
+	ttl    time.Duration
+	weight int
+}{
+	{pstore.TempAddrTTL, 10},
+	{pstore.ProviderAddrTTL, 15},
+	{pstore.AddressTTL, 40},
+	{pstore.RecentlyConnectedAddrTTL, 20},
+	{pstore.ConnectedAddrTTL, 10},
+	{pstore.PermanentAddrTTL, 5},
+}
+
+// syntheticKeyTypes are the key types of synthetic peers with keys, weighted like those of a public network. RSA is
+// left out, as generating enough RSA keys for a load test takes minutes.
+var syntheticKeyTypes = []struct {
+	typ    int
+	weight int
+}{
+	{ic.Ed25519, 60},
+	{ic.Secp256k1, 25},
+	{ic.ECDSA, 15},
+}
+
+var syntheticProtocols = []string{
+	"/ipfs/id/1.0.0",
+	"/ipfs/id/push/1.0.0",
+	"/ipfs/ping/1.0.0",
+	"/ipfs/kad/1.0.0",
+	"/ipfs/bitswap/1.2.0",
+	"/libp2p/circuit/relay/0.1.0",
+	"/libp2p/autonat/1.0.0",
+	"/meshsub/1.1.0",
+}
+
+var syntheticAgents = []string{
+	"go-ipfs/0.5.1/",
+	"go-ipfs/0.4.23/",
+	"js-libp2p/0.28.0",
+	"rust-libp2p/0.19.0",
+	"hydra-booster/0.7.0",
+}
+
+// GeneratePeerstore populates ps with n synthetic peers with addrsPerPeer addresses each, for load tests of
+// peerstore configurations. Peers have a realistic mix of transports (TCP, QUIC, websockets, IPv6, DNS and relayed
+// addresses), address TTLs, protocols, agent versions and latencies. A keyRatio share of them have a public key, of
+// varied types, that their ID derives from; the others have IDs only.
+//
+// The dataset is the same on every call with the same arguments, so that configurations are compared on equal
+// footing. It returns the IDs of the generated peers.
+func GeneratePeerstore(ps pstore.Peerstore, n, addrsPerPeer int, keyRatio float64) ([]peer.ID, error) {
+	rng := rand.New(rand.NewSource(int64(n)<<32 | int64(addrsPerPeer)))
+
+	ids := make([]peer.ID, 0, n)
+	for i := 0; i < n; i++ {
+		var (
+			id  peer.ID
+			pk  ic.PubKey
+			err error
+		)
+		if rng.Float64() < keyRatio {
+			pk, err = syntheticKey(rng)
+			if err == nil {
+				id, err = peer.IDFromPublicKey(pk)
+			}
+		} else {
+			id, err = syntheticID(rng)
+		}
+		if err != nil {
+			return ids, err
+		}
+
+		if pk != nil {
+			if err := ps.AddPubKey(id, pk); err != nil {
+				return ids, err
+			}
+		}
+
+		addrs := make([]ma.Multiaddr, 0, addrsPerPeer)
+		for j := 0; j < addrsPerPeer; j++ {
+			a, err := syntheticAddr(rng, ids)
+			if err != nil {
+				return ids, err
+			}
+			addrs = append(addrs, a)
+		}
+		ps.AddAddrs(id, addrs, syntheticTTL(rng))
+
+		protos := make([]string, 0, len(syntheticProtocols))
+		for _, proto := range syntheticProtocols {
+			if rng.Intn(2) == 0 {
+				protos = append(protos, proto)
+			}
+		}
+		if len(protos) > 0 {
+			if err := ps.AddProtocols(id, protos...); err != nil {
+				return ids, err
+			}
+		}
+
+		if rng.Intn(4) != 0 {
+			if err := ps.Put(id, "AgentVersion", syntheticAgents[rng.Intn(len(syntheticAgents))]); err != nil {
+				return ids, err
+			}
+			if err := ps.Put(id, "ProtocolVersion", "ipfs/0.1.0"); err != nil {
+				return ids, err
+			}
+		}
+
+		if rng.Intn(2) == 0 {
+			// exponentially distributed, averaging 80ms.
+			for k := rng.Intn(5) + 1; k > 0; k-- {
+				lat := time.Duration(float64(80*time.Millisecond) * rng.ExpFloat64())
+				ps.RecordLatency(id, lat+time.Millisecond)
+			}
+		}
+
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// syntheticKey returns the public key of a random type drawn from rng. Keys are derived from drawn bytes rather than
+// generated, as key generation doesn't draw from the reader it is given deterministically for all types.
+func syntheticKey(rng *rand.Rand) (ic.PubKey, error) {
+	seed := make([]byte, 32)
+	rng.Read(seed)
+	switch syntheticKeyType(rng) {
+	case ic.Secp256k1:
+		sk, err := ic.UnmarshalSecp256k1PrivateKey(seed)
+		if err != nil {
+			return nil, err
+		}
+		return sk.GetPublic(), nil
+	case ic.ECDSA:
+		curve := elliptic.P256()
+		d := new(big.Int).SetBytes(seed)
+		d.Mod(d, new(big.Int).Sub(curve.Params().N, big.NewInt(1)))
+		d.Add(d, big.NewInt(1))
+		sk := &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve}, D: d}
+		sk.X, sk.Y = curve.ScalarBaseMult(d.Bytes())
+		_, pk, err := ic.ECDSAKeyPairFromKey(sk)
+		return pk, err
+	default:
+		sk, err := ic.UnmarshalEd25519PrivateKey(ed25519.NewKeyFromSeed(seed))
+		if err != nil {
+			return nil, err
+		}
+		return sk.GetPublic(), nil
+	}
+}
+
+func syntheticKeyType(rng *rand.Rand) int {
+	total := 0
+	for _, kt := range syntheticKeyTypes {
+		total += kt.weight
+	}
+	w := rng.Intn(total)
+	for _, kt := range syntheticKeyTypes {
+		if w < kt.weight {
+			return kt.typ
+		}
+		w -= kt.weight
+	}
+	return ic.Ed25519
+}
+
+func syntheticTTL(rng *rand.Rand) time.Duration {
+	total := 0
+	for _, t := range syntheticTTLs {
+		total += t.weight
+	}
+	w := rng.Intn(total)
+	for _, t := range syntheticTTLs {
+		if w < t.weight {
+			return t.ttl
+		}
+		w -= t.weight
+	}
+	return pstore.AddressTTL
+}
+
+// syntheticID returns the ID of a peer whose key we don't know, as for RSA keys, too large to be inlined.
+func syntheticID(rng *rand.Rand) (peer.ID, error) {
+	buf := make([]byte, 32)
+	rng.Read(buf)
+	h, err := mh.Sum(buf, mh.SHA2_256, -1)
+	if err != nil {
+		return "", err
+	}
+	return peer.ID(h), nil
+}
+
+// syntheticAddr returns a public address over a random transport. Relayed addresses go through one of relays, if
+// any.
+func syntheticAddr(rng *rand.Rand, relays []peer.ID) (ma.Multiaddr, error) {
+	ip4 := fmt.Sprintf("%d.%d.%d.%d", rng.Intn(223)+1, rng.Intn(256), rng.Intn(256), rng.Intn(254)+1)
+	port := rng.Intn(60000) + 1024
+
+	var s string
+	switch w := rng.Intn(100); {
+	case w < 40:
+		s = fmt.Sprintf("/ip4/%s/tcp/%d", ip4, port)
+	case w < 65:
+		s = fmt.Sprintf("/ip4/%s/udp/%d/quic", ip4, port)
+	case w < 75:
+		s = fmt.Sprintf("/ip6/2001:db8:%x::%x/tcp/%d", rng.Intn(0xffff), rng.Intn(0xffff)+1, port)
+	case w < 85:
+		s = fmt.Sprintf("/ip4/%s/tcp/%d/ws", ip4, port)
+	case w < 92:
+		s = fmt.Sprintf("/dns4/node%d.example.com/tcp/443/wss", rng.Intn(100000))
+	default:
+		if len(relays) == 0 {
+			s = fmt.Sprintf("/ip4/%s/tcp/%d", ip4, port)
+			break
+		}
+		relay := relays[rng.Intn(len(relays))]
+		s = fmt.Sprintf("/ip4/%s/tcp/%d/p2p/%s/p2p-circuit", ip4, port, relay.Pretty())
+	}
+	return ma.NewMultiaddr(s)
+}
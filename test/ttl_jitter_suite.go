@@ -0,0 +1,64 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// testTTLJitter checks that an address book spreads the expiries of addresses added together within the bounds of
+// Config.TTLJitter, and keeps their TTL.
+func testTTLJitter(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
+	return func(t *testing.T) {
+		fraction := deps.Config.TTLJitter
+		ttl := time.Hour
+
+		eab, ok := ab.(peerstore.ExpiringAddrBook)
+		if !ok {
+			t.Skip("address book does not expose address expiry")
+		}
+
+		id := GeneratePeerIDs(1)[0]
+		addrs := GenerateAddrs(20)
+		now := deps.now()
+		ab.AddAddrs(id, addrs, ttl)
+
+		check := func(ttl time.Duration) {
+			t.Helper()
+			got := eab.AddrsWithExpiry(id)
+			if len(got) != len(addrs) {
+				t.Fatalf("expected %d addresses, got %d", len(addrs), len(got))
+			}
+			earliest := now.Add(ttl - time.Duration(fraction*float64(ttl)))
+			latest := now.Add(ttl)
+			distinct := make(map[int64]struct{})
+			for _, a := range got {
+				if a.TTL != ttl {
+					t.Fatalf("expected TTL %s, got %s", ttl, a.TTL)
+				}
+				if !expiresWithin(a.Expires, earliest, latest) {
+					t.Fatalf("expected expiry between %s and %s, got %s", earliest, latest, a.Expires)
+				}
+				distinct[a.Expires.Unix()] = struct{}{}
+			}
+			if len(distinct) < 2 {
+				t.Fatal("expected expiries to be spread out")
+			}
+		}
+		check(ttl)
+
+		// updated addresses are jittered too.
+		ab.UpdateAddrs(id, ttl, 2*ttl)
+		check(2 * ttl)
+
+		// permanent addresses are left alone.
+		ab.AddAddrs(id, addrs, pstore.PermanentAddrTTL)
+		for _, a := range eab.AddrsWithExpiry(id) {
+			if a.TTL != pstore.PermanentAddrTTL {
+				t.Fatalf("expected a permanent TTL, got %s", a.TTL)
+			}
+		}
+	}
+}
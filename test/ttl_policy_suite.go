@@ -0,0 +1,57 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// ttlWrite is an addition of an address with ttl, after age elapsed.
+type ttlWrite struct {
+	ttl time.Duration
+	age time.Duration
+}
+
+// testTTLPolicy returns a test checking that an address book resolves conflicting TTLs with Config.TTLPolicy: of the
+// writes of an address, the TTL and expiry of the one at index want must be kept.
+func testTTLPolicy(want int, writes ...ttlWrite) func(pstore.AddrBook, *Deps) func(*testing.T) {
+	return func(ab pstore.AddrBook, deps *Deps) func(*testing.T) {
+		return func(t *testing.T) {
+			id := GeneratePeerIDs(1)[0]
+			addr := GenerateAddrs(1)[0]
+			var expiries []time.Time
+			for _, w := range writes {
+				deps.sleep(w.age)
+				expiries = append(expiries, deps.now().Add(w.ttl))
+				ab.AddAddr(id, addr, w.ttl)
+			}
+
+			checkExpiringAddr(t, ab, id, addr, writes[want].ttl, expiries[want])
+		}
+	}
+}
+
+func checkExpiringAddr(t *testing.T, ab pstore.AddrBook, id peer.ID, addr ma.Multiaddr, ttl time.Duration, expires time.Time) {
+	t.Helper()
+
+	eab, ok := ab.(peerstore.ExpiringAddrBook)
+	if !ok {
+		t.Skip("address book does not expose address expiry")
+	}
+	got := eab.AddrsWithExpiry(id)
+	if len(got) != 1 || !got[0].Addr.Equal(addr) {
+		t.Fatalf("expected %s only, got %v", addr, got)
+	}
+	if got[0].TTL != ttl {
+		t.Fatalf("expected TTL %s, got %s", ttl, got[0].TTL)
+	}
+	// permanent TTLs overflow expiries, skip those.
+	if ttl < pstore.ConnectedAddrTTL && !expiresWithin(got[0].Expires, expires, expires) {
+		t.Fatalf("expected expiry %s, got %s", expires, got[0].Expires)
+	}
+}
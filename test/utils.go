@@ -4,10 +4,15 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/libp2p/go-libp2p-core/crypto"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
 	pt "github.com/libp2p/go-libp2p-core/test"
 	ma "github.com/multiformats/go-multiaddr"
+
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
 func Multiaddr(m string) ma.Multiaddr {
@@ -73,6 +78,41 @@ func GeneratePeerIDs(count int) []peer.ID {
 	return ids
 }
 
+// GenerateIdentity returns a new private key and the ID of its peer, for the tests consuming signed peer records.
+func GenerateIdentity(t testing.TB) (crypto.PrivKey, peer.ID) {
+	priv, _, err := pt.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, id
+}
+
+// SealPeerRecord returns a signed peer record of the peer of priv holding addrs.
+func SealPeerRecord(t testing.TB, priv crypto.PrivKey, addrs []ma.Multiaddr) *record.Envelope {
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := peer.NewPeerRecord()
+	rec.PeerID = id
+	rec.Addrs = addrs
+	env, err := record.Seal(rec, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+// expiresWithin reports whether t falls between earliest and latest. Address books may store expiries with second
+// granularity, so both bounds are widened by a second.
+func expiresWithin(t, earliest, latest time.Time) bool {
+	return !t.Before(earliest.Add(-time.Second)) && !t.After(latest.Add(time.Second))
+}
+
 func AssertAddressesEqual(t *testing.T, exp, act []ma.Multiaddr) {
 	t.Helper()
 	if len(exp) != len(act) {
@@ -94,3 +134,14 @@ func AssertAddressesEqual(t *testing.T, exp, act []ma.Multiaddr) {
 		}
 	}
 }
+
+// CheckInvariants fails t if book implements peerstore.InvariantChecker and reports a violated invariant after the
+// named test. The suites call it after every test.
+func CheckInvariants(t *testing.T, name string, book interface{}) {
+	t.Helper()
+	if c, ok := book.(peerstore.InvariantChecker); ok {
+		if err := c.CheckInvariants(); err != nil {
+			t.Errorf("invariant violated after %s: %s", name, err)
+		}
+	}
+}
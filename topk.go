@@ -0,0 +1,104 @@
+package peerstore
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// PeerMetric scores a peer for TopPeersBy, higher scores ranking first. It returns false for peers it cannot score,
+// which are left out.
+type PeerMetric func(ps pstore.Peerstore, p peer.ID) (score float64, ok bool)
+
+// PeerScore is a peer along with its score.
+type PeerScore struct {
+	ID    peer.ID
+	Score float64
+}
+
+// ByLatency scores peers by their latency EWMA, in seconds. Peers that have never been measured are left out.
+func ByLatency(ps pstore.Peerstore, p peer.ID) (float64, bool) {
+	lat := ps.LatencyEWMA(p)
+	return lat.Seconds(), lat > 0
+}
+
+// ByAddrCount scores peers by their number of live addresses.
+func ByAddrCount(ps pstore.Peerstore, p peer.ID) (float64, bool) {
+	return float64(len(ps.Addrs(p))), true
+}
+
+// ByMetadataSize scores peers by the size of their metadata, in bytes. It requires a peerstore implementing
+// PeerMetadataSizer, and leaves out all peers otherwise.
+func ByMetadataSize(ps pstore.Peerstore, p peer.ID) (float64, bool) {
+	sizer, ok := ps.(PeerMetadataSizer)
+	if !ok {
+		return 0, false
+	}
+	return float64(sizer.MetadataSize(p)), true
+}
+
+// ByLastSeenAge scores peers by the time elapsed since they were last seen, according to the supplied lastSeen
+// function, in seconds. Peers for which lastSeen returns the zero time are left out.
+func ByLastSeenAge(lastSeen func(peer.ID) time.Time) PeerMetric {
+	return func(_ pstore.Peerstore, p peer.ID) (float64, bool) {
+		t := lastSeen(p)
+		if t.IsZero() {
+			return 0, false
+		}
+		return time.Since(t).Seconds(), true
+	}
+}
+
+// TopPeersBy returns the k peers with the highest scores according to metric, e.g. the slowest peers or those with
+// the most addresses, in decreasing order of score. Ties are ordered by peer ID.
+//
+// Peers are scored in a single pass, and only the k best are held in memory.
+func TopPeersBy(ps pstore.Peerstore, metric PeerMetric, k int) []PeerScore {
+	if k <= 0 {
+		return []PeerScore{}
+	}
+
+	res := make(scoreHeap, 0, k)
+	for _, p := range ps.Peers() {
+		score, ok := metric(ps, p)
+		if !ok || math.IsNaN(score) {
+			continue
+		}
+		e := PeerScore{ID: p, Score: score}
+		if len(res) < k {
+			heap.Push(&res, e)
+		} else if res.worse(res[0], e) {
+			res[0] = e
+			heap.Fix(&res, 0)
+		}
+	}
+
+	out := []PeerScore(res)
+	sort.Slice(out, func(i, j int) bool { return res.worse(out[j], out[i]) })
+	return out
+}
+
+// scoreHeap is a min-heap of peer scores, the worst peer at the top.
+type scoreHeap []PeerScore
+
+func (h scoreHeap) worse(a, b PeerScore) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	return a.ID > b.ID
+}
+
+func (h scoreHeap) Len() int            { return len(h) }
+func (h scoreHeap) Less(i, j int) bool  { return h.worse(h[i], h[j]) }
+func (h scoreHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scoreHeap) Push(x interface{}) { *h = append(*h, x.(PeerScore)) }
+func (h *scoreHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,73 @@
+package peerstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	core "github.com/libp2p/go-libp2p-core/peerstore"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestTopPeersBy(t *testing.T) {
+	dstore, err := pstoreds.NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), pstoreds.DefaultOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, ps := range map[string]core.Peerstore{"Memory": pstoremem.NewPeerstore(), "Datastore": dstore} {
+		t.Run(name, func(t *testing.T) {
+			defer ps.Close()
+			testTopPeersBy(t, ps)
+		})
+	}
+}
+
+func testTopPeersBy(t *testing.T, ps core.Peerstore) {
+	ids := pt.GeneratePeerIDs(4)
+	addrs := pt.GenerateAddrs(4)
+	for i, p := range ids {
+		ps.AddAddrs(p, addrs[:i+1], time.Hour)
+		ps.RecordLatency(p, time.Duration(10-i)*time.Millisecond)
+		if err := ps.Put(p, "blob", make([]byte, 100*i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertTop := func(name string, metric pstore.PeerMetric, k int, want ...peer.ID) {
+		t.Helper()
+		got := pstore.TopPeersBy(ps, metric, k)
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d peers, got %v", name, len(want), got)
+		}
+		for i := range want {
+			if got[i].ID != want[i] {
+				t.Fatalf("%s: expected peer %d to be %s, got %v", name, i, want[i], got)
+			}
+		}
+	}
+
+	assertTop("latency", pstore.ByLatency, 2, ids[0], ids[1])
+	assertTop("addresses", pstore.ByAddrCount, 2, ids[3], ids[2])
+	assertTop("metadata", pstore.ByMetadataSize, 3, ids[3], ids[2], ids[1])
+	assertTop("all", pstore.ByAddrCount, 10, ids[3], ids[2], ids[1], ids[0])
+	assertTop("none", pstore.ByAddrCount, 0)
+
+	// peers that were never seen are left out.
+	lastSeen := map[peer.ID]time.Time{
+		ids[0]: time.Now().Add(-time.Hour),
+		ids[1]: time.Now().Add(-time.Minute),
+	}
+	assertTop("age", pstore.ByLastSeenAge(func(p peer.ID) time.Time { return lastSeen[p] }), 3, ids[0], ids[1])
+
+	top := pstore.TopPeersBy(ps, pstore.ByAddrCount, 1)
+	if top[0].Score != 4 {
+		t.Fatalf("expected a score of 4 addresses, got %v", top[0].Score)
+	}
+}
@@ -0,0 +1,435 @@
+package peerstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// traceMagic prefixes every trace log, and carries its format version in the last byte.
+var traceMagic = []byte("pstrace\x01")
+
+// ErrBadTrace is returned when reading a malformed or unsupported trace log.
+var ErrBadTrace = errors.New("malformed peerstore trace")
+
+// TraceOp identifies the peerstore method a trace entry records.
+type TraceOp uint8
+
+const (
+	TraceAddAddrs TraceOp = iota + 1
+	TraceSetAddrs
+	TraceUpdateAddrs
+	TraceAddrs
+	TraceAddrStream
+	TraceClearAddrs
+	TracePeersWithAddrs
+	TracePubKey
+	TraceAddPubKey
+	TracePrivKey
+	TraceAddPrivKey
+	TracePeersWithKeys
+	TraceGet
+	TracePut
+	TraceRecordLatency
+	TraceLatencyEWMA
+	TraceGetProtocols
+	TraceAddProtocols
+	TraceSetProtocols
+	TraceRemoveProtocols
+	TraceSupportsProtocols
+	TracePeerInfo
+	TracePeers
+
+	traceOpMax
+)
+
+var traceOpNames = [...]string{
+	TraceAddAddrs:          "AddAddrs",
+	TraceSetAddrs:          "SetAddrs",
+	TraceUpdateAddrs:       "UpdateAddrs",
+	TraceAddrs:             "Addrs",
+	TraceAddrStream:        "AddrStream",
+	TraceClearAddrs:        "ClearAddrs",
+	TracePeersWithAddrs:    "PeersWithAddrs",
+	TracePubKey:            "PubKey",
+	TraceAddPubKey:         "AddPubKey",
+	TracePrivKey:           "PrivKey",
+	TraceAddPrivKey:        "AddPrivKey",
+	TracePeersWithKeys:     "PeersWithKeys",
+	TraceGet:               "Get",
+	TracePut:               "Put",
+	TraceRecordLatency:     "RecordLatency",
+	TraceLatencyEWMA:       "LatencyEWMA",
+	TraceGetProtocols:      "GetProtocols",
+	TraceAddProtocols:      "AddProtocols",
+	TraceSetProtocols:      "SetProtocols",
+	TraceRemoveProtocols:   "RemoveProtocols",
+	TraceSupportsProtocols: "SupportsProtocols",
+	TracePeerInfo:          "PeerInfo",
+	TracePeers:             "Peers",
+}
+
+func (op TraceOp) String() string {
+	if op == 0 || op >= traceOpMax {
+		return fmt.Sprintf("TraceOp(%d)", op)
+	}
+	return traceOpNames[op]
+}
+
+// TraceEntry is a single recorded peerstore call. Only the shape of the call is recorded, not its payload: Count is
+// the number of addresses or protocols passed in, and TTL is the TTL passed in, or the latency for RecordLatency.
+// Peer is empty for calls that don't take a peer.
+type TraceEntry struct {
+	Time   time.Time
+	Op     TraceOp
+	Peer   peer.ID
+	Count  int
+	TTL    time.Duration
+	OldTTL time.Duration // UpdateAddrs only.
+}
+
+// TraceWriter encodes trace entries into a compact log: timestamps are delta-encoded, and peer IDs are written out
+// once and referenced by index afterwards. It is safe for concurrent use. Call Flush when done.
+type TraceWriter struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	peers  map[peer.ID]uint64
+	last   int64
+	header bool
+	buf    [binary.MaxVarintLen64]byte
+}
+
+// NewTraceWriter creates a TraceWriter writing to w.
+func NewTraceWriter(w io.Writer) *TraceWriter {
+	return &TraceWriter{w: bufio.NewWriter(w), peers: make(map[peer.ID]uint64)}
+}
+
+// Write appends an entry to the log.
+func (tw *TraceWriter) Write(e TraceEntry) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if !tw.header {
+		if _, err := tw.w.Write(traceMagic); err != nil {
+			return err
+		}
+		tw.header = true
+	}
+
+	ts := e.Time.UnixNano()
+	tw.putVarint(ts - tw.last)
+	tw.last = ts
+
+	tw.w.WriteByte(byte(e.Op))
+
+	// peer references are offset by one; zero introduces a new peer.
+	if idx, ok := tw.peers[e.Peer]; ok {
+		tw.putUvarint(idx + 1)
+	} else {
+		tw.peers[e.Peer] = uint64(len(tw.peers))
+		tw.putUvarint(0)
+		tw.putUvarint(uint64(len(e.Peer)))
+		tw.w.WriteString(string(e.Peer))
+	}
+
+	tw.putUvarint(uint64(e.Count))
+	tw.putVarint(int64(e.TTL))
+	if e.Op == TraceUpdateAddrs {
+		tw.putVarint(int64(e.OldTTL))
+	}
+	// bufio.Writer errors are sticky, and surface on the next write.
+	_, err := tw.w.Write(nil)
+	return err
+}
+
+// Flush writes any buffered entries to the underlying writer.
+func (tw *TraceWriter) Flush() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.w.Flush()
+}
+
+func (tw *TraceWriter) putVarint(v int64) {
+	n := binary.PutVarint(tw.buf[:], v)
+	tw.w.Write(tw.buf[:n])
+}
+
+func (tw *TraceWriter) putUvarint(v uint64) {
+	n := binary.PutUvarint(tw.buf[:], v)
+	tw.w.Write(tw.buf[:n])
+}
+
+// TraceReader decodes a log written by a TraceWriter.
+type TraceReader struct {
+	r      *bufio.Reader
+	peers  []peer.ID
+	last   int64
+	header bool
+}
+
+// NewTraceReader creates a TraceReader reading from r.
+func NewTraceReader(r io.Reader) *TraceReader {
+	return &TraceReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next entry of the log, or io.EOF at its end.
+func (tr *TraceReader) Next() (TraceEntry, error) {
+	var e TraceEntry
+
+	if !tr.header {
+		magic := make([]byte, len(traceMagic))
+		if _, err := io.ReadFull(tr.r, magic); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = ErrBadTrace
+			}
+			return e, err
+		}
+		if string(magic) != string(traceMagic) {
+			return e, ErrBadTrace
+		}
+		tr.header = true
+	}
+
+	delta, err := binary.ReadVarint(tr.r)
+	if err == io.EOF {
+		// a clean EOF can only happen between entries.
+		return e, err
+	} else if err != nil {
+		return e, ErrBadTrace
+	}
+	tr.last += delta
+	e.Time = time.Unix(0, tr.last)
+
+	op, err := tr.r.ReadByte()
+	if err != nil {
+		return e, ErrBadTrace
+	}
+	e.Op = TraceOp(op)
+	if e.Op == 0 || e.Op >= traceOpMax {
+		return e, ErrBadTrace
+	}
+
+	ref, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return e, ErrBadTrace
+	}
+	if ref == 0 {
+		l, err := binary.ReadUvarint(tr.r)
+		if err != nil || l > 1<<10 {
+			return e, ErrBadTrace
+		}
+		id := make([]byte, l)
+		if _, err := io.ReadFull(tr.r, id); err != nil {
+			return e, ErrBadTrace
+		}
+		e.Peer = peer.ID(id)
+		tr.peers = append(tr.peers, e.Peer)
+	} else if ref <= uint64(len(tr.peers)) {
+		e.Peer = tr.peers[ref-1]
+	} else {
+		return e, ErrBadTrace
+	}
+
+	count, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return e, ErrBadTrace
+	}
+	e.Count = int(count)
+
+	ttl, err := binary.ReadVarint(tr.r)
+	if err != nil {
+		return e, ErrBadTrace
+	}
+	e.TTL = time.Duration(ttl)
+
+	if e.Op == TraceUpdateAddrs {
+		oldTTL, err := binary.ReadVarint(tr.r)
+		if err != nil {
+			return e, ErrBadTrace
+		}
+		e.OldTTL = time.Duration(oldTTL)
+	}
+	return e, nil
+}
+
+// RecordingPeerstore is a Peerstore middleware that records every call into a trace log before passing it on to the
+// wrapped Peerstore. The log can later be replayed with ReplayTrace.
+type RecordingPeerstore struct {
+	pstore.Peerstore
+
+	tw    *TraceWriter
+	clock Clock
+	err   error // guarded by tw.mu
+}
+
+var _ pstore.Peerstore = (*RecordingPeerstore)(nil)
+
+// NewRecordingPeerstore wraps ps, recording calls to tw.
+func NewRecordingPeerstore(ps pstore.Peerstore, tw *TraceWriter) *RecordingPeerstore {
+	return &RecordingPeerstore{Peerstore: ps, tw: tw, clock: RealClock{}}
+}
+
+// Err returns the first error encountered while writing the trace, if any. Recording stops being reliable after
+// an error, but calls keep being passed on to the wrapped peerstore.
+func (rp *RecordingPeerstore) Err() error {
+	rp.tw.mu.Lock()
+	defer rp.tw.mu.Unlock()
+	return rp.err
+}
+
+func (rp *RecordingPeerstore) record(op TraceOp, p peer.ID, count int, ttl time.Duration) {
+	rp.recordEntry(TraceEntry{Op: op, Peer: p, Count: count, TTL: ttl})
+}
+
+func (rp *RecordingPeerstore) recordEntry(e TraceEntry) {
+	e.Time = rp.clock.Now()
+	if err := rp.tw.Write(e); err != nil {
+		rp.tw.mu.Lock()
+		if rp.err == nil {
+			rp.err = err
+		}
+		rp.tw.mu.Unlock()
+	}
+}
+
+// Close flushes the trace and closes the wrapped peerstore.
+func (rp *RecordingPeerstore) Close() error {
+	ferr := rp.tw.Flush()
+	if err := rp.Peerstore.Close(); err != nil {
+		return err
+	}
+	return ferr
+}
+
+func (rp *RecordingPeerstore) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	rp.AddAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+func (rp *RecordingPeerstore) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	rp.record(TraceAddAddrs, p, len(addrs), ttl)
+	rp.Peerstore.AddAddrs(p, addrs, ttl)
+}
+
+func (rp *RecordingPeerstore) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	rp.SetAddrs(p, []ma.Multiaddr{addr}, ttl)
+}
+
+func (rp *RecordingPeerstore) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	rp.record(TraceSetAddrs, p, len(addrs), ttl)
+	rp.Peerstore.SetAddrs(p, addrs, ttl)
+}
+
+func (rp *RecordingPeerstore) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
+	rp.recordEntry(TraceEntry{Op: TraceUpdateAddrs, Peer: p, TTL: newTTL, OldTTL: oldTTL})
+	rp.Peerstore.UpdateAddrs(p, oldTTL, newTTL)
+}
+
+func (rp *RecordingPeerstore) Addrs(p peer.ID) []ma.Multiaddr {
+	rp.record(TraceAddrs, p, 0, 0)
+	return rp.Peerstore.Addrs(p)
+}
+
+func (rp *RecordingPeerstore) AddrStream(ctx context.Context, p peer.ID) <-chan ma.Multiaddr {
+	rp.record(TraceAddrStream, p, 0, 0)
+	return rp.Peerstore.AddrStream(ctx, p)
+}
+
+func (rp *RecordingPeerstore) ClearAddrs(p peer.ID) {
+	rp.record(TraceClearAddrs, p, 0, 0)
+	rp.Peerstore.ClearAddrs(p)
+}
+
+func (rp *RecordingPeerstore) PeersWithAddrs() peer.IDSlice {
+	rp.record(TracePeersWithAddrs, "", 0, 0)
+	return rp.Peerstore.PeersWithAddrs()
+}
+
+func (rp *RecordingPeerstore) PubKey(p peer.ID) ic.PubKey {
+	rp.record(TracePubKey, p, 0, 0)
+	return rp.Peerstore.PubKey(p)
+}
+
+func (rp *RecordingPeerstore) AddPubKey(p peer.ID, pk ic.PubKey) error {
+	rp.record(TraceAddPubKey, p, 0, 0)
+	return rp.Peerstore.AddPubKey(p, pk)
+}
+
+func (rp *RecordingPeerstore) PrivKey(p peer.ID) ic.PrivKey {
+	rp.record(TracePrivKey, p, 0, 0)
+	return rp.Peerstore.PrivKey(p)
+}
+
+func (rp *RecordingPeerstore) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
+	rp.record(TraceAddPrivKey, p, 0, 0)
+	return rp.Peerstore.AddPrivKey(p, sk)
+}
+
+func (rp *RecordingPeerstore) PeersWithKeys() peer.IDSlice {
+	rp.record(TracePeersWithKeys, "", 0, 0)
+	return rp.Peerstore.PeersWithKeys()
+}
+
+func (rp *RecordingPeerstore) Get(p peer.ID, key string) (interface{}, error) {
+	rp.record(TraceGet, p, 0, 0)
+	return rp.Peerstore.Get(p, key)
+}
+
+func (rp *RecordingPeerstore) Put(p peer.ID, key string, val interface{}) error {
+	rp.record(TracePut, p, 0, 0)
+	return rp.Peerstore.Put(p, key, val)
+}
+
+func (rp *RecordingPeerstore) RecordLatency(p peer.ID, d time.Duration) {
+	rp.record(TraceRecordLatency, p, 0, d)
+	rp.Peerstore.RecordLatency(p, d)
+}
+
+func (rp *RecordingPeerstore) LatencyEWMA(p peer.ID) time.Duration {
+	rp.record(TraceLatencyEWMA, p, 0, 0)
+	return rp.Peerstore.LatencyEWMA(p)
+}
+
+func (rp *RecordingPeerstore) GetProtocols(p peer.ID) ([]string, error) {
+	rp.record(TraceGetProtocols, p, 0, 0)
+	return rp.Peerstore.GetProtocols(p)
+}
+
+func (rp *RecordingPeerstore) AddProtocols(p peer.ID, protos ...string) error {
+	rp.record(TraceAddProtocols, p, len(protos), 0)
+	return rp.Peerstore.AddProtocols(p, protos...)
+}
+
+func (rp *RecordingPeerstore) SetProtocols(p peer.ID, protos ...string) error {
+	rp.record(TraceSetProtocols, p, len(protos), 0)
+	return rp.Peerstore.SetProtocols(p, protos...)
+}
+
+func (rp *RecordingPeerstore) RemoveProtocols(p peer.ID, protos ...string) error {
+	rp.record(TraceRemoveProtocols, p, len(protos), 0)
+	return rp.Peerstore.RemoveProtocols(p, protos...)
+}
+
+func (rp *RecordingPeerstore) SupportsProtocols(p peer.ID, protos ...string) ([]string, error) {
+	rp.record(TraceSupportsProtocols, p, len(protos), 0)
+	return rp.Peerstore.SupportsProtocols(p, protos...)
+}
+
+func (rp *RecordingPeerstore) PeerInfo(p peer.ID) peer.AddrInfo {
+	rp.record(TracePeerInfo, p, 0, 0)
+	return rp.Peerstore.PeerInfo(p)
+}
+
+func (rp *RecordingPeerstore) Peers() peer.IDSlice {
+	rp.record(TracePeers, "", 0, 0)
+	return rp.Peerstore.Peers()
+}
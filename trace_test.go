@@ -0,0 +1,116 @@
+package peerstore_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	pt "github.com/libp2p/go-libp2p-peerstore/test"
+)
+
+func TestTraceRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	tw := pstore.NewTraceWriter(&buf)
+
+	ids := pt.GeneratePeerIDs(2)
+	addrs := pt.GenerateAddrs(3)
+
+	ps := pstore.NewRecordingPeerstore(pstoremem.NewPeerstore(), tw)
+	ps.AddAddrs(ids[0], addrs, time.Hour)
+	ps.AddAddr(ids[1], addrs[0], time.Minute)
+	ps.UpdateAddrs(ids[1], time.Minute, time.Hour)
+	ps.Addrs(ids[0])
+	ps.SetProtocols(ids[1], "/a", "/b")
+	ps.RecordLatency(ids[0], 42*time.Millisecond)
+	ps.PeersWithAddrs()
+	ps.ClearAddrs(ids[0])
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []pstore.TraceEntry{
+		{Op: pstore.TraceAddAddrs, Peer: ids[0], Count: 3, TTL: time.Hour},
+		{Op: pstore.TraceAddAddrs, Peer: ids[1], Count: 1, TTL: time.Minute},
+		{Op: pstore.TraceUpdateAddrs, Peer: ids[1], TTL: time.Hour, OldTTL: time.Minute},
+		{Op: pstore.TraceAddrs, Peer: ids[0]},
+		{Op: pstore.TraceSetProtocols, Peer: ids[1], Count: 2},
+		{Op: pstore.TraceRecordLatency, Peer: ids[0], TTL: 42 * time.Millisecond},
+		{Op: pstore.TracePeersWithAddrs, Peer: peer.ID("")},
+		{Op: pstore.TraceClearAddrs, Peer: ids[0]},
+	}
+
+	tr := pstore.NewTraceReader(bytes.NewReader(buf.Bytes()))
+	var last time.Time
+	for i, exp := range expected {
+		e, err := tr.Next()
+		if err != nil {
+			t.Fatalf("entry %d: %s", i, err)
+		}
+		if e.Time.Before(last) || e.Time.IsZero() {
+			t.Errorf("entry %d: unexpected timestamp %s", i, e.Time)
+		}
+		last = e.Time
+		e.Time = time.Time{}
+		if e != exp {
+			t.Errorf("entry %d: expected %+v, got %+v", i, exp, e)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	// replay onto a fresh peerstore.
+	replayed := pstoremem.NewPeerstore()
+	defer replayed.Close()
+
+	stats, err := pstore.ReplayTrace(replayed, pstore.NewTraceReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Ops != len(expected) || stats.Skipped != 0 {
+		t.Fatalf("unexpected replay stats: %+v", stats)
+	}
+	if n := len(replayed.Addrs(ids[0])); n != 0 {
+		t.Errorf("expected addresses of peer 0 to be cleared, got %d", n)
+	}
+	if n := len(replayed.Addrs(ids[1])); n != 1 {
+		t.Errorf("expected 1 address for peer 1, got %d", n)
+	}
+	if protos, _ := replayed.GetProtocols(ids[1]); len(protos) != 2 {
+		t.Errorf("expected 2 protocols for peer 1, got %d", len(protos))
+	}
+	if lat := replayed.LatencyEWMA(ids[0]); lat != 42*time.Millisecond {
+		t.Errorf("expected latency of 42ms, got %s", lat)
+	}
+}
+
+func TestTraceReaderMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	tw := pstore.NewTraceWriter(&buf)
+	if err := tw.Write(pstore.TraceEntry{Time: time.Now(), Op: pstore.TraceAddAddrs, Peer: pt.GeneratePeerIDs(1)[0], Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// truncated entry.
+	tr := pstore.NewTraceReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if _, err := tr.Next(); err != pstore.ErrBadTrace {
+		t.Fatalf("expected ErrBadTrace, got %v", err)
+	}
+
+	// bad header.
+	tr = pstore.NewTraceReader(bytes.NewReader([]byte("not a trace")))
+	if _, err := tr.Next(); err != pstore.ErrBadTrace {
+		t.Fatalf("expected ErrBadTrace, got %v", err)
+	}
+}
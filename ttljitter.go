@@ -0,0 +1,44 @@
+package peerstore
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// TTLJitter randomly shortens the lifetime of addresses by up to a fraction of their TTL, so that addresses learnt
+// at the same time, e.g. in an identify burst, don't all expire, and get refreshed, at the same instant.
+//
+// Only expiries are jittered: addresses keep the TTL they were added with, so that UpdateAddrs still matches them.
+// Addresses never outlive their TTL, and those with a TTL of ConnectedAddrTTL or above are left alone.
+type TTLJitter struct {
+	fraction float64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewTTLJitter creates a TTLJitter shortening lifetimes by up to fraction (0-1) of the TTL, drawing from a source
+// seeded with seed. It returns nil, which applies no jitter, if fraction is not positive.
+func NewTTLJitter(fraction float64, seed int64) *TTLJitter {
+	if fraction <= 0 {
+		return nil
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return &TTLJitter{fraction: fraction, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// Expiry returns when an address added at now with ttl expires.
+func (j *TTLJitter) Expiry(now time.Time, ttl time.Duration) time.Time {
+	if j == nil || ttl <= 0 || ttl >= pstore.ConnectedAddrTTL {
+		return now.Add(ttl)
+	}
+	j.mu.Lock()
+	r := j.rnd.Float64()
+	j.mu.Unlock()
+	return now.Add(ttl - time.Duration(r*j.fraction*float64(ttl)))
+}
@@ -0,0 +1,67 @@
+package peerstore
+
+import (
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// TTLPolicy resolves the conflict between an address held by an address book and the same address being added to it
+// again with AddAddrs, or through a signed peer record: it returns the TTL and expiry the address is kept with. have
+// and add carry the TTL and expiry of the held and of the incoming address. SetAddrs and UpdateAddrs are not subject
+// to the policy, they always override.
+//
+// Policies must be pure functions, as they are called with the address book locked.
+type TTLPolicy func(have, add ExpiringAddr) ExpiringAddr
+
+// MaxTTL keeps the greater of the TTLs and the later of the expiries, so that adding an address never shortens its
+// lifetime. This is the default policy.
+func MaxTTL(have, add ExpiringAddr) ExpiringAddr {
+	if add.TTL > have.TTL {
+		have.TTL = add.TTL
+	}
+	if add.Expires.After(have.Expires) {
+		have.Expires = add.Expires
+	}
+	return have
+}
+
+// LatestTTL keeps the TTL and expiry of the latest write, even if that shortens the lifetime of the address.
+func LatestTTL(_, add ExpiringAddr) ExpiringAddr {
+	return add
+}
+
+// SourcePriorityTTL keeps the TTL and expiry of the write with the highest rank, rank telling the source of a write
+// by its TTL; the latest write wins among writes of equal rank. See DefaultTTLRank.
+func SourcePriorityTTL(rank func(ttl time.Duration) int) TTLPolicy {
+	return func(have, add ExpiringAddr) ExpiringAddr {
+		if rank(add.TTL) < rank(have.TTL) {
+			return have
+		}
+		return add
+	}
+}
+
+// DefaultTTLRank ranks permanent addresses first, then the addresses of connected peers, then all others, which
+// libp2p doesn't tell apart by TTL.
+func DefaultTTLRank(ttl time.Duration) int {
+	switch ttl {
+	case pstore.PermanentAddrTTL:
+		return 2
+	case pstore.ConnectedAddrTTL:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ClampTTL caps ttl at max, for address books bounding how long they keep addresses regardless of the TTL callers
+// pass, e.g. hour-long TTLs given to addresses gossiped by third parties. TTLs of ConnectedAddrTTL and above, which
+// pin addresses, are left alone, as are non-positive TTLs, which remove addresses. A non-positive max disables the
+// clamp.
+func ClampTTL(ttl, max time.Duration) time.Duration {
+	if max <= 0 || ttl <= max || ttl >= pstore.ConnectedAddrTTL {
+		return ttl
+	}
+	return max
+}
@@ -0,0 +1,9 @@
+package peerstore
+
+// Vacuumer is implemented by in-memory books and peerstores that can release the memory their maps retain once
+// entries are deleted, e.g. after a mass expiry: Go maps never shrink, so a map keeps the memory of its peak size
+// until it's reallocated.
+type Vacuumer interface {
+	// Vacuum reallocates the maps that shrank since their peak, and returns an estimate of the bytes reclaimed.
+	Vacuum() int64
+}
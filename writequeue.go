@@ -0,0 +1,169 @@
+package peerstore
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrWriteQueueClosed is returned when enqueuing writes to a closed WriteQueue.
+var ErrWriteQueueClosed = errors.New("write queue closed")
+
+// WritePriority is the priority class of a queued write. Writes of higher classes are applied first, and shed last.
+type WritePriority int
+
+const (
+	// PriorityGossip is for writes that are cheap to lose, such as addresses of third parties learnt from gossip.
+	PriorityGossip WritePriority = iota
+	// PriorityDefault is for regular writes.
+	PriorityDefault
+	// PriorityCritical is for writes that are expensive to lose, such as keys and certified records.
+	PriorityCritical
+
+	numWritePriorities
+)
+
+// WriteQueueStats are counters of a WriteQueue, indexed by priority class.
+type WriteQueueStats struct {
+	// Depth is the number of writes waiting to be applied.
+	Depth [numWritePriorities]int
+	// Applied is the number of writes applied.
+	Applied [numWritePriorities]uint64
+	// Shed is the number of writes dropped because the queue was full.
+	Shed [numWritePriorities]uint64
+}
+
+// WriteQueue applies writes asynchronously, in priority order, so that callers on hot paths don't wait for a slow
+// peerstore. The queue is bounded: when it is full, the oldest write of the lowest class below that of the incoming
+// write is shed to make room for it; if there is none, the incoming write is shed.
+//
+// Writes are applied one at a time, in FIFO order within a class, by a background goroutine. Errors are logged.
+type WriteQueue struct {
+	limit int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues [numWritePriorities][]func() error
+	depth  int
+	busy   bool
+	closed bool
+	stats  WriteQueueStats
+
+	done chan struct{}
+}
+
+// NewWriteQueue creates a WriteQueue holding at most limit pending writes, and starts its background process. It must
+// be closed when no longer needed.
+func NewWriteQueue(limit int) *WriteQueue {
+	if limit < 1 {
+		limit = 1
+	}
+	q := &WriteQueue{limit: limit, done: make(chan struct{})}
+	q.cond = sync.NewCond(&q.mu)
+	go q.background()
+	return q
+}
+
+// Enqueue queues a write of the given class, and returns whether it was accepted: false if it was shed. It returns
+// ErrWriteQueueClosed if the queue is closed.
+func (q *WriteQueue) Enqueue(prio WritePriority, write func() error) (bool, error) {
+	if prio < 0 {
+		prio = 0
+	} else if prio >= numWritePriorities {
+		prio = numWritePriorities - 1
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false, ErrWriteQueueClosed
+	}
+
+	if q.depth >= q.limit && !q.shedBelowUnlocked(prio) {
+		q.stats.Shed[prio]++
+		return false, nil
+	}
+	q.queues[prio] = append(q.queues[prio], write)
+	q.depth++
+	q.cond.Broadcast()
+	return true, nil
+}
+
+// shedBelowUnlocked drops the oldest write of the lowest class below prio, and returns whether there was one.
+func (q *WriteQueue) shedBelowUnlocked(prio WritePriority) bool {
+	for c := WritePriority(0); c < prio; c++ {
+		if len(q.queues[c]) == 0 {
+			continue
+		}
+		q.queues[c][0] = nil
+		q.queues[c] = q.queues[c][1:]
+		q.depth--
+		q.stats.Shed[c]++
+		return true
+	}
+	return false
+}
+
+// Stats returns the counters of the queue.
+func (q *WriteQueue) Stats() WriteQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := q.stats
+	for c := range q.queues {
+		s.Depth[c] = len(q.queues[c])
+	}
+	return s
+}
+
+// Flush waits for the writes queued so far, and those queued meanwhile, to be applied.
+func (q *WriteQueue) Flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.depth > 0 || q.busy {
+		q.cond.Wait()
+	}
+}
+
+// Close stops accepting writes, and waits for the queued ones to be applied.
+func (q *WriteQueue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	<-q.done
+	return nil
+}
+
+func (q *WriteQueue) background() {
+	defer close(q.done)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for q.depth == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.depth == 0 {
+			return
+		}
+
+		prio := numWritePriorities - 1
+		for len(q.queues[prio]) == 0 {
+			prio--
+		}
+		write := q.queues[prio][0]
+		q.queues[prio][0] = nil
+		q.queues[prio] = q.queues[prio][1:]
+		q.depth--
+		q.busy = true
+
+		q.mu.Unlock()
+		if err := write(); err != nil {
+			log.Errorf("failed to apply queued write: %s", err)
+		}
+		q.mu.Lock()
+
+		q.busy = false
+		q.stats.Applied[prio]++
+		q.cond.Broadcast()
+	}
+}
@@ -0,0 +1,81 @@
+package peerstore_test
+
+import (
+	"sync"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestWriteQueue(t *testing.T) {
+	q := pstore.NewWriteQueue(3)
+
+	// block the queue with a write in progress.
+	started, release := make(chan struct{}), make(chan struct{})
+	if _, err := q.Enqueue(pstore.PriorityDefault, func() error {
+		close(started)
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	var (
+		mu      sync.Mutex
+		applied []string
+	)
+	enqueue := func(prio pstore.WritePriority, name string, want bool) {
+		t.Helper()
+		ok, err := q.Enqueue(prio, func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			applied = append(applied, name)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Fatalf("expected write %s to be accepted: %t, got %t", name, want, ok)
+		}
+	}
+
+	enqueue(pstore.PriorityGossip, "gossip1", true)
+	enqueue(pstore.PriorityGossip, "gossip2", true)
+	enqueue(pstore.PriorityDefault, "default", true)
+	// the queue is full: the oldest gossip write makes room for a critical one, and gossip writes are shed.
+	enqueue(pstore.PriorityCritical, "critical", true)
+	enqueue(pstore.PriorityGossip, "gossip3", false)
+
+	s := q.Stats()
+	if s.Depth != [3]int{1, 1, 1} {
+		t.Fatalf("expected a write of each class to be queued, got %v", s.Depth)
+	}
+	if s.Shed[pstore.PriorityGossip] != 2 {
+		t.Fatalf("expected 2 gossip writes to be shed, got %d", s.Shed[pstore.PriorityGossip])
+	}
+
+	close(release)
+	q.Flush()
+	mu.Lock()
+	got := append([]string(nil), applied...)
+	mu.Unlock()
+	want := []string{"critical", "default", "gossip2"}
+	if len(got) != len(want) {
+		t.Fatalf("expected writes %v to be applied, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected writes %v to be applied in order, got %v", want, got)
+		}
+	}
+	if s := q.Stats(); s.Applied != [3]uint64{1, 2, 1} {
+		t.Fatalf("expected applied counts [1 2 1], got %v", s.Applied)
+	}
+
+	q.Close()
+	if _, err := q.Enqueue(pstore.PriorityCritical, func() error { return nil }); err != pstore.ErrWriteQueueClosed {
+		t.Fatalf("expected %s, got %v", pstore.ErrWriteQueueClosed, err)
+	}
+}
@@ -0,0 +1,106 @@
+package peerstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/subtle"
+	"math/big"
+	"reflect"
+	"unsafe"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// KeyEqual returns whether two keys are equal, comparing their encodings in constant time, so that the comparison of
+// private keys doesn't leak their contents through timing.
+func KeyEqual(k1, k2 ic.Key) bool {
+	if k1 == nil || k2 == nil {
+		return k1 == k2
+	}
+	b1, err := k1.Bytes()
+	if err != nil {
+		return false
+	}
+	b2, err := k2.Bytes()
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(b1, b2) == 1
+}
+
+// ZeroizePrivKey overwrites the secret material of a private key with zeros, and returns whether it could. The key
+// is unusable afterwards, so it must not be shared with other users; its public key remains valid.
+//
+// Zeroization is best effort: it supports the Ed25519, RSA, ECDSA and Secp256k1 keys of the standard Go
+// implementations, and cannot reach copies made by the runtime (e.g. on stack growth) or by the key implementations.
+func ZeroizePrivKey(sk ic.PrivKey) bool {
+	switch k := sk.(type) {
+	case *ic.Ed25519PrivateKey:
+		f := unexportedField(reflect.ValueOf(k).Elem(), "k")
+		if !f.IsValid() {
+			return false
+		}
+		// the public key is a suffix of the private key, and may be shared; only wipe the seed.
+		seed := f.Interface().(ed25519.PrivateKey)
+		zeroBytes(seed[:len(seed)-ed25519.PublicKeySize])
+		return true
+	case *ic.RsaPrivateKey:
+		f := unexportedField(reflect.ValueOf(k).Elem(), "sk")
+		if !f.IsValid() || f.Type() != reflect.TypeOf(rsa.PrivateKey{}) {
+			return false
+		}
+		rk := f.Addr().Interface().(*rsa.PrivateKey)
+		zeroBigInt(rk.D)
+		for _, p := range rk.Primes {
+			zeroBigInt(p)
+		}
+		zeroBigInt(rk.Precomputed.Dp)
+		zeroBigInt(rk.Precomputed.Dq)
+		zeroBigInt(rk.Precomputed.Qinv)
+		return true
+	case *ic.ECDSAPrivateKey:
+		f := unexportedField(reflect.ValueOf(k).Elem(), "priv")
+		if !f.IsValid() || f.IsNil() {
+			return false
+		}
+		zeroBigInt(f.Interface().(*ecdsa.PrivateKey).D)
+		return true
+	case *ic.Secp256k1PrivateKey:
+		f := reflect.ValueOf(k).Elem().FieldByName("D")
+		if !f.IsValid() || f.IsNil() {
+			return false
+		}
+		zeroBigInt(f.Interface().(*big.Int))
+		return true
+	default:
+		return false
+	}
+}
+
+// unexportedField returns an accessible copy of the unexported field of an addressable struct value, or the zero
+// Value if there is no such field.
+func unexportedField(v reflect.Value, name string) reflect.Value {
+	f := v.FieldByName(name)
+	if !f.IsValid() || !f.CanAddr() {
+		return reflect.Value{}
+	}
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+}
+
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func zeroBigInt(i *big.Int) {
+	if i == nil {
+		return
+	}
+	words := i.Bits()
+	for j := range words {
+		words[j] = 0
+	}
+	i.SetInt64(0)
+}
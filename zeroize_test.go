@@ -0,0 +1,70 @@
+package peerstore
+
+import (
+	"bytes"
+	"testing"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func TestZeroizePrivKey(t *testing.T) {
+	for _, typ := range []int{ic.Ed25519, ic.RSA, ic.ECDSA, ic.Secp256k1} {
+		t.Run(pb.KeyType(typ).String(), func(t *testing.T) {
+			sk, pk, err := test.RandTestKeyPair(typ, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := []byte("hello")
+			sig, err := sk.Sign(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dup, err := ic.UnmarshalPrivateKey(mustBytes(t, sk))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !KeyEqual(sk, dup) {
+				t.Fatal("expected key to equal its copy")
+			}
+
+			if !ZeroizePrivKey(sk) {
+				t.Fatal("expected key to be zeroized")
+			}
+			if KeyEqual(sk, dup) {
+				t.Fatal("expected zeroized key to differ from its copy")
+			}
+			// public keys derived before survive.
+			if ok, err := sk.GetPublic().Verify(msg, sig); err != nil || !ok {
+				t.Fatalf("expected public key to remain valid: %v", err)
+			}
+			if ok, err := pk.Verify(msg, sig); err != nil || !ok {
+				t.Fatalf("expected public key to remain valid: %v", err)
+			}
+		})
+	}
+}
+
+func TestZeroizeEd25519Seed(t *testing.T) {
+	sk, _, err := test.RandTestKeyPair(ic.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ZeroizePrivKey(sk)
+	raw, err := sk.Raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw[:32], make([]byte, 32)) {
+		t.Fatal("expected seed to be zeroed")
+	}
+}
+
+func mustBytes(t *testing.T, k ic.Key) []byte {
+	b, err := k.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}